@@ -0,0 +1,421 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xenolf/lego/acme"
+)
+
+// acmeRenewInterval is how often the certificate manager checks for
+// certificates that are missing or due to be renewed.
+const acmeRenewInterval = time.Hour
+
+// acmeChallengePrefix is the path the ACME server fetches HTTP-01 tokens from.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// acmeState is the saved state of the certificate manager.
+// It uses the same format as rsc.io/letsencrypt so existing cache files
+// keep their account and certificates. It also implements acme.User.
+type acmeState struct {
+	Email string
+	Reg   *acme.RegistrationResource
+	Key   string
+	key   *ecdsa.PrivateKey
+	Hosts []string
+	Certs map[string]acmeCert
+}
+
+func (s *acmeState) GetEmail() string                            { return s.Email }
+func (s *acmeState) GetRegistration() *acme.RegistrationResource { return s.Reg }
+func (s *acmeState) GetPrivateKey() crypto.PrivateKey            { return s.key }
+
+type acmeCert struct {
+	Cert string
+	Key  string
+}
+
+// certManager obtains TLS certificates from an ACME certificate authority
+// and renews them in the background, well before they expire.
+type certManager struct {
+	sync.Mutex
+	path   string
+	state  acmeState
+	hosts  []string
+	certs  map[string]*tls.Certificate
+	errs   map[string]error  // the most recent failure for each host
+	tokens map[string]string // HTTP-01 token -> key authorization
+}
+
+// loadCertManager reads the certificate manager state from a cache file.
+// A missing file is treated as a new installation.
+func loadCertManager(path string) (*certManager, error) {
+	m := &certManager{
+		path:   path,
+		certs:  make(map[string]*tls.Certificate),
+		errs:   make(map[string]error),
+		tokens: make(map[string]string),
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(raw, &m.state); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if m.state.Key != "" {
+		block, _ := pem.Decode([]byte(m.state.Key))
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("account key in %s is not an EC private key", path)
+		}
+		if m.state.key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("error parsing account key in %s: %v", path, err)
+		}
+	}
+	for host, pair := range m.state.Certs {
+		cert, err := tls.X509KeyPair([]byte(pair.Cert), []byte(pair.Key))
+		if err != nil {
+			log.Printf("ignoring saved certificate for %s: %v", host, err)
+			continue
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			log.Printf("ignoring saved certificate for %s: %v", host, err)
+			continue
+		}
+		m.certs[host] = &cert
+	}
+	return m, nil
+}
+
+// save writes the state to the cache file. The caller must hold the lock.
+func (m *certManager) save() error {
+	raw, err := json.MarshalIndent(&m.state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// registered reports whether an ACME account has been set up.
+func (m *certManager) registered() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.state.Reg != nil && m.state.Reg.Body.Agreement != ""
+}
+
+// register creates an ACME account for the given email address
+// and agrees to the certificate authority's terms of service.
+func (m *certManager) register(email string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.state.Email = email
+	if m.state.key == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("error generating account key: %v", err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("error encoding account key: %v", err)
+		}
+		m.state.key = key
+		m.state.Key = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	}
+	client, err := acme.NewClient(Config().ACMEDirectory, &m.state, acme.EC256)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %v", Config().ACMEDirectory, err)
+	}
+	reg, err := client.Register()
+	if err != nil {
+		return fmt.Errorf("error registering: %v", err)
+	}
+	m.state.Reg = reg
+	if reg.Body.Agreement == "" {
+		if err := client.AgreeToTOS(); err != nil {
+			return fmt.Errorf("error agreeing to the terms of service: %v", err)
+		}
+	}
+	return m.save()
+}
+
+// setHosts sets the hosts that certificates are kept for.
+// IP addresses are skipped, since certificate authorities do not issue for them.
+func (m *certManager) setHosts(hosts []string) {
+	m.Lock()
+	defer m.Unlock()
+	m.hosts = nil
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.ToLower(strings.Trim(host, "[]"))
+		if host == "" || seen[host] || net.ParseIP(host) != nil {
+			continue
+		}
+		seen[host] = true
+		m.hosts = append(m.hosts, host)
+	}
+	sort.Strings(m.hosts)
+	m.state.Hosts = m.hosts
+}
+
+// renewLoop obtains missing certificates and renews old ones until the server exits.
+// Failures are retried on the next pass, so a brief outage at the certificate
+// authority does not matter as long as it is fixed before the certificate expires.
+func (m *certManager) renewLoop() {
+	for {
+		m.renewDue()
+		time.Sleep(acmeRenewInterval)
+	}
+}
+
+func (m *certManager) renewDue() {
+	m.Lock()
+	var due []string
+	for _, host := range m.hosts {
+		cert := m.certs[host]
+		if cert == nil || time.Now().After(certRenewTime(cert.Leaf)) {
+			due = append(due, host)
+		}
+	}
+	m.Unlock()
+
+	for _, host := range due {
+		log.Printf("requesting a TLS certificate for %s using %s", host, Config().ACMEChallenge)
+		err := m.obtain(host)
+		m.Lock()
+		m.errs[host] = err
+		m.Unlock()
+		if err != nil {
+			log.Printf("error getting a TLS certificate for %s: %v", host, err)
+		}
+	}
+}
+
+// obtain requests a new certificate for a single host and installs it.
+func (m *certManager) obtain(host string) error {
+	client, err := acme.NewClient(Config().ACMEDirectory, &m.state, acme.EC256)
+	if err != nil {
+		return err
+	}
+	switch Config().ACMEChallenge {
+	case string(acme.DNS01):
+		client.SetChallengeProvider(acme.DNS01, dnsHookProvider{command: Config().ACMEDNSHook})
+		client.ExcludeChallenges([]acme.Challenge{acme.HTTP01, acme.TLSSNI01})
+	default:
+		client.SetChallengeProvider(acme.HTTP01, httpTokenProvider{m: m})
+		client.ExcludeChallenges([]acme.Challenge{acme.DNS01, acme.TLSSNI01})
+	}
+	res, failures := client.ObtainCertificate([]string{host}, true, nil)
+	if len(failures) > 0 {
+		var msgs []string
+		for domain, err := range failures {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", domain, err))
+		}
+		sort.Strings(msgs)
+		return fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	pair := acmeCert{Cert: string(res.Certificate), Key: string(res.PrivateKey)}
+	cert, err := tls.X509KeyPair([]byte(pair.Cert), []byte(pair.Key))
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.state.Certs == nil {
+		m.state.Certs = make(map[string]acmeCert)
+	}
+	m.state.Certs[host] = pair
+	m.certs[host] = &cert
+	log.Printf("installed a TLS certificate for %s that expires %s", host, cert.Leaf.NotAfter.Format(time.RFC3339))
+	return m.save()
+}
+
+// certRenewTime is when a certificate should be replaced: halfway through its
+// lifetime, or 30 days before it expires, whichever is later.
+func certRenewTime(leaf *x509.Certificate) time.Time {
+	if leaf == nil {
+		return time.Time{}
+	}
+	t := leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 2)
+	if monthEarly := leaf.NotAfter.Add(-30 * 24 * time.Hour); t.Before(monthEarly) {
+		t = monthEarly
+	}
+	return t
+}
+
+// GetCertificate returns the certificate for a TLS handshake.
+// It is used as tls.Config.GetCertificate.
+func (m *certManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	m.Lock()
+	defer m.Unlock()
+	if cert := m.certs[host]; cert != nil {
+		return cert, nil
+	}
+	if err := m.errs[host]; err != nil {
+		return nil, fmt.Errorf("no TLS certificate for %s: %v", host, err)
+	}
+	return nil, fmt.Errorf("no TLS certificate for %q", hello.ServerName)
+}
+
+// serveChallenge answers an HTTP-01 challenge request from the certificate authority,
+// returning false if the request is not for a pending challenge.
+func (m *certManager) serveChallenge(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+		return false
+	}
+	token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+	m.Lock()
+	keyAuth, present := m.tokens[token]
+	m.Unlock()
+	if !present {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, keyAuth)
+	return true
+}
+
+// probe reports the expiration of every certificate, failing
+// if one is missing or will expire within Config.CertWarnDays.
+func (m *certManager) probe() (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	var good, bad []string
+	for _, host := range m.hosts {
+		cert := m.certs[host]
+		switch {
+		case cert == nil && m.errs[host] != nil:
+			bad = append(bad, fmt.Sprintf("%s has no certificate: %v", host, m.errs[host]))
+		case cert == nil:
+			bad = append(bad, fmt.Sprintf("%s has no certificate yet", host))
+		default:
+			days := int(time.Until(cert.Leaf.NotAfter).Hours() / 24)
+			msg := fmt.Sprintf("%s expires in %d day%s", host, days, plural(days))
+			if m.errs[host] != nil {
+				msg += fmt.Sprintf(" and renewal failed: %v", m.errs[host])
+			}
+			if days < Config().CertWarnDays {
+				bad = append(bad, msg)
+			} else {
+				good = append(good, msg)
+			}
+		}
+	}
+	if len(bad) > 0 {
+		return "", fmt.Errorf("%s", strings.Join(bad, "; "))
+	}
+	return strings.Join(good, "; "), nil
+}
+
+// checkACMEConfig makes sure the certificate settings can be used.
+func checkACMEConfig(config *serverConfig) error {
+	switch config.ACMEChallenge {
+	case string(acme.HTTP01):
+	case string(acme.DNS01):
+		if config.ACMEDNSHook == "" {
+			return fmt.Errorf("ACMEChallenge is %s but no ACMEDNSHook is set", acme.DNS01)
+		}
+	default:
+		return fmt.Errorf("ACMEChallenge must be %s or %s, not %q", acme.HTTP01, acme.DNS01, config.ACMEChallenge)
+	}
+	return nil
+}
+
+// httpTokenProvider answers HTTP-01 challenges from the port 80 listener.
+type httpTokenProvider struct {
+	m *certManager
+}
+
+func (p httpTokenProvider) Present(domain, token, keyAuth string) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.m.tokens[token] = keyAuth
+	return nil
+}
+
+func (p httpTokenProvider) CleanUp(domain, token, keyAuth string) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	delete(p.m.tokens, token)
+	return nil
+}
+
+// dnsHookProvider answers DNS-01 challenges by running a command that updates DNS:
+//
+//	command present _acme-challenge.host.example.edu. VALUE
+//	command cleanup _acme-challenge.host.example.edu. VALUE
+//
+// This lets each site use whatever DNS provider it has without codegrinder knowing about it.
+type dnsHookProvider struct {
+	command string
+}
+
+func (p dnsHookProvider) run(action, domain, keyAuth string) error {
+	fqdn, value, _ := acme.DNS01Record(domain, keyAuth)
+	fields := strings.Fields(p.command)
+	if len(fields) == 0 {
+		return fmt.Errorf("no ACMEDNSHook command is set")
+	}
+	cmd := exec.Command(fields[0], append(fields[1:], action, fqdn, value)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", p.command, action, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (p dnsHookProvider) Present(domain, token, keyAuth string) error {
+	return p.run("present", domain, keyAuth)
+}
+
+func (p dnsHookProvider) CleanUp(domain, token, keyAuth string) error {
+	return p.run("cleanup", domain, keyAuth)
+}
+
+// Timeout allows for slow DNS providers, which can take minutes to publish a record.
+func (p dnsHookProvider) Timeout() (timeout, interval time.Duration) {
+	return 10 * time.Minute, 15 * time.Second
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// activityChartWidth and activityChartHeight are the size of each chart on the activity page.
+const (
+	activityChartWidth  = 900
+	activityChartHeight = 120
+)
+
+// activityRow is one save from the submission log for a problem set.
+type activityRow struct {
+	UserID    int64     `meddler:"user_id"`
+	Graded    bool      `meddler:"graded"`
+	Passed    bool      `meddler:"passed"`
+	CreatedAt time.Time `meddler:"created_at,localtime"`
+}
+
+// getProblemSetActivity counts the work students in a course have saved on a problem set,
+// limited to one problem if problemID is set and to the time between from and to if they are set.
+func getProblemSetActivity(tx *sql.Tx, courseID, problemSetID, problemID int64, from, to time.Time) (*ProblemSetActivity, error) {
+	where := ` WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 AND NOT assignments.instructor`
+	args := []interface{}{courseID, problemSetID}
+	if problemID > 0 {
+		args = append(args, problemID)
+		where += fmt.Sprintf(` AND submission_records.problem_id = $%d`, len(args))
+	}
+	if !from.IsZero() {
+		args = append(args, from)
+		where += fmt.Sprintf(` AND submission_records.created_at >= $%d`, len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		where += fmt.Sprintf(` AND submission_records.created_at < $%d`, len(args))
+	}
+	rows := []*activityRow{}
+	if err := meddler.QueryAll(tx, &rows, `SELECT assignments.user_id, `+
+		`COALESCE(submission_snapshots.graded, false) AS graded, COALESCE(submission_snapshots.passed, false) AS passed, `+
+		`submission_records.created_at `+
+		`FROM submission_records JOIN assignments ON submission_records.assignment_id = assignments.id `+
+		`LEFT JOIN submission_snapshots ON submission_records.id = submission_snapshots.record_id`+
+		where+` ORDER BY submission_records.created_at`, args...); err != nil {
+		return nil, err
+	}
+
+	activity := &ProblemSetActivity{CourseID: courseID, ProblemSetID: problemSetID, From: from, To: to, Hours: []*ActivityHour{}}
+	students := make(map[int64]bool)
+	var hour *ActivityHour
+	var hourStudents map[int64]bool
+	for _, row := range rows {
+		when := row.CreatedAt.Local()
+		start := time.Date(when.Year(), when.Month(), when.Day(), when.Hour(), 0, 0, 0, time.Local)
+		if hour == nil || !hour.Hour.Equal(start) {
+			hour = &ActivityHour{Hour: start}
+			hourStudents = make(map[int64]bool)
+			activity.Hours = append(activity.Hours, hour)
+		}
+		if !hourStudents[row.UserID] {
+			hourStudents[row.UserID] = true
+			hour.Students++
+		}
+		students[row.UserID] = true
+		hour.Submissions++
+		activity.Submissions++
+		activity.Heatmap[when.Weekday()][when.Hour()]++
+		if row.Graded {
+			hour.Graded++
+			activity.Graded++
+			if row.Passed {
+				hour.Passed++
+				activity.Passed++
+			}
+		}
+	}
+	activity.Students = int64(len(students))
+	for _, hour := range activity.Hours {
+		if hour.Graded > 0 {
+			hour.PassRate = float64(hour.Passed) / float64(hour.Graded)
+		}
+	}
+	if activity.Graded > 0 {
+		activity.PassRate = float64(activity.Passed) / float64(activity.Graded)
+	}
+	return activity, nil
+}
+
+// parseActivityRequest gets the problem set activity named by a request,
+// reporting any error to the client.
+func parseActivityRequest(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params) (*ProblemSetActivity, bool) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return nil, false
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return nil, false
+	}
+	from, to, ok := parseUsageRange(w, r)
+	if !ok {
+		return nil, false
+	}
+	var problemID int64
+	unique := r.FormValue("problem")
+	if unique != "" {
+		if err := tx.QueryRow(`SELECT problems.id FROM problems JOIN problem_set_problems ON problems.id = problem_set_problems.problem_id `+
+			`WHERE problem_set_problems.problem_set_id = $1 AND problems.unique_id = $2`, problemSetID, unique).Scan(&problemID); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return nil, false
+		}
+	}
+	activity, err := getProblemSetActivity(tx, courseID, problemSetID, problemID, from, to)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return nil, false
+	}
+	activity.Problem = unique
+	return activity, true
+}
+
+// GetCourseProblemSetActivity handles /v2/courses/:course_id/problem_sets/:problem_set_id/activity requests,
+// returning when students in the course worked on the problem set: submissions, distinct students,
+// and the pass rate for each hour, and submissions by day of the week and hour of the day.
+// It accepts from and to parameters as YYYY-MM-DD to limit the days counted,
+// and a problem parameter with a problem's unique ID to count only that problem.
+func GetCourseProblemSetActivity(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	activity, ok := parseActivityRequest(w, r, tx, params)
+	if !ok {
+		return
+	}
+	render.JSON(http.StatusOK, activity)
+}
+
+// activityBar is one bar in a chart on the activity page.
+type activityBar struct {
+	X, Y, Width, Height float64
+	Title               string
+}
+
+// activityChart is a bar chart of one measure on the activity page.
+type activityChart struct {
+	Title string
+	Max   string
+	Bars  []*activityBar
+}
+
+// newActivityChart draws one bar for each hour with activity, placed along a timeline
+// from the first hour to the last and scaled so the largest value fills the chart.
+func newActivityChart(title string, hours []*ActivityHour, value func(*ActivityHour) float64, max float64, format func(float64) string) *activityChart {
+	chart := &activityChart{Title: title, Max: format(max)}
+	if len(hours) == 0 || max <= 0 {
+		return chart
+	}
+	first := hours[0].Hour
+	span := hours[len(hours)-1].Hour.Sub(first).Hours() + 1
+	width := activityChartWidth / span
+	for _, hour := range hours {
+		v := value(hour)
+		height := v / max * activityChartHeight
+		chart.Bars = append(chart.Bars, &activityBar{
+			X:      hour.Hour.Sub(first).Hours() * width,
+			Y:      activityChartHeight - height,
+			Width:  width,
+			Height: height,
+			Title:  hour.Hour.Format("Mon Jan 2 3pm") + ": " + format(v),
+		})
+	}
+	return chart
+}
+
+// activityCell is one hour of the week in the heatmap on the activity page.
+type activityCell struct {
+	Count   int64
+	Opacity float64
+}
+
+// GetActivityPage handles /activity/:course_id/:problem_set_id requests,
+// returning a page with charts of the activity reported by GetCourseProblemSetActivity.
+// It accepts the same parameters.
+func GetActivityPage(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params) {
+	activity, ok := parseActivityRequest(w, r, tx, params)
+	if !ok {
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, activity.CourseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	problemSet := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", problemSet, activity.ProblemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	count := func(v float64) string { return fmt.Sprintf("%.0f", v) }
+	percent := func(v float64) string { return fmt.Sprintf("%.0f%%", v*100.0) }
+	var maxSubmissions, maxStudents float64
+	for _, hour := range activity.Hours {
+		if float64(hour.Submissions) > maxSubmissions {
+			maxSubmissions = float64(hour.Submissions)
+		}
+		if float64(hour.Students) > maxStudents {
+			maxStudents = float64(hour.Students)
+		}
+	}
+	graded := []*ActivityHour{}
+	for _, hour := range activity.Hours {
+		if hour.Graded > 0 {
+			graded = append(graded, hour)
+		}
+	}
+	charts := []*activityChart{
+		newActivityChart("Submissions per hour", activity.Hours, func(h *ActivityHour) float64 { return float64(h.Submissions) }, maxSubmissions, count),
+		newActivityChart("Students active", activity.Hours, func(h *ActivityHour) float64 { return float64(h.Students) }, maxStudents, count),
+		newActivityChart("Pass rate of graded submissions", graded, func(h *ActivityHour) float64 { return h.PassRate }, 1.0, percent),
+	}
+
+	var busiest int64
+	for _, day := range activity.Heatmap {
+		for _, n := range day {
+			if n > busiest {
+				busiest = n
+			}
+		}
+	}
+	var heatmap [7][24]*activityCell
+	for day := range activity.Heatmap {
+		for hour, n := range activity.Heatmap[day] {
+			cell := &activityCell{Count: n}
+			if busiest > 0 {
+				cell.Opacity = float64(n) / float64(busiest)
+			}
+			heatmap[day][hour] = cell
+		}
+	}
+
+	var span string
+	if len(activity.Hours) > 0 {
+		span = activity.Hours[0].Hour.Format("Mon Jan 2 3pm") + " to " + activity.Hours[len(activity.Hours)-1].Hour.Format("Mon Jan 2 3pm")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := activityTemplate.Execute(w, map[string]interface{}{
+		"Course":     course,
+		"ProblemSet": problemSet,
+		"Activity":   activity,
+		"Span":       span,
+		"Charts":     charts,
+		"Heatmap":    heatmap,
+		"Days":       []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		"Width":      activityChartWidth,
+		"Height":     activityChartHeight,
+	}); err != nil {
+		loggedErrorf("error rendering activity page: %v", err)
+	}
+}
+
+var activityTemplate = template.Must(template.New("activity").Funcs(template.FuncMap{
+	"percent": func(v float64) string { return fmt.Sprintf("%.0f%%", v*100.0) },
+	"hour": func(h int) string {
+		switch {
+		case h == 0:
+			return "12a"
+		case h < 12:
+			return fmt.Sprintf("%da", h)
+		case h == 12:
+			return "12p"
+		default:
+			return fmt.Sprintf("%dp", h-12)
+		}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Activity: {{.ProblemSet.Unique}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 20px; }
+h2 { font-size: 16px; margin: 24px 0 6px; }
+.summary span { margin-right: 20px; }
+svg { background: #fafafa; border: 1px solid #ddd; }
+svg rect { fill: #3a6ea5; }
+.axis { color: #666; font-size: 12px; }
+table.heatmap { border-collapse: collapse; font-size: 11px; }
+table.heatmap th { font-weight: normal; color: #666; padding: 2px 4px; }
+table.heatmap td { width: 28px; height: 20px; text-align: center; border: 1px solid #eee; }
+</style>
+</head>
+<body>
+<h1>{{.Course.Name}}: {{.ProblemSet.Unique}}{{if .Activity.Problem}}, {{.Activity.Problem}}{{end}}</h1>
+<p class="summary">
+<span>{{.Activity.Submissions}} submissions</span>
+<span>{{.Activity.Students}} students</span>
+<span>{{.Activity.Graded}} graded, {{percent .Activity.PassRate}} passed</span>
+</p>
+{{if .Activity.Hours}}
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<div class="axis">up to {{.Max}}</div>
+<svg width="{{$.Width}}" height="{{$.Height}}" viewBox="0 0 {{$.Width}} {{$.Height}}">
+{{range .Bars}}<rect x="{{printf "%.2f" .X}}" y="{{printf "%.2f" .Y}}" width="{{printf "%.2f" .Width}}" height="{{printf "%.2f" .Height}}"><title>{{.Title}}</title></rect>
+{{end}}</svg>
+<div class="axis">{{$.Span}}</div>
+{{end}}
+<h2>Submissions by time of week</h2>
+<table class="heatmap">
+<tr><th></th>{{range $h, $_ := index .Heatmap 0}}<th>{{hour $h}}</th>{{end}}</tr>
+{{range $d, $row := .Heatmap}}<tr><th>{{index $.Days $d}}</th>{{range $row}}<td style="background: rgba(200, 40, 40, {{printf "%.2f" .Opacity}})" title="{{.Count}}">{{if .Count}}{{.Count}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+{{else}}
+<p>No submissions yet.</p>
+{{end}}
+</body>
+</html>
+`))
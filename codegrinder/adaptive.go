@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getProblemTrack returns the track of a problem in a problem set,
+// which is empty for problems given to every student.
+func getProblemTrack(tx *sql.Tx, problemSetID, problemID int64) (string, error) {
+	var track sql.NullString
+	if err := tx.QueryRow(`SELECT track FROM problem_set_problems WHERE problem_set_id = $1 AND problem_id = $2`,
+		problemSetID, problemID).Scan(&track); err != nil {
+		return "", err
+	}
+	return track.String, nil
+}
+
+// placeOnPath chooses the path for a student once the last step of the diagnostic problem
+// of an adaptive problem set has been graded, returning the new path.
+// Nothing changes if the student has already been placed.
+func placeOnPath(tx *sql.Tx, assignment *Assignment, problem *Problem, steps []*ProblemStep, commit *Commit) (string, error) {
+	if assignment.Path != "" || commit.Step != int64(len(steps)) {
+		return "", nil
+	}
+	set := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", set, assignment.ProblemSetID); err != nil {
+		return "", err
+	}
+	if !set.IsAdaptive() || set.DiagnosticProblemID != problem.ID {
+		return "", nil
+	}
+
+	// score the diagnostic problem using its step weights
+	scores := assignment.RawScores[problem.Unique]
+	total, score := 0.0, 0.0
+	for i, step := range steps {
+		total += step.Weight
+		if i < len(scores) {
+			score += scores[i] * step.Weight
+		}
+	}
+	if total > 0.0 {
+		score /= total
+	}
+
+	assignment.Path = TrackRemedial
+	if score >= set.DiagnosticThreshold {
+		assignment.Path = TrackAdvanced
+	}
+	log.Printf("assignment %d placed on the %s path with %.0f%% on diagnostic problem %s",
+		assignment.ID, assignment.Path, score*100.0, problem.Unique)
+	return assignment.Path, nil
+}
+
+// PutProblemSetPath handles /v2/problem_sets/:problem_set_id/path requests,
+// making a problem set adaptive. The diagnostic problem must already be part of the set.
+// Remedial and advanced problems are added to the set if necessary, and all other
+// problems are given to every student. Students already placed keep their paths.
+// The updated list of problems in the set is returned.
+func PutProblemSetPath(w http.ResponseWriter, tx *sql.Tx, params martini.Params, path ProblemSetPath, render render.Render) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	set := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", set, problemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if path.Threshold <= 0.0 || path.Threshold > 1.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "threshold must be greater than 0 and at most 1")
+		return
+	}
+	if len(path.Remedial) == 0 || len(path.Advanced) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "an adaptive problem set needs at least one remedial and one advanced problem")
+		return
+	}
+
+	tracks := make(map[int64]string)
+	for _, id := range path.Remedial {
+		tracks[id] = TrackRemedial
+	}
+	for _, id := range path.Advanced {
+		if tracks[id] != "" {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "problem %d cannot be both remedial and advanced", id)
+			return
+		}
+		tracks[id] = TrackAdvanced
+	}
+	if tracks[path.DiagnosticProblemID] != "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the diagnostic problem must be given to every student")
+		return
+	}
+	if _, err := getProblemTrack(tx, problemSetID, path.DiagnosticProblemID); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "diagnostic problem %d is not part of problem set %s", path.DiagnosticProblemID, set.Unique)
+		} else {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		}
+		return
+	}
+
+	// every problem not on a track is common to all paths
+	if _, err := tx.Exec(`UPDATE problem_set_problems SET track = NULL WHERE problem_set_id = $1`, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for problemID, track := range tracks {
+		problem := new(Problem)
+		if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		res, err := tx.Exec(`UPDATE problem_set_problems SET track = $1 WHERE problem_set_id = $2 AND problem_id = $3`,
+			track, problemSetID, problemID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		} else if n == 0 {
+			psp := &ProblemSetProblem{ProblemSetID: problemSetID, ProblemID: problemID, Weight: 1.0, Track: track}
+			if err := meddler.Insert(tx, "problem_set_problems", psp); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+		}
+	}
+
+	set.DiagnosticProblemID = path.DiagnosticProblemID
+	set.DiagnosticThreshold = path.Threshold
+	set.UpdatedAt = time.Now()
+	if err := meddler.Save(tx, "problem_sets", set); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("problem set %s (%d) is now adaptive with %d remedial and %d advanced problem%s",
+		set.Unique, set.ID, len(path.Remedial), len(path.Advanced), plural(len(path.Advanced)))
+
+	problemSetProblems := []*ProblemSetProblem{}
+	if err := meddler.QueryAll(tx, &problemSetProblems, `SELECT * FROM problem_set_problems WHERE problem_set_id = $1 ORDER BY problem_id`, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, problemSetProblems)
+}
+
+// DeleteProblemSetPath handles /v2/problem_sets/:problem_set_id/path requests,
+// making every problem in an adaptive problem set common to all students again.
+// Paths already recorded on assignments are left alone but no longer matter.
+func DeleteProblemSetPath(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`UPDATE problem_set_problems SET track = NULL WHERE problem_set_id = $1`, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`UPDATE problem_sets SET diagnostic_problem_id = NULL, diagnostic_threshold = 0, updated_at = $1 WHERE id = $2`,
+		time.Now(), problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("problem set %d is no longer adaptive", problemSetID)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// describeAnnouncement fills in the course label and problem set unique ID
+// so users can tell where an announcement came from.
+func describeAnnouncement(tx *sql.Tx, announcement *Announcement) error {
+	if err := tx.QueryRow(`SELECT lti_label FROM courses WHERE id = $1`, announcement.CourseID).Scan(&announcement.CourseLabel); err != nil {
+		return err
+	}
+	announcement.ProblemSetUnique = ""
+	if announcement.ProblemSetID > 0 {
+		if err := tx.QueryRow(`SELECT unique_id FROM problem_sets WHERE id = $1`, announcement.ProblemSetID).Scan(&announcement.ProblemSetUnique); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCourseAnnouncements handles /v2/courses/:course_id/announcements requests,
+// returning every announcement in the course, newest first, with the number of
+// students who have acknowledged each one.
+func GetCourseAnnouncements(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	announcements := []*Announcement{}
+	if err := meddler.QueryAll(tx, &announcements, `SELECT * FROM announcements WHERE course_id = $1 ORDER BY created_at DESC, id DESC`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, announcement := range announcements {
+		if err := describeAnnouncement(tx, announcement); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM announcement_acks WHERE announcement_id = $1`, announcement.ID).Scan(&announcement.Acknowledged); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+	render.JSON(http.StatusOK, announcements)
+}
+
+// PostCourseAnnouncement handles /v2/courses/:course_id/announcements requests,
+// posting an announcement to every student in the course, or only to those
+// assigned the problem set if one is given. The new announcement is returned.
+func PostCourseAnnouncement(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, announcement Announcement, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if err := announcement.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if announcement.ProblemSetID > 0 {
+		var inCourse bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+			courseID, announcement.ProblemSetID).Scan(&inCourse); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if !inCourse {
+			loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not part of course %d", announcement.ProblemSetID, courseID)
+			return
+		}
+	}
+
+	now := time.Now()
+	announcement.ID = 0
+	announcement.CourseID = courseID
+	announcement.AuthorID = currentUser.ID
+	announcement.CreatedAt = now
+	announcement.UpdatedAt = now
+	if err := meddler.Insert(tx, "announcements", &announcement); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := describeAnnouncement(tx, &announcement); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("announcement %d posted to %s by %s", announcement.ID, announcement.Audience(), currentUser.Name)
+	render.JSON(http.StatusOK, &announcement)
+}
+
+// DeleteCourseAnnouncement handles /v2/courses/:course_id/announcements/:announcement_id requests,
+// withdrawing an announcement so students no longer see it.
+func DeleteCourseAnnouncement(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	announcementID, err := parseID(w, "announcement_id", params["announcement_id"])
+	if err != nil {
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM announcements WHERE id = $1 AND course_id = $2`, announcementID, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "announcement %d not found in course %d", announcementID, courseID)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetUserMeAnnouncements handles /v2/users/me/announcements requests,
+// returning the announcements the current user has not acknowledged yet, oldest first.
+// A user sees the announcements for each course they are in and for each problem set
+// assigned to them. It accepts an assignment_id parameter to list only the
+// announcements that apply to one assignment.
+func GetUserMeAnnouncements(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	visible := `EXISTS (SELECT 1 FROM assignments WHERE assignments.user_id = $1 AND assignments.course_id = announcements.course_id ` +
+		`AND (announcements.problem_set_id IS NULL OR assignments.problem_set_id = announcements.problem_set_id)`
+	args := []interface{}{currentUser.ID}
+	if assignmentID := r.FormValue("assignment_id"); assignmentID != "" {
+		id, err := parseID(w, "assignment_id", assignmentID)
+		if err != nil {
+			return
+		}
+		visible += ` AND assignments.id = $2`
+		args = append(args, id)
+	}
+	visible += `)`
+
+	announcements := []*Announcement{}
+	if err := meddler.QueryAll(tx, &announcements, `SELECT * FROM announcements WHERE `+visible+` `+
+		`AND NOT EXISTS (SELECT 1 FROM announcement_acks WHERE announcement_id = announcements.id AND user_id = $1) `+
+		`ORDER BY created_at, id`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, announcement := range announcements {
+		if err := describeAnnouncement(tx, announcement); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+	render.JSON(http.StatusOK, announcements)
+}
+
+// PostUserMeAnnouncementAcknowledge handles /v2/users/me/announcements/:announcement_id/acknowledge requests,
+// recording that the current user has read an announcement so it is not shown to them again.
+// The acknowledgement is returned.
+func PostUserMeAnnouncementAcknowledge(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	announcementID, err := parseID(w, "announcement_id", params["announcement_id"])
+	if err != nil {
+		return
+	}
+	announcement := new(Announcement)
+	if err := meddler.Load(tx, "announcements", announcement, announcementID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	var visible bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE user_id = $1 AND course_id = $2 AND ($3 = 0 OR problem_set_id = $3))`,
+		currentUser.ID, announcement.CourseID, announcement.ProblemSetID).Scan(&visible); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !visible {
+		loggedHTTPErrorf(w, http.StatusNotFound, "announcement %d not found", announcementID)
+		return
+	}
+
+	ack := new(AnnouncementAck)
+	err = meddler.QueryRow(tx, ack, `SELECT * FROM announcement_acks WHERE announcement_id = $1 AND user_id = $2`, announcementID, currentUser.ID)
+	if err == sql.ErrNoRows {
+		ack = &AnnouncementAck{AnnouncementID: announcementID, UserID: currentUser.ID, AcknowledgedAt: time.Now()}
+		err = meddler.Insert(tx, "announcement_acks", ack)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, ack)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getAnonymousGrading returns the anonymous grading settings for a problem set in a course,
+// or nil if grading is not anonymous.
+func getAnonymousGrading(tx *sql.Tx, courseID, problemSetID int64) (*AnonymousGrading, error) {
+	anon := new(AnonymousGrading)
+	err := meddler.QueryRow(tx, anon, `SELECT * FROM anonymous_gradings WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return anon, nil
+}
+
+// maskAnonymousAssignments hides the scores and owners of assignments that
+// belong to other users while their grading is anonymous, so instructors
+// cannot match scores or work to names until grading is finalized.
+func maskAnonymousAssignments(tx *sql.Tx, currentUser *User, assignments []*Assignment) error {
+	for _, asst := range assignments {
+		if asst.UserID == currentUser.ID {
+			continue
+		}
+		anon, err := getAnonymousGrading(tx, asst.CourseID, asst.ProblemSetID)
+		if err != nil {
+			return err
+		}
+		if anon != nil && anon.IsAnonymous() {
+			asst.RawScores = map[string][]float64{}
+			asst.Score = 0.0
+			asst.UserID = 0
+		}
+	}
+	return nil
+}
+
+// getStudentAssignment finds the assignment of the student named in a URL.
+// Normally the student is given by user ID, but while grading is anonymous
+// only the student's pseudonym is accepted, since a user ID ties the work to a name.
+// The anonymous grading settings are returned as well, or nil if grading is not anonymous.
+func getStudentAssignment(tx *sql.Tx, courseID, problemSetID int64, student string) (*Assignment, *AnonymousGrading, error) {
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		return nil, nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if anon == nil || !anon.IsAnonymous() {
+		userID, err := strconv.ParseInt(student, 10, 64)
+		if err != nil || userID < 1 {
+			return nil, nil, httpErrorf(http.StatusBadRequest, "invalid ID in URL: %q is not a user ID", student)
+		}
+		asst := new(Assignment)
+		if err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE course_id = $1 AND problem_set_id = $2 AND user_id = $3`,
+			courseID, problemSetID, userID); err != nil {
+			return nil, nil, dbNotFoundError(err)
+		}
+		return asst, nil, nil
+	}
+
+	if !strings.HasPrefix(student, PseudonymPrefix) {
+		return nil, nil, httpErrorf(http.StatusBadRequest, "grading for this problem set is anonymous, so students must be named by pseudonym")
+	}
+	assignments := []*Assignment{}
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE course_id = $1 AND problem_set_id = $2 AND NOT instructor`,
+		courseID, problemSetID); err != nil {
+		return nil, nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	for _, asst := range assignments {
+		if anon.Pseudonym(asst.UserID) == student {
+			return asst, anon, nil
+		}
+	}
+	return nil, nil, httpErrorf(http.StatusNotFound, "no student on this problem set has the pseudonym %s", student)
+}
+
+// getAnonymousProblemSets returns the anonymous grading settings for every problem set
+// in a course whose grading is still anonymous, keyed by problem set ID.
+func getAnonymousProblemSets(tx *sql.Tx, courseID int64) (map[int64]*AnonymousGrading, error) {
+	list := []*AnonymousGrading{}
+	if err := meddler.QueryAll(tx, &list, `SELECT * FROM anonymous_gradings WHERE course_id = $1 AND finalized_at IS NULL`, courseID); err != nil {
+		return nil, err
+	}
+	anons := make(map[int64]*AnonymousGrading)
+	for _, anon := range list {
+		anons[anon.ProblemSetID] = anon
+	}
+	return anons, nil
+}
+
+// postGrade posts an assignment's grade to the LMS,
+// unless it is being held back until anonymous grading is finalized.
+func postGrade(tx *sql.Tx, tenant *TenantConfig, asst *Assignment, user *User, span *traceSpan) error {
+	anon, err := getAnonymousGrading(tx, asst.CourseID, asst.ProblemSetID)
+	if err != nil {
+		return err
+	}
+	if anon != nil && anon.IsAnonymous() {
+		log.Printf("grade for assignment %d held back until anonymous grading is finalized", asst.ID)
+		return nil
+	}
+	return saveGrade(tx, tenant, asst, user, span)
+}
+
+// PutCourseProblemSetAnonymous handles /v2/courses/:course_id/problem_sets/:problem_set_id/anonymous requests,
+// making grading for the problem set anonymous in the course. Instructor views show pseudonyms
+// and grades are held back from the LMS until grading is finalized.
+// The anonymous grading settings are returned.
+func PutCourseProblemSetAnonymous(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if anon != nil {
+		if !anon.IsAnonymous() {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "grading for this problem set has already been finalized")
+			return
+		}
+		render.JSON(http.StatusOK, anon)
+		return
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating salt: %v", err)
+		return
+	}
+	now := time.Now()
+	anon = &AnonymousGrading{
+		CourseID:     courseID,
+		ProblemSetID: problemSetID,
+		Salt:         hex.EncodeToString(salt),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := meddler.Insert(tx, "anonymous_gradings", anon); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("grading for problem set %d in course %d is now anonymous", problemSetID, courseID)
+	render.JSON(http.StatusOK, anon)
+}
+
+// GetCourseProblemSetGrades handles /v2/courses/:course_id/problem_sets/:problem_set_id/grades requests,
+// returning the grade of every student on the problem set. While grading is anonymous,
+// students are identified only by pseudonyms, assignment IDs are left out, and the list
+// is sorted by pseudonym.
+// If section_id is given, only students in that section are included.
+func GetCourseProblemSetGrades(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	sectionID, err := parseSectionFilter(w, r)
+	if err != nil {
+		return
+	}
+
+	grades, err := getCourseProblemSetGrades(tx, courseID, problemSetID, sectionID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if anon != nil && anon.IsAnonymous() {
+		for _, elt := range grades {
+			elt.Name = anon.Pseudonym(elt.UserID)
+			elt.AssignmentID = 0
+			elt.UserID = 0
+			elt.Email = ""
+			elt.Anonymous = true
+		}
+		sort.Sort(gradeEntriesByName(grades))
+	}
+
+	render.JSON(http.StatusOK, grades)
+}
+
+// PostCourseProblemSetFinalize handles /v2/courses/:course_id/problem_sets/:problem_set_id/finalize requests,
+// ending anonymous grading for the problem set and posting the grades that were held back to the LMS.
+// Failures to post individual grades are logged and do not stop the others.
+// The grades are returned with student identities revealed.
+func PostCourseProblemSetFinalize(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, span *traceSpan, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if anon == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "grading for problem set %d in course %d is not anonymous", problemSetID, courseID)
+		return
+	}
+	if !anon.IsAnonymous() {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "grading for this problem set has already been finalized")
+		return
+	}
+
+	now := time.Now()
+	anon.FinalizedAt = now
+	anon.UpdatedAt = now
+	if _, err := tx.Exec(`UPDATE anonymous_gradings SET finalized_at = $1, updated_at = $1 WHERE course_id = $2 AND problem_set_id = $3`,
+		now, courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// post the grades that were held back
+	assignments := []*Assignment{}
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE course_id = $1 AND problem_set_id = $2 AND NOT instructor ORDER BY id`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	posted := 0
+	for _, asst := range assignments {
+		if len(asst.RawScores) == 0 {
+			continue
+		}
+		user := new(User)
+		if err := meddler.Load(tx, "users", user, asst.UserID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if err := saveGrade(tx, tenant, asst, user, span); err != nil {
+			log.Printf("error posting grade for assignment %d: %v", asst.ID, err)
+			continue
+		}
+		posted++
+	}
+	log.Printf("grading for problem set %d in course %d finalized, %d grade%s posted", problemSetID, courseID, posted, plural(posted))
+
+	grades, err := getCourseProblemSetGrades(tx, courseID, problemSetID, 0)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, grades)
+}
+
+// getCourseProblemSetGrades returns the grades of students on a problem set,
+// limited to one section if sectionID is non-zero.
+func getCourseProblemSetGrades(tx *sql.Tx, courseID, problemSetID, sectionID int64) ([]*GradeEntry, error) {
+	where := ""
+	args := []interface{}{courseID, problemSetID}
+	if sectionID > 0 {
+		where = ` AND users.id IN (SELECT user_id FROM section_members WHERE section_id = $3 AND role = 'student')`
+		args = append(args, sectionID)
+	}
+	grades := []*GradeEntry{}
+	err := meddler.QueryAll(tx, &grades, `SELECT assignments.id AS assignment_id, users.id AS user_id, users.name, users.email, `+
+		`assignments.raw_scores, assignments.score, assignments.updated_at `+
+		`FROM assignments JOIN users ON assignments.user_id = users.id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 AND NOT assignments.instructor`+where+` `+
+		`ORDER BY users.name, users.id`,
+		args...)
+	return grades, err
+}
+
+type gradeEntriesByName []*GradeEntry
+
+func (s gradeEntriesByName) Len() int           { return len(s) }
+func (s gradeEntriesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s gradeEntriesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// archiveRow is an assignment with the course details that decide whether it is archived.
+type archiveRow struct {
+	ID               int64     `meddler:"id"`
+	CourseID         int64     `meddler:"course_id"`
+	CourseName       string    `meddler:"course_name"`
+	CourseLabel      string    `meddler:"lti_label"`
+	Term             string    `meddler:"term,zeroisnull"`
+	CourseEndsAt     time.Time `meddler:"course_ends_at,localtimez"`
+	Archived         bool      `meddler:"archived"`
+	CourseUpdatedAt  time.Time `meddler:"course_updated_at,localtime"`
+	ProblemSetID     int64     `meddler:"problem_set_id"`
+	ProblemSetUnique string    `meddler:"unique_id"`
+	CanvasTitle      string    `meddler:"canvas_title"`
+	Score            float64   `meddler:"score"`
+	DroppedAt        time.Time `meddler:"dropped_at,localtimez"`
+}
+
+const archiveQuery = `SELECT assignments.id, assignments.course_id, courses.name AS course_name, courses.lti_label, courses.term, ` +
+	`courses.ends_at AS course_ends_at, courses.archived, courses.updated_at AS course_updated_at, ` +
+	`assignments.problem_set_id, problem_sets.unique_id, assignments.canvas_title, COALESCE(assignments.score, 0) AS score, ` +
+	`assignments.dropped_at ` +
+	`FROM assignments JOIN courses ON assignments.course_id = courses.id ` +
+	`JOIN problem_sets ON assignments.problem_set_id = problem_sets.id ` +
+	`WHERE assignments.user_id = $1 AND NOT assignments.instructor `
+
+// archived describes the assignment if it has closed to the student, or returns nil if it is still open.
+// An archived course with no end date is taken to have closed when it was last updated.
+func (row *archiveRow) archived(now time.Time) *ArchivedAssignment {
+	var closedAt time.Time
+	switch {
+	case !row.DroppedAt.IsZero():
+		closedAt = row.DroppedAt
+	case !row.CourseEndsAt.IsZero() && !now.Before(row.CourseEndsAt):
+		closedAt = row.CourseEndsAt
+	case row.Archived:
+		closedAt = row.CourseUpdatedAt
+	default:
+		return nil
+	}
+	elt := &ArchivedAssignment{
+		AssignmentID:     row.ID,
+		CourseID:         row.CourseID,
+		CourseName:       row.CourseName,
+		CourseLabel:      row.CourseLabel,
+		Term:             row.Term,
+		ProblemSetID:     row.ProblemSetID,
+		ProblemSetUnique: row.ProblemSetUnique,
+		CanvasTitle:      row.CanvasTitle,
+		Score:            row.Score,
+		Dropped:          !row.DroppedAt.IsZero(),
+		ClosedAt:         closedAt,
+	}
+	if Config().ArchiveDays > 0 {
+		elt.AvailableUntil = closedAt.AddDate(0, 0, Config().ArchiveDays)
+	}
+	return elt
+}
+
+// archiveExpired reports whether the student can no longer download the work.
+func archiveExpired(elt *ArchivedAssignment, now time.Time) bool {
+	return !elt.AvailableUntil.IsZero() && !now.Before(elt.AvailableUntil)
+}
+
+// GetUserMeArchive handles /v2/users/me/archive requests,
+// returning the current user's assignments that have closed to them
+// but whose work they can still download.
+func GetUserMeArchive(w http.ResponseWriter, tx *sql.Tx, currentUser *User, render render.Render) {
+	rows := []*archiveRow{}
+	if err := meddler.QueryAll(tx, &rows, archiveQuery+`ORDER BY courses.name, problem_sets.unique_id`, currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	now := time.Now()
+	archive := []*ArchivedAssignment{}
+	for _, row := range rows {
+		if elt := row.archived(now); elt != nil && !archiveExpired(elt, now) {
+			archive = append(archive, elt)
+		}
+	}
+	render.JSON(http.StatusOK, archive)
+}
+
+// GetUserMeArchiveAssignment handles /v2/users/me/archive/:assignment_id requests,
+// returning the current user's last commit on each problem of an archived assignment
+// and a signed grade report. Nothing can be changed through the archive.
+func GetUserMeArchiveAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	row := new(archiveRow)
+	if err := meddler.QueryRow(tx, row, archiveQuery+`AND assignments.id = $2`, currentUser.ID, assignmentID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	now := time.Now()
+	elt := row.archived(now)
+	if elt == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "assignment %d is still open; use it as usual instead of through the archive", assignmentID)
+		return
+	}
+	if archiveExpired(elt, now) {
+		loggedHTTPErrorf(w, http.StatusGone, "work for assignment %d was available until %s", assignmentID, elt.AvailableUntil.Format("2006-01-02"))
+		return
+	}
+
+	work := &ArchivedWork{Assignment: elt, Commits: make(map[string]*Commit)}
+	commits := []*Commit{}
+	if err := meddler.QueryAll(tx, &commits, `SELECT DISTINCT ON (problem_id) * FROM commits WHERE assignment_id = $1 `+
+		`ORDER BY problem_id, step DESC, created_at DESC`, assignmentID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, commit := range commits {
+		var unique string
+		if err := tx.QueryRow(`SELECT unique_id FROM problems WHERE id = $1`, commit.ProblemID).Scan(&unique); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		commit.AddChecksums()
+		work.Commits[unique] = commit
+	}
+
+	assignment := new(Assignment)
+	if err := meddler.Load(tx, "assignments", assignment, assignmentID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if work.Report, err = buildGradeReport(tx, assignment); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, work)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// assets holds the files compiled into the server, so a release binary
+// can be installed without a copy of the source tree:
+//
+//	assets/schema.sql  the database schema
+//	assets/static/     web files served when no StaticDir is configured
+//
+//go:embed assets
+var assets embed.FS
+
+func init() {
+	commands["schema"] = &serverCommand{
+		Short:    "print the database schema, e.g., codegrinder schema | psql",
+		Run:      CommandSchema,
+		NoConfig: true,
+	}
+}
+
+// embeddedSchema returns the database schema compiled into the server.
+func embeddedSchema() []byte {
+	schema, err := assets.ReadFile("assets/schema.sql")
+	if err != nil {
+		log.Fatalf("error reading the embedded schema: %v", err)
+	}
+	return schema
+}
+
+// CommandSchema handles "codegrinder schema",
+// printing the database schema this server expects.
+func CommandSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("usage: codegrinder schema")
+	}
+	os.Stdout.Write(embeddedSchema())
+}
+
+// serveEmbeddedStatic serves GET and HEAD requests for files in assets/static,
+// passing anything else on to the next handler like martini.Static does.
+func serveEmbeddedStatic() http.HandlerFunc {
+	static, err := fs.Sub(assets, "assets/static")
+	if err != nil {
+		log.Fatalf("error reading the embedded static files: %v", err)
+	}
+	files := http.FileServer(http.FS(static))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			return
+		}
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+		info, err := fs.Stat(static, name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			if _, err := fs.Stat(static, path.Join(name, "index.html")); err != nil {
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,928 @@
+CREATE TYPE problem_types AS ENUM (
+    'python27inout',
+    'python27unittest',
+    'cppgtest',
+    'cppinout',
+    'gotest',
+    'nand2tetrishardware',
+    'nand2tetrisassembly',
+    'nasmgtest',
+    'ocamlounit',
+    'prologunittest',
+    'standardmlunittest'
+);
+
+CREATE TABLE problems (
+    id                      bigserial NOT NULL,
+    unique_id               text NOT NULL,
+    note                    text NOT NULL,
+    problem_type            problem_types NOT NULL,
+    tags                    jsonb NOT NULL,
+    options                 jsonb NOT NULL,
+    mastery                 bigint NOT NULL DEFAULT 0,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX problems_unique_id ON problems (unique_id);
+
+CREATE TABLE problem_steps (
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    note                    text NOT NULL,
+    instructions            text NOT NULL,
+    weight                  double precision NOT NULL,
+    files                   jsonb NOT NULL,
+    carry                   jsonb NOT NULL DEFAULT '[]',
+
+    PRIMARY KEY (problem_id, step),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE problem_solutions (
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    files                   jsonb NOT NULL,
+
+    PRIMARY KEY (problem_id, step),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+-- secret values are encrypted with a key derived from DaycareSecret
+CREATE TABLE problem_variables (
+    problem_id              bigint NOT NULL,
+    name                    text NOT NULL,
+    value                   text NOT NULL,
+    secret                  boolean NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, name),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+-- the latest nightly regrade of each problem's reference solutions on each daycare
+CREATE TABLE canary_results (
+    problem_id              bigint NOT NULL,
+    host                    text NOT NULL,
+    image                   text NOT NULL,
+    image_id                text NOT NULL,
+    version                 text NOT NULL,
+    passed                  boolean NOT NULL,
+    note                    text NOT NULL,
+    failing_since           timestamp with time zone,
+    ran_at                  timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, host),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+-- grading costs for each problem, totaled by the term of the course it was graded for
+CREATE TABLE problem_costs (
+    problem_id              bigint NOT NULL,
+    term                    text NOT NULL,
+    runs                    bigint NOT NULL,
+    runtime_seconds         double precision NOT NULL,
+    cpu_seconds             double precision NOT NULL,
+    memory_mb_total         double precision NOT NULL,
+    max_memory_mb           double precision NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, term),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+-- each variant grades submissions in a different container image
+CREATE TABLE problem_toolchain_variants (
+    problem_id              bigint NOT NULL,
+    label                   text NOT NULL,
+    image                   text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, label),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE problem_validations (
+    problem_id              bigint NOT NULL,
+    image                   text NOT NULL,
+    image_id                text NOT NULL,
+    version                 text NOT NULL,
+    passed                  boolean NOT NULL,
+    note                    text NOT NULL,
+    validated_at            timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, image_id),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE problem_sets (
+    id                      bigserial NOT NULL,
+    unique_id               text NOT NULL,
+    note                    text NOT NULL,
+    tags                    jsonb NOT NULL,
+    diagnostic_problem_id   bigint,
+    diagnostic_threshold    double precision NOT NULL DEFAULT 0,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (diagnostic_problem_id) REFERENCES problems (id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX problem_sets_unique_id ON problem_sets (unique_id);
+
+CREATE TABLE problem_set_problems (
+    problem_set_id          bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    weight                  double precision NOT NULL,
+    track                   text,
+
+    PRIMARY KEY (problem_set_id, problem_id),
+    FOREIGN KEY (problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE problem_set_imports (
+    problem_set_id          bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    from_problem_id         bigint NOT NULL,
+    files                   jsonb NOT NULL DEFAULT '[]',
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_set_id, problem_id),
+    FOREIGN KEY (problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE,
+    FOREIGN KEY (from_problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE courses (
+    id                      bigserial NOT NULL,
+    name                    text NOT NULL,
+    lti_label               text NOT NULL,
+    lti_id                  text NOT NULL,
+    canvas_id               bigint NOT NULL,
+    term                    text,
+    ends_at                 timestamp with time zone,
+    archived                boolean NOT NULL DEFAULT false,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+    archive_override        boolean NOT NULL DEFAULT false,
+    roster_url              text,
+    roster_id               text,
+    roster_consumer_key     text,
+    roster_synced_at        timestamp with time zone,
+
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX courses_lti_label ON courses (lti_label);
+CREATE UNIQUE INDEX courses_lti_id ON courses (lti_id);
+CREATE UNIQUE INDEX courses_canvas_id ON courses (canvas_id);
+
+CREATE TABLE course_problem_sets (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE
+);
+
+CREATE TABLE prerequisites (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    required_problem_set_id bigint NOT NULL,
+    threshold               double precision NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id, required_problem_set_id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE,
+    FOREIGN KEY (required_problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE
+);
+
+CREATE TABLE problem_type_overrides (
+    course_id               bigint NOT NULL,
+    problem_type            text NOT NULL,
+    image                   text NOT NULL,
+    max_cpu                 integer NOT NULL,
+    max_clock               integer NOT NULL,
+    max_fd                  integer NOT NULL,
+    max_file_size           integer NOT NULL,
+    max_memory              integer NOT NULL,
+    max_threads             integer NOT NULL,
+    options                 jsonb NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_type),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE
+);
+
+CREATE TABLE users (
+    id                      bigserial NOT NULL,
+    name                    text NOT NULL,
+    email                   text NOT NULL,
+    lti_id                  text NOT NULL,
+    lti_image_url           text,
+    canvas_login            text NOT NULL,
+    canvas_id               bigint NOT NULL,
+    author                  boolean NOT NULL,
+    admin                   boolean NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+    last_signed_in_at       timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX users_lti_id ON users (lti_id);
+CREATE UNIQUE INDEX users_canvas_login ON users (canvas_login);
+CREATE UNIQUE INDEX users_canvas_id ON users (canvas_id);
+
+CREATE TABLE sections (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    canvas_id               bigint NOT NULL,
+    name                    text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX sections_course_id_canvas_id ON sections (course_id, canvas_id);
+
+CREATE TABLE section_members (
+    section_id              bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    role                    text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (section_id, user_id),
+    FOREIGN KEY (section_id) REFERENCES sections (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX section_members_user_id ON section_members (user_id);
+
+CREATE TABLE assignments (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    roles                   text NOT NULL,
+    instructor              boolean NOT NULL,
+    raw_scores              jsonb NOT NULL,
+    score                   double precision,
+    grade_id                text,
+    lti_id                  text NOT NULL,
+    canvas_title            text NOT NULL,
+    canvas_id               bigint NOT NULL,
+    canvas_api_domain       text NOT NULL,
+    outcome_url             text NOT NULL,
+    outcome_ext_url         text NOT NULL,
+    outcome_ext_accepted    text NOT NULL,
+    finished_url            text NOT NULL,
+    consumer_key            text NOT NULL,
+    dropped_at              timestamp with time zone,
+    due_at                  timestamp with time zone,
+    path                    text,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX assignments_unique_user ON assignments (user_id, lti_id);
+CREATE UNIQUE INDEX assignments_grade_id ON assignments (grade_id);
+
+CREATE TABLE mastery_streaks (
+    assignment_id           bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    streak                  bigint NOT NULL,
+    required                bigint NOT NULL,
+    attempts                bigint NOT NULL,
+    mastered_at             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (assignment_id, problem_id, step),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE mastery_attempts (
+    assignment_id           bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    signature               text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (assignment_id, problem_id, step, signature),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE commits (
+    id                      bigserial NOT NULL,
+    assignment_id           bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    action                  text,
+    note                    text,
+    files                   jsonb NOT NULL,
+    transcript              jsonb NOT NULL,
+    report_card             jsonb NOT NULL,
+    reflections             jsonb NOT NULL DEFAULT '{}',
+    score                   double precision,
+    client_time             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id, step) REFERENCES problem_steps (problem_id, step) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX commits_unique_assignment_problem_step ON commits (assignment_id, problem_id, step);
+
+CREATE TABLE submission_records (
+    id                      bigserial NOT NULL,
+    assignment_id           bigint NOT NULL,
+    commit_id               bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    action                  text,
+    digest                  text NOT NULL,
+    prev_hash               text,
+    hash                    text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE
+);
+CREATE INDEX submission_records_assignment_id ON submission_records (assignment_id, id);
+
+CREATE TABLE submission_snapshots (
+    record_id               bigint NOT NULL,
+    files                   jsonb NOT NULL,
+    graded                  boolean NOT NULL,
+    passed                  boolean NOT NULL,
+    score                   double precision NOT NULL,
+    outcomes                jsonb NOT NULL,
+
+    PRIMARY KEY (record_id),
+    FOREIGN KEY (record_id) REFERENCES submission_records (id) ON DELETE CASCADE
+);
+
+CREATE TABLE submissions (
+    id                      bigserial NOT NULL,
+    user_id                 bigint NOT NULL,
+    assignment_id           bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    commit_id               bigint NOT NULL,
+    files                   jsonb NOT NULL,
+    status                  text NOT NULL,
+    note                    text NOT NULL,
+    score                   double precision NOT NULL,
+    report_card             jsonb NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (commit_id) REFERENCES commits (id) ON DELETE CASCADE
+);
+CREATE INDEX submissions_status ON submissions (status, id);
+CREATE INDEX submissions_user_id ON submissions (user_id, id);
+
+CREATE TABLE sealed_exams (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    public_key              text NOT NULL,
+    deadline                timestamp with time zone NOT NULL,
+    unsealed_at             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE
+);
+
+CREATE TABLE solution_releases (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    release_at              timestamp with time zone,
+    on_pass                 boolean NOT NULL,
+    files                   jsonb NOT NULL DEFAULT '[]',
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE
+);
+
+CREATE TABLE sealed_submissions (
+    id                      bigserial NOT NULL,
+    user_id                 bigint NOT NULL,
+    assignment_id           bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    step                    bigint NOT NULL,
+    encrypted_key           text NOT NULL,
+    nonce                   text NOT NULL,
+    ciphertext              text NOT NULL,
+    submission_id           bigint,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE,
+    FOREIGN KEY (submission_id) REFERENCES submissions (id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX sealed_submissions_assignment_problem_step ON sealed_submissions (assignment_id, problem_id, step);
+
+CREATE TABLE reflection_prompts (
+    problem_id              bigint NOT NULL,
+    name                    text NOT NULL,
+    question                text NOT NULL,
+    asked_when              text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, name),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+-- misconceptions recognized in student code or failing output, with how often each is seen
+CREATE TABLE feedback_rules (
+    problem_id              bigint NOT NULL,
+    name                    text NOT NULL,
+    match_on                text NOT NULL,
+    pattern                 text NOT NULL,
+    files                   text NOT NULL,
+    step                    bigint NOT NULL,
+    message                 text NOT NULL,
+    hits                    bigint NOT NULL,
+    last_hit_at             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (problem_id, name),
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE rubrics (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    criteria                jsonb NOT NULL,
+    formula                 text NOT NULL,
+    weight                  double precision NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE
+);
+
+CREATE TABLE rubric_grades (
+    assignment_id           bigint NOT NULL,
+    levels                  jsonb NOT NULL,
+    comments                jsonb NOT NULL DEFAULT '{}',
+    note                    text,
+    points                  double precision NOT NULL,
+    score                   double precision NOT NULL,
+    grader_id               bigint,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (assignment_id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (grader_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+-- zero means no limit
+CREATE TABLE course_quotas (
+    course_id               bigint NOT NULL,
+    grading_minutes         double precision NOT NULL,
+    storage_mb              double precision NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE
+);
+
+CREATE TABLE course_usage_days (
+    course_id               bigint NOT NULL,
+    day                     timestamp with time zone NOT NULL,
+    gradings                bigint NOT NULL,
+    grading_seconds         double precision NOT NULL,
+
+    PRIMARY KEY (course_id, day),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE
+);
+
+CREATE TABLE checkoff_policies (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    weight                  double precision NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE
+);
+
+CREATE TABLE checkoffs (
+    assignment_id           bigint NOT NULL,
+    note                    text,
+    grader_id               bigint,
+    checked_off_at          timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (assignment_id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (grader_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE lab_sessions (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    code                    text NOT NULL,
+    purpose                 text NOT NULL,
+    created_by              bigint,
+    expires_at              timestamp with time zone NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE INDEX lab_sessions_code ON lab_sessions (code, expires_at);
+
+CREATE TABLE lab_redemptions (
+    lab_session_id          bigint NOT NULL,
+    assignment_id           bigint NOT NULL,
+    redeemed_at             timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (lab_session_id, assignment_id),
+    FOREIGN KEY (lab_session_id) REFERENCES lab_sessions (id) ON DELETE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE
+);
+
+CREATE TABLE exemplar_consents (
+    assignment_id           bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (assignment_id, problem_id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE
+);
+
+CREATE TABLE exemplars (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    problem_id              bigint NOT NULL,
+    commit_id               bigint NOT NULL,
+    note                    text NOT NULL,
+    files                   jsonb NOT NULL,
+    visible_at              timestamp with time zone NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE,
+    FOREIGN KEY (commit_id) REFERENCES commits (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX exemplars_course_commit ON exemplars (course_id, commit_id);
+
+CREATE TABLE anonymous_gradings (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    salt                    text NOT NULL,
+    finalized_at            timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE
+);
+
+CREATE TABLE moderations (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    sample_percent          double precision NOT NULL,
+    threshold               double precision NOT NULL,
+    second_marker_id        bigint NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE,
+    FOREIGN KEY (second_marker_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE moderation_marks (
+    id                      bigserial NOT NULL,
+    assignment_id           bigint NOT NULL,
+    first_score             double precision NOT NULL,
+    second_score            double precision NOT NULL,
+    second_note             text,
+    second_marked_at        timestamp with time zone,
+    flagged                 boolean NOT NULL,
+    reconciled_score        double precision NOT NULL,
+    reconciled_by_id        bigint,
+    reconciled_note         text,
+    reconciled_at           timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (reconciled_by_id) REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX moderation_marks_assignment_id ON moderation_marks (assignment_id);
+
+CREATE TABLE roster_syncs (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    students                bigint NOT NULL,
+    dropped                 jsonb NOT NULL,
+    returned                jsonb NOT NULL,
+    missing                 jsonb NOT NULL,
+    error                   text,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE
+);
+CREATE INDEX roster_syncs_course_id ON roster_syncs (course_id, created_at);
+
+CREATE TABLE feature_flags (
+    name                    text NOT NULL,
+    description             text,
+    percent                 double precision NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (name)
+);
+
+CREATE TABLE course_feature_flags (
+    course_id               bigint NOT NULL,
+    name                    text NOT NULL,
+    enabled                 boolean NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, name),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (name) REFERENCES feature_flags (name) ON DELETE CASCADE
+);
+
+-- at most one scheduled maintenance window, announced to users until it ends
+CREATE TABLE maintenance_notices (
+    id                      bigserial NOT NULL,
+    message                 text NOT NULL,
+    starts_at               timestamp with time zone NOT NULL,
+    ends_at                 timestamp with time zone NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE github_classrooms (
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint NOT NULL,
+    classroom_assignment_id bigint NOT NULL,
+    logins                  jsonb NOT NULL,
+    imported_at             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, problem_set_id),
+    FOREIGN KEY (course_id, problem_set_id) REFERENCES course_problem_sets (course_id, problem_set_id) ON DELETE CASCADE
+);
+
+CREATE TABLE github_submissions (
+    submission_id           bigint NOT NULL,
+    repository              text NOT NULL,
+    sha                     text NOT NULL,
+    status_posted           boolean NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (submission_id),
+    FOREIGN KEY (submission_id) REFERENCES submissions (id) ON DELETE CASCADE
+);
+CREATE INDEX github_submissions_repository ON github_submissions (repository, sha);
+
+-- toolchain images are shared by every tenant, so only the default schema's table is used
+CREATE TABLE toolchain_images (
+    id                      bigserial NOT NULL,
+    problem_type            text NOT NULL,
+    image                   text NOT NULL,
+    image_id                text NOT NULL,
+    reference               text NOT NULL,
+    scan_note               text,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id)
+);
+CREATE INDEX toolchain_images_problem_type ON toolchain_images (problem_type, created_at);
+
+-- dependency snapshots are named by their contents, so they are shared by every tenant too
+CREATE TABLE dependency_images (
+    id                      bigserial NOT NULL,
+    key                     text NOT NULL,
+    problem_type            text NOT NULL,
+    base_image_id           text NOT NULL,
+    image_id                text NOT NULL,
+    reference               text NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX dependency_images_key ON dependency_images (key);
+
+CREATE TABLE course_suspensions (
+    course_id               bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    until                   timestamp with time zone NOT NULL,
+    reason                  text,
+    suspended_by            bigint NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, user_id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- messages from instructors to a course, or to the students working on one problem set in it
+CREATE TABLE announcements (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    problem_set_id          bigint,
+    message                 text NOT NULL,
+    author_id               bigint NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (problem_set_id) REFERENCES problem_sets (id) ON DELETE CASCADE
+);
+CREATE INDEX announcements_course_id ON announcements (course_id, created_at);
+
+CREATE TABLE announcement_acks (
+    announcement_id         bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    acknowledged_at         timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (announcement_id, user_id),
+    FOREIGN KEY (announcement_id) REFERENCES announcements (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- work sent to the email gateway, waiting for an instructor to approve it for grading
+CREATE TABLE email_submissions (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    assignment_id           bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    sender                  text NOT NULL,
+    subject                 text NOT NULL,
+    files                   jsonb NOT NULL,
+    status                  text NOT NULL,
+    note                    text,
+    reviewed_by             bigint,
+    reviewed_at             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX email_submissions_course_id ON email_submissions (course_id, status, created_at);
+
+-- students waiting for help in office hours
+CREATE TABLE help_requests (
+    id                      bigserial NOT NULL,
+    course_id               bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    assignment_id           bigint,
+    problem_id              bigint,
+    step                    bigint,
+    commit_id               bigint,
+    message                 text NOT NULL,
+    status                  text NOT NULL,
+    helper_id               bigint,
+    note                    text,
+    claimed_at              timestamp with time zone,
+    resolved_at             timestamp with time zone,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE SET NULL
+);
+CREATE INDEX help_requests_course_id ON help_requests (course_id, status, created_at);
+CREATE INDEX help_requests_user_id ON help_requests (user_id, status);
+
+-- the live session an instructor is showing to a course, if any
+CREATE TABLE course_broadcasts (
+    course_id               bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    share_id                text NOT NULL,
+    problem                 text,
+    action                  text,
+    started_at              timestamp with time zone NOT NULL,
+    expires_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- instructors who want a weekly email listing students who may be falling behind
+CREATE TABLE risk_digests (
+    course_id               bigint NOT NULL,
+    user_id                 bigint NOT NULL,
+    created_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (course_id, user_id),
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- schools listed in the public discovery index searched by grind init --school
+CREATE TABLE institutions (
+    id                      bigserial NOT NULL,
+    name                    text NOT NULL,
+    hostname                text NOT NULL,
+    contact                 text NOT NULL,
+    approved                boolean NOT NULL DEFAULT false,
+    created_at              timestamp with time zone NOT NULL,
+    updated_at              timestamp with time zone NOT NULL,
+
+    PRIMARY KEY (id),
+    UNIQUE (hostname)
+);
+
+CREATE VIEW user_problem_sets AS
+    (SELECT DISTINCT assignments.user_id, problem_sets.id AS problem_set_id FROM
+    assignments JOIN problem_sets ON assignments.problem_set_id = problem_sets.id)
+    UNION
+    (SELECT DISTINCT instructors.id AS user_id, assignments.problem_set_id AS problem_set_id FROM
+    users AS instructors JOIN assignments AS instructors_assignments ON instructors.id = instructors_assignments.user_id
+    JOIN courses ON instructors_assignments.course_id = courses.id
+    JOIN assignments ON courses.id = assignments.id
+    WHERE instructors_assignments.instructor);
+
+CREATE VIEW user_problems AS
+    (SELECT DISTINCT assignments.user_id, problem_set_problems.problem_id FROM
+    assignments JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
+    JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_set_id)
+    UNION
+    (SELECT DISTINCT instructors.id AS user_id, problem_set_problems.problem_id FROM
+    users AS instructors JOIN assignments AS instructors_assignments ON instructors.id = instructors_assignments.user_id
+    JOIN courses ON instructors_assignments.course_id = courses.id
+    JOIN assignments ON courses.id = assignments.id
+    JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
+    JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_id
+    WHERE instructors_assignments.instructor);
+
+CREATE VIEW user_users AS
+    (SELECT DISTINCT instructors.id AS user_id, users.id AS other_user_id FROM
+    users AS instructors JOIN assignments AS instructors_assignments ON instructors.id = instructors_assignments.user_id
+    JOIN courses ON instructors_assignments.course_id = courses.id
+    JOIN assignments ON courses.id = assignments.id
+    JOIN users ON assignments.user_id = users.id
+    WHERE instructors_assignments.instructor)
+    UNION
+    (SELECT id as user_id, id AS other_user_id FROM users);
+
+CREATE VIEW user_assignments AS
+    (SELECT DISTINCT instructors.id AS user_id, assignments.id AS assignment_id FROM
+    users AS instructors JOIN assignments AS instructors_assignments ON instructors.id = instructors_assignments.user_id
+    JOIN courses ON instructors_assignments.course_id = courses.id
+    JOIN assignments ON courses.id = assignments.id
+    WHERE instructors_assignments.instructor)
+    UNION
+    (SELECT user_id, id as assignment_id FROM assignments);
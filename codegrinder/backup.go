@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupOverlap is subtracted from the base backup time when deciding which rows
+// to include in an incremental backup. Transactions that were in flight when the
+// base snapshot was taken may have committed rows with older timestamps, so
+// incremental backups deliberately overlap the previous one. Restoring the same
+// row twice is harmless.
+const backupOverlap = time.Hour
+
+const backupManifestName = "manifest.json"
+
+// backupTable describes one table to be included in backups.
+// Tables are listed in an order that satisfies foreign key constraints on restore.
+type backupTable struct {
+	Name      string
+	Keys      []string // primary key columns
+	Serial    bool     // the id column is backed by a sequence
+	UpdatedAt bool     // has an updated_at column, so incremental backups can skip unchanged rows
+}
+
+var backupTables = []*backupTable{
+	{Name: "problems", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "problem_steps", Keys: []string{"problem_id", "step"}},
+	{Name: "problem_solutions", Keys: []string{"problem_id", "step"}},
+	{Name: "problem_validations", Keys: []string{"problem_id", "image_id"}},
+	{Name: "canary_results", Keys: []string{"problem_id", "host"}},
+	{Name: "problem_costs", Keys: []string{"problem_id", "term"}, UpdatedAt: true},
+	{Name: "problem_variables", Keys: []string{"problem_id", "name"}, UpdatedAt: true},
+	{Name: "problem_toolchain_variants", Keys: []string{"problem_id", "label"}, UpdatedAt: true},
+	{Name: "reflection_prompts", Keys: []string{"problem_id", "name"}, UpdatedAt: true},
+	{Name: "feedback_rules", Keys: []string{"problem_id", "name"}, UpdatedAt: true},
+	{Name: "problem_sets", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "problem_set_problems", Keys: []string{"problem_set_id", "problem_id"}},
+	{Name: "problem_set_imports", Keys: []string{"problem_set_id", "problem_id"}, UpdatedAt: true},
+	{Name: "courses", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "course_problem_sets", Keys: []string{"course_id", "problem_set_id"}},
+	{Name: "prerequisites", Keys: []string{"course_id", "problem_set_id", "required_problem_set_id"}, UpdatedAt: true},
+	{Name: "problem_type_overrides", Keys: []string{"course_id", "problem_type"}, UpdatedAt: true},
+	{Name: "users", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "sections", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "section_members", Keys: []string{"section_id", "user_id"}},
+	{Name: "assignments", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "mastery_streaks", Keys: []string{"assignment_id", "problem_id", "step"}, UpdatedAt: true},
+	{Name: "mastery_attempts", Keys: []string{"assignment_id", "problem_id", "step", "signature"}},
+	{Name: "commits", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "submission_records", Keys: []string{"id"}, Serial: true},
+	{Name: "submission_snapshots", Keys: []string{"record_id"}},
+	{Name: "submissions", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "sealed_exams", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "solution_releases", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "sealed_submissions", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "exemplar_consents", Keys: []string{"assignment_id", "problem_id"}},
+	{Name: "exemplars", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "anonymous_gradings", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "moderations", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "moderation_marks", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "rubrics", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "rubric_grades", Keys: []string{"assignment_id"}, UpdatedAt: true},
+	{Name: "course_quotas", Keys: []string{"course_id"}, UpdatedAt: true},
+	{Name: "course_usage_days", Keys: []string{"course_id", "day"}},
+	{Name: "checkoff_policies", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "checkoffs", Keys: []string{"assignment_id"}},
+	{Name: "lab_sessions", Keys: []string{"id"}, Serial: true},
+	{Name: "lab_redemptions", Keys: []string{"lab_session_id", "assignment_id"}},
+	{Name: "roster_syncs", Keys: []string{"id"}, Serial: true},
+	{Name: "feature_flags", Keys: []string{"name"}, UpdatedAt: true},
+	{Name: "course_feature_flags", Keys: []string{"course_id", "name"}, UpdatedAt: true},
+	{Name: "maintenance_notices", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "github_classrooms", Keys: []string{"course_id", "problem_set_id"}, UpdatedAt: true},
+	{Name: "github_submissions", Keys: []string{"submission_id"}},
+	{Name: "toolchain_images", Keys: []string{"id"}, Serial: true},
+	{Name: "dependency_images", Keys: []string{"id"}, Serial: true},
+	{Name: "course_suspensions", Keys: []string{"course_id", "user_id"}, UpdatedAt: true},
+	{Name: "announcements", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "announcement_acks", Keys: []string{"announcement_id", "user_id"}},
+	{Name: "email_submissions", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "help_requests", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+	{Name: "course_broadcasts", Keys: []string{"course_id"}},
+	{Name: "risk_digests", Keys: []string{"course_id", "user_id"}},
+	{Name: "institutions", Keys: []string{"id"}, Serial: true, UpdatedAt: true},
+}
+
+// BackupManifest describes the contents of a single backup directory.
+type BackupManifest struct {
+	ID        string                 `json:"id"`
+	Base      string                 `json:"base,omitempty"`
+	Since     time.Time              `json:"since,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+	Hostname  string                 `json:"hostname"`
+	Tables    []*BackupTableManifest `json:"tables"`
+	Blobs     []*BackupBlob          `json:"blobs"`
+}
+
+// BackupTableManifest records the rows saved for a single table.
+// Incremental backups also record every primary key present at backup time
+// so that rows deleted since the base backup can be removed on restore.
+type BackupTableManifest struct {
+	Name      string `json:"name"`
+	File      string `json:"file"`
+	Rows      int    `json:"rows"`
+	SHA256    string `json:"sha256"`
+	KeyFile   string `json:"keyFile,omitempty"`
+	KeyCount  int    `json:"keyCount,omitempty"`
+	KeySHA256 string `json:"keySHA256,omitempty"`
+}
+
+// BackupBlob records a single file from the static file directory.
+// Stored is false if the file is unchanged from the base backup and was not copied.
+type BackupBlob struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Stored bool   `json:"stored"`
+}
+
+func init() {
+	commands["backup"] = &serverCommand{Short: "write a consistent backup of the database and static files", Run: CommandBackup}
+	commands["restore"] = &serverCommand{Short: "restore a chain of backups to a fresh instance", Run: CommandRestore}
+	commands["verify"] = &serverCommand{Short: "verify the integrity of a chain of backups", Run: CommandVerify}
+}
+
+// CommandBackup handles "codegrinder backup [-base DIR] DIR".
+func CommandBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	var baseDir, tenantHost string
+	fs.StringVar(&baseDir, "base", "", "Directory of an earlier backup; only changes since then are saved")
+	fs.StringVar(&tenantHost, "tenant", Config().Hostname, "Hostname of the tenant to back up")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: codegrinder backup [-base DIR] [-tenant HOST] DIR")
+	}
+	tenant := findTenant(tenantHost)
+	if tenant == nil {
+		log.Fatalf("no tenant found for host %s", tenantHost)
+	}
+	dir := fs.Arg(0)
+
+	var base *BackupManifest
+	if baseDir != "" {
+		base = mustReadBackupManifest(baseDir)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		log.Fatalf("backup directory %s already exists", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tables"), 0700); err != nil {
+		log.Fatalf("error creating backup directory: %v", err)
+	}
+
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	defer db.Close()
+
+	// everything is read from a single snapshot
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("db error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY`); err != nil {
+		log.Fatalf("db error setting snapshot isolation: %v", err)
+	}
+	if err := setTenantSearchPath(tx, tenant); err != nil {
+		log.Fatalf("db error selecting tenant schema: %v", err)
+	}
+	manifest := &BackupManifest{Hostname: tenant.Hostname}
+	if err := tx.QueryRow(`SELECT now()`).Scan(&manifest.CreatedAt); err != nil {
+		log.Fatalf("db error getting snapshot time: %v", err)
+	}
+	manifest.ID = manifest.CreatedAt.UTC().Format("20060102-150405")
+	if base != nil {
+		manifest.Base = base.ID
+		manifest.Since = base.CreatedAt.Add(-backupOverlap)
+		log.Printf("writing incremental backup %s with changes since %s", manifest.ID, base.ID)
+	} else {
+		log.Printf("writing full backup %s", manifest.ID)
+	}
+
+	for _, table := range backupTables {
+		elt := &BackupTableManifest{Name: table.Name, File: filepath.Join("tables", table.Name+".json")}
+		query := `SELECT row_to_json(t) FROM ` + table.Name + ` AS t`
+		var args []interface{}
+		if base != nil && table.UpdatedAt {
+			query += ` WHERE updated_at > $1`
+			args = append(args, manifest.Since)
+		}
+		elt.Rows, elt.SHA256 = mustDumpQuery(tx, filepath.Join(dir, elt.File), query, args...)
+
+		if base != nil {
+			elt.KeyFile = filepath.Join("tables", table.Name+".keys.json")
+			query := `SELECT json_build_array(` + strings.Join(table.Keys, ", ") + `) FROM ` + table.Name
+			elt.KeyCount, elt.KeySHA256 = mustDumpQuery(tx, filepath.Join(dir, elt.KeyFile), query)
+		}
+		log.Printf("  %s: %d row%s", table.Name, elt.Rows, plural(elt.Rows))
+		manifest.Tables = append(manifest.Tables, elt)
+	}
+
+	// copy static files that are new or changed
+	baseBlobs := make(map[string]string)
+	if base != nil {
+		for _, blob := range base.Blobs {
+			baseBlobs[blob.Path] = blob.SHA256
+		}
+	}
+	if Config().StaticDir != "" {
+		err := filepath.Walk(Config().StaticDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(Config().StaticDir, path)
+			if err != nil {
+				return err
+			}
+			sum, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			blob := &BackupBlob{Path: filepath.ToSlash(rel), Size: info.Size(), SHA256: sum}
+			if baseBlobs[blob.Path] != sum {
+				blob.Stored = true
+				if err := copyFile(path, filepath.Join(dir, "blobs", rel)); err != nil {
+					return err
+				}
+			}
+			manifest.Blobs = append(manifest.Blobs, blob)
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("error backing up static files from %s: %v", Config().StaticDir, err)
+		}
+	}
+
+	raw, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		log.Fatalf("JSON error encoding backup manifest: %v", err)
+	}
+	raw = append(raw, '\n')
+	if err := ioutil.WriteFile(filepath.Join(dir, backupManifestName), raw, 0600); err != nil {
+		log.Fatalf("error writing backup manifest: %v", err)
+	}
+
+	// make sure what we wrote can be read back
+	if err := verifyBackup(dir, manifest); err != nil {
+		log.Fatalf("backup failed verification: %v", err)
+	}
+	log.Printf("backup %s written to %s and verified", manifest.ID, dir)
+}
+
+// CommandVerify handles "codegrinder verify DIR [DIR...]".
+// The directories must form a chain: a full backup followed by incremental backups, each based on the previous one.
+func CommandVerify(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: codegrinder verify FULLDIR [INCREMENTALDIR...]")
+	}
+	mustVerifyBackupChain(args)
+	log.Printf("%d backup%s verified", len(args), plural(len(args)))
+}
+
+// CommandRestore handles "codegrinder restore [-tenant HOST] DIR [DIR...]".
+// The first directory must be a full backup, followed by any incremental backups in order.
+// The target database must have the schema loaded, but no data.
+func CommandRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	var tenantHost string
+	fs.StringVar(&tenantHost, "tenant", Config().Hostname, "Hostname of the tenant to restore into")
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) == 0 {
+		log.Fatalf("usage: codegrinder restore [-tenant HOST] FULLDIR [INCREMENTALDIR...]")
+	}
+	tenant := findTenant(tenantHost)
+	if tenant == nil {
+		log.Fatalf("no tenant found for host %s", tenantHost)
+	}
+	manifests := mustVerifyBackupChain(args)
+
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatalf("db error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := setTenantSearchPath(tx, tenant); err != nil {
+		log.Fatalf("db error selecting tenant schema: %v", err)
+	}
+
+	// only restore into a fresh instance
+	for _, table := range backupTables {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM ` + table.Name).Scan(&count); err != nil {
+			log.Fatalf("db error checking table %s: %v (has the schema been loaded?)", table.Name, err)
+		}
+		if count > 0 {
+			log.Fatalf("table %s already has %d row%s; restore only works on a fresh instance", table.Name, count, plural(count))
+		}
+	}
+
+	for i, manifest := range manifests {
+		dir := args[i]
+		log.Printf("restoring backup %s from %s", manifest.ID, dir)
+		files := make(map[string]*BackupTableManifest)
+		for _, elt := range manifest.Tables {
+			files[elt.Name] = elt
+		}
+
+		for _, table := range backupTables {
+			elt := files[table.Name]
+			if elt == nil {
+				// table was added to the schema after this backup was taken
+				continue
+			}
+			n := mustRestoreTable(tx, table, filepath.Join(dir, elt.File))
+			log.Printf("  %s: %d row%s", table.Name, n, plural(n))
+		}
+
+		// remove rows that were deleted since the previous backup
+		for j := len(backupTables) - 1; j >= 0; j-- {
+			table := backupTables[j]
+			elt := files[table.Name]
+			if elt == nil || elt.KeyFile == "" {
+				continue
+			}
+			keys, err := ioutil.ReadFile(filepath.Join(dir, elt.KeyFile))
+			if err != nil {
+				log.Fatalf("error reading %s: %v", elt.KeyFile, err)
+			}
+			list := "[" + strings.Join(strings.Split(strings.TrimSpace(string(keys)), "\n"), ",") + "]"
+			res, err := tx.Exec(`DELETE FROM `+table.Name+` WHERE NOT ($1::jsonb @> json_build_array(json_build_array(`+strings.Join(table.Keys, ", ")+`))::jsonb)`, list)
+			if err != nil {
+				log.Fatalf("db error removing deleted rows from %s: %v", table.Name, err)
+			}
+			if n, err := res.RowsAffected(); err == nil && n > 0 {
+				log.Printf("  %s: %d deleted row%s removed", table.Name, n, plural(int(n)))
+			}
+		}
+
+		// restore static files
+		for _, blob := range manifest.Blobs {
+			if !blob.Stored {
+				continue
+			}
+			if Config().StaticDir == "" {
+				log.Fatalf("backup contains static files but no StaticDir is configured")
+			}
+			if err := copyFile(filepath.Join(dir, "blobs", filepath.FromSlash(blob.Path)), filepath.Join(Config().StaticDir, filepath.FromSlash(blob.Path))); err != nil {
+				log.Fatalf("error restoring static file %s: %v", blob.Path, err)
+			}
+		}
+	}
+
+	// bring sequences up to date
+	for _, table := range backupTables {
+		if !table.Serial {
+			continue
+		}
+		if _, err := tx.Exec(`SELECT setval(pg_get_serial_sequence($1, 'id'), COALESCE(MAX(id), 0) + 1, false) FROM `+table.Name, table.Name); err != nil {
+			log.Fatalf("db error resetting sequence for %s: %v", table.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatalf("db error committing restore: %v", err)
+	}
+	log.Printf("restore complete")
+}
+
+func mustReadBackupManifest(dir string) *BackupManifest {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		log.Fatalf("error reading backup manifest: %v", err)
+	}
+	manifest := new(BackupManifest)
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		log.Fatalf("error parsing backup manifest in %s: %v", dir, err)
+	}
+	return manifest
+}
+
+func mustVerifyBackupChain(dirs []string) []*BackupManifest {
+	var manifests []*BackupManifest
+	for i, dir := range dirs {
+		manifest := mustReadBackupManifest(dir)
+		if i == 0 && manifest.Base != "" {
+			log.Fatalf("%s is an incremental backup based on %s; the chain must start with a full backup", dir, manifest.Base)
+		}
+		if i > 0 && manifest.Base != manifests[i-1].ID {
+			log.Fatalf("%s is based on backup %q, but the previous backup in the chain is %s", dir, manifest.Base, manifests[i-1].ID)
+		}
+		if err := verifyBackup(dir, manifest); err != nil {
+			log.Fatalf("backup in %s failed verification: %v", dir, err)
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests
+}
+
+// verifyBackup checks every file named in the manifest against its recorded checksum and row count.
+func verifyBackup(dir string, manifest *BackupManifest) error {
+	for _, elt := range manifest.Tables {
+		if err := verifyBackupFile(filepath.Join(dir, elt.File), elt.Rows, elt.SHA256); err != nil {
+			return err
+		}
+		if elt.KeyFile != "" {
+			if err := verifyBackupFile(filepath.Join(dir, elt.KeyFile), elt.KeyCount, elt.KeySHA256); err != nil {
+				return err
+			}
+		}
+	}
+	for _, blob := range manifest.Blobs {
+		if !blob.Stored {
+			continue
+		}
+		path := filepath.Join(dir, "blobs", filepath.FromSlash(blob.Path))
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if sum != blob.SHA256 {
+			return fmt.Errorf("checksum mismatch for %s", path)
+		}
+	}
+	return nil
+}
+
+func verifyBackupFile(path string, lines int, sum string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	hash := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(fp, hash))
+	scanner.Buffer(nil, 1<<30)
+	count := 0
+	for scanner.Scan() {
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if count != lines {
+		return fmt.Errorf("%s has %d lines, but the manifest says %d", path, count, lines)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != sum {
+		return fmt.Errorf("checksum mismatch for %s", path)
+	}
+	return nil
+}
+
+// mustDumpQuery writes the single JSON column returned by a query to a file, one row per line.
+// It returns the number of rows written and the SHA-256 checksum of the file.
+func mustDumpQuery(tx *sql.Tx, path, query string, args ...interface{}) (int, string) {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("error creating %s: %v", path, err)
+	}
+	defer fp.Close()
+	hash := sha256.New()
+	out := bufio.NewWriter(io.MultiWriter(fp, hash))
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		log.Fatalf("db error dumping to %s: %v", path, err)
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			log.Fatalf("db error dumping to %s: %v", path, err)
+		}
+		out.Write(raw)
+		out.WriteByte('\n')
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("db error dumping to %s: %v", path, err)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatalf("error writing %s: %v", path, err)
+	}
+	return count, hex.EncodeToString(hash.Sum(nil))
+}
+
+// mustRestoreTable loads rows from a dump file, updating rows that already exist
+// (from an earlier backup in the chain) and inserting the rest.
+// Rows are updated in place rather than deleted and reinserted so that
+// cascading deletes do not remove dependent rows.
+func mustRestoreTable(tx *sql.Tx, table *backupTable, path string) int {
+	var columns []string
+	rows, err := tx.Query(`SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table.Name)
+	if err != nil {
+		log.Fatalf("db error getting columns for %s: %v", table.Name, err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			log.Fatalf("db error getting columns for %s: %v", table.Name, err)
+		}
+		columns = append(columns, name)
+	}
+	rows.Close()
+
+	var sets, matches []string
+	for _, col := range columns {
+		sets = append(sets, "r."+col)
+	}
+	for _, key := range table.Keys {
+		matches = append(matches, fmt.Sprintf("t.%s = r.%s", key, key))
+	}
+	update := fmt.Sprintf(`UPDATE %s AS t SET (%s) = (%s) FROM json_populate_record(NULL::%s, $1) AS r WHERE %s`,
+		table.Name, strings.Join(columns, ", "), strings.Join(sets, ", "), table.Name, strings.Join(matches, " AND "))
+	insert := fmt.Sprintf(`INSERT INTO %s SELECT * FROM json_populate_record(NULL::%s, $1)`, table.Name, table.Name)
+
+	fp, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("error opening %s: %v", path, err)
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	scanner.Buffer(nil, 1<<30)
+	count := 0
+	for scanner.Scan() {
+		row := scanner.Text()
+		res, err := tx.Exec(update, row)
+		if err != nil {
+			log.Fatalf("db error restoring row into %s: %v", table.Name, err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if _, err := tx.Exec(insert, row); err != nil {
+				log.Fatalf("db error restoring row into %s: %v", table.Name, err)
+			}
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("error reading %s: %v", path, err)
+	}
+	return count
+}
+
+func fileSHA256(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, fp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
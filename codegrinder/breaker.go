@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	breakerThreshold = 3                // consecutive failures to reach a daycare before the TA stops sending it work
+	breakerCooldown  = 30 * time.Second // how long the TA waits before trying a daycare that stopped answering
+)
+
+// daycareUnavailableError reports that a daycare could not be reached, as opposed to
+// a daycare that answered with an error. Work that fails this way should be queued
+// and tried again rather than failed.
+type daycareUnavailableError struct {
+	Host string
+	Err  error
+}
+
+func (e *daycareUnavailableError) Error() string {
+	return fmt.Sprintf("daycare %s is unavailable: %v", e.Host, e.Err)
+}
+
+// circuitBreaker tracks whether a daycare is answering. After breakerThreshold
+// failures in a row the breaker opens and requests fail immediately, until
+// breakerCooldown passes and a single request is let through to try again.
+type circuitBreaker struct {
+	failures int
+	openedAt time.Time
+	trialAt  time.Time
+	lastErr  error
+}
+
+var daycareBreakers = struct {
+	sync.Mutex
+	hosts map[string]*circuitBreaker
+}{hosts: make(map[string]*circuitBreaker)}
+
+func getBreaker(host string) *circuitBreaker {
+	b := daycareBreakers.hosts[host]
+	if b == nil {
+		b = new(circuitBreaker)
+		daycareBreakers.hosts[host] = b
+	}
+	return b
+}
+
+// daycareAvailable reports whether a request to the daycare would be let through.
+func daycareAvailable(host string) bool {
+	daycareBreakers.Lock()
+	defer daycareBreakers.Unlock()
+	b := getBreaker(host)
+	return b.failures < breakerThreshold || time.Since(b.trialAt) >= breakerCooldown
+}
+
+// daycareAllow decides whether to send a request to the daycare.
+// While the breaker is open, only one request is let through each cooldown period.
+func daycareAllow(host string) error {
+	daycareBreakers.Lock()
+	defer daycareBreakers.Unlock()
+	b := getBreaker(host)
+	if b.failures < breakerThreshold {
+		return nil
+	}
+	if time.Since(b.trialAt) < breakerCooldown {
+		return &daycareUnavailableError{Host: host, Err: fmt.Errorf("not answering since %s (%v)", b.openedAt.Format("15:04:05"), b.lastErr)}
+	}
+	b.trialAt = time.Now()
+	return nil
+}
+
+// daycareSucceeded records that the daycare answered, closing its breaker.
+func daycareSucceeded(host string) {
+	daycareBreakers.Lock()
+	defer daycareBreakers.Unlock()
+	b := getBreaker(host)
+	if b.failures >= breakerThreshold {
+		log.Printf("daycare %s is answering again after %v; grading resumes", host, time.Since(b.openedAt).Round(time.Second))
+	}
+	b.failures = 0
+	b.lastErr = nil
+}
+
+// daycareFailed records that the daycare could not be reached,
+// opening its breaker after too many failures in a row.
+func daycareFailed(host string, err error) {
+	daycareBreakers.Lock()
+	defer daycareBreakers.Unlock()
+	b := getBreaker(host)
+	b.failures++
+	b.lastErr = err
+	if b.failures == breakerThreshold {
+		b.openedAt = time.Now()
+		b.trialAt = b.openedAt
+		log.Printf("daycare %s failed %d times in a row, pausing grading: %v", host, b.failures, err)
+	} else if b.failures > breakerThreshold {
+		b.trialAt = time.Now()
+	}
+}
+
+// probeDaycareBreaker reports whether grading is paused because the daycare is not answering.
+// The TA keeps accepting work while grading is paused, so this never fails the readiness check.
+func probeDaycareBreaker() (string, error) {
+	host := Config().DaycareHost
+	daycareBreakers.Lock()
+	defer daycareBreakers.Unlock()
+	b := getBreaker(host)
+	if b.failures >= breakerThreshold {
+		return fmt.Sprintf("daycare %s not answering since %s, submissions are queued: %v",
+			host, b.openedAt.Format("2006-01-02 15:04:05"), b.lastErr), nil
+	}
+	return fmt.Sprintf("daycare %s is answering", host), nil
+}
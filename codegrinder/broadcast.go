@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// broadcastPollSeconds is how often the broadcast page checks for a new session to show.
+const broadcastPollSeconds = 5
+
+// getCourseBroadcast returns the course's current broadcast,
+// or sql.ErrNoRows if there is none or it has expired.
+func getCourseBroadcast(tx *sql.Tx, courseID int64) (*Broadcast, error) {
+	broadcast := new(Broadcast)
+	if err := meddler.QueryRow(tx, broadcast, `SELECT * FROM course_broadcasts WHERE course_id = $1 AND expires_at > $2`,
+		courseID, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow(`SELECT name FROM users WHERE id = $1`, broadcast.UserID).Scan(&broadcast.Instructor); err != nil {
+		return nil, err
+	}
+	return broadcast, nil
+}
+
+// GetCourseBroadcast handles /v2/courses/:course_id/broadcast requests,
+// returning the session the instructor is showing the course right now.
+func GetCourseBroadcast(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	broadcast, err := getCourseBroadcast(tx, courseID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, broadcast)
+}
+
+// PutCourseBroadcast handles /v2/courses/:course_id/broadcast requests,
+// showing a session the current user is sharing as a broadcast to everyone in the course.
+// It replaces any broadcast already going. The broadcast is returned.
+func PutCourseBroadcast(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, broadcast Broadcast, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if broadcast.ShareID == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "broadcast must include the share ID of the session")
+		return
+	}
+
+	now := time.Now()
+	broadcast.CourseID = courseID
+	broadcast.UserID = currentUser.ID
+	broadcast.StartedAt = now
+	broadcast.ExpiresAt = now.Add(MaxShareAge)
+	if _, err := tx.Exec(`DELETE FROM course_broadcasts WHERE course_id = $1`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := meddler.Insert(tx, "course_broadcasts", &broadcast); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	broadcast.Instructor = currentUser.Name
+	render.JSON(http.StatusOK, &broadcast)
+}
+
+// DeleteCourseBroadcast handles /v2/courses/:course_id/broadcast requests,
+// ending the course's broadcast so students stop looking for it.
+func DeleteCourseBroadcast(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM course_broadcasts WHERE course_id = $1`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetBroadcastPage handles /broadcast/:course_id requests,
+// returning a page that shows each session the instructor broadcasts to the course as it runs.
+// Like the workspace, it loads xterm from this server.
+func GetBroadcastPage(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if Config().WorkspaceLibDir == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the browser workspace is not set up on this server")
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	nonce, err := setPagePolicy(w)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating nonce: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := broadcastTemplate.Execute(w, map[string]interface{}{
+		"Course":       course,
+		"DaycareHost":  Config().DaycareHost,
+		"PollInterval": broadcastPollSeconds * 1000,
+		"Lib":          workspaceLibPrefix,
+		"Nonce":        nonce,
+	}); err != nil {
+		loggedErrorf("error rendering broadcast page: %v", err)
+	}
+}
+
+var broadcastTemplate = template.Must(template.New("broadcast").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Course.Name}} live</title>
+<link rel="stylesheet" href="{{.Lib}}/xterm/css/xterm.css">
+<style>
+html, body { margin: 0; height: 100%; font-family: sans-serif; font-size: 14px; }
+body { display: flex; flex-direction: column; }
+header { padding: 6px 10px; background: #2d2d2d; color: #eee; font-weight: bold; }
+#status { padding: 4px 10px; background: #f0f0f0; border-bottom: 1px solid #ccc; min-height: 1.2em; }
+#terminal { flex: 1; min-height: 0; background: #000; padding: 4px; }
+</style>
+</head>
+<body>
+<header>{{.Course.Name}} live</header>
+<div id="status">Waiting for your instructor to start…</div>
+<div id="terminal"></div>
+<script src="{{.Lib}}/xterm/lib/xterm.js"></script>
+<script nonce="{{.Nonce}}">
+(function() {
+	'use strict';
+
+	var courseID = {{.Course.ID}};
+	var daycareHost = {{.DaycareHost}};
+	var pollInterval = {{.PollInterval}};
+
+	var term = new Terminal({ convertEol: true, fontSize: 13, disableStdin: true });
+	term.open(document.getElementById('terminal'));
+	var shareID = '';
+	var socket = null;
+
+	function setStatus(msg) {
+		document.getElementById('status').textContent = msg;
+	}
+
+	// watch connects to a broadcast session; nothing typed here is sent
+	function watch(broadcast) {
+		if (socket) {
+			socket.close();
+		}
+		shareID = broadcast.shareID;
+		term.reset();
+		var what = broadcast.problem ? broadcast.action + ' on ' + broadcast.problem : 'a session';
+		setStatus(broadcast.instructor + ' is showing ' + what);
+		var s = new WebSocket('wss://' + daycareHost + '/v2/shares/' + encodeURIComponent(shareID));
+		socket = s;
+		s.onmessage = function(msg) {
+			var reply = JSON.parse(msg.data);
+			if (reply.error) {
+				setStatus(reply.error);
+			} else if (reply.event) {
+				var e = reply.event;
+				switch (e.event) {
+				case 'exec':
+					term.write('\x1b[36m$ ' + e.execcommand.join(' ') + '\x1b[0m\n');
+					break;
+				case 'stdin':
+					term.write('\x1b[33m' + e.streamdata + '\x1b[0m');
+					break;
+				case 'stdout':
+					term.write(e.streamdata);
+					break;
+				case 'stderr':
+					term.write('\x1b[31m' + e.streamdata + '\x1b[0m');
+					break;
+				case 'exit':
+					term.write('\x1b[36m' + e.exitstatus + '\x1b[0m\n');
+					break;
+				case 'error':
+					term.write('\x1b[31mError: ' + e.error + '\x1b[0m\n');
+					break;
+				}
+			}
+		};
+		s.onclose = function() {
+			if (socket === s) {
+				socket = null;
+				setStatus('Finished; waiting for the next one…');
+			}
+		};
+	}
+
+	function poll() {
+		fetch('/v2/courses/' + courseID + '/broadcast', { credentials: 'same-origin' }).then(function(res) {
+			return res.ok ? res.json() : null;
+		}).then(function(broadcast) {
+			if (broadcast && broadcast.shareID !== shareID) {
+				watch(broadcast);
+			}
+		}).catch(function() {
+			// try again next time
+		}).then(function() {
+			setTimeout(poll, pollInterval);
+		});
+	}
+	poll();
+})();
+</script>
+</body>
+</html>
+`))
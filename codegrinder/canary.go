@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// alertTimeout is how long to wait for the alert webhook to answer.
+const alertTimeout = 30 * time.Second
+
+// canaryStatus summarizes the most recent canary run for the health check.
+var canaryStatus = struct {
+	sync.Mutex
+	ranAt   time.Time
+	checked int
+	failing []string
+}{}
+
+// activeProblemsQuery finds the problems with reference solutions that are
+// part of a course that has not been archived.
+const activeProblemsQuery = `SELECT * FROM problems ` +
+	`WHERE EXISTS (SELECT 1 FROM problem_solutions WHERE problem_id = problems.id) ` +
+	`AND EXISTS (SELECT 1 FROM problem_set_problems ` +
+	`JOIN course_problem_sets ON course_problem_sets.problem_set_id = problem_set_problems.problem_set_id ` +
+	`JOIN courses ON courses.id = course_problem_sets.course_id ` +
+	`WHERE problem_set_problems.problem_id = problems.id AND NOT courses.archived) ` +
+	`ORDER BY id`
+
+// runCanary regrades the reference solutions of every active problem on every
+// canary daycare host. Problems that stop passing are reported to the alert
+// webhook, so authors and administrators hear about a changed environment
+// before students run into it.
+func runCanary(db *sql.DB) error {
+	now := time.Now()
+	var failing []string
+
+	// find out what each daycare is running
+	toolchains := make(map[string]map[string]*Toolchain)
+	for _, host := range Config().CanaryHosts {
+		current, err := getDaycareToolchains(host)
+		if err != nil {
+			log.Printf("canary: unable to reach daycare %s: %v", host, err)
+			failing = append(failing, fmt.Sprintf("%s: %v", host, err))
+			continue
+		}
+		toolchains[host] = current
+	}
+
+	// gather the active problems for every host that runs their problem type
+	var jobs []*toolchainJob
+	err := forEachTenant(db, func(tx *sql.Tx, tenant *TenantConfig) error {
+		problems := []*Problem{}
+		if err := meddler.QueryAll(tx, &problems, activeProblemsQuery); err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			for _, host := range Config().CanaryHosts {
+				toolchain := toolchains[host][problem.ProblemType]
+				if toolchain == nil {
+					continue
+				}
+				job, err := newToolchainJob(tx, tenant, problem, toolchain)
+				if err != nil {
+					return err
+				}
+				job.Host = host
+				jobs = append(jobs, job)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// regrade outside of any transaction, then record the results
+	var alerts []*CanaryResult
+	for _, job := range jobs {
+		validation := validateProblem(job)
+		result := &CanaryResult{
+			ProblemID: job.Problem.ID,
+			Unique:    job.Problem.Unique,
+			Host:      job.Host,
+			Image:     validation.Image,
+			ImageID:   validation.ImageID,
+			Version:   validation.Version,
+			Passed:    validation.Passed,
+			Note:      validation.Note,
+			RanAt:     validation.ValidatedAt,
+		}
+		var newlyFailing bool
+		err := withTenantTx(db, job.Tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+			var err error
+			newlyFailing, err = saveCanaryResult(tx, result)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !result.Passed {
+			log.Printf("canary: problem %s (%d) fails on %s: %s", job.Problem.Unique, job.Problem.ID, job.Host, result.Note)
+			failing = append(failing, fmt.Sprintf("%s on %s", job.Problem.Unique, job.Host))
+		}
+		if newlyFailing {
+			alerts = append(alerts, result)
+		}
+	}
+	log.Printf("canary: regraded %d problem%s, %d failing", len(jobs), plural(len(jobs)), len(failing))
+
+	canaryStatus.Lock()
+	canaryStatus.ranAt = now
+	canaryStatus.checked = len(jobs)
+	canaryStatus.failing = failing
+	canaryStatus.Unlock()
+
+	if len(alerts) > 0 {
+		if err := sendCanaryAlert(alerts); err != nil {
+			return fmt.Errorf("error sending alert: %v", err)
+		}
+	}
+	return nil
+}
+
+// saveCanaryResult records the latest canary result for a problem on a host,
+// reporting whether the problem has just stopped passing there.
+func saveCanaryResult(tx *sql.Tx, result *CanaryResult) (bool, error) {
+	old := new(CanaryResult)
+	err := meddler.QueryRow(tx, old, `SELECT * FROM canary_results WHERE problem_id = $1 AND host = $2`, result.ProblemID, result.Host)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	found := err == nil
+
+	newlyFailing := false
+	if !result.Passed {
+		if found && !old.Passed {
+			result.FailingSince = old.FailingSince
+		} else {
+			result.FailingSince = result.RanAt
+			newlyFailing = true
+		}
+	}
+	if found {
+		if _, err := tx.Exec(`DELETE FROM canary_results WHERE problem_id = $1 AND host = $2`, result.ProblemID, result.Host); err != nil {
+			return false, err
+		}
+	}
+	return newlyFailing, meddler.Insert(tx, "canary_results", result)
+}
+
+// sendCanaryAlert posts the problems that have just stopped passing to the alert webhook.
+// The message has a text field so that chat services can show it as is.
+func sendCanaryAlert(results []*CanaryResult) error {
+	var lines []string
+	for _, elt := range results {
+		lines = append(lines, fmt.Sprintf("%s on %s: %s", elt.Unique, elt.Host, elt.Note))
+	}
+	sort.Strings(lines)
+	text := fmt.Sprintf("CodeGrinder: reference solutions for %d problem%s stopped passing in the nightly regrade:\n%s",
+		len(results), plural(len(results)), strings.Join(lines, "\n"))
+	log.Print(text)
+	if Config().AlertWebhook == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{"text": text, "failures": results})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: alertTimeout}
+	resp, err := client.Post(Config().AlertWebhook, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// probeCanary reports failures found by the most recent canary run.
+func probeCanary() (string, error) {
+	canaryStatus.Lock()
+	defer canaryStatus.Unlock()
+	if canaryStatus.ranAt.IsZero() {
+		return "has not run since the server started", nil
+	}
+	if len(canaryStatus.failing) > 0 {
+		return "", fmt.Errorf("%d failing at %s: %s", len(canaryStatus.failing),
+			canaryStatus.ranAt.Format("2006-01-02 15:04"), strings.Join(canaryStatus.failing, ", "))
+	}
+	return fmt.Sprintf("%d regrade%s passed at %s", canaryStatus.checked, plural(canaryStatus.checked),
+		canaryStatus.ranAt.Format("2006-01-02 15:04")), nil
+}
+
+// GetCanaryResults handles /v2/canary_results requests,
+// returning the latest nightly regrade of each problem on each daycare host.
+//
+// If parameter failing=true is present, only problems whose reference solutions
+// failed are included.
+func GetCanaryResults(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	where := ""
+	if r.FormValue("failing") == "true" {
+		where = ` WHERE NOT passed`
+	}
+	results := []*CanaryResult{}
+	if err := meddler.QueryAll(tx, &results, `SELECT * FROM canary_results`+where+` ORDER BY problem_id, host`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	uniques, err := getProblemUniques(tx, "canary_results")
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, elt := range results {
+		elt.Unique = uniques[elt.ProblemID]
+	}
+	render.JSON(http.StatusOK, results)
+}
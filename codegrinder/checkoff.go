@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getCheckoffPolicy returns the check-off policy for a problem set in a course,
+// or nil if no check-off is required.
+func getCheckoffPolicy(tx *sql.Tx, courseID, problemSetID int64) (*CheckoffPolicy, error) {
+	policy := new(CheckoffPolicy)
+	err := meddler.QueryRow(tx, policy, `SELECT * FROM checkoff_policies WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// getCheckoff returns a student's check-off, or nil if they have not been checked off.
+func getCheckoff(tx *sql.Tx, assignmentID int64) (*Checkoff, error) {
+	checkoff := new(Checkoff)
+	err := meddler.QueryRow(tx, checkoff, `SELECT * FROM checkoffs WHERE assignment_id = $1`, assignmentID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checkoff, nil
+}
+
+// PutCourseProblemSetCheckoffPolicy handles /v2/courses/:course_id/problem_sets/:problem_set_id/checkoff_policy requests,
+// requiring students to demonstrate the problem set in person. The check-off counts for the
+// given weight of the grade, and every student's score is updated. The policy is returned.
+func PutCourseProblemSetCheckoffPolicy(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, policy CheckoffPolicy, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+	if err := policy.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	old, err := getCheckoffPolicy(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if old != nil {
+		if _, err := tx.Exec(`DELETE FROM checkoff_policies WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		policy.CreatedAt = old.CreatedAt
+	} else {
+		policy.CreatedAt = now
+	}
+	policy.CourseID = courseID
+	policy.ProblemSetID = problemSetID
+	policy.UpdatedAt = now
+	if err := meddler.Insert(tx, "checkoff_policies", &policy); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := rescoreProblemSet(tx, tenant, courseID, problemSetID, now); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	log.Printf("problem set %d in course %d now requires a check-off worth %.0f%%", problemSetID, courseID, policy.Weight*100.0)
+	render.JSON(http.StatusOK, &policy)
+}
+
+// DeleteCourseProblemSetCheckoffPolicy handles /v2/courses/:course_id/problem_sets/:problem_set_id/checkoff_policy requests,
+// no longer requiring a check-off for the problem set. Check-offs already recorded are kept
+// in case the requirement is restored.
+func DeleteCourseProblemSetCheckoffPolicy(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM checkoff_policies WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := rescoreProblemSet(tx, tenant, courseID, problemSetID, time.Now()); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCourseProblemSetCheckoffs handles /v2/courses/:course_id/problem_sets/:problem_set_id/checkoffs requests,
+// returning every check-off recorded for the problem set, oldest first. Students are named
+// by pseudonym, without assignment IDs, while grading is anonymous.
+func GetCourseProblemSetCheckoffs(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	checkoffs := []*Checkoff{}
+	if err := meddler.QueryAll(tx, &checkoffs, `SELECT checkoffs.* FROM checkoffs JOIN assignments ON checkoffs.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 ORDER BY checkoffs.checked_off_at, checkoffs.assignment_id`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	names := make(map[int64]string)
+	name := func(userID int64) (string, error) {
+		if _, exists := names[userID]; !exists {
+			user := new(User)
+			if err := meddler.Load(tx, "users", user, userID); err != nil {
+				return "", err
+			}
+			names[userID] = user.Name
+		}
+		return names[userID], nil
+	}
+	for _, checkoff := range checkoffs {
+		asst := new(Assignment)
+		if err := meddler.Load(tx, "assignments", asst, checkoff.AssignmentID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if anon != nil && anon.IsAnonymous() {
+			checkoff.Student = anon.Pseudonym(asst.UserID)
+			checkoff.AssignmentID = 0
+		} else if checkoff.Student, err = name(asst.UserID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if checkoff.GraderID != 0 {
+			if checkoff.Grader, err = name(checkoff.GraderID); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+		}
+	}
+
+	render.JSON(http.StatusOK, checkoffs)
+}
+
+// PutCourseProblemSetCheckoff handles /v2/courses/:course_id/problem_sets/:problem_set_id/checkoffs/:user_id requests,
+// recording that a student demonstrated the problem set in person. The current user is
+// recorded as the grader. If the problem set requires a check-off, the student's score is
+// updated and posted to the LMS. The student is named by pseudonym in place of user ID while
+// grading is anonymous. The check-off is returned.
+func PutCourseProblemSetCheckoff(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, currentUser *User, checkoff Checkoff, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	asst, anon, err := getStudentAssignment(tx, courseID, problemSetID, params["user_id"])
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM checkoffs WHERE assignment_id = $1`, asst.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	checkoff.AssignmentID = asst.ID
+	checkoff.Student = ""
+	checkoff.GraderID = currentUser.ID
+	checkoff.Grader = ""
+	checkoff.CheckedOffAt = now
+	if err := meddler.Insert(tx, "checkoffs", &checkoff); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := rescoreAssignment(tx, tenant, asst, now); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	log.Printf("assignment %d checked off by user %d (%s)", asst.ID, currentUser.ID, currentUser.Name)
+	checkoff.Grader = currentUser.Name
+	if anon != nil {
+		checkoff.AssignmentID = 0
+		checkoff.Student = anon.Pseudonym(asst.UserID)
+	}
+	render.JSON(http.StatusOK, &checkoff)
+}
+
+// DeleteCourseProblemSetCheckoff handles /v2/courses/:course_id/problem_sets/:problem_set_id/checkoffs/:user_id requests,
+// undoing a student's check-off. If the problem set requires a check-off, the student's score
+// is updated and posted to the LMS. The student is named by pseudonym in place of user ID while
+// grading is anonymous.
+func DeleteCourseProblemSetCheckoff(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, currentUser *User) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	asst, _, err := getStudentAssignment(tx, courseID, problemSetID, params["user_id"])
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM checkoffs WHERE assignment_id = $1`, asst.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	} else if count == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "assignment %d has not been checked off", asst.ID)
+		return
+	}
+	if err := rescoreAssignment(tx, tenant, asst, time.Now()); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	log.Printf("check-off for assignment %d undone by user %d (%s)", asst.ID, currentUser.ID, currentUser.Name)
+	w.WriteHeader(http.StatusOK)
+}
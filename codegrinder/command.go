@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sort"
+)
+
+// serverCommand is a maintenance command that is run from the command line
+// instead of starting the server, e.g., "codegrinder backup /var/backups/codegrinder".
+// Commands register themselves in init functions.
+type serverCommand struct {
+	Short    string
+	Run      func(args []string)
+	NoConfig bool // the command runs without loading the config file, which it may create
+}
+
+var commands = make(map[string]*serverCommand)
+
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandNeedsConfig reports whether the command named on the command line needs
+// the config file loaded first. The server itself always does.
+func commandNeedsConfig(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	cmd, exists := commands[args[0]]
+	return !exists || !cmd.NoConfig
+}
+
+func runCommand(args []string) {
+	cmd, exists := commands[args[0]]
+	if !exists {
+		flag.Usage()
+		log.Fatalf("unknown command %q", args[0])
+	}
+	cmd.Run(args[1:])
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/russross/codegrinder/sdk"
+	. "github.com/russross/codegrinder/types"
+)
+
+// defaultConformanceTimeout is the time limit for an action when a problem type sets no MaxClock.
+const defaultConformanceTimeout = time.Minute
+
+func init() {
+	commands["conform"] = &serverCommand{Short: "check that a scripted problem type follows the daycare conventions", Run: CommandConform}
+}
+
+// conformance collects the outcome of each check.
+type conformance struct {
+	failures int
+}
+
+func (c *conformance) check(ok bool, name string, format string, args ...interface{}) bool {
+	if ok {
+		fmt.Printf("ok    %s\n", name)
+	} else {
+		fmt.Printf("FAIL  %s: %s\n", name, fmt.Sprintf(format, args...))
+		c.failures++
+	}
+	return ok
+}
+
+// CommandConform handles "codegrinder conform DEFINITION SOLUTION [BROKEN]".
+// It loads a problem type definition, grades a directory holding a correct solution
+// and optionally one holding a broken solution, and checks that the problem type
+// behaves as the daycare expects.
+func CommandConform(args []string) {
+	fs := flag.NewFlagSet("conform", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		log.Fatalf("usage: codegrinder conform DEFINITION.json SOLUTION_DIR [BROKEN_DIR]")
+	}
+	c := new(conformance)
+
+	// the definition must be complete
+	raw, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	def := new(problemTypeDefinition)
+	if !c.check(json.Unmarshal(raw, def) == nil, "definition parses", "%s is not valid JSON", fs.Arg(0)) {
+		os.Exit(1)
+	}
+	problemType, err := def.compile()
+	if !c.check(err == nil, "definition is complete", "%v", err) {
+		os.Exit(1)
+	}
+	_, hasSave := problemType.Actions[""]
+	c.check(hasSave, "save action", "clients expect an action named \"\" to offer a save button")
+	for name, action := range problemType.Actions {
+		if name != "" && name != "confirm" && action.Button == "" {
+			c.check(false, "action "+name, "has no button label for clients")
+		}
+	}
+
+	// conformance runs always use the local container engine
+	if Config().GradingBackend == backendKubernetes {
+		config := *Config()
+		config.GradingBackend = backendDocker
+		setConfig(&config)
+	}
+	mustConnectDocker()
+	_, err = dockerClient.InspectImage(problemType.Image)
+	if !c.check(err == nil, "image", "%s is not available to the daycare: %v", problemType.Image, err) {
+		os.Exit(1)
+	}
+
+	limit := defaultConformanceTimeout
+	if problemType.MaxClock > 0 {
+		limit = time.Duration(problemType.MaxClock) * time.Second
+	}
+
+	// a correct solution must pass both grade and confirm
+	solution := mustReadConformanceFiles(fs.Arg(1))
+	for _, action := range []string{"grade", "confirm"} {
+		card, events, elapsed := runConformanceAction(problemType, action, solution, solution)
+		c.checkRun(action+" solution", card, events, elapsed, limit)
+		c.check(card.Passed, action+" solution passes", "%s", card.Note)
+	}
+
+	// a broken solution must fail with something to show the student
+	if fs.NArg() == 3 {
+		broken := mustReadConformanceFiles(fs.Arg(2))
+		card, events, elapsed := runConformanceAction(problemType, "grade", broken, solution)
+		c.checkRun("grade broken", card, events, elapsed, limit)
+		c.check(!card.Passed, "grade broken fails", "the report card says it passed")
+		failed := false
+		for _, result := range card.Results {
+			if result.Outcome != "passed" {
+				failed = true
+			}
+		}
+		c.check(failed || card.Note != "", "grade broken explains", "the report card has no failed results and no note")
+	}
+
+	if c.failures > 0 {
+		log.Fatalf("problem type %s failed %d check%s", problemType.Name, c.failures, plural(c.failures))
+	}
+	log.Printf("problem type %s passed all checks", problemType.Name)
+}
+
+// checkRun checks the conventions every action must follow, whatever the outcome.
+func (c *conformance) checkRun(name string, card *ReportCard, events []*EventMessage, elapsed, limit time.Duration) {
+	c.check(elapsed <= limit, name+" time", "took %v, but the limit is %v", elapsed, limit)
+	execs, exits := 0, 0
+	for _, event := range events {
+		switch event.Event {
+		case "exec":
+			execs++
+		case "exit":
+			exits++
+		}
+	}
+	c.check(execs > 0 && execs == exits, name+" transcript", "found %d exec and %d exit events", execs, exits)
+	for _, result := range card.Results {
+		switch {
+		case result.Name == "":
+			c.check(false, name+" report", "a result has no name")
+		case result.Stage != "" && result.Outcome == "skipped":
+			// set by the pipeline, not the grader
+		case result.Outcome != "passed" && result.Outcome != "failed" && result.Outcome != "error" && result.Outcome != "warning":
+			c.check(false, name+" report", "result %s has outcome %q; use passed, failed, warning, or error", result.Name, result.Outcome)
+		}
+	}
+}
+
+// runConformanceAction runs one action of a problem type in a fresh container.
+// Actions that compare against the reference solution are given the correct solution,
+// and capture-the-flag actions are given a flag, which a correct solution submits.
+func runConformanceAction(problemType *ProblemType, action string, files, reference map[string]string) (*ReportCard, []*EventMessage, time.Duration) {
+	handler, ok := problemType.Actions[action].Handler.(nannyHandler)
+	if !ok {
+		log.Fatalf("action %s has no handler", action)
+	}
+	elt := problemType.Actions[action]
+	if elt.Reference || elt.Flag {
+		extended := make(map[string]string)
+		for name, contents := range files {
+			extended[name] = contents
+		}
+		if elt.Reference {
+			for name, contents := range reference {
+				extended[path.Join(sdk.ReferenceDir, name)] = contents
+			}
+		}
+		if elt.Flag {
+			flag := ComputeFlag(Config().DaycareSecret, "conformance", 0)
+			extended[sdk.FlagFile] = flag
+			if sameFiles(files, reference) {
+				extended[sdk.FlagSubmissionFile] = flag + "\n"
+			}
+		}
+		files = extended
+	}
+	problem := &Problem{Unique: "conformance", ProblemType: problemType.Name}
+	n, err := NewNanny(problemType, problem, nil, "nanny-conformance", true, nil)
+	if err != nil {
+		log.Fatalf("error creating nanny: %v", err)
+	}
+
+	var events []*EventMessage
+	finished := make(chan struct{})
+	go func() {
+		for event := range n.Events {
+			events = append(events, event)
+		}
+		finished <- struct{}{}
+	}()
+
+	start := time.Now()
+	handler(n, nil, nil, files)
+	elapsed := time.Since(start)
+
+	if err := n.Shutdown(); err != nil {
+		log.Printf("nanny shutdown error: %v", err)
+	}
+	close(n.Events)
+	<-finished
+	return n.ReportCard, events, elapsed
+}
+
+// mustReadConformanceFiles reads every file under a directory, skipping hidden ones.
+func mustReadConformanceFiles(dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = string(contents)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("error reading %s: %v", dir, err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("no files found in %s", dir)
+	}
+	return files
+}
+
+// sameFiles reports whether two sets of files are identical.
+func sameFiles(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, contents := range a {
+		if other, exists := b[name]; !exists || other != contents {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// recordProblemCost adds the resources used by a grading run to the totals for
+// the problem in the term of the course. Runs the daycare could not measure are skipped.
+func recordProblemCost(tx *sql.Tx, courseID, problemID int64, resources *ResourceUsage, now time.Time) error {
+	if resources == nil {
+		return nil
+	}
+	var term string
+	if err := tx.QueryRow(`SELECT COALESCE(term, '') FROM courses WHERE id = $1`, courseID).Scan(&term); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`UPDATE problem_costs SET runs = runs + 1, runtime_seconds = runtime_seconds + $1, `+
+		`cpu_seconds = cpu_seconds + $2, memory_mb_total = memory_mb_total + $3, `+
+		`max_memory_mb = GREATEST(max_memory_mb, $3), updated_at = $4 WHERE problem_id = $5 AND term = $6`,
+		resources.Runtime.Seconds(), resources.CPUSeconds, resources.MaxMemoryMB, now, problemID, term)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		return nil
+	}
+	cost := &ProblemCost{
+		ProblemID:      problemID,
+		Term:           term,
+		Runs:           1,
+		RuntimeSeconds: resources.Runtime.Seconds(),
+		CPUSeconds:     resources.CPUSeconds,
+		MemoryMBTotal:  resources.MaxMemoryMB,
+		MaxMemoryMB:    resources.MaxMemoryMB,
+		UpdatedAt:      now,
+	}
+	return meddler.Insert(tx, "problem_costs", cost)
+}
+
+// GetProblemCosts handles /v2/problem_costs requests,
+// returning the resources used grading each problem, most expensive first,
+// with the most expensive flagged.
+//
+// If parameter term is present, only grading for courses in that term is
+// counted. Otherwise every term is added together.
+func GetProblemCosts(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	report := []*ProblemCost{}
+	var err error
+	if term := r.FormValue("term"); term != "" {
+		err = meddler.QueryAll(tx, &report, `SELECT * FROM problem_costs WHERE term = $1`, term)
+	} else {
+		err = meddler.QueryAll(tx, &report, `SELECT problem_id, '' AS term, SUM(runs) AS runs, `+
+			`SUM(runtime_seconds) AS runtime_seconds, SUM(cpu_seconds) AS cpu_seconds, `+
+			`SUM(memory_mb_total) AS memory_mb_total, MAX(max_memory_mb) AS max_memory_mb, `+
+			`MAX(updated_at) AS updated_at FROM problem_costs GROUP BY problem_id`)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	uniques, err := getProblemUniques(tx, "problem_costs")
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, elt := range report {
+		elt.Unique = uniques[elt.ProblemID]
+	}
+
+	FlagExpensiveProblems(report)
+	render.JSON(http.StatusOK, report)
+}
+
+// getProblemUniques maps the IDs of problems that appear in a table to their unique IDs.
+func getProblemUniques(tx *sql.Tx, table string) (map[int64]string, error) {
+	uniques := make(map[int64]string)
+	rows, err := tx.Query(`SELECT id, unique_id FROM problems WHERE id IN (SELECT problem_id FROM ` + table + `)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var unique string
+		if err := rows.Scan(&id, &unique); err != nil {
+			return nil, err
+		}
+		uniques[id] = unique
+	}
+	return uniques, rows.Err()
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// courseArchiveInterval is how often the server checks for courses whose term has ended.
+const courseArchiveInterval = time.Hour
+
+// isCourseInstructor returns true if the user is an instructor in the given course.
+// Instructor status is recorded on assignments, so an instructor must have
+// launched at least one problem set in the course through the LMS.
+func isCourseInstructor(tx *sql.Tx, userID, courseID int64) (bool, error) {
+	var instructor bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE user_id = $1 AND course_id = $2 AND instructor)`,
+		userID, courseID).Scan(&instructor)
+	return instructor, err
+}
+
+// isCourseStaff returns true if the user is an instructor in the given course,
+// or a TA in it according to the LMS or one of its sections.
+func isCourseStaff(tx *sql.Tx, userID, courseID int64) (bool, error) {
+	var staff bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE user_id = $1 AND course_id = $2 `+
+		`AND (instructor OR roles LIKE '%TeachingAssistant%')) `+
+		`OR EXISTS (SELECT 1 FROM section_members JOIN sections ON section_members.section_id = sections.id `+
+		`WHERE section_members.user_id = $1 AND sections.course_id = $2 AND section_members.role = 'ta')`,
+		userID, courseID).Scan(&staff)
+	return staff, err
+}
+
+// isCourseMember returns true if the user has any assignment in the given course,
+// whether as a student or as staff.
+func isCourseMember(tx *sql.Tx, userID, courseID int64) (bool, error) {
+	var member bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE user_id = $1 AND course_id = $2)`,
+		userID, courseID).Scan(&member)
+	return member, err
+}
+
+// addCourseProblemSet records that a problem set has been offered in a course.
+func addCourseProblemSet(tx *sql.Tx, courseID, problemSetID int64, now time.Time) error {
+	_, err := tx.Exec(`INSERT INTO course_problem_sets (course_id, problem_set_id, created_at) `+
+		`SELECT $1, $2, $3 WHERE NOT EXISTS `+
+		`(SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID, now)
+	return err
+}
+
+// GetCourseProblemSets handles /v2/courses/:course_id/problem_sets requests,
+// returning a list of the problem sets offered in the course.
+func GetCourseProblemSets(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	problemSets := []*ProblemSet{}
+
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &problemSets, `SELECT problem_sets.* `+
+			`FROM problem_sets JOIN course_problem_sets ON problem_sets.id = course_problem_sets.problem_set_id `+
+			`WHERE course_problem_sets.course_id = $1 `+
+			`ORDER BY course_problem_sets.created_at, problem_sets.id`,
+			courseID)
+	} else {
+		err = meddler.QueryAll(tx, &problemSets, `SELECT problem_sets.* `+
+			`FROM problem_sets JOIN course_problem_sets ON problem_sets.id = course_problem_sets.problem_set_id `+
+			`WHERE course_problem_sets.course_id = $1 AND EXISTS `+
+			`(SELECT 1 FROM assignments WHERE course_id = $1 AND user_id = $2) `+
+			`ORDER BY course_problem_sets.created_at, problem_sets.id`,
+			courseID, currentUser.ID)
+	}
+
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, problemSets)
+}
+
+// PutCourseTerm handles /v2/courses/:course_id/term requests,
+// setting the term a course belongs to, when the term ends, whether the course is archived,
+// and whether an instructor has overridden the end of term.
+// The updated course is returned.
+func PutCourseTerm(w http.ResponseWriter, tx *sql.Tx, params martini.Params, term CourseTerm, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	course.Term = term.Term
+	course.EndsAt = term.EndsAt
+	course.Archived = term.Archived
+	course.ArchiveOverride = term.ArchiveOverride
+	course.UpdatedAt = time.Now()
+	if err := meddler.Save(tx, "courses", course); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("course %d (%s) term set to %q, ends %v, archived %v, override %v",
+		course.ID, course.Name, course.Term, course.EndsAt, course.Archived, course.ArchiveOverride)
+	render.JSON(http.StatusOK, course)
+}
+
+// PostCourseRollForward handles /v2/courses/:course_id/roll_forward requests,
+// copying the problem sets and course settings of an earlier course into this one.
+// The current user must be an instructor in both courses (or an administrator).
+// Problem sets already offered in this course are left alone, but any setting
+// the earlier course has replaces the matching one in this course.
+// Feedback rules belong to problems rather than courses, so they carry over as they are.
+// The list of problem sets offered in this course is returned.
+func PostCourseRollForward(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, rollForward CourseRollForward, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if rollForward.FromCourseID == courseID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "cannot roll a course forward into itself")
+		return
+	}
+
+	// load both courses
+	from, to := new(Course), new(Course)
+	if err := meddler.Load(tx, "courses", from, rollForward.FromCourseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := meddler.Load(tx, "courses", to, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if !currentUser.Admin {
+		instructor, err := isCourseInstructor(tx, currentUser.ID, from.ID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if !instructor {
+			loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an instructor in course %d (%s)",
+				currentUser.ID, currentUser.Name, from.ID, from.Name)
+			return
+		}
+	}
+
+	// copy the problem sets
+	now := time.Now()
+	if _, err := tx.Exec(`INSERT INTO course_problem_sets (course_id, problem_set_id, created_at) `+
+		`SELECT $1, problem_set_id, $2 FROM course_problem_sets `+
+		`WHERE course_id = $3 AND problem_set_id NOT IN `+
+		`(SELECT problem_set_id FROM course_problem_sets WHERE course_id = $1)`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the problem type overrides, replacing any already set in this course
+	if _, err := tx.Exec(`DELETE FROM problem_type_overrides WHERE course_id = $1 AND problem_type IN `+
+		`(SELECT problem_type FROM problem_type_overrides WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO problem_type_overrides (course_id, problem_type, image, `+
+		`max_cpu, max_clock, max_fd, max_file_size, max_memory, max_threads, options, created_at, updated_at) `+
+		`SELECT $1, problem_type, image, max_cpu, max_clock, max_fd, max_file_size, max_memory, max_threads, options, $2, $2 `+
+		`FROM problem_type_overrides WHERE course_id = $3`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the prerequisites, replacing those of the same problem sets in this course
+	if _, err := tx.Exec(`DELETE FROM prerequisites WHERE course_id = $1 AND problem_set_id IN `+
+		`(SELECT problem_set_id FROM prerequisites WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO prerequisites (course_id, problem_set_id, required_problem_set_id, threshold, created_at, updated_at) `+
+		`SELECT $1, problem_set_id, required_problem_set_id, threshold, $2, $2 `+
+		`FROM prerequisites WHERE course_id = $3`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the feature flag settings, replacing those of the same flags in this course
+	if _, err := tx.Exec(`DELETE FROM course_feature_flags WHERE course_id = $1 AND name IN `+
+		`(SELECT name FROM course_feature_flags WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO course_feature_flags (course_id, name, enabled, created_at, updated_at) `+
+		`SELECT $1, name, enabled, $2, $2 FROM course_feature_flags WHERE course_id = $3`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the solution releases, moving release dates by the distance between
+	// the two terms' end dates; dated releases are dropped if either is unknown
+	endsAt := to.EndsAt
+	if !rollForward.EndsAt.IsZero() {
+		endsAt = rollForward.EndsAt
+	}
+	shift := !from.EndsAt.IsZero() && !endsAt.IsZero()
+	if _, err := tx.Exec(`DELETE FROM solution_releases WHERE course_id = $1 AND problem_set_id IN `+
+		`(SELECT problem_set_id FROM solution_releases WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO solution_releases (course_id, problem_set_id, release_at, on_pass, files, created_at, updated_at) `+
+		`SELECT $1, problem_set_id, CASE WHEN $4::boolean THEN release_at + $5::double precision * interval '1 second' END, on_pass, files, $2, $2 `+
+		`FROM solution_releases WHERE course_id = $3 AND (on_pass OR ($4::boolean AND release_at IS NOT NULL))`,
+		to.ID, now, from.ID, shift, endsAt.Sub(from.EndsAt).Seconds()); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the rubrics, replacing those of the same problem sets in this course
+	if _, err := tx.Exec(`DELETE FROM rubrics WHERE course_id = $1 AND problem_set_id IN `+
+		`(SELECT problem_set_id FROM rubrics WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO rubrics (course_id, problem_set_id, criteria, formula, weight, created_at, updated_at) `+
+		`SELECT $1, problem_set_id, criteria, formula, weight, $2, $2 FROM rubrics WHERE course_id = $3`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the check-off policies, replacing those of the same problem sets in this course
+	if _, err := tx.Exec(`DELETE FROM checkoff_policies WHERE course_id = $1 AND problem_set_id IN `+
+		`(SELECT problem_set_id FROM checkoff_policies WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO checkoff_policies (course_id, problem_set_id, weight, created_at, updated_at) `+
+		`SELECT $1, problem_set_id, weight, $2, $2 FROM checkoff_policies WHERE course_id = $3`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// copy the quota, replacing the one in this course if the earlier course has one
+	if _, err := tx.Exec(`DELETE FROM course_quotas WHERE course_id = $1 AND EXISTS `+
+		`(SELECT 1 FROM course_quotas WHERE course_id = $2)`,
+		to.ID, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO course_quotas (course_id, grading_minutes, storage_mb, created_at, updated_at) `+
+		`SELECT $1, grading_minutes, storage_mb, $2, $2 FROM course_quotas WHERE course_id = $3`,
+		to.ID, now, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// set up the new term
+	if rollForward.Term != "" {
+		to.Term = rollForward.Term
+	}
+	if !rollForward.EndsAt.IsZero() {
+		to.EndsAt = rollForward.EndsAt
+	}
+	to.Archived = false
+	to.ArchiveOverride = false
+	to.UpdatedAt = now
+	if err := meddler.Save(tx, "courses", to); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("course %d (%s) rolled forward into course %d (%s)", from.ID, from.Name, to.ID, to.Name)
+
+	GetCourseProblemSets(w, tx, params, currentUser, render)
+}
+
+// getProblemTypeOverride loads the course overrides for a problem type,
+// returning nil if the course uses the problem type defaults.
+// The overrides are checked against the current OverridePolicy, which may
+// have been tightened since they were saved.
+func getProblemTypeOverride(tx *sql.Tx, courseID int64, problemType string) (*ProblemTypeOverride, error) {
+	override := new(ProblemTypeOverride)
+	err := meddler.QueryRow(tx, override, `SELECT * FROM problem_type_overrides WHERE course_id = $1 AND problem_type = $2`,
+		courseID, problemType)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if elt, exists := problemTypes()[problemType]; exists {
+		if err := override.Normalize(elt, &Config().OverridePolicy); err != nil {
+			return nil, fmt.Errorf("course %d overrides problem type %s: %v", courseID, problemType, err)
+		}
+	}
+	return override, nil
+}
+
+// GetCourseProblemTypeOverrides handles /v2/courses/:course_id/problem_type_overrides requests,
+// returning the list of problem type defaults the course overrides.
+func GetCourseProblemTypeOverrides(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	overrides := []*ProblemTypeOverride{}
+
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &overrides, `SELECT * FROM problem_type_overrides WHERE course_id = $1 ORDER BY problem_type`, courseID)
+	} else {
+		err = meddler.QueryAll(tx, &overrides, `SELECT * FROM problem_type_overrides `+
+			`WHERE course_id = $1 AND EXISTS `+
+			`(SELECT 1 FROM assignments WHERE course_id = $1 AND user_id = $2) `+
+			`ORDER BY problem_type`,
+			courseID, currentUser.ID)
+	}
+
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, overrides)
+}
+
+// PutCourseProblemTypeOverride handles /v2/courses/:course_id/problem_type_overrides/:problem_type requests,
+// setting the course overrides for a problem type.
+// The overrides apply to all grading in the course from then on.
+// Limits above what the OverridePolicy allows are lowered to it.
+func PutCourseProblemTypeOverride(w http.ResponseWriter, tx *sql.Tx, params martini.Params, override ProblemTypeOverride, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	name := params["problem_type"]
+	problemType, exists := problemTypes()[name]
+	if !exists {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem type %q not found", name)
+		return
+	}
+	if err := override.Normalize(problemType, &Config().OverridePolicy); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if note := overrideImageMismatch(override.Image); note != "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "image cannot be used: %s", note)
+		return
+	}
+
+	// the old override is replaced even if the policy no longer allows it
+	old := new(ProblemTypeOverride)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM problem_type_overrides WHERE course_id = $1 AND problem_type = $2`, courseID, name)
+	if err != nil && err != sql.ErrNoRows {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	now := time.Now()
+	override.CourseID = courseID
+	override.ProblemType = name
+	override.CreatedAt = now
+	override.UpdatedAt = now
+	if err == nil {
+		override.CreatedAt = old.CreatedAt
+		if _, err := tx.Exec(`DELETE FROM problem_type_overrides WHERE course_id = $1 AND problem_type = $2`, courseID, name); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+	if err := meddler.Insert(tx, "problem_type_overrides", &override); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("course %d overrides problem type %s defaults", courseID, name)
+	render.JSON(http.StatusOK, &override)
+}
+
+// DeleteCourseProblemTypeOverride handles /v2/courses/:course_id/problem_type_overrides/:problem_type requests,
+// returning the course to the problem type defaults.
+func DeleteCourseProblemTypeOverride(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM problem_type_overrides WHERE course_id = $1 AND problem_type = $2`, courseID, params["problem_type"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+}
+
+// archiveEndedCourses marks every course whose term has ended as archived,
+// except those an instructor has kept active.
+func archiveEndedCourses(tx *sql.Tx, now time.Time) (int64, error) {
+	result, err := tx.Exec(`UPDATE courses SET archived = true, updated_at = $1 `+
+		`WHERE NOT archived AND NOT archive_override AND ends_at IS NOT NULL AND ends_at < $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// archiveEndedCoursesLoop periodically archives courses whose term has ended in every tenant.
+func archiveEndedCoursesLoop(db *sql.DB) {
+	for {
+		now := time.Now()
+		err := forEachTenant(db, func(tx *sql.Tx, tenant *TenantConfig) error {
+			n, err := archiveEndedCourses(tx, now)
+			if n > 0 {
+				log.Printf("archived %d course%s for %s at the end of term", n, plural(int(n)), tenant.Hostname)
+			}
+			return err
+		})
+		if err != nil {
+			log.Printf("error archiving courses at the end of term: %v", err)
+		}
+		time.Sleep(courseArchiveInterval)
+	}
+}
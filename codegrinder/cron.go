@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// cronJob is a task the TA runs once a day at a fixed local hour.
+type cronJob struct {
+	Name string
+	Hour int // 0–23, in the server's local time
+	Run  func(db *sql.DB) error
+}
+
+// next returns the first time after now that the job is due.
+func (job *cronJob) next(now time.Time) time.Time {
+	at := time.Date(now.Year(), now.Month(), now.Day(), job.Hour, 0, 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// cronLoop runs a job every day at its hour. A run that fails is logged
+// and tried again the next day.
+func cronLoop(db *sql.DB, job *cronJob) {
+	if job.Hour < 0 || job.Hour > 23 {
+		log.Printf("cron job %s has hour %d, which must be from 0 to 23; not scheduling it", job.Name, job.Hour)
+		return
+	}
+	for {
+		at := job.next(time.Now())
+		log.Printf("cron job %s next runs at %s", job.Name, at.Format("2006-01-02 15:04"))
+		time.Sleep(time.Until(at))
+
+		start := time.Now()
+		if err := job.Run(db); err != nil {
+			log.Printf("cron job %s failed after %v: %v", job.Name, time.Since(start).Round(time.Second), err)
+		} else {
+			log.Printf("cron job %s finished in %v", job.Name, time.Since(start).Round(time.Second))
+		}
+	}
+}
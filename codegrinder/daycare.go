@@ -3,30 +3,30 @@ package main
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"regexp"
+	"path"
+	"sync"
 	"time"
 
-	"github.com/fsouza/go-dockerclient"
 	"github.com/go-martini/martini"
 	"github.com/gorilla/websocket"
+	"github.com/russross/codegrinder/sdk"
 	. "github.com/russross/codegrinder/types"
 )
 
-var dockerClient *docker.Client
-
 // SocketProblemTypeAction handles a request to /sockets/:problem_type/:action
 // It expects a websocket connection, which will receive a series of DaycareRequest objects
 // and will respond with DaycareResponse objects, though not in a one-to-one fashion.
 // The first DaycareRequest must have the CommitBundle field present. Future requests
 // should only have Stdin present.
-func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params martini.Params) {
+func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, span *traceSpan, params martini.Params) {
 	now := time.Now()
 
-	problemType, exists := problemTypes[params["problem_type"]]
+	problemType, exists := problemTypes()[params["problem_type"]]
 	if !exists {
 		loggedHTTPErrorf(w, http.StatusNotFound, "problem type %q not found", params["problem_type"])
 		return
@@ -47,6 +47,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	logAndTransmitErrorf := func(format string, args ...interface{}) {
 		msg := fmt.Sprintf(format, args...)
 		log.Print(msg)
+		span.fail(fmt.Errorf("%s", msg))
 		res := &DaycareResponse{Error: msg}
 		if err := socket.WriteJSON(res); err != nil {
 			// what can we do? we already logged the error
@@ -88,18 +89,57 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 
 	// check signatures
 	problem, steps := req.CommitBundle.Problem, req.CommitBundle.ProblemSteps
-	problemSig := problem.ComputeSignature(Config.DaycareSecret, steps)
+	problemSig := problem.ComputeSignature(Config().DaycareSecret, steps)
 	if req.CommitBundle.ProblemSignature != problemSig {
 		logAndTransmitErrorf("problem signature mismatch: found %s but expected %s", req.CommitBundle.ProblemSignature, problemSig)
 		return
 	}
+	override := req.CommitBundle.ProblemTypeOverride
+	if override != nil && override.ProblemType != problem.ProblemType {
+		logAndTransmitErrorf("course override is for problem type %s, but problem is type %s", override.ProblemType, problem.ProblemType)
+		return
+	}
+	chainSig := req.CommitBundle.SigningSignature(Config().DaycareSecret)
 	commit := req.CommitBundle.Commit
-	commitSig := commit.ComputeSignature(Config.DaycareSecret, problemSig)
+	commitSig := commit.ComputeSignature(Config().DaycareSecret, chainSig)
 	if req.CommitBundle.CommitSignature != commitSig {
 		logAndTransmitErrorf("commit signature mismatch: found %s but expected %s", req.CommitBundle.CommitSignature, commitSig)
 		return
 	}
 	req.CommitBundle.CommitSignature = ""
+	env := &Environment{}
+	if req.CommitBundle.Environment != "" {
+		var err error
+		if env, err = OpenEnvironment(Config().DaycareSecret, req.CommitBundle.Environment, req.CommitBundle.ProblemSignature); err != nil {
+			logAndTransmitErrorf("%v", err)
+			return
+		}
+	}
+	redactor := env.Redactor()
+
+	// actions that compare against the reference solution get it in a directory of its own;
+	// a solution being confirmed is its own reference
+	var reference map[string]string
+	if action.Reference {
+		switch {
+		case req.CommitBundle.Reference != "":
+			var err error
+			if reference, err = OpenReference(Config().DaycareSecret, req.CommitBundle.Reference, req.CommitBundle.ProblemSignature, commit.Step); err != nil {
+				logAndTransmitErrorf("%v", err)
+				return
+			}
+		case commit.Action == "confirm":
+			reference = commit.Files
+		default:
+			logAndTransmitErrorf("action %s needs the reference solution, but none is on file for this step", commit.Action)
+			return
+		}
+	}
+	req.CommitBundle.Reference = ""
+	if err := commit.VerifyChecksums(); err != nil {
+		logAndTransmitErrorf("%v", err)
+		return
+	}
 
 	// commit must be recent
 	age := time.Since(commit.UpdatedAt)
@@ -111,6 +151,10 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		logAndTransmitErrorf("commit signature is %v off, cannot be more than %v", age, MaxDaycareRequestAge)
 		return
 	}
+	if req.Share != "" && req.Share != ShareView && req.Share != ShareInput && req.Share != ShareBroadcast {
+		logAndTransmitErrorf("share must be %s, %s, or %s, not %q", ShareView, ShareInput, ShareBroadcast, req.Share)
+		return
+	}
 	if commit.Action != params["action"] {
 		logAndTransmitErrorf("commit says action is %s, but request says %s", commit.Action, params["action"])
 		return
@@ -127,7 +171,12 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		return
 	}
 
-	// collect the files from the problem step and overlay the files from the commit
+	span.set("user.id", req.UserID)
+	span.set("problem.unique", problem.Unique)
+	span.set("commit.action", commit.Action)
+
+	// collect the files from the problem step and overlay the files from the commit,
+	// then files the student wrote for earlier problems
 	files := make(map[string]string)
 	for name, contents := range step.Files {
 		files[name] = contents
@@ -135,43 +184,163 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	for name, contents := range commit.Files {
 		files[name] = contents
 	}
+	for name, contents := range req.CommitBundle.Imports {
+		files[name] = contents
+	}
+	for name, contents := range reference {
+		files[path.Join(sdk.ReferenceDir, name)] = contents
+	}
+	if action.Reference {
+		for name, contents := range step.Files {
+			files[path.Join(sdk.SupportDir, name)] = contents
+		}
+	}
+	if action.Flag {
+		files[sdk.FlagFile] = ComputeFlag(Config().DaycareSecret, problem.Unique, commit.AssignmentID)
+	}
+	if secrets := env.SecretValues(); action.Secrets && secrets != nil {
+		raw, err := json.Marshal(secrets)
+		if err != nil {
+			logAndTransmitErrorf("error encoding secrets: %v", err)
+			return
+		}
+		files[sdk.SecretsFile] = string(raw)
+	}
+
+	// record an event in the transcript and feed it back to the client
+	// and anyone the student is sharing the session with
+	var share *sharedSession
+	record := func(event *EventMessage) {
+		commit.Transcript = append(commit.Transcript, event)
+		switch event.Event {
+		case "exec", "exit", "stdin", "stdout", "stderr", "stdinclosed", "error":
+			res := &DaycareResponse{Event: event}
+			if err := socket.WriteJSON(res); err != nil {
+				logAndTransmitErrorf("error writing event JSON: %v", err)
+			}
+			if share != nil {
+				share.record(event)
+			}
+		}
+	}
+
+	// course options follow problem options so they can refine them
+	options := problem.Options
+	if override != nil {
+		options = append(append([]string{}, problem.Options...), override.Options...)
+	}
+	r.ParseForm()
+
+	// a grading matrix runs every toolchain version in its own container
+	if len(req.CommitBundle.Matrix) > 0 && commit.Action == "grade" {
+		handler, ok := action.Handler.(nannyHandler)
+		if !ok {
+			logAndTransmitErrorf("handler for action %s is of wrong type", commit.Action)
+			return
+		}
+		commit.ReportCard = gradeMatrix(req.CommitBundle.Matrix, override.Apply(problemType), problem, env.List(), redactor,
+			req.CommitBundle.Tenant, req.UserID, commit, handler, r.Form["args"], options, files, record, span)
+		sendGradedCommit(socket, req.CommitBundle, chainSig, now)
+		return
+	}
+
+	// a problem that lists dependencies is graded on a snapshot with them installed
+	base := override.Apply(problemType)
+	gradeType, err := withDependencies(base, step.Files, commit.Action == "confirm")
+	if err != nil {
+		logAndTransmitErrorf("%v", err)
+		return
+	}
 
 	// launch a nanny process
 	nannyName := fmt.Sprintf("nanny-user-%d", req.UserID)
 	log.Printf("launching container for %s", nannyName)
-	n, err := NewNanny(problemType, problem, nannyName)
+	n, err := NewNanny(gradeType, problem, env.List(), nannyName, commit.Action == "confirm", span)
 	if err != nil {
 		logAndTransmitErrorf("error creating nanny: %v", err)
 		return
 	}
 
+	// the toolchain is the problem type's image, not the snapshot built on it
+	n.Image = base.Image
+	job := startDaycareJob(n, req.CommitBundle.Tenant, req.UserID, problemType.Name, problem, commit)
+	defer finishDaycareJob(job.ID)
+
+	// let others watch if the student asked
+	handled := make(chan struct{})
+	if req.Share != "" {
+		if share, err = startSharedSession(req.UserID, problem.Unique, req.Share, n.Input, handled); err != nil {
+			logAndTransmitErrorf("error sharing session: %v", err)
+			n.Shutdown()
+			return
+		}
+		defer share.finish()
+		if err := socket.WriteJSON(&DaycareResponse{ShareID: share.ID}); err != nil {
+			log.Printf("error writing share ID: %v", err)
+		}
+	}
+
 	// start a listener
 	finished := make(chan struct{})
 	go func() {
 		for event := range n.Events {
-			// record the event
-			commit.Transcript = append(commit.Transcript, event)
-
-			// feed event back to client
-			switch event.Event {
-			case "exec", "exit", "stdin", "stdout", "stderr", "stdinclosed", "error":
-				res := &DaycareResponse{Event: event}
-				if err := socket.WriteJSON(res); err != nil {
-					logAndTransmitErrorf("error writing event JSON: %v", err)
-				}
+			// secrets never leave the daycare
+			if redactor != nil {
+				event.Redact(redactor)
 			}
+			record(event)
 		}
 		finished <- struct{}{}
 	}()
 
+	// forward stdin from later requests to interactive processes
+	go func() {
+		for {
+			req := new(DaycareRequest)
+			if err := socket.ReadJSON(req); err != nil {
+				return
+			}
+			if share != nil && req.Share != "" {
+				share.allowInput(req.Share == ShareInput)
+			}
+			if req.Stdin == "" {
+				continue
+			}
+			select {
+			case n.Input <- req.Stdin:
+			case <-handled:
+				return
+			}
+		}
+	}()
+
 	// grade the problem
-	r.ParseForm()
 	handler, ok := action.Handler.(nannyHandler)
 	if ok {
-		handler(n, r.Form["args"], problem.Options, files)
+		grading := n.span.child("container "+commit.Action, spanInternal)
+		handler(n, r.Form["args"], options, files)
+		grading.finish()
 	} else {
 		logAndTransmitErrorf("handler for action %s is of wrong type", commit.Action)
 	}
+	close(handled)
+	if reason := n.StopReason(); reason != "" {
+		n.ReportCard.LogAndFailf("%s", reason)
+	}
+	if toolchain, err := inspectToolchain(n.Image); err != nil {
+		log.Printf("unable to identify toolchain: %v", err)
+	} else {
+		n.ReportCard.Toolchain = toolchain
+	}
+	if redactor != nil {
+		n.ReportCard.Redact(redactor)
+	}
+	n.ReportCard.TrimArtifacts()
+	if resources, err := n.measureResources(); err != nil {
+		log.Printf("unable to measure resource use: %v", err)
+	} else {
+		n.ReportCard.Resources = resources
+	}
 	commit.ReportCard = n.ReportCard
 	//dump(commit.ReportCard)
 
@@ -185,6 +354,13 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	<-finished
 
 	// send the final commit back to the client
+	sendGradedCommit(socket, req.CommitBundle, chainSig, now)
+}
+
+// sendGradedCommit scores a graded commit from its report card,
+// signs it, and sends it back to the client.
+func sendGradedCommit(socket *websocket.Conn, bundle *CommitBundle, chainSig string, now time.Time) {
+	commit := bundle.Commit
 	commit.Compress()
 
 	// compute the score for this step on a scale of 0.0 to 1.0
@@ -196,130 +372,123 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		commit.Score = 0.0
 	} else {
 		// compute partial credit for this step
-		passed := 0
+		passed, total := 0.0, 0.0
 		for _, elt := range commit.ReportCard.Results {
+			if elt.Outcome == "warning" {
+				continue
+			}
 			if elt.Outcome == "passed" {
-				passed++
+				passed += elt.Weight()
 			}
+			total += elt.Weight()
+		}
+		commit.Score = 0.0
+		if total > 0.0 {
+			commit.Score = passed / total
 		}
-		commit.Score = float64(passed) / float64(len(commit.ReportCard.Results))
 	}
 	commit.UpdatedAt = now
-	req.CommitBundle.CommitSignature = commit.ComputeSignature(Config.DaycareSecret, req.CommitBundle.ProblemSignature)
+	bundle.CommitSignature = commit.ComputeSignature(Config().DaycareSecret, chainSig)
+	commit.AddChecksums()
 
-	res := &DaycareResponse{CommitBundle: req.CommitBundle}
+	res := &DaycareResponse{CommitBundle: bundle}
 	if err := socket.WriteJSON(res); err != nil {
-		logAndTransmitErrorf("error writing final commit JSON: %v", err)
-		return
+		log.Printf("error writing final commit JSON: %v", err)
 	}
 }
 
 type Nanny struct {
 	Start      time.Time
-	Container  *docker.Container
+	Image      string
+	Sandbox    sandbox
 	ReportCard *ReportCard
 	Input      chan string
 	Events     chan *EventMessage
 	Transcript []*EventMessage
-}
 
-type nannyHandler func(*Nanny, []string, []string, map[string]string)
-
-var getContainerIDRE = regexp.MustCompile(`The name .* is already in use by container (.*)\. You have to delete \(or rename\) that container to be able to reuse that name`)
-
-func getContainerID(msg string) string {
-	groups := getContainerIDRE.FindStringSubmatch(msg)
-	if len(groups) != 2 {
-		return ""
-	}
-	return groups[1]
+	span       *traceSpan
+	done       chan struct{}
+	stopLock   sync.Mutex
+	stopReason string
 }
 
-func NewNanny(problemType *ProblemType, problem *Problem, name string) (*Nanny, error) {
-	// create a container
-	mem := problemType.MaxMemory * 1024 * 1024
-	config := &docker.Config{
-		Hostname:        name,
-		Memory:          int64(mem),
-		MemorySwap:      -1,
-		NetworkDisabled: true,
-		Cmd:             []string{"/bin/sh", "-c", "sleep infinity"},
-		Image:           problemType.Image,
-	}
-	hostConfig := &docker.HostConfig{
-		CapDrop: []string{
-			"NET_RAW",
-			"NET_BIND_SERVICE",
-			"AUDIT_READ",
-			"AUDIT_WRITE",
-			"DAC_OVERRIDE",
-			"SETFCAP",
-			"SETPCAP",
-			"SETGID",
-			"SETUID",
-			"MKNOD",
-			"CHOWN",
-			"FOWNER",
-			"FSETID",
-			"KILL",
-			"SYS_CHROOT",
-		},
-		Ulimits: []docker.ULimit{},
-	}
-
-	container, err := dockerClient.CreateContainer(docker.CreateContainerOptions{Name: name, Config: config, HostConfig: hostConfig})
-	if err != nil {
-		if apiError, ok := err.(*docker.Error); ok && apiError.Status == http.StatusConflict && getContainerID(apiError.Message) != "" {
-			// container already exists with that name--try killing it
-			err2 := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-				ID:    getContainerID(apiError.Message),
-				Force: true,
-			})
-			if err2 != nil {
-				log.Printf("NewNanny->StartContainer error killing existing container: %v", err2)
-				return nil, err2
-			}
+type nannyHandler func(*Nanny, []string, []string, map[string]string)
 
-			// try it one more time
-			container, err = dockerClient.CreateContainer(docker.CreateContainerOptions{Name: name, Config: config, HostConfig: hostConfig})
-		}
+// NewNanny creates a sandbox for a grading run using the configured GradingBackend.
+// env holds extra environment variables in NAME=value form, and writeCache
+// lets the run add to the problem type's build caches.
+// The life of the container is traced as part of the given span.
+func NewNanny(problemType *ProblemType, problem *Problem, env []string, name string, writeCache bool, span *traceSpan) (n *Nanny, err error) {
+	lifecycle := span.child("container", spanInternal)
+	lifecycle.set("container.name", name)
+	lifecycle.set("container.image", problemType.Image)
+	create := lifecycle.child("container create", spanInternal)
+	defer func() {
+		create.fail(err)
+		create.finish()
 		if err != nil {
-			log.Printf("NewNanny->CreateContainer: %#v", err)
-			return nil, err
+			lifecycle.fail(err)
+			lifecycle.finish()
 		}
-	}
+	}()
 
-	// start it
-	err = dockerClient.StartContainer(container.ID, nil)
+	if problem.IsMastery() {
+		// mastery problems regenerate their tests on every attempt
+		env = append(append([]string{}, env...), fmt.Sprintf("%s=%d", sdk.SeedVariable, newTestSeed()))
+	}
+	box, err := newSandbox(problemType, env, name, writeCache)
 	if err != nil {
-		log.Printf("NewNanny->StartContainer: %v", err)
-		err2 := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-			ID:    container.ID,
-			Force: true,
-		})
-		if err2 != nil {
-			log.Printf("NewNanny->StartContainer error killing container: %v", err2)
-		}
 		return nil, err
 	}
+	lifecycle.set("container.id", box.ID())
 
-	return &Nanny{
+	n = &Nanny{
 		Start:      time.Now(),
-		Container:  container,
+		Image:      problemType.Image,
+		Sandbox:    box,
 		ReportCard: NewReportCard(),
 		Input:      make(chan string),
 		Events:     make(chan *EventMessage),
 		Transcript: []*EventMessage{},
-	}, nil
+		span:       lifecycle,
+		done:       make(chan struct{}),
+	}
+	go n.watchDisk(Config().ContainerQuotaMB)
+	return n, nil
+}
+
+// Stop kills the container before its job finishes, recording the reason
+// for the report card. The job's commands fail as soon as the container dies.
+func (n *Nanny) Stop(reason string) {
+	n.stopLock.Lock()
+	if n.stopReason != "" {
+		n.stopLock.Unlock()
+		return
+	}
+	n.stopReason = reason
+	n.stopLock.Unlock()
+
+	if err := n.Sandbox.Kill(); err != nil {
+		log.Printf("error stopping container %s: %v", n.Sandbox.ID(), err)
+	}
+}
+
+// StopReason explains why the job was stopped, or is empty if it was not.
+func (n *Nanny) StopReason() string {
+	n.stopLock.Lock()
+	defer n.stopLock.Unlock()
+	return n.stopReason
 }
 
 func (n *Nanny) Shutdown() error {
+	close(n.done)
+	remove := n.span.child("container remove", spanInternal)
+	defer n.span.finish()
+	defer remove.finish()
+
 	// shut down the container
-	err := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
-		ID:    n.Container.ID,
-		Force: true,
-	})
-	if err != nil {
+	if err := n.Sandbox.Remove(); err != nil {
+		remove.fail(err)
 		log.Printf("Nanny.Shutdown: %v", err)
 		return err
 	}
@@ -333,6 +502,9 @@ func (n *Nanny) PutFiles(files map[string]string) error {
 	if len(files) == 0 {
 		return nil
 	}
+	if box, ok := n.Sandbox.(fileSandbox); ok {
+		return box.PutFiles(files)
+	}
 
 	// tar the files
 	now := time.Now()
@@ -367,29 +539,9 @@ func (n *Nanny) PutFiles(files map[string]string) error {
 	}
 
 	// exec tar in the container
-	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  true,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          false,
-		Cmd:          []string{"/bin/tar", "xf", "-"},
-		Container:    n.Container.ID,
-	})
-	if err != nil {
-		log.Printf("PutFiles: creating exec command: %v", err)
-		return err
-	}
 	out := new(bytes.Buffer)
-	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-		Detach:       false,
-		Tty:          false,
-		InputStream:  buf,
-		OutputStream: out,
-		ErrorStream:  out,
-		RawTerminal:  false,
-	})
-	if err != nil {
-		log.Printf("PutFiles: starting exec command: %v", err)
+	if _, err := n.Sandbox.Exec([]string{"/bin/tar", "xf", "-"}, buf, out, out); err != nil {
+		log.Printf("PutFiles: running tar: %v", err)
 		return err
 	}
 
@@ -407,32 +559,15 @@ func (n *Nanny) GetFiles(filenames []string) (map[string]string, error) {
 	if len(filenames) == 0 {
 		return nil, nil
 	}
+	if box, ok := n.Sandbox.(fileSandbox); ok {
+		return box.GetFiles(filenames)
+	}
 
 	// exec tar in the container
-	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  false,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          false,
-		Cmd:          append([]string{"/bin/tar", "cf", "-"}, filenames...),
-		Container:    n.Container.ID,
-	})
-	if err != nil {
-		log.Printf("GetFiles: creating exec command: %v", err)
-		return nil, err
-	}
 	tarFile := new(bytes.Buffer)
 	tarErr := new(bytes.Buffer)
-	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-		Detach:       false,
-		Tty:          false,
-		InputStream:  nil,
-		OutputStream: tarFile,
-		ErrorStream:  tarErr,
-		RawTerminal:  false,
-	})
-	if err != nil {
-		log.Printf("GetFiles: starting exec command: %v", err)
+	if _, err := n.Sandbox.Exec(append([]string{"/bin/tar", "cf", "-"}, filenames...), nil, tarFile, tarErr); err != nil {
+		log.Printf("GetFiles: running tar: %v", err)
 		return nil, err
 	}
 
@@ -532,52 +667,70 @@ func (n *Nanny) ExecNonInteractive(cmd []string) (stdout, stderr, script *bytes.
 		ExecCommand: cmd,
 	}
 
-	// create
-	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  false,
-		AttachStdout: true,
-		AttachStderr: true,
-		Tty:          false,
-		Cmd:          cmd,
-		Container:    n.Container.ID,
-	})
+	// gather output
+	var out execOutput
+	out.events = n.Events
+
+	status, err = n.Sandbox.Exec(cmd, nil, (*execStdout)(&out), (*execStderr)(&out))
 	if err != nil {
-		log.Printf("Nanny.ExecNonInteractive->docker.CreateExec: %v", err)
+		log.Printf("Nanny.ExecNonInteractive: %v", err)
 		return nil, nil, nil, -1, err
 	}
+	n.Events <- &EventMessage{
+		Time:       time.Now(),
+		Event:      "exit",
+		ExitStatus: fmt.Sprintf("exit status %d", status),
+	}
+	return &out.stdout, &out.stderr, &out.script, status, nil
+}
+
+// ExecInteractive runs a command in the container with stdin fed from
+// the client, streaming its output back as it runs.
+func (n *Nanny) ExecInteractive(cmd []string) (status int, err error) {
+	// log the event
+	n.Events <- &EventMessage{
+		Time:        time.Now(),
+		Event:       "exec",
+		ExecCommand: cmd,
+	}
 
 	// gather output
 	var out execOutput
 	out.events = n.Events
 
-	// start
-	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
-		Detach:       false,
-		Tty:          false,
-		InputStream:  nil,
-		OutputStream: (*execStdout)(&out),
-		ErrorStream:  (*execStderr)(&out),
-		RawTerminal:  false,
-	})
-	if err != nil {
-		log.Printf("Nanny.ExecNonInteractive->docker.StartExec: %v", err)
-		return nil, nil, nil, -1, err
-	}
+	// feed input from the client until the process exits
+	stdin, stdinWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case data := <-n.Input:
+				n.Events <- &EventMessage{
+					Time:       time.Now(),
+					Event:      "stdin",
+					StreamData: data,
+				}
+				if _, err := io.WriteString(stdinWriter, data); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
 
-	// inspect
-	inspect, err := dockerClient.InspectExec(exec.ID)
+	// run it
+	status, err = n.Sandbox.Exec(cmd, stdin, (*execStdout)(&out), (*execStderr)(&out))
+	close(done)
+	stdinWriter.Close()
 	if err != nil {
-		log.Printf("Nanny.ExecNonInteractive->docker.InspectExec: %v", err)
-		return nil, nil, nil, -1, err
+		log.Printf("Nanny.ExecInteractive: %v", err)
+		return -1, err
 	}
-	if inspect.Running {
-		log.Printf("Nanny.ExecNonInteractive: process still running")
-	} else {
-		n.Events <- &EventMessage{
-			Time:       time.Now(),
-			Event:      "exit",
-			ExitStatus: fmt.Sprintf("exit status %d", inspect.ExitCode),
-		}
+	n.Events <- &EventMessage{
+		Time:       time.Now(),
+		Event:      "exit",
+		ExitStatus: fmt.Sprintf("exit status %d", status),
 	}
-	return &out.stdout, &out.stderr, &out.script, inspect.ExitCode, nil
+	return status, nil
 }
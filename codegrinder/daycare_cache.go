@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/russross/codegrinder/sdk"
+	. "github.com/russross/codegrinder/types"
+)
+
+// cacheVolumePrefix starts the name of every build cache volume.
+const cacheVolumePrefix = "codegrinder-cache-"
+
+// shortImageID gives the start of an image ID without its algorithm prefix.
+func shortImageID(id string) string {
+	if i := strings.IndexByte(id, ':'); i >= 0 {
+		id = id[i+1:]
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+// cacheVolumeName names the volume holding one build cache directory of a
+// problem type. The image ID is part of the name, so a new image starts with
+// empty caches and never sees what an older toolchain left behind.
+func cacheVolumeName(imageID, problemType, dir string) string {
+	sum := sha256.Sum256([]byte(problemType + "\x00" + dir))
+	return fmt.Sprintf("%s%s-%x", cacheVolumePrefix, shortImageID(imageID), sum[:4])
+}
+
+// cacheBinds returns the binds that mount a problem type's build caches in a
+// container, along with the environment variable telling graders whether they
+// may write to them. Students' runs get the caches read-only so they cannot
+// plant anything for other students to pick up; runs confirming an author's
+// solution, which compile the same starter and test code students will, fill
+// them in.
+func cacheBinds(problemType *ProblemType, writable bool) (binds []string, env string, err error) {
+	if len(problemType.Cache) == 0 {
+		return nil, "", nil
+	}
+	info, err := dockerClient.InspectImage(problemType.Image)
+	if err != nil {
+		return nil, "", fmt.Errorf("error inspecting %s for its build cache: %v", problemType.Image, err)
+	}
+	mode, env := "ro", sdk.CacheVariable+"="+sdk.CacheReadOnly
+	if writable {
+		mode, env = "rw", sdk.CacheVariable+"="+sdk.CacheWritable
+	}
+	for _, dir := range problemType.Cache {
+		binds = append(binds, fmt.Sprintf("%s:%s:%s", cacheVolumeName(info.ID, problemType.Name, dir), dir, mode))
+	}
+	return binds, env, nil
+}
+
+// staleCacheVolume reports whether a volume is a build cache for an image that
+// is no longer on the host. Current caches are kept even while no container
+// is using them.
+func staleCacheVolume(volume docker.Volume, images map[string]bool) bool {
+	if !strings.HasPrefix(volume.Name, cacheVolumePrefix) {
+		return true
+	}
+	rest := strings.TrimPrefix(volume.Name, cacheVolumePrefix)
+	i := strings.IndexByte(rest, '-')
+	return i < 0 || !images[rest[:i]]
+}
+
+// localImageIDs returns the short IDs of every image on the host.
+func localImageIDs() (map[string]bool, error) {
+	list, err := dockerClient.ListImages(docker.ListImagesOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string]bool)
+	for _, image := range list {
+		images[shortImageID(image.ID)] = true
+	}
+	return images, nil
+}
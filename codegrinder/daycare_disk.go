@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// diskWatchInterval is how often a running container's disk use is checked.
+const diskWatchInterval = 2 * time.Second
+
+// watchDisk stops the container if it writes more than the disk quota,
+// so one runaway program cannot fill the disk that every grading job shares.
+// It runs until the nanny shuts down. Only Docker sandboxes are watched;
+// Kubernetes enforces the quota itself as an ephemeral storage limit.
+func (n *Nanny) watchDisk(quotaMB int) {
+	box, ok := n.Sandbox.(*dockerSandbox)
+	if quotaMB <= 0 || !ok {
+		return
+	}
+	quota := int64(quotaMB) * 1024 * 1024
+	ticker := time.NewTicker(diskWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		size, err := containerDiskUse(box.ID())
+		if err != nil {
+			log.Printf("error checking disk use of container %s: %v", box.ID(), err)
+			continue
+		}
+		if size > quota {
+			log.Printf("container %s wrote %d MB, more than its %d MB quota", box.ID(), size/(1024*1024), quotaMB)
+			n.Stop(fmt.Sprintf("your program wrote more than %d MB to disk and was stopped", quotaMB))
+			return
+		}
+	}
+}
+
+// containerDiskUse returns the bytes a container has written to its writable layer.
+func containerDiskUse(id string) (int64, error) {
+	list, err := dockerClient.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Size:    true,
+		Filters: map[string][]string{"id": {id}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(list) == 0 {
+		return 0, fmt.Errorf("container not found")
+	}
+	return list[0].SizeRw, nil
+}
+
+// isNannyContainer reports whether a container was created by the daycare.
+// Other containers on the host are never touched by cleanup.
+func isNannyContainer(container docker.APIContainers) bool {
+	for _, name := range container.Names {
+		if strings.HasPrefix(strings.TrimPrefix(name, "/"), "nanny-") {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneDockerLoop periodically removes what grading leaves behind, and prunes
+// early when the disk is under pressure.
+func pruneDockerLoop() {
+	last := time.Time{}
+	for {
+		interval := time.Duration(Config().PruneMinutes) * time.Minute
+		if time.Since(last) >= interval || diskUnderPressure() {
+			if err := pruneDocker(); err != nil {
+				log.Printf("error pruning docker: %v", err)
+			}
+			last = time.Now()
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// pruneDocker removes exited daycare containers, dangling images, and unused volumes.
+// Build caches are only removed once the image they were built with is gone.
+func pruneDocker() error {
+	containers, images, volumes := 0, 0, 0
+
+	list, err := dockerClient.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"status": {"exited", "dead", "created"}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, container := range list {
+		if !isNannyContainer(container) {
+			continue
+		}
+		if err := dockerClient.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true, RemoveVolumes: true}); err != nil {
+			log.Printf("error removing container %s: %v", container.ID, err)
+			continue
+		}
+		containers++
+	}
+
+	dangling, err := dockerClient.ListImages(docker.ListImagesOptions{Filters: map[string][]string{"dangling": {"true"}}})
+	if err != nil {
+		return err
+	}
+	for _, image := range dangling {
+		// images still used by a container cannot be removed, which is fine
+		if err := dockerClient.RemoveImage(image.ID); err == nil {
+			images++
+		}
+	}
+
+	unused, err := dockerClient.ListVolumes(docker.ListVolumesOptions{Filters: map[string][]string{"dangling": {"true"}}})
+	if err != nil {
+		return err
+	}
+	current, err := localImageIDs()
+	if err != nil {
+		return err
+	}
+	for _, volume := range unused {
+		if !staleCacheVolume(volume, current) {
+			continue
+		}
+		if err := dockerClient.RemoveVolume(volume.Name); err == nil {
+			volumes++
+		}
+	}
+
+	if containers+images+volumes > 0 {
+		log.Printf("pruned %d container%s, %d image%s, and %d volume%s",
+			containers, plural(containers), images, plural(images), volumes, plural(volumes))
+	}
+	return nil
+}
+
+// diskFreeMB returns the free space on the filesystem holding the path.
+func diskFreeMB(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("unable to stat filesystem for %s: %v", path, err)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize) / (1024 * 1024), nil
+}
+
+// diskUnderPressure reports whether free space is within twice the minimum
+// the readiness check demands, the point at which cleanup should not wait.
+func diskUnderPressure() bool {
+	free, err := diskFreeMB(Config().DiskCheckPath)
+	return err == nil && free < 2*int64(Config().DiskMinFreeMB)
+}
+
+// probeDockerDisk reports how much disk the daycare's containers are using
+// and fails when the disk is under pressure, so the daycare stops taking jobs
+// until cleanup catches up.
+func probeDockerDisk() (string, error) {
+	list, err := dockerClient.ListContainers(docker.ListContainersOptions{All: true, Size: true})
+	if err != nil {
+		return "", err
+	}
+	var used int64
+	running, exited := 0, 0
+	for _, container := range list {
+		if !isNannyContainer(container) {
+			continue
+		}
+		used += container.SizeRw
+		if container.State == "running" {
+			running++
+		} else {
+			exited++
+		}
+	}
+	free, err := diskFreeMB(Config().DiskCheckPath)
+	if err != nil {
+		return "", err
+	}
+	msg := fmt.Sprintf("%d running and %d exited container%s using %d MB, %d MB free",
+		running, exited, plural(running+exited), used/(1024*1024), free)
+	if diskUnderPressure() {
+		return "", fmt.Errorf("disk pressure: %s", msg)
+	}
+	return msg, nil
+}
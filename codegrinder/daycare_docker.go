@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/russross/codegrinder/types"
+)
+
+// dockerClient talks to the container engine, which is Docker or Podman.
+// Podman serves the same API, so the rest of the daycare does not need to know which.
+var dockerClient *docker.Client
+
+// containerEndpoint returns the API socket and version for the configured engine.
+// Rootless Podman listens on a socket in the runtime directory of the user running the daycare.
+func containerEndpoint() (endpoint, version string) {
+	endpoint, version = "unix:///var/run/docker.sock", "1.18"
+	if Config().GradingBackend == backendPodman {
+		// Podman only serves API versions that Docker 1.24 or later would
+		version = "1.40"
+		switch {
+		case os.Getuid() == 0:
+			endpoint = "unix:///run/podman/podman.sock"
+		case os.Getenv("XDG_RUNTIME_DIR") != "":
+			endpoint = "unix://" + os.Getenv("XDG_RUNTIME_DIR") + "/podman/podman.sock"
+		default:
+			endpoint = fmt.Sprintf("unix:///run/user/%d/podman/podman.sock", os.Getuid())
+		}
+	}
+	if Config().ContainerSocket != "" {
+		endpoint = "unix://" + Config().ContainerSocket
+	}
+	return endpoint, version
+}
+
+// containerEngineName is the name of the configured engine for messages.
+func containerEngineName() string {
+	if Config().GradingBackend == backendPodman {
+		return "Podman"
+	}
+	return "Docker"
+}
+
+// graderCapDrop lists the capabilities taken away from grading containers.
+var graderCapDrop = []string{
+	"NET_RAW",
+	"NET_BIND_SERVICE",
+	"AUDIT_READ",
+	"AUDIT_WRITE",
+	"DAC_OVERRIDE",
+	"SETFCAP",
+	"SETPCAP",
+	"SETGID",
+	"SETUID",
+	"MKNOD",
+	"CHOWN",
+	"FOWNER",
+	"FSETID",
+	"KILL",
+	"SYS_CHROOT",
+}
+
+// graderCapabilities lists the capabilities to take away from a problem type's
+// grading containers. Those that switch accounts keep what it takes to do so.
+func graderCapabilities(problemType *ProblemType) []string {
+	if !problemType.SwitchUser {
+		return graderCapDrop
+	}
+	var drop []string
+	for _, capability := range graderCapDrop {
+		if capability != "SETUID" && capability != "SETGID" {
+			drop = append(drop, capability)
+		}
+	}
+	return drop
+}
+
+// dockerSandbox is a container run by the local Docker daemon or Podman service.
+type dockerSandbox struct {
+	container *docker.Container
+}
+
+var getContainerIDRE = regexp.MustCompile(`The name .* is already in use by container (.*)\. You have to delete \(or rename\) that container to be able to reuse that name`)
+
+func getContainerID(msg string) string {
+	groups := getContainerIDRE.FindStringSubmatch(msg)
+	if len(groups) != 2 {
+		return ""
+	}
+	return groups[1]
+}
+
+func newDockerSandbox(problemType *ProblemType, env []string, name string, writeCache bool) (*dockerSandbox, error) {
+	binds, cacheEnv, err := cacheBinds(problemType, writeCache)
+	if err != nil {
+		log.Printf("NewNanny->cacheBinds: %v", err)
+		return nil, err
+	}
+	if cacheEnv != "" {
+		env = append(append([]string{}, env...), cacheEnv)
+	}
+
+	// create a container
+	mem := problemType.MaxMemory * 1024 * 1024
+	config := &docker.Config{
+		Hostname:        name,
+		Memory:          int64(mem),
+		MemorySwap:      -1,
+		NetworkDisabled: true,
+		Cmd:             []string{"/bin/sh", "-c", "sleep infinity"},
+		Image:           problemType.Image,
+		Env:             append([]string{}, env...),
+	}
+	hostConfig := &docker.HostConfig{
+		CapDrop: graderCapabilities(problemType),
+		Ulimits: []docker.ULimit{},
+		Binds:   binds,
+	}
+
+	container, err := dockerClient.CreateContainer(docker.CreateContainerOptions{Name: name, Config: config, HostConfig: hostConfig})
+	if err != nil {
+		if apiError, ok := err.(*docker.Error); ok && apiError.Status == http.StatusConflict {
+			// container already exists with that name--try killing it.
+			// Podman words the error differently, but both accept the name in place of the ID.
+			id := getContainerID(apiError.Message)
+			if id == "" {
+				id = name
+			}
+			err2 := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+				ID:    id,
+				Force: true,
+			})
+			if err2 != nil {
+				log.Printf("NewNanny->StartContainer error killing existing container: %v", err2)
+				return nil, err2
+			}
+
+			// try it one more time
+			container, err = dockerClient.CreateContainer(docker.CreateContainerOptions{Name: name, Config: config, HostConfig: hostConfig})
+		}
+		if err != nil {
+			log.Printf("NewNanny->CreateContainer: %#v", err)
+			return nil, err
+		}
+	}
+
+	// start it
+	err = dockerClient.StartContainer(container.ID, nil)
+	if err != nil {
+		log.Printf("NewNanny->StartContainer: %v", err)
+		err2 := dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+			ID:    container.ID,
+			Force: true,
+		})
+		if err2 != nil {
+			log.Printf("NewNanny->StartContainer error killing container: %v", err2)
+		}
+		return nil, err
+	}
+	return &dockerSandbox{container: container}, nil
+}
+
+func (d *dockerSandbox) ID() string {
+	return d.container.ID
+}
+
+func (d *dockerSandbox) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	// create
+	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+		Cmd:          cmd,
+		Container:    d.container.ID,
+	})
+	if err != nil {
+		log.Printf("dockerSandbox.Exec->docker.CreateExec: %v", err)
+		return -1, err
+	}
+
+	// start
+	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		Detach:       false,
+		Tty:          false,
+		InputStream:  stdin,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		RawTerminal:  false,
+	})
+	if err != nil {
+		log.Printf("dockerSandbox.Exec->docker.StartExec: %v", err)
+		return -1, err
+	}
+
+	// inspect
+	inspect, err := dockerClient.InspectExec(exec.ID)
+	if err != nil {
+		log.Printf("dockerSandbox.Exec->docker.InspectExec: %v", err)
+		return -1, err
+	}
+	if inspect.Running {
+		log.Printf("dockerSandbox.Exec: process still running")
+	}
+	return inspect.ExitCode, nil
+}
+
+func (d *dockerSandbox) Kill() error {
+	return dockerClient.KillContainer(docker.KillContainerOptions{ID: d.container.ID})
+}
+
+func (d *dockerSandbox) Remove() error {
+	return dockerClient.RemoveContainer(docker.RemoveContainerOptions{
+		ID:    d.container.ID,
+		Force: true,
+	})
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+// kubeServiceAccountDir holds the credentials Kubernetes gives to every pod.
+// The daycare uses them to talk to the cluster it is running in.
+const kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeStartTimeout is how long a grading pod has to start, including pulling its image.
+const kubeStartTimeout = 2 * time.Minute
+
+// kubeExecProtocol is the streaming protocol for commands run in a pod.
+// Version 5 is the first that lets the client close stdin, which tar needs.
+const kubeExecProtocol = "v5.channel.k8s.io"
+
+// kubeGradingLabel is the label on every grading job and pod, so a network policy
+// can cut them off from the network and an administrator can find them.
+const kubeGradingLabel = "app.kubernetes.io/component"
+
+// kubeClient talks to the Kubernetes API server using the pod's service account.
+type kubeClient struct {
+	server    string
+	namespace string
+	client    *http.Client
+	tlsConfig *tls.Config
+}
+
+var kubeCluster *kubeClient
+
+// mustConnectKube sets up the Kubernetes client from the service account
+// and makes sure the API server answers.
+func mustConnectKube() {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		log.Fatalf("the kubernetes grading backend must run in a pod in the cluster")
+	}
+	ca, err := ioutil.ReadFile(kubeServiceAccountDir + "/ca.crt")
+	if err != nil {
+		log.Fatalf("error reading the cluster CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		log.Fatalf("no certificates found in %s/ca.crt", kubeServiceAccountDir)
+	}
+	namespace := Config().KubeNamespace
+	if namespace == "" {
+		raw, err := ioutil.ReadFile(kubeServiceAccountDir + "/namespace")
+		if err != nil {
+			log.Fatalf("no KubeNamespace in the config file and unable to read the pod's namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(raw))
+	}
+	tlsConfig := &tls.Config{RootCAs: pool}
+	kubeCluster = &kubeClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		client:    &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 30 * time.Second},
+		tlsConfig: tlsConfig,
+	}
+	if _, err := probeKube(); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// token reads the service account token each time,
+// since Kubernetes replaces it before it expires.
+func (k *kubeClient) token() (string, error) {
+	raw, err := ioutil.ReadFile(kubeServiceAccountDir + "/token")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// do sends a request to the API server, decoding the response into out if it is not nil.
+func (k *kubeClient) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, k.server+path, body)
+	if err != nil {
+		return err
+	}
+	token, err := k.token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		status := new(kubeStatus)
+		if err := json.NewDecoder(resp.Body).Decode(status); err == nil && status.Message != "" {
+			return fmt.Errorf("%s %s: %s", method, path, status.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// kubeStatus is the result the API server gives for failed requests
+// and for commands that finish running in a pod.
+type kubeStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Details struct {
+		Causes []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"causes"`
+	} `json:"details"`
+}
+
+// kubePod is the part of a pod that the daycare looks at.
+type kubePod struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Status struct {
+		Phase             string `json:"phase"`
+		ContainerStatuses []struct {
+			Ready   bool   `json:"ready"`
+			Image   string `json:"image"`
+			ImageID string `json:"imageID"`
+			State   struct {
+				Waiting *struct {
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"waiting"`
+			} `json:"state"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// kubeSandbox is a Kubernetes job with a single pod.
+type kubeSandbox struct {
+	job string
+	pod string
+}
+
+var kubeNameRE = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// kubeJobName turns a nanny name into a job name Kubernetes accepts.
+// A random suffix keeps a job that is still being deleted from blocking a new one.
+func kubeJobName(name string) string {
+	name = strings.Trim(kubeNameRE.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("%s-%x", name, time.Now().UnixNano()&0xffffff)
+}
+
+func newKubeSandbox(problemType *ProblemType, env []string, name string) (*kubeSandbox, error) {
+	k := kubeCluster
+	job := kubeJobName(name)
+
+	var envVars []map[string]string
+	for _, elt := range env {
+		parts := strings.SplitN(elt, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		envVars = append(envVars, map[string]string{"name": parts[0], "value": parts[1]})
+	}
+	nodeSelector := make(map[string]string)
+	for _, label := range Config().KubeNodeLabels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) == 2 {
+			nodeSelector[parts[0]] = parts[1]
+		}
+	}
+	memory := fmt.Sprintf("%dMi", problemType.MaxMemory)
+	limits := map[string]string{"memory": memory}
+	if Config().ContainerQuotaMB > 0 {
+		limits["ephemeral-storage"] = fmt.Sprintf("%dMi", Config().ContainerQuotaMB)
+	}
+	labels := map[string]string{kubeGradingLabel: "codegrinder-grading"}
+
+	spec := map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": job, "labels": labels},
+		"spec": map[string]interface{}{
+			"backoffLimit":            0,
+			"activeDeadlineSeconds":   Config().KubeMaxMinutes * 60,
+			"ttlSecondsAfterFinished": 60,
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": labels},
+				"spec": map[string]interface{}{
+					"restartPolicy":                "Never",
+					"automountServiceAccountToken": false,
+					"enableServiceLinks":           false,
+					"hostname":                     job,
+					"nodeSelector":                 nodeSelector,
+					"containers": []map[string]interface{}{{
+						"name":    "grader",
+						"image":   problemType.Image,
+						"command": []string{"/bin/sh", "-c", "sleep infinity"},
+						"env":     envVars,
+						"resources": map[string]interface{}{
+							"requests": map[string]string{"cpu": Config().KubeCPU, "memory": memory},
+							"limits":   limits,
+						},
+						"securityContext": map[string]interface{}{
+							"allowPrivilegeEscalation": problemType.SwitchUser,
+							"capabilities":             map[string]interface{}{"drop": graderCapabilities(problemType)},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if err := k.do("POST", "/apis/batch/v1/namespaces/"+k.namespace+"/jobs", spec, nil); err != nil {
+		log.Printf("NewNanny->create job: %v", err)
+		return nil, err
+	}
+	box := &kubeSandbox{job: job}
+
+	// wait for its pod to start
+	deadline := time.Now().Add(kubeStartTimeout)
+	for {
+		pod, err := box.findPod()
+		if err == nil && pod != nil {
+			box.pod = pod.Metadata.Name
+			if pod.Status.Phase == "Running" && len(pod.Status.ContainerStatuses) > 0 && pod.Status.ContainerStatuses[0].Ready {
+				kubeSawToolchain(pod)
+				return box, nil
+			}
+			if pod.Status.Phase == "Failed" || pod.Status.Phase == "Succeeded" {
+				err = fmt.Errorf("grading pod %s stopped before it could be used", box.pod)
+			}
+			for _, status := range pod.Status.ContainerStatuses {
+				if w := status.State.Waiting; w != nil && (w.Reason == "ErrImagePull" || w.Reason == "ImagePullBackOff" || w.Reason == "InvalidImageName") {
+					err = fmt.Errorf("grading pod %s cannot get image %s: %s", box.pod, problemType.Image, w.Message)
+				}
+			}
+		}
+		if err == nil && time.Now().After(deadline) {
+			err = fmt.Errorf("grading pod for job %s did not start within %v", job, kubeStartTimeout)
+		}
+		if err != nil {
+			log.Printf("NewNanny->start pod: %v", err)
+			if err2 := box.Remove(); err2 != nil {
+				log.Printf("NewNanny->start pod error removing job: %v", err2)
+			}
+			return nil, err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// findPod returns the job's pod, or nil if it has not been created yet.
+func (box *kubeSandbox) findPod() (*kubePod, error) {
+	k := kubeCluster
+	var list struct {
+		Items []*kubePod `json:"items"`
+	}
+	selector := url.QueryEscape("job-name=" + box.job)
+	if err := k.do("GET", "/api/v1/namespaces/"+k.namespace+"/pods?labelSelector="+selector, nil, &list); err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+	return list.Items[0], nil
+}
+
+func (box *kubeSandbox) ID() string {
+	return box.job
+}
+
+// Exec runs a command in the pod over a websocket. Each message starts with a byte
+// naming the stream: 0 for stdin, 1 for stdout, 2 for stderr, 3 for the final status,
+// and 255 to close a stream.
+func (box *kubeSandbox) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	k := kubeCluster
+	params := url.Values{}
+	params.Set("container", "grader")
+	params.Set("stdout", "true")
+	params.Set("stderr", "true")
+	if stdin != nil {
+		params.Set("stdin", "true")
+	}
+	for _, arg := range cmd {
+		params.Add("command", arg)
+	}
+	u := "wss" + strings.TrimPrefix(k.server, "https") + "/api/v1/namespaces/" + k.namespace + "/pods/" + box.pod + "/exec?" + params.Encode()
+	token, err := k.token()
+	if err != nil {
+		return -1, err
+	}
+	dialer := &websocket.Dialer{TLSClientConfig: k.tlsConfig, Subprotocols: []string{kubeExecProtocol}}
+	conn, _, err := dialer.Dial(u, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		log.Printf("kubeSandbox.Exec: connecting: %v", err)
+		return -1, err
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != kubeExecProtocol {
+		return -1, fmt.Errorf("the Kubernetes API server does not support %s; version 1.30 or later is required", kubeExecProtocol)
+	}
+
+	// feed stdin, then close it
+	if stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				if n > 0 {
+					if conn.WriteMessage(websocket.BinaryMessage, append([]byte{0}, buf[:n]...)) != nil {
+						return
+					}
+				}
+				if err != nil {
+					conn.WriteMessage(websocket.BinaryMessage, []byte{255, 0})
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return -1, fmt.Errorf("command stream ended without a status: %v", err)
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case 1:
+			stdout.Write(msg[1:])
+		case 2:
+			stderr.Write(msg[1:])
+		case 3:
+			return kubeExitStatus(msg[1:])
+		}
+	}
+}
+
+// kubeExitStatus gets the exit status from the status message at the end of a command.
+func kubeExitStatus(raw []byte) (int, error) {
+	status := new(kubeStatus)
+	if err := json.Unmarshal(raw, status); err != nil {
+		return -1, fmt.Errorf("unable to parse command status: %v", err)
+	}
+	if status.Status == "Success" {
+		return 0, nil
+	}
+	if status.Reason == "NonZeroExitCode" {
+		for _, cause := range status.Details.Causes {
+			if cause.Reason == "ExitCode" {
+				if code, err := strconv.Atoi(cause.Message); err == nil {
+					return code, nil
+				}
+			}
+		}
+	}
+	return -1, fmt.Errorf("command failed: %s", status.Message)
+}
+
+func (box *kubeSandbox) Kill() error {
+	if box.pod == "" {
+		return nil
+	}
+	k := kubeCluster
+	return k.do("DELETE", "/api/v1/namespaces/"+k.namespace+"/pods/"+box.pod+"?gracePeriodSeconds=0", nil, nil)
+}
+
+// Remove deletes the job along with its pod. If the daycare dies before it gets
+// here, Kubernetes stops the job at KubeMaxMinutes and deletes it soon after.
+func (box *kubeSandbox) Remove() error {
+	k := kubeCluster
+	return k.do("DELETE", "/apis/batch/v1/namespaces/"+k.namespace+"/jobs/"+box.job+"?propagationPolicy=Background&gracePeriodSeconds=0", nil, nil)
+}
+
+// probeKube checks that the API server answers and lets the daycare list pods.
+func probeKube() (string, error) {
+	if kubeCluster == nil {
+		return "", fmt.Errorf("no kubernetes client configured")
+	}
+	k := kubeCluster
+	var list struct {
+		Items []*kubePod `json:"items"`
+	}
+	selector := url.QueryEscape(kubeGradingLabel + "=codegrinder-grading")
+	if err := k.do("GET", "/api/v1/namespaces/"+k.namespace+"/pods?labelSelector="+selector, nil, &list); err != nil {
+		return "", fmt.Errorf("kubernetes API check failed: %v", err)
+	}
+	return fmt.Sprintf("%d grading pod%s running in namespace %s", len(list.Items), plural(len(list.Items)), k.namespace), nil
+}
+
+// kubeToolchains records the image each toolchain resolved to the last time a
+// grading pod started, since the daycare cannot inspect images on cluster nodes.
+var kubeToolchains = make(map[string]*Toolchain)
+var kubeToolchainsLock sync.Mutex
+
+func kubeSawToolchain(pod *kubePod) {
+	kubeToolchainsLock.Lock()
+	defer kubeToolchainsLock.Unlock()
+	for _, status := range pod.Status.ContainerStatuses {
+		id := status.ImageID
+		if i := strings.LastIndex(id, "@"); i >= 0 {
+			id = id[i+1:]
+		}
+		kubeToolchains[status.Image] = &Toolchain{Image: status.Image, ImageID: id}
+	}
+}
+
+// inspectKubeToolchain returns the image a toolchain resolved to in the most recent grading pod.
+func inspectKubeToolchain(image string) (*Toolchain, error) {
+	kubeToolchainsLock.Lock()
+	defer kubeToolchainsLock.Unlock()
+	toolchain, exists := kubeToolchains[image]
+	if !exists {
+		return nil, fmt.Errorf("no grading pod has run %s yet", image)
+	}
+	found := *toolchain
+	return &found, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// sandbox is an isolated place to run student code for one grading run.
+// The daycare creates one per nanny using the configured GradingBackend.
+type sandbox interface {
+	// ID names the sandbox in log messages.
+	ID() string
+
+	// Exec runs a command in the sandbox and returns its exit status.
+	// stdin may be nil, in which case the command gets no input.
+	Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
+	// Kill stops everything running in the sandbox. It can only be removed after that.
+	Kill() error
+
+	// Remove tears down the sandbox and everything in it.
+	Remove() error
+}
+
+// fileSandbox is a sandbox that copies files itself
+// instead of having tar run inside it.
+type fileSandbox interface {
+	PutFiles(files map[string]string) error
+	GetFiles(names []string) (map[string]string, error)
+}
+
+// Grading backends, chosen by Config.GradingBackend.
+const (
+	backendDocker     = "docker"
+	backendPodman     = "podman"
+	backendKubernetes = "kubernetes"
+)
+
+// newSandbox starts a sandbox running the problem type's image.
+// Problem types for another platform or that require special hardware
+// go to a runner agent instead.
+// env holds environment variables in NAME=value form. writeCache lets the run
+// add to the problem type's build caches, which only Docker and Podman keep.
+func newSandbox(problemType *ProblemType, env []string, name string, writeCache bool) (sandbox, error) {
+	if problemType.Platform != "" || len(problemType.Requires) > 0 {
+		return newRunnerSandbox(problemType, env, name)
+	}
+	switch Config().GradingBackend {
+	case backendDocker, backendPodman:
+		return newDockerSandbox(problemType, env, name, writeCache)
+	case backendKubernetes:
+		return newKubeSandbox(problemType, env, name)
+	default:
+		return nil, fmt.Errorf("unknown GradingBackend %q", Config().GradingBackend)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	. "github.com/russross/codegrinder/types"
+)
+
+// statsTimeout is how long to wait for docker to report a container's resource use.
+const statsTimeout = 10 * time.Second
+
+// measureResources reports the CPU time and peak memory a container has used so far.
+// It must be called before the container is removed. Docker only reports peak memory
+// on hosts with cgroup v1; elsewhere the memory in use at the end is reported instead.
+// Only the run time is known for sandboxes that are not Docker containers.
+func (n *Nanny) measureResources() (*ResourceUsage, error) {
+	usage := &ResourceUsage{Runtime: time.Since(n.Start)}
+	box, ok := n.Sandbox.(*dockerSandbox)
+	if !ok {
+		return usage, nil
+	}
+	stats := make(chan *docker.Stats, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- dockerClient.Stats(docker.StatsOptions{
+			ID:      box.ID(),
+			Stats:   stats,
+			Stream:  false,
+			Timeout: statsTimeout,
+		})
+	}()
+	for elt := range stats {
+		usage.CPUSeconds = float64(elt.CPUStats.CPUUsage.TotalUsage) / float64(time.Second)
+		memory := elt.MemoryStats.MaxUsage
+		if memory == 0 {
+			memory = elt.MemoryStats.Usage
+		}
+		usage.MaxMemoryMB = float64(memory) / (1024.0 * 1024.0)
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// currentDependencyImagesQuery selects the dependency snapshots built on the
+// expected image for each problem type.
+const currentDependencyImagesQuery = `SELECT dependency_images.* FROM dependency_images ` +
+	`JOIN (` + latestToolchainImagesQuery + `) AS latest ` +
+	`ON dependency_images.problem_type = latest.problem_type AND dependency_images.base_image_id = latest.image_id ` +
+	`ORDER BY dependency_images.key`
+
+// dependencyImageName is the name a daycare gives the snapshot with the given
+// key, built on a problem type's image.
+func dependencyImageName(image, key string) string {
+	return imageRepository(image) + "-deps:" + key
+}
+
+// withDependencies returns the problem type to grade a step with. If the step's
+// files list dependencies, the image is the snapshot with them installed.
+// A snapshot the daycare does not have is only built on the spot for an
+// author confirming a new problem, since nothing could have built it yet.
+func withDependencies(problemType *ProblemType, files map[string]string, build bool) (*ProblemType, error) {
+	if problemType.Dependencies == nil {
+		return problemType, nil
+	}
+	if Config().GradingBackend == backendKubernetes {
+		return nil, fmt.Errorf("problem type %s installs dependencies, which needs Docker or Podman", problemType.Name)
+	}
+	base, err := inspectToolchain(problemType.Image)
+	if err != nil {
+		return nil, err
+	}
+	key := problemType.Dependencies.Key(base.ImageID, files)
+	if key == "" {
+		return problemType, nil
+	}
+	name := dependencyImageName(problemType.Image, key)
+	if _, err := inspectToolchain(name); err != nil {
+		if !build {
+			return nil, fmt.Errorf("the dependencies this problem lists are not installed on the daycare yet; " +
+				"they are built by codegrinder images deps and installed by codegrinder images pull")
+		}
+		var output bytes.Buffer
+		if err := buildDependencySnapshot(problemType, key, files, &output); err != nil {
+			return nil, fmt.Errorf("error installing the dependencies this problem lists: %v\n%s", err, output.String())
+		}
+	}
+	elt := *problemType
+	elt.Image = name
+	return &elt, nil
+}
+
+func commandImagesDeps(args []string) {
+	fs := flag.NewFlagSet("images deps", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("usage: codegrinder images deps")
+	}
+
+	mustConnectDocker()
+	auth := registryAuth()
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+
+	expected := []*ToolchainImage{}
+	if err := meddler.QueryAll(db, &expected, latestToolchainImagesQuery); err != nil {
+		log.Fatalf("db error: %v", err)
+	}
+	built, failures := 0, 0
+	for _, image := range expected {
+		problemType := problemTypes()[image.ProblemType]
+		if problemType == nil || problemType.Dependencies == nil {
+			continue
+		}
+
+		// snapshots are built on the image daycares are expected to run
+		if current, err := inspectToolchain(problemType.Image); err != nil || current.ImageID != image.ImageID {
+			log.Printf("skipping %s: %s here is not the expected image; run codegrinder images pull first", image.ProblemType, problemType.Image)
+			failures++
+			continue
+		}
+
+		steps := []*ProblemStep{}
+		if err := meddler.QueryAll(db, &steps, `SELECT problem_steps.* FROM problem_steps `+
+			`JOIN problems ON problem_steps.problem_id = problems.id WHERE problems.problem_type = $1 `+
+			`ORDER BY problem_steps.problem_id, problem_steps.step`, image.ProblemType); err != nil {
+			log.Fatalf("db error: %v", err)
+		}
+		seen := make(map[string]bool)
+		for _, step := range steps {
+			key := problemType.Dependencies.Key(image.ImageID, step.Files)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			var count int
+			if err := db.QueryRow(`SELECT COUNT(1) FROM dependency_images WHERE key = $1`, key).Scan(&count); err != nil {
+				log.Fatalf("db error: %v", err)
+			}
+			if count > 0 {
+				continue
+			}
+			if err := buildDependencyImage(db, auth, problemType, image.ImageID, key, step.Files); err != nil {
+				log.Printf("error building dependencies of problem %d step %d: %v", step.ProblemID, step.Step, err)
+				failures++
+				continue
+			}
+			built++
+		}
+	}
+	if failures > 0 {
+		log.Fatalf("built %d dependency snapshot%s, %d failed", built, plural(built), failures)
+	}
+	log.Printf("built %d dependency snapshot%s", built, plural(built))
+}
+
+// dependencyContext returns a build context for a snapshot: a Dockerfile that
+// copies the dependency files into the working directory of the problem type's
+// image and runs its install command there.
+func dependencyContext(problemType *ProblemType, key string, files map[string]string) (*bytes.Buffer, error) {
+	var dockerfile strings.Builder
+	fmt.Fprintf(&dockerfile, "FROM %s\n", problemType.Image)
+	var names []string
+	for _, name := range problemType.Dependencies.Files {
+		if _, exists := files[name]; exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		srcDst, err := json.Marshal([]string{name, name})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&dockerfile, "COPY %s\n", srcDst)
+	}
+	install, err := json.Marshal(problemType.Dependencies.Install)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&dockerfile, "RUN %s\n", install)
+	fmt.Fprintf(&dockerfile, "LABEL codegrinder.dependencies=%q\n", key)
+
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	add := func(name, contents string) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write([]byte(contents))
+		return err
+	}
+	if err := add("Dockerfile", dockerfile.String()); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := add(name, files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// buildDependencySnapshot builds a snapshot under the name daycares use for it.
+func buildDependencySnapshot(problemType *ProblemType, key string, files map[string]string, output io.Writer) error {
+	buildContext, err := dependencyContext(problemType, key, files)
+	if err != nil {
+		return err
+	}
+	local := dependencyImageName(problemType.Image, key)
+	log.Printf("building %s for %s", local, problemType.Name)
+	return dockerClient.BuildImage(docker.BuildImageOptions{
+		Name:           local,
+		InputStream:    buildContext,
+		RmTmpContainer: true,
+		OutputStream:   output,
+	})
+}
+
+// buildDependencyImage builds, scans, and pushes one snapshot and records it.
+func buildDependencyImage(db *sql.DB, auth docker.AuthConfiguration, problemType *ProblemType, baseImageID, key string, files map[string]string) error {
+	if err := buildDependencySnapshot(problemType, key, files, os.Stdout); err != nil {
+		return err
+	}
+	local := dependencyImageName(problemType.Image, key)
+
+	note := ""
+	if Config().ImageScanCommand != "" {
+		fields := strings.Fields(Config().ImageScanCommand)
+		log.Printf("scanning %s with %s", local, fields[0])
+		scan := exec.Command(fields[0], append(fields[1:], local)...)
+		scan.Stdout, scan.Stderr = os.Stdout, os.Stderr
+		if err := scan.Run(); err != nil {
+			return fmt.Errorf("scan of %s failed (%v); it was not pushed", local, err)
+		}
+		note = "passed " + fields[0]
+	}
+
+	remote := Config().ImageRegistry + "/" + path.Base(imageRepository(problemType.Image)) + "-deps"
+	if err := dockerClient.TagImage(local, docker.TagImageOptions{Repo: remote, Tag: key, Force: true}); err != nil {
+		return err
+	}
+	log.Printf("pushing %s:%s", remote, key)
+	if err := dockerClient.PushImage(docker.PushImageOptions{Name: remote, Tag: key, OutputStream: os.Stdout}, auth); err != nil {
+		return err
+	}
+	info, err := dockerClient.InspectImage(local)
+	if err != nil {
+		return err
+	}
+	reference := remote + ":" + key
+	for _, digest := range info.RepoDigests {
+		if strings.HasPrefix(digest, remote+"@") {
+			reference = digest
+		}
+	}
+	if note != "" {
+		log.Printf("%s %s", reference, note)
+	}
+	image := &DependencyImage{
+		Key:         key,
+		ProblemType: problemType.Name,
+		BaseImageID: baseImageID,
+		ImageID:     info.ID,
+		Reference:   reference,
+		CreatedAt:   time.Now(),
+	}
+	return meddler.Insert(db, "dependency_images", image)
+}
+
+// GetDependencyImages handles /v2/dependency_images requests,
+// returning the dependency snapshots every daycare is expected to have.
+func GetDependencyImages(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	images := []*DependencyImage{}
+	if err := meddler.QueryAll(tx, &images, currentDependencyImagesQuery); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, images)
+}
+
+// pullDependencyImages installs the dependency snapshots the daycare does not
+// have yet, returning the number that could not be installed.
+func pullDependencyImages(auth docker.AuthConfiguration) int {
+	images := []*DependencyImage{}
+	if err := fetchFromTA("/v2/dependency_images", &images); err != nil {
+		log.Printf("error fetching dependency snapshots: %v", err)
+		return 1
+	}
+	failures := 0
+	for _, image := range images {
+		problemType := problemTypes()[image.ProblemType]
+		if problemType == nil {
+			continue
+		}
+		name := dependencyImageName(problemType.Image, image.Key)
+		if current, err := inspectToolchain(name); err == nil && current.ImageID == image.ImageID {
+			continue
+		}
+		log.Printf("pulling %s for %s", image.Reference, image.ProblemType)
+		if err := installImage(image.Reference, name, image.ImageID, auth); err != nil {
+			log.Printf("%v", err)
+			failures++
+		}
+	}
+	return failures
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// institutionNameLimit is the longest name a school can register with.
+	institutionNameLimit = 100
+
+	// institutionRegistrationLimit is how many registrations one address can
+	// attempt in each institutionRegistrationWindow.
+	institutionRegistrationLimit  = 5
+	institutionRegistrationWindow = time.Hour
+)
+
+// registrationAttempts records when each remote address last tried to register a school.
+var registrationAttempts = struct {
+	sync.Mutex
+	times map[string][]time.Time
+}{times: make(map[string][]time.Time)}
+
+// allowRegistration records an attempt from a remote address,
+// returning false if it has made too many recently.
+func allowRegistration(remoteAddr string, now time.Time) bool {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	registrationAttempts.Lock()
+	defer registrationAttempts.Unlock()
+
+	// forget attempts that have left the window, from every address
+	for addr, times := range registrationAttempts.times {
+		recent := times[:0]
+		for _, at := range times {
+			if now.Sub(at) < institutionRegistrationWindow {
+				recent = append(recent, at)
+			}
+		}
+		if len(recent) == 0 {
+			delete(registrationAttempts.times, addr)
+		} else {
+			registrationAttempts.times[addr] = recent
+		}
+	}
+	if len(registrationAttempts.times[ip]) >= institutionRegistrationLimit {
+		return false
+	}
+	registrationAttempts.times[ip] = append(registrationAttempts.times[ip], now)
+	return true
+}
+
+// publicOnlyDialer refuses to connect to loopback, private, and other
+// non-public addresses. It checks the address each hostname resolved to,
+// so a name cannot be pointed at an internal host after it is looked up.
+var publicOnlyDialer = &net.Dialer{
+	Timeout: 10 * time.Second,
+	Control: func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+			ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+			return fmt.Errorf("%s is not a public address", host)
+		}
+		return nil
+	},
+}
+
+// validHostname reports whether host is a DNS name, with an optional port.
+func validHostname(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" || len(host) > 253 || !strings.Contains(host, ".") {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GetInstitutions handles /v2/institutions requests,
+// returning the approved schools in the discovery index. No login is needed.
+//
+// If parameter school=<...> is present, results will be filtered by case-insensitive substring match on Name field.
+func GetInstitutions(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	if !Config().Discovery {
+		loggedHTTPErrorf(w, http.StatusNotFound, "this server does not keep a discovery index")
+		return
+	}
+	where, args := addWhereEq("", nil, "approved", true)
+	if school := r.FormValue("school"); school != "" {
+		where, args = addWhereLike(where, args, "name", school)
+	}
+	institutions := []*Institution{}
+	if err := meddler.QueryAll(tx, &institutions, `SELECT * FROM institutions`+where+` ORDER BY name`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, elt := range institutions {
+		elt.Contact = ""
+	}
+	render.JSON(http.StatusOK, institutions)
+}
+
+// GetInstitutionRegistrations handles /v2/institutions/registrations requests,
+// returning every school in the discovery index, including those waiting for approval.
+func GetInstitutionRegistrations(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	institutions := []*Institution{}
+	if err := meddler.QueryAll(tx, &institutions, `SELECT * FROM institutions ORDER BY approved, name`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, institutions)
+}
+
+// PostInstitution handles /v2/institutions requests,
+// registering a school's server in the discovery index. No login is needed, but the
+// server must answer at the hostname over https, and the school is only listed once
+// an administrator approves it. Each address can only try a few times an hour,
+// and the hostname must resolve to a public address.
+func PostInstitution(w http.ResponseWriter, r *http.Request, db *sql.DB, tenant *TenantConfig, institution Institution, render render.Render) {
+	if !Config().Discovery {
+		loggedHTTPErrorf(w, http.StatusNotFound, "this server does not keep a discovery index")
+		return
+	}
+	if !allowRegistration(r.RemoteAddr, time.Now()) {
+		loggedHTTPErrorf(w, http.StatusTooManyRequests, "too many registrations from this address; try again later")
+		return
+	}
+	institution.Name = strings.TrimSpace(institution.Name)
+	institution.Hostname = strings.ToLower(strings.TrimSpace(institution.Hostname))
+	if institution.Name == "" || len(institution.Name) > institutionNameLimit {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "name must be given, with at most %d characters", institutionNameLimit)
+		return
+	}
+	if !validHostname(institution.Hostname) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "hostname must be the DNS name of the server, like codegrinder.example.edu")
+		return
+	}
+	if _, err := mail.ParseAddress(institution.Contact); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "contact must be an email address for the administrator of the server: %v", err)
+		return
+	}
+
+	// make sure a CodeGrinder server answers there, before taking a transaction
+	u := &url.URL{Scheme: "https", Host: institution.Hostname, Path: "/v2/version"}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{DialContext: publicOnlyDialer.DialContext}}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unable to reach %s: %v", u, err)
+		return
+	}
+	defer resp.Body.Close()
+	version := new(Version)
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(version) != nil || version.Version == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%s did not answer like a CodeGrinder server", u)
+		return
+	}
+
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM institutions WHERE hostname = $1)`, institution.Hostname).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return httpErrorf(http.StatusConflict, "%s is already registered", institution.Hostname)
+		}
+		now := time.Now()
+		institution.ID = 0
+		institution.Approved = false
+		institution.CreatedAt = now
+		institution.UpdatedAt = now
+		return meddler.Insert(tx, "institutions", &institution)
+	})
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, &institution)
+}
+
+// PutInstitution handles /v2/institutions/:institution_id requests,
+// changing or approving a school in the discovery index.
+func PutInstitution(w http.ResponseWriter, tx *sql.Tx, params martini.Params, institution Institution, render render.Render) {
+	institutionID, err := parseID(w, "institution_id", params["institution_id"])
+	if err != nil {
+		return
+	}
+	old := new(Institution)
+	if err := meddler.Load(tx, "institutions", old, institutionID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	institution.Name = strings.TrimSpace(institution.Name)
+	institution.Hostname = strings.ToLower(strings.TrimSpace(institution.Hostname))
+	if institution.Name == "" || len(institution.Name) > institutionNameLimit {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "name must be given, with at most %d characters", institutionNameLimit)
+		return
+	}
+	if !validHostname(institution.Hostname) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "hostname must be the DNS name of the server, like codegrinder.example.edu")
+		return
+	}
+	institution.ID = old.ID
+	institution.CreatedAt = old.CreatedAt
+	institution.UpdatedAt = time.Now()
+	if err := meddler.Update(tx, "institutions", &institution); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, &institution)
+}
+
+// DeleteInstitution handles /v2/institutions/:institution_id requests,
+// removing a school from the discovery index.
+func DeleteInstitution(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	institutionID, err := parseID(w, "institution_id", params["institution_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM institutions WHERE id = $1`, institutionID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	emailSecretHeader = "X-Gateway-Secret"
+	emailMaxSize      = 10 << 20 // largest message the gateway accepts, attachments included
+	emailMaxFiles     = 50       // most attachments the gateway accepts in one message
+	emailKeyLength    = 16       // characters in a student's email submission key
+)
+
+// emailKey derives the key a student puts in the subject line of an email submission.
+// Together with the sender address, it keeps others from submitting in their name.
+func emailKey(userID int64) string {
+	mac := hmac.New(sha256.New, []byte("email submissions\n"+Config().DaycareSecret))
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	key := base32.StdEncoding.EncodeToString(mac.Sum(nil))
+	return strings.ToLower(key[:emailKeyLength])
+}
+
+// GetUserMeEmailGateway handles /v2/users/me/email_gateway requests,
+// returning the address and key the current user can use to submit work by email.
+func GetUserMeEmailGateway(w http.ResponseWriter, currentUser *User, render render.Render) {
+	if Config().EmailGateway == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "this server does not accept work by email")
+		return
+	}
+	render.JSON(http.StatusOK, &EmailGateway{Address: Config().EmailGateway, Key: emailKey(currentUser.ID)})
+}
+
+// GetUserMeEmailSubmissions handles /v2/users/me/email_submissions requests,
+// returning the work the current user has sent by email, newest first, without the files.
+func GetUserMeEmailSubmissions(w http.ResponseWriter, tx *sql.Tx, currentUser *User, render render.Render) {
+	submissions := []*EmailSubmission{}
+	if err := meddler.QueryAll(tx, &submissions, `SELECT * FROM email_submissions WHERE user_id = $1 ORDER BY created_at DESC`, currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, elt := range submissions {
+		elt.Files = nil
+	}
+	render.JSON(http.StatusOK, submissions)
+}
+
+// PostEmailGateway handles /v2/email_gateway requests from the inbound mail service,
+// which posts each message it receives in raw RFC 5322 form. The subject line must
+// hold the sender's email submission key and the assignment, given as an assignment ID
+// or as course/problem-set, followed by /problem for a problem set with several problems.
+// The attachments are held for an instructor to approve.
+func PostEmailGateway(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	if Config().EmailGateway == "" || Config().EmailSecret == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the email gateway is off")
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get(emailSecretHeader)), []byte(Config().EmailSecret)) {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "bad or missing %s header", emailSecretHeader)
+		return
+	}
+	msg, err := mail.ReadMessage(io.LimitReader(r.Body, emailMaxSize))
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error reading message: %v", err)
+		return
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error reading sender: %v", err)
+		return
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	// the sender must be a user, and the key must be theirs
+	user := new(User)
+	if err := meddler.QueryRow(tx, user, `SELECT * FROM users WHERE lower(email) = lower($1)`, from.Address); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusForbidden, "%s is not a known user", from.Address)
+			return
+		}
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	var name string
+	keyed := false
+	for _, field := range strings.Fields(subject) {
+		switch {
+		case hmac.Equal([]byte(strings.ToLower(field)), []byte(emailKey(user.ID))):
+			keyed = true
+		case name == "" && (strings.Contains(field, "/") || isDigits(field)):
+			name = field
+		}
+	}
+	if !keyed {
+		loggedHTTPErrorf(w, http.StatusForbidden, "message from %s does not have the sender's email submission key in the subject", from.Address)
+		return
+	}
+	if name == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the subject must name the assignment")
+		return
+	}
+	asst, prefix, err := findEmailAssignment(tx, user, name)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	files := make(map[string]string)
+	if err := emailAttachments(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), "", msg.Body, files); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error reading attachments: %v", err)
+		return
+	}
+	if len(files) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the message has no attachments")
+		return
+	}
+	if prefix != "" {
+		named := make(map[string]string)
+		for name, contents := range files {
+			named[prefix+name] = contents
+		}
+		files = named
+	}
+
+	// make sure the files would be graded before holding them
+	now := time.Now()
+	commits, err := snapshotCommits(now, tx, asst, files, "")
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if len(commits) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the attachments are not for any problem in the assignment; name the problem in the subject as course/problem-set/problem")
+		return
+	}
+
+	submission := &EmailSubmission{
+		CourseID:     asst.CourseID,
+		AssignmentID: asst.ID,
+		UserID:       user.ID,
+		Sender:       from.Address,
+		Subject:      subject,
+		Files:        files,
+		Status:       "pending",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := meddler.Insert(tx, "email_submissions", submission); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("email submission %d from %s for assignment %d is waiting for an instructor", submission.ID, from.Address, asst.ID)
+	submission.Files = nil
+	render.JSON(http.StatusOK, submission)
+}
+
+func isDigits(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// findEmailAssignment finds the user's assignment named in an email subject,
+// returning it with the directory prefix for the problem if one was named.
+func findEmailAssignment(tx *sql.Tx, user *User, name string) (*Assignment, string, error) {
+	parts := strings.Split(name, "/")
+	asst := new(Assignment)
+	var err error
+	problem := ""
+	switch {
+	case isDigits(parts[0]) && len(parts) <= 2:
+		err = meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, parts[0], user.ID)
+		if len(parts) == 2 {
+			problem = parts[1]
+		}
+	case len(parts) == 2 || len(parts) == 3:
+		err = meddler.QueryRow(tx, asst, `SELECT assignments.* FROM assignments `+
+			`JOIN courses ON assignments.course_id = courses.id `+
+			`JOIN problem_sets ON assignments.problem_set_id = problem_sets.id `+
+			`WHERE assignments.user_id = $1 AND courses.lti_label = $2 AND problem_sets.unique_id = $3 `+
+			`ORDER BY assignments.id DESC LIMIT 1`, user.ID, parts[0], parts[1])
+		if len(parts) == 3 {
+			problem = parts[2]
+		}
+	default:
+		return nil, "", httpErrorf(http.StatusBadRequest, "assignment %q must be an ID or course/problem-set", name)
+	}
+	if err == sql.ErrNoRows {
+		return nil, "", httpErrorf(http.StatusNotFound, "%s has no assignment %s", user.Email, name)
+	} else if err != nil {
+		return nil, "", httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if asst.IsDropped() {
+		return nil, "", httpErrorf(http.StatusForbidden, "%s is no longer enrolled in this course", user.Email)
+	}
+	if problem != "" {
+		return asst, problem + "/", nil
+	}
+	return asst, "", nil
+}
+
+// emailAttachments gathers the attached files from a message part, descending into
+// multipart sections. Only the base name of each file is kept.
+func emailAttachments(contentType, encoding, filename string, body io.Reader, files map[string]string) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := emailAttachments(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part.FileName(), part, files); err != nil {
+				return err
+			}
+		}
+	}
+	if filename == "" {
+		filename = params["name"]
+	}
+	if filename == "" {
+		// the message text
+		return nil
+	}
+	if strings.EqualFold(strings.TrimSpace(encoding), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	contents, err := ioutil.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(files) >= emailMaxFiles {
+		return fmt.Errorf("more than %d attachments", emailMaxFiles)
+	}
+	files[path.Base(strings.Replace(filename, "\\", "/", -1))] = string(contents)
+	return nil
+}
+
+// GetCourseEmailSubmissions handles /v2/courses/:course_id/email_submissions requests,
+// returning the work sent by email in the course, with pending submissions first.
+func GetCourseEmailSubmissions(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	submissions := []*EmailSubmission{}
+	if err := meddler.QueryAll(tx, &submissions, `SELECT * FROM email_submissions WHERE course_id = $1 `+
+		`ORDER BY status = 'pending' DESC, created_at DESC`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, submissions)
+}
+
+// PutCourseEmailSubmission handles /v2/courses/:course_id/email_submissions/:email_submission_id requests,
+// approving or rejecting work sent by email. Approved work is saved and queued to be
+// graded as though the student had submitted it when the message arrived.
+// The updated email submission is returned.
+func PutCourseEmailSubmission(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, span *traceSpan, params martini.Params, currentUser *User, review EmailSubmission, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	submissionID, err := parseID(w, "email_submission_id", params["email_submission_id"])
+	if err != nil {
+		return
+	}
+	submission := new(EmailSubmission)
+	if err := meddler.QueryRow(tx, submission, `SELECT * FROM email_submissions WHERE id = $1 AND course_id = $2 FOR UPDATE`, submissionID, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if submission.Status != "pending" {
+		loggedHTTPErrorf(w, http.StatusConflict, "email submission %d was already %s", submissionID, submission.Status)
+		return
+	}
+
+	now := time.Now()
+	switch review.Status {
+	case "approved":
+		user := new(User)
+		if err := meddler.Load(tx, "users", user, submission.UserID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		asst := new(Assignment)
+		if err := meddler.Load(tx, "assignments", asst, submission.AssignmentID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		commits, err := snapshotCommits(now, tx, asst, submission.Files,
+			fmt.Sprintf("sent by email at %s", submission.CreatedAt.Format("2006-01-02 15:04:05 MST")))
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		var queued []string
+		for _, commit := range commits {
+			commit.ClientTime = submission.CreatedAt
+			signed, err := saveCommitBundle(now, tx, tenant, user, &CommitBundle{Commit: commit}, span)
+			if err != nil {
+				loggedHTTPError(w, err)
+				return
+			}
+			queue, err := queueSubmission(now, tx, user, signed.Commit)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			queued = append(queued, strconv.FormatInt(queue.ID, 10))
+		}
+		submission.Note = "queued for grading as submission " + strings.Join(queued, ", ")
+	case "rejected":
+		submission.Note = strings.TrimSpace(review.Note)
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "status must be approved or rejected")
+		return
+	}
+
+	submission.Status = review.Status
+	submission.ReviewedBy = currentUser.ID
+	submission.ReviewedAt = now
+	submission.UpdatedAt = now
+	if err := meddler.Update(tx, "email_submissions", submission); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("email submission %d %s by %s", submission.ID, submission.Status, currentUser.Name)
+	render.JSON(http.StatusOK, submission)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// loadOwnAssignmentProblem loads an assignment belonging to the current user and
+// confirms the problem is part of it. Errors are reported and nil is returned.
+func loadOwnAssignmentProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) (*Assignment, int64) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return nil, 0
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return nil, 0
+	}
+	assignment := new(Assignment)
+	if currentUser.Admin {
+		err = meddler.Load(tx, "assignments", assignment, assignmentID)
+	} else {
+		err = meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, 0
+	}
+	if _, err := getProblemTrack(tx, assignment.ProblemSetID, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, 0
+	}
+	return assignment, problemID
+}
+
+// PutExemplarConsent handles /v2/assignments/:assignment_id/problems/:problem_id/exemplar_consent requests,
+// recording that the student agrees to let instructors show their passing work on the
+// problem, without their name, to other students in the course.
+func PutExemplarConsent(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, problemID := loadOwnAssignmentProblem(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	consent := new(ExemplarConsent)
+	err := meddler.QueryRow(tx, consent, `SELECT * FROM exemplar_consents WHERE assignment_id = $1 AND problem_id = $2`, assignment.ID, problemID)
+	if err == sql.ErrNoRows {
+		consent = &ExemplarConsent{AssignmentID: assignment.ID, ProblemID: problemID, CreatedAt: time.Now()}
+		err = meddler.Insert(tx, "exemplar_consents", consent)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, consent)
+}
+
+// DeleteExemplarConsent handles /v2/assignments/:assignment_id/problems/:problem_id/exemplar_consent requests,
+// withdrawing the student's consent. Any of their work already published as an exemplar is removed.
+func DeleteExemplarConsent(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	assignment, problemID := loadOwnAssignmentProblem(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM exemplar_consents WHERE assignment_id = $1 AND problem_id = $2`, assignment.ID, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM exemplars WHERE commit_id IN (SELECT id FROM commits WHERE assignment_id = $1 AND problem_id = $2)`,
+		assignment.ID, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetAssignmentProblemExemplars handles /v2/assignments/:assignment_id/problems/:problem_id/exemplars requests,
+// returning the exemplars for the problem that students in the course may see now.
+// Nothing identifying the authors is included.
+func GetAssignmentProblemExemplars(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, problemID := loadOwnAssignmentProblem(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	exemplars := []*Exemplar{}
+	if err := meddler.QueryAll(tx, &exemplars, `SELECT * FROM exemplars WHERE course_id = $1 AND problem_id = $2 AND visible_at <= $3 ORDER BY id`,
+		assignment.CourseID, problemID, time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, elt := range exemplars {
+		elt.CommitID = 0
+	}
+	render.JSON(http.StatusOK, exemplars)
+}
+
+// GetCourseProblemExemplarCandidates handles /v2/courses/:course_id/problems/:problem_id/exemplar_candidates requests,
+// returning the passing solutions to the problem whose authors agreed to share them.
+func GetCourseProblemExemplarCandidates(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	candidates := []*ExemplarCandidate{}
+	if err := meddler.QueryAll(tx, &candidates, `SELECT commits.id AS commit_id, assignments.id AS assignment_id, `+
+		`users.name AS user_name, users.email AS user_email, commits.updated_at, `+
+		`EXISTS (SELECT 1 FROM exemplars WHERE exemplars.course_id = $1 AND exemplars.commit_id = commits.id) AS published `+
+		`FROM exemplar_consents `+
+		`JOIN assignments ON exemplar_consents.assignment_id = assignments.id `+
+		`JOIN users ON assignments.user_id = users.id `+
+		`JOIN commits ON commits.assignment_id = assignments.id AND commits.problem_id = exemplar_consents.problem_id `+
+		`WHERE assignments.course_id = $1 AND exemplar_consents.problem_id = $2 `+
+		`AND commits.step = (SELECT MAX(step) FROM problem_steps WHERE problem_id = $2) AND commits.score = 1.0 `+
+		`ORDER BY commits.updated_at DESC`, courseID, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, candidates)
+}
+
+// GetCourseExemplars handles /v2/courses/:course_id/exemplars requests,
+// returning every exemplar published in the course, including ones students cannot see yet.
+// It accepts a problem_id parameter to list the exemplars for one problem.
+func GetCourseExemplars(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	where, args := "WHERE course_id = $1", []interface{}{courseID}
+	if problemID := r.FormValue("problem_id"); problemID != "" {
+		id, err := parseID(w, "problem_id", problemID)
+		if err != nil {
+			return
+		}
+		where, args = where+" AND problem_id = $2", append(args, id)
+	}
+	exemplars := []*Exemplar{}
+	if err := meddler.QueryAll(tx, &exemplars, `SELECT * FROM exemplars `+where+` ORDER BY problem_id, id`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, exemplars)
+}
+
+// stripAuthorLines removes every line of the files that mentions the author by
+// name or email address, such as a header comment, so an exemplar does not say
+// who wrote it. Parts of names shorter than three letters are not matched,
+// since they would take out ordinary code. It returns the new files and the
+// number of lines removed.
+func stripAuthorLines(files map[string]string, author *User) (map[string]string, int) {
+	var words []string
+	if author.Email != "" {
+		words = append(words, regexp.QuoteMeta(author.Email))
+		if at := strings.IndexByte(author.Email, '@'); at >= 3 {
+			words = append(words, regexp.QuoteMeta(author.Email[:at]))
+		}
+	}
+	for _, part := range strings.Fields(author.Name) {
+		if len(part) >= 3 {
+			words = append(words, regexp.QuoteMeta(part))
+		}
+	}
+	if len(words) == 0 {
+		return files, 0
+	}
+	mentions := regexp.MustCompile(`(?i)\b(` + strings.Join(words, "|") + `)\b`)
+
+	stripped, removed := make(map[string]string), 0
+	for name, contents := range files {
+		var kept []string
+		for _, line := range strings.SplitAfter(contents, "\n") {
+			if mentions.MatchString(line) {
+				removed++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		stripped[name] = strings.Join(kept, "")
+	}
+	return stripped, removed
+}
+
+// PostCourseExemplar handles /v2/courses/:course_id/exemplars requests,
+// publishing a student's passing solution as an exemplar. The student must have
+// agreed to share their work on the problem, the commit must be their passing work
+// on its last step, and each problem may have at most MaxExemplars.
+// Students cannot see it before the last due date for the problem in the course,
+// which is used if no time is given, and lines that mention its author are removed.
+// The new exemplar is returned for the instructor to review.
+func PostCourseExemplar(w http.ResponseWriter, tx *sql.Tx, params martini.Params, exemplar Exemplar, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	commit := new(Commit)
+	if err := meddler.Load(tx, "commits", commit, exemplar.CommitID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	assignment := new(Assignment)
+	if err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND course_id = $2`, commit.AssignmentID, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	var consented bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM exemplar_consents WHERE assignment_id = $1 AND problem_id = $2)`,
+		assignment.ID, commit.ProblemID).Scan(&consented); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !consented {
+		loggedHTTPErrorf(w, http.StatusForbidden, "the author of commit %d has not agreed to share it", commit.ID)
+		return
+	}
+	passing, err := getPassingCommit(tx, assignment.ID, commit.ProblemID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if passing == nil || passing.ID != commit.ID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "commit %d is not a passing solution to the last step of the problem", commit.ID)
+		return
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM exemplars WHERE course_id = $1 AND problem_id = $2`, courseID, commit.ProblemID).Scan(&count); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count >= MaxExemplars {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "this problem already has %d exemplars; remove one first", count)
+		return
+	}
+
+	// students still working on the problem must not see a solution to it
+	var lastDue sql.NullTime
+	if err := tx.QueryRow(`SELECT MAX(assignments.due_at) FROM assignments `+
+		`JOIN problem_set_problems ON assignments.problem_set_id = problem_set_problems.problem_set_id `+
+		`WHERE assignments.course_id = $1 AND problem_set_problems.problem_id = $2 AND NOT assignments.instructor`,
+		courseID, commit.ProblemID).Scan(&lastDue); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	switch {
+	case !lastDue.Valid:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the LMS has not given a due date for this problem, so an exemplar cannot be shown safely")
+		return
+	case exemplar.VisibleAt.IsZero():
+		exemplar.VisibleAt = lastDue.Time
+	case exemplar.VisibleAt.Before(lastDue.Time):
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the exemplar cannot be shown before the last due date for the problem, %s",
+			lastDue.Time.Format(time.RFC3339))
+		return
+	}
+
+	author := new(User)
+	if err := meddler.Load(tx, "users", author, assignment.UserID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	files, removed := stripAuthorLines(commit.Files, author)
+
+	exemplar.ID = 0
+	exemplar.CourseID = courseID
+	exemplar.ProblemID = commit.ProblemID
+	exemplar.Files = files
+	exemplar.CreatedAt = now
+	exemplar.UpdatedAt = now
+	if err := meddler.Insert(tx, "exemplars", &exemplar); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("commit %d published as exemplar %d in course %d with %d line%s naming its author removed",
+		commit.ID, exemplar.ID, courseID, removed, plural(removed))
+	render.JSON(http.StatusOK, &exemplar)
+}
+
+// DeleteCourseExemplar handles /v2/courses/:course_id/exemplars/:exemplar_id requests,
+// removing an exemplar.
+func DeleteCourseExemplar(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	exemplarID, err := parseID(w, "exemplar_id", params["exemplar_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM exemplars WHERE id = $1 AND course_id = $2`, exemplarID, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
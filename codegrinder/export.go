@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	exportDefaultLimit = 50  // commits in an export page unless the client asks for fewer or more
+	exportMaxLimit     = 500 // most commits in an export page, since each carries every file
+)
+
+// exportAssignmentRow is an assignment with its course and problem set, for an export.
+type exportAssignmentRow struct {
+	ID               int64   `meddler:"id"`
+	CourseName       string  `meddler:"course_name"`
+	CourseLabel      string  `meddler:"lti_label"`
+	Term             string  `meddler:"term,zeroisnull"`
+	ProblemSetUnique string  `meddler:"unique_id"`
+	CanvasTitle      string  `meddler:"canvas_title"`
+	Score            float64 `meddler:"score"`
+}
+
+// GetUserMeExport handles /v2/users/me/export requests,
+// returning a page of every commit the current user has saved in any course, oldest first,
+// along with the assignments they belong to and a signed grade report for each.
+//
+// If parameter after=<...> is present, only commits with a greater ID are returned;
+// use the next field of the previous page. If parameter limit=<...> is present,
+// at most that many commits are returned (default 50, at most 500).
+func GetUserMeExport(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	var after int64
+	if s := r.FormValue("after"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "after must be a commit ID")
+			return
+		}
+		after = n
+	}
+	limit := exportDefaultLimit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		if n > exportMaxLimit {
+			n = exportMaxLimit
+		}
+		limit = n
+	}
+
+	// fetch one extra to learn if there is another page
+	commits := []*Commit{}
+	if err := meddler.QueryAll(tx, &commits, `SELECT commits.* FROM commits JOIN assignments ON commits.assignment_id = assignments.id `+
+		`WHERE assignments.user_id = $1 AND commits.id > $2 ORDER BY commits.id LIMIT $3`,
+		currentUser.ID, after, limit+1); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	page := &ExportPage{Assignments: []*ExportAssignment{}, Commits: []*ExportCommit{}}
+	if len(commits) > limit {
+		commits = commits[:limit]
+		page.Next = commits[limit-1].ID
+	}
+
+	problems := make(map[int64]string)
+	assignments := make(map[int64]bool)
+	for _, commit := range commits {
+		unique, exists := problems[commit.ProblemID]
+		if !exists {
+			if err := tx.QueryRow(`SELECT unique_id FROM problems WHERE id = $1`, commit.ProblemID).Scan(&unique); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			problems[commit.ProblemID] = unique
+		}
+		commit.AddChecksums()
+		page.Commits = append(page.Commits, &ExportCommit{Problem: unique, Commit: commit})
+
+		if assignments[commit.AssignmentID] {
+			continue
+		}
+		assignments[commit.AssignmentID] = true
+		elt, err := exportAssignment(tx, commit.AssignmentID)
+		if err != nil {
+			loggedHTTPError(w, err)
+			return
+		}
+		page.Assignments = append(page.Assignments, elt)
+	}
+	render.JSON(http.StatusOK, page)
+}
+
+// exportAssignment describes an assignment for an export.
+func exportAssignment(tx *sql.Tx, assignmentID int64) (*ExportAssignment, error) {
+	row := new(exportAssignmentRow)
+	if err := meddler.QueryRow(tx, row, `SELECT assignments.id, courses.name AS course_name, courses.lti_label, courses.term, `+
+		`problem_sets.unique_id, assignments.canvas_title, COALESCE(assignments.score, 0) AS score `+
+		`FROM assignments JOIN courses ON assignments.course_id = courses.id `+
+		`JOIN problem_sets ON assignments.problem_set_id = problem_sets.id `+
+		`WHERE assignments.id = $1`, assignmentID); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	assignment := new(Assignment)
+	if err := meddler.Load(tx, "assignments", assignment, assignmentID); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	report, err := buildGradeReport(tx, assignment)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportAssignment{
+		AssignmentID:     row.ID,
+		CourseName:       row.CourseName,
+		CourseLabel:      row.CourseLabel,
+		Term:             row.Term,
+		ProblemSetUnique: row.ProblemSetUnique,
+		CanvasTitle:      row.CanvasTitle,
+		Score:            row.Score,
+		CreatedAt:        assignment.CreatedAt,
+		Report:           report,
+	}, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// courseFeatures returns the names of the feature flags that are on for a course,
+// in sorted order. With a course ID of zero, only flags rolled out everywhere are on.
+func courseFeatures(tx *sql.Tx, courseID int64) ([]string, error) {
+	flags := []*FeatureFlag{}
+	if err := meddler.QueryAll(tx, &flags, `SELECT * FROM feature_flags`); err != nil {
+		return nil, err
+	}
+	settings := []*CourseFeatureFlag{}
+	if err := meddler.QueryAll(tx, &settings, `SELECT * FROM course_feature_flags WHERE course_id = $1`, courseID); err != nil {
+		return nil, err
+	}
+	enabled := make(map[string]bool)
+	for _, flag := range flags {
+		if flag.Percent >= 100.0 || courseID > 0 && flag.InRollout(courseID) {
+			enabled[flag.Name] = true
+		}
+	}
+	for _, setting := range settings {
+		enabled[setting.Name] = setting.Enabled
+	}
+
+	names := []string{}
+	for name, on := range enabled {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// featureEnabled reports whether a single feature flag is on for a course.
+// Flags that have not been created are off.
+func featureEnabled(tx *sql.Tx, name string, courseID int64) (bool, error) {
+	features, err := courseFeatures(tx, courseID)
+	if err != nil {
+		return false, err
+	}
+	i := sort.SearchStrings(features, name)
+	return i < len(features) && features[i] == name, nil
+}
+
+// GetCapabilities handles /v2/capabilities requests,
+// returning the endpoints, problem type actions, and features the server offers
+// so clients can adapt to servers running older or newer versions.
+//
+// If parameter course_id=<...> present, features turned on for that course are included.
+func (r *apiRouter) GetCapabilities(w http.ResponseWriter, req *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	version := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)[0]
+	capabilities := &Capabilities{
+		Version:      CurrentVersion.Version,
+		Endpoints:    r.endpoints(version),
+		ProblemTypes: make(map[string][]string),
+		GitPush:      Config().GitRoot != "",
+		AssetMirror:  Config().AssetMirror,
+	}
+	for name, problemType := range problemTypes() {
+		actions := []string{}
+		for action := range problemType.Actions {
+			actions = append(actions, action)
+		}
+		sort.Strings(actions)
+		capabilities.ProblemTypes[name] = actions
+	}
+
+	if s := req.FormValue("course_id"); s != "" {
+		courseID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || courseID < 1 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing course_id: %q is not a valid ID", s)
+			return
+		}
+		if !currentUser.Admin {
+			var member bool
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE user_id = $1 AND course_id = $2)`,
+				currentUser.ID, courseID).Scan(&member); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if !member {
+				loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+				return
+			}
+		}
+		capabilities.CourseID = courseID
+	}
+
+	features, err := courseFeatures(tx, capabilities.CourseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	capabilities.Features = features
+	render.JSON(http.StatusOK, capabilities)
+}
+
+// GetFeatureFlags handles /v2/feature_flags requests,
+// returning every feature flag and its rollout percentage.
+func GetFeatureFlags(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	flags := []*FeatureFlag{}
+	if err := meddler.QueryAll(tx, &flags, `SELECT * FROM feature_flags ORDER BY name`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, flags)
+}
+
+// PutFeatureFlag handles /v2/feature_flags/:name requests,
+// creating a feature flag or changing its description and rollout percentage.
+func PutFeatureFlag(w http.ResponseWriter, tx *sql.Tx, params martini.Params, flag FeatureFlag, render render.Render) {
+	flag.Name = params["name"]
+	if err := flag.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	now := time.Now()
+	flag.CreatedAt = now
+	flag.UpdatedAt = now
+	old := new(FeatureFlag)
+	err := meddler.QueryRow(tx, old, `SELECT * FROM feature_flags WHERE name = $1`, flag.Name)
+	switch {
+	case err == sql.ErrNoRows:
+		err = meddler.Insert(tx, "feature_flags", &flag)
+	case err == nil:
+		flag.CreatedAt = old.CreatedAt
+		_, err = tx.Exec(`UPDATE feature_flags SET description = $1, percent = $2, updated_at = $3 WHERE name = $4`,
+			flag.Description, flag.Percent, now, flag.Name)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("feature %s rolled out to %.1f%% of courses", flag.Name, flag.Percent)
+	render.JSON(http.StatusOK, &flag)
+}
+
+// DeleteFeatureFlag handles /v2/feature_flags/:name requests,
+// removing a feature flag and every course setting for it.
+func DeleteFeatureFlag(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	if _, err := tx.Exec(`DELETE FROM feature_flags WHERE name = $1`, params["name"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+}
+
+// GetCourseFeatureFlags handles /v2/courses/:course_id/feature_flags requests,
+// returning the features the course has turned on or off for itself.
+func GetCourseFeatureFlags(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	settings := []*CourseFeatureFlag{}
+	if err := meddler.QueryAll(tx, &settings, `SELECT * FROM course_feature_flags WHERE course_id = $1 ORDER BY name`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, settings)
+}
+
+// PutCourseFeatureFlag handles /v2/courses/:course_id/feature_flags/:name requests,
+// turning a feature on or off for the course regardless of the rollout percentage.
+func PutCourseFeatureFlag(w http.ResponseWriter, tx *sql.Tx, params martini.Params, setting CourseFeatureFlag, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	name := params["name"]
+	flag := new(FeatureFlag)
+	if err := meddler.QueryRow(tx, flag, `SELECT * FROM feature_flags WHERE name = $1`, name); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	now := time.Now()
+	setting.CourseID = courseID
+	setting.Name = name
+	setting.CreatedAt = now
+	setting.UpdatedAt = now
+	old := new(CourseFeatureFlag)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM course_feature_flags WHERE course_id = $1 AND name = $2`, courseID, name)
+	switch {
+	case err == sql.ErrNoRows:
+		err = meddler.Insert(tx, "course_feature_flags", &setting)
+	case err == nil:
+		setting.CreatedAt = old.CreatedAt
+		_, err = tx.Exec(`UPDATE course_feature_flags SET enabled = $1, updated_at = $2 WHERE course_id = $3 AND name = $4`,
+			setting.Enabled, now, courseID, name)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("course %d set feature %s enabled=%v", courseID, name, setting.Enabled)
+	render.JSON(http.StatusOK, &setting)
+}
+
+// DeleteCourseFeatureFlag handles /v2/courses/:course_id/feature_flags/:name requests,
+// returning the course to the rollout percentage for a feature.
+func DeleteCourseFeatureFlag(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM course_feature_flags WHERE course_id = $1 AND name = $2`, courseID, params["name"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetProblemFeedbackRules handles /v2/problems/:problem_id/feedback_rules requests,
+// returning the feedback rules for the problem, the ones that match most often first.
+func GetProblemFeedbackRules(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	rules := []*FeedbackRule{}
+	if err := meddler.QueryAll(tx, &rules, `SELECT * FROM feedback_rules WHERE problem_id = $1 ORDER BY hits DESC, name`, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, rules)
+}
+
+// PutProblemFeedbackRule handles /v2/problems/:problem_id/feedback_rules/:name requests,
+// setting a rule that adds a message to the report card when it recognizes a misconception
+// in a student's code or failing output. Changing a rule keeps its count of hits.
+// The rule is returned.
+func PutProblemFeedbackRule(w http.ResponseWriter, tx *sql.Tx, currentUser *User, params martini.Params, rule FeedbackRule, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	rule.ProblemID = problemID
+	rule.Name = params["name"]
+	if err := rule.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	now := time.Now()
+	rule.UpdatedAt = now
+	old := new(FeedbackRule)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM feedback_rules WHERE problem_id = $1 AND name = $2`, problemID, rule.Name)
+	switch {
+	case err == sql.ErrNoRows:
+		rule.Hits = 0
+		rule.LastHitAt = time.Time{}
+		rule.CreatedAt = now
+		err = meddler.Insert(tx, "feedback_rules", &rule)
+	case err == nil:
+		rule.Hits, rule.LastHitAt, rule.CreatedAt = old.Hits, old.LastHitAt, old.CreatedAt
+		_, err = tx.Exec(`UPDATE feedback_rules SET match_on = $1, pattern = $2, files = $3, step = $4, message = $5, updated_at = $6 `+
+			`WHERE problem_id = $7 AND name = $8`,
+			rule.Match, rule.Pattern, rule.Files, rule.Step, rule.Message, now, problemID, rule.Name)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("%s set feedback rule %s for problem %s", currentUser.Name, rule.Name, problem.Unique)
+	render.JSON(http.StatusOK, &rule)
+}
+
+// DeleteProblemFeedbackRule handles /v2/problems/:problem_id/feedback_rules/:name requests,
+// removing a feedback rule from the problem. Feedback already given is kept.
+func DeleteProblemFeedbackRule(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM feedback_rules WHERE problem_id = $1 AND name = $2`, problemID, params["name"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// applyFeedbackRules adds the message of every feedback rule for the problem that
+// matches a graded commit to its report card, counting a hit for each one.
+func applyFeedbackRules(tx *sql.Tx, problem *Problem, commit *Commit, now time.Time) error {
+	rules := []*FeedbackRule{}
+	if err := meddler.QueryAll(tx, &rules, `SELECT * FROM feedback_rules WHERE problem_id = $1 ORDER BY name`, problem.ID); err != nil {
+		return err
+	}
+	commit.ReportCard.Feedback = nil
+	for _, rule := range rules {
+		context, matched := rule.Check(commit)
+		if !matched {
+			continue
+		}
+		commit.ReportCard.Feedback = append(commit.ReportCard.Feedback, &ReportCardFeedback{
+			Rule:    rule.Name,
+			Message: rule.Message,
+			Context: context,
+		})
+		if _, err := tx.Exec(`UPDATE feedback_rules SET hits = hits + 1, last_hit_at = $1 WHERE problem_id = $2 AND name = $3`,
+			now, problem.ID, rule.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// snapshotMaxSize limits the unpacked size of a student repository.
+	snapshotMaxSize = 10 << 20
+
+	// gitZeroSHA is the commit git reports for a branch that was created or deleted.
+	gitZeroSHA = "0000000000000000000000000000000000000000"
+)
+
+func init() {
+	commands["git-hook"] = &serverCommand{Short: "grade work pushed with git (run by git as a post-receive hook)", Run: CommandGitHook}
+}
+
+// gitProjectRoot returns the directory holding a tenant's repositories.
+func gitProjectRoot(tenant *TenantConfig) string {
+	schema := tenant.Schema
+	if schema == "" {
+		schema = "public"
+	}
+	return filepath.Join(Config().GitRoot, schema)
+}
+
+// ensureGitRepository creates the bare repository for an assignment if needed
+// and installs the hook that grades each push. The hook is rewritten every time
+// so it follows the server binary and config file if they move.
+func ensureGitRepository(root string, assignmentID int64) error {
+	dir := filepath.Join(root, strconv.FormatInt(assignmentID, 10))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(root, 0755); err != nil {
+			return err
+		}
+		if out, err := exec.Command("git", "init", "--quiet", "--bare", dir).CombinedOutput(); err != nil {
+			return fmt.Errorf("git init: %v: %s", err, out)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	config, err := filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+	hook := fmt.Sprintf("#!/bin/sh\nexec '%s' -config '%s' git-hook\n", exe, config)
+	return ioutil.WriteFile(filepath.Join(dir, "hooks", "post-receive"), []byte(hook), 0755)
+}
+
+// gitBasicAuth lets git clients that were given the session cookie as the
+// password of a git remote authenticate like any other client.
+// It must run before the session is loaded.
+func gitBasicAuth(r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/git/") {
+		return
+	}
+	if _, password, ok := r.BasicAuth(); ok && password != "" {
+		password = strings.TrimPrefix(password, CookieName+"=")
+		r.AddCookie(&http.Cookie{Name: CookieName, Value: password})
+	}
+}
+
+// gitChallenge asks git clients with no session for credentials,
+// since git only sends them after it is told they are needed.
+func gitChallenge(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(CookieName); err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="CodeGrinder"`)
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "authentication: give the cookie from \"grind init\" as the password")
+	}
+}
+
+// ServeGit handles /git/:assignment_id/** requests from git push,
+// passing them to git http-backend. Each assignment has its own repository,
+// and a hook grades every push and reports the results in the push output.
+// Only pushing is supported; the repository is not a place to fetch work from.
+func ServeGit(w http.ResponseWriter, r *http.Request, db *sql.DB, tenant *TenantConfig, span *traceSpan, currentUser *User, params martini.Params) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+
+	// the push hook grades the commit before git http-backend returns,
+	// so only hold a transaction long enough to check the assignment
+	asst := new(Assignment)
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		return meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID)
+	})
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/git-upload-pack") || r.FormValue("service") == "git-upload-pack" {
+		loggedHTTPErrorf(w, http.StatusForbidden, "this repository only accepts pushes; use grind get to download your work")
+		return
+	}
+
+	root := gitProjectRoot(tenant)
+	if err := ensureGitRepository(root, assignmentID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error setting up repository: %v", err)
+		return
+	}
+	git, err := exec.LookPath("git")
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "git is not installed: %v", err)
+		return
+	}
+	backend := &cgi.Handler{
+		Path: git,
+		Args: []string{"http-backend"},
+		Root: "/git",
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"REMOTE_USER=" + currentUser.Email,
+			"CODEGRINDER_TENANT=" + tenant.Hostname,
+			"CODEGRINDER_USER=" + strconv.FormatInt(currentUser.ID, 10),
+			"CODEGRINDER_ASSIGNMENT=" + strconv.FormatInt(assignmentID, 10),
+			"CODEGRINDER_TRACEPARENT=" + span.traceParent(),
+		},
+	}
+	backend.ServeHTTP(w, r)
+}
+
+// untarSnapshot reads the files from a tar archive of a repository.
+// Everything in the archive is expected to be in a single top-level directory,
+// which is removed from the names. Hidden files and directories,
+// such as workflow definitions, are left out.
+func untarSnapshot(r io.Reader, repository string) (map[string]string, error) {
+	files := make(map[string]string)
+	size := 0
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		parts := strings.Split(header.Name, "/")
+		if len(parts) < 2 {
+			continue
+		}
+		hidden := false
+		for _, part := range parts[1:] {
+			if strings.HasPrefix(part, ".") {
+				hidden = true
+			}
+		}
+		if hidden {
+			continue
+		}
+		size += int(header.Size)
+		if size > snapshotMaxSize {
+			return nil, fmt.Errorf("repository %s is larger than %d bytes", repository, snapshotMaxSize)
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		files[strings.Join(parts[1:], "/")] = string(contents)
+	}
+	return files, nil
+}
+
+// CommandGitHook grades a push. Git runs it in the repository with the pushed
+// refs on stdin, and everything it prints is shown to the student by git push.
+// The tenant, user, and assignment come from the environment set by ServeGit.
+func CommandGitHook(args []string) {
+	log.SetFlags(0)
+	log.SetOutput(ioutil.Discard)
+	fail := func(format string, params ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", params...)
+		os.Exit(1)
+	}
+
+	tenant := findTenant(os.Getenv("CODEGRINDER_TENANT"))
+	userID, _ := strconv.ParseInt(os.Getenv("CODEGRINDER_USER"), 10, 64)
+	assignmentID, _ := strconv.ParseInt(os.Getenv("CODEGRINDER_ASSIGNMENT"), 10, 64)
+	if tenant == nil || userID < 1 || assignmentID < 1 {
+		fail("git-hook must be run by git for a push received by the server")
+	}
+
+	// grade the last branch pushed
+	sha := ""
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[1] != gitZeroSHA && strings.HasPrefix(fields[2], "refs/heads/") {
+			sha = fields[1]
+		}
+	}
+	if sha == "" {
+		return
+	}
+	archive := exec.Command("git", "archive", "--format=tar", "--prefix=snapshot/", sha)
+	out, err := archive.StdoutPipe()
+	if err != nil {
+		fail("error reading your push: %v", err)
+	}
+	if err := archive.Start(); err != nil {
+		fail("error reading your push: %v", err)
+	}
+	files, err := untarSnapshot(out, fmt.Sprintf("commit %.7s", sha))
+	if err != nil {
+		fail("error reading your push: %v", err)
+	}
+	if err := archive.Wait(); err != nil {
+		fail("error reading your push: %v", err)
+	}
+
+	// the hook runs in its own process, so its spans join the push request by way of the environment
+	span := startRemoteSpan(os.Getenv("CODEGRINDER_TRACEPARENT"), "git push grading", spanInternal)
+	span.set("commit.sha", sha)
+	defer flushSpans()
+	defer span.finish()
+
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	now := time.Now()
+	user := new(User)
+	var bundles []*CommitBundle
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		if err := meddler.Load(tx, "users", user, userID); err != nil {
+			return err
+		}
+		asst := new(Assignment)
+		if err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, userID); err != nil {
+			return err
+		}
+		commits, err := snapshotCommits(now, tx, asst, files, fmt.Sprintf("pushed with git at %.7s", sha))
+		if err != nil {
+			return err
+		}
+		if len(commits) == 0 {
+			fmt.Printf("no files found for any problem in this assignment\n")
+		}
+		for _, commit := range commits {
+			signed, err := saveCommitBundle(now, tx, tenant, user, &CommitBundle{Commit: commit}, span)
+			if err != nil {
+				if e, ok := err.(*APIError); ok && e.Status != http.StatusInternalServerError {
+					fmt.Printf("not graded: %s\n", e.Message)
+					continue
+				}
+				return err
+			}
+			bundles = append(bundles, signed)
+		}
+		return nil
+	})
+	if err != nil {
+		fail("error saving your push: %v", err)
+	}
+
+	for _, signed := range bundles {
+		fmt.Printf("grading %s step %d...\n", signed.Problem.Unique, signed.Commit.Step)
+		graded, err := runDaycareBundle(signed, span)
+		var saved *CommitBundle
+		if err == nil {
+			err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+				toSave := &CommitBundle{Imports: graded.Imports, Matrix: graded.Matrix, Commit: graded.Commit, CommitSignature: graded.CommitSignature}
+				saved, err = saveCommitBundle(time.Now(), tx, tenant, user, toSave, span)
+				return err
+			})
+		}
+		if err != nil {
+			// leave it for the background grader
+			err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+				_, err := queueSubmission(time.Now(), tx, user, signed.Commit)
+				return err
+			})
+			if err != nil {
+				fmt.Printf("  grading failed; run grind grade to try again\n")
+			} else {
+				fmt.Printf("  could not grade now; it will be graded in the background\n")
+			}
+			continue
+		}
+		printGitReportCard(saved.Commit)
+	}
+}
+
+// printGitReportCard summarizes a graded commit for the git push output.
+func printGitReportCard(commit *Commit) {
+	card := commit.ReportCard
+	if card == nil {
+		fmt.Printf("  no report card was returned\n")
+		return
+	}
+	outcome := "failed"
+	if card.Passed {
+		outcome = "passed"
+	}
+	fmt.Printf("  %s: %.0f%% %s\n", outcome, commit.Score*100.0, card.Note)
+	for _, result := range card.Results {
+		if result.Outcome != "passed" {
+			fmt.Printf("    %s: %s\n", result.Outcome, result.Name)
+		}
+	}
+}
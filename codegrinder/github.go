@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// githubAPI is the base URL of the GitHub REST API.
+	githubAPI = "https://api.github.com"
+
+	// githubTimeout limits how long GitHub has to answer a single request.
+	githubTimeout = time.Minute
+)
+
+// githubAcceptedAssignment is a student (or group) repository in a GitHub Classroom assignment.
+type githubAcceptedAssignment struct {
+	Students []struct {
+		Login string `json:"login"`
+	} `json:"students"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// githubDo makes an authenticated request to the GitHub API.
+// Responses other than success are turned into errors.
+func githubDo(method, path string, body interface{}) (*http.Response, error) {
+	if Config().GitHubToken == "" {
+		return nil, fmt.Errorf("no GitHubToken is set in the server config")
+	}
+	var payload io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, githubAPI+path, payload)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+Config().GitHubToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: githubTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GitHub returned %s for %s %s: %s", resp.Status, method, path, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+// githubRequest makes a request to the GitHub API,
+// decoding the JSON response into out if it is not nil.
+func githubRequest(method, path string, body interface{}, out interface{}) error {
+	resp, err := githubDo(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// githubAcceptedAssignments lists the repositories of a GitHub Classroom assignment.
+func githubAcceptedAssignments(classroomAssignmentID int64) ([]*githubAcceptedAssignment, error) {
+	var all []*githubAcceptedAssignment
+	for page := 1; ; page++ {
+		var list []*githubAcceptedAssignment
+		path := fmt.Sprintf("/assignments/%d/accepted_assignments?per_page=100&page=%d", classroomAssignmentID, page)
+		if err := githubRequest("GET", path, nil, &list); err != nil {
+			return nil, err
+		}
+		all = append(all, list...)
+		if len(list) < 100 {
+			return all, nil
+		}
+	}
+}
+
+// githubHeadSHA returns the latest commit on the default branch of a repository.
+func githubHeadSHA(repository string) (string, error) {
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := githubRequest("GET", "/repos/"+repository+"/commits/HEAD", nil, &commit); err != nil {
+		return "", err
+	}
+	return commit.SHA, nil
+}
+
+// githubFiles downloads the files of a repository at a commit.
+func githubFiles(repository, sha string) (map[string]string, error) {
+	resp, err := githubDo("GET", "/repos/"+repository+"/tarball/"+sha, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	gz, err := gzip.NewReader(io.LimitReader(resp.Body, snapshotMaxSize))
+	if err != nil {
+		return nil, err
+	}
+	return untarSnapshot(gz, repository)
+}
+
+// githubPostStatus posts a commit status for a problem to a repository.
+func githubPostStatus(repository, sha, unique, state, description, targetURL string) error {
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+	status := map[string]string{
+		"state":       state,
+		"description": description,
+		"context":     "codegrinder/" + unique,
+		"target_url":  targetURL,
+	}
+	return githubRequest("POST", "/repos/"+repository+"/statuses/"+sha, status, nil)
+}
+
+// PutCourseProblemSetGitHubClassroom handles /v2/courses/:course_id/problem_sets/:problem_set_id/github_classroom requests,
+// linking the problem set to a GitHub Classroom assignment along with the roster
+// that matches GitHub logins to students. The link is returned.
+func PutCourseProblemSetGitHubClassroom(w http.ResponseWriter, tx *sql.Tx, params martini.Params, classroom GitHubClassroom, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	if err := classroom.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+
+	now := time.Now()
+	classroom.CourseID = courseID
+	classroom.ProblemSetID = problemSetID
+	classroom.ImportedAt = time.Time{}
+	classroom.CreatedAt = now
+	classroom.UpdatedAt = now
+	old := new(GitHubClassroom)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM github_classrooms WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	switch {
+	case err == sql.ErrNoRows:
+		err = meddler.Insert(tx, "github_classrooms", &classroom)
+	case err == nil:
+		classroom.CreatedAt = old.CreatedAt
+		classroom.ImportedAt = old.ImportedAt
+		err = meddler.Update(tx, "github_classrooms", &classroom)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("course %d problem set %d linked to GitHub Classroom assignment %d with %d login%s",
+		courseID, problemSetID, classroom.ClassroomAssignmentID, len(classroom.Logins), plural(len(classroom.Logins)))
+	render.JSON(http.StatusOK, &classroom)
+}
+
+// PostCourseProblemSetGitHubImport handles /v2/courses/:course_id/problem_sets/:problem_set_id/github_classroom/import requests,
+// fetching the latest commit of each student repository in the linked GitHub Classroom
+// assignment and queuing it to be graded. When grading finishes, the result is posted
+// to the LMS as usual and to the repository as a commit status.
+// A report on each repository is returned.
+//
+// Nothing is fetched from GitHub while a transaction is open, and each repository
+// is queued in its own transaction, so one slow or broken repository does not hold
+// up the rest.
+func PostCourseProblemSetGitHubImport(w http.ResponseWriter, db *sql.DB, tenant *TenantConfig, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	classroom := new(GitHubClassroom)
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		if err := meddler.QueryRow(tx, classroom, `SELECT * FROM github_classrooms WHERE course_id = $1 AND problem_set_id = $2`,
+			courseID, problemSetID); err != nil {
+			return dbNotFoundError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	accepted, err := githubAcceptedAssignments(classroom.ClassroomAssignmentID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadGateway, "error listing GitHub Classroom repositories: %v", err)
+		return
+	}
+
+	report := &GitHubImport{Repositories: []*GitHubImportRepository{}}
+	for _, repo := range accepted {
+		for _, student := range repo.Students {
+			elt := &GitHubImportRepository{Login: student.Login, Repository: repo.Repository.FullName}
+			report.Repositories = append(report.Repositories, elt)
+			if err := importGitHubRepository(now, db, tenant, classroom, elt); err != nil {
+				loggedHTTPError(w, err)
+				return
+			}
+		}
+	}
+
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		_, err := tx.Exec(`UPDATE github_classrooms SET imported_at = $1 WHERE course_id = $2 AND problem_set_id = $3`,
+			now, courseID, problemSetID)
+		return err
+	})
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
+
+// githubImported checks if a commit of a repository was already imported for an assignment.
+func githubImported(tx *sql.Tx, repository, sha string, assignmentID int64) (bool, error) {
+	var imported bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM github_submissions JOIN submissions ON github_submissions.submission_id = submissions.id `+
+		`WHERE github_submissions.repository = $1 AND github_submissions.sha = $2 AND submissions.assignment_id = $3)`,
+		repository, sha, assignmentID).Scan(&imported)
+	return imported, err
+}
+
+// importGitHubRepository queues one student's repository for grading,
+// recording what happened in the report. Problems with the repository or the
+// student are noted in the report; only database errors are returned.
+// GitHub is only contacted between transactions, and pending statuses are
+// posted once the submissions are committed.
+func importGitHubRepository(now time.Time, db *sql.DB, tenant *TenantConfig, classroom *GitHubClassroom, elt *GitHubImportRepository) error {
+	email, exists := classroom.Logins[strings.ToLower(elt.Login)]
+	if !exists {
+		elt.Note = "login is not in the roster"
+		return nil
+	}
+	asst, user := new(Assignment), new(User)
+	found := false
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		err := meddler.QueryRow(tx, asst, `SELECT assignments.* FROM assignments JOIN users ON assignments.user_id = users.id `+
+			`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 AND lower(users.email) = lower($3)`,
+			classroom.CourseID, classroom.ProblemSetID, email)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return meddler.Load(tx, "users", user, asst.UserID)
+	})
+	if err != nil {
+		return dbNotFoundError(err)
+	}
+	if !found {
+		elt.Note = fmt.Sprintf("%s has not opened this assignment in the LMS yet", email)
+		return nil
+	}
+
+	sha, err := githubHeadSHA(elt.Repository)
+	if err != nil {
+		elt.Note = err.Error()
+		return nil
+	}
+	elt.SHA = sha
+	var imported bool
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		imported, err = githubImported(tx, elt.Repository, sha, asst.ID)
+		return err
+	})
+	if err != nil {
+		return dbNotFoundError(err)
+	}
+	if imported {
+		elt.Note = "latest commit was already imported"
+		return nil
+	}
+	files, err := githubFiles(elt.Repository, sha)
+	if err != nil {
+		elt.Note = err.Error()
+		return nil
+	}
+
+	// queue a submission for each problem
+	var notes []string
+	var uniques []string
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		// another import may have queued it while the files were downloading
+		if imported, err = githubImported(tx, elt.Repository, sha, asst.ID); err != nil || imported {
+			return err
+		}
+		commits, err := snapshotCommits(now, tx, asst, files, fmt.Sprintf("imported from %s at %.7s", elt.Repository, sha))
+		if err != nil {
+			return err
+		}
+		for _, commit := range commits {
+			signed, err := saveCommitBundle(now, tx, tenant, user, &CommitBundle{Commit: commit}, nil)
+			if err != nil {
+				if e, ok := err.(*APIError); ok && e.Status != http.StatusInternalServerError {
+					notes = append(notes, fmt.Sprintf("problem %d: %s", commit.ProblemID, e.Message))
+					continue
+				}
+				return err
+			}
+			submission, err := queueSubmission(now, tx, user, signed.Commit)
+			if err != nil {
+				return err
+			}
+			record := &GitHubSubmission{SubmissionID: submission.ID, Repository: elt.Repository, SHA: sha, CreatedAt: now}
+			if err := meddler.Insert(tx, "github_submissions", record); err != nil {
+				return err
+			}
+			elt.SubmissionIDs = append(elt.SubmissionIDs, submission.ID)
+			uniques = append(uniques, signed.Problem.Unique)
+		}
+		return nil
+	})
+	if err != nil {
+		elt.SubmissionIDs = nil
+		if _, ok := err.(*APIError); ok {
+			return err
+		}
+		return dbNotFoundError(err)
+	}
+	if imported {
+		elt.Note = "latest commit was already imported"
+		return nil
+	}
+
+	// the submissions are visible to the grader now, so announce them
+	target := fmt.Sprintf("https://%s/progress/%d", tenant.Hostname, asst.ID)
+	for i, submissionID := range elt.SubmissionIDs {
+		if err := githubPostStatus(elt.Repository, sha, uniques[i], "pending", "waiting to be graded", target); err != nil {
+			log.Printf("error posting pending status to %s: %v", elt.Repository, err)
+		}
+		repostGitHubStatus(db, tenant, submissionID)
+	}
+
+	switch {
+	case len(elt.SubmissionIDs) > 0:
+		notes = append([]string{fmt.Sprintf("queued %d submission%s", len(elt.SubmissionIDs), plural(len(elt.SubmissionIDs)))}, notes...)
+	case len(notes) == 0:
+		notes = append(notes, "no files found for any problem in the set")
+	}
+	elt.Note = strings.Join(notes, "; ")
+	return nil
+}
+
+// repostGitHubStatus posts the outcome of a submission again if it was
+// graded before its pending status went out, so the pending status does not
+// stay on the repository.
+func repostGitHubStatus(db *sql.DB, tenant *TenantConfig, submissionID int64) {
+	var again bool
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		result, err := tx.Exec(`UPDATE github_submissions SET status_posted = false WHERE submission_id = $1 AND status_posted`, submissionID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		again = count > 0
+		return err
+	})
+	if err != nil {
+		log.Printf("error checking GitHub status for submission %d: %v", submissionID, err)
+		return
+	}
+	if again {
+		reportGitHubStatus(db, tenant, submissionID)
+	}
+}
+
+// reportGitHubStatus posts the outcome of a submission imported from GitHub
+// back to the repository it came from. Other submissions are ignored.
+func reportGitHubStatus(db *sql.DB, tenant *TenantConfig, submissionID int64) {
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		record := new(GitHubSubmission)
+		err := meddler.QueryRow(tx, record, `SELECT * FROM github_submissions WHERE submission_id = $1 AND NOT status_posted`, submissionID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		submission, problem := new(Submission), new(Problem)
+		if err := meddler.Load(tx, "submissions", submission, submissionID); err != nil {
+			return err
+		}
+		if err := meddler.Load(tx, "problems", problem, submission.ProblemID); err != nil {
+			return err
+		}
+
+		state := "error"
+		switch {
+		case submission.Status == "done" && submission.Score == 1.0:
+			state = "success"
+		case submission.Status == "done":
+			state = "failure"
+		}
+		description := fmt.Sprintf("step %d: %.0f%%", submission.Step, submission.Score*100.0)
+		if submission.Note != "" {
+			description += " " + submission.Note
+		}
+		target := fmt.Sprintf("https://%s/progress/%d", tenant.Hostname, submission.AssignmentID)
+		if err := githubPostStatus(record.Repository, record.SHA, problem.Unique, state, description, target); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE github_submissions SET status_posted = true WHERE submission_id = $1`, submissionID)
+		return err
+	})
+	if err != nil {
+		log.Printf("error posting GitHub status for submission %d: %v", submissionID, err)
+	}
+}
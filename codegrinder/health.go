@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+// healthProbeTimeout is the longest a single dependency probe may take
+// before it is considered to have failed.
+const healthProbeTimeout = 5 * time.Second
+
+// healthProbe checks a single dependency, returning a short status message
+// on success or an error describing the failure.
+type healthProbe struct {
+	Name  string
+	Probe func() (string, error)
+}
+
+// healthProbes is the list of dependency checks for the roles served by this instance.
+// It is populated as each role is set up in main.
+var healthProbes []*healthProbe
+
+// healthRoles is the list of roles served by this instance.
+var healthRoles []string
+
+// GetHealthz handles /healthz requests,
+// reporting that the server process is alive. No dependencies are checked.
+func GetHealthz(w http.ResponseWriter) {
+	fmt.Fprintln(w, "ok")
+}
+
+// GetReadyz handles /readyz requests,
+// probing every dependency and returning a HealthReport.
+// The status is 200 if everything is healthy and 503 otherwise.
+// Only the name and status of each check are given unless the request comes
+// from a loopback address; administrators can get the rest from /v2/health.
+func GetReadyz(w http.ResponseWriter, r *http.Request, render render.Render) {
+	report := runHealthProbes()
+	if !isLoopback(r.RemoteAddr) {
+		report = report.Summary()
+	}
+	renderHealthReport(report, render)
+}
+
+// GetHealth handles /v2/health requests,
+// returning the full HealthReport from the readiness probes.
+func GetHealth(render render.Render) {
+	renderHealthReport(runHealthProbes(), render)
+}
+
+func renderHealthReport(report *HealthReport, render render.Render) {
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	render.JSON(status, report)
+}
+
+// isLoopback returns true if a request's remote address is on this machine.
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func runHealthProbes() *HealthReport {
+	report := &HealthReport{
+		Healthy: true,
+		Host:    Config().Hostname,
+		Roles:   healthRoles,
+		Time:    time.Now(),
+		Checks:  []*HealthCheck{},
+	}
+
+	// run the probes in parallel so one slow dependency does not hide the others
+	results := make([]chan *HealthCheck, len(healthProbes))
+	for i, probe := range healthProbes {
+		results[i] = make(chan *HealthCheck, 1)
+		go func(probe *healthProbe, out chan *HealthCheck) {
+			start := time.Now()
+			msg, err := probe.Probe()
+			check := &HealthCheck{Name: probe.Name, Healthy: err == nil, Message: msg, Duration: time.Since(start)}
+			if err != nil {
+				check.Message = err.Error()
+			}
+			out <- check
+		}(probe, results[i])
+	}
+
+	for i, probe := range healthProbes {
+		var check *HealthCheck
+		select {
+		case check = <-results[i]:
+		case <-time.After(healthProbeTimeout):
+			check = &HealthCheck{Name: probe.Name, Message: fmt.Sprintf("timed out after %v", healthProbeTimeout), Duration: healthProbeTimeout}
+		}
+		if !check.Healthy {
+			report.Healthy = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	return report
+}
+
+func probeDatabase(db *sql.DB) func() (string, error) {
+	return func() (string, error) {
+		if err := db.Ping(); err != nil {
+			return "", fmt.Errorf("database ping failed: %v", err)
+		}
+		var n int
+		if err := db.QueryRow(`SELECT 1`).Scan(&n); err != nil {
+			return "", fmt.Errorf("database query failed: %v", err)
+		}
+		return "database is accepting queries", nil
+	}
+}
+
+func probeDocker() (string, error) {
+	if dockerClient == nil {
+		return "", fmt.Errorf("no docker client configured")
+	}
+	if err := dockerClient.Ping(); err != nil {
+		return "", fmt.Errorf("%s ping failed: %v", containerEngineName(), err)
+	}
+	return containerEngineName() + " is reachable", nil
+}
+
+func probeDisk(path string, minFreeMB int) func() (string, error) {
+	return func() (string, error) {
+		free, err := diskFreeMB(path)
+		if err != nil {
+			return "", err
+		}
+		if free < int64(minFreeMB) {
+			return "", fmt.Errorf("only %d MB free on %s, need at least %d MB", free, path, minFreeMB)
+		}
+		return fmt.Sprintf("%d MB free on %s", free, path), nil
+	}
+}
+
+func probeLMS(target string) func() (string, error) {
+	return func() (string, error) {
+		client := &http.Client{Timeout: healthProbeTimeout}
+		resp, err := client.Head(target)
+		if err != nil {
+			return "", fmt.Errorf("LMS at %s is unreachable: %v", target, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", fmt.Errorf("LMS at %s returned %s", target, resp.Status)
+		}
+		return fmt.Sprintf("LMS at %s returned %s", target, resp.Status), nil
+	}
+}
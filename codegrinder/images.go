@@ -0,0 +1,413 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// toolchainImageCheckInterval is how often the TA checks that the daycare
+	// runs the expected toolchain images.
+	toolchainImageCheckInterval = time.Minute
+
+	// toolchainImageTimeout limits how long a daycare waits for the expected images.
+	toolchainImageTimeout = 30 * time.Second
+)
+
+// latestToolchainImagesQuery selects the newest image built for each problem type.
+const latestToolchainImagesQuery = `SELECT DISTINCT ON (problem_type) * FROM toolchain_images ORDER BY problem_type, created_at DESC`
+
+func init() {
+	commands["images"] = &serverCommand{Short: "build, scan, and push toolchain images, or pull them onto a daycare", Run: CommandImages}
+}
+
+// CommandImages handles "codegrinder images build [-tag TAG] DIR...", "codegrinder images deps",
+// and "codegrinder images pull".
+//
+// Build is run wherever the images are made. Each directory holds the Dockerfile for
+// one image and is named after it, e.g., containers/python3 for codegrinder/python3.
+// The image is built, scanned with the ImageScanCommand, pushed to the ImageRegistry,
+// and recorded as the expected image for every problem type that uses it.
+//
+// Deps is run after build. It builds a snapshot image with the dependencies of each
+// problem that lists some installed on the expected image for its problem type.
+//
+// Pull is run on each daycare, e.g., from a systemd timer. It fetches the expected
+// images and dependency snapshots from the TA and installs any the daycare does
+// not already have.
+func CommandImages(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("usage: codegrinder images build [-tag TAG] DIR... | codegrinder images deps | codegrinder images pull")
+	}
+	if Config().ImageRegistry == "" {
+		log.Fatalf("no ImageRegistry is set in the config file")
+	}
+	switch args[0] {
+	case "build":
+		commandImagesBuild(args[1:])
+	case "deps":
+		commandImagesDeps(args[1:])
+	case "pull":
+		commandImagesPull(args[1:])
+	default:
+		log.Fatalf("unknown images command %q; use build, deps, or pull", args[0])
+	}
+}
+
+func commandImagesBuild(args []string) {
+	fs := flag.NewFlagSet("images build", flag.ExitOnError)
+	tag := fs.String("tag", time.Now().Format("20060102-150405"), "Tag for the new images")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		log.Fatalf("usage: codegrinder images build [-tag TAG] DIR...")
+	}
+
+	mustConnectDocker()
+	auth := registryAuth()
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+
+	for _, dir := range fs.Args() {
+		base := filepath.Base(filepath.Clean(dir))
+		var names []string
+		repo := ""
+		for name, problemType := range problemTypes() {
+			if path.Base(imageRepository(problemType.Image)) == base {
+				names = append(names, name)
+				repo = imageRepository(problemType.Image)
+			}
+		}
+		if len(names) == 0 {
+			log.Printf("skipping %s: no problem type uses an image named %s", dir, base)
+			continue
+		}
+		sort.Strings(names)
+		local := repo + ":" + *tag
+
+		log.Printf("building %s from %s", local, dir)
+		err := dockerClient.BuildImage(docker.BuildImageOptions{
+			Name:           local,
+			ContextDir:     dir,
+			Pull:           true,
+			RmTmpContainer: true,
+			OutputStream:   os.Stdout,
+		})
+		if err != nil {
+			log.Fatalf("error building %s: %v", local, err)
+		}
+		if err := dockerClient.TagImage(local, docker.TagImageOptions{Repo: repo, Tag: "latest", Force: true}); err != nil {
+			log.Fatalf("error tagging %s: %v", local, err)
+		}
+
+		// a failed scan stops the image before it reaches any daycare
+		note := ""
+		if Config().ImageScanCommand != "" {
+			fields := strings.Fields(Config().ImageScanCommand)
+			log.Printf("scanning %s with %s", local, fields[0])
+			scan := exec.Command(fields[0], append(fields[1:], local)...)
+			scan.Stdout, scan.Stderr = os.Stdout, os.Stderr
+			if err := scan.Run(); err != nil {
+				log.Fatalf("scan of %s failed (%v); it was not pushed", local, err)
+			}
+			note = "passed " + fields[0]
+		}
+
+		remote := Config().ImageRegistry + "/" + base
+		if err := dockerClient.TagImage(local, docker.TagImageOptions{Repo: remote, Tag: *tag, Force: true}); err != nil {
+			log.Fatalf("error tagging %s: %v", local, err)
+		}
+		log.Printf("pushing %s:%s", remote, *tag)
+		if err := dockerClient.PushImage(docker.PushImageOptions{Name: remote, Tag: *tag, OutputStream: os.Stdout}, auth); err != nil {
+			log.Fatalf("error pushing %s:%s: %v", remote, *tag, err)
+		}
+
+		// daycares pull by digest when the registry reports one, so a tag moved later cannot change what they run
+		info, err := dockerClient.InspectImage(local)
+		if err != nil {
+			log.Fatalf("error inspecting %s: %v", local, err)
+		}
+		reference := remote + ":" + *tag
+		for _, digest := range info.RepoDigests {
+			if strings.HasPrefix(digest, remote+"@") {
+				reference = digest
+			}
+		}
+		now := time.Now()
+		for _, name := range names {
+			image := &ToolchainImage{
+				ProblemType: name,
+				Image:       problemTypes()[name].Image,
+				ImageID:     info.ID,
+				Reference:   reference,
+				ScanNote:    note,
+				CreatedAt:   now,
+			}
+			if err := meddler.Insert(db, "toolchain_images", image); err != nil {
+				log.Fatalf("db error recording image for %s: %v", name, err)
+			}
+		}
+		log.Printf("%s is now expected for %s", reference, strings.Join(names, ", "))
+	}
+}
+
+func commandImagesPull(args []string) {
+	fs := flag.NewFlagSet("images pull", flag.ExitOnError)
+	fs.Parse(args)
+
+	expected, err := fetchToolchainImages()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	mustConnectDocker()
+	auth := registryAuth()
+
+	failures := 0
+	for _, image := range expected {
+		if current, err := inspectToolchain(image.Image); err == nil && current.ImageID == image.ImageID {
+			continue
+		}
+		log.Printf("pulling %s for %s", image.Reference, image.ProblemType)
+		if err := installImage(image.Reference, image.Image, image.ImageID, auth); err != nil {
+			log.Printf("%v", err)
+			failures++
+		}
+	}
+	failures += pullDependencyImages(auth)
+	if failures > 0 {
+		log.Fatalf("%d image%s could not be installed", failures, plural(failures))
+	}
+	log.Printf("all %d toolchain image%s are up to date", len(expected), plural(len(expected)))
+}
+
+// installImage pulls an image from the registry and tags it with the name
+// grading uses, checking that it is the image expected.
+func installImage(reference, name, imageID string, auth docker.AuthConfiguration) error {
+	repo, tag := reference, ""
+	if !strings.Contains(repo, "@") {
+		repo, tag = imageRepository(reference), imageTag(reference)
+	}
+	if err := dockerClient.PullImage(docker.PullImageOptions{Repository: repo, Tag: tag, OutputStream: os.Stdout}, auth); err != nil {
+		return fmt.Errorf("error pulling %s: %v", reference, err)
+	}
+	opts := docker.TagImageOptions{Repo: imageRepository(name), Tag: imageTag(name), Force: true}
+	if err := dockerClient.TagImage(reference, opts); err != nil {
+		return fmt.Errorf("error tagging %s as %s: %v", reference, name, err)
+	}
+	if current, err := inspectToolchain(name); err != nil || current.ImageID != imageID {
+		return fmt.Errorf("%s is not the expected image %s after pulling", name, imageID)
+	}
+	return nil
+}
+
+// imageRepository returns an image name without its tag.
+func imageRepository(name string) string {
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i]
+	}
+	return name
+}
+
+// imageTag returns the tag of an image name, which defaults to latest.
+func imageTag(name string) string {
+	if repo := imageRepository(name); repo != name {
+		return name[len(repo)+1:]
+	}
+	return "latest"
+}
+
+// registryAuth returns the docker login credentials for the image registry, if any.
+func registryAuth() docker.AuthConfiguration {
+	configs, err := docker.NewAuthConfigurationsFromDockerCfg()
+	if err != nil {
+		return docker.AuthConfiguration{}
+	}
+	host := strings.SplitN(Config().ImageRegistry, "/", 2)[0]
+	return configs.Configs[host]
+}
+
+// GetToolchainImages handles /v2/toolchain_images requests,
+// returning the image every daycare is expected to run for each problem type.
+func GetToolchainImages(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	images := []*ToolchainImage{}
+	if err := meddler.QueryAll(tx, &images, latestToolchainImagesQuery); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, images)
+}
+
+// fetchToolchainImages asks the TA which images the daycare should run.
+func fetchToolchainImages() ([]*ToolchainImage, error) {
+	images := []*ToolchainImage{}
+	if err := fetchFromTA("/v2/toolchain_images", &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// fetchFromTA decodes the JSON the TA serves at the given path,
+// signing the request so the TA knows it comes from a daycare.
+func fetchFromTA(path string, elt interface{}) error {
+	u := &url.URL{Scheme: "https", Host: Config().Hostname, Path: path}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	at := time.Now().Unix()
+	req.Header.Set(daycareSignatureHeader, fmt.Sprintf("%d:%s", at, daycareSignature("GET", path, "", at)))
+	client := &http.Client{Timeout: toolchainImageTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TA returned %s for %s", resp.Status, u.String())
+	}
+	if err := json.NewDecoder(resp.Body).Decode(elt); err != nil {
+		return fmt.Errorf("error decoding %s: %v", path, err)
+	}
+	return nil
+}
+
+// compareToolchains explains each problem type whose toolchain is not the expected image.
+// Problem types with no expected image are not checked.
+func compareToolchains(expected []*ToolchainImage, current map[string]*Toolchain) map[string]string {
+	notes := make(map[string]string)
+	for _, image := range expected {
+		toolchain := current[image.ProblemType]
+		switch {
+		case toolchain == nil:
+			notes[image.ProblemType] = fmt.Sprintf("%s is not installed", image.Image)
+		case toolchain.ImageID != image.ImageID:
+			notes[image.ProblemType] = fmt.Sprintf("%s is %.19s, but %.19s is expected", image.Image, toolchain.ImageID, image.ImageID)
+		}
+	}
+	return notes
+}
+
+// probeToolchainImages checks that the daycare runs the expected image for each
+// problem type, so a daycare that missed an update is taken out of rotation.
+func probeToolchainImages() (string, error) {
+	expected, err := fetchToolchainImages()
+	if err != nil {
+		return "", err
+	}
+	current := make(map[string]*Toolchain)
+	for _, image := range expected {
+		if toolchain, err := inspectToolchain(image.Image); err == nil {
+			current[image.ProblemType] = toolchain
+		}
+	}
+	notes := compareToolchains(expected, current)
+	if len(notes) > 0 {
+		var msgs []string
+		for name, note := range notes {
+			msgs = append(msgs, name+": "+note)
+		}
+		sort.Strings(msgs)
+		return "", fmt.Errorf("%s", strings.Join(msgs, "; "))
+	}
+	return fmt.Sprintf("%d toolchain image%s as expected", len(expected), plural(len(expected))), nil
+}
+
+// toolchainMismatches records the problem types the daycare cannot be trusted
+// to grade, as found by checkToolchainImages. Images lists every expected image
+// by name, with a note for each that the daycare does not run as expected.
+var toolchainMismatches = struct {
+	sync.Mutex
+	notes  map[string]string
+	images map[string]string
+}{notes: make(map[string]string), images: make(map[string]string)}
+
+// toolchainMismatch explains why jobs for a problem type should not be
+// sent to the daycare, or returns an empty string if they can be.
+func toolchainMismatch(problemType string) string {
+	toolchainMismatches.Lock()
+	defer toolchainMismatches.Unlock()
+	return toolchainMismatches.notes[problemType]
+}
+
+// overrideImageMismatch explains why a course cannot grade with the given image
+// in place of its problem type's own, or returns an empty string if it can.
+// When images come from the registry, a course image must be one built by
+// codegrinder images build, and the daycare must run the expected build of it,
+// so it is checked the same way as the problem type images.
+func overrideImageMismatch(image string) string {
+	if image == "" || Config().ImageRegistry == "" {
+		return ""
+	}
+	toolchainMismatches.Lock()
+	defer toolchainMismatches.Unlock()
+	note, known := toolchainMismatches.images[image]
+	if !known {
+		return fmt.Sprintf("%s is not a toolchain image built by codegrinder images build", image)
+	}
+	return note
+}
+
+// checkToolchainImagesLoop periodically compares the toolchains on the daycare
+// with the expected images.
+func checkToolchainImagesLoop(db *sql.DB) {
+	for {
+		if err := checkToolchainImages(db); err != nil {
+			log.Printf("error checking toolchain images: %v", err)
+		}
+		time.Sleep(toolchainImageCheckInterval)
+	}
+}
+
+func checkToolchainImages(db *sql.DB) error {
+	// the images are built for the whole server and recorded with the default tenant
+	expected := []*ToolchainImage{}
+	err := withTenantTx(db, defaultTenant(), func(tx *sql.Tx, tenant *TenantConfig) error {
+		return meddler.QueryAll(tx, &expected, latestToolchainImagesQuery)
+	})
+	if err != nil {
+		return err
+	}
+	current, err := getDaycareToolchains(Config().DaycareHost)
+	if err != nil {
+		return err
+	}
+	notes := compareToolchains(expected, current)
+	images := make(map[string]string)
+	for _, image := range expected {
+		if images[image.Image] == "" {
+			images[image.Image] = notes[image.ProblemType]
+		}
+	}
+
+	toolchainMismatches.Lock()
+	defer toolchainMismatches.Unlock()
+	for name, note := range notes {
+		if toolchainMismatches.notes[name] == "" {
+			log.Printf("holding %s jobs: %s", name, note)
+		}
+	}
+	for name := range toolchainMismatches.notes {
+		if notes[name] == "" {
+			log.Printf("daycare now runs the expected toolchain for %s", name)
+		}
+	}
+	toolchainMismatches.notes = notes
+	toolchainMismatches.images = images
+	return nil
+}
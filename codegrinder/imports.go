@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetProblemSetImports handles /v2/problem_sets/:problem_set_id/imports requests,
+// returning the files each problem in the set takes from an earlier problem.
+func GetProblemSetImports(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	imports := []*ProblemSetImport{}
+	if err := meddler.QueryAll(tx, &imports, `SELECT * FROM problem_set_imports WHERE problem_set_id = $1 ORDER BY problem_id`, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, imports)
+}
+
+// PutProblemSetImport handles /v2/problem_sets/:problem_set_id/imports/:problem_id requests,
+// making a problem use files the student wrote for an earlier problem in the set.
+// Both problems must already be in the set, and the files must be student files
+// in the earlier problem. The import is returned.
+func PutProblemSetImport(w http.ResponseWriter, tx *sql.Tx, params martini.Params, imp ProblemSetImport, render render.Render) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if imp.FromProblemID == problemID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a problem cannot import files from itself")
+		return
+	}
+	for _, id := range []int64{problemID, imp.FromProblemID} {
+		if _, err := getProblemTrack(tx, problemSetID, id); err != nil {
+			if err == sql.ErrNoRows {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "problem %d is not part of problem set %d", id, problemSetID)
+			} else {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			}
+			return
+		}
+	}
+
+	// only student files can be imported
+	from := new(Problem)
+	if err := meddler.Load(tx, "problems", from, imp.FromProblemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	steps := []*ProblemStep{}
+	if err := meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = $1 ORDER BY step`, from.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if len(steps) == 0 {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "no steps found for problem %s (%d)", from.Unique, from.ID)
+		return
+	}
+	whitelists := from.GetStepWhitelists(steps)
+	var files []string
+	for _, name := range imp.Files {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !whitelists[len(whitelists)-1][name] {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "%s is not a student file in problem %s", name, from.Unique)
+			return
+		}
+		files = append(files, name)
+	}
+	if len(files) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "name at least one file to import")
+		return
+	}
+	sort.Strings(files)
+
+	now := time.Now()
+	imp.ProblemSetID = problemSetID
+	imp.ProblemID = problemID
+	imp.Files = files
+	imp.UpdatedAt = now
+	old := new(ProblemSetImport)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM problem_set_imports WHERE problem_set_id = $1 AND problem_id = $2`, problemSetID, problemID)
+	switch {
+	case err == sql.ErrNoRows:
+		imp.CreatedAt = now
+		err = meddler.Insert(tx, "problem_set_imports", &imp)
+	case err == nil:
+		imp.CreatedAt = old.CreatedAt
+		var raw []byte
+		if raw, err = json.Marshal(files); err == nil {
+			_, err = tx.Exec(`UPDATE problem_set_imports SET from_problem_id = $1, files = $2, updated_at = $3 WHERE problem_set_id = $4 AND problem_id = $5`,
+				imp.FromProblemID, raw, now, problemSetID, problemID)
+		}
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, &imp)
+}
+
+// DeleteProblemSetImport handles /v2/problem_sets/:problem_set_id/imports/:problem_id requests,
+// so the problem no longer uses files from an earlier problem.
+func DeleteProblemSetImport(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM problem_set_imports WHERE problem_set_id = $1 AND problem_id = $2`, problemSetID, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// gatherImports collects the files a problem takes from an earlier problem in the
+// assignment's problem set, or nil if it takes none. The files come from the
+// student's passing commit for the last step of the earlier problem; if there is
+// none, the error says which problem must be finished first.
+func gatherImports(tx *sql.Tx, assignment *Assignment, problem *Problem) (map[string]string, error) {
+	imp := new(ProblemSetImport)
+	err := meddler.QueryRow(tx, imp, `SELECT * FROM problem_set_imports WHERE problem_set_id = $1 AND problem_id = $2`,
+		assignment.ProblemSetID, problem.ID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+
+	from := new(Problem)
+	if err := meddler.Load(tx, "problems", from, imp.FromProblemID); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	commit, err := getPassingCommit(tx, assignment.ID, from.ID)
+	if err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if commit == nil {
+		return nil, httpErrorf(http.StatusForbidden, "%s uses %s from %s; pass every step of %s before this can be graded",
+			problem.Unique, strings.Join(imp.Files, ", "), from.Unique, from.Unique)
+	}
+
+	files := make(map[string]string)
+	for _, name := range imp.Files {
+		contents, exists := commit.Files[name]
+		if !exists {
+			return nil, httpErrorf(http.StatusForbidden, "%s uses %s from %s, but your passing work for %s does not include it",
+				problem.Unique, name, from.Unique, from.Unique)
+		}
+		files[name] = contents
+	}
+	return files, nil
+}
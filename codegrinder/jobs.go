@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// daycareSignatureHeader carries the signature on requests between the TA and the daycare
+// that are not part of grading, in the form "<unix time>:<signature>".
+const daycareSignatureHeader = "X-Daycare-Signature"
+
+// daycareJobs tracks the jobs running on this daycare.
+var daycareJobs = struct {
+	sync.Mutex
+	next    int64
+	running map[int64]*runningJob
+}{running: make(map[int64]*runningJob)}
+
+type runningJob struct {
+	Job   *DaycareJob
+	Nanny *Nanny
+}
+
+// startDaycareJob records a job so it can be listed and stopped while it runs.
+// The tenant comes from the signed commit bundle, so each TA tenant only sees its own jobs.
+func startDaycareJob(n *Nanny, tenant string, userID int64, problemType string, problem *Problem, commit *Commit) *DaycareJob {
+	daycareJobs.Lock()
+	defer daycareJobs.Unlock()
+	daycareJobs.next++
+	job := &DaycareJob{
+		ID:           daycareJobs.next,
+		UserID:       userID,
+		AssignmentID: commit.AssignmentID,
+		ProblemID:    problem.ID,
+		Unique:       problem.Unique,
+		ProblemType:  problemType,
+		Action:       commit.Action,
+		Tenant:       tenant,
+		StartedAt:    n.Start,
+	}
+	daycareJobs.running[job.ID] = &runningJob{Job: job, Nanny: n}
+	return job
+}
+
+func finishDaycareJob(id int64) {
+	daycareJobs.Lock()
+	defer daycareJobs.Unlock()
+	delete(daycareJobs.running, id)
+}
+
+// daycareSignature signs a request between the TA and the daycare,
+// covering the query string so parameters cannot be changed or added.
+func daycareSignature(method, path, query string, at int64) string {
+	mac := hmac.New(sha256.New, []byte(Config().DaycareSecret))
+	fmt.Fprintf(mac, "%s %s?%s %d", method, path, query, at)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// daycareSignedOnly is martini middleware that only lets requests signed
+// with the DaycareSecret through, i.e., the TA calling the daycare or the
+// daycare calling the TA.
+func daycareSignedOnly(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(r.Header.Get(daycareSignatureHeader), ":", 2)
+	if len(parts) != 2 {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "request must be signed with the daycare secret")
+		return
+	}
+	at, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "bad signature time")
+		return
+	}
+	age := time.Since(time.Unix(at, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > MaxDaycareRequestAge {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "signature is %v off, cannot be more than %v", age, MaxDaycareRequestAge)
+		return
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(daycareSignature(r.Method, r.URL.Path, r.URL.RawQuery, at))) {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "signature mismatch")
+		return
+	}
+}
+
+// GetDaycareJobs handles /v2/daycare_jobs requests on the daycare,
+// returning the jobs running now for the tenant given in the tenant parameter, oldest first.
+func GetDaycareJobs(w http.ResponseWriter, r *http.Request, render render.Render) {
+	tenant := r.FormValue("tenant")
+	daycareJobs.Lock()
+	jobs := []*DaycareJob{}
+	for _, elt := range daycareJobs.running {
+		if strings.EqualFold(elt.Job.Tenant, tenant) {
+			jobs = append(jobs, elt.Job)
+		}
+	}
+	daycareJobs.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	render.JSON(http.StatusOK, jobs)
+}
+
+// DeleteDaycareJob handles /v2/daycare_jobs/:job_id requests on the daycare,
+// stopping a running job for the tenant given in the tenant parameter.
+// The student sees the reason in the report card.
+func DeleteDaycareJob(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	jobID, err := parseID(w, "job_id", params["job_id"])
+	if err != nil {
+		return
+	}
+	daycareJobs.Lock()
+	elt, exists := daycareJobs.running[jobID]
+	daycareJobs.Unlock()
+	if !exists || !strings.EqualFold(elt.Job.Tenant, r.FormValue("tenant")) {
+		loggedHTTPErrorf(w, http.StatusNotFound, "job %d is not running", jobID)
+		return
+	}
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = "stopped by an instructor"
+	}
+	log.Printf("stopping job %d (%s %s for user %d): %s", jobID, elt.Job.Action, elt.Job.Unique, elt.Job.UserID, reason)
+	elt.Nanny.Stop(reason)
+	w.WriteHeader(http.StatusOK)
+}
+
+// daycareDo makes a signed request from the TA to the daycare,
+// decoding the JSON response into out if it is not nil.
+func daycareDo(method, path string, params url.Values, out interface{}) error {
+	u := &url.URL{Scheme: "https", Host: Config().DaycareHost, Path: path, RawQuery: params.Encode()}
+	query := u.RawQuery
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	at := time.Now().Unix()
+	req.Header.Set(daycareSignatureHeader, fmt.Sprintf("%d:%s", at, daycareSignature(method, path, query, at)))
+	client := &http.Client{Timeout: healthProbeTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return httpErrorf(http.StatusNotFound, "that job is not running")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("daycare returned %s for %s", resp.Status, u.String())
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// getJobs fetches the running jobs for this tenant from the daycare and fills
+// in the course and student for each. If courseID is non-zero, only jobs in
+// that course are returned.
+func getJobs(tx *sql.Tx, tenant *TenantConfig, courseID int64) ([]*DaycareJob, error) {
+	all := []*DaycareJob{}
+	if err := daycareDo("GET", "/v2/daycare_jobs", url.Values{"tenant": {tenant.Hostname}}, &all); err != nil {
+		if _, ok := err.(*APIError); ok {
+			return nil, err
+		}
+		return nil, httpErrorf(http.StatusBadGateway, "unable to reach the daycare: %v", err)
+	}
+	jobs := []*DaycareJob{}
+	for _, job := range all {
+		var courseForJob int64
+		var email string
+		err := tx.QueryRow(`SELECT assignments.course_id, users.email FROM assignments `+
+			`JOIN users ON assignments.user_id = users.id `+
+			`JOIN problem_set_problems ON assignments.problem_set_id = problem_set_problems.problem_set_id `+
+			`WHERE assignments.id = $1 AND problem_set_problems.problem_id = $2`,
+			job.AssignmentID, job.ProblemID).Scan(&courseForJob, &email)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if courseID != 0 && courseForJob != courseID {
+			continue
+		}
+		job.CourseID = courseForJob
+		job.Email = email
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// GetJobs handles /v2/jobs requests,
+// returning every grading job running on the daycare for this tenant.
+func GetJobs(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, render render.Render) {
+	jobs, err := getJobs(tx, tenant, 0)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, jobs)
+}
+
+// GetCourseJobs handles /v2/courses/:course_id/jobs requests,
+// returning the grading jobs running on the daycare for students in the course.
+func GetCourseJobs(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	jobs, err := getJobs(tx, tenant, courseID)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, jobs)
+}
+
+// DeleteJob handles /v2/jobs/:job_id and /v2/courses/:course_id/jobs/:job_id requests,
+// stopping a running grading job. Through a course, only jobs in that course can be stopped.
+func DeleteJob(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, currentUser *User, params martini.Params) {
+	jobID, err := parseID(w, "job_id", params["job_id"])
+	if err != nil {
+		return
+	}
+	var courseID int64
+	if params["course_id"] != "" {
+		if courseID, err = parseID(w, "course_id", params["course_id"]); err != nil {
+			return
+		}
+	}
+	jobs, err := getJobs(tx, tenant, courseID)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	var job *DaycareJob
+	for _, elt := range jobs {
+		if elt.ID == jobID {
+			job = elt
+		}
+	}
+	if job == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "job %d is not running", jobID)
+		return
+	}
+
+	reason := fmt.Sprintf("stopped by %s", currentUser.Name)
+	if err := daycareDo("DELETE", fmt.Sprintf("/v2/daycare_jobs/%d", jobID), url.Values{"reason": {reason}, "tenant": {tenant.Hostname}}, nil); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	log.Printf("job %d (%s for %s) %s", jobID, job.Unique, job.Email, reason)
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkSuspension returns an error if the student may not have work graded in the course.
+func checkSuspension(tx *sql.Tx, now time.Time, userID, courseID int64) error {
+	suspension := new(CourseSuspension)
+	err := meddler.QueryRow(tx, suspension, `SELECT * FROM course_suspensions WHERE course_id = $1 AND user_id = $2 AND until > $3`,
+		courseID, userID, now)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return dbNotFoundError(err)
+	}
+	e := NewAPIError(http.StatusForbidden, suspension.Message())
+	e.Hint = "you can still save your work; ask your instructor if you think this is a mistake"
+	return e
+}
+
+// GetCourseSuspensions handles /v2/courses/:course_id/suspensions requests,
+// returning the suspensions in the course that have not yet ended.
+func GetCourseSuspensions(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	suspensions := []*CourseSuspension{}
+	if err := meddler.QueryAll(tx, &suspensions, `SELECT * FROM course_suspensions WHERE course_id = $1 AND until > $2 ORDER BY until`,
+		courseID, time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, suspensions)
+}
+
+// PutCourseSuspension handles /v2/courses/:course_id/suspensions/:user_id requests,
+// stopping a student from having work graded in the course until the given time.
+// The suspension is returned.
+func PutCourseSuspension(w http.ResponseWriter, tx *sql.Tx, currentUser *User, params martini.Params, suspension CourseSuspension, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if !suspension.Until.After(now) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a suspension must end in the future")
+		return
+	}
+	var enrolled bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE user_id = $1 AND course_id = $2 AND NOT instructor)`,
+		userID, courseID).Scan(&enrolled); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !enrolled {
+		loggedHTTPErrorf(w, http.StatusNotFound, "user %d is not a student in course %d", userID, courseID)
+		return
+	}
+
+	suspension.CourseID = courseID
+	suspension.UserID = userID
+	suspension.Reason = strings.TrimSpace(suspension.Reason)
+	suspension.SuspendedBy = currentUser.ID
+	suspension.UpdatedAt = now
+	var createdAt time.Time
+	err = tx.QueryRow(`SELECT created_at FROM course_suspensions WHERE course_id = $1 AND user_id = $2`, courseID, userID).Scan(&createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+		suspension.CreatedAt = now
+		err = meddler.Insert(tx, "course_suspensions", &suspension)
+	case err == nil:
+		suspension.CreatedAt = createdAt
+		_, err = tx.Exec(`UPDATE course_suspensions SET until = $1, reason = $2, suspended_by = $3, updated_at = $4 `+
+			`WHERE course_id = $5 AND user_id = $6`,
+			suspension.Until, suspension.Reason, suspension.SuspendedBy, now, courseID, userID)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("user %d suspended in course %d until %v by %s", userID, courseID, suspension.Until, currentUser.Name)
+	render.JSON(http.StatusOK, &suspension)
+}
+
+// DeleteCourseSuspension handles /v2/courses/:course_id/suspensions/:user_id requests,
+// ending a suspension early.
+func DeleteCourseSuspension(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM course_suspensions WHERE course_id = $1 AND user_id = $2`, courseID, userID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// labCodeAlphabet leaves out letters and digits that are easy to confuse when read off a projector.
+const labCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const labCodeLength = 6
+
+// newLabCode generates a lab code that is not in use by any session that has not expired.
+func newLabCode(tx *sql.Tx, now time.Time) (string, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		buf := make([]byte, labCodeLength)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for i := range buf {
+			buf[i] = labCodeAlphabet[int(buf[i])%len(labCodeAlphabet)]
+		}
+		code := string(buf)
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM lab_sessions WHERE code = $1 AND expires_at > $2)`, code, now).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find an unused lab code")
+}
+
+// checkLabUnlock returns an error if the assignment is locked until the student
+// redeems a lab code. Instructors are never locked out.
+func checkLabUnlock(tx *sql.Tx, asst *Assignment) error {
+	if asst.Instructor {
+		return nil
+	}
+	var required, redeemed bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM lab_sessions WHERE course_id = $1 AND problem_set_id = $2 AND purpose = $3), `+
+		`EXISTS (SELECT 1 FROM lab_redemptions JOIN lab_sessions ON lab_redemptions.lab_session_id = lab_sessions.id `+
+		`WHERE lab_redemptions.assignment_id = $4 AND lab_sessions.purpose = $3)`,
+		asst.CourseID, asst.ProblemSetID, LabUnlock, asst.ID).Scan(&required, &redeemed); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if required && !redeemed {
+		return httpErrorf(http.StatusForbidden, "this assignment is done in the lab; it is locked until you redeem the code given there with \"grind redeem\"")
+	}
+	return nil
+}
+
+// GetCourseProblemSetLabSessions handles /v2/courses/:course_id/problem_sets/:problem_set_id/lab_sessions requests,
+// returning the lab codes issued for a problem set with the number of students who redeemed each.
+func GetCourseProblemSetLabSessions(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	sessions := []*LabSession{}
+	if err := meddler.QueryAll(tx, &sessions, `SELECT * FROM lab_sessions WHERE course_id = $1 AND problem_set_id = $2 ORDER BY created_at, id`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, session := range sessions {
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM lab_redemptions WHERE lab_session_id = $1`, session.ID).Scan(&session.Redeemed); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+	render.JSON(http.StatusOK, sessions)
+}
+
+// PostCourseProblemSetLabSession handles /v2/courses/:course_id/problem_sets/:problem_set_id/lab_sessions requests,
+// issuing a new lab code for the problem set that students can redeem until it expires.
+// Issuing the first code that unlocks a problem set locks it for every student who has not
+// redeemed one. The session is returned with its code.
+func PostCourseProblemSetLabSession(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, session LabSession, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+	if err := session.Normalize(now); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	session.ID = 0
+	session.CourseID = courseID
+	session.ProblemSetID = problemSetID
+	session.CreatedBy = currentUser.ID
+	session.Redeemed = 0
+	session.CreatedAt = now
+	if session.Code, err = newLabCode(tx, now); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating lab code: %v", err)
+		return
+	}
+	if err := meddler.Insert(tx, "lab_sessions", &session); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("lab code for %s on problem set %d in course %d issued by user %d (%s) until %s",
+		session.Purpose, problemSetID, courseID, currentUser.ID, currentUser.Name, session.ExpiresAt.Format(time.RFC3339))
+	render.JSON(http.StatusOK, &session)
+}
+
+// DeleteCourseProblemSetLabSession handles /v2/courses/:course_id/problem_sets/:problem_set_id/lab_sessions/:lab_session_id requests,
+// expiring a lab code early. Students who already redeemed it keep what it gave them.
+func DeleteCourseProblemSetLabSession(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	sessionID, err := parseID(w, "lab_session_id", params["lab_session_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	session := new(LabSession)
+	if err := meddler.QueryRow(tx, session, `SELECT * FROM lab_sessions WHERE id = $1 AND course_id = $2 AND problem_set_id = $3`,
+		sessionID, courseID, problemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if session.IsExpired(now) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if _, err := tx.Exec(`UPDATE lab_sessions SET expires_at = $1 WHERE id = $2`, now, sessionID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PostLabRedemption handles /v2/lab_redemptions requests,
+// redeeming a lab code for the current user. A code that unlocks a problem set lets the
+// student open it; a code for credit checks the student off, updating their score.
+// Redeeming the same code twice has no further effect. The redemption is returned.
+func PostLabRedemption(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, currentUser *User, labCode LabCode, render render.Render) {
+	now := time.Now()
+	code := strings.ToUpper(strings.TrimSpace(labCode.Code))
+
+	session := new(LabSession)
+	if err := meddler.QueryRow(tx, session, `SELECT * FROM lab_sessions WHERE code = $1 AND expires_at > $2`, code, now); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusNotFound, "lab code %s is not valid or has expired", code)
+			return
+		}
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	asst := new(Assignment)
+	if err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE course_id = $1 AND problem_set_id = $2 AND user_id = $3`,
+		session.CourseID, session.ProblemSetID, currentUser.ID); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusNotFound, "lab code %s is for an assignment you have not opened; open it from the course page first", code)
+			return
+		}
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if asst.IsDropped() {
+		loggedHTTPErrorf(w, http.StatusForbidden, "you are no longer enrolled in this course")
+		return
+	}
+
+	redemption := new(LabRedemption)
+	err := meddler.QueryRow(tx, redemption, `SELECT * FROM lab_redemptions WHERE lab_session_id = $1 AND assignment_id = $2`, session.ID, asst.ID)
+	switch {
+	case err == sql.ErrNoRows:
+		redemption = &LabRedemption{LabSessionID: session.ID, AssignmentID: asst.ID, RedeemedAt: now}
+		if err := meddler.Insert(tx, "lab_redemptions", redemption); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if session.Purpose == LabCredit {
+			if err := checkOffFromLab(tx, tenant, asst, session, now); err != nil {
+				loggedHTTPError(w, err)
+				return
+			}
+		}
+		log.Printf("lab code for assignment %d redeemed by user %d (%s)", asst.ID, currentUser.ID, currentUser.Name)
+	case err != nil:
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	redemption.ProblemSetID = session.ProblemSetID
+	redemption.Purpose = session.Purpose
+	render.JSON(http.StatusOK, redemption)
+}
+
+// checkOffFromLab checks a student off after they redeem a lab code for credit,
+// crediting the instructor who issued the code. An existing check-off is kept.
+func checkOffFromLab(tx *sql.Tx, tenant *TenantConfig, asst *Assignment, session *LabSession, now time.Time) error {
+	old, err := getCheckoff(tx, asst.ID)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if old != nil {
+		return nil
+	}
+	checkoff := &Checkoff{
+		AssignmentID: asst.ID,
+		Note:         fmt.Sprintf("redeemed lab code %s", session.Code),
+		GraderID:     session.CreatedBy,
+		CheckedOffAt: now,
+	}
+	if err := meddler.Insert(tx, "checkoffs", checkoff); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	return rescoreAssignment(tx, tenant, asst, now)
+}
@@ -61,6 +61,13 @@ type LTIRequest struct {
 	CanvasAssignmentTitle            string  `form:"custom_canvas_assignment_title"`           // YouFace Template
 	CanvasAssignmentID               int64   `form:"custom_canvas_assignment_id"`              // 1566693
 	CanvasAPIDomain                  string  `form:"custom_canvas_api_domain"`                 // dixie.instructure.com
+	CanvasTermName                   string  `form:"custom_canvas_term_name"`                  // Fall 2016
+	CanvasTermEndAt                  string  `form:"custom_canvas_term_end_at"`                // 2016-12-17T06:59:59Z
+	CanvasAssignmentDueAt            string  `form:"custom_canvas_assignment_due_at"`          // 2016-12-10T06:59:59Z
+	CanvasSectionIDs                 string  `form:"custom_canvas_course_section_ids"`         // 1234,1235
+	CanvasSectionNames               string  `form:"custom_canvas_course_section_names"`       // ["CS-1400-01","CS-1400-02"]
+	ExtMembershipsURL                string  `form:"ext_ims_lis_memberships_url"`              // https://... to read the course roster
+	ExtMembershipsID                 string  `form:"ext_ims_lis_memberships_id"`               // <opaque>: roster ID for the course
 	OAuthVersion                     string  `form:"oauth_version"`                            // 1.0
 	OAuthSignature                   string  `form:"oauth_signature"`                          // <opaque> base64
 	OAuthSignatureMethod             string  `form:"oauth_signature_method"`                   // HMAC-SHA1
@@ -127,7 +134,11 @@ type LTICartridge struct {
 }
 
 // GetConfigXML handles /lti/config.xml requests, returning an XML file to configure the LMS to use this tool.
-func GetConfigXML(w http.ResponseWriter) {
+func GetConfigXML(w http.ResponseWriter, r *http.Request) {
+	tenant := mustFindTenant(w, r)
+	if tenant == nil {
+		return
+	}
 	c := &LTIConfig{
 		Namespace:      "http://www.imsglobal.org/xsd/imslticc_v1p0",
 		NamespaceBLTI:  "http://www.imsglobal.org/xsd/imsbasiclti_v1p0",
@@ -138,21 +149,21 @@ func GetConfigXML(w http.ResponseWriter) {
 			" http://www.imsglobal.org/xsd/imsbasiclti_v1p0 http://www.imsglobal.org/xsd/lti/ltiv1p0/imsbasiclti_v1p0.xsd" +
 			" http://www.imsglobal.org/xsd/imslticm_v1p0 http://www.imsglobal.org/xsd/lti/ltiv1p0/imslticm_v1p0.xsd" +
 			" http://www.imsglobal.org/xsd/imslticp_v1p0 http://www.imsglobal.org/xsd/lti/ltiv1p0/imslticp_v1p0.xsd",
-		Title:       Config.ToolName,
-		Description: Config.ToolDescription,
+		Title:       tenant.ToolName,
+		Description: tenant.ToolDescription,
 		Extensions: LTIConfigExtensions{
 			Platform: "canvas.instructure.com",
 			Extensions: []LTIConfigExtension{
-				LTIConfigExtension{Name: "tool_id", Value: Config.ToolID},
+				LTIConfigExtension{Name: "tool_id", Value: tenant.ToolID},
 				LTIConfigExtension{Name: "privacy_level", Value: "public"},
-				LTIConfigExtension{Name: "domain", Value: Config.Hostname},
+				LTIConfigExtension{Name: "domain", Value: tenant.Hostname},
 			},
 			Options: []LTIConfigOptions{
 				LTIConfigOptions{
 					Name: "resource_selection",
 					Options: []LTIConfigExtension{
-						LTIConfigExtension{Name: "url", Value: "https://" + Config.Hostname + "/v2/lti/problem_sets"},
-						LTIConfigExtension{Name: "text", Value: Config.ToolName},
+						LTIConfigExtension{Name: "url", Value: "https://" + tenant.Hostname + "/v2/lti/problem_sets"},
+						LTIConfigExtension{Name: "text", Value: tenant.ToolName},
 						LTIConfigExtension{Name: "selection_width", Value: "320"},
 						LTIConfigExtension{Name: "selection_height", Value: "640"},
 						LTIConfigExtension{Name: "enabled", Value: "true"},
@@ -175,7 +186,7 @@ func GetConfigXML(w http.ResponseWriter) {
 	}
 }
 
-func signXMLRequest(consumerKey, method, targetURL, content, secret string) string {
+func signXMLRequest(consumerKey, method, targetURL, content, secret, hostname string) string {
 	sum := sha1.Sum([]byte(content))
 	bodyHash := base64.StdEncoding.EncodeToString(sum[:])
 
@@ -195,7 +206,7 @@ func signXMLRequest(consumerKey, method, targetURL, content, secret string) stri
 
 	// form the Authorization header
 	var buf bytes.Buffer
-	buf.WriteString(fmt.Sprintf(`OAuth realm="%s"`, escape("https://"+Config.Hostname)))
+	buf.WriteString(fmt.Sprintf(`OAuth realm="%s"`, escape("https://"+hostname)))
 	for key, val := range v {
 		buf.WriteString(fmt.Sprintf(`,%s="%s"`, key, escape(val[0])))
 	}
@@ -230,8 +241,14 @@ func checkOAuthSignature(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// each tenant has its own secret
+	tenant := mustFindTenant(w, r)
+	if tenant == nil {
+		return
+	}
+
 	// compute the signature
-	sig := computeOAuthSignature(r.Method, getMyURL(r, true).String(), r.Form, Config.LTISecret)
+	sig := computeOAuthSignature(r.Method, getMyURL(r, true).String(), r.Form, tenant.LTISecret)
 
 	// verify it
 	if sig != expected {
@@ -317,7 +334,7 @@ func encode(v url.Values) string {
 // LtiProblem handles /lti/problem/:unique requests.
 // It creates the user/course/assignment if necessary, creates a session,
 // and redirects the user to the main UI URL.
-func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIRequest, params martini.Params, session sessions.Session) {
+func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, tenant *TenantConfig, form LTIRequest, params martini.Params, session sessions.Session) {
 	unique := params["unique"]
 	if unique == "" {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "malformed URL: missing unique ID for problem")
@@ -358,19 +375,34 @@ func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIR
 		return
 	}
 
+	// note that the problem set is offered in this course
+	if err := addCourseProblemSet(tx, course.ID, problemSet.ID, now); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// record which sections the user is in
+	if err := updateSections(tx, &form, now, course, user, asst); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
 	// sign the user in
 	session.Set("id", user.ID)
+	session.Set("tenant", tenant.Hostname)
 
-	// redirect to the console
-	//http.Redirect(w, r, fmt.Sprintf("/#/assignment/%d", asst.ID), http.StatusSeeOther)
-	_ = asst
-	http.Redirect(w, r, "/v2/users/me/cookie", http.StatusSeeOther)
+	// redirect to the browser workspace, which also points to the cookie for grind
+	if Config().WorkspaceLibDir == "" {
+		http.Redirect(w, r, "/v2/users/me/cookie", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/workspace/%d", asst.ID), http.StatusSeeOther)
 }
 
 // LtiProblemSets handles /lti/problem_set requests.
 // It creates the user/course if necessary, creates a session,
 // and redirects the user to the problem set picker UI URL.
-func LtiProblemSets(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIRequest, render render.Render, session sessions.Session) {
+func LtiProblemSets(w http.ResponseWriter, r *http.Request, tx *sql.Tx, tenant *TenantConfig, form LTIRequest, render render.Render, session sessions.Session) {
 	now := time.Now()
 
 	// load the coarse
@@ -388,6 +420,7 @@ func LtiProblemSets(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTI
 
 	// sign the user in
 	session.Set("id", user.ID)
+	session.Set("tenant", tenant.Hostname)
 
 	u := &url.URL{
 		Path: "/",
@@ -460,17 +493,48 @@ func getUpdateCourse(tx *sql.Tx, form *LTIRequest, now time.Time) (*Course, erro
 		course.UpdatedAt = now
 	}
 
+	// the term is only reported if the LMS is configured to send it,
+	// so leave any term set by an instructor alone otherwise;
+	// the end date is also left alone once an instructor has overridden it
+	term, endsAt := course.Term, course.EndsAt
+	if form.CanvasTermName != "" {
+		term = form.CanvasTermName
+	}
+	if form.CanvasTermEndAt != "" && !course.ArchiveOverride {
+		if t, err := time.Parse(time.RFC3339, form.CanvasTermEndAt); err == nil {
+			endsAt = t
+		} else {
+			log.Printf("unable to parse term end time %q for course %s: %v", form.CanvasTermEndAt, form.ContextID, err)
+		}
+	}
+
+	// likewise the roster service is only reported if the LMS offers it
+	rosterURL, rosterID, rosterConsumerKey := course.RosterURL, course.RosterID, course.RosterConsumerKey
+	if form.ExtMembershipsURL != "" && form.ExtMembershipsID != "" {
+		rosterURL, rosterID, rosterConsumerKey = form.ExtMembershipsURL, form.ExtMembershipsID, form.OAuthConsumerKey
+	}
+
 	// any changes?
 	changed := course.Name != form.ContextTitle ||
 		course.Label != form.ContextLabel ||
 		course.LtiID != form.ContextID ||
-		course.CanvasID != form.CanvasCourseID
+		course.CanvasID != form.CanvasCourseID ||
+		course.Term != term ||
+		!course.EndsAt.Equal(endsAt) ||
+		course.RosterURL != rosterURL ||
+		course.RosterID != rosterID ||
+		course.RosterConsumerKey != rosterConsumerKey
 
 	// make any changes
 	course.Name = form.ContextTitle
 	course.Label = form.ContextLabel
 	course.LtiID = form.ContextID
 	course.CanvasID = form.CanvasCourseID
+	course.Term = term
+	course.EndsAt = endsAt
+	course.RosterURL = rosterURL
+	course.RosterID = rosterID
+	course.RosterConsumerKey = rosterConsumerKey
 	if course.ID < 1 || changed {
 		// if something changed, note the update time and save
 		if course.ID > 0 {
@@ -505,6 +569,16 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 		asst.UpdatedAt = now
 	}
 
+	// the due date is only reported if the LMS is configured to send it
+	dueAt := asst.DueAt
+	if form.CanvasAssignmentDueAt != "" {
+		if t, err := time.Parse(time.RFC3339, form.CanvasAssignmentDueAt); err == nil {
+			dueAt = t
+		} else {
+			log.Printf("unable to parse due time %q for assignment %s: %v", form.CanvasAssignmentDueAt, form.ResourceLinkID, err)
+		}
+	}
+
 	// any changes?
 	changed := asst.CourseID != course.ID ||
 		asst.ProblemSetID != problemSet.ID ||
@@ -520,7 +594,9 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 		asst.OutcomeExtURL != form.ExtIMSBasicOutcomeURL ||
 		asst.OutcomeExtAccepted != form.ExtOutcomeDataValuesAccepted ||
 		asst.FinishedURL != form.LaunchPresentationReturnURL ||
-		asst.ConsumerKey != form.OAuthConsumerKey
+		asst.ConsumerKey != form.OAuthConsumerKey ||
+		!asst.DueAt.Equal(dueAt) ||
+		asst.IsDropped()
 
 	// make any changes
 	asst.CourseID = course.ID
@@ -559,6 +635,13 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 	asst.OutcomeExtAccepted = form.ExtOutcomeDataValuesAccepted
 	asst.FinishedURL = form.LaunchPresentationReturnURL
 	asst.ConsumerKey = form.OAuthConsumerKey
+	asst.DueAt = dueAt
+
+	// a launch from the LMS means the student is enrolled again
+	if asst.IsDropped() {
+		log.Printf("user %d (%s) has returned to course %d (%s)", user.ID, user.Email, course.ID, course.Name)
+		asst.DroppedAt = time.Time{}
+	}
 	if asst.ID < 1 || changed {
 		// if something changed, note the update time and save
 		if asst.ID > 0 {
@@ -585,7 +668,9 @@ func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Co
 	return asst, nil
 }
 
-func saveGrade(tx *sql.Tx, asst *Assignment, user *User) error {
+// saveGrade posts an assignment's grade to the LMS. The passback is traced as part of
+// the given span, or as a trace of its own if there is none.
+func saveGrade(tx *sql.Tx, tenant *TenantConfig, asst *Assignment, user *User, span *traceSpan) (err error) {
 	if asst.GradeID == "" {
 		log.Printf("cannot post grade for assignment %d user %d (%s) because no grade ID is present", asst.ID, asst.UserID, user.Name)
 		return nil
@@ -623,8 +708,19 @@ func saveGrade(tx *sql.Tx, asst *Assignment, user *User) error {
 	}
 	result := fmt.Sprintf("%s%s\n", xml.Header, raw)
 
+	passback := span.child("lms passback", spanClient)
+	if span == nil {
+		passback = startTrace("lms passback", spanClient)
+	}
+	passback.set("assignment.id", asst.ID)
+	passback.set("lms.outcome_url", outcomeURL)
+	defer func() {
+		passback.fail(err)
+		passback.finish()
+	}()
+
 	// sign the request
-	auth := signXMLRequest(asst.ConsumerKey, "POST", outcomeURL, result, Config.LTISecret)
+	auth := signXMLRequest(asst.ConsumerKey, "POST", outcomeURL, result, tenant.LTISecret, tenant.Hostname)
 
 	// POST the grade
 	req, err := http.NewRequest("POST", outcomeURL, strings.NewReader(result))
@@ -640,6 +736,7 @@ func saveGrade(tx *sql.Tx, asst *Assignment, user *User) error {
 		return err
 	}
 	resp.Body.Close()
+	passback.set("http.status_code", resp.StatusCode)
 	if resp.StatusCode == http.StatusOK {
 		log.Printf("grade of %0.5f posted for %s (%s)", asst.Score, user.Name, user.Email)
 	} else {
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// sendMail sends a plain text message through the configured mail server.
+func sendMail(to, subject, body string) error {
+	if Config().SMTPServer == "" {
+		return fmt.Errorf("no SMTPServer is configured")
+	}
+	var auth smtp.Auth
+	if Config().SMTPUsername != "" {
+		host, _, err := net.SplitHostPort(Config().SMTPServer)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", Config().SMTPUsername, Config().SMTPPassword, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", Config().MailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return smtp.SendMail(Config().SMTPServer, auth, Config().MailFrom, []string{to}, []byte(msg.String()))
+}
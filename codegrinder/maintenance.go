@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// maintenanceRefreshInterval is how often the maintenance notices are reloaded from the
+// database, so notices set through another server behind the same hostname are picked up.
+const maintenanceRefreshInterval = time.Minute
+
+// maintenanceNotices caches the maintenance notice of each tenant by hostname,
+// since it is announced on every response.
+var maintenanceNotices = struct {
+	sync.Mutex
+	byHost map[string]*MaintenanceNotice
+}{byHost: make(map[string]*MaintenanceNotice)}
+
+func getMaintenanceNotice(tx *sql.Tx) (*MaintenanceNotice, error) {
+	notice := new(MaintenanceNotice)
+	if err := meddler.QueryRow(tx, notice, `SELECT * FROM maintenance_notices ORDER BY id DESC LIMIT 1`); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return notice, nil
+}
+
+func cacheMaintenanceNotice(tenant *TenantConfig, notice *MaintenanceNotice) {
+	maintenanceNotices.Lock()
+	defer maintenanceNotices.Unlock()
+	if notice == nil {
+		delete(maintenanceNotices.byHost, tenant.Hostname)
+	} else {
+		maintenanceNotices.byHost[tenant.Hostname] = notice
+	}
+}
+
+// currentMaintenanceNotice returns the notice for a tenant, if one is set and has not ended.
+func currentMaintenanceNotice(tenant *TenantConfig) *MaintenanceNotice {
+	if tenant == nil {
+		return nil
+	}
+	maintenanceNotices.Lock()
+	defer maintenanceNotices.Unlock()
+	notice := maintenanceNotices.byHost[tenant.Hostname]
+	if notice == nil || !time.Now().Before(notice.EndsAt) {
+		return nil
+	}
+	return notice
+}
+
+// maintenanceLoop keeps the cached maintenance notices up to date.
+func maintenanceLoop(db *sql.DB) {
+	for {
+		err := forEachTenant(db, func(tx *sql.Tx, tenant *TenantConfig) error {
+			notice, err := getMaintenanceNotice(tx)
+			if err != nil {
+				return err
+			}
+			cacheMaintenanceNotice(tenant, notice)
+			return nil
+		})
+		if err != nil {
+			log.Printf("error loading maintenance notices: %v", err)
+		}
+		time.Sleep(maintenanceRefreshInterval)
+	}
+}
+
+// announceMaintenance is martini middleware that adds the maintenance notice,
+// if there is one, to every response.
+func announceMaintenance(w http.ResponseWriter, r *http.Request) {
+	if notice := currentMaintenanceNotice(findTenant(r.Host)); notice != nil {
+		w.Header().Set(MaintenanceHeader, notice.Header())
+	}
+}
+
+// GetMaintenance handles /v2/maintenance requests,
+// returning the scheduled maintenance notice, or null if there is none.
+func GetMaintenance(w http.ResponseWriter, r *http.Request, render render.Render) {
+	render.JSON(http.StatusOK, currentMaintenanceNotice(findTenant(r.Host)))
+}
+
+// PutMaintenance handles /v2/maintenance requests,
+// scheduling a maintenance window and replacing any notice already set.
+// Users see the notice from now until the window ends.
+func PutMaintenance(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, notice MaintenanceNotice, render render.Render) {
+	now := time.Now()
+	if err := notice.Normalize(now); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	notice.ID = 0
+	notice.CreatedAt = now
+	notice.UpdatedAt = now
+	if _, err := tx.Exec(`DELETE FROM maintenance_notices`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := meddler.Insert(tx, "maintenance_notices", &notice); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	cacheMaintenanceNotice(tenant, &notice)
+
+	log.Printf("maintenance scheduled from %s to %s: %s", notice.StartsAt.Format("2006-01-02 15:04"), notice.EndsAt.Format("2006-01-02 15:04"), notice.Message)
+	render.JSON(http.StatusOK, &notice)
+}
+
+// DeleteMaintenance handles /v2/maintenance requests,
+// removing the maintenance notice.
+func DeleteMaintenance(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig) {
+	if _, err := tx.Exec(`DELETE FROM maintenance_notices`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	cacheMaintenanceNotice(tenant, nil)
+	log.Printf("maintenance notice removed")
+}
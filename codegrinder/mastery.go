@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// updateMasteryStreak records a graded attempt at a step of a mastery problem,
+// extending the run of consecutive passes or starting over after a failure.
+// A step stays mastered once the run has reached the required length.
+// Each attempt is a separate daycare run with a signature of its own, so an
+// attempt whose signature has been seen before is a repeat of one already
+// counted and leaves the streak as it is.
+func updateMasteryStreak(tx *sql.Tx, now time.Time, problem *Problem, commit *Commit, signature string) (*MasteryStreak, error) {
+	streak := new(MasteryStreak)
+	err := meddler.QueryRow(tx, streak, `SELECT * FROM mastery_streaks WHERE assignment_id = $1 AND problem_id = $2 AND step = $3`,
+		commit.AssignmentID, commit.ProblemID, commit.Step)
+	isNew := err == sql.ErrNoRows
+	if err != nil && !isNew {
+		return nil, err
+	}
+
+	var seen bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM mastery_attempts WHERE assignment_id = $1 AND problem_id = $2 AND step = $3 AND signature = $4)`,
+		commit.AssignmentID, commit.ProblemID, commit.Step, signature).Scan(&seen); err != nil {
+		return nil, err
+	}
+	if seen {
+		log.Printf("assignment %d sent the same graded attempt at %s step %d again; not counting it", commit.AssignmentID, problem.Unique, commit.Step)
+		if isNew {
+			streak = &MasteryStreak{AssignmentID: commit.AssignmentID, ProblemID: commit.ProblemID, Step: commit.Step, Required: problem.Mastery}
+		}
+		return streak, nil
+	}
+	if _, err := tx.Exec(`INSERT INTO mastery_attempts (assignment_id, problem_id, step, signature, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		commit.AssignmentID, commit.ProblemID, commit.Step, signature, now); err != nil {
+		return nil, err
+	}
+
+	if isNew {
+		streak = &MasteryStreak{
+			AssignmentID: commit.AssignmentID,
+			ProblemID:    commit.ProblemID,
+			Step:         commit.Step,
+			CreatedAt:    now,
+		}
+	}
+
+	streak.Required = problem.Mastery
+	streak.Attempts++
+	if commit.ReportCard.Passed {
+		streak.Streak++
+	} else {
+		streak.Streak = 0
+	}
+	if !streak.IsMastered() && streak.Streak >= streak.Required {
+		log.Printf("assignment %d mastered %s step %d after %d attempt%s",
+			commit.AssignmentID, problem.Unique, commit.Step, streak.Attempts, plural(int(streak.Attempts)))
+		streak.MasteredAt = now
+	}
+	streak.UpdatedAt = now
+
+	if !isNew {
+		if _, err := tx.Exec(`DELETE FROM mastery_streaks WHERE assignment_id = $1 AND problem_id = $2 AND step = $3`,
+			streak.AssignmentID, streak.ProblemID, streak.Step); err != nil {
+			return nil, err
+		}
+	}
+	if err := meddler.Insert(tx, "mastery_streaks", streak); err != nil {
+		return nil, err
+	}
+	return streak, nil
+}
+
+// newTestSeed picks a seed that mastery problems can use to generate fresh tests.
+// Test harnesses find it in the CODEGRINDER_SEED environment variable.
+func newTestSeed() uint32 {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("error generating test seed: %v", err)
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint32(buf)
+}
+
+// GetAssignmentMasteryStreaks handles /v2/assignments/:assignment_id/mastery_streaks requests,
+// returning the progress toward mastery on each step attempted in an assignment.
+func GetAssignmentMasteryStreaks(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+
+	streaks := []*MasteryStreak{}
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &streaks, `SELECT * FROM mastery_streaks WHERE assignment_id = $1 ORDER BY problem_id, step`, assignmentID)
+	} else {
+		err = meddler.QueryAll(tx, &streaks, `SELECT mastery_streaks.* `+
+			`FROM mastery_streaks JOIN user_assignments ON mastery_streaks.assignment_id = user_assignments.assignment_id `+
+			`WHERE mastery_streaks.assignment_id = $1 AND user_assignments.user_id = $2 `+
+			`ORDER BY problem_id, step`,
+			assignmentID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, streaks)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getToolchainMatrix returns the toolchain variants a problem is graded against,
+// or nil if it is graded with the problem type's usual image.
+func getToolchainMatrix(tx *sql.Tx, problemID int64) ([]*ToolchainVariant, error) {
+	matrix := []*ToolchainVariant{}
+	if err := meddler.QueryAll(tx, &matrix, `SELECT * FROM problem_toolchain_variants WHERE problem_id = $1 ORDER BY label`, problemID); err != nil {
+		return nil, err
+	}
+	if len(matrix) == 0 {
+		return nil, nil
+	}
+	return matrix, nil
+}
+
+// GetProblemToolchainVariants handles /v2/problems/:problem_id/toolchain_variants requests,
+// returning the grading matrix for a problem.
+func GetProblemToolchainVariants(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	matrix := []*ToolchainVariant{}
+	if err := meddler.QueryAll(tx, &matrix, `SELECT * FROM problem_toolchain_variants WHERE problem_id = $1 ORDER BY label`, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, matrix)
+}
+
+// PutProblemToolchainVariant handles /v2/problems/:problem_id/toolchain_variants/:label requests,
+// adding a toolchain version to the grading matrix for a problem or changing its image.
+func PutProblemToolchainVariant(w http.ResponseWriter, tx *sql.Tx, currentUser *User, params martini.Params, variant ToolchainVariant, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	variant.ProblemID = problemID
+	variant.Label = params["label"]
+	if err := variant.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	now := time.Now()
+	variant.UpdatedAt = now
+	old := new(ToolchainVariant)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM problem_toolchain_variants WHERE problem_id = $1 AND label = $2`, problemID, variant.Label)
+	switch {
+	case err == sql.ErrNoRows:
+		count := 0
+		if err = tx.QueryRow(`SELECT COUNT(1) FROM problem_toolchain_variants WHERE problem_id = $1`, problemID).Scan(&count); err != nil {
+			break
+		}
+		if count >= MaxToolchainVariants {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "a problem can be graded against at most %d toolchain versions", MaxToolchainVariants)
+			return
+		}
+		variant.CreatedAt = now
+		err = meddler.Insert(tx, "problem_toolchain_variants", &variant)
+	case err == nil:
+		variant.CreatedAt = old.CreatedAt
+		_, err = tx.Exec(`UPDATE problem_toolchain_variants SET image = $1, updated_at = $2 WHERE problem_id = $3 AND label = $4`,
+			variant.Image, now, problemID, variant.Label)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("%s set toolchain variant %s=%s for problem %s", currentUser.Name, variant.Label, variant.Image, problem.Unique)
+	render.JSON(http.StatusOK, &variant)
+}
+
+// DeleteProblemToolchainVariant handles /v2/problems/:problem_id/toolchain_variants/:label requests,
+// removing a toolchain version from the grading matrix for a problem.
+func DeleteProblemToolchainVariant(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM problem_toolchain_variants WHERE problem_id = $1 AND label = $2`, problemID, params["label"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// variantRun is the outcome of grading against one toolchain variant.
+type variantRun struct {
+	card   *ReportCard
+	events []*EventMessage
+}
+
+// gradeMatrix grades a commit against every toolchain variant in parallel,
+// each in its own container. Events from each run are held until all runs
+// finish, then passed to send one variant at a time so the transcript reads
+// in order. It returns the combined report card.
+func gradeMatrix(matrix []*ToolchainVariant, problemType *ProblemType, problem *Problem, env []string, redactor *strings.Replacer,
+	tenant string, userID int64, commit *Commit, handler nannyHandler, args, options []string, files map[string]string, send func(*EventMessage), span *traceSpan) *ReportCard {
+
+	runs := make([]*variantRun, len(matrix))
+	var wg sync.WaitGroup
+	for i, variant := range matrix {
+		wg.Add(1)
+		go func(i int, variant *ToolchainVariant) {
+			defer wg.Done()
+			run := &variantRun{card: NewReportCard()}
+			runs[i] = run
+			variantSpan := span.child("matrix variant "+variant.Label, spanInternal)
+			variantSpan.set("toolchain.image", variant.Image)
+			defer variantSpan.finish()
+
+			variantType := *problemType
+			variantType.Image = variant.Image
+			nannyName := fmt.Sprintf("nanny-user-%d-%d", userID, i)
+			log.Printf("launching container for %s with %s", nannyName, variant.Image)
+			n, err := NewNanny(&variantType, problem, env, nannyName, false, variantSpan)
+			if err != nil {
+				run.card.LogAndFailf("error creating nanny: %v", err)
+				return
+			}
+			job := startDaycareJob(n, tenant, userID, problemType.Name, problem, commit)
+			defer finishDaycareJob(job.ID)
+
+			finished := make(chan struct{})
+			go func() {
+				for event := range n.Events {
+					if redactor != nil {
+						event.Redact(redactor)
+					}
+					run.events = append(run.events, event)
+				}
+				finished <- struct{}{}
+			}()
+
+			grading := n.span.child("container "+commit.Action, spanInternal)
+			handler(n, args, options, files)
+			grading.finish()
+			if reason := n.StopReason(); reason != "" {
+				n.ReportCard.LogAndFailf("%s", reason)
+			}
+			if toolchain, err := inspectToolchain(n.Image); err != nil {
+				log.Printf("unable to identify toolchain: %v", err)
+			} else {
+				n.ReportCard.Toolchain = toolchain
+			}
+			if resources, err := n.measureResources(); err != nil {
+				log.Printf("unable to measure resource use: %v", err)
+			} else {
+				n.ReportCard.Resources = resources
+			}
+			run.card = n.ReportCard
+			if err := n.Shutdown(); err != nil {
+				log.Printf("nanny shutdown error: %v", err)
+			}
+			close(n.Events)
+			<-finished
+		}(i, variant)
+	}
+	wg.Wait()
+
+	card := NewReportCard()
+	for i, variant := range matrix {
+		run := runs[i]
+		send(&EventMessage{
+			Time:       time.Now(),
+			Event:      "stdout",
+			StreamData: fmt.Sprintf("\n=== %s (%s) ===\n", variant.Label, variant.Image),
+		})
+		for _, event := range run.events {
+			send(event)
+		}
+		if redactor != nil {
+			run.card.Redact(redactor)
+		}
+		card.AddVariant(variant, run.card)
+	}
+	card.TrimArtifacts()
+	return card
+}
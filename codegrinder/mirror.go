@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// Problem steps can be served by a mirror or CDN at Config.AssetMirror that uses this
+// server as its origin. Clients ask this server for a signed URL on the mirror, and the
+// mirror passes requests it has not seen to the same path here. The path names the
+// version of the step, so the mirror can keep each response as long as it likes.
+//
+// This server only sees the requests the mirror has not cached, so the signature and
+// expiration time in the query keep problems private only if the mirror checks them
+// on every request before serving from its cache, e.g., in an edge function. For that
+// reason the mirror and this server must share a MirrorSecret. The mirror must also
+// pass along the Host header so requests reach the right tenant.
+
+// stepVersion names the current version of a problem step, changing whenever the problem is updated.
+func stepVersion(problem *Problem, step int64) string {
+	version := fmt.Sprintf("%d/%d/%s", problem.ID, step, problem.UpdatedAt.UTC().Format(time.RFC3339Nano))
+	sum := sha256.Sum256([]byte(version))
+	return hex.EncodeToString(sum[:12])
+}
+
+// checkMirrorConfig makes sure an asset mirror has a secret to check signatures with.
+func checkMirrorConfig(config *serverConfig) error {
+	if config.AssetMirror != "" && config.MirrorSecret == "" {
+		return fmt.Errorf("AssetMirror needs a MirrorSecret shared with the mirror so it can check signatures before serving from its cache")
+	}
+	return nil
+}
+
+// mirrorSignature signs a mirror path and its expiration time with the MirrorSecret.
+// The mirror checks it in the same way, e.g., in Go:
+//
+//	mac := hmac.New(sha256.New, []byte(secret))
+//	fmt.Fprintf(mac, "%s?expires=%d", path, expires)
+//	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+func mirrorSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(Config().MirrorSecret))
+	fmt.Fprintf(mac, "%s?expires=%d", path, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GetProblemStepMirror handles a request to /v2/problems/:problem_id/steps/:step/mirror,
+// returning a signed URL where the step can be downloaded from the asset mirror
+// as a gzip tarball in the same form as .../steps/:step/bundle.
+func GetProblemStepMirror(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	if Config().AssetMirror == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the asset mirror is off")
+		return
+	}
+	problemStep := loadProblemStep(w, tx, params, currentUser)
+	if problemStep == nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemStep.ProblemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(Config().MirrorMinutes) * time.Minute)
+	path := fmt.Sprintf("/v2/mirror/problems/%d/steps/%d/%s", problem.ID, problemStep.Step, stepVersion(problem, problemStep.Step))
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", mirrorSignature(path, expiresAt.Unix()))
+	render.JSON(http.StatusOK, &StepMirror{
+		URL:       strings.TrimSuffix(Config().AssetMirror, "/") + path + "?" + query.Encode(),
+		ExpiresAt: expiresAt,
+	})
+}
+
+// GetMirrorProblemStep handles a request to /v2/mirror/problems/:problem_id/steps/:step/:version
+// from the asset mirror, returning the step as a gzip tarball. There is no session; the
+// signed URL from GetProblemStepMirror is the permission, checked here when the mirror
+// has no copy and by the mirror itself otherwise. A version that is no longer
+// current is not found, so the mirror never keeps an old copy under a new name.
+func GetMirrorProblemStep(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params) {
+	if Config().AssetMirror == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the asset mirror is off")
+		return
+	}
+	expires, err := strconv.ParseInt(r.FormValue("expires"), 10, 64)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusForbidden, "missing or invalid expires parameter")
+		return
+	}
+	if !hmac.Equal([]byte(r.FormValue("signature")), []byte(mirrorSignature(r.URL.Path, expires))) {
+		loggedHTTPErrorf(w, http.StatusForbidden, "bad or missing signature")
+		return
+	}
+	if time.Now().Unix() > expires {
+		loggedHTTPErrorf(w, http.StatusForbidden, "this link has expired")
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	step, err := parseID(w, "step", params["step"])
+	if err != nil {
+		return
+	}
+
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if params["version"] != stepVersion(problem, step) {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem %d has changed since this link was made", problemID)
+		return
+	}
+	problemStep := new(ProblemStep)
+	if err := meddler.QueryRow(tx, problemStep, `SELECT * FROM problem_steps WHERE problem_id = $1 AND step = $2`, problemID, step); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	// the version is in the path, so this response never changes
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	writeStepBundle(w, problemStep)
+}
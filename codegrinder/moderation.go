@@ -0,0 +1,332 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getModeration returns the second marking settings for a problem set in a course,
+// or nil if it is not moderated.
+func getModeration(tx *sql.Tx, courseID, problemSetID int64) (*Moderation, error) {
+	moderation := new(Moderation)
+	err := meddler.QueryRow(tx, moderation, `SELECT * FROM moderations WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return moderation, nil
+}
+
+// PutCourseProblemSetModeration handles /v2/courses/:course_id/problem_sets/:problem_set_id/moderation requests,
+// setting up second marking for the problem set. Students are chosen at random until the sample
+// includes the requested percentage of the students in the course; running it again as more students
+// join tops up the sample without changing those already chosen.
+// The moderation settings are returned.
+func PutCourseProblemSetModeration(w http.ResponseWriter, tx *sql.Tx, params martini.Params, moderation Moderation, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	if moderation.SamplePercent <= 0.0 || moderation.SamplePercent > 100.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "sample percentage must be more than 0 and at most 100")
+		return
+	}
+	if moderation.Threshold < 0.0 || moderation.Threshold > 1.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "discrepancy threshold must be between 0 and 1")
+		return
+	}
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+	instructor, err := isCourseInstructor(tx, moderation.SecondMarkerID, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !instructor {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the second marker must be an instructor in the course")
+		return
+	}
+
+	old, err := getModeration(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if old != nil {
+		if _, err := tx.Exec(`DELETE FROM moderations WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		moderation.CreatedAt = old.CreatedAt
+	} else {
+		moderation.CreatedAt = now
+	}
+	moderation.CourseID = courseID
+	moderation.ProblemSetID = problemSetID
+	moderation.UpdatedAt = now
+	if err := meddler.Insert(tx, "moderations", &moderation); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// top up the sample
+	var students, sampled int
+	if err := tx.QueryRow(`SELECT COUNT(1), COUNT(moderation_marks.id) `+
+		`FROM assignments LEFT JOIN moderation_marks ON assignments.id = moderation_marks.assignment_id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 AND NOT assignments.instructor`,
+		courseID, problemSetID).Scan(&students, &sampled); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	wanted := int(math.Ceil(float64(students) * moderation.SamplePercent / 100.0))
+	if wanted > sampled {
+		if _, err := tx.Exec(`INSERT INTO moderation_marks (assignment_id, first_score, second_score, flagged, reconciled_score, created_at, updated_at) `+
+			`SELECT id, 0.0, 0.0, false, 0.0, $1, $1 FROM assignments `+
+			`WHERE course_id = $2 AND problem_set_id = $3 AND NOT instructor `+
+			`AND id NOT IN (SELECT assignment_id FROM moderation_marks) `+
+			`ORDER BY random() LIMIT $4`,
+			now, courseID, problemSetID, wanted-sampled); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		log.Printf("added %d student%s to the second marking sample for problem set %d in course %d",
+			wanted-sampled, plural(wanted-sampled), problemSetID, courseID)
+	}
+
+	render.JSON(http.StatusOK, &moderation)
+}
+
+// GetCourseProblemSetModerationMarks handles /v2/courses/:course_id/problem_sets/:problem_set_id/moderation_marks requests,
+// returning the second marking for each student in the sample. Students are named by
+// pseudonym, without assignment IDs, while grading is anonymous. Until the second mark is recorded, the first
+// score is the current grade.
+func GetCourseProblemSetModerationMarks(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	marks := []*ModerationMark{}
+	if err := meddler.QueryAll(tx, &marks, `SELECT moderation_marks.* `+
+		`FROM moderation_marks JOIN assignments ON moderation_marks.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 `+
+		`ORDER BY moderation_marks.id`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, mark := range marks {
+		asst := new(Assignment)
+		if err := meddler.Load(tx, "assignments", asst, mark.AssignmentID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if mark.SecondMarkedAt.IsZero() {
+			mark.FirstScore = asst.Score
+		}
+		if anon != nil && anon.IsAnonymous() {
+			mark.Student = anon.Pseudonym(asst.UserID)
+			mark.AssignmentID = 0
+		} else {
+			user := new(User)
+			if err := meddler.Load(tx, "users", user, asst.UserID); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			mark.Student = user.Name
+		}
+	}
+
+	render.JSON(http.StatusOK, marks)
+}
+
+// getCourseModerationMark loads a moderation mark, along with its assignment and moderation settings,
+// making sure it belongs to the given course.
+func getCourseModerationMark(tx *sql.Tx, courseID, markID int64) (*ModerationMark, *Assignment, *Moderation, error) {
+	mark := new(ModerationMark)
+	if err := meddler.Load(tx, "moderation_marks", mark, markID); err != nil {
+		return nil, nil, nil, dbNotFoundError(err)
+	}
+	asst := new(Assignment)
+	if err := meddler.Load(tx, "assignments", asst, mark.AssignmentID); err != nil {
+		return nil, nil, nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if asst.CourseID != courseID {
+		return nil, nil, nil, httpErrorf(http.StatusNotFound, "moderation mark %d is not part of course %d", markID, courseID)
+	}
+	moderation, err := getModeration(tx, asst.CourseID, asst.ProblemSetID)
+	if err != nil {
+		return nil, nil, nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if moderation == nil {
+		return nil, nil, nil, httpErrorf(http.StatusNotFound, "second marking is not set up for this problem set")
+	}
+	return mark, asst, moderation, nil
+}
+
+// maskAnonymousMark names the student on a mark by pseudonym and leaves out
+// the assignment ID while grading is anonymous.
+func maskAnonymousMark(tx *sql.Tx, mark *ModerationMark, asst *Assignment) error {
+	anon, err := getAnonymousGrading(tx, asst.CourseID, asst.ProblemSetID)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if anon != nil && anon.IsAnonymous() {
+		mark.Student = anon.Pseudonym(asst.UserID)
+		mark.AssignmentID = 0
+	}
+	return nil
+}
+
+// PutCourseModerationMarkSecond handles /v2/courses/:course_id/moderation_marks/:mark_id/second requests,
+// recording the second marker's score. The current grade is recorded as the first score,
+// and the mark is flagged if the two differ by more than the threshold.
+// Only the assigned second marker (or an administrator) may record it.
+// The updated mark is returned.
+func PutCourseModerationMarkSecond(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, second Mark, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	markID, err := parseID(w, "mark_id", params["mark_id"])
+	if err != nil {
+		return
+	}
+	mark, asst, moderation, err := getCourseModerationMark(tx, courseID, markID)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	if !currentUser.Admin && currentUser.ID != moderation.SecondMarkerID {
+		loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not the second marker for this problem set", currentUser.ID, currentUser.Name)
+		return
+	}
+	if !mark.ReconciledAt.IsZero() {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "this mark has already been reconciled")
+		return
+	}
+	if second.Score < 0.0 || second.Score > 1.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "score must be between 0 and 1")
+		return
+	}
+
+	now := time.Now()
+	mark.FirstScore = asst.Score
+	mark.SecondScore = second.Score
+	mark.SecondNote = second.Note
+	mark.SecondMarkedAt = now
+	mark.Flagged = math.Abs(mark.FirstScore-mark.SecondScore) > moderation.Threshold
+	mark.UpdatedAt = now
+	if err := meddler.Save(tx, "moderation_marks", mark); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if mark.Flagged {
+		log.Printf("moderation mark %d flagged: first score %.3f, second score %.3f", mark.ID, mark.FirstScore, mark.SecondScore)
+	}
+
+	if err := maskAnonymousMark(tx, mark, asst); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, mark)
+}
+
+// getReconciledMark returns the moderation mark for an assignment if its grade
+// has been reconciled, or nil if it has not.
+func getReconciledMark(tx *sql.Tx, assignmentID int64) (*ModerationMark, error) {
+	mark := new(ModerationMark)
+	err := meddler.QueryRow(tx, mark, `SELECT * FROM moderation_marks WHERE assignment_id = $1 AND reconciled_at IS NOT NULL`, assignmentID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mark, nil
+}
+
+// PutCourseModerationMarkReconcile handles /v2/courses/:course_id/moderation_marks/:mark_id/reconcile requests,
+// recording the grade agreed on after second marking. The reconciled grade replaces the student's
+// grade on the problem set from then on, even after later work is graded, and is posted to the LMS
+// (unless grading is still anonymous). The updated mark is returned.
+func PutCourseModerationMarkReconcile(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, currentUser *User, reconciled Mark, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	markID, err := parseID(w, "mark_id", params["mark_id"])
+	if err != nil {
+		return
+	}
+	mark, asst, _, err := getCourseModerationMark(tx, courseID, markID)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	if mark.SecondMarkedAt.IsZero() {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the second mark must be recorded before the grade can be reconciled")
+		return
+	}
+	if reconciled.Score < 0.0 || reconciled.Score > 1.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "score must be between 0 and 1")
+		return
+	}
+
+	now := time.Now()
+	mark.ReconciledScore = reconciled.Score
+	mark.ReconciledNote = reconciled.Note
+	mark.ReconciledByID = currentUser.ID
+	mark.ReconciledAt = now
+	mark.UpdatedAt = now
+	if err := meddler.Save(tx, "moderation_marks", mark); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// the reconciled grade becomes the grade for the problem set
+	if err := rescoreAssignment(tx, tenant, asst, now); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	log.Printf("moderation mark %d reconciled at %.3f by user %d (%s)", mark.ID, mark.ReconciledScore, currentUser.ID, currentUser.Name)
+
+	if err := maskAnonymousMark(tx, mark, asst); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, mark)
+}
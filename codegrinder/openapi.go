@@ -0,0 +1,306 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+// apiVersions lists the API versions served, oldest first.
+// Every route is registered under /v2. A route only needs its own /v3
+// registration when its behavior changes incompatibly; everything else
+// is served unchanged under /v3 so grind clients in the field keep working.
+var apiVersions = []string{"v2", "v3"}
+
+// apiOperation is a route as it was registered, kept to describe the API.
+type apiOperation struct {
+	Method   string
+	Pattern  string
+	Handlers []martini.Handler
+}
+
+// apiRouter is a martini router that remembers its routes
+// so it can mount them under newer API versions and describe them.
+type apiRouter struct {
+	martini.Router
+	operations []*apiOperation
+	roles      map[uintptr]string
+}
+
+func newAPIRouter(router martini.Router) *apiRouter {
+	return &apiRouter{Router: router, roles: make(map[uintptr]string)}
+}
+
+func (r *apiRouter) Get(pattern string, h ...martini.Handler) martini.Route {
+	return r.add("GET", pattern, h)
+}
+
+func (r *apiRouter) Post(pattern string, h ...martini.Handler) martini.Route {
+	return r.add("POST", pattern, h)
+}
+
+func (r *apiRouter) Put(pattern string, h ...martini.Handler) martini.Route {
+	return r.add("PUT", pattern, h)
+}
+
+func (r *apiRouter) Patch(pattern string, h ...martini.Handler) martini.Route {
+	return r.add("PATCH", pattern, h)
+}
+
+func (r *apiRouter) Delete(pattern string, h ...martini.Handler) martini.Route {
+	return r.add("DELETE", pattern, h)
+}
+
+func (r *apiRouter) add(method, pattern string, h []martini.Handler) martini.Route {
+	r.operations = append(r.operations, &apiOperation{Method: method, Pattern: pattern, Handlers: h})
+	return r.Router.AddRoute(method, pattern, h...)
+}
+
+// Role records that a middleware handler restricts who may use a route,
+// so the API description can say so.
+func (r *apiRouter) Role(role string, h martini.Handler) {
+	r.roles[reflect.ValueOf(h).Pointer()] = role
+}
+
+// MountVersions serves every /v2 route under each newer API version
+// unless that version registered its own route for the same method and path.
+// It must be called after all routes are registered.
+func (r *apiRouter) MountVersions() {
+	registered := make(map[string]bool)
+	for _, op := range r.operations {
+		registered[op.Method+" "+op.Pattern] = true
+	}
+	ops := r.operations
+	for _, version := range apiVersions[1:] {
+		for _, op := range ops {
+			if !strings.HasPrefix(op.Pattern, "/v2/") {
+				continue
+			}
+			pattern := "/" + version + strings.TrimPrefix(op.Pattern, "/v2")
+			if registered[op.Method+" "+pattern] {
+				continue
+			}
+			registered[op.Method+" "+pattern] = true
+			r.add(op.Method, pattern, op.Handlers)
+		}
+	}
+}
+
+// endpoints lists the routes of an API version in the form "GET /problems/:problem_id",
+// with the version prefix removed.
+func (r *apiRouter) endpoints(version string) []string {
+	prefix := "/" + version
+	list := []string{}
+	for _, op := range r.operations {
+		if strings.HasPrefix(op.Pattern, prefix+"/") {
+			list = append(list, op.Method+" "+strings.TrimPrefix(op.Pattern, prefix))
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// GetOpenAPI handles /v2/openapi.json requests,
+// returning an OpenAPI 3 description of the API version in the request path.
+// It is generated from the registered routes and the types their handlers accept.
+func (r *apiRouter) GetOpenAPI(w http.ResponseWriter, req *http.Request, render render.Render) {
+	version := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)[0]
+	render.JSON(http.StatusOK, r.openAPISpec(version))
+}
+
+func (r *apiRouter) openAPISpec(version string) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]map[string]interface{})
+	prefix := "/" + version + "/"
+	for _, op := range r.operations {
+		if !strings.HasPrefix(op.Pattern, prefix) {
+			continue
+		}
+
+		// martini path parameters become OpenAPI path parameters
+		var params []interface{}
+		parts := strings.Split(op.Pattern, "/")
+		for i, part := range parts {
+			if !strings.HasPrefix(part, ":") {
+				continue
+			}
+			name := part[1:]
+			kind := "string"
+			if strings.HasSuffix(name, "_id") {
+				kind = "integer"
+			}
+			parts[i] = "{" + name + "}"
+			params = append(params, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": kind},
+			})
+		}
+		path := strings.Join(parts, "/")
+
+		operation := map[string]interface{}{
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "success"},
+				"default": map[string]interface{}{
+					"description": "error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{"schema": typeSchema(reflect.TypeOf(APIError{}), schemas)},
+					},
+				},
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		// the final handler names the operation and says what it accepts
+		handler := op.Handlers[len(op.Handlers)-1]
+		if name := handlerName(handler); name != "" {
+			operation["operationId"] = name
+		}
+		var roles []string
+		for _, h := range op.Handlers {
+			if role, ok := r.roles[reflect.ValueOf(h).Pointer()]; ok {
+				roles = append(roles, role)
+			}
+		}
+		if len(roles) > 0 {
+			operation["security"] = []interface{}{map[string]interface{}{"session": []string{}}}
+			operation["x-codegrinder-roles"] = roles
+		}
+		if body := requestBodyType(handler); body != nil && op.Method != "GET" {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": typeSchema(body, schemas)},
+				},
+			}
+		}
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "CodeGrinder",
+			"version": CurrentVersion.Version,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": CookieName},
+			},
+		},
+	}
+}
+
+// handlerName returns the name of a top-level handler function,
+// or the empty string for closures.
+func handlerName(h martini.Handler) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(h).Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := strings.TrimSuffix(fn.Name(), "-fm")
+	name = name[strings.LastIndex(name, ".")+1:]
+	if strings.HasPrefix(name, "func") || name == "" {
+		return ""
+	}
+	return name
+}
+
+// requestBodyType finds the struct a handler receives by value,
+// which is the request body bound by the binding middleware.
+func requestBodyType(h martini.Handler) reflect.Type {
+	t := reflect.TypeOf(h)
+	if t.Kind() != reflect.Func {
+		return nil
+	}
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if in.Kind() == reflect.Struct && in.PkgPath() == reflect.TypeOf(User{}).PkgPath() {
+			return in
+		}
+	}
+	return nil
+}
+
+// typeSchema returns the JSON schema for a Go type as encoding/json would render it,
+// adding named structs to schemas and referring to them there.
+func typeSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), schemas)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), schemas)}
+	case reflect.Struct:
+		// anonymous structs have no name to refer to, so they are described in place
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, exists := schemas[t.Name()]; exists {
+			return ref
+		}
+
+		// reserve the name first in case the type refers to itself
+		schemas[t.Name()] = nil
+		schemas[t.Name()] = structSchema(t, schemas)
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// structSchema describes the exported fields of a struct type by their JSON names.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if parts := strings.Split(tag, ","); parts[0] != "" {
+				name = parts[0]
+			}
+		}
+		properties[name] = typeSchema(field.Type, schemas)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// pageMaxLimit is the most rows a client can ask for in one page of a list.
+const pageMaxLimit = 1000
+
+// listPage is a request for one page of a list. Lists are only paged if the client
+// gives limit=<...>. The response then has NextCursorHeader if there is more,
+// and the client passes it back as cursor=<...> to get the next page.
+// The cursor is opaque to clients.
+type listPage struct {
+	Limit  int   `json:"-"`
+	After  int64 `json:"after,omitempty"`  // for lists in ID order, the last ID already returned
+	Offset int   `json:"offset,omitempty"` // for other lists, how many rows were already returned
+}
+
+// parseListPage reads the limit and cursor parameters of a list request.
+func parseListPage(w http.ResponseWriter, r *http.Request) (*listPage, error) {
+	page := new(listPage)
+	if s := r.FormValue("cursor"); s != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err == nil {
+			err = json.Unmarshal(raw, page)
+		}
+		if err != nil || page.After < 0 || page.Offset < 0 {
+			return nil, loggedHTTPErrorf(w, http.StatusBadRequest, "cursor is not valid; use the %s header from the previous page", NextCursorHeader)
+		}
+	}
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, loggedHTTPErrorf(w, http.StatusBadRequest, "limit must be a positive integer")
+		}
+		if n > pageMaxLimit {
+			n = pageMaxLimit
+		}
+		page.Limit = n
+	}
+	return page, nil
+}
+
+// whereAfter narrows a list in ID order to the rows after the cursor.
+func (page *listPage) whereAfter(where string, args []interface{}, column string) (string, []interface{}) {
+	if page.After == 0 {
+		return where, args
+	}
+	args = append(args, page.After)
+	if where == "" {
+		where = " WHERE"
+	} else {
+		where += " AND"
+	}
+	return where + fmt.Sprintf(" %s > $%d", column, len(args)), args
+}
+
+// limitSQL returns the LIMIT and OFFSET clause for the page,
+// which asks for one extra row to learn if there is another page.
+func (page *listPage) limitSQL() string {
+	if page.Limit == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", page.Limit+1, page.Offset)
+}
+
+// trim returns how many of the n rows fetched belong on this page, and sets the
+// header with the cursor for the next page if there is one. For lists in ID order,
+// id gives the ID of a row; it is nil for other lists.
+func (page *listPage) trim(w http.ResponseWriter, n int, id func(int) int64) int {
+	if page.Limit == 0 || n <= page.Limit {
+		return n
+	}
+	next := &listPage{Offset: page.Offset + page.Limit}
+	if id != nil {
+		next = &listPage{After: id(page.Limit - 1)}
+	}
+	raw, err := json.Marshal(next)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set(NextCursorHeader, base64.RawURLEncoding.EncodeToString(raw))
+	return page.Limit
+}
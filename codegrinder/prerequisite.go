@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getPrerequisiteStatus reports how the student on an assignment stands on each
+// prerequisite of its problem set. Problem sets the student has not started count as zero.
+func getPrerequisiteStatus(tx *sql.Tx, asst *Assignment) ([]*PrerequisiteStatus, error) {
+	statuses := []*PrerequisiteStatus{}
+	err := meddler.QueryAll(tx, &statuses, `SELECT prerequisites.required_problem_set_id AS problem_set_id, `+
+		`problem_sets.unique_id, problem_sets.note, prerequisites.threshold, COALESCE(assignments.score, 0) AS score `+
+		`FROM prerequisites JOIN problem_sets ON prerequisites.required_problem_set_id = problem_sets.id `+
+		`LEFT JOIN assignments ON assignments.course_id = prerequisites.course_id `+
+		`AND assignments.problem_set_id = prerequisites.required_problem_set_id AND assignments.user_id = $3 `+
+		`WHERE prerequisites.course_id = $1 AND prerequisites.problem_set_id = $2 `+
+		`ORDER BY problem_sets.unique_id`,
+		asst.CourseID, asst.ProblemSetID, asst.UserID)
+	return statuses, err
+}
+
+// addPrerequisiteStatus fills in the prerequisites of student assignments.
+// Instructors are never locked out.
+func addPrerequisiteStatus(tx *sql.Tx, assignments []*Assignment) error {
+	for _, asst := range assignments {
+		if asst.Instructor {
+			continue
+		}
+		statuses, err := getPrerequisiteStatus(tx, asst)
+		if err != nil {
+			return err
+		}
+		if len(statuses) > 0 {
+			asst.Prerequisites = statuses
+		}
+	}
+	return nil
+}
+
+// checkPrerequisites returns an error explaining which prerequisites
+// are unmet if the assignment is locked, or that a lab code must be
+// redeemed first.
+func checkPrerequisites(tx *sql.Tx, asst *Assignment) error {
+	if err := addPrerequisiteStatus(tx, []*Assignment{asst}); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if !asst.IsLocked() {
+		return checkLabUnlock(tx, asst)
+	}
+	var unmet []string
+	for _, status := range asst.Prerequisites {
+		if !status.IsMet() {
+			unmet = append(unmet, fmt.Sprintf("%s at %.0f%% (you have %.0f%%)", status.Unique, status.Threshold*100.0, status.Score*100.0))
+		}
+	}
+	return httpErrorf(http.StatusForbidden, "this assignment is locked until you complete %s", strings.Join(unmet, ", "))
+}
+
+// GetCourseProblemSetPrerequisites handles /v2/courses/:course_id/problem_sets/:problem_set_id/prerequisites requests,
+// returning the prerequisites of a problem set in a course.
+func GetCourseProblemSetPrerequisites(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	prerequisites := []*Prerequisite{}
+	if err := meddler.QueryAll(tx, &prerequisites, `SELECT * FROM prerequisites WHERE course_id = $1 AND problem_set_id = $2 ORDER BY required_problem_set_id`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, prerequisites)
+}
+
+// PutCourseProblemSetPrerequisite handles /v2/courses/:course_id/problem_sets/:problem_set_id/prerequisites/:required_id requests,
+// locking the problem set until the required problem set is completed at the given threshold.
+// Both problem sets must be offered in the course. The prerequisite is returned.
+func PutCourseProblemSetPrerequisite(w http.ResponseWriter, tx *sql.Tx, params martini.Params, prerequisite Prerequisite, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	requiredID, err := parseID(w, "required_id", params["required_id"])
+	if err != nil {
+		return
+	}
+	if requiredID == problemSetID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a problem set cannot be its own prerequisite")
+		return
+	}
+	if prerequisite.Threshold <= 0.0 || prerequisite.Threshold > 1.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "threshold must be greater than 0 and at most 1")
+		return
+	}
+
+	var offered int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM course_problem_sets WHERE course_id = $1 AND problem_set_id IN ($2, $3)`,
+		courseID, problemSetID, requiredID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if offered != 2 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "both problem sets must be offered in course %d", courseID)
+		return
+	}
+
+	// refuse cycles, which would lock students out of both problem sets
+	var cycle bool
+	if err := tx.QueryRow(`WITH RECURSIVE required (id) AS (`+
+		`SELECT $3::bigint UNION `+
+		`SELECT prerequisites.required_problem_set_id FROM prerequisites JOIN required ON prerequisites.problem_set_id = required.id `+
+		`WHERE prerequisites.course_id = $1) `+
+		`SELECT EXISTS (SELECT 1 FROM required WHERE id = $2)`,
+		courseID, problemSetID, requiredID).Scan(&cycle); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if cycle {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "problem set %d already requires problem set %d", requiredID, problemSetID)
+		return
+	}
+
+	now := time.Now()
+	old := new(Prerequisite)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM prerequisites WHERE course_id = $1 AND problem_set_id = $2 AND required_problem_set_id = $3`,
+		courseID, problemSetID, requiredID)
+	switch {
+	case err == sql.ErrNoRows:
+		prerequisite.CreatedAt = now
+	case err != nil:
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	default:
+		prerequisite.CreatedAt = old.CreatedAt
+		if _, err := tx.Exec(`DELETE FROM prerequisites WHERE course_id = $1 AND problem_set_id = $2 AND required_problem_set_id = $3`,
+			courseID, problemSetID, requiredID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+	prerequisite.CourseID = courseID
+	prerequisite.ProblemSetID = problemSetID
+	prerequisite.RequiredProblemSetID = requiredID
+	prerequisite.UpdatedAt = now
+	if err := meddler.Insert(tx, "prerequisites", &prerequisite); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("problem set %d in course %d now requires %.0f%% on problem set %d", problemSetID, courseID, prerequisite.Threshold*100.0, requiredID)
+	render.JSON(http.StatusOK, &prerequisite)
+}
+
+// DeleteCourseProblemSetPrerequisite handles /v2/courses/:course_id/problem_sets/:problem_set_id/prerequisites/:required_id requests,
+// removing a prerequisite from a problem set.
+func DeleteCourseProblemSetPrerequisite(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	requiredID, err := parseID(w, "required_id", params["required_id"])
+	if err != nil {
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM prerequisites WHERE course_id = $1 AND problem_set_id = $2 AND required_problem_set_id = $3`,
+		courseID, problemSetID, requiredID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("problem set %d in course %d no longer requires problem set %d", problemSetID, courseID, requiredID)
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"database/sql"
+	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
@@ -14,7 +20,7 @@ import (
 // GetProblemTypes handles a request to /v2/problemtypes,
 // returning a complete list of problem types.
 func GetProblemTypes(w http.ResponseWriter, render render.Render) {
-	render.JSON(http.StatusOK, problemTypes)
+	render.JSON(http.StatusOK, problemTypes())
 }
 
 // GetProblemType handles a request to /v2/problemtypes/:name,
@@ -22,7 +28,7 @@ func GetProblemTypes(w http.ResponseWriter, render render.Render) {
 func GetProblemType(w http.ResponseWriter, params martini.Params, render render.Render) {
 	name := params["name"]
 
-	problemType, exists := problemTypes[name]
+	problemType, exists := problemTypes()[name]
 
 	if !exists {
 		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
@@ -155,13 +161,94 @@ func GetProblemSteps(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params
 // GetProblemStep handles a request to /v2/problems/:problem_id/steps/:step,
 // returning a single problem step.
 func GetProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
-	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	problemStep := loadProblemStep(w, tx, params, currentUser)
+	if problemStep == nil {
+		return
+	}
+
+	render.JSON(http.StatusOK, problemStep)
+}
+
+// GetProblemStepBundle handles a request to /v2/problems/:problem_id/steps/:step/bundle,
+// returning a single problem step as a gzip tarball, so a step with many files
+// can be downloaded in one compressed response. The tarball holds the step
+// without its files as step.json, followed by each file under files/.
+func GetProblemStepBundle(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	problemStep := loadProblemStep(w, tx, params, currentUser)
+	if problemStep == nil {
+		return
+	}
+	writeStepBundle(w, problemStep)
+}
+
+// writeStepBundle sends a problem step as a gzip tarball.
+func writeStepBundle(w http.ResponseWriter, problemStep *ProblemStep) {
+	files := problemStep.Files
+	problemStep.Files = nil
+	meta, err := json.MarshalIndent(problemStep, "", "    ")
 	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "JSON error encoding problem step: %v", err)
+		return
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	writer := tar.NewWriter(gz)
+	add := func(name string, contents []byte) error {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := writer.Write(contents)
+		return err
+	}
+	if err := add(StepBundleMetadata, meta); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing tarball: %v", err)
+		return
+	}
+	for _, name := range names {
+		if err := add(StepBundleFilesDir+name, []byte(files[name])); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing tarball: %v", err)
+			return
+		}
+	}
+	if err := writer.Close(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error closing tarball: %v", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error compressing tarball: %v", err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// loadProblemStep loads the problem step named in the request, checking that the current
+// user can see it. It returns nil if the step is not found or cannot be loaded, after reporting the error.
+func loadProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) *ProblemStep {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return nil
+	}
 	step, err := parseID(w, "step", params["step"])
 	if err != nil {
-		return
+		return nil
 	}
 
 	problemStep := new(ProblemStep)
@@ -177,10 +264,9 @@ func GetProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cu
 
 	if err != nil {
 		loggedHTTPDBNotFoundError(w, err)
-		return
+		return nil
 	}
-
-	render.JSON(http.StatusOK, problemStep)
+	return problemStep
 }
 
 // GetProblemSets handles a request to /v2/problem_sets,
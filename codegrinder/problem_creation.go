@@ -96,7 +96,7 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, bundle *ProblemB
 	// note: unique constraint will be checked by the database
 
 	// verify the signature
-	sig := problem.ComputeSignature(Config.DaycareSecret, steps)
+	sig := problem.ComputeSignature(Config().DaycareSecret, steps)
 	if sig != bundle.ProblemSignature {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "problem signature does not check out: found %s but expected %s", bundle.ProblemSignature, sig)
 		return
@@ -113,7 +113,7 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, bundle *ProblemB
 	}
 	for i, commit := range bundle.Commits {
 		// check the commit signature
-		csig := commit.ComputeSignature(Config.DaycareSecret, bundle.ProblemSignature)
+		csig := commit.ComputeSignature(Config().DaycareSecret, bundle.SigningSignature(Config().DaycareSecret))
 		if csig != bundle.CommitSignatures[i] {
 			loggedHTTPErrorf(w, http.StatusBadRequest, "commit for step %d has a bad signature", commit.Step)
 			return
@@ -145,8 +145,13 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, bundle *ProblemB
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "json error: %v", err)
 				return
 			}
-			if _, err = tx.Exec(`UPDATE problem_steps SET note=$1,instructions=$2,weight=$3,files=$4 WHERE problem_id=$5 AND step=$6`,
-				step.Note, step.Instructions, step.Weight, raw, step.ProblemID, step.Step); err != nil {
+			carry, err := json.Marshal(step.Carry)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "json error: %v", err)
+				return
+			}
+			if _, err = tx.Exec(`UPDATE problem_steps SET note=$1,instructions=$2,weight=$3,files=$4,carry=$5 WHERE problem_id=$6 AND step=$7`,
+				step.Note, step.Instructions, step.Weight, raw, carry, step.ProblemID, step.Step); err != nil {
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 				return
 			}
@@ -157,6 +162,10 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, bundle *ProblemB
 			}
 		}
 	}
+	if err := saveProblemSolutions(tx, problem, bundle.Commits, now); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
 	if isUpdate {
 		log.Printf("problem %s (%d) with %d step(s) updated", problem.Unique, problem.ID, len(steps))
 	} else {
@@ -241,7 +250,13 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 	bundle.Problem.UpdatedAt = now
 
 	// compute signature
-	bundle.ProblemSignature = bundle.Problem.ComputeSignature(Config.DaycareSecret, bundle.ProblemSteps)
+	bundle.ProblemSignature = bundle.Problem.ComputeSignature(Config().DaycareSecret, bundle.ProblemSteps)
+	env, err := sealProblemEnvironment(tx, bundle.Problem.ID, bundle.ProblemSignature)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error preparing environment: %v", err)
+		return
+	}
+	bundle.Environment = env
 
 	// check the commits
 	whitelists := bundle.Problem.GetStepWhitelists(bundle.ProblemSteps)
@@ -267,7 +282,7 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 		}
 
 		// set timestamps and compute signature
-		sig := commit.ComputeSignature(Config.DaycareSecret, bundle.ProblemSignature)
+		sig := commit.ComputeSignature(Config().DaycareSecret, bundle.SigningSignature(Config().DaycareSecret))
 		bundle.CommitSignatures = append(bundle.CommitSignatures, sig)
 	}
 
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// progressAssignment is one row of the progress page.
+type progressAssignment struct {
+	ID               int64     `meddler:"id"`
+	CourseName       string    `meddler:"course_name"`
+	CourseEndsAt     time.Time `meddler:"course_ends_at,localtimez"`
+	Archived         bool      `meddler:"archived"`
+	ProblemSetUnique string    `meddler:"unique_id"`
+	ProblemSetNote   string    `meddler:"note"`
+	Score            float64   `meddler:"score"`
+	Deadline         time.Time `meddler:"deadline,localtimez"`
+	DroppedAt        time.Time `meddler:"dropped_at,localtimez"`
+	UpdatedAt        time.Time `meddler:"updated_at,localtime"`
+}
+
+// progressProblem is the status of one problem on the progress page for an assignment.
+type progressProblem struct {
+	Problem *Problem
+	Steps   int
+	Scores  []*progressStep
+	Commit  *Commit
+}
+
+type progressStep struct {
+	Step  int
+	Score float64
+}
+
+// GetProgress handles /progress requests,
+// returning a read-only page listing the current user's assignments that works well on a phone.
+func GetProgress(w http.ResponseWriter, tx *sql.Tx, currentUser *User) {
+	assignments := []*progressAssignment{}
+	if err := meddler.QueryAll(tx, &assignments, `SELECT assignments.id, courses.name AS course_name, courses.ends_at AS course_ends_at, courses.archived, `+
+		`problem_sets.unique_id, problem_sets.note, COALESCE(assignments.score, 0) AS score, sealed_exams.deadline, `+
+		`assignments.dropped_at, assignments.updated_at `+
+		`FROM assignments JOIN courses ON assignments.course_id = courses.id `+
+		`JOIN problem_sets ON assignments.problem_set_id = problem_sets.id `+
+		`LEFT JOIN sealed_exams ON sealed_exams.course_id = assignments.course_id AND sealed_exams.problem_set_id = assignments.problem_set_id `+
+		`WHERE assignments.user_id = $1 `+
+		`ORDER BY courses.archived, courses.name, assignments.updated_at DESC`,
+		currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	renderProgress(w, "list", map[string]interface{}{
+		"User":        currentUser,
+		"Assignments": assignments,
+	})
+}
+
+// GetProgressAssignment handles /progress/:assignment_id requests,
+// returning a read-only page with the status and latest report of each problem in an assignment.
+func GetProgressAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+
+	assignment := new(Assignment)
+	if err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := addPrerequisiteStatus(tx, []*Assignment{assignment}); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, assignment.CourseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	set := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", set, assignment.ProblemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	exam, err := getSealedExam(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	problemSetProblems := []*ProblemSetProblem{}
+	if err := meddler.QueryAll(tx, &problemSetProblems, `SELECT * FROM problem_set_problems WHERE problem_set_id = $1 ORDER BY problem_id`, set.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	problems := []*progressProblem{}
+	for _, elt := range problemSetProblems {
+		if !assignment.OnPath(elt.Track) {
+			continue
+		}
+		problem := new(Problem)
+		if err := meddler.Load(tx, "problems", problem, elt.ProblemID); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		var steps int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM problem_steps WHERE problem_id = $1`, problem.ID).Scan(&steps); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		commit := new(Commit)
+		if err := meddler.QueryRow(tx, commit, `SELECT * FROM commits WHERE assignment_id = $1 AND problem_id = $2 ORDER BY step DESC, created_at DESC LIMIT 1`,
+			assignment.ID, problem.ID); err == sql.ErrNoRows {
+			commit = nil
+		} else if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		scores := []*progressStep{}
+		for i, score := range assignment.RawScores[problem.Unique] {
+			scores = append(scores, &progressStep{Step: i + 1, Score: score})
+		}
+		problems = append(problems, &progressProblem{
+			Problem: problem,
+			Steps:   steps,
+			Scores:  scores,
+			Commit:  commit,
+		})
+	}
+
+	renderProgress(w, "assignment", map[string]interface{}{
+		"User":       currentUser,
+		"Assignment": assignment,
+		"Course":     course,
+		"ProblemSet": set,
+		"Exam":       exam,
+		"Problems":   problems,
+	})
+}
+
+func renderProgress(w http.ResponseWriter, name string, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := progressTemplates.ExecuteTemplate(w, name, data); err != nil {
+		loggedErrorf("error rendering progress page: %v", err)
+	}
+}
+
+var progressTemplates = template.Must(template.New("progress").Funcs(template.FuncMap{
+	"percent": func(score float64) string {
+		return fmt.Sprintf("%.0f%%", score*100.0)
+	},
+	"when": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format("Mon Jan 2, 3:04 PM")
+	},
+	"past": func(t time.Time) bool {
+		return !t.IsZero() && t.Before(time.Now())
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { margin: 0 auto; max-width: 48em; padding: 0 12px 24px; font-family: sans-serif; font-size: 16px; line-height: 1.4; }
+h1 { font-size: 1.3em; }
+h2 { font-size: 1.1em; margin-bottom: 4px; }
+a { color: #0a5ba8; }
+.card { border: 1px solid #ccc; border-radius: 6px; padding: 8px 12px; margin: 8px 0; }
+.card a { text-decoration: none; display: block; }
+.row { display: flex; flex-wrap: wrap; justify-content: space-between; gap: 4px 12px; }
+.score { font-weight: bold; white-space: nowrap; }
+.muted { color: #666; font-size: 0.9em; }
+.passed { color: #197a19; }
+.failed, .locked { color: #a11; }
+.results { margin: 4px 0 0; padding-left: 1.2em; font-size: 0.9em; }
+</style>
+</head>
+<body>{{end}}
+
+{{define "list"}}{{template "head" "My progress"}}
+<h1>Assignments for {{.User.Name}}</h1>
+{{range .Assignments}}
+<div class="card">
+<a href="/progress/{{.ID}}">
+<div class="row"><span>{{.ProblemSetNote}}</span><span class="score">{{percent .Score}}</span></div>
+<div class="muted">{{.CourseName}} · {{.ProblemSetUnique}}</div>
+{{if not .DroppedAt.IsZero}}<div class="muted locked">You are no longer enrolled in this course</div>
+{{else if .Archived}}<div class="muted">Course archived</div>
+{{else if not .Deadline.IsZero}}<div class="muted{{if past .Deadline}} locked{{end}}">Due {{when .Deadline}}</div>
+{{else if not .CourseEndsAt.IsZero}}<div class="muted">Term ends {{when .CourseEndsAt}}</div>{{end}}
+<div class="muted">Last activity {{when .UpdatedAt}}</div>
+</a>
+</div>
+{{else}}
+<p>You have no assignments yet. Launch one from your course in the LMS first.</p>
+{{end}}
+</body>
+</html>{{end}}
+
+{{define "assignment"}}{{template "head" .ProblemSet.Note}}
+<p><a href="/progress">&larr; All assignments</a></p>
+<h1>{{.ProblemSet.Note}}</h1>
+<div class="muted">{{.Course.Name}} · {{.ProblemSet.Unique}}</div>
+<div class="row"><span>Score</span><span class="score">{{percent .Assignment.Score}}</span></div>
+{{with .Exam}}<div class="muted{{if past .Deadline}} locked{{end}}">Due {{when .Deadline}}</div>{{end}}
+{{if .Assignment.IsLocked}}
+<div class="card locked">Locked until you complete:
+<ul>{{range .Assignment.Prerequisites}}{{if not .IsMet}}<li>{{.Note}} at {{percent .Threshold}} (you have {{percent .Score}})</li>{{end}}{{end}}</ul>
+</div>
+{{end}}
+{{if not .Assignment.DroppedAt.IsZero}}<div class="card locked">You are no longer enrolled in this course, so new work cannot be submitted.</div>{{end}}
+{{range .Problems}}
+<div class="card">
+<h2>{{.Problem.Note}}</h2>
+<div class="muted">{{.Problem.Unique}}</div>
+<div>{{range .Scores}}Step {{.Step}}: {{percent .Score}}&ensp;{{end}}</div>
+{{$steps := .Steps}}
+{{if .Commit}}{{with .Commit}}
+<div>Working on step {{.Step}} of {{$steps}}</div>
+<div class="muted">Last saved {{when .UpdatedAt}}</div>
+{{with .ReportCard}}
+<div class="{{if .Passed}}passed{{else}}failed{{end}}">{{if .Passed}}Passed{{else}}Failed{{end}}{{if .Note}}: {{.Note}}{{end}}</div>
+{{if .Results}}<ul class="results">{{range .Results}}<li class="{{.Outcome}}">{{.Name}}: {{.Outcome}}</li>{{end}}</ul>{{end}}
+{{else}}<div class="muted">Not graded yet</div>{{end}}
+{{end}}{{else}}<div class="muted">Not started</div>{{end}}
+</div>
+{{end}}
+</body>
+</html>{{end}}
+`))
@@ -15,7 +15,7 @@ import (
 const workingDir = "/home/student"
 
 func init() {
-	problemTypes["python27unittest"] = &ProblemType{
+	builtinProblemTypes["python27unittest"] = &ProblemType{
 		Name:        "python27unittest",
 		Image:       "codegrinder/python2",
 		MaxCPU:      10,
@@ -41,7 +41,7 @@ func init() {
 				Button:  "Run",
 				Message: "Running %s‥",
 				Class:   "btn-run",
-				//handler: autoHandler(python27Interactive),
+				Handler: nannyHandler(python2Interactive),
 			},
 			"debug": &ProblemTypeAction{
 				Action:  "debug",
@@ -55,7 +55,7 @@ func init() {
 				Button:  "Shell",
 				Message: "Running Python shell‥",
 				Class:   "btn-shell",
-				//handler: autoHandler(python27Adhoc),
+				Handler: nannyHandler(python2Adhoc),
 			},
 			"stylecheck": &ProblemTypeAction{
 				Action:  "stylecheck",
@@ -75,7 +75,7 @@ func init() {
 			},
 		},
 	}
-	problemTypes["python27inout"] = &ProblemType{
+	builtinProblemTypes["python27inout"] = &ProblemType{
 		Name:        "python27inout",
 		Image:       "codegrinder/python2",
 		MaxCPU:      10,
@@ -101,7 +101,7 @@ func init() {
 				Button:  "Run",
 				Message: "Running %s‥",
 				Class:   "btn-run",
-				//handler: autoHandler(python27Interactive),
+				Handler: nannyHandler(python2Interactive),
 			},
 			"debug": &ProblemTypeAction{
 				Action:  "debug",
@@ -115,7 +115,7 @@ func init() {
 				Button:  "Shell",
 				Message: "Running Python shell‥",
 				Class:   "btn-shell",
-				//handler: autoHandler(python27Adhoc),
+				Handler: nannyHandler(python2Adhoc),
 			},
 			"stylecheck": &ProblemTypeAction{
 				Action:  "stylecheck",
@@ -274,3 +274,42 @@ func python2UnittestGrade(n *Nanny, args []string, options []string, files map[s
 		}
 	}
 }
+
+func python2Interactive(n *Nanny, args []string, options []string, files map[string]string) {
+	log.Printf("python2Interactive")
+	if len(args) != 1 {
+		n.ReportCard.LogAndFailf("the name of the file to run must be given")
+		return
+	}
+
+	// put the files in the container
+	if err := n.PutFiles(files); err != nil {
+		n.ReportCard.LogAndFailf("PutFiles error: %v", err)
+		return
+	}
+
+	// run the program with the client attached
+	status, err := n.ExecInteractive([]string{"python", args[0]})
+	if err != nil {
+		n.ReportCard.LogAndFailf("exec error: %v", err)
+		return
+	}
+	if status != 0 {
+		n.ReportCard.Passed = false
+	}
+}
+
+func python2Adhoc(n *Nanny, args []string, options []string, files map[string]string) {
+	log.Printf("python2Adhoc")
+
+	// put the files in the container
+	if err := n.PutFiles(files); err != nil {
+		n.ReportCard.LogAndFailf("PutFiles error: %v", err)
+		return
+	}
+
+	// start a python shell with the client attached
+	if _, err := n.ExecInteractive([]string{"python", "-i"}); err != nil {
+		n.ReportCard.LogAndFailf("exec error: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// describeHelpRequests fills in the names of the students, problems, and helpers
+// in a list of help requests, and the place in line of each one still waiting.
+func describeHelpRequests(tx *sql.Tx, requests []*HelpRequest) error {
+	for _, elt := range requests {
+		if err := tx.QueryRow(`SELECT name FROM users WHERE id = $1`, elt.UserID).Scan(&elt.Student); err != nil {
+			return err
+		}
+		elt.Problem = ""
+		if elt.ProblemID > 0 {
+			if err := tx.QueryRow(`SELECT unique_id FROM problems WHERE id = $1`, elt.ProblemID).Scan(&elt.Problem); err != nil {
+				return err
+			}
+		}
+		elt.Helper = ""
+		if elt.HelperID > 0 {
+			if err := tx.QueryRow(`SELECT name FROM users WHERE id = $1`, elt.HelperID).Scan(&elt.Helper); err != nil {
+				return err
+			}
+		}
+		elt.Position = 0
+		if elt.Status == "waiting" {
+			if err := tx.QueryRow(`SELECT COUNT(1) + 1 FROM help_requests WHERE course_id = $1 AND status = 'waiting' `+
+				`AND (created_at, id) < ($2, $3)`, elt.CourseID, elt.CreatedAt, elt.ID).Scan(&elt.Position); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getOpenHelpRequests returns the requests in a course's queue that are still
+// waiting or being helped, in the order they joined.
+func getOpenHelpRequests(tx *sql.Tx, courseID int64) ([]*HelpRequest, error) {
+	requests := []*HelpRequest{}
+	if err := meddler.QueryAll(tx, &requests, `SELECT * FROM help_requests WHERE course_id = $1 AND status IN ('waiting', 'claimed') `+
+		`ORDER BY created_at, id`, courseID); err != nil {
+		return nil, err
+	}
+	if err := describeHelpRequests(tx, requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// PostAssignmentHelpRequest handles /v2/assignments/:assignment_id/help_requests requests,
+// putting the current user in the office-hours queue for the assignment's course.
+// If a problem is given, the request links the latest work saved for it.
+// A student has one place in line per course, so asking again while still in
+// the queue updates the request without losing that place.
+// The request is returned.
+func PostAssignmentHelpRequest(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, request HelpRequest, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	asst := new(Assignment)
+	if err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if asst.IsDropped() {
+		loggedHTTPErrorf(w, http.StatusForbidden, "you are no longer enrolled in this course")
+		return
+	}
+	if err := request.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// link the work the student has saved so far
+	commitID, step := int64(0), int64(0)
+	if request.ProblemID > 0 {
+		var inSet bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM problem_set_problems WHERE problem_set_id = $1 AND problem_id = $2)`,
+			asst.ProblemSetID, request.ProblemID).Scan(&inSet); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if !inSet {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "problem %d is not part of this assignment", request.ProblemID)
+			return
+		}
+		err := tx.QueryRow(`SELECT id, step FROM commits WHERE assignment_id = $1 AND problem_id = $2 ORDER BY step DESC LIMIT 1`,
+			asst.ID, request.ProblemID).Scan(&commitID, &step)
+		if err != nil && err != sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+
+	now := time.Now()
+	existing := new(HelpRequest)
+	err = meddler.QueryRow(tx, existing, `SELECT * FROM help_requests WHERE course_id = $1 AND user_id = $2 AND status IN ('waiting', 'claimed') `+
+		`ORDER BY id LIMIT 1 FOR UPDATE`, asst.CourseID, currentUser.ID)
+	switch {
+	case err == sql.ErrNoRows:
+		existing = &HelpRequest{
+			CourseID:  asst.CourseID,
+			UserID:    currentUser.ID,
+			Status:    "waiting",
+			CreatedAt: now,
+		}
+	case err != nil:
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	existing.AssignmentID = asst.ID
+	existing.ProblemID = request.ProblemID
+	existing.Step = step
+	existing.CommitID = commitID
+	existing.Message = request.Message
+	existing.UpdatedAt = now
+	if err := meddler.Save(tx, "help_requests", existing); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := describeHelpRequests(tx, []*HelpRequest{existing}); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("help request %d from %s in course %d", existing.ID, currentUser.Name, asst.CourseID)
+	render.JSON(http.StatusOK, existing)
+}
+
+// GetUserMeHelpRequests handles /v2/users/me/help_requests requests,
+// returning the current user's places in office-hours queues.
+func GetUserMeHelpRequests(w http.ResponseWriter, tx *sql.Tx, currentUser *User, render render.Render) {
+	requests := []*HelpRequest{}
+	if err := meddler.QueryAll(tx, &requests, `SELECT * FROM help_requests WHERE user_id = $1 AND status IN ('waiting', 'claimed') `+
+		`ORDER BY created_at, id`, currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := describeHelpRequests(tx, requests); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, requests)
+}
+
+// DeleteUserMeHelpRequest handles /v2/users/me/help_requests/:help_request_id requests,
+// taking the current user out of an office-hours queue. The request is kept,
+// marked withdrawn, so it still counts in the course's statistics.
+func DeleteUserMeHelpRequest(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	requestID, err := parseID(w, "help_request_id", params["help_request_id"])
+	if err != nil {
+		return
+	}
+	result, err := tx.Exec(`UPDATE help_requests SET status = 'withdrawn', updated_at = $1 `+
+		`WHERE id = $2 AND user_id = $3 AND status IN ('waiting', 'claimed')`, time.Now(), requestID, currentUser.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "you have no help request %d waiting", requestID)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCourseHelpRequests handles /v2/courses/:course_id/help_requests requests,
+// returning the students in the course's office-hours queue in the order they
+// joined, with those already being helped.
+func GetCourseHelpRequests(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	requests, err := getOpenHelpRequests(tx, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, requests)
+}
+
+// PutCourseHelpRequest handles /v2/courses/:course_id/help_requests/:help_request_id requests,
+// moving a request through the queue. Status claimed takes the request for the
+// current user, waiting puts it back in its place in line, and resolved takes it
+// out of the queue with an optional note.
+// The updated request is returned.
+func PutCourseHelpRequest(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, update HelpRequest, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	requestID, err := parseID(w, "help_request_id", params["help_request_id"])
+	if err != nil {
+		return
+	}
+	request := new(HelpRequest)
+	if err := meddler.QueryRow(tx, request, `SELECT * FROM help_requests WHERE id = $1 AND course_id = $2 FOR UPDATE`, requestID, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if !request.IsOpen() {
+		loggedHTTPErrorf(w, http.StatusConflict, "help request %d was already %s", requestID, request.Status)
+		return
+	}
+
+	now := time.Now()
+	switch update.Status {
+	case "claimed":
+		if request.Status == "claimed" && request.HelperID != currentUser.ID {
+			loggedHTTPErrorf(w, http.StatusConflict, "help request %d has already been claimed", requestID)
+			return
+		}
+		request.HelperID = currentUser.ID
+		request.ClaimedAt = now
+	case "waiting":
+		request.HelperID = 0
+		request.ClaimedAt = time.Time{}
+	case "resolved":
+		if request.Status == "waiting" {
+			request.HelperID = currentUser.ID
+			request.ClaimedAt = now
+		}
+		request.ResolvedAt = now
+		request.Note = strings.TrimSpace(update.Note)
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "status must be claimed, waiting, or resolved")
+		return
+	}
+
+	request.Status = update.Status
+	request.UpdatedAt = now
+	if err := meddler.Update(tx, "help_requests", request); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := describeHelpRequests(tx, []*HelpRequest{request}); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("help request %d %s by %s", request.ID, request.Status, currentUser.Name)
+	render.JSON(http.StatusOK, request)
+}
+
+// getHelpQueueStats summarizes a course's office-hours queue for requests made
+// between from and to if they are set. It returns nil if the queue has not been used.
+func getHelpQueueStats(tx *sql.Tx, courseID int64, from, to time.Time) (*HelpQueueStats, error) {
+	stats := new(HelpQueueStats)
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM help_requests WHERE course_id = $1 AND status = 'waiting'`, courseID).Scan(&stats.Waiting); err != nil {
+		return nil, err
+	}
+	where, args := ` WHERE course_id = $1`, []interface{}{courseID}
+	if !from.IsZero() {
+		args = append(args, from)
+		where += ` AND created_at >= $2`
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		where += fmt.Sprintf(` AND created_at < $%d`, len(args))
+	}
+	var meanWait, maxWait, meanHelp float64
+	if err := tx.QueryRow(`SELECT COUNT(1), COUNT(resolved_at), COALESCE(SUM(CASE WHEN status = 'withdrawn' THEN 1 ELSE 0 END), 0), `+
+		`COALESCE(AVG(EXTRACT(EPOCH FROM claimed_at - created_at)), 0), COALESCE(MAX(EXTRACT(EPOCH FROM claimed_at - created_at)), 0), `+
+		`COALESCE(AVG(EXTRACT(EPOCH FROM resolved_at - claimed_at)), 0) FROM help_requests`+where, args...).
+		Scan(&stats.Requests, &stats.Resolved, &stats.Withdrawn, &meanWait, &maxWait, &meanHelp); err != nil {
+		return nil, err
+	}
+	if stats.Requests == 0 && stats.Waiting == 0 {
+		return nil, nil
+	}
+	stats.MeanWaitMinutes = meanWait / 60.0
+	stats.MaxWaitMinutes = maxWait / 60.0
+	stats.MeanHelpMinutes = meanHelp / 60.0
+	return stats, nil
+}
+
+// GetHelpQueuePage handles /help_queue/:course_id requests,
+// returning a page where instructors and TAs can work through the office-hours
+// queue. It refreshes itself, and uses the same API calls as grind.
+func GetHelpQueuePage(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	requests, err := getOpenHelpRequests(tx, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := helpQueueTemplate.Execute(w, map[string]interface{}{
+		"User":     currentUser,
+		"Course":   course,
+		"Requests": requests,
+	}); err != nil {
+		loggedErrorf("error rendering help queue page: %v", err)
+	}
+}
+
+var helpQueueTemplate = template.Must(template.New("help_queue").Funcs(template.FuncMap{
+	"waited": func(t time.Time) string {
+		minutes := int(time.Since(t).Minutes())
+		if minutes < 1 {
+			return "just now"
+		}
+		return fmt.Sprintf("%d min ago", minutes)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>Office hours: {{.Course.Name}}</title>
+<style>
+body { margin: 0 auto; max-width: 48em; padding: 0 12px 24px; font-family: sans-serif; font-size: 16px; line-height: 1.4; }
+h1 { font-size: 1.3em; }
+.card { border: 1px solid #ccc; border-radius: 6px; padding: 8px 12px; margin: 8px 0; }
+.claimed { background: #f4f8ff; }
+.row { display: flex; flex-wrap: wrap; justify-content: space-between; gap: 4px 12px; }
+.muted { color: #666; font-size: 0.9em; }
+button { margin: 6px 6px 0 0; }
+</style>
+</head>
+<body>
+<h1>Office hours: {{.Course.Name}}</h1>
+{{range .Requests}}
+<div class="card{{if eq .Status "claimed"}} claimed{{end}}">
+<div class="row"><strong>{{if .Position}}{{.Position}}. {{end}}{{.Student}}</strong><span class="muted">joined {{waited .CreatedAt}}</span></div>
+<div>{{.Message}}</div>
+{{if .Problem}}<div class="muted">{{.Problem}} step {{.Step}}{{if .CommitID}}, work saved as commit {{.CommitID}}{{end}}</div>{{end}}
+{{if eq .Status "claimed"}}<div class="muted">Being helped by {{.Helper}}</div>
+<button onclick="update({{.ID}}, 'resolved')">Resolved</button>
+<button onclick="update({{.ID}}, 'waiting')">Put back</button>
+{{else}}<button onclick="update({{.ID}}, 'claimed')">Claim</button>
+<button onclick="update({{.ID}}, 'resolved')">Resolved</button>{{end}}
+</div>
+{{else}}
+<p>Nobody is waiting for help.</p>
+{{end}}
+<script>
+function update(id, status) {
+	var body = { status: status };
+	if (status === 'resolved') {
+		var note = prompt('Note about the visit (optional)');
+		if (note === null) {
+			return;
+		}
+		body.note = note;
+	}
+	fetch('/v2/courses/{{.Course.ID}}/help_requests/' + id, {
+		method: 'PUT',
+		credentials: 'same-origin',
+		headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify(body)
+	}).then(function(res) {
+		if (!res.ok) {
+			return res.text().then(function(text) { alert(text.trim() || res.statusText); });
+		}
+		location.reload();
+	});
+}
+</script>
+</body>
+</html>`))
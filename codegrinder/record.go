@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// reportSigningKey returns the key grade reports are signed with, from the config file.
+// It has nothing to do with any other secret, so it can be kept for years without
+// tying down the rest of the configuration.
+func reportSigningKey() (ed25519.PrivateKey, error) {
+	if Config().ReportSigningKey == "" {
+		return nil, fmt.Errorf("no ReportSigningKey in the config file")
+	}
+	seed, err := base64.StdEncoding.DecodeString(Config().ReportSigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("ReportSigningKey is not valid base64: %v", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ReportSigningKey must be a %d-byte seed, found %d bytes", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// appendSubmissionRecord adds a saved commit to the end of the submission log for its assignment,
+// keeping a snapshot of its files alongside.
+// The assignment is locked so that concurrent saves cannot fork the chain.
+func appendSubmissionRecord(tx *sql.Tx, commit *Commit, now time.Time) error {
+	var id int64
+	if err := tx.QueryRow(`SELECT id FROM assignments WHERE id = $1 FOR UPDATE`, commit.AssignmentID).Scan(&id); err != nil {
+		return err
+	}
+	record := &SubmissionRecord{
+		AssignmentID: commit.AssignmentID,
+		CommitID:     commit.ID,
+		ProblemID:    commit.ProblemID,
+		Step:         commit.Step,
+		Action:       commit.Action,
+		Digest:       commit.Digest(),
+		CreatedAt:    now.Round(time.Second),
+	}
+	err := tx.QueryRow(`SELECT hash FROM submission_records WHERE assignment_id = $1 ORDER BY id DESC LIMIT 1`, commit.AssignmentID).Scan(&record.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	record.Hash = record.ComputeHash()
+	if err := meddler.Insert(tx, "submission_records", record); err != nil {
+		return err
+	}
+	return meddler.Insert(tx, "submission_snapshots", NewSubmissionSnapshot(record.ID, commit))
+}
+
+// loadOwnAssignment loads an assignment, which must belong to the current user unless they are an administrator.
+func loadOwnAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) *Assignment {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return nil
+	}
+	assignment := new(Assignment)
+	if currentUser.Admin {
+		err = meddler.Load(tx, "assignments", assignment, assignmentID)
+	} else {
+		err = meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil
+	}
+	return assignment
+}
+
+// GetReportSigningKey handles /v2/report_signing_key requests,
+// returning the public key for checking grade reports signed by this server.
+func GetReportSigningKey(w http.ResponseWriter, render render.Render) {
+	key, err := reportSigningKey()
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	public := key.Public().(ed25519.PublicKey)
+	render.JSON(http.StatusOK, &ReportSigningKey{
+		PublicKey:   base64.StdEncoding.EncodeToString(public),
+		Fingerprint: ReportKeyFingerprint(public),
+	})
+}
+
+// GetAssignmentSubmissionRecords handles /v2/assignments/:assignment_id/submission_records requests,
+// returning the log of everything saved for an assignment, oldest first.
+// If parameter limit=<...> is present, the log is returned a page at a time (see parseListPage).
+func GetAssignmentSubmissionRecords(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment := loadOwnAssignment(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	page, err := parseListPage(w, r)
+	if err != nil {
+		return
+	}
+	where, args := page.whereAfter(" WHERE assignment_id = $1", []interface{}{assignment.ID}, "id")
+	records := []*SubmissionRecord{}
+	if err := meddler.QueryAll(tx, &records, `SELECT * FROM submission_records`+where+` ORDER BY id`+page.limitSQL(), args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	records = records[:page.trim(w, len(records), func(i int) int64 { return records[i].ID })]
+	render.JSON(http.StatusOK, records)
+}
+
+// GetAssignmentReport handles /v2/assignments/:assignment_id/report requests,
+// returning a grade report for the assignment signed by the server. It names the
+// last entry in the submission log, so it also vouches for everything saved before it.
+func GetAssignmentReport(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment := loadOwnAssignment(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	// a report names the student, so it waits until anonymous grading is finalized
+	if assignment.UserID != currentUser.ID {
+		anon, err := getAnonymousGrading(tx, assignment.CourseID, assignment.ProblemSetID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if anon != nil && anon.IsAnonymous() {
+			loggedHTTPErrorf(w, http.StatusForbidden, "grading for this problem set is anonymous until it is finalized")
+			return
+		}
+	}
+	report, err := buildGradeReport(tx, assignment)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
+
+// buildGradeReport gathers the grade report for an assignment and signs it.
+func buildGradeReport(tx *sql.Tx, assignment *Assignment) (*GradeReport, error) {
+	user := new(User)
+	if err := meddler.Load(tx, "users", user, assignment.UserID); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	problemSet := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", problemSet, assignment.ProblemSetID); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+
+	report := &GradeReport{
+		AssignmentID: assignment.ID,
+		CourseID:     assignment.CourseID,
+		ProblemSetID: assignment.ProblemSetID,
+		ProblemSet:   problemSet.Unique,
+		UserID:       user.ID,
+		Name:         user.Name,
+		Email:        user.Email,
+		RawScores:    assignment.RawScores,
+		Score:        assignment.Score,
+		IssuedAt:     time.Now(),
+	}
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM submission_records WHERE assignment_id = $1`, assignment.ID).Scan(&report.Records); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	err := tx.QueryRow(`SELECT hash FROM submission_records WHERE assignment_id = $1 ORDER BY id DESC LIMIT 1`, assignment.ID).Scan(&report.LastHash)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	key, err := reportSigningKey()
+	if err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "%v", err)
+	}
+	report.Sign(key)
+	return report, nil
+}
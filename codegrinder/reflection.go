@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetProblemReflections handles /v2/problems/:problem_id/reflections requests,
+// returning the reflection prompts for the problem.
+func GetProblemReflections(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	prompts, err := getReflectionPrompts(tx, problemID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, prompts)
+}
+
+// PutProblemReflection handles /v2/problems/:problem_id/reflections/:name requests,
+// setting a reflection prompt that students answer before or after the problem is graded.
+// The prompt is returned.
+func PutProblemReflection(w http.ResponseWriter, tx *sql.Tx, currentUser *User, params martini.Params, prompt ReflectionPrompt, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	prompt.ProblemID = problemID
+	prompt.Name = params["name"]
+	if err := prompt.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	now := time.Now()
+	prompt.UpdatedAt = now
+	old := new(ReflectionPrompt)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM reflection_prompts WHERE problem_id = $1 AND name = $2`, problemID, prompt.Name)
+	switch {
+	case err == sql.ErrNoRows:
+		prompt.CreatedAt = now
+		err = meddler.Insert(tx, "reflection_prompts", &prompt)
+	case err == nil:
+		prompt.CreatedAt = old.CreatedAt
+		_, err = tx.Exec(`UPDATE reflection_prompts SET question = $1, asked_when = $2, updated_at = $3 WHERE problem_id = $4 AND name = $5`,
+			prompt.Question, prompt.When, now, problemID, prompt.Name)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("%s set reflection prompt %s for problem %s", currentUser.Name, prompt.Name, problem.Unique)
+	render.JSON(http.StatusOK, &prompt)
+}
+
+// DeleteProblemReflection handles /v2/problems/:problem_id/reflections/:name requests,
+// removing a reflection prompt from the problem. Answers already given are kept.
+func DeleteProblemReflection(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM reflection_prompts WHERE problem_id = $1 AND name = $2`, problemID, params["name"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func getReflectionPrompts(tx *sql.Tx, problemID int64) ([]*ReflectionPrompt, error) {
+	prompts := []*ReflectionPrompt{}
+	err := meddler.QueryAll(tx, &prompts, `SELECT * FROM reflection_prompts WHERE problem_id = $1 ORDER BY asked_when DESC, name`, problemID)
+	return prompts, err
+}
+
+// checkReflections returns an error if a commit sent to be graded on the last step
+// of a problem is missing answers to prompts that must be answered first.
+func checkReflections(tx *sql.Tx, problem *Problem, commit *Commit) error {
+	prompts, err := getReflectionPrompts(tx, problem.ID)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	var missing []string
+	for _, prompt := range prompts {
+		if prompt.When == ReflectionBefore && strings.TrimSpace(commit.Reflections[prompt.Name]) == "" {
+			missing = append(missing, prompt.Question)
+		}
+	}
+	if len(missing) > 0 {
+		return httpErrorf(http.StatusForbidden, "answer these questions about %s before it is graded: %s",
+			problem.Unique, strings.Join(missing, " / "))
+	}
+	return nil
+}
+
+// GetAssignmentProblemReflections handles /v2/assignments/:assignment_id/problems/:problem_id/reflections requests,
+// returning the reflection prompts for the problem along with the student's answers.
+func GetAssignmentProblemReflections(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, problemID := loadOwnAssignmentProblem(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	prompts, err := getReflectionPrompts(tx, problemID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	commit, err := getLastStepCommit(tx, assignment.ID, problemID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	statuses := []*ReflectionStatus{}
+	for _, prompt := range prompts {
+		status := &ReflectionStatus{ReflectionPrompt: *prompt}
+		if commit != nil {
+			status.Answer = commit.Reflections[prompt.Name]
+		}
+		statuses = append(statuses, status)
+	}
+	render.JSON(http.StatusOK, statuses)
+}
+
+// PutAssignmentProblemReflections handles /v2/assignments/:assignment_id/problems/:problem_id/reflections requests,
+// saving the student's answers to reflection prompts with their commit for the last step of
+// the problem. Answers that are not given are left unchanged. The prompts and answers are returned.
+func PutAssignmentProblemReflections(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, answers ReflectionAnswers, render render.Render) {
+	assignment, problemID := loadOwnAssignmentProblem(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	prompts, err := getReflectionPrompts(tx, problemID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	known := make(map[string]bool)
+	for _, prompt := range prompts {
+		known[prompt.Name] = true
+	}
+	for name := range answers.Answers {
+		if !known[name] {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "there is no reflection prompt named %s", name)
+			return
+		}
+	}
+	commit, err := getLastStepCommit(tx, assignment.ID, problemID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if commit == nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "save your work on the last step of the problem before answering")
+		return
+	}
+	if commit.Reflections == nil {
+		commit.Reflections = make(map[string]string)
+	}
+	for name, answer := range answers.Answers {
+		commit.Reflections[name] = strings.TrimSpace(answer)
+	}
+	if err := meddler.Update(tx, "commits", commit); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	statuses := []*ReflectionStatus{}
+	for _, prompt := range prompts {
+		statuses = append(statuses, &ReflectionStatus{ReflectionPrompt: *prompt, Answer: commit.Reflections[prompt.Name]})
+	}
+	render.JSON(http.StatusOK, statuses)
+}
+
+// GetCourseReflections handles /v2/courses/:course_id/reflections requests,
+// returning every answer to a reflection prompt by students in the course.
+// It accepts a problem_id parameter to limit it to one problem, and with
+// anonymous=true, students are identified only by pseudonyms that stay the
+// same from one export to the next, for use in research. Otherwise, answers on
+// problem sets whose grading is still anonymous use that grading's pseudonyms.
+func GetCourseReflections(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	where, args := "WHERE assignments.course_id = $1", []interface{}{courseID}
+	if problemID := r.FormValue("problem_id"); problemID != "" {
+		id, err := parseID(w, "problem_id", problemID)
+		if err != nil {
+			return
+		}
+		where, args = where+" AND commits.problem_id = $2", append(args, id)
+	}
+	answers := []*ReflectionAnswer{}
+	if err := meddler.QueryAll(tx, &answers, `SELECT assignments.id AS assignment_id, users.id AS user_id, users.name, users.email, `+
+		`commits.problem_id, problems.unique_id, reflection.key AS prompt, reflection.value AS answer, `+
+		`commits.score, commits.updated_at AS answered_at `+
+		`FROM commits JOIN assignments ON commits.assignment_id = assignments.id `+
+		`JOIN users ON assignments.user_id = users.id `+
+		`JOIN problems ON commits.problem_id = problems.id, `+
+		`jsonb_each_text(CASE WHEN jsonb_typeof(commits.reflections) = 'object' THEN commits.reflections ELSE '{}' END) AS reflection `+
+		where+` ORDER BY problems.unique_id, users.name, reflection.key`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if r.FormValue("anonymous") == "true" {
+		anon := &AnonymousGrading{Salt: fmt.Sprintf("research-%d-%s", courseID, Config().DaycareSecret)}
+		for _, elt := range answers {
+			elt.Name = anon.Pseudonym(elt.UserID)
+			elt.AssignmentID = 0
+			elt.UserID = 0
+			elt.Email = ""
+		}
+	} else {
+		anons, err := getAnonymousProblemSets(tx, courseID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		problemSets := make(map[int64]int64)
+		for _, elt := range answers {
+			if len(anons) == 0 {
+				break
+			}
+			problemSetID, exists := problemSets[elt.AssignmentID]
+			if !exists {
+				asst := new(Assignment)
+				if err := meddler.Load(tx, "assignments", asst, elt.AssignmentID); err != nil {
+					loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+					return
+				}
+				problemSetID = asst.ProblemSetID
+				problemSets[elt.AssignmentID] = problemSetID
+			}
+			if anon := anons[problemSetID]; anon != nil {
+				elt.Name = anon.Pseudonym(elt.UserID)
+				elt.AssignmentID = 0
+				elt.UserID = 0
+				elt.Email = ""
+			}
+		}
+	}
+	render.JSON(http.StatusOK, answers)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+// reloadableConfig lists the config fields that take effect without a restart.
+// Tenants can be reloaded as long as the set of hostnames stays the same,
+// since the TLS certificates are requested for those hosts at startup.
+var reloadableConfig = map[string]bool{
+	"LTISecret":        true,
+	"ToolName":         true,
+	"ToolID":           true,
+	"ToolDescription":  true,
+	"DaycareHost":      true,
+	"ContainerQuotaMB": true,
+	"PruneMinutes":     true,
+	"ProblemTypesDir":  true,
+	"GitHubToken":      true,
+	"CanaryHosts":      true,
+	"AlertWebhook":     true,
+	"TraceEndpoint":    true,
+	"TraceSampleRate":  true,
+	"ArchiveDays":      true,
+	"EmailGateway":     true,
+	"EmailSecret":      true,
+	"AssetMirror":      true,
+	"MirrorSecret":     true,
+	"MirrorMinutes":    true,
+	"Discovery":        true,
+	"ACMEChallenge":    true,
+	"ACMEDNSHook":      true,
+	"CertWarnDays":     true,
+	"Tenants":          true,
+}
+
+var reloadLock sync.Mutex
+
+// reloadConfig reads the config file again and applies the settings that can change
+// while the server runs. The new config, tenants, and problem types are built aside
+// and then published, so requests in progress, including daycare websocket sessions,
+// carry on with the settings they started with. Nothing changes if the file has an error.
+func reloadConfig() (*ConfigReload, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	fresh, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	freshTenants, err := buildTenants(fresh)
+	if err != nil {
+		return nil, err
+	}
+	oldTenants := tenants()
+	sameHosts := len(freshTenants) == len(oldTenants)
+	for host := range freshTenants {
+		if oldTenants[host] == nil {
+			sameHosts = false
+		}
+	}
+
+	// problem type definitions may have changed even if the directory has not
+	loaded := builtinProblemTypes
+	if fresh.ProblemTypesDir != "" {
+		if loaded, err = loadProblemTypes(fresh.ProblemTypesDir); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ConfigReload{Reloaded: []string{}, NeedRestart: []string{}}
+	config := *Config()
+	current, next := reflect.ValueOf(&config).Elem(), reflect.ValueOf(fresh).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		name := current.Type().Field(i).Name
+		if reflect.DeepEqual(current.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+		if reloadableConfig[name] && (name != "Tenants" || sameHosts) {
+			current.Field(i).Set(next.Field(i))
+			report.Reloaded = append(report.Reloaded, name)
+		} else {
+			report.NeedRestart = append(report.NeedRestart, name)
+		}
+	}
+
+	if err := checkMirrorConfig(&config); err != nil {
+		return nil, err
+	}
+
+	// the default tenant comes from the top-level fields
+	built, err := buildTenants(&config)
+	if err != nil {
+		return nil, err
+	}
+	setConfig(&config)
+	currentTenants.Store(built)
+	setProblemTypes(loaded)
+
+	log.Printf("reloaded config from %s: changed [%s], changes needing a restart [%s]",
+		configPath, strings.Join(report.Reloaded, ", "), strings.Join(report.NeedRestart, ", "))
+	return report, nil
+}
+
+// reloadConfigOnSignal reloads the config file whenever the server gets a SIGHUP.
+func reloadConfigOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if _, err := reloadConfig(); err != nil {
+			log.Printf("error reloading config: %v", err)
+		}
+	}
+}
+
+// PostConfigReload handles /v2/config/reload requests,
+// reloading the server config file and reporting what changed.
+func PostConfigReload(w http.ResponseWriter, render render.Render) {
+	report, err := reloadConfig()
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error reloading config: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func init() {
+	commands["record-traffic"] = &serverCommand{Short: "save a day of grading requests, without student details, for replay", Run: CommandRecordTraffic}
+	commands["replay"] = &serverCommand{Short: "replay recorded grading requests against a daycare for load testing", Run: CommandReplay}
+}
+
+// replayUserBase is added to the request number to get the user ID for a replayed
+// request, so that replayed requests get their own containers instead of
+// replacing each other or those of real students.
+const replayUserBase = 1 << 40
+
+// ReplayEntry is one recorded grading request. Nothing about the student is kept:
+// not their name, user ID, or assignment, and not the time of day.
+type ReplayEntry struct {
+	Offset  time.Duration     `json:"offset"`  // since the first request in the recording
+	Problem string            `json:"problem"` // unique ID, which is the same across deployments
+	Step    int64             `json:"step"`
+	Action  string            `json:"action"`
+	Files   map[string]string `json:"files"`
+}
+
+// CommandRecordTraffic handles "codegrinder record-traffic [-day DATE] FILE".
+// The grading requests come from the submission log, so only work saved since
+// it was introduced can be recorded. The files are the latest saved for each
+// commit, which are usually the ones that were graded.
+func CommandRecordTraffic(args []string) {
+	fs := flag.NewFlagSet("record-traffic", flag.ExitOnError)
+	var tenantHost, day string
+	fs.StringVar(&tenantHost, "tenant", Config().Hostname, "Hostname of the tenant to record")
+	fs.StringVar(&day, "day", time.Now().AddDate(0, 0, -1).Format("2006-01-02"), "Day to record, in local time")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: codegrinder record-traffic [-tenant HOST] [-day YYYY-MM-DD] FILE")
+	}
+	tenant := findTenant(tenantHost)
+	if tenant == nil {
+		log.Fatalf("no tenant found for host %s", tenantHost)
+	}
+	start, err := time.ParseInLocation("2006-01-02", day, time.Local)
+	if err != nil {
+		log.Fatalf("day must be given as YYYY-MM-DD: %v", err)
+	}
+	path := fs.Arg(0)
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("error creating %s: %v", path, err)
+	}
+	defer fp.Close()
+	out := bufio.NewWriter(fp)
+	encoder := json.NewEncoder(out)
+
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	defer db.Close()
+
+	count := 0
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		rows, err := tx.Query(`SELECT submission_records.created_at, problems.unique_id, commits.step, `+
+			`submission_records.action, commits.files FROM submission_records `+
+			`JOIN commits ON commits.id = submission_records.commit_id `+
+			`JOIN problems ON problems.id = submission_records.problem_id `+
+			`WHERE submission_records.action = 'grade' `+
+			`AND submission_records.created_at >= $1 AND submission_records.created_at < $2 `+
+			`ORDER BY submission_records.created_at, submission_records.id`, start, start.AddDate(0, 0, 1))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var first time.Time
+		for rows.Next() {
+			entry := new(ReplayEntry)
+			var at time.Time
+			var files []byte
+			if err := rows.Scan(&at, &entry.Problem, &entry.Step, &entry.Action, &files); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(files, &entry.Files); err != nil {
+				return err
+			}
+			if first.IsZero() {
+				first = at
+			}
+			entry.Offset = at.Sub(first)
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+			count++
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		log.Fatalf("error recording traffic: %v", err)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatalf("error writing %s: %v", path, err)
+	}
+	log.Printf("recorded %d grading request%s from %s to %s", count, plural(count), day, path)
+}
+
+// replayProblem is what is needed to sign requests for one problem.
+type replayProblem struct {
+	Problem   *Problem
+	Steps     []*ProblemStep
+	Signature string
+	Env       string
+}
+
+// replayResult is the outcome of one replayed request.
+type replayResult struct {
+	Latency time.Duration
+	Passed  bool
+	Err     error
+}
+
+// CommandReplay handles "codegrinder replay [-speed N] [-daycare HOST] FILE".
+// Each request is sent at its recorded offset divided by the speed, without
+// waiting for earlier requests to finish, so the daycare sees the same bursts
+// of load it saw on the recorded day. The problems must exist on the deployment
+// named in the config file, which should be a staging deployment.
+func CommandReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var tenantHost, daycareHost string
+	var speed float64
+	var limit int
+	fs.StringVar(&tenantHost, "tenant", Config().Hostname, "Hostname of the tenant whose problems are used")
+	fs.StringVar(&daycareHost, "daycare", Config().DaycareHost, "Host of the daycare to send requests to")
+	fs.Float64Var(&speed, "speed", 1.0, "How many times faster than recorded to send requests")
+	fs.IntVar(&limit, "limit", 0, "Stop after this many requests, or 0 for all of them")
+	fs.Parse(args)
+	if fs.NArg() != 1 || speed <= 0 {
+		log.Fatalf("usage: codegrinder replay [-tenant HOST] [-daycare HOST] [-speed N] [-limit N] FILE")
+	}
+	tenant := findTenant(tenantHost)
+	if tenant == nil {
+		log.Fatalf("no tenant found for host %s", tenantHost)
+	}
+
+	// read the recording
+	path := fs.Arg(0)
+	fp, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("error opening %s: %v", path, err)
+	}
+	var entries []*ReplayEntry
+	decoder := json.NewDecoder(bufio.NewReader(fp))
+	for decoder.More() {
+		entry := new(ReplayEntry)
+		if err := decoder.Decode(entry); err != nil {
+			log.Fatalf("error reading %s: %v", path, err)
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+	fp.Close()
+	if len(entries) == 0 {
+		log.Fatalf("no requests found in %s", path)
+	}
+
+	// look up the problems on this deployment
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	problems := make(map[string]*replayProblem)
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		for _, entry := range entries {
+			if _, exists := problems[entry.Problem]; exists {
+				continue
+			}
+			elt := &replayProblem{Problem: new(Problem)}
+			if err := meddler.QueryRow(tx, elt.Problem, `SELECT * FROM problems WHERE unique_id = $1`, entry.Problem); err != nil {
+				if err == sql.ErrNoRows {
+					log.Printf("problem %s is not on this deployment; its requests will be skipped", entry.Problem)
+					problems[entry.Problem] = nil
+					continue
+				}
+				return err
+			}
+			if err := meddler.QueryAll(tx, &elt.Steps, `SELECT * FROM problem_steps WHERE problem_id = $1 ORDER BY step`, elt.Problem.ID); err != nil {
+				return err
+			}
+			elt.Signature = elt.Problem.ComputeSignature(Config().DaycareSecret, elt.Steps)
+			env, err := sealProblemEnvironment(tx, elt.Problem.ID, elt.Signature)
+			if err != nil {
+				return err
+			}
+			elt.Env = env
+			problems[entry.Problem] = elt
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		log.Fatalf("error loading problems: %v", err)
+	}
+
+	last := entries[len(entries)-1].Offset
+	log.Printf("replaying %d request%s spanning %v against %s at %gx speed, taking about %v",
+		len(entries), plural(len(entries)), last.Round(time.Second), daycareHost, speed,
+		time.Duration(float64(last)/speed).Round(time.Second))
+
+	// send each request on schedule
+	var lock sync.Mutex
+	var results []*replayResult
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, entry := range entries {
+		elt := problems[entry.Problem]
+		if elt == nil {
+			continue
+		}
+		if entry.Step < 1 || entry.Step > int64(len(elt.Steps)) {
+			log.Printf("request %d is for step %d of %s, which has %d step%s; skipping it",
+				i+1, entry.Step, entry.Problem, len(elt.Steps), plural(len(elt.Steps)))
+			continue
+		}
+		time.Sleep(time.Until(start.Add(time.Duration(float64(entry.Offset) / speed))))
+
+		wg.Add(1)
+		go func(n int, entry *ReplayEntry, elt *replayProblem) {
+			defer wg.Done()
+			now := time.Now()
+			commit := &Commit{
+				ProblemID: elt.Problem.ID,
+				Step:      entry.Step,
+				Action:    entry.Action,
+				Note:      "replayed for load testing",
+				Files:     entry.Files,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			bundle := &CommitBundle{
+				Problem:          elt.Problem,
+				ProblemSteps:     elt.Steps,
+				ProblemSignature: elt.Signature,
+				Environment:      elt.Env,
+				Commit:           commit,
+			}
+			bundle.CommitSignature = commit.ComputeSignature(Config().DaycareSecret, bundle.SigningSignature(Config().DaycareSecret))
+			span := startTrace("replay request", spanInternal)
+			span.set("problem.unique", elt.Problem.Unique)
+			graded, err := runDaycareBundleOn(daycareHost, replayUserBase+int64(n), bundle, span)
+			span.fail(err)
+			span.finish()
+			result := &replayResult{Latency: time.Since(now), Err: err}
+			if err == nil && graded.Commit.ReportCard != nil {
+				result.Passed = graded.Commit.ReportCard.Passed
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			results = append(results, result)
+			if len(results)%100 == 0 {
+				log.Printf("%d of %d requests finished", len(results), len(entries))
+			}
+		}(i, entry, elt)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	flushSpans()
+
+	// summarize
+	var latencies []time.Duration
+	errors, passed := 0, 0
+	for _, elt := range results {
+		switch {
+		case elt.Err != nil:
+			errors++
+			if errors <= 10 {
+				log.Printf("error: %v", elt.Err)
+			}
+			continue
+		case elt.Passed:
+			passed++
+		}
+		latencies = append(latencies, elt.Latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("requests:   %d sent in %v, %d error%s, %d passed\n",
+		len(results), elapsed.Round(time.Second), errors, plural(errors), passed)
+	if len(latencies) > 0 {
+		percentile := func(p float64) time.Duration {
+			return latencies[int(p*float64(len(latencies)-1))].Round(time.Millisecond)
+		}
+		fmt.Printf("latency:    p50 %v, p90 %v, p99 %v, max %v\n",
+			percentile(0.50), percentile(0.90), percentile(0.99), latencies[len(latencies)-1].Round(time.Millisecond))
+	}
+	if errors > 0 {
+		os.Exit(1)
+	}
+}
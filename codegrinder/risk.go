@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// riskAssignmentRow is an open assignment for a student, with when they last saved work on it.
+type riskAssignmentRow struct {
+	ID          int64     `meddler:"id"`
+	UserID      int64     `meddler:"user_id"`
+	ProblemSet  string    `meddler:"unique_id"`
+	CreatedAt   time.Time `meddler:"created_at,localtime"`
+	LastSavedAt time.Time `meddler:"last_saved_at,localtimez"`
+}
+
+// riskStuckRow is a step a student has failed many times without passing.
+type riskStuckRow struct {
+	AssignmentID int64  `meddler:"assignment_id"`
+	UserID       int64  `meddler:"user_id"`
+	Problem      string `meddler:"unique_id"`
+	Step         int64  `meddler:"step"`
+	Attempts     int64  `meddler:"attempts"`
+}
+
+// riskActivityRow is when a student last saved work anywhere in a course.
+type riskActivityRow struct {
+	UserID      int64     `meddler:"user_id"`
+	LastSavedAt time.Time `meddler:"last_saved_at,localtime"`
+}
+
+// getAtRiskStudents looks for students in a course who show signs of falling behind.
+// An assignment is open if the student has not dropped, has not earned full credit,
+// and it is not past due. Students with the most signals are listed first.
+func getAtRiskStudents(tx *sql.Tx, courseID int64, now time.Time) ([]*AtRiskStudent, error) {
+	open := []*riskAssignmentRow{}
+	if err := meddler.QueryAll(tx, &open, `SELECT assignments.id, assignments.user_id, problem_sets.unique_id, assignments.created_at, `+
+		`(SELECT MAX(created_at) FROM submission_records WHERE assignment_id = assignments.id) AS last_saved_at `+
+		`FROM assignments JOIN problem_sets ON assignments.problem_set_id = problem_sets.id `+
+		`WHERE assignments.course_id = $1 AND NOT assignments.instructor AND assignments.dropped_at IS NULL `+
+		`AND COALESCE(assignments.score, 0) < 1 AND (assignments.due_at IS NULL OR assignments.due_at > $2) `+
+		`ORDER BY assignments.id`, courseID, now); err != nil {
+		return nil, err
+	}
+	stuck := []*riskStuckRow{}
+	if err := meddler.QueryAll(tx, &stuck, `SELECT assignments.id AS assignment_id, assignments.user_id, problems.unique_id, `+
+		`submission_records.step, COUNT(*) AS attempts `+
+		`FROM submission_records JOIN submission_snapshots ON submission_records.id = submission_snapshots.record_id `+
+		`JOIN assignments ON submission_records.assignment_id = assignments.id `+
+		`JOIN problems ON submission_records.problem_id = problems.id `+
+		`WHERE assignments.course_id = $1 AND NOT assignments.instructor AND assignments.dropped_at IS NULL `+
+		`AND submission_snapshots.graded AND NOT submission_snapshots.passed AND submission_records.created_at >= $2 `+
+		`AND NOT EXISTS (SELECT 1 FROM submission_records AS passes `+
+		`JOIN submission_snapshots AS passed ON passes.id = passed.record_id `+
+		`WHERE passes.assignment_id = submission_records.assignment_id AND passes.problem_id = submission_records.problem_id `+
+		`AND passes.step = submission_records.step AND passed.passed) `+
+		`GROUP BY assignments.id, assignments.user_id, problems.unique_id, submission_records.step `+
+		`HAVING COUNT(*) >= $3 `+
+		`ORDER BY assignments.id, problems.unique_id, submission_records.step`,
+		courseID, now.AddDate(0, 0, -RiskWindowDays), RiskFailedAttempts); err != nil {
+		return nil, err
+	}
+	activity := []*riskActivityRow{}
+	if err := meddler.QueryAll(tx, &activity, `SELECT assignments.user_id, MAX(submission_records.created_at) AS last_saved_at `+
+		`FROM submission_records JOIN assignments ON submission_records.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = $1 AND NOT assignments.instructor `+
+		`GROUP BY assignments.user_id`, courseID); err != nil {
+		return nil, err
+	}
+	lastActive := make(map[int64]time.Time)
+	for _, row := range activity {
+		lastActive[row.UserID] = row.LastSavedAt
+	}
+
+	students := make(map[int64]*AtRiskStudent)
+	flag := func(userID int64, signal *RiskSignal) {
+		student := students[userID]
+		if student == nil {
+			student = &AtRiskStudent{UserID: userID, LastActiveAt: lastActive[userID]}
+			students[userID] = student
+		}
+		student.Signals = append(student.Signals, signal)
+	}
+	hasOpen := make(map[int64]bool)
+	for _, row := range open {
+		hasOpen[row.UserID] = true
+		if row.LastSavedAt.IsZero() && now.Sub(row.CreatedAt) >= RiskIdleDays*24*time.Hour {
+			flag(row.UserID, &RiskSignal{Kind: "idle", AssignmentID: row.ID, ProblemSet: row.ProblemSet, Since: row.CreatedAt})
+		}
+	}
+	for _, row := range stuck {
+		flag(row.UserID, &RiskSignal{Kind: "stuck", AssignmentID: row.AssignmentID, Problem: row.Problem, Step: row.Step, Attempts: row.Attempts})
+	}
+	for userID, last := range lastActive {
+		if hasOpen[userID] && now.Sub(last) >= RiskInactiveDays*24*time.Hour {
+			flag(userID, &RiskSignal{Kind: "inactive", Since: last})
+		}
+	}
+
+	list := []*AtRiskStudent{}
+	for userID, student := range students {
+		user := new(User)
+		if err := meddler.Load(tx, "users", user, userID); err != nil {
+			return nil, err
+		}
+		student.Name, student.Email = user.Name, user.Email
+		list = append(list, student)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].Signals) != len(list[j].Signals) {
+			return len(list[i].Signals) > len(list[j].Signals)
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// GetCourseAtRisk handles /v2/courses/:course_id/at_risk requests,
+// returning the students in the course who show signs of falling behind:
+// open assignments they have not started, steps they keep failing, or
+// no work saved in a long time.
+func GetCourseAtRisk(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	students, err := getAtRiskStudents(tx, courseID, time.Now())
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, students)
+}
+
+// PutCourseAtRiskDigest handles /v2/courses/:course_id/at_risk/digest requests,
+// signing the current user up to be emailed the course's at-risk list each week.
+// The digest request is returned.
+func PutCourseAtRiskDigest(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if Config().SMTPServer == "" || Config().RiskDigestDay == "" {
+		loggedHTTPErrorf(w, http.StatusNotImplemented, "this server is not set up to send email digests")
+		return
+	}
+	digest := new(RiskDigest)
+	err = meddler.QueryRow(tx, digest, `SELECT * FROM risk_digests WHERE course_id = $1 AND user_id = $2`, courseID, currentUser.ID)
+	if err == sql.ErrNoRows {
+		digest = &RiskDigest{CourseID: courseID, UserID: currentUser.ID, CreatedAt: time.Now()}
+		err = meddler.Insert(tx, "risk_digests", digest)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, digest)
+}
+
+// DeleteCourseAtRiskDigest handles /v2/courses/:course_id/at_risk/digest requests,
+// stopping the weekly at-risk email to the current user.
+func DeleteCourseAtRiskDigest(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM risk_digests WHERE course_id = $1 AND user_id = $2`, courseID, currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseWeekday finds the day of the week with the given name.
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), name) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week", name)
+}
+
+// sendRiskDigests emails each instructor who asked for it the at-risk list for their course.
+// It runs every day but only sends on the configured day of the week.
+// Courses that are archived, or that have no one at risk, are skipped.
+func sendRiskDigests(db *sql.DB) error {
+	now := time.Now()
+	day, err := parseWeekday(Config().RiskDigestDay)
+	if err != nil {
+		return err
+	}
+	if now.Weekday() != day {
+		return nil
+	}
+
+	return forEachTenant(db, func(tx *sql.Tx, tenant *TenantConfig) error {
+		digests := []*RiskDigest{}
+		if err := meddler.QueryAll(tx, &digests, `SELECT risk_digests.* FROM risk_digests `+
+			`JOIN courses ON risk_digests.course_id = courses.id `+
+			`WHERE NOT courses.archived ORDER BY risk_digests.course_id`); err != nil {
+			return err
+		}
+		lists := make(map[int64][]*AtRiskStudent)
+		for _, digest := range digests {
+			students, seen := lists[digest.CourseID]
+			if !seen {
+				if students, err = getAtRiskStudents(tx, digest.CourseID, now); err != nil {
+					return err
+				}
+				lists[digest.CourseID] = students
+			}
+			if len(students) == 0 {
+				continue
+			}
+			course, instructor := new(Course), new(User)
+			if err := meddler.Load(tx, "courses", course, digest.CourseID); err != nil {
+				return err
+			}
+			if err := meddler.Load(tx, "users", instructor, digest.UserID); err != nil {
+				return err
+			}
+
+			var body strings.Builder
+			fmt.Fprintf(&body, "%d student%s in %s may be falling behind:\n\n", len(students), plural(len(students)), course.Name)
+			for _, student := range students {
+				fmt.Fprintf(&body, "%s <%s>\n", student.Name, student.Email)
+				for _, signal := range student.Signals {
+					fmt.Fprintf(&body, "    %s\n", signal)
+				}
+			}
+			fmt.Fprintf(&body, "\nRun \"grind course at-risk %s\" for the current list, or add --digest off to stop these emails.\n", course.Label)
+			subject := fmt.Sprintf("%s: %d student%s at risk", course.Name, len(students), plural(len(students)))
+			if err := sendMail(instructor.Email, subject, body.String()); err != nil {
+				// one bad address should not keep the others from their digests
+				log.Printf("unable to send at-risk digest for course %d to %s: %v", course.ID, instructor.Email, err)
+				continue
+			}
+			log.Printf("sent at-risk digest for course %d to %s listing %d students", course.ID, instructor.Email, len(students))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// rosterSyncInterval is how often course rosters are compared with the LMS.
+const rosterSyncInterval = 12 * time.Hour
+
+// rosterSyncTimeout limits how long the LMS has to return a roster.
+const rosterSyncTimeout = time.Minute
+
+// rosterResponse is the XML format of a roster returned by the LTI memberships extension.
+type rosterResponse struct {
+	XMLName   xml.Name        `xml:"message_response"`
+	CodeMajor string          `xml:"statusinfo>codemajor"`
+	CodeMinor string          `xml:"statusinfo>codeminor"`
+	Members   []*rosterMember `xml:"members>member"`
+}
+
+type rosterMember struct {
+	UserID string `xml:"user_id"`
+	Roles  string `xml:"roles"`
+	Name   string `xml:"person_name_full"`
+	Email  string `xml:"person_contact_email_primary"`
+}
+
+func (member *rosterMember) isLearner() bool {
+	for _, role := range strings.Split(member.Roles, ",") {
+		if role == "Learner" || strings.HasSuffix(role, "/Learner") {
+			return true
+		}
+	}
+	return false
+}
+
+// rosterStudent is a student with work in a course.
+type rosterStudent struct {
+	UserID  int64  `meddler:"user_id"`
+	Name    string `meddler:"name"`
+	Email   string `meddler:"email"`
+	LtiID   string `meddler:"lti_id"`
+	Dropped bool   `meddler:"dropped"`
+}
+
+// fetchRoster asks the LMS for the current members of a course.
+func fetchRoster(tenant *TenantConfig, course *Course) ([]*rosterMember, error) {
+	v := url.Values{}
+	v.Set("lti_message_type", "basic-lis-readmembershipsforcontext")
+	v.Set("lti_version", "LTI-1p0")
+	v.Set("id", course.RosterID)
+	v.Set("oauth_callback", "about:blank")
+	v.Set("oauth_consumer_key", course.RosterConsumerKey)
+	v.Set("oauth_signature_method", "HMAC-SHA1")
+	v.Set("oauth_timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	v.Set("oauth_version", "1.0")
+	v.Set("oauth_nonce", strconv.FormatInt(time.Now().UnixNano(), 10))
+	v.Set("oauth_signature", computeOAuthSignature("POST", course.RosterURL, v, tenant.LTISecret))
+
+	client := &http.Client{Timeout: rosterSyncTimeout}
+	resp, err := client.PostForm(course.RosterURL, v)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("result status %d (%s) when requesting the roster", resp.StatusCode, resp.Status)
+	}
+
+	roster := new(rosterResponse)
+	if err := xml.NewDecoder(resp.Body).Decode(roster); err != nil {
+		return nil, fmt.Errorf("error decoding roster: %v", err)
+	}
+	if roster.CodeMajor != "Success" {
+		return nil, fmt.Errorf("LMS reported %s (%s) when requesting the roster", roster.CodeMajor, roster.CodeMinor)
+	}
+	return roster.Members, nil
+}
+
+// syncCourseRoster compares the students in a course with its roster in the LMS.
+// Students who are no longer on the roster are marked as dropped, which blocks
+// new work but keeps everything they have done. Students who reappear are reinstated.
+// Students on the roster who have never launched a problem set are reported as missing.
+// The report is saved whether or not the roster could be fetched.
+// The roster is fetched before the course's transaction starts.
+func syncCourseRoster(db *sql.DB, tenant *TenantConfig, course *Course, now time.Time) (*RosterSync, error) {
+	members, fetchErr := fetchRoster(tenant, course)
+
+	var report *RosterSync
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		var err error
+		report, err = applyRoster(tx, course, members, fetchErr, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	course.RosterSyncedAt = now
+
+	switch {
+	case report.Error != "":
+		log.Printf("roster sync for course %d (%s) failed: %s", course.ID, course.Name, report.Error)
+	case report.HasDrift():
+		log.Printf("roster sync for course %d (%s): %d dropped, %d returned, %d never launched",
+			course.ID, course.Name, len(report.Dropped), len(report.Returned), len(report.Missing))
+	}
+	return report, nil
+}
+
+// applyRoster records the result of fetching a course's roster from the LMS,
+// where fetchErr is the reason the roster could not be fetched, if any.
+func applyRoster(tx *sql.Tx, course *Course, members []*rosterMember, fetchErr error, now time.Time) (*RosterSync, error) {
+	report := &RosterSync{
+		CourseID:  course.ID,
+		Dropped:   []*RosterEntry{},
+		Returned:  []*RosterEntry{},
+		Missing:   []*RosterEntry{},
+		CreatedAt: now,
+	}
+
+	students := []*rosterStudent{}
+	if err := meddler.QueryAll(tx, &students, `SELECT users.id AS user_id, users.name, users.email, users.lti_id, `+
+		`bool_and(assignments.dropped_at IS NOT NULL) AS dropped `+
+		`FROM users JOIN assignments ON users.id = assignments.user_id `+
+		`WHERE assignments.course_id = $1 AND NOT assignments.instructor `+
+		`GROUP BY users.id ORDER BY users.name, users.id`,
+		course.ID); err != nil {
+		return nil, err
+	}
+
+	if fetchErr == nil && len(students) > 0 && len(members) == 0 {
+		// never drop a whole course because of a glitch in the LMS
+		fetchErr = fmt.Errorf("LMS returned an empty roster")
+	}
+	if fetchErr != nil {
+		report.Error = fetchErr.Error()
+	} else {
+		onRoster := make(map[string]*rosterMember)
+		for _, member := range members {
+			if member.isLearner() {
+				onRoster[member.UserID] = member
+				report.Students++
+			}
+		}
+
+		known := make(map[string]bool)
+		for _, student := range students {
+			known[student.LtiID] = true
+			entry := &RosterEntry{UserID: student.UserID, Name: student.Name, Email: student.Email}
+			switch _, present := onRoster[student.LtiID]; {
+			case !present && !student.Dropped:
+				if _, err := tx.Exec(`UPDATE assignments SET dropped_at = $1, updated_at = $1 `+
+					`WHERE course_id = $2 AND user_id = $3 AND NOT instructor AND dropped_at IS NULL`,
+					now, course.ID, student.UserID); err != nil {
+					return nil, err
+				}
+				report.Dropped = append(report.Dropped, entry)
+			case present && student.Dropped:
+				if _, err := tx.Exec(`UPDATE assignments SET dropped_at = NULL, updated_at = $1 `+
+					`WHERE course_id = $2 AND user_id = $3 AND NOT instructor`,
+					now, course.ID, student.UserID); err != nil {
+					return nil, err
+				}
+				report.Returned = append(report.Returned, entry)
+			}
+		}
+		for _, member := range members {
+			if member.isLearner() && !known[member.UserID] {
+				report.Missing = append(report.Missing, &RosterEntry{Name: member.Name, Email: member.Email})
+			}
+		}
+	}
+
+	if err := meddler.Insert(tx, "roster_syncs", report); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE courses SET roster_synced_at = $1 WHERE id = $2`, now, course.ID); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// rosterSyncLoop compares every active course with its LMS roster twice a day.
+// Each course is synced in its own transaction, so a slow LMS does not hold one open.
+func rosterSyncLoop(db *sql.DB) {
+	for {
+		now := time.Now()
+		for _, tenant := range tenants() {
+			courses := []*Course{}
+			err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+				return meddler.QueryAll(tx, &courses, `SELECT * FROM courses WHERE roster_url IS NOT NULL AND NOT archived ORDER BY id`)
+			})
+			if err != nil {
+				log.Printf("error syncing course rosters for tenant %s: %v", tenant.Hostname, err)
+				continue
+			}
+			for _, course := range courses {
+				if !course.IsActive(now) {
+					continue
+				}
+				if _, err := syncCourseRoster(db, tenant, course, now); err != nil {
+					log.Printf("error syncing roster for course %d for tenant %s: %v", course.ID, tenant.Hostname, err)
+				}
+			}
+		}
+		time.Sleep(rosterSyncInterval)
+	}
+}
+
+// GetCourseRosterSyncs handles /v2/courses/:course_id/roster_syncs requests,
+// returning the most recent roster sync reports for a course, newest first.
+func GetCourseRosterSyncs(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	reports := []*RosterSync{}
+	if err := meddler.QueryAll(tx, &reports, `SELECT * FROM roster_syncs WHERE course_id = $1 ORDER BY created_at DESC, id DESC LIMIT 10`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, reports)
+}
+
+// PostCourseRosterSync handles /v2/courses/:course_id/roster_syncs requests,
+// comparing the course with its roster in the LMS right away.
+// The new roster sync report is returned.
+func PostCourseRosterSync(w http.ResponseWriter, db *sql.DB, tenant *TenantConfig, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	course := new(Course)
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		return meddler.Load(tx, "courses", course, courseID)
+	})
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if course.RosterURL == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the LMS has not offered a roster service for this course; "+
+			"it is recorded the next time someone launches a problem set")
+		return
+	}
+
+	report, err := syncCourseRoster(db, tenant, course, time.Now())
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
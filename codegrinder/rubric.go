@@ -0,0 +1,405 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getRubric returns the rubric for a problem set in a course, or nil if it has none.
+func getRubric(tx *sql.Tx, courseID, problemSetID int64) (*Rubric, error) {
+	rubric := new(Rubric)
+	err := meddler.QueryRow(tx, rubric, `SELECT * FROM rubrics WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rubric, nil
+}
+
+// getRubricGrade returns a student's rubric grade, or nil if they have not been graded.
+func getRubricGrade(tx *sql.Tx, assignmentID int64) (*RubricGrade, error) {
+	grade := new(RubricGrade)
+	err := meddler.QueryRow(tx, grade, `SELECT * FROM rubric_grades WHERE assignment_id = $1`, assignmentID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return grade, nil
+}
+
+// combineManualGrades merges the autograded score for an assignment with any
+// grades given by hand. Until a student has been graded against the rubric,
+// the autograded score stands alone, but a required check-off counts as
+// missing until the student has been checked off. A grade reconciled after
+// second marking replaces all of these.
+func combineManualGrades(tx *sql.Tx, assignment *Assignment, auto float64) (float64, error) {
+	score := auto
+	rubric, err := getRubric(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		return 0.0, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if rubric != nil {
+		grade, err := getRubricGrade(tx, assignment.ID)
+		if err != nil {
+			return 0.0, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+		}
+		if grade != nil {
+			score = rubric.Combine(score, grade.Score)
+		}
+	}
+
+	policy, err := getCheckoffPolicy(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		return 0.0, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if policy != nil {
+		checkoff, err := getCheckoff(tx, assignment.ID)
+		if err != nil {
+			return 0.0, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+		}
+		score = policy.Combine(score, checkoff != nil)
+	}
+
+	mark, err := getReconciledMark(tx, assignment.ID)
+	if err != nil {
+		return 0.0, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if mark != nil {
+		score = mark.ReconciledScore
+	}
+	return score, nil
+}
+
+// rescoreAssignment recomputes the score for an assignment after a grade given by hand
+// changes, saving it and posting it to the LMS if it is different.
+func rescoreAssignment(tx *sql.Tx, tenant *TenantConfig, asst *Assignment, now time.Time) error {
+	if asst.RawScores == nil {
+		asst.RawScores = map[string][]float64{}
+	}
+	score, err := computeAssignmentScore(tx, asst)
+	if err != nil {
+		return err
+	}
+	if score == asst.Score {
+		return nil
+	}
+	asst.Score = score
+	asst.UpdatedAt = now
+	if err := meddler.Save(tx, "assignments", asst); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	student := new(User)
+	if err := meddler.Load(tx, "users", student, asst.UserID); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if err := postGrade(tx, tenant, asst, student, nil); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "error posting grade back to LMS: %v", err)
+	}
+	return nil
+}
+
+// rescoreProblemSet recomputes the score of every student on a problem set in a course
+// after the way grades given by hand are combined changes.
+func rescoreProblemSet(tx *sql.Tx, tenant *TenantConfig, courseID, problemSetID int64, now time.Time) error {
+	assignments := []*Assignment{}
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE course_id = $1 AND problem_set_id = $2 AND NOT instructor ORDER BY id`,
+		courseID, problemSetID); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	for _, asst := range assignments {
+		if err := rescoreAssignment(tx, tenant, asst, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCourseProblemSetRubric handles /v2/courses/:course_id/problem_sets/:problem_set_id/rubric requests,
+// returning the rubric for the problem set.
+func GetCourseProblemSetRubric(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	rubric, err := getRubric(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if rubric == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d has no rubric in course %d", problemSetID, courseID)
+		return
+	}
+	render.JSON(http.StatusOK, rubric)
+}
+
+// PutCourseProblemSetRubric handles /v2/courses/:course_id/problem_sets/:problem_set_id/rubric requests,
+// setting the rubric for grading the problem set by hand and the formula for combining it with
+// the autograded score. Students already graded are graded again against the new rubric, so it
+// must still have the criteria and levels they were given. The rubric is returned.
+func PutCourseProblemSetRubric(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, rubric Rubric, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+	if err := rubric.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// grade everyone already graded again with the new rubric
+	grades := []*RubricGrade{}
+	if err := meddler.QueryAll(tx, &grades, `SELECT rubric_grades.* FROM rubric_grades JOIN assignments ON rubric_grades.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2`, courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, grade := range grades {
+		if err := rubric.Grade(grade); err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "assignment %d was already graded and the new rubric does not fit: %v", grade.AssignmentID, err)
+			return
+		}
+		if _, err := tx.Exec(`UPDATE rubric_grades SET points = $1, score = $2 WHERE assignment_id = $3`, grade.Points, grade.Score, grade.AssignmentID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+
+	old, err := getRubric(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if old != nil {
+		if _, err := tx.Exec(`DELETE FROM rubrics WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		rubric.CreatedAt = old.CreatedAt
+	} else {
+		rubric.CreatedAt = now
+	}
+	rubric.CourseID = courseID
+	rubric.ProblemSetID = problemSetID
+	rubric.UpdatedAt = now
+	if err := meddler.Insert(tx, "rubrics", &rubric); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := rescoreProblemSet(tx, tenant, courseID, problemSetID, now); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	log.Printf("rubric set for problem set %d in course %d with %d criteria", problemSetID, courseID, len(rubric.Criteria))
+	render.JSON(http.StatusOK, &rubric)
+}
+
+// DeleteCourseProblemSetRubric handles /v2/courses/:course_id/problem_sets/:problem_set_id/rubric requests,
+// removing the rubric so the problem set is graded by the autograder alone.
+// Grades already given are kept in case the rubric is restored.
+func DeleteCourseProblemSetRubric(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM rubrics WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := rescoreProblemSet(tx, tenant, courseID, problemSetID, time.Now()); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCourseProblemSetRubricGrades handles /v2/courses/:course_id/problem_sets/:problem_set_id/rubric_grades requests,
+// returning the rubric grade of every student graded so far. Students are named by
+// pseudonym, without assignment IDs, while grading is anonymous.
+func GetCourseProblemSetRubricGrades(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	grades := []*RubricGrade{}
+	if err := meddler.QueryAll(tx, &grades, `SELECT rubric_grades.* FROM rubric_grades JOIN assignments ON rubric_grades.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 ORDER BY rubric_grades.assignment_id`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	anon, err := getAnonymousGrading(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, grade := range grades {
+		asst := new(Assignment)
+		if err := meddler.Load(tx, "assignments", asst, grade.AssignmentID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if anon != nil && anon.IsAnonymous() {
+			grade.Student = anon.Pseudonym(asst.UserID)
+			grade.AssignmentID = 0
+		} else {
+			user := new(User)
+			if err := meddler.Load(tx, "users", user, asst.UserID); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			grade.Student = user.Name
+		}
+	}
+
+	render.JSON(http.StatusOK, grades)
+}
+
+// PutCourseProblemSetRubricGrade handles /v2/courses/:course_id/problem_sets/:problem_set_id/rubric_grades/:user_id requests,
+// grading a student against the rubric. A level must be chosen for every criterion. The student's
+// score on the problem set is updated and posted to the LMS. The student is named by pseudonym
+// in place of user ID while grading is anonymous. The grade is returned.
+func PutCourseProblemSetRubricGrade(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, currentUser *User, grade RubricGrade, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	rubric, err := getRubric(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if rubric == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d has no rubric in course %d", problemSetID, courseID)
+		return
+	}
+	asst, anon, err := getStudentAssignment(tx, courseID, problemSetID, params["user_id"])
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	if err := rubric.Grade(&grade); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	old, err := getRubricGrade(tx, asst.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	grade.AssignmentID = asst.ID
+	grade.Student = ""
+	grade.GraderID = currentUser.ID
+	grade.UpdatedAt = now
+	if old != nil {
+		grade.CreatedAt = old.CreatedAt
+		if _, err := tx.Exec(`DELETE FROM rubric_grades WHERE assignment_id = $1`, asst.ID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	} else {
+		grade.CreatedAt = now
+	}
+	if err := meddler.Insert(tx, "rubric_grades", &grade); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := rescoreAssignment(tx, tenant, asst, now); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	log.Printf("assignment %d graded %.1f/%.1f on the rubric by user %d (%s)", asst.ID, grade.Points, rubric.MaxPoints(), currentUser.ID, currentUser.Name)
+	if anon != nil {
+		grade.AssignmentID = 0
+		grade.Student = anon.Pseudonym(asst.UserID)
+	}
+	render.JSON(http.StatusOK, &grade)
+}
+
+// GetAssignmentRubric handles /v2/assignments/:assignment_id/rubric requests,
+// returning the rubric for the assignment along with the student's grade and feedback
+// once they have been graded.
+func GetAssignmentRubric(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	assignment := new(Assignment)
+	if currentUser.Admin {
+		err = meddler.Load(tx, "assignments", assignment, assignmentID)
+	} else {
+		err = meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	rubric, err := getRubric(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if rubric == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "assignment %d is not graded with a rubric", assignmentID)
+		return
+	}
+	grade, err := getRubricGrade(tx, assignment.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if grade != nil {
+		grade.GraderID = 0
+	}
+	render.JSON(http.StatusOK, &RubricFeedback{Rubric: rubric, Grade: grade})
+}
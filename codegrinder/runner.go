@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+const (
+	// runnerWaitTimeout is how long a grading run waits for a suitable runner to be free.
+	runnerWaitTimeout = time.Minute
+
+	// runnerLeaseSlack is how long a lease may sit idle beyond the problem type's MaxClock
+	// before the daycare gives up on the grading run holding it.
+	runnerLeaseSlack = 5 * time.Minute
+
+	// runnerHelloTimeout is how long a new connection has to say hello.
+	runnerHelloTimeout = 10 * time.Second
+)
+
+func init() {
+	commands["runner-token"] = &serverCommand{Short: "print the token a runner agent with the given name connects with", Run: CommandRunnerToken}
+}
+
+// runnerToken derives the token for the runner agent with the given name from
+// Config.RunnerSecret. Each agent gets its own token, so a token copied from one
+// department's machine cannot be used to connect as any other runner.
+func runnerToken(name string) string {
+	mac := hmac.New(sha256.New, []byte(Config().RunnerSecret))
+	mac.Write([]byte("codegrinder runner\n" + name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CommandRunnerToken handles "codegrinder runner-token NAME",
+// printing the token to give to the runner agent called NAME.
+func CommandRunnerToken(args []string) {
+	fs := flag.NewFlagSet("runner-token", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: codegrinder runner-token NAME")
+	}
+	if Config().RunnerSecret == "" {
+		log.Fatalf("RunnerSecret must be set in the config file before runners can connect")
+	}
+	fmt.Fprintln(os.Stdout, runnerToken(fs.Arg(0)))
+}
+
+// runnerConn is a runner agent connected to this daycare.
+type runnerConn struct {
+	info      RunnerInfo
+	lease     int // counts leases, so a grading run can tell if its lease was lost
+	socket    *websocket.Conn
+	writeLock sync.Mutex
+	messages  chan *RunnerMessage // from the agent, closed when it disconnects
+}
+
+func (runner *runnerConn) send(msg *RunnerMessage) error {
+	runner.writeLock.Lock()
+	defer runner.writeLock.Unlock()
+	return runner.socket.WriteJSON(msg)
+}
+
+// runnerSuits reports whether a runner can grade a problem type. It must be on the
+// problem type's platform, if it names one, and offer every capability it requires.
+func runnerSuits(info *RunnerInfo, problemType *ProblemType) bool {
+	if problemType.Platform != "" && info.Platform != problemType.Platform {
+		return false
+	}
+	for _, need := range problemType.Requires {
+		found := false
+		for _, capability := range info.Capabilities {
+			if capability == need {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// runnerNeeds describes what a problem type asks of a runner, e.g., "windows" or "linux+fpga".
+func runnerNeeds(problemType *ProblemType) string {
+	needs := problemType.Platform
+	if needs == "" {
+		needs = "any"
+	}
+	for _, capability := range problemType.Requires {
+		needs += "+" + capability
+	}
+	return needs
+}
+
+// runnerPool holds the connected runner agents. Free runners are handed out
+// least recently used first, so work is spread across the machines that can do it.
+type runnerPool struct {
+	sync.Mutex
+	runners []*runnerConn
+	changed chan struct{} // closed and replaced whenever a runner comes, goes, or is freed
+}
+
+var runners = &runnerPool{changed: make(chan struct{})}
+
+// notify wakes up grading runs waiting for a runner. The caller must hold the lock.
+func (pool *runnerPool) notify() {
+	close(pool.changed)
+	pool.changed = make(chan struct{})
+}
+
+func (pool *runnerPool) add(runner *runnerConn) {
+	pool.Lock()
+	defer pool.Unlock()
+	pool.runners = append(pool.runners, runner)
+	pool.notify()
+}
+
+func (pool *runnerPool) remove(runner *runnerConn) {
+	pool.Lock()
+	defer pool.Unlock()
+	for i, elt := range pool.runners {
+		if elt == runner {
+			pool.runners = append(pool.runners[:i], pool.runners[i+1:]...)
+			break
+		}
+	}
+	pool.notify()
+}
+
+// claim waits for a free runner that suits the problem type and leases it to the named
+// grading run for the given time. A runner whose lease has expired counts as free.
+// It returns the runner and the lease number to renew and release it with.
+func (pool *runnerPool) claim(problemType *ProblemType, name string, length time.Duration) (*runnerConn, int, error) {
+	deadline := time.After(runnerWaitTimeout)
+	for {
+		pool.Lock()
+		now := time.Now()
+		for _, runner := range pool.runners {
+			if !runnerSuits(&runner.info, problemType) {
+				continue
+			}
+			if runner.info.LeasedTo != "" {
+				if now.Before(runner.info.LeaseExpires) {
+					continue
+				}
+				log.Printf("lease on runner %s by %s expired", runner.info.Name, runner.info.LeasedTo)
+			}
+			runner.lease++
+			runner.info.LeasedTo = name
+			runner.info.LeaseExpires = now.Add(length)
+			pool.Unlock()
+			return runner, runner.lease, nil
+		}
+		changed := pool.changed
+		pool.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, 0, fmt.Errorf("no %s runner was free within %v", runnerNeeds(problemType), runnerWaitTimeout)
+		}
+	}
+}
+
+// renew extends a lease, reporting false if it has already expired or been released.
+func (pool *runnerPool) renew(runner *runnerConn, lease int, length time.Duration) bool {
+	pool.Lock()
+	defer pool.Unlock()
+	now := time.Now()
+	if runner.lease != lease || runner.info.LeasedTo == "" || now.After(runner.info.LeaseExpires) {
+		return false
+	}
+	runner.info.LeaseExpires = now.Add(length)
+	return true
+}
+
+// release ends a lease and moves the runner to the back of the line.
+func (pool *runnerPool) release(runner *runnerConn, lease int) {
+	pool.Lock()
+	defer pool.Unlock()
+	if runner.lease != lease {
+		return
+	}
+	runner.info.LeasedTo = ""
+	runner.info.LeaseExpires = time.Time{}
+	for i, elt := range pool.runners {
+		if elt == runner {
+			pool.runners = append(append(pool.runners[:i], pool.runners[i+1:]...), runner)
+			break
+		}
+	}
+	pool.notify()
+}
+
+func (pool *runnerPool) list() []RunnerInfo {
+	pool.Lock()
+	defer pool.Unlock()
+	var list []RunnerInfo
+	for _, runner := range pool.runners {
+		list = append(list, runner.info)
+	}
+	return list
+}
+
+// SocketRunner handles /v2/runners/connect requests on the daycare.
+// A runner agent connects here, presenting the token for its name from
+// "codegrinder runner-token", and then says hello with its platform and
+// capabilities before waiting for grading work.
+// The name parameter identifies the agent.
+func SocketRunner(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	if name == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "runner must give its name")
+		return
+	}
+	if !hmac.Equal([]byte(r.Header.Get(RunnerTokenHeader)), []byte(runnerToken(name))) {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "runner %s did not give the correct token", name)
+		return
+	}
+	socket, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "websocket error: %v", err)
+		return
+	}
+	defer socket.Close()
+
+	// the agent must say what it can do before it gets any work
+	hello := new(RunnerMessage)
+	socket.SetReadDeadline(time.Now().Add(runnerHelloTimeout))
+	if err := socket.ReadJSON(hello); err != nil {
+		log.Printf("runner %s did not say hello: %v", name, err)
+		return
+	}
+	socket.SetReadDeadline(time.Time{})
+	if hello.Op != RunnerHello || hello.Platform == "" {
+		log.Printf("runner %s must start with hello giving its platform", name)
+		socket.WriteJSON(&RunnerMessage{Op: RunnerError, Error: "expected hello with a platform"})
+		return
+	}
+	info := RunnerInfo{Name: name, Platform: strings.ToLower(hello.Platform), Address: r.RemoteAddr}
+	for _, capability := range hello.Capabilities {
+		info.Capabilities = append(info.Capabilities, strings.ToLower(capability))
+	}
+	sort.Strings(info.Capabilities)
+
+	runner := &runnerConn{
+		info:     info,
+		socket:   socket,
+		messages: make(chan *RunnerMessage, 64),
+	}
+	desc := info.Platform
+	if len(info.Capabilities) > 0 {
+		desc += " (" + strings.Join(info.Capabilities, ", ") + ")"
+	}
+	log.Printf("%s runner %s connected from %s", desc, name, r.RemoteAddr)
+	runners.add(runner)
+	defer runners.remove(runner)
+	defer close(runner.messages)
+
+	for {
+		msg := new(RunnerMessage)
+		if err := socket.ReadJSON(msg); err != nil {
+			log.Printf("%s runner %s disconnected: %v", info.Platform, name, err)
+			return
+		}
+		runner.messages <- msg
+	}
+}
+
+// probeRunners checks that every problem type graded by runners has one connected that suits it.
+func probeRunners() (string, error) {
+	list := runners.list()
+	counts := make(map[string]int)
+	for _, problemType := range problemTypes() {
+		if problemType.Platform == "" && len(problemType.Requires) == 0 {
+			continue
+		}
+		needs := runnerNeeds(problemType)
+		if _, exists := counts[needs]; exists {
+			continue
+		}
+		counts[needs] = 0
+		for i := range list {
+			if runnerSuits(&list[i], problemType) {
+				counts[needs]++
+			}
+		}
+	}
+	var kinds, missing []string
+	for needs, count := range counts {
+		kinds = append(kinds, fmt.Sprintf("%d %s runner%s", count, needs, plural(count)))
+		if count == 0 {
+			missing = append(missing, needs)
+		}
+	}
+	sort.Strings(kinds)
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no runner connected for %s", strings.Join(missing, ", "))
+	}
+	if len(kinds) == 0 {
+		return fmt.Sprintf("%d runner%s connected", len(list), plural(len(list))), nil
+	}
+	return strings.Join(kinds, ", "), nil
+}
+
+// runnerSandbox is a working directory on a runner agent, held under a lease.
+// There is no container, so the agent enforces the problem type's MaxClock itself.
+type runnerSandbox struct {
+	runner *runnerConn
+	lease  int
+	length time.Duration
+	name   string
+}
+
+func newRunnerSandbox(problemType *ProblemType, env []string, name string) (*runnerSandbox, error) {
+	length := time.Duration(problemType.MaxClock)*time.Second + runnerLeaseSlack
+	runner, lease, err := runners.claim(problemType, name, length)
+	if err != nil {
+		return nil, err
+	}
+	box := &runnerSandbox{runner: runner, lease: lease, length: length, name: name}
+	start := &RunnerMessage{Op: RunnerStart, Env: env, Timeout: problemType.MaxClock}
+	if err := box.request(start); err != nil {
+		runners.release(runner, lease)
+		return nil, err
+	}
+	return box, nil
+}
+
+// renew extends the lease, failing if it was lost.
+func (box *runnerSandbox) renew() error {
+	if !runners.renew(box.runner, box.lease, box.length) {
+		return fmt.Errorf("lease on runner %s expired", box.runner.info.Name)
+	}
+	return nil
+}
+
+// send renews the lease and sends a message to the agent.
+func (box *runnerSandbox) send(msg *RunnerMessage) error {
+	if err := box.renew(); err != nil {
+		return err
+	}
+	return box.runner.send(msg)
+}
+
+// next waits for the next message from the agent and renews the lease.
+func (box *runnerSandbox) next() (*RunnerMessage, error) {
+	msg, ok := <-box.runner.messages
+	if !ok {
+		return nil, fmt.Errorf("runner %s disconnected", box.runner.info.Name)
+	}
+	if err := box.renew(); err != nil {
+		return nil, err
+	}
+	if msg.Op == RunnerError {
+		return nil, fmt.Errorf("runner %s: %s", box.runner.info.Name, msg.Error)
+	}
+	return msg, nil
+}
+
+// request sends a message and waits for the agent to say it is done.
+func (box *runnerSandbox) request(msg *RunnerMessage) error {
+	if err := box.send(msg); err != nil {
+		return err
+	}
+	for {
+		reply, err := box.next()
+		if err != nil {
+			return err
+		}
+		if reply.Op == RunnerReady {
+			return nil
+		}
+	}
+}
+
+func (box *runnerSandbox) ID() string {
+	return box.runner.info.Name + "/" + box.name
+}
+
+func (box *runnerSandbox) Exec(cmd []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if err := box.send(&RunnerMessage{Op: RunnerExec, Cmd: cmd}); err != nil {
+		return -1, err
+	}
+
+	// feed stdin until it runs out or the command finishes
+	done := make(chan struct{})
+	defer close(done)
+	if stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := stdin.Read(buf)
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if n > 0 {
+					if box.send(&RunnerMessage{Op: RunnerStdin, Data: string(buf[:n])}) != nil {
+						return
+					}
+				}
+				if err != nil {
+					box.send(&RunnerMessage{Op: RunnerEOF})
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		msg, err := box.next()
+		if err != nil {
+			return -1, err
+		}
+		switch msg.Op {
+		case RunnerStdout:
+			stdout.Write([]byte(msg.Data))
+		case RunnerStderr:
+			stderr.Write([]byte(msg.Data))
+		case RunnerExit:
+			return msg.Status, nil
+		}
+	}
+}
+
+// PutFiles writes files directly, since the agent's platform may not have tar.
+func (box *runnerSandbox) PutFiles(files map[string]string) error {
+	return box.request(&RunnerMessage{Op: RunnerPut, Files: files})
+}
+
+// GetFiles reads files directly, leaving out any that do not exist.
+func (box *runnerSandbox) GetFiles(names []string) (map[string]string, error) {
+	if err := box.send(&RunnerMessage{Op: RunnerGet, Names: names}); err != nil {
+		return nil, err
+	}
+	for {
+		msg, err := box.next()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Op == RunnerFiles {
+			return msg.Files, nil
+		}
+	}
+}
+
+func (box *runnerSandbox) Kill() error {
+	return box.send(&RunnerMessage{Op: RunnerKill})
+}
+
+// Remove deletes the working directory and ends the lease,
+// handing the runner to the next grading run.
+func (box *runnerSandbox) Remove() error {
+	defer runners.release(box.runner, box.lease)
+	return box.request(&RunnerMessage{Op: RunnerRemove})
+}
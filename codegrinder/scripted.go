@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/russross/codegrinder/sdk"
+	. "github.com/russross/codegrinder/types"
+)
+
+// problemTypeDefinition is the file format of a scripted problem type.
+// Scripted problem types are defined by JSON files instead of Go code.
+// Each action runs a command shipped in the problem type's image, with the
+// student files in the working directory and any arguments from the client
+// appended. The conventions the command must follow are in the sdk package.
+//
+// Instead of a single command, an action can give a pipeline of stages such
+// as setup, build, test, style, and teardown. Each stage is a command that
+// follows the same conventions, and its results are reported in a section
+// of their own. A failing stage stops the pipeline, fails the grade but lets
+// later stages run, or only warns, as its onFailure policy says. Stages marked
+// always run even after the pipeline stops, for cleaning up.
+//
+// An action marked reference also gets the reference solution to the step in
+// sdk.ReferenceDir and the step's own files in sdk.SupportDir, for graders that
+// compare the student's program against it. An action marked flag gets the
+// student's flag for a capture-the-flag problem in sdk.FlagFile, and one marked
+// secrets gets the problem's secret variables in sdk.SecretsFile. Cache lists build cache directories shared between runs,
+// which only runs confirming a solution may write to. Dependencies names the
+// files in which a problem lists packages to install ahead of time and the
+// command that installs them. SwitchUser lets setuid programs in the image change
+// accounts, as codegrinder-ctf does to run a capture-the-flag target apart from
+// the student's code.
+type problemTypeDefinition struct {
+	ProblemType
+	Actions map[string]*actionDefinition `json:"actions"`
+}
+
+type actionDefinition struct {
+	ProblemTypeAction
+	Command     []string           `json:"command"`
+	Interactive bool               `json:"interactive"`
+	Pipeline    []*stageDefinition `json:"pipeline"`
+}
+
+type stageDefinition struct {
+	Name      string   `json:"name"`
+	Command   []string `json:"command"`
+	OnFailure string   `json:"onFailure"`
+	Always    bool     `json:"always"`
+}
+
+// builtinProblemTypes are the problem types compiled into the server,
+// registered by init functions and kept so problem types can be reloaded from scratch.
+var builtinProblemTypes = make(map[string]*ProblemType)
+
+// loadProblemTypes returns the built-in problem types along with those defined
+// by *.json files in a directory. A definition with the same name as a built-in
+// problem type replaces it.
+func loadProblemTypes(dir string) (map[string]*ProblemType, error) {
+	loaded := make(map[string]*ProblemType)
+	for name, problemType := range builtinProblemTypes {
+		loaded[name] = problemType
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		def := new(problemTypeDefinition)
+		if err := json.Unmarshal(raw, def); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		problemType, err := def.compile()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if _, exists := builtinProblemTypes[problemType.Name]; exists {
+			log.Printf("problem type %s from %s replaces the built-in definition", problemType.Name, path)
+		}
+		loaded[problemType.Name] = problemType
+		log.Printf("loaded problem type %s with %d action%s from %s",
+			problemType.Name, len(problemType.Actions), plural(len(problemType.Actions)), path)
+	}
+	return loaded, nil
+}
+
+// compile checks a definition and turns it into a problem type with handlers.
+func (def *problemTypeDefinition) compile() (*ProblemType, error) {
+	if def.Name == "" || def.Image == "" {
+		return nil, fmt.Errorf("a problem type must have a name and an image")
+	}
+	def.Platform = strings.ToLower(def.Platform)
+	for i, capability := range def.Requires {
+		def.Requires[i] = strings.ToLower(capability)
+	}
+	if def.SwitchUser && (def.Platform != "" || len(def.Requires) > 0) {
+		return nil, fmt.Errorf("problem type %s cannot switch accounts on a runner agent", def.Name)
+	}
+	if deps := def.Dependencies; deps != nil && (len(deps.Files) == 0 || len(deps.Install) == 0) {
+		return nil, fmt.Errorf("the dependencies of problem type %s need files and an install command", def.Name)
+	}
+	for _, dir := range def.Cache {
+		if !path.IsAbs(dir) || strings.Contains(dir, ":") {
+			return nil, fmt.Errorf("cache directory %q of problem type %s must be an absolute path", dir, def.Name)
+		}
+	}
+	if _, exists := def.Actions["grade"]; !exists {
+		return nil, fmt.Errorf("problem type %s must define a grade action", def.Name)
+	}
+	if _, exists := def.Actions["confirm"]; !exists {
+		// confirming a new problem runs the grader against the solution
+		grade := def.Actions["grade"]
+		def.Actions["confirm"] = &actionDefinition{
+			ProblemTypeAction: ProblemTypeAction{Reference: grade.Reference, Flag: grade.Flag, Secrets: grade.Secrets},
+			Command:           grade.Command,
+			Pipeline:          grade.Pipeline,
+		}
+	}
+
+	problemType := def.ProblemType
+	problemType.Actions = make(map[string]*ProblemTypeAction)
+	for name, action := range def.Actions {
+		elt := action.ProblemTypeAction
+		elt.Action = name
+		switch {
+		case len(action.Command) > 0 && len(action.Pipeline) > 0:
+			return nil, fmt.Errorf("action %q of problem type %s has both a command and a pipeline", name, def.Name)
+		case len(action.Pipeline) > 0:
+			if action.Interactive {
+				return nil, fmt.Errorf("action %q of problem type %s cannot be both interactive and a pipeline", name, def.Name)
+			}
+			if err := action.checkPipeline(); err != nil {
+				return nil, fmt.Errorf("action %q of problem type %s: %v", name, def.Name, err)
+			}
+			elt.Handler = nannyHandler(pipelineHandler(action))
+		case len(action.Command) > 0:
+			elt.Handler = nannyHandler(scriptedHandler(action))
+		case name != "":
+			return nil, fmt.Errorf("action %q of problem type %s has no command", name, def.Name)
+		}
+		problemType.Actions[name] = &elt
+	}
+	return &problemType, nil
+}
+
+// checkPipeline checks the stages of an action, filling in the default failure policy.
+func (action *actionDefinition) checkPipeline() error {
+	names := make(map[string]bool)
+	for i, stage := range action.Pipeline {
+		if stage.Name == "" {
+			return fmt.Errorf("stage %d has no name", i+1)
+		}
+		if names[stage.Name] {
+			return fmt.Errorf("stage %s appears more than once", stage.Name)
+		}
+		names[stage.Name] = true
+		if len(stage.Command) == 0 {
+			return fmt.Errorf("stage %s has no command", stage.Name)
+		}
+		policy, err := ValidStagePolicy(stage.OnFailure)
+		if err != nil {
+			return fmt.Errorf("stage %s: %v", stage.Name, err)
+		}
+		stage.OnFailure = policy
+	}
+	return nil
+}
+
+// putScriptedFiles puts the student files in the container along with the options.
+func putScriptedFiles(n *Nanny, options []string, files map[string]string) error {
+	withOptions := make(map[string]string)
+	for name, contents := range files {
+		withOptions[name] = contents
+	}
+	if len(options) > 0 {
+		withOptions[sdk.OptionsFile] = strings.Join(options, "\n") + "\n"
+	}
+	return n.PutFiles(withOptions)
+}
+
+// scriptedHandler returns a handler that runs the command of an action.
+func scriptedHandler(action *actionDefinition) nannyHandler {
+	return func(n *Nanny, args []string, options []string, files map[string]string) {
+		log.Printf("scripted %s", strings.Join(action.Command, " "))
+
+		if err := putScriptedFiles(n, options, files); err != nil {
+			n.ReportCard.LogAndFailf("PutFiles error: %v", err)
+			return
+		}
+
+		// run the command
+		cmd := append(append([]string{}, action.Command...), args...)
+		var status int
+		var err error
+		if action.Interactive {
+			status, err = n.ExecInteractive(cmd)
+		} else {
+			_, _, _, status, err = n.ExecNonInteractive(cmd)
+		}
+		if err != nil {
+			n.ReportCard.LogAndFailf("exec error: %v", err)
+			return
+		}
+
+		// use the report card from the script if there is one
+		report, err := n.GetFiles([]string{sdk.ReportFile})
+		if err != nil || report[sdk.ReportFile] == "" {
+			if status != 0 {
+				n.ReportCard.Failf("exit status %d", status)
+			}
+			return
+		}
+		card := NewReportCard()
+		if err := json.Unmarshal([]byte(report[sdk.ReportFile]), card); err != nil {
+			n.ReportCard.LogAndFailf("error parsing report from %s: %v", action.Command[0], err)
+			return
+		}
+		card.Duration = n.ReportCard.Duration
+		n.ReportCard = card
+	}
+}
+
+// pipelineHandler returns a handler that runs the stages of an action in order,
+// collecting the report card of each stage into a section of the overall report card.
+// Arguments from the client are appended to the command of every stage.
+func pipelineHandler(action *actionDefinition) nannyHandler {
+	return func(n *Nanny, args []string, options []string, files map[string]string) {
+		log.Printf("pipeline with %d stage%s", len(action.Pipeline), plural(len(action.Pipeline)))
+
+		if err := putScriptedFiles(n, options, files); err != nil {
+			n.ReportCard.LogAndFailf("PutFiles error: %v", err)
+			return
+		}
+
+		stopped := false
+		for _, stage := range action.Pipeline {
+			if stopped && !stage.Always {
+				n.ReportCard.SkipStage(stage.Name)
+				continue
+			}
+			start := time.Now()
+			card := runStage(n, stage, args)
+			if n.ReportCard.AddStage(stage.Name, stage.OnFailure, card, time.Since(start)) {
+				stopped = true
+			}
+		}
+	}
+}
+
+// runStage runs one stage of a pipeline and returns its report card. A stage
+// that writes no report card gets a single result named for the stage,
+// passing if its command exits with status zero.
+func runStage(n *Nanny, stage *stageDefinition, args []string) *ReportCard {
+	card := NewReportCard()
+
+	// clear the report card left by the previous stage
+	if err := n.PutFiles(map[string]string{sdk.ReportFile: ""}); err != nil {
+		card.LogAndFailf("PutFiles error: %v", err)
+		return card
+	}
+
+	cmd := append(append([]string{}, stage.Command...), args...)
+	_, _, _, status, err := n.ExecNonInteractive(cmd)
+	if err != nil {
+		card.LogAndFailf("exec error: %v", err)
+		return card
+	}
+
+	report, err := n.GetFiles([]string{sdk.ReportFile})
+	if err != nil || report[sdk.ReportFile] == "" {
+		if status != 0 {
+			card.AddFailedResult(stage.Name, fmt.Sprintf("exit status %d", status), "")
+			card.Failf("exit status %d", status)
+		} else {
+			card.AddPassedResult(stage.Name, "")
+		}
+		return card
+	}
+	if err := json.Unmarshal([]byte(report[sdk.ReportFile]), card); err != nil {
+		card = NewReportCard()
+		card.LogAndFailf("error parsing report from %s: %v", stage.Command[0], err)
+	}
+	return card
+}
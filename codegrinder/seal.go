@@ -0,0 +1,329 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getSealedExam returns the sealed exam settings for a problem set in a course,
+// or nil if it is not a sealed exam.
+func getSealedExam(tx *sql.Tx, courseID, problemSetID int64) (*SealedExam, error) {
+	exam := new(SealedExam)
+	err := meddler.QueryRow(tx, exam, `SELECT * FROM sealed_exams WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return exam, nil
+}
+
+// PutCourseProblemSetSeal handles /v2/courses/:course_id/problem_sets/:problem_set_id/seal requests,
+// making the problem set a sealed exam in the course. Student work must be encrypted to the
+// given public key until the deadline has passed and the instructor unseals the exam.
+// The public key cannot be changed once sealed work has been submitted.
+// The sealed exam settings are returned.
+func PutCourseProblemSetSeal(w http.ResponseWriter, tx *sql.Tx, params martini.Params, exam SealedExam, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+	if _, err := ParseSealPublicKey(exam.PublicKey); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if !exam.Deadline.After(now) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the deadline must be in the future")
+		return
+	}
+
+	old, err := getSealedExam(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if old != nil {
+		if !old.IsSealed() {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "this exam has already been unsealed")
+			return
+		}
+		if old.PublicKey != exam.PublicKey {
+			var submitted bool
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM sealed_submissions JOIN assignments ON sealed_submissions.assignment_id = assignments.id `+
+				`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2)`,
+				courseID, problemSetID).Scan(&submitted); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if submitted {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "sealed work has already been submitted, so the public key cannot be changed")
+				return
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM sealed_exams WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		exam.CreatedAt = old.CreatedAt
+	} else {
+		exam.CreatedAt = now
+	}
+
+	exam.CourseID = courseID
+	exam.ProblemSetID = problemSetID
+	exam.UnsealedAt = time.Time{}
+	exam.UpdatedAt = now
+	if err := meddler.Insert(tx, "sealed_exams", &exam); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("problem set %d sealed in course %d until %v", problemSetID, courseID, exam.Deadline)
+	render.JSON(http.StatusOK, &exam)
+}
+
+// GetAssignmentSeal handles /v2/assignments/:assignment_id/seal requests,
+// returning the sealed exam settings for the assignment, or not found if it is not a sealed exam.
+func GetAssignmentSeal(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+
+	assignment := new(Assignment)
+	if currentUser.Admin {
+		err = meddler.Load(tx, "assignments", assignment, assignmentID)
+	} else {
+		err = meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	exam, err := getSealedExam(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if exam == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "assignment %d is not a sealed exam", assignmentID)
+		return
+	}
+	render.JSON(http.StatusOK, exam)
+}
+
+// PostSealedSubmission handles /v2/sealed_submissions requests,
+// saving encrypted work for a step of a problem in a sealed exam.
+// Only the most recent sealed submission for each step is kept.
+// The saved sealed submission is returned.
+func PostSealedSubmission(w http.ResponseWriter, tx *sql.Tx, currentUser *User, sealed SealedSubmission, render render.Render) {
+	now := time.Now()
+
+	assignment := new(Assignment)
+	if err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, sealed.AssignmentID, currentUser.ID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if assignment.IsDropped() {
+		loggedHTTPErrorf(w, http.StatusForbidden, "you are no longer enrolled in this course; your earlier work has been kept")
+		return
+	}
+	if err := checkPrerequisites(tx, assignment); err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	exam, err := getSealedExam(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if exam == nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "assignment %d is not a sealed exam", assignment.ID)
+		return
+	}
+	if !exam.IsSealed() || now.After(exam.Deadline) {
+		loggedHTTPErrorf(w, http.StatusForbidden, "the deadline for this exam has passed")
+		return
+	}
+
+	// make sure the problem and step are part of the problem set
+	var steps int64
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM problem_steps JOIN problem_set_problems ON problem_steps.problem_id = problem_set_problems.problem_id `+
+		`WHERE problem_set_problems.problem_set_id = $1 AND problem_steps.problem_id = $2`,
+		assignment.ProblemSetID, sealed.ProblemID).Scan(&steps); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if sealed.Step < 1 || sealed.Step > steps {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "problem %d has no step %d in this problem set", sealed.ProblemID, sealed.Step)
+		return
+	}
+	if sealed.EncryptedKey == "" || sealed.Nonce == "" || sealed.Ciphertext == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "sealed submission must include the encrypted key, nonce, and ciphertext")
+		return
+	}
+
+	// replace any earlier sealed submission for this step
+	old := new(SealedSubmission)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM sealed_submissions WHERE assignment_id = $1 AND problem_id = $2 AND step = $3`,
+		sealed.AssignmentID, sealed.ProblemID, sealed.Step)
+	switch {
+	case err == sql.ErrNoRows:
+		sealed.ID = 0
+		sealed.CreatedAt = now
+	case err != nil:
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	default:
+		sealed.ID = old.ID
+		sealed.CreatedAt = old.CreatedAt
+	}
+	sealed.UserID = currentUser.ID
+	sealed.SubmissionID = 0
+	sealed.UpdatedAt = now
+	if err := meddler.Save(tx, "sealed_submissions", &sealed); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("sealed submission %d saved for user %d (%s) problem %d step %d",
+		sealed.ID, currentUser.ID, currentUser.Name, sealed.ProblemID, sealed.Step)
+	render.JSON(http.StatusOK, &sealed)
+}
+
+// PostCourseProblemSetUnseal handles /v2/courses/:course_id/problem_sets/:problem_set_id/unseal requests,
+// decrypting the sealed work for an exam whose deadline has passed and queuing it for grading.
+// The private key is used only for this request and is never stored.
+// Work that cannot be decrypted is logged and left sealed.
+// The list of sealed submissions is returned, with the submission ID set for those that were queued.
+func PostCourseProblemSetUnseal(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, params martini.Params, key SealKey, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	exam, err := getSealedExam(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if exam == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not a sealed exam in course %d", problemSetID, courseID)
+		return
+	}
+	if now.Before(exam.Deadline) {
+		loggedHTTPErrorf(w, http.StatusForbidden, "the exam cannot be unsealed until the deadline at %v", exam.Deadline)
+		return
+	}
+	publicKey, err := ParseSealPublicKey(exam.PublicKey)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error parsing exam public key: %v", err)
+		return
+	}
+	privateKey, err := ParseSealPrivateKey(key.PrivateKey)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if !MatchesPublicKey(privateKey, publicKey) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the private key does not match the public key for this exam")
+		return
+	}
+
+	// mark it unsealed first so the decrypted work can be saved as ordinary commits
+	exam.UnsealedAt = now
+	exam.UpdatedAt = now
+	if _, err := tx.Exec(`UPDATE sealed_exams SET unsealed_at = $1, updated_at = $1 WHERE course_id = $2 AND problem_set_id = $3`,
+		now, courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	sealed := []*SealedSubmission{}
+	if err := meddler.QueryAll(tx, &sealed, `SELECT sealed_submissions.* `+
+		`FROM sealed_submissions JOIN assignments ON sealed_submissions.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = $1 AND assignments.problem_set_id = $2 AND sealed_submissions.submission_id IS NULL `+
+		`ORDER BY sealed_submissions.user_id, sealed_submissions.problem_id, sealed_submissions.step`,
+		courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	queued := 0
+	for _, elt := range sealed {
+		files, err := elt.Unseal(privateKey)
+		if err != nil {
+			log.Printf("unable to unseal sealed submission %d: %v", elt.ID, err)
+			continue
+		}
+		student := new(User)
+		if err := meddler.Load(tx, "users", student, elt.UserID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		bundle := &CommitBundle{
+			Commit: &Commit{
+				AssignmentID: elt.AssignmentID,
+				ProblemID:    elt.ProblemID,
+				Step:         elt.Step,
+				Action:       "grade",
+				Note:         "sealed exam submission",
+				Files:        files,
+				Transcript:   []*EventMessage{},
+				CreatedAt:    now,
+				UpdatedAt:    now,
+			},
+		}
+		signed, err := saveCommitBundle(now, tx, tenant, student, bundle, nil)
+		if err != nil {
+			log.Printf("unable to save unsealed submission %d: %v", elt.ID, err)
+			continue
+		}
+		submission, err := queueSubmission(now, tx, student, signed.Commit)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		elt.SubmissionID = submission.ID
+		elt.UpdatedAt = now
+		if err := meddler.Save(tx, "sealed_submissions", elt); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		queued++
+	}
+
+	log.Printf("exam for problem set %d in course %d unsealed, %d of %d submissions queued for grading",
+		problemSetID, courseID, queued, len(sealed))
+	render.JSON(http.StatusOK, sealed)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// updateSections records the sections a user belongs to, as reported by the LMS.
+// Student memberships follow the LMS exactly. TAs are added to the sections
+// the LMS reports but never removed, since instructors may also attach TAs by hand.
+// Nothing changes if the LMS is not configured to send section information.
+func updateSections(tx *sql.Tx, form *LTIRequest, now time.Time, course *Course, user *User, asst *Assignment) error {
+	if form.CanvasSectionIDs == "" || asst.IsInstructorRole() {
+		return nil
+	}
+	role := "student"
+	if asst.IsTARole() {
+		role = "ta"
+	}
+
+	var canvasIDs []int64
+	for _, field := range strings.Split(form.CanvasSectionIDs, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			log.Printf("unable to parse section ID %q for course %d: %v", field, course.ID, err)
+			return nil
+		}
+		canvasIDs = append(canvasIDs, id)
+	}
+
+	// names are only usable if they line up with the IDs
+	var names []string
+	if form.CanvasSectionNames != "" {
+		if err := json.Unmarshal([]byte(form.CanvasSectionNames), &names); err != nil || len(names) != len(canvasIDs) {
+			log.Printf("unable to match section names %q with section IDs %q for course %d", form.CanvasSectionNames, form.CanvasSectionIDs, course.ID)
+			names = nil
+		}
+	}
+
+	inSection := make(map[int64]bool)
+	for i, canvasID := range canvasIDs {
+		name := fmt.Sprintf("Section %d", canvasID)
+		if names != nil {
+			name = names[i]
+		}
+
+		section := new(Section)
+		err := meddler.QueryRow(tx, section, `SELECT * FROM sections WHERE course_id = $1 AND canvas_id = $2`, course.ID, canvasID)
+		switch {
+		case err == sql.ErrNoRows:
+			section = &Section{
+				CourseID:  course.ID,
+				CanvasID:  canvasID,
+				Name:      name,
+				CreatedAt: now,
+				UpdatedAt: now,
+			}
+			log.Printf("creating new section %s in course %d (%s)", name, course.ID, course.Name)
+			if err := meddler.Insert(tx, "sections", section); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case names != nil && section.Name != name:
+			section.Name = name
+			section.UpdatedAt = now
+			if err := meddler.Save(tx, "sections", section); err != nil {
+				return err
+			}
+		}
+		inSection[section.ID] = true
+
+		if _, err := tx.Exec(`UPDATE section_members SET role = $1 WHERE section_id = $2 AND user_id = $3 AND role <> $1`,
+			role, section.ID, user.ID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO section_members (section_id, user_id, role, created_at) `+
+			`SELECT $1, $2, $3, $4 WHERE NOT EXISTS `+
+			`(SELECT 1 FROM section_members WHERE section_id = $1 AND user_id = $2)`,
+			section.ID, user.ID, role, now); err != nil {
+			return err
+		}
+	}
+
+	// students who moved or dropped a section leave it
+	if role == "student" {
+		members := []*SectionMember{}
+		if err := meddler.QueryAll(tx, &members, `SELECT section_members.* `+
+			`FROM section_members JOIN sections ON section_members.section_id = sections.id `+
+			`WHERE sections.course_id = $1 AND section_members.user_id = $2 AND section_members.role = 'student'`,
+			course.ID, user.ID); err != nil {
+			return err
+		}
+		for _, member := range members {
+			if inSection[member.SectionID] {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM section_members WHERE section_id = $1 AND user_id = $2`, member.SectionID, user.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseSectionFilter reads the optional section_id parameter,
+// returning zero if it is not present.
+func parseSectionFilter(w http.ResponseWriter, r *http.Request) (int64, error) {
+	s := r.FormValue("section_id")
+	if s == "" {
+		return 0, nil
+	}
+	return parseID(w, "section_id", s)
+}
+
+// GetCourseSections handles /v2/courses/:course_id/sections requests,
+// returning the sections of a course with the number of students
+// and the TAs in each. Only instructors see more of a TA than their name.
+func GetCourseSections(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+
+	sections := []*Section{}
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &sections, `SELECT * FROM sections WHERE course_id = $1 ORDER BY name, id`, courseID)
+	} else {
+		err = meddler.QueryAll(tx, &sections, `SELECT * FROM sections WHERE course_id = $1 AND EXISTS `+
+			`(SELECT 1 FROM assignments WHERE course_id = $1 AND user_id = $2) `+
+			`ORDER BY name, id`,
+			courseID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	instructor := currentUser.Admin
+	if !instructor {
+		if instructor, err = isCourseInstructor(tx, currentUser.ID, courseID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+
+	for _, section := range sections {
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM section_members WHERE section_id = $1 AND role = 'student'`,
+			section.ID).Scan(&section.Students); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		section.TAs = []*User{}
+		if err := meddler.QueryAll(tx, &section.TAs, `SELECT users.* `+
+			`FROM users JOIN section_members ON users.id = section_members.user_id `+
+			`WHERE section_members.section_id = $1 AND section_members.role = 'ta' `+
+			`ORDER BY users.name`,
+			section.ID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if !instructor {
+			for i, ta := range section.TAs {
+				section.TAs[i] = &User{Name: ta.Name}
+			}
+		}
+	}
+
+	render.JSON(http.StatusOK, sections)
+}
+
+// PutCourseSectionTA handles /v2/courses/:course_id/sections/:section_id/tas/:user_id requests,
+// attaching a TA to a section. The user must belong to the course.
+func PutCourseSectionTA(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, sectionID, userID, ok := parseCourseSectionUser(w, tx, params)
+	if !ok {
+		return
+	}
+	var member bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM assignments WHERE course_id = $1 AND user_id = $2)`,
+		courseID, userID).Scan(&member); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !member {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "user %d is not part of course %d", userID, courseID)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM section_members WHERE section_id = $1 AND user_id = $2`, sectionID, userID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO section_members (section_id, user_id, role, created_at) VALUES ($1, $2, 'ta', $3)`,
+		sectionID, userID, time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("user %d attached as a TA to section %d in course %d", userID, sectionID, courseID)
+}
+
+// DeleteCourseSectionTA handles /v2/courses/:course_id/sections/:section_id/tas/:user_id requests,
+// removing a TA from a section.
+func DeleteCourseSectionTA(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, sectionID, userID, ok := parseCourseSectionUser(w, tx, params)
+	if !ok {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM section_members WHERE section_id = $1 AND user_id = $2 AND role = 'ta'`, sectionID, userID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("user %d removed as a TA from section %d in course %d", userID, sectionID, courseID)
+}
+
+// parseCourseSectionUser parses the course, section, and user IDs from a request,
+// making sure the section belongs to the course.
+func parseCourseSectionUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params) (courseID, sectionID, userID int64, ok bool) {
+	var err error
+	if courseID, err = parseID(w, "course_id", params["course_id"]); err != nil {
+		return 0, 0, 0, false
+	}
+	if sectionID, err = parseID(w, "section_id", params["section_id"]); err != nil {
+		return 0, 0, 0, false
+	}
+	if userID, err = parseID(w, "user_id", params["user_id"]); err != nil {
+		return 0, 0, 0, false
+	}
+	section := new(Section)
+	if err := meddler.QueryRow(tx, section, `SELECT * FROM sections WHERE id = $1 AND course_id = $2`, sectionID, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return 0, 0, 0, false
+	}
+	return courseID, sectionID, userID, true
+}
@@ -11,12 +11,14 @@ import (
 	"html"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
@@ -28,31 +30,114 @@ import (
 	. "github.com/russross/codegrinder/types"
 	"github.com/russross/meddler"
 	"github.com/sergi/go-diff/diffmatchpatch"
-	"rsc.io/letsencrypt"
 )
 
-// Config holds site-specific configuration data.
+// serverConfig holds site-specific configuration data.
 // Contains a mix of Daycare and main server parameters.
-var Config struct {
+type serverConfig struct {
 	Hostname         string // Hostname for the site: "your.host.goes.here"
 	LetsEncryptEmail string // Email address to register TLS certificates: "foo@bar.com"
 	LTISecret        string // LTI authentication shared secret. Must match that given to Canvas course: "asdf..."
 	SessionSecret    string // Random string used to sign cookie sessions: "asdf..."
 	DaycareSecret    string // Random string used to sign daycare requests: "asdf..."
-	StaticDir        string // Full path of directory holding static files to serve: "/home/foo/codegrinder/client"
+	StaticDir        string // Full path of directory holding static files to serve, instead of those built in: "/home/foo/codegrinder/client"
+
+	ToolName         string         // LTI human readable name: "CodeGrinder"
+	ToolID           string         // LTI unique ID: "codegrinder"
+	ToolDescription  string         // LTI description: "Programming exercises with grading"
+	LetsEncryptCache string         // Full path of LetsEncrypt cache file: "/etc/codegrinder/letsencrypt.cache"
+	PostgresHost     string         // Host parameter for Postgres: "/var/run/postgresql"
+	PostgresPort     string         // Port parameter for Postgres: "5432"
+	PostgresUsername string         // Username parameter for Postgres: "codegrinder"
+	PostgresPassword string         // Password parameter for Postgres: "super$trong"
+	PostgresDatabase string         // Database parameter for Postgres: "codegrinder"
+	LMSURL           string         // URL of the LMS probed by readiness checks: "https://dixie.instructure.com"
+	DiskCheckPath    string         // Path whose filesystem is checked for free space by readiness checks: "/var/lib/docker"
+	DiskMinFreeMB    int            // Minimum free space in megabytes for readiness checks to pass: 1024
+	ContainerQuotaMB int            // Most a grading container may write to disk before it is stopped: 256
+	PruneMinutes     int            // How often the daycare removes exited containers, dangling images, and unused volumes: 60
+	DaycareHost      string         // Host of the daycare used for background grading, defaults to Hostname: "daycare.host.goes.here"
+	ProblemTypesDir  string         // Directory of *.json problem type definitions added to the built-in types: "/etc/codegrinder/problem_types"
+	GitHubToken      string         // GitHub token used to read GitHub Classroom repositories and post commit statuses: "ghp_..."
+	ImageRegistry    string         // Registry toolchain images are pushed to and daycares pull them from: "registry.example.edu/codegrinder"
+	ImageScanCommand string         // Command that scans a new toolchain image named as its last argument, failing to stop it being used: "trivy image --exit-code 1"
+	GitRoot          string         // Directory holding repositories for students who submit with git push, which is off if empty: "/var/lib/codegrinder/git"
+	WorkspaceLibDir  string         // Directory holding the xterm and monaco-editor npm packages the browser workspace loads, which is off if empty: "/usr/lib/node_modules"
+	ReportSigningKey string         // Base64 Ed25519 seed used to sign grade reports, required by the TA role; make one with "head -c 32 /dev/urandom | base64": "asdf..."
+	CanaryHour       int            // Local hour when reference solutions are regraded each night, -1 to turn it off: 3
+	CanaryHosts      []string       // Daycare hosts checked by the nightly regrade, defaults to DaycareHost: ["daycare1.host.goes.here", "daycare2.host.goes.here"]
+	AlertWebhook     string         // URL that is sent a JSON message when reference solutions stop passing: "https://hooks.slack.com/services/..."
+	TraceEndpoint    string         // OTLP/HTTP URL of the OpenTelemetry collector that receives traces, which are off if empty: "http://localhost:4318/v1/traces"
+	TraceSampleRate  float64        // Fraction of new traces that are recorded; traces started by a client follow its choice: 1.0
+	ArchiveDays      int            // Days students can still download their work after a course closes to them, 0 for no limit: 365
+	EmailGateway     string         // Address students can send work to when they cannot reach the LMS, which is off if empty: "submit@your.host.goes.here"
+	EmailSecret      string         // Shared secret the inbound mail service sends in the X-Gateway-Secret header: "asdf..."
+	AssetMirror      string         // Base URL of a mirror or CDN that serves problem steps with this server as its origin, which is off if empty: "https://cdn.example.edu"
+	MirrorSecret     string         // Random string shared with the asset mirror to sign URLs it checks before serving from its cache, required with AssetMirror: "asdf..."
+	MirrorMinutes    int            // How long a signed asset mirror URL can be used: 60
+	Discovery        bool           // Keep the public index of CodeGrinder servers that "grind init --school" searches: false
+	ACMEDirectory    string         // ACME directory that TLS certificates are requested from: "https://acme-v01.api.letsencrypt.org/directory"
+	ACMEChallenge    string         // How the certificate authority checks that we control each host, http-01 on port 80 or dns-01: "http-01"
+	ACMEDNSHook      string         // Command that publishes dns-01 records, run with present or cleanup, the record name, and its value: "/etc/codegrinder/dns-hook"
+	CertWarnDays     int            // Readiness checks fail when a TLS certificate expires within this many days: 7
+	GradingBackend   string         // Where the daycare runs grading containers, docker, podman (which can be rootless), or kubernetes: "docker"
+	ContainerSocket  string         // API socket of Docker or Podman, defaults to the usual one for the engine and user: "/run/user/1000/podman/podman.sock"
+	KubeNamespace    string         // Kubernetes namespace for grading jobs, defaults to the daycare pod's own: "codegrinder"
+	KubeCPU          string         // CPU requested for each Kubernetes grading pod: "500m"
+	KubeNodeLabels   []string       // Node labels that Kubernetes grading pods must be scheduled on: ["codegrinder=grading"]
+	KubeMaxMinutes   int            // Most minutes a Kubernetes grading job can run before the cluster removes it: 15
+	RunnerSecret     string         // Secret that runner agent tokens are derived from, which turns runners off if empty: "asdf..."
+	SMTPServer       string         // Mail server, with port, used to send email such as at-risk digests, which is off if empty: "smtp.example.edu:587"
+	SMTPUsername     string         // Username for the mail server, if it requires one: "codegrinder"
+	SMTPPassword     string         // Password for the mail server: "super$trong"
+	MailFrom         string         // Address email is sent from: "codegrinder@your.host.goes.here"
+	RiskDigestDay    string         // Day of the week instructors who ask for it are emailed their at-risk students, which is off if empty: "Monday"
+	RiskDigestHour   int            // Local hour when at-risk digests are sent: 7
+	OverridePolicy   OverridePolicy // Images courses can switch problem types to and the most they can raise each limit to: {"Images": ["codegrinder/python3:3.12"], "MaxMemory": 512}
+
+	Tenants []*TenantConfig // Additional tenants served by this installation, each with its own hostname and database schema
+}
+
+// currentConfig holds the configuration loaded from the config file.
+// Some fields can be reloaded while the server runs; see reloadConfig.
+// A reload publishes a new copy instead of changing the one in use,
+// so a handler never sees a config that is half old and half new.
+var currentConfig atomic.Value
+
+func init() {
+	currentConfig.Store(new(serverConfig))
+}
 
-	ToolName         string // LTI human readable name: "CodeGrinder"
-	ToolID           string // LTI unique ID: "codegrinder"
-	ToolDescription  string // LTI description: "Programming exercises with grading"
-	LetsEncryptCache string // Full path of LetsEncrypt cache file: "/etc/codegrinder/letsencrypt.cache"
-	PostgresHost     string // Host parameter for Postgres: "/var/run/postgresql"
-	PostgresPort     string // Port parameter for Postgres: "5432"
-	PostgresUsername string // Username parameter for Postgres: "codegrinder"
-	PostgresPassword string // Password parameter for Postgres: "super$trong"
-	PostgresDatabase string // Database parameter for Postgres: "codegrinder"
+// Config returns the current configuration. It must not be changed;
+// publish a changed copy with setConfig instead.
+func Config() *serverConfig {
+	return currentConfig.Load().(*serverConfig)
 }
 
-var problemTypes = make(map[string]*ProblemType)
+// setConfig makes config the current configuration.
+func setConfig(config *serverConfig) {
+	currentConfig.Store(config)
+}
+
+// configPath is the config file the server was started with.
+var configPath string
+
+// currentProblemTypes maps names to the problem types in use. It is replaced
+// as a whole when problem types are reloaded.
+var currentProblemTypes atomic.Value
+
+// problemTypes returns the map of names to problem types in use. It must not be changed.
+func problemTypes() map[string]*ProblemType {
+	if loaded, ok := currentProblemTypes.Load().(map[string]*ProblemType); ok {
+		return loaded
+	}
+	return builtinProblemTypes
+}
+
+// setProblemTypes makes loaded the problem types in use.
+func setProblemTypes(loaded map[string]*ProblemType) {
+	currentProblemTypes.Store(loaded)
+}
 
 func main() {
 	// parse command line
@@ -61,45 +146,67 @@ func main() {
 	var ta, daycare bool
 	flag.BoolVar(&ta, "ta", true, "Serve the TA role")
 	flag.BoolVar(&daycare, "daycare", true, "Serve the daycare role")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [command [arguments]]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "With no command, runs the server. Commands:\n\n")
+		for _, name := range commandNames() {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].Short)
+		}
+		fmt.Fprintf(os.Stderr, "\nOptions:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if !ta && !daycare {
-		log.Fatalf("must run at least one role (ta/daycare)")
+	if commandNeedsConfig(flag.Args()) {
+		loadConfig(configFile)
+	} else {
+		configPath = configFile
 	}
 
-	// set config defaults
-	Config.ToolName = "CodeGrinder"
-	Config.ToolID = "codegrinder"
-	Config.ToolDescription = "Programming exercises with grading"
-	Config.LetsEncryptCache = "/etc/codegrinder/letsencrypt.cache"
-	Config.PostgresHost = "/var/run/postgresql"
-	Config.PostgresPort = ""
-	Config.PostgresUsername = os.Getenv("USER")
-	Config.PostgresPassword = ""
-	Config.PostgresDatabase = os.Getenv("USER")
+	// run a maintenance command instead of the server?
+	if flag.NArg() > 0 {
+		runCommand(flag.Args())
+		return
+	}
 
-	// load config file
-	if raw, err := ioutil.ReadFile(configFile); err != nil {
-		log.Fatalf("failed to load config file %q: %v", configFile, err)
-	} else if err := json.Unmarshal(raw, &Config); err != nil {
-		log.Fatalf("failed to parse config file: %v", err)
+	if !ta && !daycare {
+		log.Fatalf("must run at least one role (ta/daycare)")
 	}
-	Config.SessionSecret = unBase64(Config.SessionSecret)
-	Config.DaycareSecret = unBase64(Config.DaycareSecret)
+
+	// pick up config changes without dropping connections
+	go reloadConfigOnSignal()
 
 	// set up martini
-	r := martini.NewRouter()
+	r := newAPIRouter(martini.NewRouter())
 	m := martini.New()
 	m.Logger(log.New(os.Stderr, "", log.LstdFlags))
 	m.Use(martini.Logger())
 	m.Use(martini.Recovery())
-	m.Use(martini.Static(Config.StaticDir, martini.StaticOptions{SkipLogging: true}))
+	if Config().StaticDir != "" {
+		m.Use(martini.Static(Config().StaticDir, martini.StaticOptions{SkipLogging: true}))
+	} else {
+		m.Use(serveEmbeddedStatic())
+	}
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
 
 	m.Use(render.Renderer(render.Options{IndentJSON: true}))
 
-	store := sessions.NewCookieStore([]byte(Config.SessionSecret))
+	// record a span for every request, joining the trace of the caller if there is one
+	m.Use(traceRequests)
+
+	// every response carries the server clock, which decides all deadlines
+	m.Use(func(w http.ResponseWriter) {
+		w.Header().Set(ServerTimeHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	})
+
+	// health checks are served by every role
+	r.Get("/healthz", GetHealthz)
+	r.Get("/readyz", GetReadyz)
+	healthProbes = append(healthProbes, &healthProbe{Name: "disk", Probe: probeDisk(Config().DiskCheckPath, Config().DiskMinFreeMB)})
+
+	store := sessions.NewCookieStore([]byte(Config().SessionSecret))
+	m.Use(gitBasicAuth)
 	m.Use(sessions.Sessions(CookieName, store))
 
 	// sessions expire June 30 and December 31
@@ -127,21 +234,80 @@ func main() {
 	// set up TA role
 	if ta {
 		// make sure relevant secrets are included in config file
-		if Config.LTISecret == "" {
+		if Config().LTISecret == "" {
 			log.Fatalf("cannot run TA role with no LTISecret in the config file")
 		}
-		if Config.SessionSecret == "" {
+		if Config().SessionSecret == "" {
 			log.Fatalf("cannot run TA role with no SessionSecret in the config file")
 		}
-		if Config.DaycareSecret == "" {
+		if Config().DaycareSecret == "" {
 			log.Fatalf("cannot run with no DaycareSecret in the config file")
 		}
+		if _, err := reportSigningKey(); err != nil {
+			log.Fatalf("cannot run TA role: %v", err)
+		}
+		if err := checkMirrorConfig(Config()); err != nil {
+			log.Fatalf("cannot run TA role: %v", err)
+		}
 
 		// set up the database
-		db := setupDB(Config.PostgresHost, Config.PostgresPort, Config.PostgresUsername, Config.PostgresPassword, Config.PostgresDatabase)
+		db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+		healthRoles = append(healthRoles, "ta")
+		healthProbes = append(healthProbes, &healthProbe{Name: "database", Probe: probeDatabase(db)})
+		if Config().LMSURL != "" {
+			healthProbes = append(healthProbes, &healthProbe{Name: "lms", Probe: probeLMS(Config().LMSURL)})
+		}
+
+		// archive courses when their terms end
+		go archiveEndedCoursesLoop(db)
+
+		// re-run reference solutions when toolchains change
+		go checkToolchainsLoop(db)
+
+		// regrade reference solutions every night to catch drift before students do
+		if Config().CanaryHour >= 0 {
+			go cronLoop(db, &cronJob{Name: "canary", Hour: Config().CanaryHour, Run: runCanary})
+			healthProbes = append(healthProbes, &healthProbe{Name: "canary", Probe: probeCanary})
+		}
+
+		// grade submissions queued by clients that did not wait,
+		// or that could not be graded because the daycare was down
+		go gradeSubmissionsLoop(db)
+		healthProbes = append(healthProbes, &healthProbe{Name: "grading", Probe: probeDaycareBreaker})
+
+		// email instructors who asked for it a weekly list of students who may be falling behind
+		if Config().RiskDigestDay != "" && Config().SMTPServer != "" {
+			if _, err := parseWeekday(Config().RiskDigestDay); err != nil {
+				log.Fatalf("RiskDigestDay: %v", err)
+			}
+			go cronLoop(db, &cronJob{Name: "at-risk digest", Hour: Config().RiskDigestHour, Run: sendRiskDigests})
+		}
+
+		// compare course rosters with the LMS to catch drops
+		go rosterSyncLoop(db)
+
+		// announce scheduled maintenance on every response
+		go maintenanceLoop(db)
+		m.Use(announceMaintenance)
+
+		// hold jobs for problem types whose daycare toolchain is not the expected image
+		if Config().ImageRegistry != "" {
+			go checkToolchainImagesLoop(db)
+		}
+
+		// serve the editor and terminal the browser workspace uses from this server
+		if Config().WorkspaceLibDir != "" {
+			m.Use(martini.Static(Config().WorkspaceLibDir, martini.StaticOptions{Prefix: workspaceLibPrefix, SkipLogging: true}))
+		}
 
 		// martini service: wrap handler in a transaction
-		withTx := func(c martini.Context, w http.ResponseWriter) {
+		withTx := func(c martini.Context, w http.ResponseWriter, r *http.Request) {
+			// find the tenant this request is for
+			tenant := mustFindTenant(w, r)
+			if tenant == nil {
+				return
+			}
+
 			// start a transaction
 			tx, err := db.Begin()
 			if err != nil {
@@ -149,7 +315,15 @@ func main() {
 				return
 			}
 
+			// all queries in this transaction only see the tenant's data
+			if err := setTenantSearchPath(tx, tenant); err != nil {
+				tx.Rollback()
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error selecting tenant schema: %v", err)
+				return
+			}
+
 			// pass it on to the main handler
+			c.Map(tenant)
 			c.Map(tx)
 			c.Next()
 
@@ -180,38 +354,51 @@ func main() {
 		}
 
 		// martini service: include the current logged-in user (requires withTx and auth)
-		withCurrentUser := func(c martini.Context, w http.ResponseWriter, tx *sql.Tx, session sessions.Session) {
-			rawID := session.Get("id")
-			if rawID == nil {
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "cannot find user ID in session")
-				return
+		withCurrentUser := func(c martini.Context, w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, session sessions.Session) {
+			if user := loadSessionUser(w, tx, tenant, session); user != nil {
+				c.Map(user)
 			}
-			userID, ok := rawID.(int64)
-			if !ok {
-				session.Clear()
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "error extracting user ID from session")
+		}
+
+		// martini service: include the tenant and current logged-in user without holding
+		// a transaction open, for handlers that wait and run their own (requires auth)
+		withCurrentUserNoTx := func(c martini.Context, w http.ResponseWriter, r *http.Request, session sessions.Session) {
+			tenant := mustFindTenant(w, r)
+			if tenant == nil {
 				return
 			}
-
-			// load the user record
-			user := new(User)
-			if err := meddler.Load(tx, "users", user, userID); err != nil {
-				if err == sql.ErrNoRows {
-					loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d not found", userID)
-					return
-				}
+			var user *User
+			err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+				user = loadSessionUser(w, tx, tenant, session)
+				return nil
+			})
+			if err != nil {
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 				return
 			}
-
-			// map the current user to the request context
+			if user == nil {
+				return
+			}
+			c.Map(db)
+			c.Map(tenant)
 			c.Map(user)
 		}
 
+		// martini service: include the tenant without holding a transaction open,
+		// for handlers that need no login and run their own
+		withTenantNoTx := func(c martini.Context, w http.ResponseWriter, r *http.Request) {
+			tenant := mustFindTenant(w, r)
+			if tenant == nil {
+				return
+			}
+			c.Map(db)
+			c.Map(tenant)
+		}
+
 		// martini service: require logged in user to be an administrator (requires withCurrentUser)
 		administratorOnly := func(w http.ResponseWriter, currentUser *User) {
 			if !currentUser.Admin {
-				loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d (%s) is not an administrator", currentUser.ID, currentUser.Email)
+				loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an administrator", currentUser.ID, currentUser.Email)
 				return
 			}
 		}
@@ -222,16 +409,130 @@ func main() {
 				return
 			}
 			if !currentUser.Author {
-				loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d (%s) is not an author", currentUser.ID, currentUser.Name)
+				loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an author", currentUser.ID, currentUser.Name)
+				return
+			}
+		}
+
+		// martini service: require logged in user to be an instructor in the course
+		// named in the URL or an administrator (requires withCurrentUser)
+		courseInstructorOnly := func(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+			if currentUser.Admin {
+				return
+			}
+			courseID, err := parseID(w, "course_id", params["course_id"])
+			if err != nil {
+				return
+			}
+			instructor, err := isCourseInstructor(tx, currentUser.ID, courseID)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if !instructor {
+				loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an instructor in course %d", currentUser.ID, currentUser.Name, courseID)
+				return
+			}
+		}
+
+		// martini service: require logged in user to be an instructor in the course
+		// named in the URL or an administrator (requires withCurrentUserNoTx)
+		courseInstructorOnlyNoTx := func(w http.ResponseWriter, db *sql.DB, tenant *TenantConfig, params martini.Params, currentUser *User) {
+			if currentUser.Admin {
+				return
+			}
+			courseID, err := parseID(w, "course_id", params["course_id"])
+			if err != nil {
+				return
+			}
+			var instructor bool
+			err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+				instructor, err = isCourseInstructor(tx, currentUser.ID, courseID)
+				return err
+			})
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if !instructor {
+				loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an instructor in course %d", currentUser.ID, currentUser.Name, courseID)
+				return
+			}
+		}
+
+		// martini service: require logged in user to be an instructor or TA in the course
+		// named in the URL or an administrator (requires withCurrentUser)
+		courseStaffOnly := func(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+			if currentUser.Admin {
+				return
+			}
+			courseID, err := parseID(w, "course_id", params["course_id"])
+			if err != nil {
+				return
+			}
+			staff, err := isCourseStaff(tx, currentUser.ID, courseID)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if !staff {
+				loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not an instructor or TA in course %d", currentUser.ID, currentUser.Name, courseID)
+				return
+			}
+		}
+
+		// martini service: require logged in user to be in the course named in the URL
+		// or an administrator (requires withCurrentUser)
+		courseMemberOnly := func(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+			if currentUser.Admin {
+				return
+			}
+			courseID, err := parseID(w, "course_id", params["course_id"])
+			if err != nil {
+				return
+			}
+			member, err := isCourseMember(tx, currentUser.ID, courseID)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			if !member {
+				loggedHTTPErrorf(w, http.StatusForbidden, "user %d (%s) is not in course %d", currentUser.ID, currentUser.Name, courseID)
 				return
 			}
 		}
 
+		// describe who may use each route in the API description
+		r.Role("session", auth)
+		r.Role("administrator", administratorOnly)
+		r.Role("author", authorOnly)
+		r.Role("instructor", courseInstructorOnly)
+		r.Role("staff", courseStaffOnly)
+		r.Role("member", courseMemberOnly)
+
+		// API description
+		r.Get("/v2/openapi.json", r.GetOpenAPI)
+
 		// version
 		r.Get("/v2/version", func(w http.ResponseWriter, render render.Render) {
 			render.JSON(http.StatusOK, &CurrentVersion)
 		})
 
+		// config
+		r.Post("/v2/config/reload", auth, withTx, withCurrentUser, administratorOnly, PostConfigReload)
+		r.Get("/v2/health", auth, withTx, withCurrentUser, administratorOnly, GetHealth)
+
+		// feature flags
+		r.Get("/v2/capabilities", auth, withTx, withCurrentUser, r.GetCapabilities)
+		r.Get("/v2/feature_flags", auth, withTx, withCurrentUser, administratorOnly, GetFeatureFlags)
+		r.Put("/v2/feature_flags/:name", auth, withTx, withCurrentUser, administratorOnly, binding.Json(FeatureFlag{}), PutFeatureFlag)
+		r.Delete("/v2/feature_flags/:name", auth, withTx, withCurrentUser, administratorOnly, DeleteFeatureFlag)
+
+		// maintenance notices
+		r.Get("/v2/maintenance", GetMaintenance)
+		r.Put("/v2/maintenance", auth, withTx, withCurrentUser, administratorOnly, binding.Json(MaintenanceNotice{}), PutMaintenance)
+		r.Delete("/v2/maintenance", auth, withTx, withCurrentUser, administratorOnly, DeleteMaintenance)
+
 		// LTI
 		r.Get("/v2/lti/config.xml", GetConfigXML)
 		r.Post("/v2/lti/problem_sets", binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSets)
@@ -254,23 +555,144 @@ func main() {
 		r.Get("/v2/problems/:problem_id", auth, withTx, withCurrentUser, GetProblem)
 		r.Get("/v2/problems/:problem_id/steps", auth, withTx, withCurrentUser, GetProblemSteps)
 		r.Get("/v2/problems/:problem_id/steps/:step", auth, withTx, withCurrentUser, GetProblemStep)
+		r.Get("/v2/problems/:problem_id/steps/:step/bundle", auth, withTx, withCurrentUser, GetProblemStepBundle)
+		r.Get("/v2/problems/:problem_id/steps/:step/mirror", auth, withTx, withCurrentUser, GetProblemStepMirror)
+		r.Get("/v2/mirror/problems/:problem_id/steps/:step/:version", withTx, GetMirrorProblemStep)
 		r.Delete("/v2/problems/:problem_id", auth, withTx, withCurrentUser, administratorOnly, DeleteProblem)
+		r.Get("/v2/problems/:problem_id/validations", auth, withTx, withCurrentUser, authorOnly, GetProblemValidations)
+		r.Get("/v2/problems/:problem_id/variables", auth, withTx, withCurrentUser, authorOnly, GetProblemVariables)
+		r.Put("/v2/problems/:problem_id/variables/:name", auth, withTx, withCurrentUser, authorOnly, binding.Json(ProblemVariable{}), PutProblemVariable)
+		r.Delete("/v2/problems/:problem_id/variables/:name", auth, withTx, withCurrentUser, authorOnly, DeleteProblemVariable)
+		r.Get("/v2/problems/:problem_id/toolchain_variants", auth, withTx, withCurrentUser, authorOnly, GetProblemToolchainVariants)
+		r.Put("/v2/problems/:problem_id/toolchain_variants/:label", auth, withTx, withCurrentUser, authorOnly, binding.Json(ToolchainVariant{}), PutProblemToolchainVariant)
+		r.Delete("/v2/problems/:problem_id/toolchain_variants/:label", auth, withTx, withCurrentUser, authorOnly, DeleteProblemToolchainVariant)
+		r.Get("/v2/problems/:problem_id/reflections", auth, withTx, withCurrentUser, authorOnly, GetProblemReflections)
+		r.Put("/v2/problems/:problem_id/reflections/:name", auth, withTx, withCurrentUser, authorOnly, binding.Json(ReflectionPrompt{}), PutProblemReflection)
+		r.Delete("/v2/problems/:problem_id/reflections/:name", auth, withTx, withCurrentUser, authorOnly, DeleteProblemReflection)
+		r.Get("/v2/problems/:problem_id/feedback_rules", auth, withTx, withCurrentUser, authorOnly, GetProblemFeedbackRules)
+		r.Put("/v2/problems/:problem_id/feedback_rules/:name", auth, withTx, withCurrentUser, authorOnly, binding.Json(FeedbackRule{}), PutProblemFeedbackRule)
+		r.Delete("/v2/problems/:problem_id/feedback_rules/:name", auth, withTx, withCurrentUser, authorOnly, DeleteProblemFeedbackRule)
+		r.Get("/v2/problem_compatibility", auth, withTx, withCurrentUser, authorOnly, GetProblemCompatibility)
+		r.Get("/v2/canary_results", auth, withTx, withCurrentUser, authorOnly, GetCanaryResults)
+		r.Get("/v2/problem_costs", auth, withTx, withCurrentUser, authorOnly, GetProblemCosts)
 
 		// problem sets
 		r.Get("/v2/problem_sets", auth, withTx, withCurrentUser, GetProblemSets)
 		r.Get("/v2/problem_sets/:problem_set_id", auth, withTx, withCurrentUser, GetProblemSet)
 		r.Get("/v2/problem_sets/:problem_set_id/problems", auth, withTx, withCurrentUser, GetProblemSetProblems)
 		r.Delete("/v2/problem_sets/:problem_set_id", auth, withTx, withCurrentUser, administratorOnly, DeleteProblemSet)
+		r.Put("/v2/problem_sets/:problem_set_id/path", auth, withTx, withCurrentUser, authorOnly, binding.Json(ProblemSetPath{}), PutProblemSetPath)
+		r.Delete("/v2/problem_sets/:problem_set_id/path", auth, withTx, withCurrentUser, authorOnly, DeleteProblemSetPath)
+		r.Get("/v2/problem_sets/:problem_set_id/imports", auth, withTx, withCurrentUser, GetProblemSetImports)
+		r.Put("/v2/problem_sets/:problem_set_id/imports/:problem_id", auth, withTx, withCurrentUser, authorOnly, binding.Json(ProblemSetImport{}), PutProblemSetImport)
+		r.Delete("/v2/problem_sets/:problem_set_id/imports/:problem_id", auth, withTx, withCurrentUser, authorOnly, DeleteProblemSetImport)
 
 		// courses
 		r.Get("/v2/courses", auth, withTx, withCurrentUser, GetCourses)
 		r.Get("/v2/courses/:course_id", auth, withTx, withCurrentUser, GetCourse)
 		r.Delete("/v2/courses/:course_id", auth, withTx, withCurrentUser, administratorOnly, DeleteCourse)
+		r.Put("/v2/courses/:course_id/term", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(CourseTerm{}), PutCourseTerm)
+		r.Get("/v2/courses/:course_id/problem_sets", auth, withTx, withCurrentUser, GetCourseProblemSets)
+		r.Post("/v2/courses/:course_id/roll_forward", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(CourseRollForward{}), PostCourseRollForward)
+		r.Get("/v2/courses/:course_id/problem_type_overrides", auth, withTx, withCurrentUser, GetCourseProblemTypeOverrides)
+		r.Put("/v2/courses/:course_id/problem_type_overrides/:problem_type", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(ProblemTypeOverride{}), PutCourseProblemTypeOverride)
+		r.Delete("/v2/courses/:course_id/problem_type_overrides/:problem_type", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemTypeOverride)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/seal", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(SealedExam{}), PutCourseProblemSetSeal)
+		r.Post("/v2/courses/:course_id/problem_sets/:problem_set_id/unseal", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(SealKey{}), PostCourseProblemSetUnseal)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/solution_release", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(SolutionRelease{}), PutCourseProblemSetSolutionRelease)
+		r.Delete("/v2/courses/:course_id/problem_sets/:problem_set_id/solution_release", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemSetSolutionRelease)
+		r.Get("/v2/courses/:course_id/problems/:problem_id/exemplar_candidates", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemExemplarCandidates)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/rubric", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetRubric)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/rubric", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Rubric{}), PutCourseProblemSetRubric)
+		r.Delete("/v2/courses/:course_id/problem_sets/:problem_set_id/rubric", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemSetRubric)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/rubric_grades", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetRubricGrades)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/rubric_grades/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(RubricGrade{}), PutCourseProblemSetRubricGrade)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/checkoff_policy", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(CheckoffPolicy{}), PutCourseProblemSetCheckoffPolicy)
+		r.Delete("/v2/courses/:course_id/problem_sets/:problem_set_id/checkoff_policy", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemSetCheckoffPolicy)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/checkoffs", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetCheckoffs)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/checkoffs/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Checkoff{}), PutCourseProblemSetCheckoff)
+		r.Delete("/v2/courses/:course_id/problem_sets/:problem_set_id/checkoffs/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemSetCheckoff)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/stories/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetStory)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/activity", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetActivity)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/lab_sessions", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetLabSessions)
+		r.Post("/v2/courses/:course_id/problem_sets/:problem_set_id/lab_sessions", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(LabSession{}), PostCourseProblemSetLabSession)
+		r.Delete("/v2/courses/:course_id/problem_sets/:problem_set_id/lab_sessions/:lab_session_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemSetLabSession)
+		r.Get("/v2/courses/:course_id/reflections", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseReflections)
+		r.Get("/v2/courses/:course_id/exemplars", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseExemplars)
+		r.Post("/v2/courses/:course_id/exemplars", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Exemplar{}), PostCourseExemplar)
+		r.Delete("/v2/courses/:course_id/exemplars/:exemplar_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseExemplar)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/anonymous", auth, withTx, withCurrentUser, courseInstructorOnly, PutCourseProblemSetAnonymous)
+		r.Post("/v2/courses/:course_id/problem_sets/:problem_set_id/finalize", auth, withTx, withCurrentUser, courseInstructorOnly, PostCourseProblemSetFinalize)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/grades", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetGrades)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/moderation", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Moderation{}), PutCourseProblemSetModeration)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/moderation_marks", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetModerationMarks)
+		r.Put("/v2/courses/:course_id/moderation_marks/:mark_id/second", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Mark{}), PutCourseModerationMarkSecond)
+		r.Put("/v2/courses/:course_id/moderation_marks/:mark_id/reconcile", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Mark{}), PutCourseModerationMarkReconcile)
+		r.Get("/v2/courses/:course_id/sections", auth, withTx, withCurrentUser, GetCourseSections)
+		r.Put("/v2/courses/:course_id/sections/:section_id/tas/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, PutCourseSectionTA)
+		r.Delete("/v2/courses/:course_id/sections/:section_id/tas/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseSectionTA)
+		r.Get("/v2/courses/:course_id/problem_sets/:problem_set_id/prerequisites", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseProblemSetPrerequisites)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/prerequisites/:required_id", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Prerequisite{}), PutCourseProblemSetPrerequisite)
+		r.Delete("/v2/courses/:course_id/problem_sets/:problem_set_id/prerequisites/:required_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseProblemSetPrerequisite)
+		r.Get("/v2/courses/:course_id/roster_syncs", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseRosterSyncs)
+		r.Post("/v2/courses/:course_id/roster_syncs", auth, withCurrentUserNoTx, courseInstructorOnlyNoTx, PostCourseRosterSync)
+		r.Get("/v2/courses/:course_id/feature_flags", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseFeatureFlags)
+		r.Put("/v2/courses/:course_id/feature_flags/:name", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(CourseFeatureFlag{}), PutCourseFeatureFlag)
+		r.Delete("/v2/courses/:course_id/feature_flags/:name", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseFeatureFlag)
+		r.Put("/v2/courses/:course_id/problem_sets/:problem_set_id/github_classroom", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(GitHubClassroom{}), PutCourseProblemSetGitHubClassroom)
+		r.Post("/v2/courses/:course_id/problem_sets/:problem_set_id/github_classroom/import", auth, withCurrentUserNoTx, courseInstructorOnlyNoTx, PostCourseProblemSetGitHubImport)
+		r.Get("/v2/courses/:course_id/jobs", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseJobs)
+		r.Delete("/v2/courses/:course_id/jobs/:job_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteJob)
+		r.Get("/v2/courses/:course_id/suspensions", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseSuspensions)
+		r.Put("/v2/courses/:course_id/suspensions/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(CourseSuspension{}), PutCourseSuspension)
+		r.Delete("/v2/courses/:course_id/suspensions/:user_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseSuspension)
+		r.Get("/v2/courses/:course_id/announcements", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseAnnouncements)
+		r.Post("/v2/courses/:course_id/announcements", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Announcement{}), PostCourseAnnouncement)
+		r.Delete("/v2/courses/:course_id/announcements/:announcement_id", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseAnnouncement)
+		r.Get("/v2/courses/:course_id/email_submissions", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseEmailSubmissions)
+		r.Put("/v2/courses/:course_id/email_submissions/:email_submission_id", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(EmailSubmission{}), PutCourseEmailSubmission)
+		r.Get("/v2/courses/:course_id/usage", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseUsage)
+		r.Get("/v2/courses/:course_id/help_requests", auth, withTx, withCurrentUser, courseStaffOnly, GetCourseHelpRequests)
+		r.Put("/v2/courses/:course_id/help_requests/:help_request_id", auth, withTx, withCurrentUser, courseStaffOnly, binding.Json(HelpRequest{}), PutCourseHelpRequest)
+		r.Get("/v2/courses/:course_id/broadcast", auth, withTx, withCurrentUser, courseMemberOnly, GetCourseBroadcast)
+		r.Put("/v2/courses/:course_id/broadcast", auth, withTx, withCurrentUser, courseInstructorOnly, binding.Json(Broadcast{}), PutCourseBroadcast)
+		r.Delete("/v2/courses/:course_id/broadcast", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseBroadcast)
+		r.Get("/v2/courses/:course_id/at_risk", auth, withTx, withCurrentUser, courseInstructorOnly, GetCourseAtRisk)
+		r.Put("/v2/courses/:course_id/at_risk/digest", auth, withTx, withCurrentUser, courseInstructorOnly, PutCourseAtRiskDigest)
+		r.Delete("/v2/courses/:course_id/at_risk/digest", auth, withTx, withCurrentUser, courseInstructorOnly, DeleteCourseAtRiskDigest)
+		r.Put("/v2/courses/:course_id/quota", auth, withTx, withCurrentUser, administratorOnly, binding.Json(CourseQuota{}), PutCourseQuota)
+		r.Delete("/v2/courses/:course_id/quota", auth, withTx, withCurrentUser, administratorOnly, DeleteCourseQuota)
+		r.Get("/v2/usage", auth, withTx, withCurrentUser, administratorOnly, GetUsage)
 
 		// users
 		r.Get("/v2/users", auth, withTx, withCurrentUser, GetUsers)
 		r.Get("/v2/users/me", auth, withTx, withCurrentUser, GetUserMe)
 		r.Get("/v2/users/me/cookie", auth, GetUserMeCookie)
+		r.Get("/v2/users/me/assignments", auth, withTx, withCurrentUser, GetUserMeAssignments)
+		r.Get("/v2/users/me/announcements", auth, withTx, withCurrentUser, GetUserMeAnnouncements)
+		r.Post("/v2/users/me/announcements/:announcement_id/acknowledge", auth, withTx, withCurrentUser, PostUserMeAnnouncementAcknowledge)
+		r.Get("/v2/users/me/archive", auth, withTx, withCurrentUser, GetUserMeArchive)
+		r.Get("/v2/users/me/archive/:assignment_id", auth, withTx, withCurrentUser, GetUserMeArchiveAssignment)
+		r.Get("/v2/users/me/export", auth, withTx, withCurrentUser, GetUserMeExport)
+		r.Get("/v2/users/me/email_gateway", auth, withTx, withCurrentUser, GetUserMeEmailGateway)
+		r.Get("/v2/users/me/email_submissions", auth, withTx, withCurrentUser, GetUserMeEmailSubmissions)
+		r.Get("/v2/users/me/help_requests", auth, withTx, withCurrentUser, GetUserMeHelpRequests)
+		r.Delete("/v2/users/me/help_requests/:help_request_id", auth, withTx, withCurrentUser, DeleteUserMeHelpRequest)
+		r.Post("/v2/email_gateway", withTx, PostEmailGateway)
+
+		// discovery index of schools and their servers
+		r.Get("/v2/institutions", withTx, GetInstitutions)
+		r.Post("/v2/institutions", withTenantNoTx, binding.Json(Institution{}), PostInstitution)
+		r.Get("/v2/institutions/registrations", auth, withTx, withCurrentUser, administratorOnly, GetInstitutionRegistrations)
+		r.Put("/v2/institutions/:institution_id", auth, withTx, withCurrentUser, administratorOnly, binding.Json(Institution{}), PutInstitution)
+		r.Delete("/v2/institutions/:institution_id", auth, withTx, withCurrentUser, administratorOnly, DeleteInstitution)
+		r.Get("/workspace/:assignment_id", auth, withTx, withCurrentUser, GetWorkspace)
+		r.Get("/progress", auth, withTx, withCurrentUser, GetProgress)
+		r.Get("/progress/:assignment_id", auth, withTx, withCurrentUser, GetProgressAssignment)
+		r.Get("/watch/:share_id", auth, withTx, withCurrentUser, GetWatch)
+		r.Get("/activity/:course_id/:problem_set_id", auth, withTx, withCurrentUser, courseInstructorOnly, GetActivityPage)
+		r.Get("/broadcast/:course_id", auth, withTx, withCurrentUser, courseMemberOnly, GetBroadcastPage)
+		r.Get("/help_queue/:course_id", auth, withTx, withCurrentUser, courseStaffOnly, GetHelpQueuePage)
 		r.Get("/v2/users/:user_id", auth, withTx, withCurrentUser, GetUser)
 		r.Get("/v2/courses/:course_id/users", auth, withTx, withCurrentUser, GetCourseUsers)
 		r.Delete("/v2/users/:user_id", auth, withTx, withCurrentUser, administratorOnly, DeleteUser)
@@ -279,6 +701,18 @@ func main() {
 		r.Get("/v2/users/:user_id/assignments", auth, withTx, withCurrentUser, GetUserAssignments)
 		r.Get("/v2/courses/:course_id/users/:user_id/assignments", auth, withTx, withCurrentUser, GetCourseUserAssignments)
 		r.Get("/v2/assignments/:assignment_id", auth, withTx, withCurrentUser, GetAssignment)
+		r.Get("/v2/assignments/:assignment_id/mastery_streaks", auth, withTx, withCurrentUser, GetAssignmentMasteryStreaks)
+		r.Get("/v2/assignments/:assignment_id/seal", auth, withTx, withCurrentUser, GetAssignmentSeal)
+		r.Get("/v2/assignments/:assignment_id/rubric", auth, withTx, withCurrentUser, GetAssignmentRubric)
+		r.Get("/v2/assignments/:assignment_id/submission_records", auth, withTx, withCurrentUser, GetAssignmentSubmissionRecords)
+		r.Get("/v2/assignments/:assignment_id/report", auth, withTx, withCurrentUser, GetAssignmentReport)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/solution", auth, withTx, withCurrentUser, GetAssignmentProblemSolution)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/exemplars", auth, withTx, withCurrentUser, GetAssignmentProblemExemplars)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/reflections", auth, withTx, withCurrentUser, GetAssignmentProblemReflections)
+		r.Put("/v2/assignments/:assignment_id/problems/:problem_id/reflections", auth, withTx, withCurrentUser, binding.Json(ReflectionAnswers{}), PutAssignmentProblemReflections)
+		r.Put("/v2/assignments/:assignment_id/problems/:problem_id/exemplar_consent", auth, withTx, withCurrentUser, PutExemplarConsent)
+		r.Delete("/v2/assignments/:assignment_id/problems/:problem_id/exemplar_consent", auth, withTx, withCurrentUser, DeleteExemplarConsent)
+		r.Post("/v2/assignments/:assignment_id/help_requests", auth, withTx, withCurrentUser, binding.Json(HelpRequest{}), PostAssignmentHelpRequest)
 		r.Delete("/v2/assignments/:assignment_id", auth, withTx, withCurrentUser, administratorOnly, DeleteAssignment)
 
 		// commits
@@ -289,31 +723,112 @@ func main() {
 		// commit bundles
 		r.Post("/v2/commit_bundles/unsigned", auth, withTx, withCurrentUser, binding.Json(CommitBundle{}), PostCommitBundlesUnsigned)
 		r.Post("/v2/commit_bundles/signed", auth, withTx, withCurrentUser, binding.Json(CommitBundle{}), PostCommitBundlesSigned)
+
+		// submissions
+		r.Post("/v2/submissions", auth, withTx, withCurrentUser, binding.Json(CommitBundle{}), PostSubmission)
+		r.Get("/v2/submissions", auth, withTx, withCurrentUser, GetSubmissions)
+		r.Get("/v2/submissions/:submission_id", auth, withCurrentUserNoTx, GetSubmission)
+		r.Post("/v2/sealed_submissions", auth, withTx, withCurrentUser, binding.Json(SealedSubmission{}), PostSealedSubmission)
+
+		// lab codes
+		r.Post("/v2/lab_redemptions", auth, withTx, withCurrentUser, binding.Json(LabCode{}), PostLabRedemption)
+
+		// public key for checking signed grade reports
+		r.Get("/v2/report_signing_key", GetReportSigningKey)
+
+		// toolchain images expected on every daycare
+		r.Get("/v2/toolchain_images", daycareSignedOnly, withTx, GetToolchainImages)
+		r.Get("/v2/dependency_images", daycareSignedOnly, withTx, GetDependencyImages)
+
+		// grading jobs running on the daycare
+		r.Get("/v2/jobs", auth, withTx, withCurrentUser, administratorOnly, GetJobs)
+		r.Delete("/v2/jobs/:job_id", auth, withTx, withCurrentUser, administratorOnly, DeleteJob)
+
+		// submission by git push
+		if Config().GitRoot != "" {
+			r.Get("/git/:assignment_id/**", gitChallenge, auth, withCurrentUserNoTx, ServeGit)
+			r.Post("/git/:assignment_id/**", gitChallenge, auth, withCurrentUserNoTx, ServeGit)
+		}
 	}
 
 	// set up daycare role
 	if daycare {
 		// make sure relevant secrets are included in config file
-		if Config.DaycareSecret == "" {
+		if Config().DaycareSecret == "" {
 			log.Fatalf("cannot run with no DaycareSecret in the config file")
 		}
 
-		// attach to docker and try a ping
-		var err error
-		dockerClient, err = docker.NewVersionedClient("unix:///var/run/docker.sock", "1.18")
-		if err != nil {
-			log.Fatalf("NewVersionedClient: %v", err)
-		}
-		if err = dockerClient.Ping(); err != nil {
-			log.Fatalf("Ping: %v", err)
+		healthRoles = append(healthRoles, "daycare")
+		switch Config().GradingBackend {
+		case backendDocker, backendPodman:
+			mustConnectDocker()
+			healthProbes = append(healthProbes, &healthProbe{Name: "docker", Probe: probeDocker})
+			healthProbes = append(healthProbes, &healthProbe{Name: "docker disk", Probe: probeDockerDisk})
+			if Config().ImageRegistry != "" {
+				healthProbes = append(healthProbes, &healthProbe{Name: "toolchains", Probe: probeToolchainImages})
+			}
+
+			// clean up after grading jobs
+			go pruneDockerLoop()
+
+		case backendKubernetes:
+			// the cluster pulls images and removes finished jobs itself
+			mustConnectKube()
+			healthProbes = append(healthProbes, &healthProbe{Name: "kubernetes", Probe: probeKube})
+
+		default:
+			log.Fatalf("GradingBackend must be %s, %s, or %s, not %q", backendDocker, backendPodman, backendKubernetes, Config().GradingBackend)
 		}
 
 		r.Get("/v2/sockets/:problem_type/:action", SocketProblemTypeAction)
+		r.Get("/v2/shares/:share_id", SocketShare)
+
+		// runner agents for problem types that need another platform or special hardware
+		if Config().RunnerSecret != "" {
+			r.Get(RunnerPath, SocketRunner)
+			healthProbes = append(healthProbes, &healthProbe{Name: "runners", Probe: probeRunners})
+		}
+		r.Get("/v2/toolchains", GetToolchains)
+		r.Get("/v2/toolchains/images", GetImageToolchains)
+		r.Get("/v2/daycare_jobs", daycareSignedOnly, GetDaycareJobs)
+		r.Delete("/v2/daycare_jobs/:job_id", daycareSignedOnly, DeleteDaycareJob)
 	}
 
+	// serve unchanged routes under newer API versions
+	r.MountVersions()
+
+	// set up TLS certificates for every host this instance answers to
+	if err := checkACMEConfig(Config()); err != nil {
+		log.Fatalf("%v", err)
+	}
+	certs, err := loadCertManager(Config().LetsEncryptCache)
+	if err != nil {
+		log.Fatalf("Setting up TLS certificates: %v", err)
+	}
+	var hosts []string
+	if ta {
+		hosts = append(hosts, tenantHostnames()...)
+	}
+	if daycare {
+		hosts = append(hosts, Config().DaycareHost)
+	}
+	certs.setHosts(hosts)
+	if !certs.registered() {
+		log.Printf("registering with %s", Config().ACMEDirectory)
+		if err := certs.register(Config().LetsEncryptEmail); err != nil {
+			log.Fatalf("Registering for TLS certificates: %v", err)
+		}
+	}
+	healthProbes = append(healthProbes, &healthProbe{Name: "certificates", Probe: certs.probe})
+
 	// start redirecting http calls to https
 	log.Printf("starting http -> https forwarder")
 	go http.ListenAndServe(":http", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// answer certificate authority challenges before anything else
+		if certs.serveChallenge(w, r) {
+			return
+		}
+
 		// get the address of the client
 		addr := r.Header.Get("X-Real-IP")
 		if addr == "" {
@@ -323,30 +838,21 @@ func main() {
 			}
 		}
 
-		// make sure the request is for the right host name
-		if Config.Hostname != r.Host {
+		// make sure the request is for one of our host names
+		tenant := findTenant(r.Host)
+		if tenant == nil {
 			loggedHTTPErrorf(w, http.StatusNotFound, "http request to invalid host: %s", r.Host)
 			return
 		}
 		var u url.URL = *r.URL
 		u.Scheme = "https"
-		u.Host = Config.Hostname
+		u.Host = tenant.Hostname
 		log.Printf("redirecting http request from %s to %s", addr, u.String())
 		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
 	}))
 
-	// set up letsencrypt
-	lem := letsencrypt.Manager{}
-	if err := lem.CacheFile(Config.LetsEncryptCache); err != nil {
-		log.Fatalf("Setting up LetsEncrypt: %v", err)
-	}
-	lem.SetHosts([]string{Config.Hostname})
-	if !lem.Registered() {
-		log.Printf("registering with letsencrypt")
-		if err := lem.Register(Config.LetsEncryptEmail, nil); err != nil {
-			log.Fatalf("Registering with LetsEncrypt: %v", err)
-		}
-	}
+	// keep certificates current, starting now in case some are missing
+	go certs.renewLoop()
 
 	// start the https server
 	log.Printf("accepting https connections")
@@ -355,7 +861,7 @@ func main() {
 		Handler: m,
 		TLSConfig: &tls.Config{
 			MinVersion:     tls.VersionTLS10,
-			GetCertificate: lem.GetCertificate,
+			GetCertificate: certs.GetCertificate,
 		},
 	}
 	if err := server.ListenAndServeTLS("", ""); err != nil {
@@ -363,6 +869,135 @@ func main() {
 	}
 }
 
+// mustConnectDocker attaches to docker (or podman) and tries a ping.
+func mustConnectDocker() {
+	var err error
+	dockerClient, err = docker.NewVersionedClient(containerEndpoint())
+	if err != nil {
+		log.Fatalf("NewVersionedClient: %v", err)
+	}
+	if err = dockerClient.Ping(); err != nil {
+		log.Fatalf("Ping: %v", err)
+	}
+}
+
+// loadConfig loads the config file and sets up everything that depends on it.
+func loadConfig(configFile string) {
+	config, err := readConfig(configFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	setConfig(config)
+	configPath = configFile
+	loadTenants()
+	if config.ProblemTypesDir != "" {
+		loaded, err := loadProblemTypes(config.ProblemTypesDir)
+		if err != nil {
+			log.Fatalf("failed to load problem types: %v", err)
+		}
+		setProblemTypes(loaded)
+	}
+}
+
+// readConfig sets the config defaults and then loads the config file over them.
+func readConfig(configFile string) (*serverConfig, error) {
+	// set config defaults
+	config := &serverConfig{
+		ToolName:         "CodeGrinder",
+		ToolID:           "codegrinder",
+		ToolDescription:  "Programming exercises with grading",
+		LetsEncryptCache: "/etc/codegrinder/letsencrypt.cache",
+		PostgresHost:     "/var/run/postgresql",
+		PostgresPort:     "",
+		PostgresUsername: os.Getenv("USER"),
+		PostgresPassword: "",
+		PostgresDatabase: os.Getenv("USER"),
+		DiskCheckPath:    "/",
+		DiskMinFreeMB:    1024,
+		ContainerQuotaMB: 256,
+		PruneMinutes:     60,
+		CanaryHour:       3,
+		TraceSampleRate:  1.0,
+		ArchiveDays:      365,
+		MirrorMinutes:    60,
+		ACMEDirectory:    "https://acme-v01.api.letsencrypt.org/directory",
+		ACMEChallenge:    "http-01",
+		CertWarnDays:     7,
+		GradingBackend:   "docker",
+		KubeCPU:          "500m",
+		KubeMaxMinutes:   15,
+		RiskDigestHour:   7,
+	}
+
+	// load config file
+	if raw, err := ioutil.ReadFile(configFile); err != nil {
+		return nil, fmt.Errorf("failed to load config file %q: %v", configFile, err)
+	} else if err := json.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	config.SessionSecret = unBase64(config.SessionSecret)
+	config.DaycareSecret = unBase64(config.DaycareSecret)
+	if config.DaycareHost == "" {
+		config.DaycareHost = config.Hostname
+	}
+	config.DaycareHost = bracketIPv6(config.DaycareHost)
+	if len(config.CanaryHosts) == 0 {
+		config.CanaryHosts = []string{config.DaycareHost}
+	}
+	for i, host := range config.CanaryHosts {
+		config.CanaryHosts[i] = bracketIPv6(host)
+	}
+	return config, nil
+}
+
+// bracketIPv6 puts an IPv6 address given as a host in brackets,
+// so it can be used in URLs and followed by a port.
+func bracketIPv6(host string) string {
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// loadSessionUser loads the logged-in user named by the session.
+// On failure it reports the error and returns nil.
+func loadSessionUser(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, session sessions.Session) *User {
+	// sessions are only valid for the tenant that created them
+	// note: sessions created before tenants existed belong to the default tenant
+	sessionTenant, ok := session.Get("tenant").(string)
+	if !ok {
+		sessionTenant = Config().Hostname
+	}
+	if !strings.EqualFold(sessionTenant, tenant.Hostname) {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "session belongs to %s, not %s", sessionTenant, tenant.Hostname)
+		return nil
+	}
+
+	rawID := session.Get("id")
+	if rawID == nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "cannot find user ID in session")
+		return nil
+	}
+	userID, ok := rawID.(int64)
+	if !ok {
+		session.Clear()
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error extracting user ID from session")
+		return nil
+	}
+
+	// load the user record
+	user := new(User)
+	if err := meddler.Load(tx, "users", user, userID); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d not found", userID)
+			return nil
+		}
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return nil
+	}
+	return user
+}
+
 func setupDB(host, port, user, password, database string) *sql.DB {
 	if port == "" {
 		log.Printf("connecting to database at %s", host)
@@ -429,16 +1064,51 @@ func loggedHTTPDBNotFoundError(w http.ResponseWriter, err error) {
 		status = http.StatusInternalServerError
 	}
 	log.Print(logPrefix(), msg)
-	http.Error(w, msg, status)
+	writeAPIError(w, NewAPIError(status, msg))
+}
+
+// httpErrorf returns an error to be reported with the given HTTP status.
+func httpErrorf(status int, format string, params ...interface{}) error {
+	return NewAPIError(status, fmt.Sprintf(format, params...))
+}
+
+// dbNotFoundError converts a database error into an HTTP error,
+// reporting missing rows as not found.
+func dbNotFoundError(err error) error {
+	if err == sql.ErrNoRows {
+		return httpErrorf(http.StatusNotFound, "not found")
+	}
+	return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+}
+
+// loggedHTTPError reports an error returned by a helper,
+// using its HTTP status if it has one.
+func loggedHTTPError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*APIError); ok {
+		log.Print(logPrefix() + e.Message)
+		writeAPIError(w, e)
+		return
+	}
+	loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
 }
 
 func loggedHTTPErrorf(w http.ResponseWriter, status int, format string, params ...interface{}) error {
 	msg := fmt.Sprintf(format, params...)
 	log.Print(logPrefix() + msg)
-	http.Error(w, msg, status)
+	writeAPIError(w, NewAPIError(status, msg))
 	return fmt.Errorf("%s", msg)
 }
 
+// writeAPIError sends an error response in the structured form clients expect.
+func writeAPIError(w http.ResponseWriter, e *APIError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		log.Printf("error writing error response: %v", err)
+	}
+}
+
 func loggedErrorf(f string, params ...interface{}) error {
 	log.Print(logPrefix() + fmt.Sprintf(f, params...))
 	return fmt.Errorf(f, params...)
@@ -450,7 +1120,7 @@ func parseID(w http.ResponseWriter, name, s string) (int64, error) {
 		return 0, loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing %s from URL: %v", name, err)
 	}
 	if id < 1 {
-		return 0, loggedHTTPErrorf(w, http.StatusBadRequest, "invalid ID in URL: %s must be 1 or greater", name, err)
+		return 0, loggedHTTPErrorf(w, http.StatusBadRequest, "invalid ID in URL: %s must be 1 or greater", name)
 	}
 
 	return id, nil
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func init() {
+	commands["setup"] = &serverCommand{Short: "set up a new installation: config file, database, TLS, and Docker", Run: CommandSetup, NoConfig: true}
+	commands["admin"] = &serverCommand{Short: "make an existing user an administrator, by email address", Run: CommandAdmin}
+}
+
+// setupPrompter asks questions on the terminal for CommandSetup.
+type setupPrompter struct {
+	in *bufio.Reader
+}
+
+// ask returns the answer to a question, or def if the answer is blank.
+func (p *setupPrompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil {
+		log.Fatalf("error reading answer: %v", err)
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks a yes or no question.
+func (p *setupPrompter) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		switch strings.ToLower(p.ask(question+" ("+hint+")", "")) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
+
+// newSecret returns a random secret in the form the config file expects.
+func newSecret() string {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Fatalf("error generating a secret: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// CommandSetup handles "codegrinder setup [-schema FILE]".
+// The schema comes from the server binary unless a file is given.
+// It walks through a new installation one step at a time: writing the config file
+// (keeping any settings already there), loading the database schema, registering with
+// Let's Encrypt, checking that Docker is reachable, and making the first administrator.
+// Each step can be skipped, and it is safe to run again.
+func CommandSetup(args []string) {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	var schemaFile string
+	fs.StringVar(&schemaFile, "schema", "", "Path of the database schema, instead of the one built in")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("usage: codegrinder [-config FILE] setup [-schema FILE]")
+	}
+	p := &setupPrompter{in: bufio.NewReader(os.Stdin)}
+
+	// step 1: the config file, starting from the one already there if any
+	fmt.Printf("Step 1: the config file %s\n", configPath)
+	settings := make(map[string]interface{})
+	if raw, err := ioutil.ReadFile(configPath); err == nil {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			log.Fatalf("error parsing %s: %v", configPath, err)
+		}
+		fmt.Printf("  %s already exists; its settings are the defaults below\n", configPath)
+	} else if !os.IsNotExist(err) {
+		log.Fatalf("error reading %s: %v", configPath, err)
+	}
+	setting := func(name, def string) string {
+		if s, ok := settings[name].(string); ok && s != "" {
+			return s
+		}
+		return def
+	}
+	settings["Hostname"] = p.ask("  Hostname of this server", setting("Hostname", ""))
+	settings["LetsEncryptEmail"] = p.ask("  Email address for TLS certificate notices", setting("LetsEncryptEmail", ""))
+	settings["StaticDir"] = p.ask("  Directory of static files to serve (blank for the built-in files)", setting("StaticDir", ""))
+	settings["PostgresHost"] = p.ask("  PostgreSQL host or socket directory", setting("PostgresHost", "/var/run/postgresql"))
+	settings["PostgresDatabase"] = p.ask("  PostgreSQL database", setting("PostgresDatabase", os.Getenv("USER")))
+	settings["PostgresUsername"] = p.ask("  PostgreSQL user", setting("PostgresUsername", os.Getenv("USER")))
+	for _, name := range []string{"LTISecret", "SessionSecret", "DaycareSecret"} {
+		if setting(name, "") == "" {
+			settings[name] = newSecret()
+			fmt.Printf("  generated a new %s\n", name)
+		}
+	}
+	if settings["Hostname"] == "" {
+		log.Fatalf("the hostname is required")
+	}
+	raw, err := json.MarshalIndent(settings, "", "    ")
+	if err != nil {
+		log.Fatalf("JSON error encoding config file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		log.Fatalf("error creating directory %s: %v", filepath.Dir(configPath), err)
+	}
+	if err := ioutil.WriteFile(configPath, append(raw, '\n'), 0600); err != nil {
+		log.Fatalf("error writing %s: %v", configPath, err)
+	}
+	fmt.Printf("  saved %s\n\n", configPath)
+	loadConfig(configPath)
+
+	// step 2: the database schema
+	fmt.Printf("Step 2: the database\n")
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	source := "the schema built into this server"
+	if schemaFile != "" {
+		source = "the schema from " + schemaFile
+	}
+	if tableExists(db, "users") {
+		fmt.Printf("  the schema is already loaded\n\n")
+	} else if p.confirm("  Load "+source+"?", true) {
+		schema := embeddedSchema()
+		if schemaFile != "" {
+			var err error
+			if schema, err = ioutil.ReadFile(schemaFile); err != nil {
+				log.Fatalf("error reading %s: %v", schemaFile, err)
+			}
+		}
+		if _, err := db.Exec(string(schema)); err != nil {
+			log.Fatalf("db error loading the schema: %v", err)
+		}
+		fmt.Printf("  loaded the schema\n\n")
+	} else {
+		fmt.Printf("  skipped; the server will not run until the schema is loaded\n\n")
+	}
+
+	// step 3: TLS certificates
+	fmt.Printf("Step 3: TLS certificates from %s\n", Config().ACMEDirectory)
+	if err := checkACMEConfig(Config()); err != nil {
+		log.Fatalf("%v", err)
+	}
+	certs, err := loadCertManager(Config().LetsEncryptCache)
+	if err != nil {
+		log.Fatalf("error using %s: %v", Config().LetsEncryptCache, err)
+	}
+	if certs.registered() {
+		fmt.Printf("  already registered; certificates are kept in %s\n\n", Config().LetsEncryptCache)
+	} else if p.confirm("  Register "+Config().LetsEncryptEmail+" with the certificate authority and accept its terms of service?", true) {
+		if err := certs.register(Config().LetsEncryptEmail); err != nil {
+			log.Fatalf("error registering for TLS certificates: %v", err)
+		}
+		fmt.Printf("  registered; certificates for %s are requested and renewed by the server,\n", Config().Hostname)
+		if Config().ACMEChallenge == "dns-01" {
+			fmt.Printf("  which runs %s to publish DNS records\n\n", Config().ACMEDNSHook)
+		} else {
+			fmt.Printf("  so port 80 must be reachable from the internet\n\n")
+		}
+	} else {
+		fmt.Printf("  skipped; the server will register when it starts\n\n")
+	}
+
+	// step 4: Docker or Podman, which the daycare uses to grade work
+	engine := containerEngineName()
+	fmt.Printf("Step 4: %s\n", engine)
+	client, err := docker.NewVersionedClient(containerEndpoint())
+	if err == nil {
+		err = client.Ping()
+	}
+	switch {
+	case Config().GradingBackend == backendKubernetes:
+		fmt.Printf("  skipped; grading runs on Kubernetes\n\n")
+	case err != nil && Config().GradingBackend == backendPodman:
+		fmt.Printf("  unable to reach Podman: %v\n", err)
+		fmt.Printf("  run \"systemctl --user enable --now podman.socket\" as %s to run the daycare role here\n\n", os.Getenv("USER"))
+	case err != nil:
+		fmt.Printf("  unable to reach Docker: %v\n", err)
+		fmt.Printf("  install Docker and add %s to the docker group to run the daycare role here\n\n", os.Getenv("USER"))
+	default:
+		fmt.Printf("  %s is running\n\n", engine)
+	}
+
+	// step 5: the first administrator, who must have logged in through the LMS
+	fmt.Printf("Step 5: the first administrator\n")
+	var users, admins int
+	if tableExists(db, "users") {
+		if err := db.QueryRow(`SELECT COUNT(1), COUNT(1) FILTER (WHERE admin) FROM users`).Scan(&users, &admins); err != nil {
+			log.Fatalf("db error counting users: %v", err)
+		}
+	}
+	switch {
+	case admins > 0:
+		fmt.Printf("  %d administrator%s already set up\n\n", admins, plural(admins))
+	case users > 0:
+		if email := p.ask("  Email address of the user to make an administrator (blank to skip)", ""); email != "" {
+			promoteAdmin(db, email)
+		}
+		fmt.Println()
+	default:
+		fmt.Printf("  users are created when they first launch CodeGrinder from the LMS; after you have,\n")
+		fmt.Printf("  run \"codegrinder admin you@example.edu\" to make yourself an administrator\n\n")
+	}
+
+	fmt.Printf("Done. To add CodeGrinder to a Canvas course, use:\n")
+	fmt.Printf("  configuration URL: https://%s/v2/lti/config.xml\n", Config().Hostname)
+	fmt.Printf("  consumer key:      any name for the course, e.g., cs1400\n")
+	fmt.Printf("  shared secret:     %s\n", Config().LTISecret)
+	fmt.Printf("Then start the server with: codegrinder -config %s\n", configPath)
+}
+
+// tableExists reports whether a table is in the database.
+func tableExists(db *sql.DB, table string) bool {
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+		log.Fatalf("db error checking for table %s: %v", table, err)
+	}
+	return exists
+}
+
+// promoteAdmin makes the user with the given email address an administrator.
+func promoteAdmin(db *sql.DB, email string) {
+	result, err := db.Exec(`UPDATE users SET admin = true, updated_at = now() WHERE lower(email) = lower($1)`, email)
+	if err != nil {
+		log.Fatalf("db error: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		log.Fatalf("db error: %v", err)
+	} else if n == 0 {
+		log.Fatalf("no user has the email address %s; launch CodeGrinder from the LMS as that user first", email)
+	}
+	log.Printf("%s is now an administrator", email)
+}
+
+// CommandAdmin handles "codegrinder admin EMAIL".
+func CommandAdmin(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("usage: codegrinder admin EMAIL")
+	}
+	db := setupDB(Config().PostgresHost, Config().PostgresPort, Config().PostgresUsername, Config().PostgresPassword, Config().PostgresDatabase)
+	promoteAdmin(db, args[0])
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+// shareIDLength is long enough that a live share ID cannot be guessed.
+const shareIDLength = 10
+
+// maxShareViewers is the most people that can watch one session at a time,
+// and maxBroadcastViewers is the same for a session shared with a whole class.
+const (
+	maxShareViewers     = 5
+	maxBroadcastViewers = 1000
+)
+
+// broadcastLinger is how long a finished broadcast can still be replayed,
+// so a class that checks for it a little late still sees the whole thing.
+const broadcastLinger = time.Minute
+
+// shareWriteTimeout keeps a viewer with a slow connection from holding up the session.
+const shareWriteTimeout = 10 * time.Second
+
+// daycareShares tracks the sessions on this daycare that others can watch.
+var daycareShares = struct {
+	sync.Mutex
+	sessions map[string]*sharedSession
+}{sessions: make(map[string]*sharedSession)}
+
+// sharedSession is a running session that others can watch by its ID.
+// Viewers get everything that has happened so far when they join,
+// then each event as it happens. Their input goes to the running program
+// only while the student allows it.
+type sharedSession struct {
+	ID        string
+	UserID    int64
+	Unique    string
+	ExpiresAt time.Time
+
+	sync.Mutex
+	broadcast  bool
+	input      bool
+	ended      bool
+	transcript []*EventMessage
+	viewers    map[*websocket.Conn]bool
+	stdin      chan<- string
+	handled    <-chan struct{}
+}
+
+// startSharedSession makes a session available to watch. Input from viewers is
+// sent to stdin until handled is closed.
+func startSharedSession(userID int64, unique, mode string, stdin chan<- string, handled <-chan struct{}) (*sharedSession, error) {
+	daycareShares.Lock()
+	defer daycareShares.Unlock()
+	for attempt := 0; attempt < 10; attempt++ {
+		buf := make([]byte, shareIDLength)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for i := range buf {
+			buf[i] = labCodeAlphabet[int(buf[i])%len(labCodeAlphabet)]
+		}
+		id := string(buf)
+		if _, taken := daycareShares.sessions[id]; taken {
+			continue
+		}
+		session := &sharedSession{
+			ID:        id,
+			UserID:    userID,
+			Unique:    unique,
+			ExpiresAt: time.Now().Add(MaxShareAge),
+			broadcast: mode == ShareBroadcast,
+			input:     mode == ShareInput,
+			viewers:   make(map[*websocket.Conn]bool),
+			stdin:     stdin,
+			handled:   handled,
+		}
+		daycareShares.sessions[id] = session
+		log.Printf("user %d is sharing a session for %s as %s", userID, unique, id)
+		return session, nil
+	}
+	return nil, fmt.Errorf("unable to find an unused share ID")
+}
+
+// findSharedSession returns the session with the given ID,
+// or nil if there is no such session or it has expired.
+func findSharedSession(id string) *sharedSession {
+	daycareShares.Lock()
+	defer daycareShares.Unlock()
+	session := daycareShares.sessions[id]
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil
+	}
+	return session
+}
+
+// finish ends the session for everyone watching it.
+func (session *sharedSession) finish() {
+	remove := func() {
+		daycareShares.Lock()
+		delete(daycareShares.sessions, session.ID)
+		daycareShares.Unlock()
+	}
+	if session.broadcast {
+		time.AfterFunc(broadcastLinger, remove)
+	} else {
+		remove()
+	}
+
+	session.Lock()
+	defer session.Unlock()
+	session.ended = true
+	for viewer := range session.viewers {
+		session.close(viewer, "the session has ended")
+	}
+}
+
+// close hangs up on a viewer. The caller must hold the session lock.
+func (session *sharedSession) close(viewer *websocket.Conn, reason string) {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	viewer.WriteControl(websocket.CloseMessage, msg, time.Now().Add(shareWriteTimeout))
+	viewer.Close()
+	delete(session.viewers, viewer)
+}
+
+// send writes a message to a viewer, hanging up if it fails.
+// The caller must hold the session lock.
+func (session *sharedSession) send(viewer *websocket.Conn, res *DaycareResponse) {
+	viewer.SetWriteDeadline(time.Now().Add(shareWriteTimeout))
+	if err := viewer.WriteJSON(res); err != nil {
+		session.close(viewer, "unable to keep up with the session")
+	}
+}
+
+// record passes an event along to everyone watching.
+func (session *sharedSession) record(event *EventMessage) {
+	session.Lock()
+	defer session.Unlock()
+	session.transcript = append(session.transcript, event)
+	expired := time.Now().After(session.ExpiresAt)
+	for viewer := range session.viewers {
+		if expired {
+			session.close(viewer, "the share has expired")
+			continue
+		}
+		session.send(viewer, &DaycareResponse{Event: event})
+	}
+}
+
+// allowInput sets whether viewers can type into the session.
+// No one can type into a broadcast.
+func (session *sharedSession) allowInput(input bool) {
+	session.Lock()
+	defer session.Unlock()
+	session.input = input && !session.broadcast
+}
+
+// join adds a viewer, catching them up on what has happened so far.
+// A broadcast that has just ended is played back in full.
+func (session *sharedSession) join(viewer *websocket.Conn) error {
+	session.Lock()
+	defer session.Unlock()
+	if session.ended && !session.broadcast {
+		return fmt.Errorf("the session has ended")
+	}
+	limit := maxShareViewers
+	if session.broadcast {
+		limit = maxBroadcastViewers
+	}
+	if len(session.viewers) >= limit {
+		return fmt.Errorf("this session already has %d viewers, which is the limit", limit)
+	}
+	session.viewers[viewer] = true
+	for _, event := range session.transcript {
+		session.send(viewer, &DaycareResponse{Event: event})
+	}
+	if session.ended && session.viewers[viewer] {
+		session.close(viewer, "the session has ended")
+	}
+	return nil
+}
+
+// leave removes a viewer.
+func (session *sharedSession) leave(viewer *websocket.Conn) {
+	session.Lock()
+	defer session.Unlock()
+	delete(session.viewers, viewer)
+}
+
+// typeInput passes input from a viewer to the running program if the student allows it.
+func (session *sharedSession) typeInput(viewer *websocket.Conn, data string) {
+	session.Lock()
+	input := session.input
+	if !input {
+		if session.viewers[viewer] && !session.broadcast {
+			session.send(viewer, &DaycareResponse{Error: "the student has not allowed viewers to type"})
+		}
+		session.Unlock()
+		return
+	}
+	session.Unlock()
+
+	select {
+	case session.stdin <- data:
+	case <-session.handled:
+	}
+}
+
+// SocketShare handles a request to /v2/shares/:share_id
+// It expects a websocket connection, and streams the shared session to it
+// as DaycareResponse objects, starting with everything that has happened so far.
+// Stdin from any DaycareRequest objects it receives is passed along to the
+// running program if the student allows it.
+func SocketShare(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	session := findSharedSession(params["share_id"])
+	if session == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "shared session %q not found; it may have ended or expired", params["share_id"])
+		return
+	}
+
+	socket, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "websocket error: %v", err)
+		return
+	}
+	defer socket.Close()
+	if err := session.join(socket); err != nil {
+		log.Printf("unable to join shared session %s: %v", session.ID, err)
+		socket.WriteJSON(&DaycareResponse{Error: err.Error()})
+		return
+	}
+	defer session.leave(socket)
+	log.Printf("viewer joined shared session %s for user %d", session.ID, session.UserID)
+
+	for {
+		req := new(DaycareRequest)
+		if err := socket.ReadJSON(req); err != nil {
+			return
+		}
+		if req.Stdin != "" {
+			session.typeInput(socket, req.Stdin)
+		}
+	}
+}
+
+// GetWatch handles /watch/:share_id requests,
+// returning a page that shows a session a student has shared as it runs.
+// Like the workspace, it loads xterm from this server.
+func GetWatch(w http.ResponseWriter, params martini.Params) {
+	if Config().WorkspaceLibDir == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the browser workspace is not set up on this server")
+		return
+	}
+	nonce, err := setPagePolicy(w)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating nonce: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := watchTemplate.Execute(w, map[string]interface{}{
+		"ShareID":     params["share_id"],
+		"DaycareHost": Config().DaycareHost,
+		"Lib":         workspaceLibPrefix,
+		"Nonce":       nonce,
+	}); err != nil {
+		loggedErrorf("error rendering watch page: %v", err)
+	}
+}
+
+var watchTemplate = template.Must(template.New("watch").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>CodeGrinder shared session {{.ShareID}}</title>
+<link rel="stylesheet" href="{{.Lib}}/xterm/css/xterm.css">
+<style>
+html, body { margin: 0; height: 100%; font-family: sans-serif; font-size: 14px; }
+body { display: flex; flex-direction: column; }
+header { padding: 6px 10px; background: #2d2d2d; color: #eee; font-weight: bold; }
+#status { padding: 4px 10px; background: #f0f0f0; border-bottom: 1px solid #ccc; min-height: 1.2em; }
+#status.error { background: #fbe3e3; color: #900; }
+#terminal { flex: 1; min-height: 0; background: #000; padding: 4px; }
+</style>
+</head>
+<body>
+<header>CodeGrinder shared session {{.ShareID}}</header>
+<div id="status">Connecting…</div>
+<div id="terminal"></div>
+<script src="{{.Lib}}/xterm/lib/xterm.js"></script>
+<script nonce="{{.Nonce}}">
+(function() {
+	'use strict';
+
+	var shareID = {{.ShareID}};
+	var daycareHost = {{.DaycareHost}};
+
+	function setStatus(msg, isError) {
+		var elt = document.getElementById('status');
+		elt.textContent = msg;
+		elt.className = isError ? 'error' : '';
+	}
+
+	var term = new Terminal({ convertEol: true, fontSize: 13 });
+	term.open(document.getElementById('terminal'));
+
+	var socket = new WebSocket('wss://' + daycareHost + '/v2/shares/' + encodeURIComponent(shareID));
+	term.onData(function(data) {
+		// the daycare only passes this along if the student allows it
+		if (socket.readyState === WebSocket.OPEN) {
+			socket.send(JSON.stringify({ stdin: data === '\r' ? '\n' : data }));
+		}
+	});
+	socket.onopen = function() {
+		setStatus('Watching; anything you type goes to the program only if the student allows it');
+		term.focus();
+	};
+	socket.onmessage = function(msg) {
+		var reply = JSON.parse(msg.data);
+		if (reply.error) {
+			setStatus(reply.error, true);
+		} else if (reply.event) {
+			var e = reply.event;
+			switch (e.event) {
+			case 'exec':
+				term.write('\x1b[36m$ ' + e.execcommand.join(' ') + '\x1b[0m\n');
+				break;
+			case 'stdin':
+				term.write('\x1b[33m' + e.streamdata + '\x1b[0m');
+				break;
+			case 'stdout':
+				term.write(e.streamdata);
+				break;
+			case 'stderr':
+				term.write('\x1b[31m' + e.streamdata + '\x1b[0m');
+				break;
+			case 'exit':
+				term.write('\x1b[36m' + e.exitstatus + '\x1b[0m\n');
+				break;
+			case 'error':
+				term.write('\x1b[31mError: ' + e.error + '\x1b[0m\n');
+				break;
+			}
+		}
+	};
+	socket.onclose = function(e) {
+		setStatus(e.reason || 'The session is no longer shared; it may have ended or expired', !e.reason);
+	};
+})();
+</script>
+</body>
+</html>
+`))
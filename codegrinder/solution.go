@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getSolutionRelease returns the solution release policy for a problem set in a course,
+// or nil if its solutions are never released.
+func getSolutionRelease(tx *sql.Tx, courseID, problemSetID int64) (*SolutionRelease, error) {
+	release := new(SolutionRelease)
+	err := meddler.QueryRow(tx, release, `SELECT * FROM solution_releases WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// getLastStepCommit returns the student's commit for the last step of a problem,
+// or nil if there is none.
+func getLastStepCommit(tx *sql.Tx, assignmentID, problemID int64) (*Commit, error) {
+	commit := new(Commit)
+	err := meddler.QueryRow(tx, commit, `SELECT * FROM commits WHERE assignment_id = $1 AND problem_id = $2 `+
+		`AND step = (SELECT MAX(step) FROM problem_steps WHERE problem_id = $2)`, assignmentID, problemID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// getPassingCommit returns the student's commit for the last step of a problem
+// if it passed, or nil if the student has not passed every step.
+func getPassingCommit(tx *sql.Tx, assignmentID, problemID int64) (*Commit, error) {
+	commit, err := getLastStepCommit(tx, assignmentID, problemID)
+	if err != nil || commit == nil {
+		return nil, err
+	}
+	if commit.ReportCard == nil || !commit.ReportCard.Passed || commit.Score != 1.0 {
+		return nil, nil
+	}
+	return commit, nil
+}
+
+// PutCourseProblemSetSolutionRelease handles /v2/courses/:course_id/problem_sets/:problem_set_id/solution_release requests,
+// setting when students in the course may download the reference solutions to the problem set.
+// The release policy is returned.
+func PutCourseProblemSetSolutionRelease(w http.ResponseWriter, tx *sql.Tx, params martini.Params, release SolutionRelease, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	var offered bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM course_problem_sets WHERE course_id = $1 AND problem_set_id = $2)`,
+		courseID, problemSetID).Scan(&offered); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !offered {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem set %d is not offered in course %d", problemSetID, courseID)
+		return
+	}
+	if release.ReleaseAt.IsZero() && !release.OnPass {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "solutions must be released at a given time, when students pass, or both")
+		return
+	}
+
+	old, err := getSolutionRelease(tx, courseID, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if old != nil {
+		if _, err := tx.Exec(`DELETE FROM solution_releases WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		release.CreatedAt = old.CreatedAt
+	} else {
+		release.CreatedAt = now
+	}
+
+	release.CourseID = courseID
+	release.ProblemSetID = problemSetID
+	release.UpdatedAt = now
+	if err := meddler.Insert(tx, "solution_releases", &release); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("solutions to problem set %d in course %d released at %v, on pass: %v", problemSetID, courseID, release.ReleaseAt, release.OnPass)
+	render.JSON(http.StatusOK, &release)
+}
+
+// DeleteCourseProblemSetSolutionRelease handles /v2/courses/:course_id/problem_sets/:problem_set_id/solution_release requests,
+// so the reference solutions to the problem set are no longer released to students in the course.
+func DeleteCourseProblemSetSolutionRelease(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM solution_releases WHERE course_id = $1 AND problem_set_id = $2`, courseID, problemSetID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetAssignmentProblemSolution handles /v2/assignments/:assignment_id/problems/:problem_id/solution requests,
+// returning the released files of the reference solution to each step of the problem.
+// If the solution has not been released to the student yet, the error says when it will be.
+func GetAssignmentProblemSolution(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, problemID := loadOwnAssignmentProblem(w, tx, params, currentUser)
+	if assignment == nil {
+		return
+	}
+	now := time.Now()
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	release, err := getSolutionRelease(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if release == nil {
+		loggedHTTPErrorf(w, http.StatusForbidden, "solutions to this assignment are not released to students")
+		return
+	}
+	released := !release.ReleaseAt.IsZero() && !now.Before(release.ReleaseAt)
+	if !released && release.OnPass {
+		commit, err := getPassingCommit(tx, assignment.ID, problem.ID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		released = commit != nil
+	}
+	if !released {
+		var msg string
+		switch {
+		case release.ReleaseAt.IsZero():
+			msg = fmt.Sprintf("the solution to %s is released when you pass every step", problem.Unique)
+		case release.OnPass:
+			msg = fmt.Sprintf("the solution to %s is released at %s or when you pass every step", problem.Unique, release.ReleaseAt.Format(time.RFC1123))
+		default:
+			msg = fmt.Sprintf("the solution to %s is released at %s", problem.Unique, release.ReleaseAt.Format(time.RFC1123))
+		}
+		loggedHTTPErrorf(w, http.StatusForbidden, "%s", msg)
+		return
+	}
+
+	solutions := []*ProblemSolution{}
+	if err := meddler.QueryAll(tx, &solutions, `SELECT * FROM problem_solutions WHERE problem_id = $1 ORDER BY step`, problem.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if len(solutions) == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no reference solution is on file for %s", problem.Unique)
+		return
+	}
+	for _, solution := range solutions {
+		for name := range solution.Files {
+			if !release.Includes(name) {
+				delete(solution.Files, name)
+			}
+		}
+	}
+	render.JSON(http.StatusOK, solutions)
+}
+
+// sealProblemReference seals the reference solution to a problem step for the daycare,
+// for actions that compare the student's program against it. It returns an empty
+// string if the action does not use the reference solution or none is on file.
+func sealProblemReference(tx *sql.Tx, problem *Problem, step int64, action, problemSignature string) (string, error) {
+	problemType, exists := problemTypes()[problem.ProblemType]
+	if !exists {
+		return "", nil
+	}
+	if elt, exists := problemType.Actions[action]; !exists || !elt.Reference {
+		return "", nil
+	}
+	solution := new(ProblemSolution)
+	err := meddler.QueryRow(tx, solution, `SELECT * FROM problem_solutions WHERE problem_id = $1 AND step = $2`, problem.ID, step)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return SealReference(Config().DaycareSecret, solution.Files, problemSignature, step)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// storyContext is the number of unchanged lines shown on each side of a change in a story.
+const storyContext = 2
+
+// storyRow is one save from the submission log with its snapshot.
+type storyRow struct {
+	RecordID  int64             `meddler:"record_id"`
+	Problem   string            `meddler:"unique_id"`
+	Step      int64             `meddler:"step"`
+	Action    string            `meddler:"action,zeroisnull"`
+	CreatedAt time.Time         `meddler:"created_at,localtime"`
+	Files     map[string]string `meddler:"files,json"`
+	Graded    bool              `meddler:"graded"`
+	Passed    bool              `meddler:"passed"`
+	Score     float64           `meddler:"score"`
+	Outcomes  map[string]int    `meddler:"outcomes,json"`
+}
+
+// GetCourseProblemSetStory handles /v2/courses/:course_id/problem_sets/:problem_set_id/stories/:user_id requests,
+// returning the story of a student's work on a problem set: every save, oldest first, with the
+// files it touched, their sizes, the lines changed since the save before it for the same problem,
+// and how the work fared when it was graded. Saves from before snapshots were kept are left out.
+// While grading is anonymous, the student is named by pseudonym in place of user ID, and the story
+// leaves out the user and assignment IDs.
+func GetCourseProblemSetStory(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	asst, anon, err := getStudentAssignment(tx, courseID, problemSetID, params["user_id"])
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	rows := []*storyRow{}
+	if err := meddler.QueryAll(tx, &rows, `SELECT submission_records.id AS record_id, problems.unique_id, submission_records.step, `+
+		`submission_records.action, submission_records.created_at, submission_snapshots.files, submission_snapshots.graded, `+
+		`submission_snapshots.passed, submission_snapshots.score, submission_snapshots.outcomes `+
+		`FROM submission_records JOIN submission_snapshots ON submission_records.id = submission_snapshots.record_id `+
+		`JOIN problems ON submission_records.problem_id = problems.id `+
+		`WHERE submission_records.assignment_id = $1 `+
+		`ORDER BY submission_records.id`, asst.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	story := buildStory(asst, rows)
+	if anon != nil {
+		story.AssignmentID = 0
+		story.UserID = 0
+	}
+	render.JSON(http.StatusOK, story)
+}
+
+// buildStory turns the saves for an assignment into a story,
+// comparing each save with the one before it for the same problem.
+func buildStory(asst *Assignment, rows []*storyRow) *SubmissionStory {
+	story := &SubmissionStory{AssignmentID: asst.ID, UserID: asst.UserID, Saves: []*StorySave{}}
+	previous := make(map[string]map[string]string)
+	for _, row := range rows {
+		save := &StorySave{
+			RecordID:  row.RecordID,
+			Problem:   row.Problem,
+			Step:      row.Step,
+			Action:    row.Action,
+			Files:     len(row.Files),
+			Graded:    row.Graded,
+			Passed:    row.Passed,
+			Score:     row.Score,
+			Outcomes:  row.Outcomes,
+			CreatedAt: row.CreatedAt,
+		}
+		before := previous[row.Problem]
+		var names []string
+		for name, contents := range row.Files {
+			save.Size += len(contents)
+			names = append(names, name)
+		}
+		for name := range before {
+			if _, exists := row.Files[name]; !exists {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			from, existed := before[name]
+			to, exists := row.Files[name]
+			if existed && exists && from == to {
+				continue
+			}
+			change := &StoryChange{Name: name, Size: len(to)}
+			switch {
+			case !existed:
+				change.Change = "added"
+			case !exists:
+				change.Change = "removed"
+			default:
+				change.Change = "changed"
+			}
+			change.Added, change.Removed, change.Diff = storyDiff(from, to)
+			save.Changes = append(save.Changes, change)
+		}
+		previous[row.Problem] = row.Files
+		story.Saves = append(story.Saves, save)
+	}
+	return story
+}
+
+// storyDiff compares two versions of a file line by line. It returns the number
+// of lines added and removed, and the changed lines with a little context.
+func storyDiff(from, to string) (added, removed int, diff string) {
+	dmp := diffmatchpatch.New()
+	a, b, lines := dmp.DiffLinesToChars(from, to)
+	chunks := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lines)
+
+	var out strings.Builder
+	for i, chunk := range chunks {
+		text := strings.Split(strings.TrimSuffix(chunk.Text, "\n"), "\n")
+		switch chunk.Type {
+		case diffmatchpatch.DiffInsert:
+			added += len(text)
+			for _, line := range text {
+				out.WriteString("+" + line + "\n")
+			}
+		case diffmatchpatch.DiffDelete:
+			removed += len(text)
+			for _, line := range text {
+				out.WriteString("-" + line + "\n")
+			}
+		case diffmatchpatch.DiffEqual:
+			// show the lines just after the change before and just before the change after
+			head, tail := 0, 0
+			if i > 0 {
+				head = storyContext
+			}
+			if i < len(chunks)-1 {
+				tail = storyContext
+			}
+			if head+tail >= len(text) {
+				head, tail = len(text), 0
+			}
+			for _, line := range text[:head] {
+				out.WriteString(" " + line + "\n")
+			}
+			if head+tail < len(text) {
+				out.WriteString("…\n")
+			}
+			for _, line := range text[len(text)-tail:] {
+				out.WriteString(" " + line + "\n")
+			}
+		}
+	}
+	diff = out.String()
+	if len(diff) > MaxDetailsLen {
+		diff = diff[:MaxDetailsLen] + "\n[TRUNCATED]\n"
+	}
+	return added, removed, diff
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// submissionPollInterval is how often the TA looks for queued submissions.
+	submissionPollInterval = 5 * time.Second
+
+	// submissionStaleAge is how long a submission can be in the grading state
+	// before it is assumed that the grader died and it is queued again.
+	submissionStaleAge = 15 * time.Minute
+
+	// maxSubmissionWait is the longest a client can wait for a submission to finish.
+	maxSubmissionWait = 60 * time.Second
+
+	// daycareDownNote explains a submission that is queued because no daycare is answering.
+	daycareDownNote = "waiting for the grading service to come back"
+)
+
+// PostSubmission handles requests to /v2/submissions,
+// saving an unsigned commit and queuing it to be graded in the background.
+// The new submission is returned immediately.
+func PostSubmission(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, span *traceSpan, currentUser *User, bundle CommitBundle, render render.Render) {
+	now := time.Now()
+
+	if bundle.Commit == nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must include a commit object")
+		return
+	}
+	if len(bundle.CommitSignature) != 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must not include commit signature")
+		return
+	}
+	if bundle.Commit.Action != "grade" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "only commits with the grade action can be submitted, found %q", bundle.Commit.Action)
+		return
+	}
+	bundle.Commit.Transcript = []*EventMessage{}
+	bundle.Commit.ReportCard = nil
+	bundle.Commit.Score = 0.0
+	bundle.Commit.CreatedAt = now
+	bundle.Commit.UpdatedAt = now
+	signed, err := saveCommitBundle(now, tx, tenant, currentUser, &bundle, span)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+
+	submission, err := queueSubmission(now, tx, currentUser, signed.Commit)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, submission)
+}
+
+// queueSubmission queues a saved commit to be graded in the background.
+func queueSubmission(now time.Time, tx *sql.Tx, user *User, commit *Commit) (*Submission, error) {
+	submission := &Submission{
+		UserID:       user.ID,
+		AssignmentID: commit.AssignmentID,
+		ProblemID:    commit.ProblemID,
+		Step:         commit.Step,
+		CommitID:     commit.ID,
+		Files:        commit.Files,
+		Status:       "queued",
+		Note:         "waiting to be graded",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if !daycareAvailable(Config().DaycareHost) {
+		submission.Note = daycareDownNote
+	}
+	if err := meddler.Insert(tx, "submissions", submission); err != nil {
+		return nil, err
+	}
+	log.Printf("submission %d queued for user %d (%s) problem %d step %d",
+		submission.ID, user.ID, user.Name, submission.ProblemID, submission.Step)
+	return submission, nil
+}
+
+// snapshotCommits turns a snapshot of a student's files, such as a Git repository,
+// into a commit to grade for each problem in the assignment. Like grind, a problem
+// set with several problems has a directory for each one. Each commit is for the
+// first step the student has not passed, and problems with no files are skipped.
+func snapshotCommits(now time.Time, tx *sql.Tx, asst *Assignment, files map[string]string, note string) ([]*Commit, error) {
+	problems := []*Problem{}
+	if err := meddler.QueryAll(tx, &problems, `SELECT problems.* FROM problems `+
+		`JOIN problem_set_problems ON problems.id = problem_set_problems.problem_id `+
+		`WHERE problem_set_problems.problem_set_id = $1 ORDER BY problems.unique_id`, asst.ProblemSetID); err != nil {
+		return nil, err
+	}
+
+	var commits []*Commit
+	for _, problem := range problems {
+		mine := files
+		if len(problems) > 1 {
+			mine = make(map[string]string)
+			prefix := problem.Unique + "/"
+			for name, contents := range files {
+				if strings.HasPrefix(name, prefix) {
+					mine[strings.TrimPrefix(name, prefix)] = contents
+				}
+			}
+		}
+		if len(mine) == 0 {
+			continue
+		}
+
+		var steps int64
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM problem_steps WHERE problem_id = $1`, problem.ID).Scan(&steps); err != nil {
+			return nil, err
+		}
+		step := int64(1)
+		for _, score := range asst.RawScores[problem.Unique] {
+			if score != 1.0 || step >= steps {
+				break
+			}
+			step++
+		}
+
+		commits = append(commits, &Commit{
+			AssignmentID: asst.ID,
+			ProblemID:    problem.ID,
+			Step:         step,
+			Action:       "grade",
+			Note:         note,
+			Files:        mine,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		})
+	}
+	return commits, nil
+}
+
+// GetSubmissions handles requests to /v2/submissions,
+// returning the current user's most recent submissions, newest first.
+//
+// If parameter limit=<...> present, at most that many submissions are returned (default 20).
+func GetSubmissions(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	limit := 20
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	submissions := []*Submission{}
+	if err := meddler.QueryAll(tx, &submissions, `SELECT * FROM submissions WHERE user_id = $1 ORDER BY id DESC LIMIT $2`,
+		currentUser.ID, limit); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, submissions)
+}
+
+// GetSubmission handles requests to /v2/submissions/:submission_id,
+// returning a single submission.
+//
+// If parameter wait=<seconds> is present and the submission is still queued or grading,
+// the request waits up to that long (at most 60 seconds) for it to finish before returning.
+// Each check uses its own short transaction, so a waiting client does not hold one open.
+func GetSubmission(w http.ResponseWriter, r *http.Request, db *sql.DB, tenant *TenantConfig, params martini.Params, currentUser *User, render render.Render) {
+	submissionID, err := parseID(w, "submission_id", params["submission_id"])
+	if err != nil {
+		return
+	}
+	wait := time.Duration(0)
+	if s := r.FormValue("wait"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "wait must be a number of seconds")
+			return
+		}
+		wait = time.Duration(n) * time.Second
+		if wait > maxSubmissionWait {
+			wait = maxSubmissionWait
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	submission := new(Submission)
+	for {
+		err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+			return meddler.QueryRow(tx, submission, `SELECT * FROM submissions WHERE id = $1 AND user_id = $2`,
+				submissionID, currentUser.ID)
+		})
+		if err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		if submission.IsFinished() || time.Now().Add(time.Second).After(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	render.JSON(http.StatusOK, submission)
+}
+
+// gradeSubmissionsLoop grades queued submissions in the background for every tenant.
+func gradeSubmissionsLoop(db *sql.DB) {
+	for {
+		for _, tenant := range tenants() {
+			// leave submissions queued until the daycare answers again
+			if !daycareAvailable(Config().DaycareHost) {
+				break
+			}
+			for {
+				found, err := gradeNextSubmission(db, tenant)
+				if err != nil {
+					log.Printf("error grading submission for %s: %v", tenant.Hostname, err)
+				}
+				if !found {
+					break
+				}
+			}
+		}
+		time.Sleep(submissionPollInterval)
+	}
+}
+
+// gradeNextSubmission claims the oldest queued submission for a tenant and grades it.
+// It reports whether a submission was found.
+func gradeNextSubmission(db *sql.DB, tenant *TenantConfig) (bool, error) {
+	now := time.Now()
+	submission, user := new(Submission), new(User)
+	var signed *CommitBundle
+	found := false
+
+	// claim a submission and sign its commit for the daycare
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		err := meddler.QueryRow(tx, submission, `SELECT * FROM submissions `+
+			`WHERE status = 'queued' OR (status = 'grading' AND updated_at < $1) `+
+			`ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED`,
+			now.Add(-submissionStaleAge))
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		submission.Status = "grading"
+		submission.Note = "grading in progress"
+		submission.UpdatedAt = now
+		if err := meddler.Save(tx, "submissions", submission); err != nil {
+			return err
+		}
+
+		if err := meddler.Load(tx, "users", user, submission.UserID); err != nil {
+			return err
+		}
+		commit := new(Commit)
+		if err := meddler.Load(tx, "commits", commit, submission.CommitID); err != nil {
+			return err
+		}
+
+		// grade the files as submitted, even if the commit has been saved over since
+		commit.Step = submission.Step
+		commit.Files = submission.Files
+		commit.Action = "grade"
+		commit.Transcript = []*EventMessage{}
+		commit.ReportCard = nil
+		commit.Score = 0.0
+		commit.UpdatedAt = now
+		signed, err = saveCommitBundle(now, tx, tenant, user, &CommitBundle{Commit: commit}, nil)
+		return err
+	})
+	if err != nil || !found {
+		if found {
+			failSubmission(db, tenant, submission, err)
+		}
+		return found, err
+	}
+
+	// grade it
+	log.Printf("grading submission %d for user %d (%s)", submission.ID, user.ID, user.Name)
+	span := startTrace("grade submission", spanInternal)
+	span.set("submission.id", submission.ID)
+	span.set("user.id", user.ID)
+	defer span.finish()
+	graded, err := runDaycareBundle(signed, span)
+	if err != nil {
+		span.fail(err)
+		if _, ok := err.(*daycareUnavailableError); ok {
+			requeueSubmission(db, tenant, submission, err)
+			return false, err
+		}
+		failSubmission(db, tenant, submission, err)
+		return true, err
+	}
+
+	// record the result and post the grade
+	err = withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		toSave := &CommitBundle{Imports: graded.Imports, Matrix: graded.Matrix, Commit: graded.Commit, CommitSignature: graded.CommitSignature}
+		saved, err := saveCommitBundle(time.Now(), tx, tenant, user, toSave, span)
+		if err != nil {
+			return err
+		}
+		submission.Status = "done"
+		submission.Score = saved.Commit.Score
+		submission.ReportCard = saved.Commit.ReportCard
+		submission.Note = "graded"
+		if saved.Commit.ReportCard != nil {
+			submission.Note = saved.Commit.ReportCard.Note
+		}
+		submission.UpdatedAt = time.Now()
+		return meddler.Save(tx, "submissions", submission)
+	})
+	if err != nil {
+		span.fail(err)
+		failSubmission(db, tenant, submission, err)
+		return true, err
+	}
+	log.Printf("submission %d graded: score %.2f", submission.ID, submission.Score)
+	reportGitHubStatus(db, tenant, submission.ID)
+	return true, nil
+}
+
+// requeueSubmission puts a submission back in the queue when no daycare could grade it.
+func requeueSubmission(db *sql.DB, tenant *TenantConfig, submission *Submission, cause error) {
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		_, err := tx.Exec(`UPDATE submissions SET status = 'queued', note = $1, updated_at = $2 WHERE id = $3`,
+			daycareDownNote, time.Now(), submission.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("error returning submission %d to the queue: %v", submission.ID, err)
+		failSubmission(db, tenant, submission, cause)
+	}
+}
+
+func failSubmission(db *sql.DB, tenant *TenantConfig, submission *Submission, cause error) {
+	err := withTenantTx(db, tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+		_, err := tx.Exec(`UPDATE submissions SET status = 'failed', note = $1, updated_at = $2 WHERE id = $3`,
+			fmt.Sprintf("grading failed: %v", cause), time.Now(), submission.ID)
+		return err
+	})
+	if err != nil {
+		log.Printf("error recording failure of submission %d: %v", submission.ID, err)
+	}
+	reportGitHubStatus(db, tenant, submission.ID)
+}
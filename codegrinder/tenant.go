@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/lib/pq"
+)
+
+// TenantConfig holds the settings for one tenant (an institution or department)
+// hosted by this installation. Each tenant is identified by the hostname used to
+// reach the server, and its data lives in a separate Postgres schema so that
+// queries from one tenant can never see another tenant's users, courses, or problems.
+//
+// The top-level Config fields define the default tenant, whose data lives in
+// the default search path. Additional tenants are listed in Config.Tenants.
+type TenantConfig struct {
+	Hostname        string // Hostname that identifies this tenant: "cs.example.edu"
+	Schema          string // Postgres schema holding this tenant's data: "cs"
+	LTISecret       string // LTI authentication shared secret for this tenant: "asdf..."
+	ToolName        string // LTI human readable name: "CS Grinder"
+	ToolID          string // LTI unique ID: "csgrinder"
+	ToolDescription string // LTI description: "Programming exercises for the CS department"
+}
+
+// currentTenants maps hostnames to tenants. It is populated by loadTenants,
+// and replaced as a whole when the config is reloaded.
+var currentTenants atomic.Value
+
+// tenants returns the map of hostnames to tenants. It must not be changed.
+func tenants() map[string]*TenantConfig {
+	built, _ := currentTenants.Load().(map[string]*TenantConfig)
+	return built
+}
+
+// loadTenants builds the tenant map from the config file,
+// filling in missing fields from the default tenant.
+func loadTenants() {
+	built, err := buildTenants(Config())
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	currentTenants.Store(built)
+}
+
+// buildTenants returns the tenant map for a configuration.
+func buildTenants(config *serverConfig) (map[string]*TenantConfig, error) {
+	built := make(map[string]*TenantConfig)
+	built[strings.ToLower(config.Hostname)] = &TenantConfig{
+		Hostname:        config.Hostname,
+		LTISecret:       config.LTISecret,
+		ToolName:        config.ToolName,
+		ToolID:          config.ToolID,
+		ToolDescription: config.ToolDescription,
+	}
+	for _, tenant := range config.Tenants {
+		if tenant.Hostname == "" || tenant.Schema == "" {
+			return nil, fmt.Errorf("each tenant must have a Hostname and a Schema in the config file")
+		}
+		if tenant.LTISecret == "" {
+			return nil, fmt.Errorf("tenant %s has no LTISecret in the config file", tenant.Hostname)
+		}
+		if tenant.ToolName == "" {
+			tenant.ToolName = config.ToolName
+		}
+		if tenant.ToolID == "" {
+			tenant.ToolID = config.ToolID
+		}
+		if tenant.ToolDescription == "" {
+			tenant.ToolDescription = config.ToolDescription
+		}
+		key := strings.ToLower(tenant.Hostname)
+		if _, exists := built[key]; exists {
+			return nil, fmt.Errorf("tenant hostname %s is listed more than once in the config file", tenant.Hostname)
+		}
+		built[key] = tenant
+	}
+	return built, nil
+}
+
+// defaultTenant returns the tenant defined by the top-level Config fields.
+// Data shared by the whole server, such as the toolchain images, is kept with it.
+func defaultTenant() *TenantConfig {
+	return tenants()[strings.ToLower(Config().Hostname)]
+}
+
+// findTenant returns the tenant for the host named in a request, or nil if none matches.
+func findTenant(host string) *TenantConfig {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return tenants()[strings.ToLower(strings.Trim(host, "[]"))]
+}
+
+// tenantHostnames returns the hostnames of all tenants.
+func tenantHostnames() []string {
+	var hosts []string
+	for _, tenant := range tenants() {
+		hosts = append(hosts, tenant.Hostname)
+	}
+	return hosts
+}
+
+// mustFindTenant looks up the tenant for a request, reporting an error if it is unknown.
+func mustFindTenant(w http.ResponseWriter, r *http.Request) *TenantConfig {
+	tenant := findTenant(r.Host)
+	if tenant == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no tenant found for host %s", r.Host)
+		return nil
+	}
+	return tenant
+}
+
+// setTenantSearchPath restricts a transaction to the tenant's schema.
+// The default tenant uses the database's default search path.
+func setTenantSearchPath(tx *sql.Tx, tenant *TenantConfig) error {
+	if tenant.Schema == "" {
+		return nil
+	}
+	_, err := tx.Exec(`SET LOCAL search_path TO ` + pq.QuoteIdentifier(tenant.Schema))
+	return err
+}
+
+// forEachTenant runs a background job once for each tenant,
+// each in its own transaction restricted to that tenant's schema.
+// A failure in one tenant does not prevent the job from running in the others;
+// the last error encountered is returned.
+func forEachTenant(db *sql.DB, job func(tx *sql.Tx, tenant *TenantConfig) error) error {
+	var lastErr error
+	for _, tenant := range tenants() {
+		if err := withTenantTx(db, tenant, job); err != nil {
+			log.Printf("background job failed for tenant %s: %v", tenant.Hostname, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// withTenantTx runs a background job in a transaction restricted to the tenant's schema,
+// committing if the job succeeds and rolling back otherwise.
+func withTenantTx(db *sql.DB, tenant *TenantConfig, job func(tx *sql.Tx, tenant *TenantConfig) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = setTenantSearchPath(tx, tenant); err == nil {
+		err = job(tx, tenant)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
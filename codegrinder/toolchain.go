@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// toolchainCheckInterval is how often the TA asks the daycare for its current toolchains.
+const toolchainCheckInterval = 6 * time.Hour
+
+// inspectToolchain identifies the image currently installed under the given name.
+func inspectToolchain(image string) (*Toolchain, error) {
+	if Config().GradingBackend == backendKubernetes {
+		return inspectKubeToolchain(image)
+	}
+	info, err := dockerClient.InspectImage(image)
+	if err != nil {
+		return nil, err
+	}
+	toolchain := &Toolchain{Image: image, ImageID: info.ID}
+	if info.Config != nil {
+		toolchain.Version = info.Config.Labels["version"]
+	}
+	return toolchain, nil
+}
+
+// GetToolchains handles /v2/toolchains requests on the daycare,
+// returning the toolchain currently installed for each problem type.
+func GetToolchains(w http.ResponseWriter, render render.Render) {
+	toolchains := make(map[string]*Toolchain)
+	for name, problemType := range problemTypes() {
+		toolchain, err := inspectToolchain(problemType.Image)
+		if err != nil {
+			log.Printf("unable to identify toolchain for problem type %s: %v", name, err)
+			continue
+		}
+		toolchains[name] = toolchain
+	}
+	render.JSON(http.StatusOK, toolchains)
+}
+
+// GetImageToolchains handles /v2/toolchains/images requests on the daycare,
+// returning the toolchain currently installed under each image name given
+// in the image parameter (which may be repeated), such as images courses use
+// in place of a problem type's own. Images that are not installed are left out.
+func GetImageToolchains(w http.ResponseWriter, r *http.Request, render render.Render) {
+	r.ParseForm()
+	toolchains := make(map[string]*Toolchain)
+	for _, image := range r.Form["image"] {
+		toolchain, err := inspectToolchain(image)
+		if err != nil {
+			log.Printf("unable to identify toolchain for image %s: %v", image, err)
+			continue
+		}
+		toolchains[image] = toolchain
+	}
+	render.JSON(http.StatusOK, toolchains)
+}
+
+// saveProblemSolutions records the reference solutions for a problem that has just
+// been confirmed by the daycare, along with the toolchain it was validated against.
+func saveProblemSolutions(tx *sql.Tx, problem *Problem, commits []*Commit, now time.Time) error {
+	if _, err := tx.Exec(`DELETE FROM problem_solutions WHERE problem_id = $1`, problem.ID); err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		solution := &ProblemSolution{ProblemID: problem.ID, Step: commit.Step, Files: commit.Files}
+		if err := meddler.Insert(tx, "problem_solutions", solution); err != nil {
+			return err
+		}
+	}
+
+	// every step must have been graded by the same toolchain
+	var toolchain *Toolchain
+	for _, commit := range commits {
+		if commit.ReportCard == nil || commit.ReportCard.Toolchain == nil {
+			return nil
+		}
+		if toolchain != nil && *toolchain != *commit.ReportCard.Toolchain {
+			return nil
+		}
+		toolchain = commit.ReportCard.Toolchain
+	}
+	if toolchain == nil {
+		return nil
+	}
+	return saveProblemValidation(tx, &ProblemValidation{
+		ProblemID:   problem.ID,
+		Image:       toolchain.Image,
+		ImageID:     toolchain.ImageID,
+		Version:     toolchain.Version,
+		Passed:      true,
+		Note:        "validated when the problem was saved",
+		ValidatedAt: now,
+	})
+}
+
+func saveProblemValidation(tx *sql.Tx, validation *ProblemValidation) error {
+	if _, err := tx.Exec(`DELETE FROM problem_validations WHERE problem_id = $1 AND image_id = $2`,
+		validation.ProblemID, validation.ImageID); err != nil {
+		return err
+	}
+	return meddler.Insert(tx, "problem_validations", validation)
+}
+
+// GetProblemValidations handles /v2/problems/:problem_id/validations requests,
+// returning the toolchains the problem has been validated against, most recent first.
+func GetProblemValidations(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+
+	validations := []*ProblemValidation{}
+	if err := meddler.QueryAll(tx, &validations, `SELECT * FROM problem_validations WHERE problem_id = $1 ORDER BY validated_at DESC`, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, validations)
+}
+
+// GetProblemCompatibility handles /v2/problem_compatibility requests,
+// returning the most recent toolchain validation of every problem.
+//
+// If parameter failing=true is present, only problems whose reference solutions
+// failed their most recent validation are included.
+func GetProblemCompatibility(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	where := ""
+	if r.FormValue("failing") == "true" {
+		where = ` WHERE NOT passed`
+	}
+
+	report := []*ProblemCompatibility{}
+	if err := meddler.QueryAll(tx, &report, `SELECT * FROM (`+
+		`SELECT DISTINCT ON (problems.id) problems.id AS problem_id, problems.unique_id, problems.problem_type, `+
+		`problem_validations.image, problem_validations.image_id, problem_validations.version, `+
+		`problem_validations.passed, problem_validations.note, problem_validations.validated_at `+
+		`FROM problems JOIN problem_validations ON problems.id = problem_validations.problem_id `+
+		`ORDER BY problems.id, problem_validations.validated_at DESC) AS latest`+
+		where+` ORDER BY unique_id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, report)
+}
+
+// checkToolchainsLoop periodically compares the toolchains installed on the daycare with
+// those each problem was last validated against, and re-runs the reference solutions
+// of any problem whose toolchain has changed.
+func checkToolchainsLoop(db *sql.DB) {
+	for {
+		if err := checkToolchains(db); err != nil {
+			log.Printf("error checking toolchain compatibility: %v", err)
+		}
+		time.Sleep(toolchainCheckInterval)
+	}
+}
+
+// toolchainJob is a single problem whose reference solutions need to be re-run.
+type toolchainJob struct {
+	Tenant    *TenantConfig
+	Problem   *Problem
+	Steps     []*ProblemStep
+	Solutions []*ProblemSolution
+	Toolchain *Toolchain
+	Override  *ProblemTypeOverride // course override whose image is being checked, if any
+	Env       string               // sealed environment, if the problem has variables
+	Host      string               // daycare to run on, if not the usual one
+}
+
+// newToolchainJob gathers what is needed to re-run the reference solutions of a problem.
+func newToolchainJob(tx *sql.Tx, tenant *TenantConfig, problem *Problem, toolchain *Toolchain) (*toolchainJob, error) {
+	job := &toolchainJob{Tenant: tenant, Problem: problem, Toolchain: toolchain}
+	if err := meddler.QueryAll(tx, &job.Steps, `SELECT * FROM problem_steps WHERE problem_id = $1 ORDER BY step`, problem.ID); err != nil {
+		return nil, err
+	}
+	if err := meddler.QueryAll(tx, &job.Solutions, `SELECT * FROM problem_solutions WHERE problem_id = $1 ORDER BY step`, problem.ID); err != nil {
+		return nil, err
+	}
+	env, err := sealProblemEnvironment(tx, problem.ID, problem.ComputeSignature(Config().DaycareSecret, job.Steps))
+	if err != nil {
+		return nil, err
+	}
+	job.Env = env
+	return job, nil
+}
+
+// getDaycareToolchains asks a daycare what toolchain it runs for each problem type.
+func getDaycareToolchains(host string) (map[string]*Toolchain, error) {
+	return fetchDaycareToolchains(&url.URL{Scheme: "https", Host: host, Path: "/v2/toolchains"})
+}
+
+// getDaycareImageToolchains asks a daycare what toolchain it has installed under each image name.
+func getDaycareImageToolchains(host string, images []string) (map[string]*Toolchain, error) {
+	if len(images) == 0 {
+		return make(map[string]*Toolchain), nil
+	}
+	return fetchDaycareToolchains(&url.URL{Scheme: "https", Host: host, Path: "/v2/toolchains/images", RawQuery: url.Values{"image": images}.Encode()})
+}
+
+func fetchDaycareToolchains(u *url.URL) (map[string]*Toolchain, error) {
+	host := u.Host
+	resp, err := http.Get(u.String())
+	if err != nil {
+		daycareFailed(host, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("daycare returned %s for %s", resp.Status, u.String())
+		daycareFailed(host, err)
+		return nil, err
+	}
+	daycareSucceeded(host)
+	current := make(map[string]*Toolchain)
+	if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+		return nil, fmt.Errorf("error decoding toolchains from daycare: %v", err)
+	}
+	return current, nil
+}
+
+func checkToolchains(db *sql.DB) error {
+	// find the images courses use in place of the problem type defaults
+	var images []string
+	seen := make(map[string]bool)
+	err := forEachTenant(db, func(tx *sql.Tx, tenant *TenantConfig) error {
+		rows, err := tx.Query(`SELECT DISTINCT image FROM problem_type_overrides WHERE image <> ''`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var image string
+			if err := rows.Scan(&image); err != nil {
+				return err
+			}
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	// find out what the daycare is running now
+	current, err := getDaycareToolchains(Config().DaycareHost)
+	if err != nil {
+		return err
+	}
+	currentImages, err := getDaycareImageToolchains(Config().DaycareHost, images)
+	if err != nil {
+		return err
+	}
+
+	// gather the problems that have not been validated against the current toolchains,
+	// including the image each course that uses the problem has chosen instead
+	var jobs []*toolchainJob
+	err = forEachTenant(db, func(tx *sql.Tx, tenant *TenantConfig) error {
+		problems := []*Problem{}
+		if err := meddler.QueryAll(tx, &problems, `SELECT * FROM problems `+
+			`WHERE EXISTS (SELECT 1 FROM problem_solutions WHERE problem_id = problems.id) `+
+			`ORDER BY id`); err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			overrides := []*ProblemTypeOverride{}
+			if err := meddler.QueryAll(tx, &overrides, `SELECT DISTINCT ON (problem_type_overrides.image) problem_type_overrides.* `+
+				`FROM problem_type_overrides JOIN course_problem_sets ON problem_type_overrides.course_id = course_problem_sets.course_id `+
+				`JOIN problem_set_problems ON course_problem_sets.problem_set_id = problem_set_problems.problem_set_id `+
+				`WHERE problem_set_problems.problem_id = $1 AND problem_type_overrides.problem_type = $2 AND problem_type_overrides.image <> '' `+
+				`ORDER BY problem_type_overrides.image, problem_type_overrides.course_id`,
+				problem.ID, problem.ProblemType); err != nil {
+				return err
+			}
+			candidates := []*toolchainJob{{Toolchain: current[problem.ProblemType]}}
+			for _, override := range overrides {
+				candidates = append(candidates, &toolchainJob{Toolchain: currentImages[override.Image], Override: override})
+			}
+			for _, candidate := range candidates {
+				if candidate.Toolchain == nil {
+					continue
+				}
+				var count int
+				if err := tx.QueryRow(`SELECT COUNT(1) FROM problem_validations WHERE problem_id = $1 AND image_id = $2`,
+					problem.ID, candidate.Toolchain.ImageID).Scan(&count); err != nil {
+					return err
+				}
+				if count > 0 {
+					continue
+				}
+				job, err := newToolchainJob(tx, tenant, problem, candidate.Toolchain)
+				if err != nil {
+					return err
+				}
+				job.Override = candidate.Override
+				jobs = append(jobs, job)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// re-run the reference solutions outside of any transaction, then record the results
+	for _, job := range jobs {
+		validation := validateProblem(job)
+		if validation.Passed {
+			log.Printf("problem %s (%d) passes with toolchain %s", job.Problem.Unique, job.Problem.ID, validation.ImageID)
+		} else {
+			log.Printf("problem %s (%d) fails with toolchain %s: %s", job.Problem.Unique, job.Problem.ID, validation.ImageID, validation.Note)
+		}
+		err := withTenantTx(db, job.Tenant, func(tx *sql.Tx, tenant *TenantConfig) error {
+			return saveProblemValidation(tx, validation)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateProblem runs each reference solution of a problem on the daycare.
+func validateProblem(job *toolchainJob) *ProblemValidation {
+	validation := &ProblemValidation{
+		ProblemID:   job.Problem.ID,
+		Image:       job.Toolchain.Image,
+		ImageID:     job.Toolchain.ImageID,
+		Version:     job.Toolchain.Version,
+		Passed:      true,
+		ValidatedAt: time.Now(),
+	}
+	span := startTrace("validate problem", spanInternal)
+	span.set("problem.unique", job.Problem.Unique)
+	span.set("toolchain.image", job.Toolchain.Image)
+	defer func() {
+		span.set("validation.passed", validation.Passed)
+		if !validation.Passed {
+			span.fail(fmt.Errorf("%s", validation.Note))
+		}
+		span.finish()
+	}()
+	if len(job.Solutions) != len(job.Steps) {
+		validation.Passed = false
+		validation.Note = fmt.Sprintf("found %d reference solutions for %d steps", len(job.Solutions), len(job.Steps))
+		return validation
+	}
+
+	problemSig := job.Problem.ComputeSignature(Config().DaycareSecret, job.Steps)
+	var notes []string
+	for _, solution := range job.Solutions {
+		now := time.Now()
+		commit := &Commit{
+			ProblemID: job.Problem.ID,
+			Step:      solution.Step,
+			Action:    "confirm",
+			Note:      "toolchain compatibility check",
+			Files:     solution.Files,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		bundle := &CommitBundle{
+			Problem:             job.Problem,
+			ProblemSteps:        job.Steps,
+			ProblemSignature:    problemSig,
+			ProblemTypeOverride: job.Override,
+			Environment:         job.Env,
+			Commit:              commit,
+		}
+		bundle.CommitSignature = commit.ComputeSignature(Config().DaycareSecret, bundle.SigningSignature(Config().DaycareSecret))
+		var graded *CommitBundle
+		var err error
+		if job.Host != "" {
+			graded, err = runDaycareBundleOn(job.Host, 0, bundle, span)
+		} else {
+			graded, err = runDaycareBundle(bundle, span)
+		}
+		switch {
+		case err != nil:
+			validation.Passed = false
+			notes = append(notes, fmt.Sprintf("step %d: %v", solution.Step, err))
+		case graded.Commit.ReportCard == nil || !graded.Commit.ReportCard.Passed || graded.Commit.Score != 1.0:
+			validation.Passed = false
+			note := "no report card"
+			if graded.Commit.ReportCard != nil {
+				note = graded.Commit.ReportCard.Note
+			}
+			notes = append(notes, fmt.Sprintf("step %d failed: %s", solution.Step, note))
+		case graded.Commit.ReportCard.Toolchain != nil && graded.Commit.ReportCard.Toolchain.ImageID != job.Toolchain.ImageID:
+			// the image changed again while we were running
+			validation.Passed = false
+			notes = append(notes, fmt.Sprintf("step %d was graded by toolchain %s", solution.Step, graded.Commit.ReportCard.Toolchain.ImageID))
+		}
+	}
+	if validation.Passed {
+		validation.Note = fmt.Sprintf("all %d step%s passed", len(job.Steps), plural(len(job.Steps)))
+	} else {
+		validation.Note = strings.Join(notes, "; ")
+	}
+	return validation
+}
+
+// runDaycareBundle sends a signed commit bundle to the daycare and waits for the graded result.
+func runDaycareBundle(bundle *CommitBundle, span *traceSpan) (*CommitBundle, error) {
+	if note := toolchainMismatch(bundle.Problem.ProblemType); note != "" {
+		return nil, fmt.Errorf("daycare is not ready for %s: %s", bundle.Problem.ProblemType, note)
+	}
+	if override := bundle.ProblemTypeOverride; override != nil {
+		if note := overrideImageMismatch(override.Image); note != "" {
+			return nil, fmt.Errorf("daycare is not ready for the course image: %s", note)
+		}
+	}
+	return runDaycareBundleOn(Config().DaycareHost, 0, bundle, span)
+}
+
+// runDaycareBundleOn sends a signed commit bundle to the given daycare and waits for the graded result.
+// The daycare runs one container at a time for each user ID, replacing any that is already running.
+func runDaycareBundleOn(host string, userID int64, bundle *CommitBundle, span *traceSpan) (graded *CommitBundle, err error) {
+	dispatch := span.child("daycare dispatch", spanClient)
+	dispatch.set("daycare.host", host)
+	dispatch.set("problem.type", bundle.Problem.ProblemType)
+	dispatch.set("commit.action", bundle.Commit.Action)
+	defer func() {
+		dispatch.fail(err)
+		dispatch.finish()
+	}()
+
+	if err := daycareAllow(host); err != nil {
+		return nil, err
+	}
+	unavailable := func(format string, args ...interface{}) error {
+		err := fmt.Errorf(format, args...)
+		daycareFailed(host, err)
+		return &daycareUnavailableError{Host: host, Err: err}
+	}
+
+	u := &url.URL{Scheme: "wss", Host: host, Path: "/v2/sockets/" + bundle.Problem.ProblemType + "/" + bundle.Commit.Action}
+	headers := make(http.Header)
+	dispatch.inject(headers)
+	socket, _, err := websocket.DefaultDialer.Dial(u.String(), headers)
+	if err != nil {
+		return nil, unavailable("error dialing %s: %v", u.String(), err)
+	}
+	defer socket.Close()
+
+	if err := socket.WriteJSON(&DaycareRequest{UserID: userID, CommitBundle: bundle}); err != nil {
+		return nil, unavailable("error writing request message: %v", err)
+	}
+	for {
+		reply := new(DaycareResponse)
+		if err := socket.ReadJSON(reply); err != nil {
+			return nil, unavailable("error reading daycare response: %v", err)
+		}
+		daycareSucceeded(host)
+		switch {
+		case reply.Error != "":
+			return nil, fmt.Errorf("daycare error: %s", reply.Error)
+		case reply.CommitBundle != nil:
+			if reply.CommitBundle.Commit == nil {
+				return nil, fmt.Errorf("daycare returned no commit")
+			}
+			sig := reply.CommitBundle.Commit.ComputeSignature(Config().DaycareSecret, reply.CommitBundle.SigningSignature(Config().DaycareSecret))
+			if sig != reply.CommitBundle.CommitSignature {
+				return nil, fmt.Errorf("daycare returned a commit with a bad signature")
+			}
+			return reply.CommitBundle, nil
+		}
+	}
+}
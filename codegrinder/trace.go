@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+)
+
+const (
+	traceFlushInterval = 5 * time.Second  // longest a finished span waits before it is exported
+	traceBatchSize     = 256              // spans exported in one request
+	traceMaxPending    = 8192             // spans held while the collector is unreachable before new ones are dropped
+	traceTimeout       = 10 * time.Second // how long to wait for the collector to accept a batch
+)
+
+// span kinds as defined by OpenTelemetry
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// traceSpan is one timed step of a trace, exported to an OpenTelemetry collector when it finishes.
+// A nil *traceSpan is valid and records nothing, which is what every span is when tracing is off
+// or the trace was not sampled, so callers never need to check.
+type traceSpan struct {
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+
+	lock       sync.Mutex
+	attributes map[string]interface{}
+	failure    string
+}
+
+// startTrace begins a new trace, subject to sampling.
+func startTrace(name string, kind int) *traceSpan {
+	if Config().TraceEndpoint == "" || rand.Float64() >= Config().TraceSampleRate {
+		return nil
+	}
+	return newSpan(NewTraceID(), "", name, kind)
+}
+
+// startRemoteSpan continues the trace named in a traceparent header,
+// or begins a new one if the header is missing or invalid.
+// A caller that chose not to sample the trace is respected.
+func startRemoteSpan(header, name string, kind int) *traceSpan {
+	traceID, parentID, sampled, ok := ParseTraceParent(header)
+	if !ok {
+		return startTrace(name, kind)
+	}
+	if Config().TraceEndpoint == "" || !sampled {
+		return nil
+	}
+	return newSpan(traceID, parentID, name, kind)
+}
+
+func newSpan(traceID, parentID, name string, kind int) *traceSpan {
+	return &traceSpan{
+		traceID:    traceID,
+		spanID:     NewSpanID(),
+		parentID:   parentID,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+}
+
+// child begins a span nested inside this one.
+func (s *traceSpan) child(name string, kind int) *traceSpan {
+	if s == nil {
+		return nil
+	}
+	return newSpan(s.traceID, s.spanID, name, kind)
+}
+
+// set records an attribute. Values should be strings, integers, floats, or booleans.
+func (s *traceSpan) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attributes[key] = value
+}
+
+// fail marks the span as failed. A nil error is ignored.
+func (s *traceSpan) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failure = err.Error()
+}
+
+// traceParent is the header value that makes a request to another service part of this span.
+func (s *traceSpan) traceParent() string {
+	if s == nil {
+		return ""
+	}
+	return FormatTraceParent(s.traceID, s.spanID, true)
+}
+
+// inject adds the trace context to the headers of an outgoing request.
+func (s *traceSpan) inject(header http.Header) {
+	if s == nil {
+		return
+	}
+	header.Set(TraceParentHeader, s.traceParent())
+}
+
+// finish ends the span and queues it for export.
+func (s *traceSpan) finish() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.end = time.Now()
+	s.lock.Unlock()
+	queueSpan(s)
+}
+
+// pendingSpans holds finished spans until they are exported in a batch.
+var pendingSpans = struct {
+	sync.Mutex
+	spans   []*traceSpan
+	flusher *time.Timer
+	dropped int
+}{}
+
+func queueSpan(s *traceSpan) {
+	pendingSpans.Lock()
+	defer pendingSpans.Unlock()
+	if len(pendingSpans.spans) >= traceMaxPending {
+		pendingSpans.dropped++
+		return
+	}
+	pendingSpans.spans = append(pendingSpans.spans, s)
+	if len(pendingSpans.spans) >= traceBatchSize {
+		go flushSpans()
+	} else if pendingSpans.flusher == nil {
+		pendingSpans.flusher = time.AfterFunc(traceFlushInterval, flushSpans)
+	}
+}
+
+// flushSpans exports every finished span. Commands that exit soon after grading
+// call it directly so their spans are not lost.
+func flushSpans() {
+	pendingSpans.Lock()
+	spans := pendingSpans.spans
+	dropped := pendingSpans.dropped
+	pendingSpans.spans = nil
+	pendingSpans.dropped = 0
+	if pendingSpans.flusher != nil {
+		pendingSpans.flusher.Stop()
+		pendingSpans.flusher = nil
+	}
+	pendingSpans.Unlock()
+
+	if dropped > 0 {
+		log.Printf("tracing: dropped %d span%s while the collector was unreachable", dropped, plural(dropped))
+	}
+	for len(spans) > 0 {
+		batch := spans
+		if len(batch) > traceBatchSize {
+			batch = batch[:traceBatchSize]
+		}
+		spans = spans[len(batch):]
+		if err := exportSpans(batch); err != nil {
+			log.Printf("tracing: error exporting %d span%s: %v", len(batch), plural(len(batch)), err)
+			return
+		}
+	}
+}
+
+// exportSpans sends spans to the collector using the OTLP/HTTP JSON encoding.
+func exportSpans(spans []*traceSpan) error {
+	endpoint := Config().TraceEndpoint
+	if endpoint == "" {
+		return nil
+	}
+	var out []interface{}
+	for _, s := range spans {
+		out = append(out, s.otlp())
+	}
+	message := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{
+					"service.name":    "codegrinder",
+					"service.version": CurrentVersion.Version,
+					"host.name":       Config().Hostname,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "codegrinder"},
+				"spans": out,
+			}},
+		}},
+	}
+	raw, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: traceTimeout}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlp renders a finished span in the OTLP JSON encoding.
+func (s *traceSpan) otlp() map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	span := map[string]interface{}{
+		"traceId":           s.traceID,
+		"spanId":            s.spanID,
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attributes),
+	}
+	if s.parentID != "" {
+		span["parentSpanId"] = s.parentID
+	}
+	if s.failure != "" {
+		span["status"] = map[string]interface{}{"code": 2, "message": s.failure}
+	}
+	return span
+}
+
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	out := []interface{}{}
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": value}
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+		}
+		out = append(out, map[string]interface{}{"key": key, "value": v})
+	}
+	return out
+}
+
+// numericPathSegment matches the IDs in a request path, which are left out of span names
+// so that requests for the same route are grouped together.
+var numericPathSegment = regexp.MustCompile(`/[0-9]+`)
+
+// traceRequests is martini middleware that records a server span for every request
+// and makes it available to handlers that want to add spans of their own.
+func traceRequests(c martini.Context, w http.ResponseWriter, r *http.Request) {
+	span := startRemoteSpan(r.Header.Get(TraceParentHeader), r.Method+" "+numericPathSegment.ReplaceAllString(r.URL.Path, "/:id"), spanServer)
+	span.set("http.method", r.Method)
+	span.set("http.target", r.URL.Path)
+	c.Map(span)
+	c.Next()
+	if rw, ok := w.(martini.ResponseWriter); ok {
+		span.set("http.status_code", rw.Status())
+		if rw.Status() >= 500 {
+			span.fail(fmt.Errorf("%s", http.StatusText(rw.Status())))
+		}
+	}
+	span.finish()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// recordGradingUsage adds a grading run to the course's usage for the day.
+func recordGradingUsage(tx *sql.Tx, courseID int64, card *ReportCard, now time.Time) error {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	seconds := card.ComputeTime().Seconds()
+	result, err := tx.Exec(`UPDATE course_usage_days SET gradings = gradings + 1, grading_seconds = grading_seconds + $1 `+
+		`WHERE course_id = $2 AND day = $3`, seconds, courseID, day)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		return nil
+	}
+	usage := &CourseUsageDay{CourseID: courseID, Day: day, Gradings: 1, GradingSeconds: seconds}
+	return meddler.Insert(tx, "course_usage_days", usage)
+}
+
+// getCourseQuota returns the quota for a course, or nil if it has none.
+func getCourseQuota(tx *sql.Tx, courseID int64) (*CourseQuota, error) {
+	quota := new(CourseQuota)
+	err := meddler.QueryRow(tx, quota, `SELECT * FROM course_quotas WHERE course_id = $1`, courseID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// getCourseUsage totals the resources used by a course. Grading is counted
+// between from and to if they are set. Storage is only measured if withStorage
+// is true, since it means adding up the size of every commit in the course.
+func getCourseUsage(tx *sql.Tx, course *Course, from, to time.Time, withStorage bool) (*CourseUsage, error) {
+	usage := &CourseUsage{CourseID: course.ID, Name: course.Name, From: from, To: to}
+	where, args := ` WHERE course_id = $1`, []interface{}{course.ID}
+	if !from.IsZero() {
+		args = append(args, from)
+		where += ` AND day >= $2`
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		where += fmt.Sprintf(` AND day < $%d`, len(args))
+	}
+	var seconds float64
+	if err := tx.QueryRow(`SELECT COALESCE(SUM(gradings), 0), COALESCE(SUM(grading_seconds), 0) FROM course_usage_days`+where, args...).
+		Scan(&usage.Gradings, &seconds); err != nil {
+		return nil, err
+	}
+	usage.GradingMinutes = seconds / 60.0
+
+	if withStorage {
+		var bytes int64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(pg_column_size(commits.files) + pg_column_size(commits.transcript) + `+
+			`pg_column_size(commits.report_card)), 0) FROM commits `+
+			`JOIN assignments ON assignments.id = commits.assignment_id WHERE assignments.course_id = $1`, course.ID).Scan(&bytes); err != nil {
+			return nil, err
+		}
+		usage.StorageMB = float64(bytes) / (1024.0 * 1024.0)
+	}
+
+	quota, err := getCourseQuota(tx, course.ID)
+	if err != nil {
+		return nil, err
+	}
+	usage.Quota = quota
+	return usage, nil
+}
+
+// checkCourseQuota returns an error if a student's work may not be graded
+// because the course has used up its quota. Instructors are not held back.
+func checkCourseQuota(tx *sql.Tx, assignment *Assignment) error {
+	if assignment.Instructor {
+		return nil
+	}
+	quota, err := getCourseQuota(tx, assignment.CourseID)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if quota == nil {
+		return nil
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, assignment.CourseID); err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	usage, err := getCourseUsage(tx, course, time.Time{}, time.Time{}, quota.StorageMB > 0)
+	if err != nil {
+		return httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if over := usage.CheckQuota(); over != "" {
+		e := NewAPIError(http.StatusForbidden, over)
+		e.Hint = "you can still save your work; let your instructor know so they can have the quota raised"
+		return e
+	}
+	return nil
+}
+
+// GetCourseUsage handles /v2/courses/:course_id/usage requests,
+// returning the grading time and storage used by a course, with warnings
+// if it is near its quota, and how its office-hours queue has been used.
+//
+// If parameters from and to are present (YYYY-MM-DD), only grading on those
+// days is counted, with to not included.
+func GetCourseUsage(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	from, to, ok := parseUsageRange(w, r)
+	if !ok {
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	usage, err := getCourseUsage(tx, course, from, to, true)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if usage.Help, err = getHelpQueueStats(tx, course.ID, from, to); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	usage.CheckQuota()
+	render.JSON(http.StatusOK, usage)
+}
+
+// GetUsage handles /v2/usage requests,
+// returning the grading time and storage used by every course, for
+// departments that charge back compute costs. It takes the same
+// from and to parameters as GetCourseUsage.
+func GetUsage(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	from, to, ok := parseUsageRange(w, r)
+	if !ok {
+		return
+	}
+	courses := []*Course{}
+	if err := meddler.QueryAll(tx, &courses, `SELECT * FROM courses ORDER BY id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	report := []*CourseUsage{}
+	for _, course := range courses {
+		usage, err := getCourseUsage(tx, course, from, to, true)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		usage.CheckQuota()
+		report = append(report, usage)
+	}
+	render.JSON(http.StatusOK, report)
+}
+
+// parseUsageRange parses the optional from and to days of a usage request.
+func parseUsageRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	var days [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := r.FormValue(name)
+		if value == "" {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "%s must be given as YYYY-MM-DD", name)
+			return time.Time{}, time.Time{}, false
+		}
+		days[i] = day
+	}
+	return days[0], days[1], true
+}
+
+// PutCourseQuota handles /v2/courses/:course_id/quota requests,
+// limiting the grading time and storage a course can use.
+// The quota is returned.
+func PutCourseQuota(w http.ResponseWriter, tx *sql.Tx, params martini.Params, quota CourseQuota, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := quota.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	now := time.Now()
+	old, err := getCourseQuota(tx, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if old != nil {
+		if _, err := tx.Exec(`DELETE FROM course_quotas WHERE course_id = $1`, courseID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		quota.CreatedAt = old.CreatedAt
+	} else {
+		quota.CreatedAt = now
+	}
+	quota.CourseID = courseID
+	quota.UpdatedAt = now
+	if err := meddler.Insert(tx, "course_quotas", &quota); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	log.Printf("course %d (%s) quota set to %.0f grading minutes and %.0f MB", course.ID, course.Name, quota.GradingMinutes, quota.StorageMB)
+	render.JSON(http.StatusOK, &quota)
+}
+
+// DeleteCourseQuota handles /v2/courses/:course_id/quota requests,
+// removing the limits on a course. Its usage is still recorded.
+func DeleteCourseQuota(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM course_quotas WHERE course_id = $1`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -98,7 +98,13 @@ func DeleteCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
 // If parameter email=<...> present, results will be filtered by case-insensitive substring match on Email field.
 // If parameter instructor=<...> present, results will be filtered matching instructor field (true or false).
 // If parameter admin=<...> present, results will be filtered matching admin field (true or false).
+// If parameter limit=<...> present, results are returned a page at a time (see parseListPage).
 func GetUsers(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	page, err := parseListPage(w, r)
+	if err != nil {
+		return
+	}
+
 	// build search terms
 	where := ""
 	args := []interface{}{}
@@ -128,23 +134,24 @@ func GetUsers(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *U
 		}
 		where, args = addWhereEq(where, args, "admin", val)
 	}
+	where, args = page.whereAfter(where, args, "users.id")
 
 	users := []*User{}
-	var err error
 
 	if currentUser.Admin {
-		err = meddler.QueryAll(tx, &users, `SELECT * FROM users`+where+` ORDER BY id`, args...)
+		err = meddler.QueryAll(tx, &users, `SELECT * FROM users`+where+` ORDER BY id`+page.limitSQL(), args...)
 	} else {
 		where, args = addWhereEq(where, args, "user_users.user_id", currentUser.ID)
 		err = meddler.QueryAll(tx, &users, `SELECT users.* `+
 			`FROM users JOIN user_users ON users.id = user_users.other_user_id`+
-			where+` ORDER BY id`, args...)
+			where+` ORDER BY id`+page.limitSQL(), args...)
 	}
 
 	if err != nil {
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
+	users = users[:page.trim(w, len(users), func(i int) int64 { return users[i].ID })]
 	render.JSON(http.StatusOK, users)
 }
 
@@ -193,26 +200,45 @@ func GetUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUs
 
 // GetCourseUsers handles request to /v2/course/:course_id/users,
 // returning a list of users in the given course.
-func GetCourseUsers(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+// If section_id is given, only students in that section are included.
+// If limit is given, users are returned a page at a time (see parseListPage).
+func GetCourseUsers(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
 	courseID, err := parseID(w, "course_id", params["course_id"])
 	if err != nil {
 		return
 	}
+	sectionID, err := parseSectionFilter(w, r)
+	if err != nil {
+		return
+	}
+	page, err := parseListPage(w, r)
+	if err != nil {
+		return
+	}
 
 	users := []*User{}
 
+	where := ""
+	args := []interface{}{courseID}
+	if sectionID > 0 {
+		args = append(args, sectionID)
+		where = fmt.Sprintf(` AND users.id IN (SELECT user_id FROM section_members WHERE section_id = $%d AND role = 'student')`, len(args))
+	}
+	where, args = page.whereAfter(where, args, "users.id")
+
 	if currentUser.Admin {
 		err = meddler.QueryAll(tx, &users, `SELECT DISTINCT users.* `+
 			`FROM users JOIN assignments ON users.id = assignments.user_id `+
-			`WHERE assignments.course_id = $1 ORDER BY users.id`,
-			courseID)
+			`WHERE assignments.course_id = $1`+where+` ORDER BY users.id`+page.limitSQL(),
+			args...)
 	} else {
+		args = append(args, currentUser.ID)
 		err = meddler.QueryAll(tx, &users, `SELECT DISTINCT users.* `+
 			`FROM users JOIN assignments ON users.id = assignments.user_id `+
 			`JOIN user_users ON assignments.user_id = user_users.other_user_id `+
-			`WHERE assignments.course_id = $1 AND user_users.user_id = $2 `+
-			`ORDER BY users.id`,
-			courseID, currentUser.ID)
+			`WHERE assignments.course_id = $1`+where+fmt.Sprintf(` AND user_users.user_id = $%d `, len(args))+
+			`ORDER BY users.id`+page.limitSQL(),
+			args...)
 	}
 
 	if err != nil {
@@ -220,10 +246,11 @@ func GetCourseUsers(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cu
 		return
 	}
 
-	if len(users) == 0 {
+	if len(users) == 0 && page.After == 0 {
 		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
 		return
 	}
+	users = users[:page.trim(w, len(users), func(i int) int64 { return users[i].ID })]
 
 	render.JSON(http.StatusOK, users)
 }
@@ -245,23 +272,28 @@ func DeleteUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
 
 // GetUserAssignments handles requests to /v2/users/:user_id/assignments,
 // returning a list of assignments for the given user.
-func GetUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+// If parameter limit=<...> is present, they are returned a page at a time (see parseListPage).
+func GetUserAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
 	userID, err := parseID(w, "user_id", params["user_id"])
 	if err != nil {
 		return
 	}
+	page, err := parseListPage(w, r)
+	if err != nil {
+		return
+	}
 
 	assignments := []*Assignment{}
 
 	if currentUser.Admin {
 		err = meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE user_id = $1 `+
-			`ORDER BY course_id, updated_at`,
+			`ORDER BY course_id, updated_at, id`+page.limitSQL(),
 			userID)
 	} else {
 		err = meddler.QueryAll(tx, &assignments, `SELECT assignments.* `+
 			`FROM assignments JOIN user_assignments ON assignments.id = user_assignments.assignment_id `+
 			`WHERE assignments.user_id = $1 AND user_assignments.user_id = $2 `+
-			`ORDER BY course_id, updated_at`,
+			`ORDER BY course_id, updated_at, assignments.id`+page.limitSQL(),
 			userID, currentUser.ID)
 	}
 
@@ -269,10 +301,139 @@ func GetUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.Params
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
+	assignments = assignments[:page.trim(w, len(assignments), nil)]
+	if err := addPrerequisiteStatus(tx, assignments); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := maskAnonymousAssignments(tx, currentUser, assignments); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
 
 	render.JSON(http.StatusOK, assignments)
 }
 
+// assignmentSorts maps the sort parameter of GetUserMeAssignments to an ORDER BY clause.
+// Each ends with the assignment ID so pages of the list do not overlap.
+var assignmentSorts = map[string]string{
+	"course":  "courses.lti_label, courses.id, assignments.updated_at, assignments.id",
+	"due":     "assignments.due_at, courses.lti_label, assignments.canvas_title, assignments.id",
+	"title":   "assignments.canvas_title, courses.lti_label, assignments.id",
+	"updated": "assignments.updated_at DESC, assignments.id",
+}
+
+// GetUserMeAssignments handles /v2/users/me/assignments requests,
+// returning the current user's assignments with the course and problem set details needed to list them.
+//
+// If parameter course_lti_label=<...> is present, only assignments in courses with that label are returned.
+// If parameter course_id=<...> is present, only assignments in that course are returned.
+// If parameter problem_unique=<...> is present, only assignments for that problem set are returned.
+// If parameter due_before=<...> is present (RFC 3339), only assignments due before then are returned.
+// If parameter incomplete=true is present, assignments with full credit are left out.
+// If parameter active=true is present, assignments in archived or ended courses are left out.
+// Parameter sort=<...> orders them by course (the default), due, title, or updated (most recent first).
+// If parameter limit=<...> is present, they are returned a page at a time (see parseListPage).
+func GetUserMeAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	page, err := parseListPage(w, r)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	where, args := addWhereEq("", nil, "assignments.user_id", currentUser.ID)
+	if label := r.FormValue("course_lti_label"); label != "" {
+		where, args = addWhereEq(where, args, "courses.lti_label", label)
+	}
+	if s := r.FormValue("course_id"); s != "" {
+		courseID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || courseID < 1 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "course_id must be a course ID")
+			return
+		}
+		where, args = addWhereEq(where, args, "assignments.course_id", courseID)
+	}
+	if unique := r.FormValue("problem_unique"); unique != "" {
+		where, args = addWhereEq(where, args, "problem_sets.unique_id", unique)
+	}
+	if s := r.FormValue("due_before"); s != "" {
+		dueBefore, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "due_before must be a time in RFC 3339 format: %v", err)
+			return
+		}
+		args = append(args, dueBefore)
+		where += fmt.Sprintf(" AND assignments.due_at < $%d", len(args))
+	}
+	if r.FormValue("incomplete") == "true" {
+		where += " AND COALESCE(assignments.score, 0) < 1"
+	}
+	if r.FormValue("active") == "true" {
+		args = append(args, now)
+		where += fmt.Sprintf(" AND NOT courses.archived AND (courses.ends_at IS NULL OR courses.ends_at > $%d)", len(args))
+	}
+	sort := r.FormValue("sort")
+	if sort == "" {
+		sort = "course"
+	}
+	order, exists := assignmentSorts[sort]
+	if !exists {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "sort must be course, due, title, or updated, not %q", sort)
+		return
+	}
+
+	assignments := []*Assignment{}
+	if err := meddler.QueryAll(tx, &assignments, `SELECT assignments.* `+
+		`FROM assignments JOIN courses ON assignments.course_id = courses.id `+
+		`JOIN problem_sets ON assignments.problem_set_id = problem_sets.id`+
+		where+` ORDER BY `+order+page.limitSQL(), args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	assignments = assignments[:page.trim(w, len(assignments), nil)]
+	if err := addPrerequisiteStatus(tx, assignments); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := maskAnonymousAssignments(tx, currentUser, assignments); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// fill in the course and problem set details
+	courses := make(map[int64]*Course)
+	problemSets := make(map[int64]*ProblemSet)
+	listing := []*AssignmentListing{}
+	for _, asst := range assignments {
+		course, exists := courses[asst.CourseID]
+		if !exists {
+			course = new(Course)
+			if err := meddler.Load(tx, "courses", course, asst.CourseID); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			courses[asst.CourseID] = course
+		}
+		problemSet, exists := problemSets[asst.ProblemSetID]
+		if !exists {
+			problemSet = new(ProblemSet)
+			if err := meddler.Load(tx, "problem_sets", problemSet, asst.ProblemSetID); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			problemSets[asst.ProblemSetID] = problemSet
+		}
+		listing = append(listing, &AssignmentListing{
+			Assignment:       asst,
+			CourseName:       course.Name,
+			CourseLabel:      course.Label,
+			CourseTerm:       course.Term,
+			CourseActive:     course.IsActive(now),
+			ProblemSetUnique: problemSet.Unique,
+		})
+	}
+	render.JSON(http.StatusOK, listing)
+}
+
 // GetCourseUserAssignments handles requests to /v2/courses/:course_id/users/:user_id/assignments,
 // returning a list of assignments for the given user in the given course.
 func GetCourseUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
@@ -308,6 +469,14 @@ func GetCourseUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.
 		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
 		return
 	}
+	if err := addPrerequisiteStatus(tx, assignments); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := maskAnonymousAssignments(tx, currentUser, assignments); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
 
 	render.JSON(http.StatusOK, assignments)
 }
@@ -335,6 +504,20 @@ func GetAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cur
 		loggedHTTPDBNotFoundError(w, err)
 		return
 	}
+	// students cannot open a locked assignment
+	if assignment.UserID == currentUser.ID {
+		if err := checkPrerequisites(tx, assignment); err != nil {
+			loggedHTTPError(w, err)
+			return
+		}
+	} else if err := addPrerequisiteStatus(tx, []*Assignment{assignment}); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := maskAnonymousAssignments(tx, currentUser, []*Assignment{assignment}); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
 
 	render.JSON(http.StatusOK, assignment)
 }
@@ -382,6 +565,7 @@ func GetAssignmentProblemCommitLast(w http.ResponseWriter, tx *sql.Tx, params ma
 		return
 	}
 
+	commit.AddChecksums()
 	render.JSON(http.StatusOK, commit)
 }
 
@@ -418,6 +602,7 @@ func GetAssignmentProblemStepCommitLast(w http.ResponseWriter, tx *sql.Tx, param
 		return
 	}
 
+	commit.AddChecksums()
 	render.JSON(http.StatusOK, commit)
 }
 
@@ -438,7 +623,7 @@ func DeleteCommit(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
 // PostCommitBundlesUnsigned handles requests to /v2/commit_bundles/unsigned,
 // saving a new commit (or updating the most recent one), gathering the problem data,
 // signing everything, and returning it in a form ready to send to the daycare.
-func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, span *traceSpan, currentUser *User, bundle CommitBundle, render render.Render) {
 	now := time.Now()
 
 	if bundle.Commit == nil {
@@ -454,13 +639,18 @@ func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, currentUser *U
 	bundle.Commit.Score = 0.0
 	bundle.Commit.CreatedAt = now
 	bundle.Commit.UpdatedAt = now
-	saveCommitBundleCommon(now, w, tx, currentUser, bundle, render)
+	signed, err := saveCommitBundle(now, tx, tenant, currentUser, &bundle, span)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, signed)
 }
 
 // PostCommitBundlesSigned handles requests to /v2/commit_bundles/signed,
 // saving a new commit (or updating the most recent one), gathering the problem data,
 // verifying signatures, and posting a grade (if appropriate).
-func PostCommitBundlesSigned(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+func PostCommitBundlesSigned(w http.ResponseWriter, tx *sql.Tx, tenant *TenantConfig, span *traceSpan, currentUser *User, bundle CommitBundle, render render.Render) {
 	now := time.Now()
 
 	if bundle.Commit == nil {
@@ -471,45 +661,83 @@ func PostCommitBundlesSigned(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must include commit signature")
 		return
 	}
-	saveCommitBundleCommon(now, w, tx, currentUser, bundle, render)
+	signed, err := saveCommitBundle(now, tx, tenant, currentUser, &bundle, span)
+	if err != nil {
+		loggedHTTPError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, signed)
 }
 
-func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+// saveCommitBundle saves a commit bundle for the current user and returns it
+// signed and ready to send to the daycare. If the bundle was already signed by
+// the daycare, the score is recorded and posted to the LMS.
+func saveCommitBundle(now time.Time, tx *sql.Tx, tenant *TenantConfig, currentUser *User, bundle *CommitBundle, span *traceSpan) (*CommitBundle, error) {
 	if bundle.Problem != nil {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must not include a problem object")
-		return
+		return nil, httpErrorf(http.StatusBadRequest, "bundle must not include a problem object")
 	}
 	if len(bundle.ProblemSteps) != 0 {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must not include problem step objects")
-		return
+		return nil, httpErrorf(http.StatusBadRequest, "bundle must not include problem step objects")
 	}
 	if len(bundle.ProblemSignature) != 0 {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must not include problem signature")
-		return
+		return nil, httpErrorf(http.StatusBadRequest, "bundle must not include problem signature")
 	}
 	commit := bundle.Commit
 
 	// get the assignment and make sure it is for this user
 	assignment := new(Assignment)
 	if err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, commit.AssignmentID, currentUser.ID); err != nil {
-		loggedHTTPDBNotFoundError(w, err)
-		return
+		return nil, dbNotFoundError(err)
+	}
+
+	if assignment.IsDropped() {
+		return nil, httpErrorf(http.StatusForbidden, "you are no longer enrolled in this course; your earlier work has been kept")
+	}
+	if err := checkPrerequisites(tx, assignment); err != nil {
+		return nil, err
+	}
+
+	// a suspended student can save work but not send it to be graded
+	if len(bundle.CommitSignature) == 0 && commit.Action != "" {
+		if err := checkSuspension(tx, now, currentUser.ID, assignment.CourseID); err != nil {
+			return nil, err
+		}
+		if err := checkCourseQuota(tx, assignment); err != nil {
+			return nil, err
+		}
+	}
+
+	// work on a sealed exam can only be submitted sealed until the exam is unsealed
+	exam, err := getSealedExam(tx, assignment.CourseID, assignment.ProblemSetID)
+	if err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if exam != nil && exam.IsSealed() {
+		return nil, httpErrorf(http.StatusForbidden, "this problem set is a sealed exam; work must be submitted sealed until the instructor unseals it")
 	}
 
 	// get the problem
 	problem := new(Problem)
 	if err := meddler.QueryRow(tx, problem, `SELECT * FROM problems WHERE id = $1`, commit.ProblemID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 	}
 	steps := []*ProblemStep{}
 	if err := meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = $1 ORDER BY step`, commit.ProblemID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 	}
 	if len(steps) == 0 {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "no steps found for problem %s (%d)", problem.Unique, problem.ID)
-		return
+		return nil, httpErrorf(http.StatusInternalServerError, "no steps found for problem %s (%d)", problem.Unique, problem.ID)
+	}
+
+	// problems on another path through an adaptive problem set are off limits
+	track, err := getProblemTrack(tx, assignment.ProblemSetID, problem.ID)
+	if err == sql.ErrNoRows {
+		return nil, httpErrorf(http.StatusBadRequest, "problem %s is not part of this assignment", problem.Unique)
+	} else if err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if !assignment.OnPath(track) {
+		return nil, httpErrorf(http.StatusForbidden, "problem %s is not on your path through this problem set", problem.Unique)
 	}
 
 	// reject commit if a previous step remains incomplete
@@ -519,20 +747,30 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	scores := assignment.RawScores[problem.Unique]
 	for i := 0; i < int(commit.Step)-1; i++ {
 		if i >= len(scores) || scores[i] != 1.0 {
-			loggedHTTPErrorf(w, http.StatusBadRequest, "commit is for step %d, but user has not passed step %d", commit.Step, i+1)
-			return
+			return nil, httpErrorf(http.StatusBadRequest, "commit is for step %d, but user has not passed step %d", commit.Step, i+1)
+		}
+	}
+
+	// the server clock is the authority on when work was saved; the client's
+	// clock is kept alongside it for audits
+	if len(bundle.CommitSignature) == 0 {
+		if commit.ClientTime.IsZero() {
+			commit.ClientTime = commit.UpdatedAt
 		}
+		commit.CreatedAt = now
+		commit.UpdatedAt = now
 	}
 
 	// validate commit
 	if commit.Step > int64(len(steps)) {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "commit has step number %d, but there are only %d steps in the problem", commit.Step, len(steps))
-		return
+		return nil, httpErrorf(http.StatusBadRequest, "commit has step number %d, but there are only %d steps in the problem", commit.Step, len(steps))
+	}
+	if err := commit.VerifyChecksums(); err != nil {
+		return nil, httpErrorf(http.StatusBadRequest, "%v", err)
 	}
 	whitelists := problem.GetStepWhitelists(steps)
 	if err := commit.Normalize(now, whitelists[commit.Step-1]); err != nil {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
-		return
+		return nil, httpErrorf(http.StatusBadRequest, "%v", err)
 	}
 
 	// update an existing commit if it exists
@@ -542,31 +780,100 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		if err == sql.ErrNoRows {
 			commit.ID = 0
 		} else {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-			return
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 		}
 	} else {
 		commit.ID = openCommit.ID
 		commit.CreatedAt = openCommit.CreatedAt
+
+		// reflection answers are kept unless the student changes them
+		if len(bundle.CommitSignature) == 0 && len(openCommit.Reflections) > 0 {
+			answers := openCommit.Reflections
+			for name, answer := range commit.Reflections {
+				answers[name] = answer
+			}
+			commit.Reflections = answers
+		}
+	}
+
+	// the last step cannot be graded until the reflection prompts asked first are answered
+	if len(bundle.CommitSignature) == 0 && commit.Action == "grade" && commit.Step == int64(len(steps)) {
+		if err := checkReflections(tx, problem, commit); err != nil {
+			return nil, err
+		}
+	}
+
+	// get the course overrides for this problem type, if any
+	override, err := getProblemTypeOverride(tx, assignment.CourseID, problem.ProblemType)
+	if err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "error loading course overrides: %v", err)
+	}
+	if override != nil && len(bundle.CommitSignature) == 0 && commit.Action != "" {
+		if note := overrideImageMismatch(override.Image); note != "" {
+			return nil, httpErrorf(http.StatusServiceUnavailable, "the course image for %s cannot be used for grading now: %s", problem.ProblemType, note)
+		}
 	}
 
 	// sign the problem and the commit
-	problemSig := problem.ComputeSignature(Config.DaycareSecret, steps)
-	commitSig := commit.ComputeSignature(Config.DaycareSecret, problemSig)
+	signed := &CommitBundle{
+		Problem:             problem,
+		ProblemSteps:        steps,
+		ProblemSignature:    problem.ComputeSignature(Config().DaycareSecret, steps),
+		ProblemTypeOverride: override,
+		Commit:              commit,
+		Tenant:              tenant.Hostname,
+	}
+	// the sealed environment is part of the signature, so it must come back unchanged as well
+	if bundle.CommitSignature != "" {
+		signed.Environment = bundle.Environment
+	} else if signed.Environment, err = sealProblemEnvironment(tx, problem.ID, signed.ProblemSignature); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "error preparing environment: %v", err)
+	}
+	if bundle.CommitSignature == "" && commit.Action != "" {
+		if signed.Reference, err = sealProblemReference(tx, problem, commit.Step, commit.Action, signed.ProblemSignature); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "error preparing reference solution: %v", err)
+		}
+	}
+
+	// files from earlier problems come from the database when a commit is sent
+	// to be graded, and must come back unchanged with the graded commit
+	if bundle.CommitSignature != "" {
+		signed.Imports = bundle.Imports
+	} else if commit.Action != "" {
+		if signed.Imports, err = gatherImports(tx, assignment, problem); err != nil {
+			return nil, err
+		}
+	}
+
+	// the grading matrix is fixed when a commit is sent to be graded, for the same reason
+	if bundle.CommitSignature != "" {
+		signed.Matrix = bundle.Matrix
+	} else if commit.Action == "grade" {
+		if signed.Matrix, err = getToolchainMatrix(tx, problem.ID); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+		}
+	}
+	chainSig := signed.SigningSignature(Config().DaycareSecret)
+	commitSig := commit.ComputeSignature(Config().DaycareSecret, chainSig)
 
 	// verify signature
 	if bundle.CommitSignature != "" {
 		if bundle.CommitSignature != commitSig {
-			loggedHTTPErrorf(w, http.StatusBadRequest, "found commit signature of %s, but expected %s", bundle.CommitSignature, commitSig)
-			return
+			return nil, httpErrorf(http.StatusBadRequest, "found commit signature of %s, but expected %s", bundle.CommitSignature, commitSig)
 		}
 		age := now.Sub(commit.UpdatedAt)
 		if age < 0 {
 			age = -age
 		}
 		if age > SignedCommitTimeout {
-			loggedHTTPErrorf(w, http.StatusBadRequest, "commit signature has expired")
-			return
+			return nil, httpErrorf(http.StatusBadRequest, "commit signature has expired")
+		}
+	}
+
+	// explain misconceptions the problem's feedback rules recognize
+	if bundle.CommitSignature != "" && commit.ReportCard != nil {
+		if err := applyFeedbackRules(tx, problem, commit, now); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 		}
 	}
 
@@ -577,20 +884,24 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		commit.Action = ""
 	}
 	if err := meddler.Save(tx, "commits", commit); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if err := appendSubmissionRecord(tx, commit, now); err != nil {
+		return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if bundle.CommitSignature != "" && commit.ReportCard != nil {
+		if err := recordGradingUsage(tx, assignment.CourseID, commit.ReportCard, now); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+		}
+		if err := recordProblemCost(tx, assignment.CourseID, problem.ID, commit.ReportCard.Resources, now); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+		}
 	}
 	commit.Action = action
 
 	// recompute the signature as the ID may have changed when saving
-	commitSig = commit.ComputeSignature(Config.DaycareSecret, problemSig)
-	signed := &CommitBundle{
-		Problem:          problem,
-		ProblemSteps:     steps,
-		ProblemSignature: problemSig,
-		Commit:           commit,
-		CommitSignature:  commitSig,
-	}
+	signed.CommitSignature = commit.ComputeSignature(Config().DaycareSecret, chainSig)
+	commit.AddChecksums()
 
 	// save the grade update
 	if signed.Commit.ReportCard != nil {
@@ -599,78 +910,98 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		for int(signed.Commit.Step) > len(scores) {
 			scores = append(scores, 0.0)
 		}
-		scores[signed.Commit.Step-1] = signed.Commit.ReportCard.ComputeScore()
-		assignment.RawScores[problem.Unique] = scores
-
-		// get the weight of each step in the problem and problem in the set
-		weights := []*StepWeights{}
-		if err := meddler.QueryAll(tx, &weights, `SELECT problems.unique_id, problem_set_problems.weight AS problem_weight, problem_steps.step, problem_steps.weight AS step_weight `+
-			`FROM problem_set_problems JOIN problems ON problem_set_problems.problem_id = problems.id `+
-			`JOIN problem_steps ON problem_steps.problem_id = problems.id `+
-			`WHERE problem_set_problems.problem_set_id = $1 `+
-			`ORDER BY unique_id, step`, assignment.ProblemSetID); err != nil {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-			return
-		}
-		if len(weights) == 0 {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "no problem step weights found, unable to compute score")
-			return
-		}
-		problemWeights := make(map[string]float64)
-		stepWeights := make(map[string][]float64)
-		for _, elt := range weights {
-			problemWeights[elt.Unique] = elt.ProblemWeight
-			stepWeights[elt.Unique] = append(stepWeights[elt.Unique], elt.StepWeight)
-			if len(stepWeights[elt.Unique]) != int(elt.Step) {
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "step weights do not line up when computing score")
-				return
+		score := signed.Commit.ReportCard.ComputeScore()
+		if problem.IsMastery() {
+			// credit comes from a run of consecutive passes, not a single one
+			streak, err := updateMasteryStreak(tx, now, problem, signed.Commit, bundle.CommitSignature)
+			if err != nil {
+				return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 			}
+			signed.Commit.Streak = streak
+			score = streak.Score()
 		}
+		scores[signed.Commit.Step-1] = score
+		assignment.RawScores[problem.Unique] = scores
 
-		// compute an overall score
-		setWeightTotal, setScore := 0.0, 0.0
-		for unique, problemWeight := range problemWeights {
-			setWeightTotal += problemWeight
-			scores := assignment.RawScores[unique]
-			problemWeightTotal, problemScore := 0.0, 0.0
-			for i, stepWeight := range stepWeights[unique] {
-				problemWeightTotal += stepWeight
-				if i < len(scores) {
-					problemScore += scores[i] * stepWeight
-				}
-			}
-			if problemWeightTotal == 0.0 {
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "problem %s has no weight", unique)
-				return
-			}
-			problemScore /= problemWeightTotal
-			setScore += problemScore * problemWeight
+		// an adaptive problem set is graded on the student's path only
+		if signed.Path, err = placeOnPath(tx, assignment, problem, steps, signed.Commit); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 		}
-		if setWeightTotal == 0.0 {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "problem set has no weight")
-			return
+		if assignment.Score, err = computeAssignmentScore(tx, assignment); err != nil {
+			return nil, err
 		}
-		assignment.Score = setScore / setWeightTotal
 
 		// save the updates to the assignment
 		assignment.UpdatedAt = now
 		if err := meddler.Save(tx, "assignments", assignment); err != nil {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-			return
+			return nil, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
 		}
 		// post grade to LMS using LTI
-		if err := saveGrade(tx, assignment, currentUser); err != nil {
-			loggedHTTPErrorf(w, http.StatusInternalServerError, "error posting grade back to LMS: %v", err)
-			return
+		if err := postGrade(tx, tenant, assignment, currentUser, span); err != nil {
+			return nil, httpErrorf(http.StatusInternalServerError, "error posting grade back to LMS: %v", err)
 		}
 	}
 
-	render.JSON(http.StatusOK, &signed)
+	return signed, nil
+}
+
+// computeAssignmentScore computes the overall score for an assignment from the raw
+// scores of each step, weighted by step and by problem, and combined with any
+// grades given by hand.
+func computeAssignmentScore(tx *sql.Tx, assignment *Assignment) (float64, error) {
+	// get the weight of each step in the problem and problem in the set
+	weights := []*StepWeights{}
+	if err := meddler.QueryAll(tx, &weights, `SELECT problems.unique_id, problem_set_problems.weight AS problem_weight, problem_set_problems.track, problem_steps.step, problem_steps.weight AS step_weight `+
+		`FROM problem_set_problems JOIN problems ON problem_set_problems.problem_id = problems.id `+
+		`JOIN problem_steps ON problem_steps.problem_id = problems.id `+
+		`WHERE problem_set_problems.problem_set_id = $1 `+
+		`ORDER BY unique_id, step`, assignment.ProblemSetID); err != nil {
+		return 0.0, httpErrorf(http.StatusInternalServerError, "db error: %v", err)
+	}
+	if len(weights) == 0 {
+		return 0.0, httpErrorf(http.StatusInternalServerError, "no problem step weights found, unable to compute score")
+	}
+	problemWeights := make(map[string]float64)
+	stepWeights := make(map[string][]float64)
+	for _, elt := range weights {
+		if !assignment.OnPath(elt.Track) {
+			continue
+		}
+		problemWeights[elt.Unique] = elt.ProblemWeight
+		stepWeights[elt.Unique] = append(stepWeights[elt.Unique], elt.StepWeight)
+		if len(stepWeights[elt.Unique]) != int(elt.Step) {
+			return 0.0, httpErrorf(http.StatusInternalServerError, "step weights do not line up when computing score")
+		}
+	}
+
+	// compute an overall score
+	setWeightTotal, setScore := 0.0, 0.0
+	for unique, problemWeight := range problemWeights {
+		setWeightTotal += problemWeight
+		scores := assignment.RawScores[unique]
+		problemWeightTotal, problemScore := 0.0, 0.0
+		for i, stepWeight := range stepWeights[unique] {
+			problemWeightTotal += stepWeight
+			if i < len(scores) {
+				problemScore += scores[i] * stepWeight
+			}
+		}
+		if problemWeightTotal == 0.0 {
+			return 0.0, httpErrorf(http.StatusInternalServerError, "problem %s has no weight", unique)
+		}
+		problemScore /= problemWeightTotal
+		setScore += problemScore * problemWeight
+	}
+	if setWeightTotal == 0.0 {
+		return 0.0, httpErrorf(http.StatusInternalServerError, "problem set has no weight")
+	}
+	return combineManualGrades(tx, assignment, setScore/setWeightTotal)
 }
 
 type StepWeights struct {
 	Unique        string  `meddler:"unique_id"`
 	ProblemWeight float64 `meddler:"problem_weight"`
+	Track         string  `meddler:"track,zeroisnull"`
 	Step          int64   `meddler:"step"`
 	StepWeight    float64 `meddler:"step_weight"`
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetProblemVariables handles /v2/problems/:problem_id/variables requests,
+// returning the environment variables set for the problem.
+// The values of secrets are left out.
+func GetProblemVariables(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	variables := []*ProblemVariable{}
+	if err := meddler.QueryAll(tx, &variables, `SELECT * FROM problem_variables WHERE problem_id = $1 ORDER BY name`, problemID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, elt := range variables {
+		if elt.Secret {
+			elt.Value = ""
+		}
+	}
+	render.JSON(http.StatusOK, variables)
+}
+
+// PutProblemVariable handles /v2/problems/:problem_id/variables/:name requests,
+// setting an environment variable for every grading run of the problem.
+// The variable is returned, without its value if it is a secret.
+func PutProblemVariable(w http.ResponseWriter, tx *sql.Tx, currentUser *User, params martini.Params, variable ProblemVariable, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	variable.ProblemID = problemID
+	variable.Name = params["name"]
+	if err := variable.Normalize(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if variable.Secret {
+		sealed, err := SealVariableValue(Config().DaycareSecret, problemID, variable.Name, variable.Value)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error encrypting secret: %v", err)
+			return
+		}
+		variable.Value = sealed
+	}
+
+	now := time.Now()
+	variable.UpdatedAt = now
+	old := new(ProblemVariable)
+	err = meddler.QueryRow(tx, old, `SELECT * FROM problem_variables WHERE problem_id = $1 AND name = $2`, problemID, variable.Name)
+	switch {
+	case err == sql.ErrNoRows:
+		variable.CreatedAt = now
+		err = meddler.Insert(tx, "problem_variables", &variable)
+	case err == nil:
+		variable.CreatedAt = old.CreatedAt
+		_, err = tx.Exec(`UPDATE problem_variables SET value = $1, secret = $2, updated_at = $3 WHERE problem_id = $4 AND name = $5`,
+			variable.Value, variable.Secret, now, problemID, variable.Name)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("%s set variable %s for problem %s", currentUser.Name, variable.Name, problem.Unique)
+	if variable.Secret {
+		variable.Value = ""
+	}
+	render.JSON(http.StatusOK, &variable)
+}
+
+// DeleteProblemVariable handles /v2/problems/:problem_id/variables/:name requests,
+// removing an environment variable from the problem.
+func DeleteProblemVariable(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM problem_variables WHERE problem_id = $1 AND name = $2`, problemID, params["name"]); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// sealProblemEnvironment gathers the environment variables for a problem and seals
+// them for the daycare, decrypting secrets along the way. It returns an empty
+// string if the problem has no variables.
+func sealProblemEnvironment(tx *sql.Tx, problemID int64, problemSignature string) (string, error) {
+	variables := []*ProblemVariable{}
+	if err := meddler.QueryAll(tx, &variables, `SELECT * FROM problem_variables WHERE problem_id = $1`, problemID); err != nil {
+		return "", err
+	}
+	if len(variables) == 0 {
+		return "", nil
+	}
+	env := &Environment{Vars: make(map[string]string)}
+	for _, elt := range variables {
+		value := elt.Value
+		if elt.Secret {
+			var err error
+			if value, err = OpenVariableValue(Config().DaycareSecret, problemID, elt.Name, elt.Value); err != nil {
+				return "", err
+			}
+			env.Secrets = append(env.Secrets, elt.Name)
+		}
+		env.Vars[elt.Name] = value
+	}
+	return SealEnvironment(Config().DaycareSecret, env, problemSignature)
+}
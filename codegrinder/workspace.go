@@ -0,0 +1,558 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// workspaceLibPrefix is where the workspace page finds xterm and monaco-editor,
+// which are served from WorkspaceLibDir.
+const workspaceLibPrefix = "/workspace/lib"
+
+// setPagePolicy sets the content security policy for a browser page that loads its
+// libraries from workspaceLibPrefix and talks only to this server and the daycare.
+// Only the page's own inline script runs, and it is the one with the nonce returned.
+func setPagePolicy(w http.ResponseWriter) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	nonce := base64.StdEncoding.EncodeToString(raw)
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf("default-src 'self'; script-src 'self' 'nonce-%s'; "+
+		"style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self' data:; worker-src 'self' blob:; "+
+		"connect-src 'self' wss://%s; object-src 'none'; base-uri 'none'; form-action 'self'",
+		nonce, Config().DaycareHost))
+	return nonce, nil
+}
+
+// workspaceData is what the workspace page needs to find its way around.
+type workspaceData struct {
+	AssignmentID int64
+	CourseID     int64
+	UserID       int64
+	UserName     string
+	Instructor   bool
+	DaycareHost  string
+	Lib          string
+	Nonce        string
+}
+
+// GetWorkspace handles /workspace/:assignment_id requests,
+// returning a page where a student can edit, run, and grade an assignment
+// entirely in the browser. The page uses the same API calls as grind, so work
+// moves freely between the browser and the command line.
+//
+// Everything the page runs comes from this server, and a content security policy
+// keeps it from loading scripts from anywhere else or talking to anything but
+// this server and the daycare.
+func GetWorkspace(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	if Config().WorkspaceLibDir == "" {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the browser workspace is not set up on this server")
+		return
+	}
+
+	// commits are always saved as the current user, so only their own assignments make sense
+	assignment := new(Assignment)
+	if err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = $1 AND user_id = $2`, assignmentID, currentUser.ID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	data := &workspaceData{
+		AssignmentID: assignment.ID,
+		CourseID:     assignment.CourseID,
+		UserID:       currentUser.ID,
+		UserName:     currentUser.Name,
+		Instructor:   assignment.Instructor,
+		DaycareHost:  Config().DaycareHost,
+		Lib:          workspaceLibPrefix,
+	}
+
+	if data.Nonce, err = setPagePolicy(w); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating nonce: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := workspaceTemplate.Execute(w, data); err != nil {
+		loggedErrorf("error rendering workspace: %v", err)
+	}
+}
+
+var workspaceTemplate = template.Must(template.New("workspace").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>CodeGrinder workspace</title>
+<link rel="stylesheet" href="{{.Lib}}/xterm/css/xterm.css">
+<style>
+html, body { margin: 0; height: 100%; font-family: sans-serif; font-size: 14px; }
+body { display: flex; flex-direction: column; }
+header { display: flex; align-items: center; gap: 8px; padding: 6px 10px; background: #2d2d2d; color: #eee; }
+header .title { font-weight: bold; margin-right: auto; }
+header select, header button { font-size: 14px; }
+#status { padding: 4px 10px; background: #f0f0f0; border-bottom: 1px solid #ccc; min-height: 1.2em; }
+#status.error { background: #fbe3e3; color: #900; }
+.announcement { display: flex; align-items: flex-start; gap: 8px; padding: 6px 10px; background: #fff4d6; border-bottom: 1px solid #e0c97a; }
+.announcement .message { flex: 1; white-space: pre-wrap; }
+.announcement .from { font-size: 12px; color: #666; }
+#main { flex: 1; display: flex; min-height: 0; }
+#side { width: 30%; overflow: auto; padding: 0 10px; border-right: 1px solid #ccc; }
+#work { flex: 1; display: flex; flex-direction: column; min-width: 0; }
+#tabs { display: flex; flex-wrap: wrap; background: #ddd; }
+#tabs button { border: none; padding: 6px 10px; background: #ddd; cursor: pointer; }
+#tabs button.active { background: #fff; font-weight: bold; }
+#editor { flex: 2; min-height: 0; }
+#terminal { flex: 1; min-height: 0; background: #000; padding: 4px; }
+#cli { font-size: 12px; color: #666; }
+#cli code { word-break: break-all; }
+</style>
+</head>
+<body>
+<header>
+<span class="title">CodeGrinder</span>
+<select id="problem"></select>
+<button id="save">Save</button>
+<button id="run">Run</button>
+<button id="shell">Shell</button>
+<button id="grade">Grade</button>
+<label title="Give a link to someone helping you so they can watch your runs"><input type="checkbox" id="share"> Share</label>
+<label><input type="checkbox" id="share-input"> Let them type</label>
+{{if .Instructor}}<label title="Show your runs live to everyone in the course at /broadcast/{{.CourseID}}"><input type="checkbox" id="broadcast"> Broadcast</label>{{end}}
+<a href="/progress/{{.AssignmentID}}" style="color: #eee">Progress</a>
+</header>
+<div id="status">Loading assignment…</div>
+<div id="announcements"></div>
+<div id="main">
+<div id="side">
+<div id="instructions"></div>
+<div id="cli">
+<p>Prefer the command line? Install grind, then run
+<code>grind init</code> and give it the session cookie from
+<a href="/v2/users/me/cookie" target="_blank" rel="noopener">this page</a>.</p>
+</div>
+</div>
+<div id="work">
+<div id="tabs"></div>
+<div id="editor"></div>
+<div id="terminal"></div>
+</div>
+</div>
+<script src="{{.Lib}}/xterm/lib/xterm.js"></script>
+<script src="{{.Lib}}/monaco-editor/min/vs/loader.js"></script>
+<script nonce="{{.Nonce}}">
+(function() {
+	'use strict';
+
+	var assignmentID = {{.AssignmentID}};
+	var courseID = {{.CourseID}};
+	var userID = {{.UserID}};
+	var daycareHost = {{.DaycareHost}};
+
+	var assignment = null;
+	var problems = [];
+	var current = null;
+	var currentFile = '';
+	var editor = null;
+	var socket = null;
+	var busy = false;
+
+	// shareMode tells the daycare whether to let a helper watch, and whether they can type too,
+	// or whether an instructor is showing the run to the whole class
+	function shareMode() {
+		var broadcast = document.getElementById('broadcast');
+		if (broadcast && broadcast.checked) {
+			return 'broadcast';
+		}
+		if (!document.getElementById('share').checked) {
+			return undefined;
+		}
+		return document.getElementById('share-input').checked ? 'input' : 'view';
+	}
+
+	var term = new Terminal({ convertEol: true, fontSize: 13 });
+	term.open(document.getElementById('terminal'));
+	term.onData(function(data) {
+		// keystrokes go to whatever is running in the daycare
+		if (socket && socket.readyState === WebSocket.OPEN) {
+			socket.send(JSON.stringify({ stdin: data === '\r' ? '\n' : data }));
+		}
+	});
+
+	function setStatus(msg, isError) {
+		var elt = document.getElementById('status');
+		elt.textContent = msg;
+		elt.className = isError ? 'error' : '';
+	}
+
+	function api(method, path, body) {
+		var opts = { method: method, credentials: 'same-origin', headers: {} };
+		if (body !== undefined) {
+			opts.headers['Content-Type'] = 'application/json';
+			opts.body = JSON.stringify(body);
+		}
+		return fetch('/v2' + path, opts).then(function(res) {
+			if (!res.ok) {
+				return res.text().then(function(text) {
+					// errors are reported as JSON with a message and sometimes a hint
+					var msg = text.trim() || res.statusText;
+					try {
+						var body = JSON.parse(text);
+						msg = body.hint ? body.message + ' (' + body.hint + ')' : body.message;
+					} catch (e) {}
+					var err = new Error(msg);
+					err.status = res.status;
+					throw err;
+				});
+			}
+			return res.json();
+		});
+	}
+
+	// announcements stay on the page until the student dismisses them
+	function showAnnouncements(announcements) {
+		var box = document.getElementById('announcements');
+		box.innerHTML = '';
+		announcements.forEach(function(announcement) {
+			var div = document.createElement('div');
+			div.className = 'announcement';
+			var msg = document.createElement('div');
+			msg.className = 'message';
+			msg.textContent = announcement.message;
+			var from = document.createElement('div');
+			from.className = 'from';
+			from.textContent = announcement.problemSetUnique ? announcement.courseLabel + '/' + announcement.problemSetUnique : announcement.courseLabel;
+			msg.appendChild(from);
+			var dismiss = document.createElement('button');
+			dismiss.textContent = 'Dismiss';
+			dismiss.onclick = function() {
+				api('POST', '/users/me/announcements/' + announcement.id + '/acknowledge').then(function() {
+					box.removeChild(div);
+				}, function(err) {
+					setStatus(err.message, true);
+				});
+			};
+			div.appendChild(msg);
+			div.appendChild(dismiss);
+			box.appendChild(div);
+		});
+	}
+
+	function isStarterFile(name) {
+		return name.indexOf('/') < 0;
+	}
+
+	function languageFor(name) {
+		var ext = name.slice(name.lastIndexOf('.') + 1);
+		return {
+			py: 'python', c: 'c', h: 'c', cpp: 'cpp', cc: 'cpp', go: 'go', java: 'java',
+			js: 'javascript', rs: 'rust', s: 'mips', asm: 'mips', pl: 'prolog', scm: 'scheme',
+			rkt: 'scheme', sql: 'sql', html: 'html', css: 'css', md: 'markdown', sh: 'shell'
+		}[ext] || 'plaintext';
+	}
+
+	// loadStep fetches a step and adds its starter files without losing the student's work.
+	// When advancing, starter files replace the student's copy unless the step carries them forward.
+	function loadStep(entry, n, advancing) {
+		return api('GET', '/problems/' + entry.problem.id + '/steps/' + n).then(function(step) {
+			entry.step = step;
+			var carry = step.carry || [];
+			Object.keys(step.files || {}).forEach(function(name) {
+				if (!isStarterFile(name)) {
+					return;
+				}
+				if (!(name in entry.files) || (advancing && carry.indexOf(name) < 0)) {
+					entry.files[name] = step.files[name];
+				}
+			});
+			return entry;
+		});
+	}
+
+	function passed(commit) {
+		if (!commit || !commit.reportCard || !commit.reportCard.passed || commit.score !== 1) {
+			return false;
+		}
+		return !commit.streak || commit.streak.streak >= commit.streak.required;
+	}
+
+	function loadProblem(psp) {
+		var entry = { files: {}, commit: null };
+		return api('GET', '/problems/' + psp.problemID).then(function(problem) {
+			entry.problem = problem;
+			return api('GET', '/assignments/' + assignmentID + '/problems/' + problem.id + '/commits/last').catch(function(err) {
+				if (err.status === 404) {
+					return null;
+				}
+				throw err;
+			});
+		}).then(function(commit) {
+			entry.commit = commit;
+			if (commit) {
+				entry.files = commit.files || {};
+			}
+			return loadStep(entry, commit ? commit.step : 1);
+		}).then(function(entry) {
+			// pick up where the student left off
+			if (passed(entry.commit)) {
+				return loadStep(entry, entry.step.step + 1, true).catch(function() { return entry; });
+			}
+			return entry;
+		});
+	}
+
+	function load() {
+		return api('GET', '/assignments/' + assignmentID).then(function(asst) {
+			assignment = asst;
+			return api('GET', '/capabilities?course_id=' + asst.courseID);
+		}).then(function(capabilities) {
+			// features turned on for the course are marked on the page
+			capabilities.features.forEach(function(name) {
+				document.body.classList.add('feature-' + name);
+			});
+			return api('GET', '/problem_sets/' + assignment.problemSetID + '/problems');
+		}).then(function(psps) {
+			// adaptive problem sets only include the student's path
+			psps = psps.filter(function(psp) { return !psp.track || psp.track === assignment.path; });
+			return Promise.all(psps.map(loadProblem));
+		}).then(function(entries) {
+			problems = entries;
+			var select = document.getElementById('problem');
+			select.innerHTML = '';
+			problems.forEach(function(entry, i) {
+				var opt = document.createElement('option');
+				opt.value = i;
+				opt.textContent = entry.problem.unique;
+				select.appendChild(opt);
+			});
+			if (problems.length > 0) {
+				showProblem(problems[0]);
+			}
+			setStatus('Ready');
+		});
+	}
+
+	function captureEditor() {
+		if (current && currentFile && editor) {
+			current.files[currentFile] = editor.getValue();
+		}
+	}
+
+	function showProblem(entry) {
+		captureEditor();
+		current = entry;
+		document.getElementById('instructions').innerHTML =
+			'<h2>' + entry.problem.note.replace(/</g, '&lt;') + '</h2>' +
+			'<p><b>Step ' + entry.step.step + '</b></p>' + (entry.step.instructions || '');
+		var names = Object.keys(entry.files).sort();
+		var tabs = document.getElementById('tabs');
+		tabs.innerHTML = '';
+		names.forEach(function(name) {
+			var tab = document.createElement('button');
+			tab.textContent = name;
+			tab.onclick = function() { showFile(name); };
+			tabs.appendChild(tab);
+		});
+		currentFile = '';
+		if (names.length > 0) {
+			showFile(names[0]);
+		}
+	}
+
+	function showFile(name) {
+		captureEditor();
+		currentFile = name;
+		Array.prototype.forEach.call(document.getElementById('tabs').children, function(tab) {
+			tab.className = tab.textContent === name ? 'active' : '';
+		});
+		if (editor) {
+			monaco.editor.setModelLanguage(editor.getModel(), languageFor(name));
+			editor.setValue(current.files[name]);
+		}
+	}
+
+	function newCommit(action, note) {
+		captureEditor();
+		return {
+			assignmentID: assignmentID,
+			problemID: current.problem.id,
+			step: current.step.step,
+			action: action,
+			note: note,
+			files: current.files
+		};
+	}
+
+	// runDaycare sends a signed bundle to the daycare and streams the session to the terminal,
+	// resolving with the bundle the daycare sends back
+	function runDaycare(signed, action, args) {
+		return new Promise(function(resolve, reject) {
+			var url = 'wss://' + daycareHost + '/v2/sockets/' + signed.problem.problemType + '/' + action;
+			if (args) {
+				url += '?args=' + encodeURIComponent(args);
+			}
+			socket = new WebSocket(url);
+			var done = false;
+			socket.onopen = function() {
+				socket.send(JSON.stringify({ userID: userID, commitBundle: signed, share: shareMode() }));
+			};
+			socket.onmessage = function(msg) {
+				var reply = JSON.parse(msg.data);
+				if (reply.error) {
+					done = true;
+					reject(new Error(reply.error));
+				} else if (reply.commitBundle) {
+					done = true;
+					resolve(reply.commitBundle);
+				} else if (reply.shareID && shareMode() === 'broadcast') {
+					api('PUT', '/courses/' + courseID + '/broadcast', { shareID: reply.shareID, problem: signed.problem.unique, action: action }).then(function() {
+						setStatus('Broadcasting this run to the class at ' + location.origin + '/broadcast/' + courseID);
+					}, function(err) {
+						setStatus(err.message, true);
+					});
+				} else if (reply.shareID) {
+					setStatus('Sharing this run as ' + reply.shareID + ': ' + location.origin + '/watch/' + reply.shareID);
+				} else if (reply.event) {
+					var e = reply.event;
+					switch (e.event) {
+					case 'exec':
+						term.write('\x1b[36m$ ' + e.execcommand.join(' ') + '\x1b[0m\n');
+						break;
+					case 'stdout':
+						term.write(e.streamdata);
+						break;
+					case 'stderr':
+						term.write('\x1b[31m' + e.streamdata + '\x1b[0m');
+						break;
+					case 'exit':
+						term.write('\x1b[36m' + e.exitstatus + '\x1b[0m\n');
+						break;
+					case 'error':
+						term.write('\x1b[31mError: ' + e.error + '\x1b[0m\n');
+						break;
+					}
+				}
+			};
+			socket.onclose = function() {
+				socket = null;
+				if (!done) {
+					reject(new Error('lost the connection to the grader'));
+				}
+			};
+		});
+	}
+
+	function withBusy(label, f) {
+		if (busy || !current) {
+			return;
+		}
+		busy = true;
+		setStatus(label);
+		f().catch(function(err) {
+			setStatus(err.message, true);
+		}).then(function() {
+			busy = false;
+		});
+	}
+
+	function save() {
+		withBusy('Saving…', function() {
+			return api('POST', '/commit_bundles/unsigned', { commit: newCommit('', 'saving from the web workspace') }).then(function(signed) {
+				current.commit = signed.commit;
+				setStatus('Saved');
+			});
+		});
+	}
+
+	function run(action, args) {
+		withBusy('Starting…', function() {
+			term.reset();
+			term.focus();
+			return api('POST', '/commit_bundles/unsigned', { commit: newCommit(action, 'running from the web workspace') }).then(function(signed) {
+				setStatus('Running; type in the terminal to send input');
+				return runDaycare(signed, action, args);
+			}).then(function() {
+				setStatus('Finished');
+			});
+		});
+	}
+
+	function grade() {
+		withBusy('Grading…', function() {
+			term.reset();
+			var entry = current;
+			return api('POST', '/commit_bundles/unsigned', { commit: newCommit('grade', 'grading from the web workspace') }).then(function(signed) {
+				return runDaycare(signed, 'grade');
+			}).then(function(graded) {
+				return api('POST', '/commit_bundles/signed', { imports: graded.imports, matrix: graded.matrix, commit: graded.commit, commitSignature: graded.commitSignature });
+			}).then(function(saved) {
+				var commit = saved.commit;
+				entry.commit = commit;
+				var note = commit.reportCard ? commit.reportCard.note : '';
+				if (saved.path) {
+					// placement opens up new problems, so start over
+					setStatus('You have been placed on the ' + saved.path + ' path; loading the rest of the problem set…');
+					return load();
+				}
+				if (!passed(commit)) {
+					if (commit.reportCard && commit.reportCard.passed && commit.streak) {
+						setStatus('Passed ' + commit.streak.streak + ' of ' + commit.streak.required + ' times in a row; grade again for a new set of tests');
+					} else {
+						setStatus('Step ' + commit.step + ' failed: ' + note, true);
+					}
+					return;
+				}
+				return loadStep(entry, commit.step + 1, true).then(function() {
+					setStatus('Step ' + commit.step + ' passed; moving to step ' + entry.step.step);
+					showProblem(entry);
+				}, function() {
+					setStatus('Step ' + commit.step + ' passed; you have completed all steps for this problem');
+				});
+			});
+		});
+	}
+
+	document.getElementById('problem').onchange = function(e) {
+		showProblem(problems[e.target.value]);
+	};
+	document.getElementById('save').onclick = save;
+	document.getElementById('run').onclick = function() { run('interactive', currentFile); };
+	document.getElementById('shell').onclick = function() { run('adhoc'); };
+	document.getElementById('grade').onclick = grade;
+	document.getElementById('share-input').onchange = function() {
+		// changing your mind applies to a shared run already going
+		if (socket && socket.readyState === WebSocket.OPEN && shareMode() && shareMode() !== 'broadcast') {
+			socket.send(JSON.stringify({ share: shareMode() }));
+		}
+	};
+
+	require.config({ paths: { vs: {{.Lib}} + '/monaco-editor/min/vs' } });
+	require(['vs/editor/editor.main'], function() {
+		editor = monaco.editor.create(document.getElementById('editor'), { automaticLayout: true, value: '' });
+		if (currentFile) {
+			showFile(currentFile);
+		}
+	});
+
+	api('GET', '/users/me/announcements?assignment_id=' + assignmentID).then(showAnnouncements, function() {});
+
+	load().catch(function(err) {
+		setStatus(err.message, true);
+	});
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandAdminHealth(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	// an unhealthy server reports with a 503 status, which is still a report
+	url := fmt.Sprintf("https://%s/v2/health", Config.Host)
+	if Config.apiReport {
+		log.Printf("GET %s", url)
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		fatalf(exitUsage, "error creating http request: %v\n", err)
+	}
+	req.Header["Accept"] = []string{"application/json"}
+	req.Header["Cookie"] = []string{Config.Cookie}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		mustReportNetworkError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		mustReportAPIError(url, resp)
+	}
+	report := new(HealthReport)
+	if err := json.NewDecoder(resp.Body).Decode(report); err != nil {
+		fatalf(exitUsage, "failed to parse health report from server: %v\n", err)
+	}
+
+	fmt.Printf("%s (%v) at %s\n", report.Host, report.Roles, report.Time.Format("2006-01-02 15:04:05 MST"))
+	for _, check := range report.Checks {
+		status := "ok"
+		if !check.Healthy {
+			status = "FAILED"
+		}
+		fmt.Printf("  %-10s %-6s %s (%v)\n", check.Name, status, check.Message, check.Duration)
+	}
+	if !report.Healthy {
+		fatalf(exitUsage, "server is not healthy")
+	}
+	fmt.Println("server is healthy")
+}
+
+func CommandAdminReload(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	if len(args) != 0 {
+		usage(cmd)
+	}
+
+	report := new(ConfigReload)
+	mustPostObject("/config/reload", nil, nil, report)
+	if len(report.Reloaded) == 0 && len(report.NeedRestart) == 0 {
+		fmt.Println("no changes found in the server config file")
+		return
+	}
+	for _, name := range report.Reloaded {
+		fmt.Printf("  reloaded      %s\n", name)
+	}
+	for _, name := range report.NeedRestart {
+		fmt.Printf("  needs restart %s\n", name)
+	}
+}
+
+func CommandAdminFeature(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	if len(args) > 1 {
+		usage(cmd)
+	}
+
+	if len(args) == 0 {
+		flags := []*FeatureFlag{}
+		mustGetObject("/feature_flags", nil, &flags)
+		if len(flags) == 0 {
+			fmt.Println("no feature flags defined")
+		}
+		for _, flag := range flags {
+			printFeatureFlag(flag)
+		}
+		return
+	}
+
+	path := fmt.Sprintf("/feature_flags/%s", args[0])
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		fmt.Printf("feature %s removed\n", args[0])
+		return
+	}
+	flag := new(FeatureFlag)
+	flag.Percent, _ = cmd.Flags().GetFloat64("percent")
+	flag.Description, _ = cmd.Flags().GetString("description")
+	saved := new(FeatureFlag)
+	mustPutObject(path, nil, flag, saved)
+	printFeatureFlag(saved)
+}
+
+func printFeatureFlag(flag *FeatureFlag) {
+	fmt.Printf("  %-20s %5.1f%%  %s\n", flag.Name, flag.Percent, flag.Description)
+}
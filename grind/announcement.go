@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// printAnnouncements shows announcements the user has not acknowledged yet,
+// with a reminder of how to stop seeing them.
+func printAnnouncements(announcements []*Announcement) {
+	if len(announcements) == 0 {
+		return
+	}
+	fmt.Println()
+	title := fmt.Sprintf("%d announcement%s", len(announcements), plural(len(announcements)))
+	fmt.Println(title)
+	fmt.Println(dashes(len(title)))
+	for _, elt := range announcements {
+		fmt.Printf("%d: %s, %s\n", elt.ID, elt.Audience(), elt.CreatedAt.Local().Format("Jan 2 at 3:04pm"))
+		for _, line := range strings.Split(elt.Message, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+	fmt.Println()
+	fmt.Println(`use "grind ack ID" once you have read an announcement, or "grind ack --all"`)
+}
+
+func CommandAck(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	var ids []int64
+	if cmd.Flag("all").Value.String() == "true" {
+		if len(args) != 0 {
+			usage(cmd)
+		}
+		announcements := []*Announcement{}
+		mustGetObject("/users/me/announcements", nil, &announcements)
+		for _, elt := range announcements {
+			ids = append(ids, elt.ID)
+		}
+		if len(ids) == 0 {
+			log.Printf("there are no announcements to acknowledge")
+			return
+		}
+	} else {
+		if len(args) == 0 {
+			usage(cmd)
+		}
+		for _, arg := range args {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id < 1 {
+				fatalf(exitUsage, "announcement ID must be a number, not %q", arg)
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range ids {
+		mustPostObject(fmt.Sprintf("/users/me/announcements/%d/acknowledge", id), nil, nil, nil)
+	}
+	log.Printf("%d announcement%s acknowledged", len(ids), plural(len(ids)))
+}
+
+func CommandCourseAnnounce(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	path := fmt.Sprintf("/courses/%d/announcements", course.ID)
+
+	if remove := cmd.Flag("remove").Value.String(); remove != "0" {
+		if len(args) != 1 {
+			usage(cmd)
+		}
+		doRequest(path+"/"+remove, nil, "DELETE", nil, nil, false)
+		log.Printf("announcement %s withdrawn", remove)
+		return
+	}
+
+	if len(args) == 1 {
+		announcements := []*Announcement{}
+		mustGetObject(path, nil, &announcements)
+		if len(announcements) == 0 {
+			fmt.Printf("no announcements have been posted in %s\n", course.Label)
+			return
+		}
+		for _, elt := range announcements {
+			fmt.Printf("%d: %s, %s, acknowledged by %d\n", elt.ID, elt.Audience(), elt.CreatedAt.Local().Format("Jan 2 at 3:04pm"), elt.Acknowledged)
+			for _, line := range strings.Split(elt.Message, "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+		return
+	}
+
+	announcement := &Announcement{Message: strings.Join(args[1:], " ")}
+	if unique := cmd.Flag("problem-set").Value.String(); unique != "" {
+		announcement.ProblemSetID = mustFindCourseProblemSet(course, unique).ID
+	}
+	saved := new(Announcement)
+	mustPostObject(path, nil, announcement, saved)
+	log.Printf("announcement %d posted to %s", saved.ID, saved.Audience())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandArchiveList(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	if len(args) != 0 {
+		usage(cmd)
+	}
+
+	archive := []*ArchivedAssignment{}
+	mustGetObject("/users/me/archive", nil, &archive)
+	if len(archive) == 0 {
+		fmt.Println("you have no work from past courses available to download")
+		return
+	}
+	for _, elt := range archive {
+		fmt.Printf("%d: %s (%s)\n", elt.AssignmentID, elt.CanvasTitle, elt.Name())
+		why := "closed"
+		if elt.Dropped {
+			why = "dropped"
+		}
+		fmt.Printf("    score %.1f%%, %s %s", elt.Score*100.0, why, elt.ClosedAt.Local().Format("2006-01-02"))
+		if !elt.AvailableUntil.IsZero() {
+			fmt.Printf(", available until %s", elt.AvailableUntil.Local().Format("2006-01-02"))
+		}
+		fmt.Println()
+	}
+}
+
+func CommandArchiveGet(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		fatalf(exitUsage, "give the assignment ID shown by \"grind archive list\"")
+	}
+
+	work := new(ArchivedWork)
+	mustGetObject(fmt.Sprintf("/users/me/archive/%d", id), nil, work)
+	mustVerifyReport(work.Report)
+
+	rootDir := filepath.Join("archive", work.Assignment.CourseLabel, work.Assignment.ProblemSetUnique)
+	if len(args) == 2 {
+		rootDir = args[1]
+	}
+	if _, err := os.Stat(rootDir); err == nil {
+		errorLog.Printf("directory %s already exists", rootDir)
+		fatalf(exitUsage, "give a different directory or delete it first")
+	} else if !os.IsNotExist(err) {
+		fatalf(exitUsage, "error checking if directory %s exists: %v", rootDir, err)
+	}
+
+	var uniques []string
+	for unique := range work.Commits {
+		uniques = append(uniques, unique)
+	}
+	sort.Strings(uniques)
+	for _, unique := range uniques {
+		commit := work.Commits[unique]
+		mustVerifyCommit(commit)
+
+		// as with "grind get", a problem set with one problem uses the main directory
+		target := rootDir
+		if len(uniques) > 1 {
+			target = filepath.Join(rootDir, unique)
+		}
+		log.Printf("writing problem %s as of step %d", unique, commit.Step)
+		for name, contents := range commit.Files {
+			path := filepath.Join(target, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(path), err)
+			}
+			if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+				fatalf(exitUsage, "error saving file %s: %v", path, err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", rootDir, err)
+	}
+	path := filepath.Join(rootDir, gradeReportFile)
+	contents, err := json.MarshalIndent(work.Report, "", "    ")
+	if err != nil {
+		fatalf(exitUsage, "JSON error encoding %s: %v", path, err)
+	}
+	contents = append(contents, '\n')
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		fatalf(exitUsage, "error saving file %s: %v", path, err)
+	}
+	printReport(work.Report)
+	log.Printf("work saved in %s; this copy is read-only and cannot be submitted", rootDir)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// artifactsSubdir is where artifacts from the last grading run are saved,
+// under the problem directory. Files in subdirectories are never uploaded.
+const artifactsSubdir = "grind-artifacts"
+
+// saveArtifacts writes the artifacts from a report card, such as replays and
+// screenshots, into the problem directory, replacing those from the last grade.
+func saveArtifacts(dir string, card *ReportCard) {
+	if card == nil {
+		return
+	}
+	artifactsDir := filepath.Join(dir, artifactsSubdir)
+	if err := os.RemoveAll(artifactsDir); err != nil {
+		log.Printf("error removing old artifacts: %v", err)
+		return
+	}
+	if len(card.Artifacts) == 0 {
+		return
+	}
+	if err := os.MkdirAll(artifactsDir, 0755); err != nil {
+		log.Printf("error creating %s: %v", artifactsDir, err)
+		return
+	}
+	for _, artifact := range card.Artifacts {
+		if err := ValidArtifactName(artifact.Name); err != nil {
+			log.Printf("skipping artifact: %v", err)
+			continue
+		}
+		name := artifact.Name
+		if artifact.Variant != "" {
+			// the same grader ran against each variant, so keep their artifacts apart
+			name = artifact.Variant + "-" + name
+		}
+		path := filepath.Join(artifactsDir, name)
+		if err := ioutil.WriteFile(path, artifact.Contents, 0644); err != nil {
+			log.Printf("error saving artifact %s: %v", path, err)
+			continue
+		}
+		if artifact.Result != "" {
+			log.Printf("  saved %s for %s", path, artifact.Result)
+		} else {
+			log.Printf("  saved %s", path)
+		}
+	}
+}
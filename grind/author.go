@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandAuthorCompat(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	switch len(args) {
+	case 0:
+		params := map[string]string{"failing": "true"}
+		if cmd.Flag("all").Value.String() == "true" {
+			params = nil
+		}
+		report := []*ProblemCompatibility{}
+		mustGetObject("/problem_compatibility", params, &report)
+		if len(report) == 0 {
+			fmt.Println("no compatibility problems found")
+			return
+		}
+		failed := 0
+		for _, elt := range report {
+			status := "ok"
+			if !elt.Passed {
+				status = "FAILED"
+				failed++
+			}
+			fmt.Printf("%-6s %s (%s) %s %s\n", status, elt.Unique, elt.ProblemType, toolchainName(elt.Image, elt.ImageID, elt.Version), elt.ValidatedAt.Format("2006-01-02"))
+			if !elt.Passed {
+				fmt.Printf("       %s\n", elt.Note)
+			}
+		}
+		if failed > 0 {
+			fatalf(exitUsage, "%d problem%s failed with the current toolchain", failed, plural(failed))
+		}
+
+	case 1:
+		problems := []*Problem{}
+		mustGetObject("/problems", map[string]string{"unique": args[0]}, &problems)
+		if len(problems) != 1 {
+			fatalf(exitUsage, "no problem found with unique ID %s", args[0])
+		}
+		validations := []*ProblemValidation{}
+		mustGetObject(fmt.Sprintf("/problems/%d/validations", problems[0].ID), nil, &validations)
+		if len(validations) == 0 {
+			fmt.Printf("%s has no recorded toolchain validations\n", args[0])
+			return
+		}
+		for _, elt := range validations {
+			status := "ok"
+			if !elt.Passed {
+				status = "FAILED"
+			}
+			fmt.Printf("%s %-6s %s: %s\n", elt.ValidatedAt.Format("2006-01-02 15:04"), status, toolchainName(elt.Image, elt.ImageID, elt.Version), elt.Note)
+		}
+
+	default:
+		usage(cmd)
+	}
+}
+
+func CommandAuthorCanary(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	params := map[string]string{"failing": "true"}
+	if cmd.Flag("all").Value.String() == "true" {
+		params = nil
+	}
+	results := []*CanaryResult{}
+	mustGetObject("/canary_results", params, &results)
+	if len(results) == 0 {
+		fmt.Println("no failures found in the nightly regrade")
+		return
+	}
+	failed := 0
+	for _, elt := range results {
+		status := "ok"
+		if !elt.Passed {
+			status = "FAILED"
+			failed++
+		}
+		fmt.Printf("%-6s %s on %s %s %s\n", status, elt.Unique, elt.Host, toolchainName(elt.Image, elt.ImageID, elt.Version), elt.RanAt.Local().Format("2006-01-02"))
+		if !elt.Passed {
+			fmt.Printf("       failing since %s: %s\n", elt.FailingSince.Local().Format("2006-01-02"), elt.Note)
+		}
+	}
+	if failed > 0 {
+		fatalf(exitUsage, "%d problem%s failed the nightly regrade", failed, plural(failed))
+	}
+}
+
+func CommandAuthorCosts(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	var params map[string]string
+	if term := cmd.Flag("term").Value.String(); term != "" {
+		params = map[string]string{"term": term}
+	}
+	report := []*ProblemCost{}
+	mustGetObject("/problem_costs", params, &report)
+	if len(report) == 0 {
+		fmt.Println("no grading runs have been measured")
+		return
+	}
+	expensive := 0
+	fmt.Printf("   %-30s %-12s %8s %10s %10s %10s %10s\n", "problem", "term", "runs", "runtime", "cpu", "memory", "peak")
+	for _, elt := range report {
+		mark := " "
+		if elt.Expensive {
+			mark = "*"
+			expensive++
+		}
+		fmt.Printf("%s  %-30s %-12s %8d %9.1fs %9.1fs %8.0fMB %8.0fMB\n", mark, elt.Unique, elt.Term, elt.Runs,
+			elt.AverageRuntime(), elt.AverageCPU(), elt.AverageMemory(), elt.MaxMemoryMB)
+	}
+	if expensive > 0 {
+		fmt.Printf("\n* the %d most expensive problem%s by total CPU time; speeding up their test suites\n"+
+			"  will save the most grading capacity\n", expensive, plural(expensive))
+	}
+}
+
+func toolchainName(image, imageID, version string) string {
+	id := imageID
+	if len(id) > 19 {
+		// sha256:abcdef012345
+		id = id[:19]
+	}
+	if version != "" {
+		return fmt.Sprintf("%s %s [%s]", image, version, id)
+	}
+	return fmt.Sprintf("%s [%s]", image, id)
+}
+
+func CommandAuthorPath(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	remove := cmd.Flag("remove").Value.String() == "true"
+	if len(args) != 2 && !(remove && len(args) == 1) {
+		usage(cmd)
+	}
+	sets := []*ProblemSet{}
+	mustGetObject("/problem_sets", map[string]string{"unique": args[0]}, &sets)
+	if len(sets) != 1 {
+		fatalf(exitUsage, "no problem set found with unique ID %s", args[0])
+	}
+	set := sets[0]
+	path := fmt.Sprintf("/problem_sets/%d/path", set.ID)
+
+	if remove {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("every problem in %s is now given to every student", set.Unique)
+		return
+	}
+
+	config := &ProblemSetPath{
+		DiagnosticProblemID: mustFindProblem(args[1]).ID,
+		Threshold:           mustParsePercent(cmd.Flag("threshold").Value.String()),
+	}
+	remedial, err := cmd.Flags().GetStringSlice("remedial")
+	if err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	advanced, err := cmd.Flags().GetStringSlice("advanced")
+	if err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if len(remedial) == 0 || len(advanced) == 0 {
+		fatalf(exitUsage, "you must give at least one remedial and one advanced problem")
+	}
+	for _, unique := range remedial {
+		config.Remedial = append(config.Remedial, mustFindProblem(unique).ID)
+	}
+	for _, unique := range advanced {
+		config.Advanced = append(config.Advanced, mustFindProblem(unique).ID)
+	}
+
+	problemSetProblems := []*ProblemSetProblem{}
+	mustPutObject(path, nil, config, &problemSetProblems)
+	log.Printf("%s now places students with %s at %.0f%%", set.Unique, args[1], config.Threshold*100.0)
+	log.Printf("the problem set has %d problem%s", len(problemSetProblems), plural(len(problemSetProblems)))
+}
+
+func mustFindProblem(unique string) *Problem {
+	problems := []*Problem{}
+	mustGetObject("/problems", map[string]string{"unique": unique}, &problems)
+	if len(problems) != 1 {
+		fatalf(exitUsage, "no problem found with unique ID %s", unique)
+	}
+	return problems[0]
+}
+
+func CommandAuthorEnv(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	problem := mustFindProblem(args[0])
+	path := fmt.Sprintf("/problems/%d/variables", problem.ID)
+
+	if len(args) == 2 {
+		name, value := args[1], ""
+		hasValue := false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		if cmd.Flag("remove").Value.String() == "true" {
+			doRequest(path+"/"+name, nil, "DELETE", nil, nil, false)
+			log.Printf("%s no longer sets %s", problem.Unique, name)
+			return
+		}
+		secret := cmd.Flag("secret").Value.String() == "true"
+		if !hasValue {
+			if !secret {
+				usage(cmd)
+			}
+			// read secrets from stdin so they stay out of shell history
+			fmt.Fprintf(os.Stderr, "value for %s: ", name)
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				fatalf(exitUsage, "error reading the value: %v", err)
+			}
+			value = strings.TrimRight(line, "\r\n")
+		}
+		variable := &ProblemVariable{Name: name, Value: value, Secret: secret}
+		mustPutObject(path+"/"+name, nil, variable, nil)
+	}
+
+	variables := []*ProblemVariable{}
+	mustGetObject(path, nil, &variables)
+	if len(variables) == 0 {
+		fmt.Printf("%s sets no environment variables\n", problem.Unique)
+		return
+	}
+	for _, elt := range variables {
+		if elt.Secret {
+			fmt.Printf("%s (secret)\n", elt.Name)
+		} else {
+			fmt.Printf("%s=%s\n", elt.Name, elt.Value)
+		}
+	}
+}
+
+func CommandAuthorMatrix(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 {
+		usage(cmd)
+	}
+	problem := mustFindProblem(args[0])
+	path := fmt.Sprintf("/problems/%d/toolchain_variants", problem.ID)
+
+	remove := cmd.Flag("remove").Value.String() == "true"
+	for _, arg := range args[1:] {
+		if remove {
+			doRequest(path+"/"+arg, nil, "DELETE", nil, nil, false)
+			continue
+		}
+		i := strings.Index(arg, "=")
+		if i < 1 {
+			fatalf(exitUsage, "toolchain versions must be given as LABEL=IMAGE, not %q", arg)
+		}
+		variant := &ToolchainVariant{Label: arg[:i], Image: arg[i+1:]}
+		mustPutObject(path+"/"+variant.Label, nil, variant, nil)
+	}
+
+	matrix := []*ToolchainVariant{}
+	mustGetObject(path, nil, &matrix)
+	if len(matrix) == 0 {
+		fmt.Printf("%s is graded with the usual image for %s\n", problem.Unique, problem.ProblemType)
+		return
+	}
+	fmt.Printf("%s is graded against %d toolchain version%s:\n", problem.Unique, len(matrix), plural(len(matrix)))
+	for _, elt := range matrix {
+		fmt.Printf("    %s=%s\n", elt.Label, elt.Image)
+	}
+}
+
+func CommandAuthorImport(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	remove := cmd.Flag("remove").Value.String() == "true"
+	if len(args) < 1 || (remove && len(args) != 2) || (!remove && len(args) == 2) {
+		usage(cmd)
+	}
+	sets := []*ProblemSet{}
+	mustGetObject("/problem_sets", map[string]string{"unique": args[0]}, &sets)
+	if len(sets) != 1 {
+		fatalf(exitUsage, "no problem set found with unique ID %s", args[0])
+	}
+	set := sets[0]
+	path := fmt.Sprintf("/problem_sets/%d/imports", set.ID)
+
+	if len(args) > 1 {
+		problem := mustFindProblem(args[1])
+		if remove {
+			doRequest(fmt.Sprintf("%s/%d", path, problem.ID), nil, "DELETE", nil, nil, false)
+			log.Printf("%s no longer uses files from another problem", problem.Unique)
+			return
+		}
+		from := mustFindProblem(args[2])
+		imp := &ProblemSetImport{FromProblemID: from.ID, Files: args[3:]}
+		mustPutObject(fmt.Sprintf("%s/%d", path, problem.ID), nil, imp, nil)
+	}
+
+	imports := []*ProblemSetImport{}
+	mustGetObject(path, nil, &imports)
+	if len(imports) == 0 {
+		fmt.Printf("no problems in %s use files from another problem\n", set.Unique)
+		return
+	}
+	problems := make(map[int64]string)
+	unique := func(id int64) string {
+		if _, exists := problems[id]; !exists {
+			problem := new(Problem)
+			mustGetObject(fmt.Sprintf("/problems/%d", id), nil, problem)
+			problems[id] = problem.Unique
+		}
+		return problems[id]
+	}
+	for _, elt := range imports {
+		fmt.Printf("%s uses %s from %s\n", unique(elt.ProblemID), strings.Join(elt.Files, ", "), unique(elt.FromProblemID))
+	}
+}
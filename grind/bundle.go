@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// stepBundleEndpoint downloads a problem step as one gzip tarball instead of JSON.
+const stepBundleEndpoint = "GET /problems/:problem_id/steps/:step/bundle"
+
+// getStepBundle downloads a problem step as a gzip tarball and unpacks it.
+// It returns nil if notfoundokay is set and there is no such step.
+func getStepBundle(problemID, step int64, notfoundokay bool) *ProblemStep {
+	path := fmt.Sprintf("/problems/%d/steps/%d/bundle", problemID, step)
+	var raw []byte
+	if !doRequest(path, nil, "GET", nil, &raw, notfoundokay) {
+		return nil
+	}
+	problemStep, err := unpackStepBundle(raw)
+	if err != nil {
+		fatalf(exitServer, "error reading %s from server: %v", path, err)
+	}
+	return problemStep
+}
+
+// getMirroredStep downloads a problem step from the server's asset mirror.
+// It returns nil if the mirror cannot provide it, so the caller can ask the server instead.
+func getMirroredStep(problemID, step int64) *ProblemStep {
+	mirror := new(StepMirror)
+	if !getObject(fmt.Sprintf("/problems/%d/steps/%d/mirror", problemID, step), nil, mirror) {
+		return nil
+	}
+	if Config.apiReport {
+		log.Printf("GET %s", mirror.URL)
+	}
+	resp, err := http.Get(mirror.URL)
+	if err != nil {
+		if Config.apiReport {
+			log.Printf("error downloading from the asset mirror: %v", err)
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if Config.apiReport {
+			log.Printf("the asset mirror returned %s", resp.Status)
+		}
+		return nil
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if Config.apiReport {
+			log.Printf("error downloading from the asset mirror: %v", err)
+		}
+		return nil
+	}
+	problemStep, err := unpackStepBundle(raw)
+	if err != nil || problemStep.ProblemID != problemID || problemStep.Step != step {
+		if Config.apiReport {
+			log.Printf("the asset mirror sent something other than step %d of problem %d", step, problemID)
+		}
+		return nil
+	}
+	return problemStep
+}
+
+// unpackStepBundle reads a problem step from a gzip tarball.
+func unpackStepBundle(raw []byte) (*ProblemStep, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	reader := tar.NewReader(gz)
+	problemStep := new(ProblemStep)
+	files := make(map[string]string)
+	found := false
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case header.Name == StepBundleMetadata:
+			if err := json.Unmarshal(contents, problemStep); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", StepBundleMetadata, err)
+			}
+			found = true
+		case strings.HasPrefix(header.Name, StepBundleFilesDir):
+			files[strings.TrimPrefix(header.Name, StepBundleFilesDir)] = string(contents)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is missing", StepBundleMetadata)
+	}
+	problemStep.Files = files
+	return problemStep, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// Problem steps (instructions, images, and starter files) are kept in the cache directory,
+// named for a hash of the problem, the step, and when the problem was last changed.
+// A problem that is updated gets new names, so stale copies are never used.
+const stepCacheSubdir = "steps" // in the cache directory
+
+// stepSources records where steps that are not in the cache can be downloaded from.
+type stepSources struct {
+	bundles bool // the server can send a step as one compressed download
+	mirror  bool // the server has an asset mirror
+}
+
+// stepSource is nil until the first step that is not in the cache is downloaded.
+var stepSource *stepSources
+
+// stepCachePath returns where a step of a problem is kept in the cache directory.
+func stepCachePath(problem *Problem, step int64) (string, error) {
+	dir, err := xdgDir("XDG_CACHE_HOME", ".cache")
+	if err != nil {
+		return "", err
+	}
+	version := fmt.Sprintf("%d/%d/%s", problem.ID, step, problem.UpdatedAt.UTC().Format(time.RFC3339Nano))
+	sum := sha256.Sum256([]byte(version))
+	return filepath.Join(dir, stepCacheSubdir, hex.EncodeToString(sum[:12])+".json"), nil
+}
+
+func mustGetProblemStep(problem *Problem, step int64) *ProblemStep {
+	return getProblemStep(problem, step, false)
+}
+
+// getProblemStep returns a step of a problem, from the cache if possible.
+// It returns nil if notfoundokay is set and there is no such step.
+// Failing to use the cache is not an error; the step is downloaded instead.
+func getProblemStep(problem *Problem, step int64, notfoundokay bool) *ProblemStep {
+	path, err := stepCachePath(problem, step)
+	if err == nil {
+		if raw, err := ioutil.ReadFile(path); err == nil {
+			cached := new(ProblemStep)
+			if err := json.Unmarshal(raw, cached); err == nil && cached.ProblemID == problem.ID && cached.Step == step {
+				if Config.apiReport {
+					log.Printf("using step %d of problem %s from %s", step, problem.Unique, path)
+				}
+				return cached
+			}
+		}
+	}
+
+	if stepSource == nil {
+		// newer servers can send each step as a single compressed download,
+		// and may point to a mirror to download it from instead
+		capabilities := getCapabilities(nil)
+		stepSource = &stepSources{
+			bundles: capabilities != nil && capabilities.Supports(stepBundleEndpoint),
+			mirror:  capabilities != nil && capabilities.AssetMirror != "",
+		}
+	}
+	var problemStep *ProblemStep
+	if stepSource.mirror {
+		problemStep = getMirroredStep(problem.ID, step)
+	}
+	if problemStep == nil && stepSource.bundles {
+		problemStep = getStepBundle(problem.ID, step, notfoundokay)
+	} else if problemStep == nil {
+		problemStep = new(ProblemStep)
+		if !doRequest(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, step), nil, "GET", nil, problemStep, notfoundokay) {
+			problemStep = nil
+		}
+	}
+	if problemStep == nil || path == "" {
+		return problemStep
+	}
+
+	if raw, err := json.Marshal(problemStep); err == nil {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			ioutil.WriteFile(path, raw, 0600)
+		}
+	}
+	return problemStep
+}
+
+func CommandCacheClean(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	dir := filepath.Join(mustFindDir("XDG_CACHE_HOME", ".cache"), stepCacheSubdir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		log.Printf("the cache is already empty")
+		return
+	} else if err != nil {
+		fatalf(exitUsage, "error reading %s: %v", dir, err)
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size()
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		fatalf(exitUsage, "error removing %s: %v", dir, err)
+	}
+	log.Printf("removed %d cached step%s (%d KB) from %s", len(entries), plural(len(entries)), (size+1023)/1024, dir)
+}
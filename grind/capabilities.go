@@ -0,0 +1,66 @@
+package main
+
+import (
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// requirement is an API endpoint that a command needs, written the way the
+// server lists it, e.g. "PUT /courses/:course_id/term". If Flag is set,
+// the endpoint is only needed when that flag is used.
+type requirement struct {
+	Flag     string
+	Endpoint string
+}
+
+var commandRequirements = make(map[*cobra.Command][]requirement)
+
+// requires records endpoints a command needs that an older server may not offer.
+// The command is refused up front on such servers instead of failing partway through.
+func requires(cmd *cobra.Command, endpoints ...string) {
+	for _, endpoint := range endpoints {
+		commandRequirements[cmd] = append(commandRequirements[cmd], requirement{Endpoint: endpoint})
+	}
+}
+
+// requiresWithFlag records an endpoint a command only needs when a flag is used.
+func requiresWithFlag(cmd *cobra.Command, flag string, endpoint string) {
+	commandRequirements[cmd] = append(commandRequirements[cmd], requirement{Flag: flag, Endpoint: endpoint})
+}
+
+// getCapabilities asks the server what it offers,
+// returning nil if the server is too old to say.
+func getCapabilities(params map[string]string) *Capabilities {
+	capabilities := new(Capabilities)
+	if !doRequest("/capabilities", params, "GET", nil, capabilities, true) {
+		return nil
+	}
+	return capabilities
+}
+
+// checkCapabilities stops with an explanation if the server lacks an endpoint
+// the command needs. Only commands with recorded requirements ask the server.
+func checkCapabilities(cmd *cobra.Command) {
+	var needed []requirement
+	for _, req := range commandRequirements[cmd] {
+		if req.Flag == "" || cmd.Flags().Changed(req.Flag) {
+			needed = append(needed, req)
+		}
+	}
+	if len(needed) == 0 {
+		return
+	}
+
+	capabilities := getCapabilities(nil)
+	for _, req := range needed {
+		if capabilities.Supports(req.Endpoint) {
+			continue
+		}
+		what := cmd.CommandPath()
+		if req.Flag != "" {
+			what += " --" + req.Flag
+		}
+		errorLog.Printf("the server at %s (version %s) does not support %s", Config.Host, capabilities.Version, req.Endpoint)
+		fatalf(exitServer, "  \"%s\" needs a newer server; ask your administrator to upgrade", what)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCheckoff(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	var email, assignment string
+	switch len(args) {
+	case 1:
+		assignment = args[0]
+	case 2:
+		email, assignment = args[0], args[1]
+	default:
+		usage(cmd)
+	}
+	parts := strings.SplitN(assignment, "/", 2)
+	if len(parts) != 2 {
+		fatalf(exitUsage, "give the assignment as course/problem-set, not %q", assignment)
+	}
+	course := mustFindCourse(parts[0])
+	problemSet := mustFindCourseProblemSet(course, parts[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/checkoffs", course.ID, problemSet.ID)
+
+	if email == "" {
+		checkoffs := []*Checkoff{}
+		mustGetObject(path, nil, &checkoffs)
+		if len(checkoffs) == 0 {
+			fmt.Printf("no students have been checked off for %s\n", problemSet.Unique)
+			return
+		}
+		for _, elt := range checkoffs {
+			fmt.Printf("%-30s %s by %s", elt.Student, elt.CheckedOffAt.Local().Format("2006-01-02 15:04"), elt.Grader)
+			if elt.Note != "" {
+				fmt.Printf(": %s", elt.Note)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	student, name := mustFindStudent(email)
+	if cmd.Flag("undo").Value.String() == "true" {
+		doRequest(path+"/"+student, nil, "DELETE", nil, nil, false)
+		log.Printf("check-off for %s on %s undone", name, problemSet.Unique)
+		return
+	}
+	checkoff := &Checkoff{Note: cmd.Flag("note").Value.String()}
+	saved := new(Checkoff)
+	mustPutObject(path+"/"+student, nil, checkoff, saved)
+	log.Printf("%s checked off on %s at %s", name, problemSet.Unique, saved.CheckedOffAt.Local().Format("2006-01-02 15:04"))
+}
+
+func CommandCourseCheckoff(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/checkoff_policy", course.ID, problemSet.ID)
+
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("%s no longer requires a check-off", problemSet.Unique)
+		return
+	}
+	policy := new(CheckoffPolicy)
+	mustPutObject(path, nil, &CheckoffPolicy{Weight: mustParsePercent(cmd.Flag("weight").Value.String())}, policy)
+	log.Printf("%s now requires a check-off worth %.0f%% of the grade", problemSet.Unique, policy.Weight*100.0)
+}
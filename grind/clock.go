@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// serverOffset is how far the server clock is ahead of the local clock,
+// measured from the most recent response.
+var serverOffset time.Duration
+
+var skewWarned bool
+
+// noteServerTime measures the local clock against the server clock reported
+// in a response, warning once if they are too far apart. The request is assumed
+// to have reached the server halfway between when it was sent and when the
+// response arrived.
+func noteServerTime(resp *http.Response, sent, received time.Time) {
+	var server time.Time
+	if header := resp.Header.Get(ServerTimeHeader); header != "" {
+		t, err := time.Parse(time.RFC3339Nano, header)
+		if err != nil {
+			return
+		}
+		server = t
+	} else if t, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		server = t
+	} else {
+		return
+	}
+	serverOffset = server.Sub(sent.Add(received.Sub(sent) / 2))
+
+	skew := serverOffset
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > MaxClockSkew && !skewWarned {
+		skewWarned = true
+		direction := "behind"
+		if serverOffset < 0 {
+			direction = "ahead of"
+		}
+		errorLog.Printf("warning: your computer's clock is %v %s the server", skew.Round(time.Second), direction)
+		errorLog.Printf("  deadlines are decided by the server's clock, so set your clock to update automatically")
+	}
+}
+
+// serverNow returns the current time according to the server clock,
+// as best it is known from responses so far.
+func serverNow() time.Time {
+	return time.Now().Add(serverOffset)
+}
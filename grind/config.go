@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// configKey describes a setting in the config file that "grind config" can show and change.
+type configKey struct {
+	name   string
+	env    string // environment variable that overrides the config file, if any
+	value  string // default used when the setting is missing
+	help   string
+	field  func(*grindConfig) *string
+	secret bool                         // only show the start of the value when listing
+	check  func(string) (string, error) // validates a value, returning it in normal form
+}
+
+var configKeys = []*configKey{
+	{
+		name:  "host",
+		env:   hostOverrideVariable,
+		value: defaultHost,
+		help:  "name of the CodeGrinder server, with an optional :port",
+		field: func(c *grindConfig) *string { return &c.Host },
+		check: checkConfigHost,
+	},
+	{
+		name:   "cookie",
+		env:    cookieOverrideVariable,
+		help:   "login cookie from \"grind init\"",
+		field:  func(c *grindConfig) *string { return &c.Cookie },
+		secret: true,
+		check:  checkConfigCookie,
+	},
+	{
+		name:  "confirm",
+		value: confirmAsk,
+		help:  "how to confirm operations that overwrite work: ask, strict, or never",
+		field: func(c *grindConfig) *string { return &c.Confirm },
+		check: checkConfigConfirm,
+	},
+	{
+		name:  "credentials",
+		value: credentialsKeychain,
+		help:  "where to keep the cookie: keychain if the system has one, or file",
+		field: func(c *grindConfig) *string { return &c.Credentials },
+		check: checkConfigCredentials,
+	},
+	{
+		name:  "state",
+		value: stateDotFile,
+		help:  "where to keep what grind knows about each problem set: dotfile, or home to use the state directory",
+		field: func(c *grindConfig) *string { return &c.State },
+		check: checkConfigState,
+	},
+	{
+		name:  "reportKey",
+		help:  "public key grade reports must be signed with, pinned by \"grind init\"",
+		field: func(c *grindConfig) *string { return &c.ReportKey },
+		check: checkConfigReportKey,
+	},
+}
+
+// internalConfigKeys are kept in the config file by grind itself and cannot be set.
+var internalConfigKeys = map[string]bool{
+	"maintenance": true,
+}
+
+func findConfigKey(name string) *configKey {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key
+		}
+	}
+	var names []string
+	for _, key := range configKeys {
+		names = append(names, key.name)
+	}
+	fatalf(exitUsage, "unknown setting %q; the settings are %s", name, strings.Join(names, ", "))
+	return nil
+}
+
+func checkConfigHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if strings.Contains(host, "://") {
+		return "", fmt.Errorf("give the server name without %s", host[:strings.Index(host, "://")+3])
+	}
+	host = strings.TrimSuffix(host, "/")
+	if strings.Contains(host, "/") {
+		return "", fmt.Errorf("give just the server name, without a path")
+	}
+
+	// IPv6 addresses are written in brackets, which are needed to add a port
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 {
+			return "", fmt.Errorf("%q is missing the ] after the IPv6 address", host)
+		}
+		if ip := net.ParseIP(host[1:end]); ip == nil || ip.To4() != nil {
+			return "", fmt.Errorf("%q is not a valid IPv6 address", host[1:end])
+		}
+		if rest := host[end+1:]; rest != "" {
+			port, err := strconv.Atoi(strings.TrimPrefix(rest, ":"))
+			if !strings.HasPrefix(rest, ":") || err != nil || port < 1 || port > 65535 {
+				return "", fmt.Errorf("%q is not a valid port", strings.TrimPrefix(rest, ":"))
+			}
+		}
+		return strings.ToLower(host), nil
+	}
+
+	name := host
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		name = host[:i]
+		port, err := strconv.Atoi(host[i+1:])
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("%q is not a valid port", host[i+1:])
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("the server name is missing")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", fmt.Errorf("%q is not a valid server name", name)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return "", fmt.Errorf("%q is not a valid server name", name)
+			}
+		}
+	}
+	return strings.ToLower(host), nil
+}
+
+func checkConfigCookie(cookie string) (string, error) {
+	cookie = strings.TrimSpace(cookie)
+	if cookie == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(cookie, CookieName+"=") {
+		cookie = CookieName + "=" + cookie
+	}
+	if strings.ContainsAny(cookie, " ;\t") {
+		return "", fmt.Errorf("the cookie should be a single %s=... value; perhaps you copied the wrong thing?", CookieName)
+	}
+	return cookie, nil
+}
+
+func checkConfigConfirm(mode string) (string, error) {
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	switch mode {
+	case "", confirmAsk, confirmStrict, confirmNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("must be %s, %s, or %s, not %q", confirmAsk, confirmStrict, confirmNever, mode)
+}
+
+func checkConfigReportKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", nil
+	}
+	if _, err := DecodeReportSigningKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// mustReadConfigFile loads the config file into Config, checking every setting.
+// It returns false if there is no config file, leaving the defaults in Config.
+func checkConfigCredentials(where string) (string, error) {
+	where = strings.ToLower(strings.TrimSpace(where))
+	switch where {
+	case "", credentialsKeychain, credentialsFile:
+		return where, nil
+	}
+	return "", fmt.Errorf("must be %s or %s, not %q", credentialsKeychain, credentialsFile, where)
+}
+
+func checkConfigState(state string) (string, error) {
+	state = strings.ToLower(strings.TrimSpace(state))
+	switch state {
+	case "", stateDotFile, stateHome:
+		return state, nil
+	}
+	return "", fmt.Errorf("must be %s or %s, not %q", stateDotFile, stateHome, state)
+}
+
+func mustReadConfigFile(path string) bool {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		for _, key := range configKeys {
+			*key.field(&Config) = key.value
+		}
+		return false
+	} else if err != nil {
+		fatalf(exitUsage, "error reading %s: %v", path, err)
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		errorLog.Printf("%s is not valid: %s", path, describeJSONError(raw, err))
+		fatalf(exitUsage, "it should look like {\"host\": \"%s\", \"cookie\": \"%s=...\"}", defaultHost, CookieName)
+	}
+	if err := json.Unmarshal(raw, &Config); err != nil {
+		fatalf(exitUsage, "%s is not valid: %s", path, describeJSONError(raw, err))
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		known := internalConfigKeys[name]
+		for _, key := range configKeys {
+			known = known || key.name == name
+		}
+		if !known {
+			errorLog.Printf("ignoring unknown setting %q in %s", name, path)
+		}
+	}
+
+	for _, key := range configKeys {
+		field := key.field(&Config)
+		value, err := key.check(*field)
+		if err != nil {
+			errorLog.Printf("the %s setting in %s is not valid: %v", key.name, path, err)
+			fatalf(exitUsage, "use \"grind config set %s VALUE\" to fix it", key.name)
+		}
+		if value == "" {
+			value = key.value
+		}
+		*field = value
+	}
+	if Config.Keychain {
+		loadKeychainCookie()
+	}
+	return true
+}
+
+// applyConfigEnvironment lets environment variables override settings from the config file.
+// The values from the file are remembered so they are the ones saved if the file is rewritten.
+func applyConfigEnvironment() {
+	for _, key := range configKeys {
+		if key.env == "" || os.Getenv(key.env) == "" {
+			continue
+		}
+		value, err := key.check(os.Getenv(key.env))
+		if err != nil {
+			fatalf(exitUsage, "%s is not valid: %v", key.env, err)
+		}
+		if Config.fileValues == nil {
+			Config.fileValues = make(map[string]string)
+		}
+		field := key.field(&Config)
+		Config.fileValues[key.name] = *field
+		*field = value
+	}
+}
+
+// describeJSONError explains where a JSON error was found in raw.
+func describeJSONError(raw []byte, err error) string {
+	var offset int64
+	switch elt := err.(type) {
+	case *json.SyntaxError:
+		offset = elt.Offset
+	case *json.UnmarshalTypeError:
+		if elt.Field != "" {
+			return fmt.Sprintf("%s should be a %v, not a %s", elt.Field, elt.Type, elt.Value)
+		}
+		offset = elt.Offset
+	default:
+		return err.Error()
+	}
+	line, column := 1, 1
+	for _, b := range raw[:offset] {
+		if b == '\n' {
+			line, column = line+1, 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Sprintf("line %d, column %d: %v", line, column, err)
+}
+
+func CommandConfigList(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	path := mustFindConfigFile()
+	if !mustReadConfigFile(path) {
+		fmt.Printf("%s does not exist; run \"grind init\" to create it\n", path)
+	}
+	applyConfigEnvironment()
+
+	for _, key := range configKeys {
+		value := *key.field(&Config)
+		if key.secret && len(value) > len(CookieName)+9 {
+			value = value[:len(CookieName)+9] + "..."
+		}
+		source := ""
+		if _, exists := Config.fileValues[key.name]; exists {
+			source = " (from " + key.env + ")"
+		} else if key.name == "cookie" && Config.Keychain && value != "" {
+			source = " (in the " + platformKeychain().String() + ")"
+		} else if value == "" {
+			source = " (not set)"
+		} else if value == key.value {
+			source = " (default)"
+		}
+		fmt.Printf("%-8s %s%s\n", key.name, value, source)
+		fmt.Printf("         %s\n", key.help)
+	}
+}
+
+func CommandConfigGet(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	key := findConfigKey(args[0])
+	mustReadConfigFile(mustFindConfigFile())
+	applyConfigEnvironment()
+	fmt.Println(*key.field(&Config))
+}
+
+func CommandConfigSet(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	key := findConfigKey(args[0])
+	value, err := key.check(args[1])
+	if err != nil {
+		fatalf(exitUsage, "%s: %v", key.name, err)
+	}
+	if value == "" {
+		value = key.value
+	}
+	path := mustFindConfigFile()
+	mustReadConfigFile(path)
+
+	field := key.field(&Config)
+	if key.name == "host" && value != *field {
+		// a maintenance notice belongs to the old server
+		Config.Maintenance = nil
+	}
+	*field = value
+	mustWriteConfig()
+	if key.env != "" && os.Getenv(key.env) != "" {
+		errorLog.Printf("note: %s is set and overrides this setting", key.env)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Settings for the confirm option in the config file, which controls how grind asks
+// before an operation that overwrites or discards work.
+const (
+	confirmAsk    = "ask"    // ask, and require typing a name for the riskiest operations (the default)
+	confirmStrict = "strict" // require typing yes, not just y, when there is no name to type
+	confirmNever  = "never"  // never ask, as though --force were always given
+)
+
+// confirmation describes an operation that overwrites or discards work.
+type confirmation struct {
+	action  string   // what will happen, e.g., "overwrite 3 files in CS-1400/cs1400-loops"
+	changes []string // one line for each thing that will change
+	name    string   // for the riskiest operations, a name the user must type to go ahead
+}
+
+// addForceFlag gives a command that uses mustConfirm the --force flag to skip confirmation.
+func addForceFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolP("force", "f", false, "go ahead without asking for confirmation")
+}
+
+// mustConfirm shows what an operation will change and asks the user to go ahead,
+// exiting if they do not. It returns without asking if --force was given or the
+// config file says never to ask. When not running interactively, --force is required.
+func mustConfirm(cmd *cobra.Command, c *confirmation) {
+	mode := Config.Confirm
+	switch mode {
+	case "":
+		mode = confirmAsk
+	case confirmAsk, confirmStrict, confirmNever:
+	default:
+		fatalf(exitUsage, "confirm in the config file must be %s, %s, or %s, not %q", confirmAsk, confirmStrict, confirmNever, mode)
+	}
+	if cmd.Flag("force").Value.String() == "true" || mode == confirmNever {
+		return
+	}
+
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		errorLog.Printf("this would %s", c.action)
+		fatalf(exitUsage, "not running interactively; use --force to go ahead without confirmation")
+	}
+
+	fmt.Printf("this will %s:\n", c.action)
+	for _, line := range c.changes {
+		fmt.Printf("    %s\n", line)
+	}
+	reader := bufio.NewReader(os.Stdin)
+	if c.name != "" {
+		fmt.Printf("type %s to go ahead: ", c.name)
+	} else if mode == confirmStrict {
+		fmt.Printf("type yes to go ahead: ")
+	} else {
+		fmt.Printf("go ahead? [y/N] ")
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		fatalf(exitUsage, "error reading response: %v", err)
+	}
+	answer := strings.TrimSpace(line)
+	switch {
+	case c.name != "" && answer == c.name:
+		return
+	case c.name == "" && mode == confirmStrict && strings.ToLower(answer) == "yes":
+		return
+	case c.name == "" && mode == confirmAsk && (strings.ToLower(answer) == "y" || strings.ToLower(answer) == "yes"):
+		return
+	}
+	fatalf(exitUsage, "cancelled; nothing was changed")
+}
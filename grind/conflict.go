@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// serverSuffix is added to the names of server copies written alongside local files
+// when a conflict is resolved by keeping both.
+const serverSuffix = ".server"
+
+// takeSnapshot records the files as they were last synchronized with the server,
+// so later changes on either side can be detected.
+func takeSnapshot(info *ProblemInfo, files map[string]string, syncedAt time.Time) {
+	info.Snapshot = make(map[string]string)
+	for name, contents := range files {
+		info.Snapshot[name] = FileChecksum(contents)
+	}
+	info.SyncedAt = syncedAt
+}
+
+// reconcile compares local files with the most recent commit on the server
+// using the snapshot from the last time they were synchronized.
+// Files changed on only one side take that side's version; files changed on
+// both sides are conflicts, resolved according to mode (local, server, or both),
+// or by asking the user if mode is empty.
+// It returns the files to use and whether it is safe to save them to the server.
+func reconcile(dir string, info *ProblemInfo, unique string, local map[string]string, server *Commit, mode string) (map[string]string, bool) {
+	// nothing on the server, or nothing to compare against
+	if server == nil || info.Snapshot == nil {
+		return local, true
+	}
+
+	// has the server moved on since we last synchronized?
+	if server.UpdatedAt.Round(time.Second).Equal(info.SyncedAt.Round(time.Second)) {
+		return local, true
+	}
+	if server.Step > info.Step {
+		log.Printf("the server copy of %s is at step %d, but this copy is at step %d", unique, server.Step, info.Step)
+		log.Printf("  it was probably worked on from another computer")
+		if mustChooseConflictMode(mode, false) != "local" {
+			fatalf(exitUsage, "use \"grind get\" on this problem set to move this copy to step %d, or keep the local copy", server.Step)
+		}
+		return local, true
+	}
+	if server.Step < info.Step {
+		return local, true
+	}
+
+	// three-way comparison of each file
+	merged := make(map[string]string)
+	var conflicts []string
+	for name, contents := range local {
+		merged[name] = contents
+	}
+	for name, theirs := range server.Files {
+		mine, exists := local[name]
+		base := info.Snapshot[name]
+		switch {
+		case exists && FileChecksum(FixLineEndings(mine)) == FileChecksum(theirs):
+			// same on both sides
+		case FileChecksum(theirs) == base:
+			// only changed locally
+		case exists && FileChecksum(FixLineEndings(mine)) == base:
+			// only changed on the server
+			log.Printf("taking server copy of %s, which changed since it was last synchronized", name)
+			mustWriteProblemFile(dir, name, theirs)
+			merged[name] = theirs
+		case !exists:
+			// not present locally, but changed on the server
+			mustWriteProblemFile(dir, name, theirs)
+			merged[name] = theirs
+		default:
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) == 0 {
+		return merged, true
+	}
+
+	sort.Strings(conflicts)
+	log.Printf("these files in %s were changed both here and on the server (probably from another computer):", unique)
+	for _, name := range conflicts {
+		log.Printf("  %s", name)
+	}
+	switch mustChooseConflictMode(mode, true) {
+	case "local":
+		log.Printf("keeping the local copies")
+		return merged, true
+	case "server":
+		for _, name := range conflicts {
+			log.Printf("taking the server copy of %s", name)
+			mustWriteProblemFile(dir, name, server.Files[name])
+			merged[name] = server.Files[name]
+		}
+		return merged, true
+	default:
+		for _, name := range conflicts {
+			log.Printf("writing the server copy of %s as %s", name, name+serverSuffix)
+			mustWriteProblemFile(dir, name+serverSuffix, server.Files[name])
+		}
+		log.Printf("merge the changes you want to keep into the local files, delete the %s copies,", serverSuffix)
+		log.Printf("then save again and choose to keep the local copies")
+		return merged, false
+	}
+}
+
+// mustChooseConflictMode returns the conflict mode given on the command line,
+// or asks the user to choose one.
+func mustChooseConflictMode(mode string, allowBoth bool) string {
+	switch mode {
+	case "local", "server":
+		return mode
+	case "both":
+		if allowBoth {
+			return mode
+		}
+		return "server"
+	case "":
+	default:
+		fatalf(exitUsage, "conflict resolution must be local, server, or both, not %q", mode)
+	}
+
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		fatalf(exitUsage, "not running interactively; use --conflict=local, --conflict=server, or --conflict=both")
+	}
+	prompt := "keep [l]ocal copies or take [s]erver copies? "
+	if allowBoth {
+		prompt = "keep [l]ocal copies, take [s]erver copies, or keep [b]oth? "
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print(prompt)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			fatalf(exitUsage, "error reading response: %v", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "l", "local":
+			return "local"
+		case "s", "server":
+			return "server"
+		case "b", "both":
+			if allowBoth {
+				return "both"
+			}
+		}
+	}
+}
+
+func mustWriteProblemFile(dir, name, contents string) {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		fatalf(exitUsage, "error saving file %s: %v", path, err)
+	}
+}
+
+// mustGetLastCommit returns the most recent commit on the server for a problem, or nil if there is none.
+func mustGetLastCommit(assignmentID, problemID int64) *Commit {
+	commit := new(Commit)
+	if !getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", assignmentID, problemID), nil, commit) {
+		return nil
+	}
+	mustVerifyCommit(commit)
+	return commit
+}
+
+// mustReconcileCommit checks a commit formed from local files against the server
+// before it is saved, updating the commit with the files to save.
+// It returns false if the commit should not be saved.
+func mustReconcileCommit(dir string, dotfile *DotFileInfo, problem *Problem, commit *Commit, mode string) bool {
+	info := dotfile.Problems[problem.Unique]
+	server := mustGetLastCommit(dotfile.AssignmentID, problem.ID)
+	files, ok := reconcile(dir, info, problem.Unique, commit.Files, server, mode)
+	commit.Files = files
+	if !ok {
+		log.Printf("problem %s step %d not saved", problem.Unique, commit.Step)
+	}
+	return ok
+}
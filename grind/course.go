@@ -0,0 +1,597 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCourseTerm(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 2 || len(args) > 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	term := &CourseTerm{Term: args[1], Archived: course.Archived, ArchiveOverride: course.ArchiveOverride}
+	if len(args) == 3 {
+		term.EndsAt = mustParseDate(args[2])
+	}
+
+	mustPutObject(fmt.Sprintf("/courses/%d/term", course.ID), nil, term, course)
+	printCourseTerm(course)
+}
+
+func CommandCourseArchive(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	term := &CourseTerm{
+		Term:     course.Term,
+		EndsAt:   course.EndsAt,
+		Archived: cmd.Flag("undo").Value.String() != "true",
+	}
+
+	// un-archiving a course whose term has ended would not make it active,
+	// and the override keeps the LMS and the end-of-term archiving from undoing it
+	if !term.Archived && !term.EndsAt.IsZero() && term.EndsAt.Before(serverNow()) {
+		term.EndsAt = time.Time{}
+		term.ArchiveOverride = true
+	}
+
+	if term.Archived {
+		mustConfirm(cmd, &confirmation{
+			action:  fmt.Sprintf("archive %s (%s)", course.Label, course.Name),
+			changes: []string{"the course closes to students, who can still download their earlier work for a time"},
+		})
+	} else {
+		mustConfirm(cmd, &confirmation{
+			action:  fmt.Sprintf("make %s (%s) active again", course.Label, course.Name),
+			changes: []string{"the course opens to students again"},
+		})
+	}
+
+	mustPutObject(fmt.Sprintf("/courses/%d/term", course.ID), nil, term, course)
+	printCourseTerm(course)
+}
+
+func CommandCourseRollForward(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	from := mustFindCourse(args[0])
+	to := mustFindCourse(args[1])
+	rollForward := &CourseRollForward{
+		FromCourseID: from.ID,
+		Term:         cmd.Flag("term").Value.String(),
+	}
+	if ends := cmd.Flag("ends").Value.String(); ends != "" {
+		rollForward.EndsAt = mustParseDate(ends)
+	}
+	mustConfirm(cmd, &confirmation{
+		action: fmt.Sprintf("roll %s forward into %s", from.Label, to.Label),
+		changes: []string{
+			fmt.Sprintf("problem sets offered in %s are added to %s", from.Label, to.Label),
+			fmt.Sprintf("course settings in %s, such as problem type overrides, are replaced by those from %s", to.Label, from.Label),
+			fmt.Sprintf("%s becomes active if it was archived", to.Label),
+		},
+		name: to.Label,
+	})
+
+	problemSets := []*ProblemSet{}
+	mustPostObject(fmt.Sprintf("/courses/%d/roll_forward", to.ID), nil, rollForward, &problemSets)
+	fmt.Printf("%s now offers %d problem set%s:\n", to.Label, len(problemSets), plural(len(problemSets)))
+	for _, problemSet := range problemSets {
+		fmt.Printf("  %s: %s\n", problemSet.Unique, problemSet.Note)
+	}
+}
+
+func mustFindCourse(label string) *Course {
+	courses := []*Course{}
+	mustGetObject("/courses", map[string]string{"lti_label": label}, &courses)
+	if len(courses) == 0 {
+		fatalf(exitUsage, "no course found with label %s", label)
+	}
+	if len(courses) > 1 {
+		fatalf(exitUsage, "found %d courses with label %s", len(courses), label)
+	}
+	return courses[0]
+}
+
+func mustParseDate(s string) time.Time {
+	// the term ends at the end of the given day
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		fatalf(exitUsage, "date must be in the form YYYY-MM-DD: %v", err)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func printCourseTerm(course *Course) {
+	fmt.Printf("%s (%s)\n", course.Label, course.Name)
+	if course.Term != "" {
+		fmt.Printf("  term:   %s\n", course.Term)
+	}
+	if !course.EndsAt.IsZero() {
+		fmt.Printf("  ends:   %s\n", course.EndsAt.Format("2006-01-02 15:04 MST"))
+	}
+	status := "active"
+	if !course.IsActive(serverNow()) {
+		status = "inactive"
+	}
+	if course.ArchiveOverride {
+		status += " (kept active by an instructor after the term ended)"
+	}
+	fmt.Printf("  status: %s\n", status)
+}
+
+func CommandCourseProblemType(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	name := args[1]
+	path := fmt.Sprintf("/courses/%d/problem_type_overrides/%s", course.ID, name)
+
+	if cmd.Flag("reset").Value.String() == "true" {
+		mustConfirm(cmd, &confirmation{
+			action:  fmt.Sprintf("remove the %s overrides in %s", name, course.Label),
+			changes: []string{fmt.Sprintf("all grading in %s uses the %s defaults from then on", course.Label, name)},
+		})
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		fmt.Printf("%s now uses the %s defaults\n", course.Label, name)
+		return
+	}
+
+	override := new(ProblemTypeOverride)
+	flags := cmd.Flags()
+	override.Image, _ = flags.GetString("image")
+	override.MaxCPU, _ = flags.GetInt("max-cpu")
+	override.MaxClock, _ = flags.GetInt("max-clock")
+	override.MaxFD, _ = flags.GetInt("max-fd")
+	override.MaxFileSize, _ = flags.GetInt("max-file-size")
+	override.MaxMemory, _ = flags.GetInt("max-memory")
+	override.MaxThreads, _ = flags.GetInt("max-threads")
+	override.Options, _ = flags.GetStringSlice("option")
+
+	saved := new(ProblemTypeOverride)
+	mustPutObject(path, nil, override, saved)
+	problemType := new(ProblemType)
+	mustGetObject(fmt.Sprintf("/problem_types/%s", name), nil, problemType)
+	fmt.Printf("%s (%s)\n", course.Label, course.Name)
+	printProblemType(saved.Apply(problemType), saved)
+}
+
+func CommandCourseAnonymous(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+
+	anon := new(AnonymousGrading)
+	mustPutObject(fmt.Sprintf("/courses/%d/problem_sets/%d/anonymous", course.ID, problemSet.ID), nil, nil, anon)
+	fmt.Printf("grading for %s in %s is anonymous\n", problemSet.Unique, course.Label)
+	fmt.Printf("grades will be posted when you run \"grind course finalize %s %s\"\n", course.Label, problemSet.Unique)
+}
+
+func CommandCourseFinalize(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+
+	// grades posted to the LMS cannot be taken back
+	grades := []*GradeEntry{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_sets/%d/grades", course.ID, problemSet.ID), nil, &grades)
+	mustConfirm(cmd, &confirmation{
+		action: fmt.Sprintf("reveal identities and post grades for %d student%s in %s", len(grades), plural(len(grades)), course.Label),
+		changes: []string{
+			"graders will see which student wrote each submission",
+			"each student's current score replaces their grade in the LMS",
+		},
+		name: problemSet.Unique,
+	})
+
+	grades = []*GradeEntry{}
+	mustPostObject(fmt.Sprintf("/courses/%d/problem_sets/%d/finalize", course.ID, problemSet.ID), nil, nil, &grades)
+	fmt.Printf("grading for %s in %s is finalized and grades have been posted\n", problemSet.Unique, course.Label)
+	printGrades(grades, false)
+}
+
+func CommandCourseGrades(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+
+	params := map[string]string{}
+	if section := cmd.Flag("section").Value.String(); section != "" {
+		params["section_id"] = strconv.FormatInt(mustFindSection(course, section).ID, 10)
+	}
+
+	grades := []*GradeEntry{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_sets/%d/grades", course.ID, problemSet.ID), params, &grades)
+	printGrades(grades, cmd.Flag("csv").Value.String() == "true")
+}
+
+func printGrades(grades []*GradeEntry, asCSV bool) {
+	if asCSV {
+		out := csv.NewWriter(os.Stdout)
+		out.Write([]string{"name", "email", "score"})
+		for _, elt := range grades {
+			out.Write([]string{elt.Name, elt.Email, fmt.Sprintf("%.5f", elt.Score)})
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			fatalf(exitUsage, "error writing CSV: %v", err)
+		}
+		return
+	}
+
+	if len(grades) > 0 && grades[0].Anonymous {
+		fmt.Printf("grading is anonymous; students are shown by pseudonym\n")
+	}
+	for _, elt := range grades {
+		name := elt.Name
+		if elt.Email != "" {
+			name += " <" + elt.Email + ">"
+		}
+		fmt.Printf("  %6.1f%%  %s\n", elt.Score*100.0, name)
+	}
+}
+
+func CommandCourseModerate(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	email := cmd.Flag("marker").Value.String()
+	if email == "" {
+		fatalf(exitUsage, "you must give the email address of the second marker with --marker")
+	}
+	marker := mustFindUserByEmail(email)
+
+	flags := cmd.Flags()
+	percent, _ := flags.GetFloat64("percent")
+	threshold, _ := flags.GetFloat64("threshold")
+	moderation := &Moderation{
+		SamplePercent:  percent,
+		Threshold:      threshold / 100.0,
+		SecondMarkerID: marker.ID,
+	}
+	saved := new(Moderation)
+	mustPutObject(fmt.Sprintf("/courses/%d/problem_sets/%d/moderation", course.ID, problemSet.ID), nil, moderation, saved)
+
+	marks := []*ModerationMark{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_sets/%d/moderation_marks", course.ID, problemSet.ID), nil, &marks)
+	fmt.Printf("%d student%s in %s chosen for second marking by %s\n", len(marks), plural(len(marks)), problemSet.Unique, marker.Name)
+	fmt.Printf("differences of more than %.1f percentage points will be flagged\n", saved.Threshold*100.0)
+}
+
+func CommandCourseMarks(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+
+	marks := []*ModerationMark{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_sets/%d/moderation_marks", course.ID, problemSet.ID), nil, &marks)
+	if len(marks) == 0 {
+		fmt.Printf("no students have been chosen for second marking\n")
+		return
+	}
+	for _, mark := range marks {
+		printModerationMark(mark)
+	}
+}
+
+func CommandCourseMark(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	markID := mustParseID(args[1])
+	mark := &Mark{Score: mustParsePercent(args[2]), Note: cmd.Flag("note").Value.String()}
+
+	saved := new(ModerationMark)
+	mustPutObject(fmt.Sprintf("/courses/%d/moderation_marks/%d/second", course.ID, markID), nil, mark, saved)
+	printModerationMark(saved)
+}
+
+func CommandCourseReconcile(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	markID := mustParseID(args[1])
+	mark := &Mark{Score: mustParsePercent(args[2]), Note: cmd.Flag("note").Value.String()}
+
+	saved := new(ModerationMark)
+	mustPutObject(fmt.Sprintf("/courses/%d/moderation_marks/%d/reconcile", course.ID, markID), nil, mark, saved)
+	printModerationMark(saved)
+}
+
+func printModerationMark(mark *ModerationMark) {
+	student := mark.Student
+	if student == "" {
+		student = fmt.Sprintf("assignment %d", mark.AssignmentID)
+	}
+	fmt.Printf("%4d  %-30s  %s\n", mark.ID, student, mark.Status())
+	fmt.Printf("        first: %5.1f%%", mark.FirstScore*100.0)
+	if !mark.SecondMarkedAt.IsZero() {
+		fmt.Printf("  second: %5.1f%%", mark.SecondScore*100.0)
+	}
+	if !mark.ReconciledAt.IsZero() {
+		fmt.Printf("  reconciled: %5.1f%%", mark.ReconciledScore*100.0)
+	}
+	fmt.Println()
+}
+
+func mustFindUserByEmail(email string) *User {
+	users := []*User{}
+	mustGetList("/users", map[string]string{"email": email}, &users)
+	for _, user := range users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+	fatalf(exitUsage, "no user found with email address %s", email)
+	return nil
+}
+
+// mustFindStudent returns how to name a student in a URL, and how to name them to the user.
+// The student is given by email address, or by pseudonym while grading is anonymous.
+func mustFindStudent(student string) (string, string) {
+	if strings.HasPrefix(student, PseudonymPrefix) {
+		return student, student
+	}
+	user := mustFindUserByEmail(student)
+	return strconv.FormatInt(user.ID, 10), user.Name
+}
+
+func mustParseID(s string) int64 {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 1 {
+		fatalf(exitUsage, "%q is not a valid ID", s)
+	}
+	return id
+}
+
+func mustParsePercent(s string) float64 {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || percent < 0.0 || percent > 100.0 {
+		fatalf(exitUsage, "score must be a percentage between 0 and 100")
+	}
+	return percent / 100.0
+}
+
+func CommandCourseSections(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+
+	sections := []*Section{}
+	mustGetObject(fmt.Sprintf("/courses/%d/sections", course.ID), nil, &sections)
+	if len(sections) == 0 {
+		fmt.Printf("no sections found; they are recorded as students launch problem sets from the LMS\n")
+		return
+	}
+	for _, section := range sections {
+		fmt.Printf("%d: %s (%d student%s)\n", section.ID, section.Name, section.Students, plural(section.Students))
+		for _, ta := range section.TAs {
+			if ta.Email == "" {
+				fmt.Printf("    TA: %s\n", ta.Name)
+			} else {
+				fmt.Printf("    TA: %s <%s>\n", ta.Name, ta.Email)
+			}
+		}
+	}
+}
+
+func CommandCourseTA(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	section := mustFindSection(course, args[1])
+	user := mustFindUserByEmail(args[2])
+
+	path := fmt.Sprintf("/courses/%d/sections/%d/tas/%d", course.ID, section.ID, user.ID)
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("%s is no longer a TA for %s", user.Name, section.Name)
+		return
+	}
+	mustPutObject(path, nil, nil, nil)
+	log.Printf("%s is now a TA for %s", user.Name, section.Name)
+}
+
+func mustFindSection(course *Course, nameOrID string) *Section {
+	sections := []*Section{}
+	mustGetObject(fmt.Sprintf("/courses/%d/sections", course.ID), nil, &sections)
+	for _, section := range sections {
+		if section.Name == nameOrID || strconv.FormatInt(section.ID, 10) == nameOrID {
+			return section
+		}
+	}
+	fatalf(exitUsage, "no section %s found in course %s", nameOrID, course.Label)
+	return nil
+}
+
+func CommandCourseRoster(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+
+	path := fmt.Sprintf("/courses/%d/roster_syncs", course.ID)
+	report := new(RosterSync)
+	if cmd.Flag("sync").Value.String() == "true" {
+		mustPostObject(path, nil, nil, report)
+	} else {
+		reports := []*RosterSync{}
+		mustGetObject(path, nil, &reports)
+		if len(reports) == 0 {
+			fmt.Printf("the roster for %s has not been compared with the LMS yet\n", course.Label)
+			return
+		}
+		report = reports[0]
+	}
+
+	fmt.Printf("roster for %s as of %s\n", course.Label, report.CreatedAt.Format("2006-01-02 15:04 MST"))
+	if report.Error != "" {
+		fmt.Printf("  error: %s\n", report.Error)
+		return
+	}
+	fmt.Printf("  %d student%s enrolled in the LMS\n", report.Students, plural(report.Students))
+	if !report.HasDrift() {
+		fmt.Printf("  no drift found\n")
+	}
+	printRosterEntries("dropped", report.Dropped)
+	printRosterEntries("returned", report.Returned)
+	printRosterEntries("never launched a problem set", report.Missing)
+}
+
+func printRosterEntries(label string, entries []*RosterEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Printf("  %s:\n", label)
+	for _, elt := range entries {
+		fmt.Printf("    %s <%s>\n", elt.Name, elt.Email)
+	}
+}
+
+func CommandCourseRequire(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 && len(args) != 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/prerequisites", course.ID, problemSet.ID)
+
+	if len(args) == 2 {
+		prerequisites := []*Prerequisite{}
+		mustGetObject(path, nil, &prerequisites)
+		if len(prerequisites) == 0 {
+			fmt.Printf("%s has no prerequisites\n", problemSet.Unique)
+			return
+		}
+		fmt.Printf("%s requires:\n", problemSet.Unique)
+		for _, elt := range prerequisites {
+			required := new(ProblemSet)
+			mustGetObject(fmt.Sprintf("/problem_sets/%d", elt.RequiredProblemSetID), nil, required)
+			fmt.Printf("  %s at %.0f%%\n", required.Unique, elt.Threshold*100.0)
+		}
+		return
+	}
+
+	required := mustFindCourseProblemSet(course, args[2])
+	path += fmt.Sprintf("/%d", required.ID)
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("%s no longer requires %s", problemSet.Unique, required.Unique)
+		return
+	}
+	prerequisite := &Prerequisite{Threshold: mustParsePercent(cmd.Flag("threshold").Value.String())}
+	if prerequisite.Threshold == 0.0 {
+		fatalf(exitUsage, "threshold must be greater than zero")
+	}
+	mustPutObject(path, nil, prerequisite, prerequisite)
+	log.Printf("%s is locked until %s is completed at %.0f%%", problemSet.Unique, required.Unique, prerequisite.Threshold*100.0)
+}
+
+func CommandCourseFeature(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+
+	if len(args) == 2 {
+		path := fmt.Sprintf("/courses/%d/feature_flags/%s", course.ID, args[1])
+		switch {
+		case cmd.Flag("reset").Value.String() == "true":
+			mustConfirm(cmd, &confirmation{
+				action:  fmt.Sprintf("reset the %s setting in %s", args[1], course.Label),
+				changes: []string{"the feature follows the rollout set by the administrators"},
+			})
+			doRequest(path, nil, "DELETE", nil, nil, false)
+		case cmd.Flag("off").Value.String() == "true":
+			mustPutObject(path, nil, &CourseFeatureFlag{Enabled: false}, nil)
+		default:
+			mustPutObject(path, nil, &CourseFeatureFlag{Enabled: true}, nil)
+		}
+	}
+
+	settings := []*CourseFeatureFlag{}
+	mustGetObject(fmt.Sprintf("/courses/%d/feature_flags", course.ID), nil, &settings)
+	set := make(map[string]bool)
+	for _, setting := range settings {
+		set[setting.Name] = true
+	}
+	capabilities := getCapabilities(map[string]string{"course_id": strconv.FormatInt(course.ID, 10)})
+	if capabilities == nil {
+		fatalf(exitServer, "the server at %s does not report which features are turned on", Config.Host)
+	}
+
+	fmt.Printf("%s (%s)\n", course.Label, course.Name)
+	if len(capabilities.Features) == 0 {
+		fmt.Println("  no features are turned on")
+	}
+	for _, name := range capabilities.Features {
+		how := "rollout"
+		if set[name] {
+			how = "course setting"
+		}
+		fmt.Printf("  %-20s on (%s)\n", name, how)
+	}
+	for _, setting := range settings {
+		if !setting.Enabled {
+			fmt.Printf("  %-20s off (course setting)\n", setting.Name)
+		}
+	}
+}
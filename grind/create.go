@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -33,55 +32,10 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 	case 1:
 		d = args[0]
 	default:
-		cmd.Help()
-		return
-	}
-	dir, err := filepath.Abs(d)
-	if err != nil {
-		log.Fatalf("error finding directory %q: %v", d, err)
-	}
-
-	// find the problem.cfg file
-	for {
-		path := filepath.Join(dir, ProblemConfigName)
-		if _, err := os.Stat(path); err != nil {
-			if os.IsNotExist(err) {
-				// try moving up a directory
-				old := dir
-				dir = filepath.Dir(dir)
-				if dir == old {
-					log.Fatalf("unable to find %s in %s or an ancestor directory", ProblemConfigName, d)
-				}
-				log.Printf("could not find %s in %s, trying %s", ProblemConfigName, old, dir)
-				continue
-			}
-
-			log.Fatalf("error searching for %s in %s: %v", ProblemConfigName, dir, err)
-		}
-		break
-	}
-
-	// parse problem.cfg
-	cfg := struct {
-		Problem struct {
-			Unique string
-			Note   string
-			Type   string
-			Tag    []string
-			Option []string
-		}
-		Step map[string]*struct {
-			Note   string
-			Weight float64
-		}
-	}{}
-
-	configPath := filepath.Join(dir, ProblemConfigName)
-	fmt.Printf("reading %s\n", configPath)
-	err = gcfg.ReadFileInto(&cfg, configPath)
-	if err != nil {
-		log.Fatalf("failed to parse %s: %v", configPath, err)
+		usage(cmd)
 	}
+	dir := mustFindProblemDir(d)
+	cfg := mustReadProblemConfig(dir)
 
 	// create problem object
 	problem := &Problem{
@@ -90,6 +44,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 		ProblemType: cfg.Problem.Type,
 		Tags:        cfg.Problem.Tag,
 		Options:     cfg.Problem.Option,
+		Mastery:     cfg.Problem.Mastery,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
@@ -106,25 +61,25 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 	case 0:
 		// new problem
 		if cmd.Flag("update").Value.String() == "true" {
-			log.Fatalf("you specified --update, but no existing problem with unique ID %q was found", problem.Unique)
+			fatalf(exitUsage, "you specified --update, but no existing problem with unique ID %q was found", problem.Unique)
 		}
 
 		// make sure the problem set with this unique name is free as well
 		existingSets := []*ProblemSet{}
 		mustGetObject("/problem_sets", map[string]string{"unique": problem.Unique}, &existingSets)
 		if len(existingSets) > 1 {
-			log.Fatalf("error: server found multiple problem sets with matching unique ID %q", problem.Unique)
+			fatalf(exitUsage, "error: server found multiple problem sets with matching unique ID %q", problem.Unique)
 		}
 		if len(existingSets) != 0 {
-			log.Printf("problem set %d already exists with unique ID %q", existingSets[0].ID, existingSets[0].Unique)
-			log.Fatalf("  this would prevent creating a problem set containing just this problem with matching id")
+			errorLog.Printf("problem set %d already exists with unique ID %q", existingSets[0].ID, existingSets[0].Unique)
+			fatalf(exitUsage, "  this would prevent creating a problem set containing just this problem with matching id")
 		}
 
 		log.Printf("this problem is new--no existing problem has the same unique ID")
 	case 1:
 		// update to existing problem
 		if cmd.Flag("update").Value.String() == "false" {
-			log.Fatalf("you did not specify --update, but a problem already exists with unique ID %q", problem.Unique)
+			fatalf(exitUsage, "you did not specify --update, but a problem already exists with unique ID %q", problem.Unique)
 		}
 		log.Printf("unique ID is %s", problem.Unique)
 		log.Printf("  this is an update of problem %d (%q)", existing[0].ID, existing[0].Note)
@@ -132,7 +87,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 		problem.CreatedAt = existing[0].CreatedAt
 	default:
 		// server does not know what "unique" means
-		log.Fatalf("error: server found multiple problems with matching unique ID %q", problem.Unique)
+		fatalf(exitUsage, "error: server found multiple problems with matching unique ID %q", problem.Unique)
 	}
 
 	// generate steps
@@ -145,6 +100,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 			Note:   s.Note,
 			Weight: s.Weight,
 			Files:  make(map[string]string),
+			Carry:  s.Carry,
 		}
 		commit := &Commit{
 			Step:      i,
@@ -160,20 +116,20 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 		stepdir := filepath.Join(dir, strconv.FormatInt(i, 10))
 		err := filepath.Walk(stepdir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				log.Fatalf("walk error for %s: %v", path, err)
+				fatalf(exitUsage, "walk error for %s: %v", path, err)
 			}
 			if info.IsDir() {
 				return nil
 			}
 			relpath, err := filepath.Rel(stepdir, path)
 			if err != nil {
-				log.Fatalf("error finding relative path of %s: %v", path, err)
+				fatalf(exitUsage, "error finding relative path of %s: %v", path, err)
 			}
 
 			// load the file and add it to the appropriate place
 			contents, err := ioutil.ReadFile(path)
 			if err != nil {
-				log.Fatalf("error reading %s: %v", relpath, err)
+				fatalf(exitUsage, "error reading %s: %v", relpath, err)
 			}
 
 			// pick out solution/starter files
@@ -191,12 +147,12 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 			return nil
 		})
 		if err != nil {
-			log.Fatalf("walk error for %s: %v", stepdir, err)
+			fatalf(exitUsage, "walk error for %s: %v", stepdir, err)
 		}
 
 		// find starter files and solution files
 		if len(solution) > 0 && len(starter) > 0 && len(root) > 0 {
-			log.Fatalf("found files in _starter, _solution, and root directory; unsure how to proceed")
+			fatalf(exitUsage, "found files in _starter, _solution, and root directory; unsure how to proceed")
 		}
 		if len(solution) > 0 {
 			// explicit solution
@@ -205,7 +161,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 			solution = root
 			root = nil
 		} else {
-			log.Fatalf("no solution files found in _solution or root directory; problem must have a solution")
+			fatalf(exitUsage, "no solution files found in _solution or root directory; problem must have a solution")
 		}
 		if len(starter) == 0 && root != nil {
 			starter = root
@@ -235,7 +191,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 	}
 
 	if len(unsigned.ProblemSteps) != len(cfg.Step) {
-		log.Fatalf("expected to find %d step%s, but only found %d", len(cfg.Step), plural(len(cfg.Step)), len(unsigned.ProblemSteps))
+		fatalf(exitUsage, "expected to find %d step%s, but only found %d", len(cfg.Step), plural(len(cfg.Step)), len(unsigned.ProblemSteps))
 	}
 
 	// get user ID
@@ -253,6 +209,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 			Problem:          signed.Problem,
 			ProblemSteps:     signed.ProblemSteps,
 			ProblemSignature: signed.ProblemSignature,
+			Environment:      signed.Environment,
 			Commit:           signed.Commits[n],
 			CommitSignature:  signed.CommitSignatures[n],
 		}
@@ -278,7 +235,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 					color.Red("Error: %s\n", event.Error)
 				}
 			}
-			log.Fatalf("please fix solution and try again")
+			fatalf(exitFailed, "please fix solution and try again")
 		}
 		signed.Problem = validated.Problem
 		signed.ProblemSteps = validated.ProblemSteps
@@ -321,45 +278,76 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 	}
 }
 
-func mustConfirmCommitBundle(userID int64, bundle *CommitBundle, args []string) *CommitBundle {
-	verbose := false
-
-	// create a websocket connection to the server
+// dialDaycare opens a websocket connection to the daycare to run a signed commit bundle.
+// If the grading service cannot be reached, it explains why and returns nil.
+// Any other error is fatal.
+func dialDaycare(bundle *CommitBundle) *websocket.Conn {
 	headers := make(http.Header)
+	headers.Set(TraceParentHeader, traceParent)
 	url := "wss://" + Config.Host + "/v2/sockets/" + bundle.Problem.ProblemType + "/" + bundle.Commit.Action
 	socket, resp, err := websocket.DefaultDialer.Dial(url, headers)
-	if err != nil {
-		log.Printf("error dialing %s: %v", url, err)
-		if resp != nil && resp.Body != nil {
-			io.Copy(os.Stderr, resp.Body)
-			resp.Body.Close()
+	if err == nil {
+		return socket
+	}
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusNotFound, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			// no daycare is answering behind this server
+		default:
+			if resp.Body != nil {
+				mustReportAPIError(url, resp)
+			}
 		}
-		log.Fatalf("giving up")
 	}
+	errorLog.Printf("unable to reach the grading service at %s: %v", url, err)
+	return nil
+}
+
+func mustConfirmCommitBundle(userID int64, bundle *CommitBundle, args []string) *CommitBundle {
+	socket := dialDaycare(bundle)
+	if socket == nil {
+		fatalf(exitNetwork, "giving up")
+	}
+	return mustRunCommitBundle(socket, &DaycareRequest{UserID: userID, CommitBundle: bundle}, nil)
+}
+
+// mustRunCommitBundle sends the request with a signed commit bundle over a daycare
+// connection and waits for the result. If the request shares the session, shared
+// is called with the share ID once the daycare has one.
+func mustRunCommitBundle(socket *websocket.Conn, req *DaycareRequest, shared func(shareID string)) *CommitBundle {
+	// an instructor broadcasting to a class follows along too
+	verbose := req.Share == ShareBroadcast
 	defer socket.Close()
 
-	// form the initial request
-	req := &DaycareRequest{UserID: userID, CommitBundle: bundle}
+	// send the initial request
 	if err := socket.WriteJSON(req); err != nil {
-		log.Fatalf("error writing request message: %v", err)
+		fatalf(exitNetwork, "error writing request message: %v", err)
 	}
 
 	// start listening for events
 	for {
 		reply := new(DaycareResponse)
 		if err := socket.ReadJSON(reply); err != nil {
-			log.Fatalf("socket error reading event: %v", err)
+			fatalf(exitNetwork, "socket error reading event: %v", err)
 			break
 		}
 
 		switch {
 		case reply.Error != "":
-			log.Printf("server returned an error:")
-			log.Fatalf("  %s", reply.Error)
+			errorLog.Printf("server returned an error:")
+			fatalf(exitServer, "  %s", reply.Error)
 
 		case reply.CommitBundle != nil:
+			if reply.CommitBundle.Commit != nil {
+				mustVerifyCommit(reply.CommitBundle.Commit)
+			}
 			return reply.CommitBundle
 
+		case reply.ShareID != "":
+			if shared != nil {
+				shared(reply.ShareID)
+			}
+
 		case reply.Event != nil:
 			if verbose {
 				switch reply.Event.Event {
@@ -379,10 +367,64 @@ func mustConfirmCommitBundle(userID int64, bundle *CommitBundle, args []string)
 			}
 
 		default:
-			log.Fatalf("unexpected reply from server")
+			fatalf(exitServer, "unexpected reply from server")
 		}
 	}
 
-	log.Fatalf("no commit returned from server")
+	fatalf(exitServer, "no commit returned from server")
 	return nil
 }
+
+// problemConfig is the contents of a problem.cfg file.
+type problemConfig struct {
+	Problem struct {
+		Unique  string
+		Note    string
+		Type    string
+		Tag     []string
+		Option  []string
+		Mastery int64
+	}
+	Step map[string]*struct {
+		Note   string
+		Weight float64
+		Carry  []string
+	}
+}
+
+// mustFindProblemDir finds the directory holding problem.cfg,
+// starting at d and moving up toward the root.
+func mustFindProblemDir(d string) string {
+	dir, err := filepath.Abs(d)
+	if err != nil {
+		fatalf(exitUsage, "error finding directory %q: %v", d, err)
+	}
+	for {
+		path := filepath.Join(dir, ProblemConfigName)
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				// try moving up a directory
+				old := dir
+				dir = filepath.Dir(dir)
+				if dir == old {
+					fatalf(exitUsage, "unable to find %s in %s or an ancestor directory", ProblemConfigName, d)
+				}
+				log.Printf("could not find %s in %s, trying %s", ProblemConfigName, old, dir)
+				continue
+			}
+
+			fatalf(exitUsage, "error searching for %s in %s: %v", ProblemConfigName, dir, err)
+		}
+		return dir
+	}
+}
+
+func mustReadProblemConfig(dir string) *problemConfig {
+	cfg := new(problemConfig)
+	configPath := filepath.Join(dir, ProblemConfigName)
+	fmt.Printf("reading %s\n", configPath)
+	if err := gcfg.ReadFileInto(cfg, configPath); err != nil {
+		fatalf(exitUsage, "failed to parse %s: %v", configPath, err)
+	}
+	return cfg
+}
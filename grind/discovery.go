@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// discoveryHost keeps the public index of schools and their CodeGrinder servers.
+const discoveryHost = defaultHost
+
+// applySchoolFlag sets the host to the server of the school named by --school,
+// as listed in the discovery index. Only servers an administrator of the index
+// has approved are listed, so a name cannot send students to an unknown server.
+func applySchoolFlag(cmd *cobra.Command) {
+	school := strings.TrimSpace(cmd.Flag("school").Value.String())
+	if school == "" {
+		return
+	}
+	if cmd.Flag("host").Changed {
+		fatalf(exitUsage, "give either --school or --host, not both")
+	}
+
+	// the index is on another server, which should not see the cookie
+	host, cookie := Config.Host, Config.Cookie
+	Config.Host, Config.Cookie = discoveryHost, ""
+	institutions := []*Institution{}
+	if !getObject("/institutions", map[string]string{"school": school}, &institutions) {
+		fatalf(exitServer, "the school directory at %s is not available; use --host to give the server name", discoveryHost)
+	}
+	Config.Host, Config.Cookie = host, cookie
+
+	var found *Institution
+	for _, elt := range institutions {
+		if strings.EqualFold(elt.Name, school) {
+			found = elt
+		}
+	}
+	switch {
+	case found != nil:
+	case len(institutions) == 1:
+		found = institutions[0]
+	case len(institutions) == 0:
+		errorLog.Printf("no school matching %q is in the directory", school)
+		fatalf(exitUsage, "ask your instructor for the name of the CodeGrinder server and use --host instead")
+	default:
+		errorLog.Printf("more than one school matches %q:", school)
+		for _, elt := range institutions {
+			errorLog.Printf("    %s", elt.Name)
+		}
+		fatalf(exitUsage, "give the full name of your school")
+	}
+
+	checked, err := checkConfigHost(found.Hostname)
+	if err != nil {
+		fatalf(exitServer, "the directory lists %q for %s, which is not valid: %v", found.Hostname, found.Name, err)
+	}
+	Config.Host = checked
+	log.Printf("%s uses the CodeGrinder server at %s", found.Name, Config.Host)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandEmail(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	if len(args) != 0 {
+		usage(cmd)
+	}
+
+	gateway := new(EmailGateway)
+	if !getObject("/users/me/email_gateway", nil, gateway) {
+		fatalf(exitUsage, "this server does not accept work by email")
+	}
+	fmt.Printf("If you cannot reach the LMS to submit your work, email it as attachments\n")
+	fmt.Printf("from the address the LMS has for you to:\n\n")
+	fmt.Printf("    %s\n\n", gateway.Address)
+	fmt.Printf("with this subject, giving the assignment as shown by \"grind list\":\n\n")
+	fmt.Printf("    %s COURSE/problem-set\n\n", gateway.Key)
+	fmt.Printf("For a problem set with more than one problem, add the problem: COURSE/problem-set/problem.\n")
+	fmt.Printf("Your instructor must approve the work before it is graded. Keep this key private.\n")
+
+	submissions := []*EmailSubmission{}
+	mustGetObject("/users/me/email_submissions", nil, &submissions)
+	if len(submissions) > 0 {
+		fmt.Println()
+		fmt.Println("work you have sent by email:")
+		for _, elt := range submissions {
+			fmt.Printf("  %s  %-8s %s\n", elt.CreatedAt.Local().Format("2006-01-02 15:04"), elt.Status, elt.Note)
+		}
+	}
+}
+
+func CommandCourseEmail(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	path := fmt.Sprintf("/courses/%d/email_submissions", course.ID)
+
+	approve, reject := cmd.Flag("approve").Value.String(), cmd.Flag("reject").Value.String()
+	if approve != "0" || reject != "0" {
+		if approve != "0" && reject != "0" {
+			fatalf(exitUsage, "give either --approve or --reject, not both")
+		}
+		review := &EmailSubmission{Status: "approved"}
+		id := approve
+		if reject != "0" {
+			review = &EmailSubmission{Status: "rejected", Note: cmd.Flag("reason").Value.String()}
+			id = reject
+		}
+		saved := new(EmailSubmission)
+		mustPutObject(path+"/"+id, nil, review, saved)
+		if saved.Status == "approved" {
+			log.Printf("email submission %d approved and %s", saved.ID, saved.Note)
+		} else {
+			log.Printf("email submission %d rejected", saved.ID)
+		}
+		return
+	}
+
+	submissions := []*EmailSubmission{}
+	mustGetObject(path, nil, &submissions)
+	if len(submissions) == 0 {
+		fmt.Printf("no work has been sent by email in %s\n", course.Label)
+		return
+	}
+	users := make(map[int64]*User)
+	for _, elt := range submissions {
+		user, exists := users[elt.UserID]
+		if !exists {
+			user = new(User)
+			mustGetObject("/users/"+strconv.FormatInt(elt.UserID, 10), nil, user)
+			users[elt.UserID] = user
+		}
+		fmt.Printf("%d: %s from %s (%s), %s\n", elt.ID, elt.Status, user.Name, elt.Sender, elt.CreatedAt.Local().Format("2006-01-02 15:04"))
+		fmt.Printf("    subject: %s\n", elt.Subject)
+		var names []string
+		for name := range elt.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("    %s (%d bytes)\n", name, len(elt.Files[name]))
+		}
+		if elt.Note != "" {
+			fmt.Printf("    %s\n", elt.Note)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// cliHints replaces the server's hint for errors where grind users
+// have something different to do than browser users.
+var cliHints = map[string]string{
+	ErrorNotAuthenticated: "your cookie has expired or is not valid; run \"grind init\" to get a new one",
+	ErrorForbidden:        "if you think you should be allowed to do this, ask your instructor",
+	ErrorInternal:         "this is a problem with the server; if it keeps happening, tell your instructor",
+}
+
+// exitCodes maps error codes from the server to grind exit codes.
+var exitCodes = map[string]int{
+	ErrorBadRequest:       exitUsage,
+	ErrorNotAuthenticated: exitAuth,
+	ErrorForbidden:        exitAuth,
+	ErrorNotFound:         exitUsage,
+	ErrorConflict:         exitUsage,
+	ErrorTooLarge:         exitUsage,
+}
+
+// mustReportAPIError explains an error response from the server and exits.
+// Servers older than the structured error format, and proxies in front of
+// the server, send plain text, which is shown as it is.
+func mustReportAPIError(url string, resp *http.Response) {
+	body, _ := ioutil.ReadAll(resp.Body)
+	e := new(APIError)
+	if err := json.Unmarshal(body, e); err != nil || e.Code == "" {
+		errorLog.Printf("unexpected status from %s: %s\n", url, resp.Status)
+		if resp.StatusCode >= 500 && explainMaintenance() {
+			fatalf(exitServer, "  try again when the maintenance is over")
+		}
+		os.Stderr.Write(body)
+		code := exitServer
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			code = exitAuth
+		}
+		fatalf(code, "giving up")
+	}
+
+	if Config.apiReport {
+		errorLog.Printf("%s from %s (%s)", resp.Status, url, e.Code)
+	}
+	errorLog.Printf("error: %s", e.Message)
+	hint := e.Hint
+	if cli, exists := cliHints[e.Code]; exists {
+		hint = cli
+	}
+	if hint == "" && e.Retryable {
+		hint = "this is probably temporary; try again in a few minutes"
+	}
+	if hint != "" {
+		errorLog.Printf("  %s", hint)
+	}
+	code, exists := exitCodes[e.Code]
+	if !exists {
+		code = exitServer
+	}
+	os.Exit(code)
+}
+
+// mustReportNetworkError explains a failure to reach the server and exits.
+func mustReportNetworkError(err error) {
+	errorLog.Printf("error connecting to %s: %v", Config.Host, err)
+	if explainMaintenance() {
+		fatalf(exitNetwork, "  try again when the maintenance is over")
+	}
+	fatalf(exitNetwork, "  check your network connection; if it is working, the server may be down")
+}
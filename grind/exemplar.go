@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// exemplarDir is where downloaded exemplars are kept within a problem directory.
+const exemplarDir = "exemplars"
+
+func CommandExemplars(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	dir := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+	dotfile, unique, problemDir := mustFindProblemDirectory(dir)
+	path := fmt.Sprintf("/assignments/%d/problems/%d", dotfile.AssignmentID, dotfile.Problems[unique].ID)
+
+	share := cmd.Flag("share").Value.String() == "true"
+	unshare := cmd.Flag("unshare").Value.String() == "true"
+	switch {
+	case share && unshare:
+		usage(cmd)
+	case share:
+		mustPutObject(path+"/exemplar_consent", nil, nil, nil)
+		log.Printf("your instructor may now show your passing work on %s to other students without your name", unique)
+		return
+	case unshare:
+		doRequest(path+"/exemplar_consent", nil, "DELETE", nil, nil, false)
+		log.Printf("your work on %s will not be shown to other students", unique)
+		return
+	}
+
+	exemplars := []*Exemplar{}
+	mustGetObject(path+"/exemplars", nil, &exemplars)
+	if len(exemplars) == 0 {
+		log.Printf("no exemplars have been published for %s yet", unique)
+		return
+	}
+	target := filepath.Join(problemDir, exemplarDir)
+	if _, err := os.Stat(target); err == nil {
+		fatalf(exitUsage, "%s already exists; delete it first to download the exemplars again", target)
+	}
+	for i, exemplar := range exemplars {
+		exampleDir := filepath.Join(target, strconv.Itoa(i+1))
+		var names []string
+		for name := range exemplar.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			mustWriteProblemFile(exampleDir, name, exemplar.Files[name])
+		}
+		fmt.Printf("%s\n", exampleDir)
+		if exemplar.Note != "" {
+			fmt.Printf("  %s\n", exemplar.Note)
+		}
+	}
+	log.Printf("saved %d exemplar%s for %s", len(exemplars), plural(len(exemplars)), unique)
+}
+
+func CommandCourseExemplars(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problem := mustFindProblem(args[1])
+
+	if id := cmd.Flag("remove").Value.String(); id != "" {
+		doRequest(fmt.Sprintf("/courses/%d/exemplars/%d", course.ID, mustParseID(id)), nil, "DELETE", nil, nil, false)
+		log.Printf("exemplar %s removed", id)
+		return
+	}
+	if id := cmd.Flag("publish").Value.String(); id != "" {
+		exemplar := &Exemplar{
+			CommitID: mustParseID(id),
+			Note:     cmd.Flag("note").Value.String(),
+		}
+		if after := cmd.Flag("after").Value.String(); after != "" {
+			exemplar.VisibleAt = mustParseDeadline(after)
+		}
+		saved := new(Exemplar)
+		mustPostObject(fmt.Sprintf("/courses/%d/exemplars", course.ID), nil, exemplar, saved)
+		log.Printf("published exemplar %d, visible to students from %s", saved.ID, saved.VisibleAt.Local().Format("2006-01-02 15:04 MST"))
+		log.Printf("lines naming its author were removed; check the files below before students see them")
+		names := []string{}
+		for name := range saved.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("==> %s <==\n%s\n", name, saved.Files[name])
+		}
+		return
+	}
+
+	exemplars := []*Exemplar{}
+	mustGetObject(fmt.Sprintf("/courses/%d/exemplars", course.ID), map[string]string{"problem_id": strconv.FormatInt(problem.ID, 10)}, &exemplars)
+	fmt.Printf("published exemplars for %s (%d of %d):\n", problem.Unique, len(exemplars), MaxExemplars)
+	now := serverNow()
+	for _, elt := range exemplars {
+		status := "visible"
+		if now.Before(elt.VisibleAt) {
+			status = "from " + elt.VisibleAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  %d: commit %d, %s %s\n", elt.ID, elt.CommitID, status, elt.Note)
+	}
+
+	candidates := []*ExemplarCandidate{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problems/%d/exemplar_candidates", course.ID, problem.ID), nil, &candidates)
+	fmt.Printf("passing solutions shared by their authors:\n")
+	if len(candidates) == 0 {
+		fmt.Printf("  none\n")
+	}
+	for _, elt := range candidates {
+		published := ""
+		if elt.Published {
+			published = " (published)"
+		}
+		fmt.Printf("  commit %d: %s <%s>, %s%s\n", elt.CommitID, elt.UserName, elt.UserEmail, elt.UpdatedAt.Local().Format("2006-01-02 15:04"), published)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes let shell scripts and CI jobs branch on the outcome of a command.
+const (
+	exitPassed  = 0 // the command worked, and any work it graded passed
+	exitFailed  = 1 // work was graded and did not pass, or does not match the server
+	exitUsage   = 2 // the command line was wrong, or local files are missing or broken
+	exitAuth    = 3 // not logged in, or not allowed to do this
+	exitNetwork = 4 // the server could not be reached
+	exitServer  = 5 // the server reported an error or is too old
+)
+
+// errorLog reports errors. Unlike the standard logger, it is not silenced by --quiet.
+var errorLog = log.New(os.Stderr, "", log.Ltime)
+
+// fatalf reports an error and exits with the given code.
+func fatalf(code int, format string, args ...interface{}) {
+	errorLog.Printf(format, args...)
+	os.Exit(code)
+}
+
+// usage shows the help for a command that was used incorrectly and exits.
+func usage(cmd *cobra.Command) {
+	cmd.Help()
+	os.Exit(exitUsage)
+}
+
+// setQuiet silences progress messages and transcripts,
+// leaving errors and the data a command was asked for.
+func setQuiet(cmd *cobra.Command) {
+	if cmd.Flag("quiet").Value.String() != "true" {
+		return
+	}
+	log.SetOutput(ioutil.Discard)
+	color.Output = ioutil.Discard
+}
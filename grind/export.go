@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// exportCommitFile holds the details of a commit other than its files,
+// saved alongside them in an export.
+const exportCommitFile = "commit.json"
+
+// exportTarget receives the files of an export, either in a directory or in a zip file.
+type exportTarget interface {
+	write(name string, contents []byte, modTime time.Time)
+	close()
+}
+
+type exportDir struct {
+	root string
+}
+
+func (t *exportDir) write(name string, contents []byte, modTime time.Time) {
+	full := filepath.Join(t.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(full), err)
+	}
+	if err := ioutil.WriteFile(full, contents, 0644); err != nil {
+		fatalf(exitUsage, "error saving file %s: %v", full, err)
+	}
+	if err := os.Chtimes(full, modTime, modTime); err != nil {
+		fatalf(exitUsage, "error setting the time on %s: %v", full, err)
+	}
+}
+
+func (t *exportDir) close() {}
+
+type exportZip struct {
+	file   *os.File
+	writer *zip.Writer
+}
+
+func (t *exportZip) write(name string, contents []byte, modTime time.Time) {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	header.SetModTime(modTime)
+	out, err := t.writer.CreateHeader(header)
+	if err != nil {
+		fatalf(exitUsage, "error adding %s to %s: %v", name, t.file.Name(), err)
+	}
+	if _, err := out.Write(contents); err != nil {
+		fatalf(exitUsage, "error adding %s to %s: %v", name, t.file.Name(), err)
+	}
+}
+
+func (t *exportZip) close() {
+	if err := t.writer.Close(); err != nil {
+		fatalf(exitUsage, "error finishing %s: %v", t.file.Name(), err)
+	}
+	if err := t.file.Close(); err != nil {
+		fatalf(exitUsage, "error closing %s: %v", t.file.Name(), err)
+	}
+}
+
+// exportName makes a string safe to use as one element of a path in an export.
+func exportName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" || s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+func CommandExportMyWork(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	asZip := cmd.Flag("zip").Value.String() == "true"
+	name := "codegrinder-export"
+	if asZip {
+		name += ".zip"
+	}
+	switch len(args) {
+	case 0:
+	case 1:
+		name = args[0]
+	default:
+		usage(cmd)
+	}
+	if _, err := os.Stat(name); err == nil {
+		errorLog.Printf("%s already exists", name)
+		fatalf(exitUsage, "give a different name or delete it first")
+	} else if !os.IsNotExist(err) {
+		fatalf(exitUsage, "error checking if %s exists: %v", name, err)
+	}
+
+	var target exportTarget
+	if asZip {
+		file, err := os.Create(name)
+		if err != nil {
+			fatalf(exitUsage, "error creating %s: %v", name, err)
+		}
+		target = &exportZip{file: file, writer: zip.NewWriter(file)}
+	} else {
+		target = &exportDir{root: name}
+	}
+
+	// work is filed as COURSE/problem-set/problem/step-N/TIME
+	dirs := make(map[int64]string)
+	assignments, commits := 0, 0
+	params := map[string]string{}
+	for {
+		page := new(ExportPage)
+		mustGetObject("/users/me/export", params, page)
+
+		for _, elt := range page.Assignments {
+			dir := path.Join(exportName(elt.CourseLabel), exportName(elt.ProblemSetUnique))
+			dirs[elt.AssignmentID] = dir
+			report, err := json.MarshalIndent(elt.Report, "", "    ")
+			if err != nil {
+				fatalf(exitUsage, "JSON error encoding grade report: %v", err)
+			}
+			target.write(path.Join(dir, gradeReportFile), append(report, '\n'), elt.Report.IssuedAt)
+			assignments++
+		}
+
+		for _, elt := range page.Commits {
+			commit := elt.Commit
+			mustVerifyCommit(commit)
+			dir, exists := dirs[commit.AssignmentID]
+			if !exists {
+				fatalf(exitServer, "the server sent commit %d without its assignment", commit.ID)
+			}
+			when := commit.CreatedAt.Local().Format("2006-01-02T15-04-05")
+			if commit.Action != "" {
+				when += "-" + exportName(commit.Action)
+			}
+			dir = path.Join(dir, exportName(elt.Problem), "step-"+strconv.FormatInt(commit.Step, 10), when)
+			for fileName, contents := range commit.Files {
+				clean := path.Clean(fileName)
+				if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+					log.Printf("skipping file %q in commit %d", fileName, commit.ID)
+					continue
+				}
+				target.write(path.Join(dir, clean), []byte(contents), commit.UpdatedAt)
+			}
+
+			// everything but the files goes in commit.json
+			details := *commit
+			details.Files = nil
+			details.Checksums = nil
+			raw, err := json.MarshalIndent(&details, "", "    ")
+			if err != nil {
+				fatalf(exitUsage, "JSON error encoding commit %d: %v", commit.ID, err)
+			}
+			target.write(path.Join(dir, exportCommitFile), append(raw, '\n'), commit.UpdatedAt)
+			commits++
+		}
+
+		if page.Next == 0 {
+			break
+		}
+		params["after"] = strconv.FormatInt(page.Next, 10)
+		log.Printf("%d commit%s so far", commits, plural(commits))
+	}
+	target.close()
+
+	if commits == 0 {
+		if asZip {
+			log.Printf("you have not saved any work, so %s is empty", name)
+		} else {
+			log.Printf("you have not saved any work, so there was nothing to export")
+		}
+		return
+	}
+	fmt.Printf("exported %d commit%s from %d assignment%s to %s\n", commits, plural(commits), assignments, plural(assignments), name)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// reportFeedback shows the misconceptions the problem's feedback rules recognized.
+func reportFeedback(commit *Commit) {
+	if commit.ReportCard == nil {
+		return
+	}
+	for _, elt := range commit.ReportCard.Feedback {
+		if elt.Context != "" {
+			log.Printf("  hint (%s): %s", elt.Context, elt.Message)
+		} else {
+			log.Printf("  hint: %s", elt.Message)
+		}
+	}
+}
+
+func CommandAuthorFeedback(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	problem := mustFindProblem(args[0])
+	path := fmt.Sprintf("/problems/%d/feedback_rules", problem.ID)
+
+	if len(args) == 2 {
+		name := args[1]
+		if cmd.Flag("remove").Value.String() == "true" {
+			doRequest(path+"/"+name, nil, "DELETE", nil, nil, false)
+			log.Printf("%s no longer uses feedback rule %s", problem.Unique, name)
+			return
+		}
+		rule := &FeedbackRule{
+			Match:   FeedbackCode,
+			Pattern: cmd.Flag("code").Value.String(),
+			Files:   cmd.Flag("files").Value.String(),
+			Message: cmd.Flag("message").Value.String(),
+		}
+		if output := cmd.Flag("output").Value.String(); output != "" {
+			if rule.Pattern != "" {
+				fatalf(exitUsage, "give either --code or --output, not both")
+			}
+			rule.Match, rule.Pattern = FeedbackOutput, output
+		}
+		if rule.Pattern == "" || rule.Message == "" {
+			fatalf(exitUsage, "give a pattern with --code or --output and the feedback with --message")
+		}
+		step, err := strconv.ParseInt(cmd.Flag("step").Value.String(), 10, 64)
+		if err != nil {
+			fatalf(exitUsage, "bad step number: %v", err)
+		}
+		rule.Step = step
+		mustPutObject(path+"/"+name, nil, rule, nil)
+	}
+
+	rules := []*FeedbackRule{}
+	mustGetObject(path, nil, &rules)
+	if len(rules) == 0 {
+		fmt.Printf("%s has no feedback rules\n", problem.Unique)
+		return
+	}
+	for _, elt := range rules {
+		where := elt.Match
+		if elt.Files != "" {
+			where += " in " + elt.Files
+		}
+		if elt.Step != 0 {
+			where += fmt.Sprintf(", step %d", elt.Step)
+		}
+		fmt.Printf("%s (%s, %d hit%s): /%s/\n    %s\n", elt.Name, where, elt.Hits, plural(int(elt.Hits)), elt.Pattern, elt.Message)
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	. "github.com/russross/codegrinder/types"
 	"github.com/spf13/cobra"
@@ -21,46 +22,18 @@ func CommandGet(cmd *cobra.Command, args []string) {
 	name, rootDir := "", ""
 	switch len(args) {
 	case 0:
-		log.Printf("you must specify the problem set to download")
-		log.Fatalf("in the form COURSE/problem-set-id as displayed by \"grind list\"")
+		errorLog.Printf("you must specify the problem set to download")
+		fatalf(exitUsage, "in the form COURSE/problem-set-id as displayed by \"grind list\"")
 	case 1:
 		name = args[0]
 	case 2:
 		name = args[0]
 		rootDir = args[1]
 	default:
-		cmd.Help()
-		return
+		usage(cmd)
 	}
 
-	var assignment *Assignment
-
-	if id, err := strconv.Atoi(name); err == nil && id > 0 {
-		// look it up by ID
-		assignment = new(Assignment)
-		mustGetObject(fmt.Sprintf("/assignments/%d", id), nil, assignment)
-	} else {
-		// parse the course label and the problem unique id
-		parts := strings.Split(name, "/")
-		if len(parts) != 2 {
-			log.Fatalf("problem name %q must be of form course/problem-id as displayed by \"grind list\"", name)
-		}
-		label, unique := parts[0], parts[1]
-
-		// find the assignment
-		assignmentList := []*Assignment{}
-		mustGetObject("/users/me/assignments",
-			map[string]string{"course_lti_label": label, "problem_unique": unique},
-			&assignmentList)
-		if len(assignmentList) == 0 {
-			log.Printf("no matching assignment found")
-			log.Fatalf("use \"grind list\" to see available assignments")
-		} else if len(assignmentList) != 1 {
-			log.Printf("found more than one matching assignment")
-			log.Fatalf("try searching by assignment ID instead")
-		}
-		assignment = assignmentList[0]
-	}
+	assignment := mustFindAssignment(name)
 
 	// get the course
 	course := new(Course)
@@ -80,11 +53,16 @@ func CommandGet(cmd *cobra.Command, args []string) {
 	problems := make(map[string]*Problem)
 	steps := make(map[string]*ProblemStep)
 	for _, elt := range problemSetProblems {
-		problem, commit, info, step := new(Problem), new(Commit), new(ProblemInfo), new(ProblemStep)
+		if !assignment.OnPath(elt.Track) {
+			// adaptive problem sets only include the student's path
+			continue
+		}
+		problem, commit, info := new(Problem), new(Commit), new(ProblemInfo)
 		mustGetObject(fmt.Sprintf("/problems/%d", elt.ProblemID), nil, problem)
 		problems[problem.Unique] = problem
 
 		if getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", assignment.ID, problem.ID), nil, commit) {
+			mustVerifyCommit(commit)
 			info.ID = problem.ID
 			info.Step = commit.Step
 			info.Whitelist = make(map[string]bool)
@@ -101,7 +79,7 @@ func CommandGet(cmd *cobra.Command, args []string) {
 			info.Whitelist = make(map[string]bool)
 		}
 
-		mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+		step := mustGetProblemStep(problem, info.Step)
 		for name := range step.Files {
 			// starter files are added to the whitelist
 			dir, _ := filepath.Split(name)
@@ -120,16 +98,54 @@ func CommandGet(cmd *cobra.Command, args []string) {
 	}
 
 	if _, err := os.Stat(rootDir); err == nil {
-		log.Printf("directory %s already exists", rootDir)
-		log.Fatalf("delete it first if you want to re-download the assignment")
+		// an existing copy of this assignment can be brought up to date
+		if dotfile := readDotFile(rootDir); dotfile != nil && dotfile.AssignmentID == assignment.ID {
+			log.Printf("updating problem set %s in %s", problemSet.Unique, rootDir)
+			refreshProblemSet(dotfile, dotfile.Dir, problems, commits, cmd.Flag("conflict").Value.String())
+			return
+		}
+
+		// anything already there that would be overwritten?
+		var overwrite []string
+		if dotfile := readDotFile(rootDir); dotfile != nil {
+			overwrite = append(overwrite, fmt.Sprintf("%s (the copy of assignment %d in this directory will stop working)", dotfile.Path, dotfile.AssignmentID))
+		}
+		for unique, step := range steps {
+			target := rootDir
+			if len(steps) > 1 {
+				target = filepath.Join(rootDir, unique)
+			}
+			names := make(map[string]bool)
+			for name := range step.Files {
+				names[name] = true
+			}
+			if commit := commits[unique]; commit != nil {
+				for name := range commit.Files {
+					names[name] = true
+				}
+			}
+			for name := range names {
+				if _, err := os.Stat(filepath.Join(target, name)); err == nil {
+					rel, _ := filepath.Rel(rootDir, filepath.Join(target, name))
+					overwrite = append(overwrite, rel)
+				}
+			}
+		}
+		if len(overwrite) > 0 {
+			sort.Strings(overwrite)
+			mustConfirm(cmd, &confirmation{
+				action:  fmt.Sprintf("overwrite %d file%s in %s, which already exists", len(overwrite), plural(len(overwrite)), rootDir),
+				changes: overwrite,
+			})
+		}
 	} else if !os.IsNotExist(err) {
-		log.Fatalf("error checking if directory %s exists: %v", rootDir, err)
+		fatalf(exitUsage, "error checking if directory %s exists: %v", rootDir, err)
 	}
 
 	// create the target directory
 	log.Printf("unpacking problem set %s in %s", problemSet.Unique, rootDir)
 	if err := os.MkdirAll(rootDir, 0755); err != nil {
-		log.Fatalf("error creating directory %s: %v", rootDir, err)
+		fatalf(exitUsage, "error creating directory %s: %v", rootDir, err)
 	}
 
 	for unique := range steps {
@@ -142,7 +158,7 @@ func CommandGet(cmd *cobra.Command, args []string) {
 			target = filepath.Join(rootDir, unique)
 			log.Printf("unpacking problem %s", unique)
 			if err := os.MkdirAll(target, 0755); err != nil {
-				log.Fatalf("error creating directory %s: %v", target, err)
+				fatalf(exitUsage, "error creating directory %s: %v", target, err)
 			}
 		}
 
@@ -151,10 +167,10 @@ func CommandGet(cmd *cobra.Command, args []string) {
 			path := filepath.Join(target, name)
 			log.Printf("writing step %d file %s", step.Step, name)
 			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-				log.Fatalf("error create directory %s: %v", filepath.Dir(path), err)
+				fatalf(exitUsage, "error create directory %s: %v", filepath.Dir(path), err)
 			}
 			if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
-				log.Fatalf("error saving file %s: %v", path, err)
+				fatalf(exitUsage, "error saving file %s: %v", path, err)
 			}
 		}
 
@@ -164,10 +180,12 @@ func CommandGet(cmd *cobra.Command, args []string) {
 				path := filepath.Join(target, name)
 				log.Printf("writing commit file %s", name)
 				if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
-					log.Fatalf("error saving file %s: %v", path, err)
+					fatalf(exitUsage, "error saving file %s: %v", path, err)
 				}
 			}
 
+			takeSnapshot(infos[unique], commit.Files, commit.UpdatedAt)
+
 			// does this commit indicate the step was finished and needs to advance?
 			if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
 				nextStep(target, infos[unique], problem, commit)
@@ -177,14 +195,119 @@ func CommandGet(cmd *cobra.Command, args []string) {
 	dotfile := &DotFileInfo{
 		AssignmentID: assignment.ID,
 		Problems:     infos,
-		Path:         filepath.Join(rootDir, perProblemSetDotFile),
+		Path:         dotFilePath(rootDir),
+		Dir:          rootDir,
+	}
+	mustWriteDotFile(dotfile)
+}
+
+// mustFindAssignment looks up an assignment of the current user, given either its
+// numeric ID or the course/problem-set name shown by "grind list".
+func mustFindAssignment(name string) *Assignment {
+	if id, err := strconv.Atoi(name); err == nil && id > 0 {
+		assignment := new(Assignment)
+		mustGetObject(fmt.Sprintf("/assignments/%d", id), nil, assignment)
+		return assignment
 	}
-	contents, err := json.MarshalIndent(dotfile, "", "    ")
-	if err != nil {
-		log.Fatalf("JSON error encoding %s: %v", dotfile.Path, err)
+
+	// parse the course label and the problem unique id
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 {
+		fatalf(exitUsage, "problem name %q must be of form course/problem-id as displayed by \"grind list\"", name)
+	}
+	label, unique := parts[0], parts[1]
+
+	// find the assignment
+	assignmentList := []*Assignment{}
+	mustGetList("/users/me/assignments",
+		map[string]string{"course_lti_label": label, "problem_unique": unique},
+		&assignmentList)
+	if len(assignmentList) == 0 {
+		errorLog.Printf("no matching assignment found")
+		fatalf(exitUsage, "use \"grind list\" to see available assignments")
+	} else if len(assignmentList) != 1 {
+		errorLog.Printf("found more than one matching assignment")
+		fatalf(exitUsage, "try searching by assignment ID instead")
+	}
+	return assignmentList[0]
+}
+
+// refreshProblemSet brings an existing copy of a problem set up to date with
+// the most recent commits on the server, without discarding local changes.
+// A problem the server has at a later step is moved to that step unless the
+// local copy is kept.
+func refreshProblemSet(dotfile *DotFileInfo, problemSetDir string, problems map[string]*Problem, commits map[string]*Commit, mode string) {
+	now := time.Now()
+	var uniques []string
+	for unique := range dotfile.Problems {
+		uniques = append(uniques, unique)
+	}
+	sort.Strings(uniques)
+
+	for _, unique := range uniques {
+		info, server := dotfile.Problems[unique], commits[unique]
+		if server == nil {
+			continue
+		}
+		dir := problemDirectory(dotfile, problemSetDir, unique)
+		if server.Step > info.Step {
+			log.Printf("the server copy of %s is at step %d, but this copy is at step %d", unique, server.Step, info.Step)
+			log.Printf("  it was probably worked on from another computer")
+			if mustChooseConflictMode(mode, false) == "local" {
+				log.Printf("keeping the local copy at step %d", info.Step)
+				continue
+			}
+			moveToStep(dir, info, problems[unique], server)
+			takeSnapshot(info, server.Files, server.UpdatedAt)
+			continue
+		}
+		_, local := gatherProblem(now, dotfile, problemSetDir, unique)
+		if _, ok := reconcile(dir, info, unique, local.Files, server, mode); !ok {
+			continue
+		}
+		if server.Step == info.Step {
+			takeSnapshot(info, server.Files, server.UpdatedAt)
+		}
+	}
+	for unique := range commits {
+		if _, exists := dotfile.Problems[unique]; !exists {
+			log.Printf("problem %s was added to the problem set; download a fresh copy to get it", unique)
+		}
+	}
+	mustWriteDotFile(dotfile)
+}
+
+// moveToStep replaces the files of a problem at one step with those of the
+// later step the server has, taking the server copy of the student's files.
+func moveToStep(dir string, info *ProblemInfo, problem *Problem, server *Commit) {
+	oldStep := mustGetProblemStep(problem, info.Step)
+	newStep := mustGetProblemStep(problem, server.Step)
+	log.Printf("moving %s to step %d", problem.Unique, server.Step)
+
+	// delete the files from the old step that are not in the root directory
+	for name := range oldStep.Files {
+		if len(strings.Split(name, "/")) == 1 {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fatalf(exitUsage, "error deleting %s: %v", path, err)
+		}
+		if err := os.Remove(filepath.Dir(path)); err != nil {
+			// do nothing; the directory probably has other files left
+		}
+	}
+
+	// write the new step and then the server copy of the student's files
+	for name, contents := range newStep.Files {
+		mustWriteProblemFile(dir, name, contents)
+		if len(strings.Split(name, "/")) == 1 {
+			info.Whitelist[name] = true
+		}
 	}
-	contents = append(contents, '\n')
-	if err := ioutil.WriteFile(dotfile.Path, contents, 0644); err != nil {
-		log.Fatalf("error saving file %s: %v", dotfile.Path, err)
+	for name, contents := range server.Files {
+		mustWriteProblemFile(dir, name, contents)
+		info.Whitelist[name] = true
 	}
+	info.Step = server.Step
 }
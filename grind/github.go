@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCourseGitHub(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 2 || len(args) > 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/github_classroom", course.ID, problemSet.ID)
+	roster := cmd.Flag("roster").Value.String()
+	doImport := cmd.Flag("import").Value.String() == "true"
+
+	if len(args) == 3 {
+		if roster == "" {
+			fatalf(exitUsage, "a roster exported from GitHub Classroom is needed to link an assignment")
+		}
+		classroom := &GitHubClassroom{
+			ClassroomAssignmentID: mustParseID(args[2]),
+			Logins:                mustReadGitHubRoster(roster),
+		}
+		saved := new(GitHubClassroom)
+		mustPutObject(path, nil, classroom, saved)
+		log.Printf("%s in %s is linked to GitHub Classroom assignment %d with %d students",
+			problemSet.Unique, course.Label, saved.ClassroomAssignmentID, len(saved.Logins))
+	} else if roster != "" {
+		fatalf(exitUsage, "give the GitHub Classroom assignment ID along with the roster")
+	} else if !doImport {
+		usage(cmd)
+	}
+
+	if !doImport {
+		return
+	}
+	report := new(GitHubImport)
+	mustPostObject(path+"/import", nil, nil, report)
+	queued := 0
+	for _, repo := range report.Repositories {
+		fmt.Printf("%-24s %-40s %s\n", repo.Login, repo.Repository, repo.Note)
+		queued += len(repo.SubmissionIDs)
+	}
+	log.Printf("%d submissions queued from %d repositories", queued, len(report.Repositories))
+}
+
+// mustReadGitHubRoster reads a roster exported from GitHub Classroom,
+// returning a map from GitHub login to the roster identifier, which must be
+// the student's email address. Students who have not linked an account are skipped.
+func mustReadGitHubRoster(path string) map[string]string {
+	fp, err := os.Open(path)
+	if err != nil {
+		fatalf(exitUsage, "error opening roster: %v", err)
+	}
+	defer fp.Close()
+	rows, err := csv.NewReader(fp).ReadAll()
+	if err != nil {
+		fatalf(exitUsage, "error reading roster %s: %v", path, err)
+	}
+	if len(rows) == 0 {
+		fatalf(exitUsage, "roster %s is empty", path)
+	}
+	identifier, login := -1, -1
+	for i, name := range rows[0] {
+		switch strings.TrimSpace(name) {
+		case "identifier":
+			identifier = i
+		case "github_username":
+			login = i
+		}
+	}
+	if identifier < 0 || login < 0 {
+		fatalf(exitUsage, "roster %s must have identifier and github_username columns", path)
+	}
+
+	logins := make(map[string]string)
+	for _, row := range rows[1:] {
+		if len(row) <= identifier || len(row) <= login || row[login] == "" {
+			continue
+		}
+		if !strings.Contains(row[identifier], "@") {
+			errorLog.Printf("skipping %s: identifier %q is not an email address", row[login], row[identifier])
+			continue
+		}
+		logins[row[login]] = row[identifier]
+	}
+	return logins
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -20,94 +19,235 @@ func CommandGrade(cmd *cobra.Command, args []string) {
 	now := time.Now()
 
 	// find the directory
-	dir := ""
-	switch len(args) {
-	case 0:
-		dir = "."
-	case 1:
+	dir := cmd.Flag("dir").Value.String()
+	switch {
+	case len(args) == 1 && dir == "":
 		dir = args[0]
-	default:
-		cmd.Help()
-		return
+	case len(args) > 0:
+		usage(cmd)
+	case dir == "":
+		dir = "."
+	}
+
+	var problems []*Problem
+	var commits []*Commit
+	var dirs []string
+	var dotfile *DotFileInfo
+	all := cmd.Flag("all").Value.String() == "true"
+	if all {
+		problems, commits, dirs, dotfile = gatherAll(now, dir)
+	} else {
+		problem, _, commit, df := gather(now, dir)
+		problemDir := problemDirectory(df, df.Dir, problem.Unique)
+		problems, commits, dirs, dotfile = []*Problem{problem}, []*Commit{commit}, []string{problemDir}, df
+	}
+	async := cmd.Flag("async").Value.String() == "true"
+	mode := cmd.Flag("conflict").Value.String()
+	junitPath := cmd.Flag("junit").Value.String()
+	junit := new(junitSuites)
+	broadcast := cmd.Flag("broadcast").Value.String() == "true"
+	if broadcast && async {
+		fatalf(exitUsage, "--broadcast shows grading as it happens, so it cannot be used with --async")
+	}
+
+	// get the user ID
+	user := new(User)
+	if !async {
+		mustGetObject("/users/me", nil, user)
+	}
+
+	var summary []string
+	failed := false
+	for i, commit := range commits {
+		problem := problems[i]
+		if mustSubmitSealed(problem, commit) {
+			summary = append(summary, fmt.Sprintf("  %-6s %s step %d: graded after the deadline", "sealed", problem.Unique, commit.Step))
+			continue
+		}
+		if !mustReconcileCommit(dirs[i], dotfile, problem, commit, mode) {
+			continue
+		}
+		commit.Action = "grade"
+		commit.Note = "grading from grind tool"
+		commit.AddChecksums()
+		unsigned := &CommitBundle{Commit: commit}
+
+		if async {
+			// queue it on the server and return without waiting
+			submission := new(Submission)
+			mustPostObject("/submissions", nil, unsigned, submission)
+			takeSnapshot(dotfile.Problems[problem.Unique], commit.Files, submission.CreatedAt)
+			mustWriteDotFile(dotfile)
+			log.Printf("submitted %s step %d for grading as submission %d", problem.Unique, commit.Step, submission.ID)
+			log.Printf("use \"grind results %d\" to see the results", submission.ID)
+			continue
+		}
+
+		saved, queued := gradeCommit(user.ID, unsigned, problem, broadcast)
+		if queued != nil {
+			takeSnapshot(dotfile.Problems[problem.Unique], commit.Files, queued.CreatedAt)
+			mustWriteDotFile(dotfile)
+			summary = append(summary, fmt.Sprintf("  %-6s %s step %d: submission %d, %s", "queued", problem.Unique, commit.Step, queued.ID, queued.Note))
+			continue
+		}
+		junit.add(problem.Unique, saved)
+		saveArtifacts(dirs[i], saved.ReportCard)
+		reportFeedback(saved)
+		reportMetrics(saved)
+		takeSnapshot(dotfile.Problems[problem.Unique], saved.Files, saved.UpdatedAt)
+		mustWriteDotFile(dotfile)
+		passed := saved.ReportCard != nil && saved.ReportCard.Passed && saved.Score == 1.0
+		note := ""
+		if saved.ReportCard != nil {
+			note = saved.ReportCard.Note
+		}
+		result := "failed"
+		switch {
+		case passed && saved.Streak != nil && !saved.Streak.IsMastered():
+			// mastery problems need several passes in a row before moving on
+			result = "streak"
+			note = fmt.Sprintf("passed %d of %d times in a row; grade again for a new set of tests", saved.Streak.Streak, saved.Streak.Required)
+			log.Print(note)
+		case passed:
+			result = "passed"
+			if nextStep(dirs[i], dotfile.Problems[problem.Unique], problem, saved) {
+				// save the updated dotfile with whitelist updates and new step number
+				mustWriteDotFile(dotfile)
+			} else {
+				remindReflections(dotfile.AssignmentID, problem)
+			}
+		default:
+			failed = true
+			reportFailure(saved)
+			if saved.Streak != nil && !saved.Streak.IsMastered() {
+				log.Printf("you need to pass %d times in a row to master this step; the count starts over", saved.Streak.Required)
+			}
+		}
+		summary = append(summary, fmt.Sprintf("  %-6s %s step %d: %s", result, problem.Unique, saved.Step, note))
 	}
 
-	problem, _, commit, dotfile := gather(now, dir)
-	commit.Action = "grade"
-	commit.Note = "grading from grind tool"
-	unsigned := &CommitBundle{Commit: commit}
+	if all && len(summary) > 0 {
+		fmt.Println()
+		fmt.Println("summary:")
+		for _, line := range summary {
+			fmt.Println(line)
+		}
+	}
+	if junitPath != "" && !async {
+		junit.mustWrite(junitPath)
+	}
+	if failed {
+		os.Exit(exitFailed)
+	}
+}
 
+// gradeCommit sends a commit to the server to be signed, has the daycare grade it,
+// and saves the graded commit, returning the saved commit. If the grading service
+// is down, the commit is queued on the server instead and the submission is returned.
+// With broadcast, everyone in the course can watch the grading as it happens.
+func gradeCommit(userID int64, unsigned *CommitBundle, problem *Problem, broadcast bool) (*Commit, *Submission) {
 	// send the commit bundle to the server
 	signed := new(CommitBundle)
 	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
+	mustVerifyCommit(signed.Commit)
 
 	// TODO: get a daycare referral
 
-	// get the user ID
-	user := new(User)
-	mustGetObject("/users/me", nil, user)
-
 	// send it to the daycare for grading
-	log.Printf("submitting %s step %d for grading", problem.Unique, commit.Step)
-	graded := mustConfirmCommitBundle(user.ID, signed, nil)
+	log.Printf("submitting %s step %d for grading", problem.Unique, unsigned.Commit.Step)
+	socket := dialDaycare(signed)
+	if socket == nil {
+		submission := new(Submission)
+		mustPostObject("/submissions", nil, unsigned, submission)
+		log.Printf("your work is saved, and the server queued it for grading as submission %d", submission.ID)
+		log.Printf("it will be graded when the grading service is back; use \"grind results %d\" to check on it", submission.ID)
+		return nil, submission
+	}
+	req := &DaycareRequest{UserID: userID, CommitBundle: signed}
+	var shared func(string)
+	if broadcast {
+		assignment := new(Assignment)
+		mustGetObject(fmt.Sprintf("/assignments/%d", signed.Commit.AssignmentID), nil, assignment)
+		req.Share = ShareBroadcast
+		shared = func(shareID string) {
+			show := &Broadcast{ShareID: shareID, Problem: problem.Unique, Action: signed.Commit.Action}
+			mustPutObject(fmt.Sprintf("/courses/%d/broadcast", assignment.CourseID), nil, show, nil)
+			log.Printf("broadcasting to the class at https://%s/broadcast/%d", Config.Host, assignment.CourseID)
+		}
+	}
+	graded := mustRunCommitBundle(socket, req, shared)
 
 	// save the commit with report card
 	toSave := &CommitBundle{
+		Imports:         graded.Imports,
+		Matrix:          graded.Matrix,
 		Commit:          graded.Commit,
 		CommitSignature: graded.CommitSignature,
 	}
 	saved := new(CommitBundle)
 	mustPostObject("/commit_bundles/signed", nil, toSave, saved)
-	commit = saved.Commit
-
-	if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
-		if nextStep(dir, dotfile.Problems[problem.Unique], problem, commit) {
-			// save the updated dotfile with whitelist updates and new step number
-			contents, err := json.MarshalIndent(dotfile, "", "    ")
-			if err != nil {
-				log.Fatalf("JSON error encoding %s: %v", dotfile.Path, err)
+	mustVerifyCommit(saved.Commit)
+	if saved.Path != "" {
+		log.Printf("based on %s you have been placed on the %s path", problem.Unique, saved.Path)
+		log.Printf("use \"grind get\" again to download the rest of the problem set")
+	}
+	return saved.Commit, nil
+}
+
+// reportFailure explains why a commit did not pass, playing back its transcript.
+func reportFailure(commit *Commit) {
+	log.Printf("  solution for step %d failed", commit.Step)
+	if commit.ReportCard != nil {
+		log.Printf("  ReportCard: %s", commit.ReportCard.Note)
+		for _, variant := range commit.ReportCard.Variants {
+			outcome := "passed"
+			if !variant.Passed {
+				outcome = "failed"
 			}
-			contents = append(contents, '\n')
-			if err := ioutil.WriteFile(dotfile.Path, contents, 0644); err != nil {
-				log.Fatalf("error saving file %s: %v", dotfile.Path, err)
+			if variant.Note != "" {
+				log.Printf("    %-8s %s (%s): %s", outcome, variant.Label, variant.Image, variant.Note)
+			} else {
+				log.Printf("    %-8s %s (%s)", outcome, variant.Label, variant.Image)
 			}
 		}
-	} else {
-		// solution failed
-		log.Printf("  solution for step %d failed", commit.Step)
-		if commit.ReportCard != nil {
-			log.Printf("  ReportCard: %s", commit.ReportCard.Note)
-		}
-
-		// play the transcript
-		for _, event := range commit.Transcript {
-			switch event.Event {
-			case "exec":
-				color.Cyan("$ %s\n", strings.Join(event.ExecCommand, " "))
-			case "stdin":
-				color.Yellow("%s", event.StreamData)
-			case "stdout":
-				color.White("%s", event.StreamData)
-			case "stderr":
-				color.Red("%s", event.StreamData)
-			case "exit":
-				color.Cyan("%s\n", event.ExitStatus)
-			case "error":
-				color.Red("Error: %s\n", event.Error)
+		for _, stage := range commit.ReportCard.Stages {
+			if stage.Note != "" {
+				log.Printf("    %-8s %s: %s", stage.Outcome, stage.Name, stage.Note)
+			} else {
+				log.Printf("    %-8s %s", stage.Outcome, stage.Name)
 			}
 		}
 	}
+
+	// play the transcript
+	for _, event := range commit.Transcript {
+		switch event.Event {
+		case "exec":
+			color.Cyan("$ %s\n", strings.Join(event.ExecCommand, " "))
+		case "stdin":
+			color.Yellow("%s", event.StreamData)
+		case "stdout":
+			color.White("%s", event.StreamData)
+		case "stderr":
+			color.Red("%s", event.StreamData)
+		case "exit":
+			color.Cyan("%s\n", event.ExitStatus)
+		case "error":
+			color.Red("Error: %s\n", event.Error)
+		}
+	}
 }
 
 func nextStep(dir string, info *ProblemInfo, problem *Problem, commit *Commit) bool {
 	log.Printf("step %d passed", commit.Step)
 
 	// advance to the next step
-	oldStep, newStep := new(ProblemStep), new(ProblemStep)
-	if !getObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step+1), nil, newStep) {
+	newStep := getProblemStep(problem, commit.Step+1, true)
+	if newStep == nil {
 		log.Printf("you have completed all steps for this problem")
 		return false
 	}
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step), nil, oldStep)
+	oldStep := mustGetProblemStep(problem, commit.Step)
 	log.Printf("moving to step %d", newStep.Step)
 
 	// delete all the files from the old step
@@ -118,7 +258,7 @@ func nextStep(dir string, info *ProblemInfo, problem *Problem, commit *Commit) b
 		path := filepath.Join(dir, name)
 		log.Printf("deleting %s from old step", path)
 		if err := os.Remove(path); err != nil {
-			log.Fatalf("error deleting %s: %v", path, err)
+			fatalf(exitUsage, "error deleting %s: %v", path, err)
 		}
 		dirpath := filepath.Dir(path)
 		if err := os.Remove(dirpath); err != nil {
@@ -129,12 +269,18 @@ func nextStep(dir string, info *ProblemInfo, problem *Problem, commit *Commit) b
 	// write files from new step and update the whitelist
 	for name, contents := range newStep.Files {
 		path := filepath.Join(dir, name)
+		if newStep.Carries(name) {
+			if _, err := os.Stat(path); err == nil {
+				log.Printf("keeping your %s from the last step", path)
+				continue
+			}
+		}
 		log.Printf("writing %s from new step", path)
 		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			log.Fatalf("error creating directory %s: %v", filepath.Dir(path), err)
+			fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(path), err)
 		}
 		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
-			log.Fatalf("error saving file %s: %v", path, err)
+			fatalf(exitUsage, "error saving file %s: %v", path, err)
 		}
 
 		// add the file to the whitelist as well if it is in the root directory
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+// Every command takes --host to use a different server than the config file names,
+// for students behind split-horizon DNS, and --connect to reach the server through
+// another address such as an SSH tunnel. Connections made through --connect still
+// check the certificate of the server named by the host.
+
+// connectAddress is where connections to the server go instead, if --connect was given.
+var connectAddress string
+
+func addHostFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("host", "", "", "use this server, with an optional :port, instead of the one in the config file")
+	cmd.PersistentFlags().StringP("connect", "", "", "reach the server through this host:port instead, e.g., an SSH tunnel")
+}
+
+// applyHostFlags applies --host and --connect. Unless save is set, the host from the
+// config file is remembered so it is the one saved if the file is rewritten.
+func applyHostFlags(cmd *cobra.Command, save bool) {
+	if flag := cmd.Flag("host"); flag != nil && flag.Changed {
+		host, err := checkConfigHost(flag.Value.String())
+		if err != nil {
+			fatalf(exitUsage, "--host is not valid: %v", err)
+		}
+		if !save {
+			if Config.fileValues == nil {
+				Config.fileValues = make(map[string]string)
+			}
+			if _, exists := Config.fileValues["host"]; !exists {
+				Config.fileValues["host"] = Config.Host
+			}
+		}
+		Config.Host = host
+	}
+
+	if flag := cmd.Flag("connect"); flag != nil && flag.Changed {
+		connectAddress = flag.Value.String()
+		if _, _, err := net.SplitHostPort(connectAddress); err != nil {
+			fatalf(exitUsage, "--connect must be a host and port, like localhost:8443 or [::1]:8443: %v", err)
+		}
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == serverAddress() {
+				addr = connectAddress
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+		http.DefaultTransport.(*http.Transport).DialContext = dial
+		websocket.DefaultDialer.NetDial = func(network, addr string) (net.Conn, error) {
+			return dial(context.Background(), network, addr)
+		}
+	}
+}
+
+// serverAddress returns the host and port of the server in the form dialers are given.
+func serverAddress() string {
+	if _, _, err := net.SplitHostPort(Config.Host); err == nil {
+		return Config.Host
+	}
+	return net.JoinHostPort(strings.Trim(Config.Host, "[]"), "443")
+}
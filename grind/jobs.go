@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCourseJobs(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	listOrKillJobs(cmd, fmt.Sprintf("/courses/%d/jobs", course.ID))
+}
+
+func CommandAdminJobs(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	listOrKillJobs(cmd, "/jobs")
+}
+
+func listOrKillJobs(cmd *cobra.Command, path string) {
+	if kill := cmd.Flag("kill").Value.String(); kill != "" {
+		jobID := mustParseID(kill)
+		mustConfirm(cmd, &confirmation{
+			action:  fmt.Sprintf("stop grading job %d", jobID),
+			changes: []string{"the student sees that the job was stopped, and the work is not graded"},
+		})
+		doRequest(fmt.Sprintf("%s/%d", path, jobID), nil, "DELETE", nil, nil, false)
+		log.Printf("job %d stopped", jobID)
+		return
+	}
+
+	jobs := []*DaycareJob{}
+	mustGetObject(path, nil, &jobs)
+	if len(jobs) == 0 {
+		fmt.Println("no grading jobs are running")
+		return
+	}
+	now := time.Now()
+	for _, job := range jobs {
+		fmt.Printf("%6d  %-32s %-24s %-8s running for %v\n",
+			job.ID, job.Email, job.Unique, job.Action, now.Sub(job.StartedAt).Round(time.Second))
+	}
+}
+
+func CommandCourseSuspend(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	path := fmt.Sprintf("/courses/%d/suspensions", course.ID)
+
+	if len(args) == 2 {
+		user := mustFindUserByEmail(args[1])
+		path = fmt.Sprintf("%s/%d", path, user.ID)
+		if cmd.Flag("lift").Value.String() == "true" {
+			doRequest(path, nil, "DELETE", nil, nil, false)
+			log.Printf("%s can have work graded in %s again", user.Name, course.Label)
+			return
+		}
+		duration, err := time.ParseDuration(cmd.Flag("for").Value.String())
+		if err != nil || duration <= 0 {
+			fatalf(exitUsage, "--for must be a positive duration such as 30m or 48h")
+		}
+		suspension := &CourseSuspension{
+			Until:  serverNow().Add(duration),
+			Reason: cmd.Flag("reason").Value.String(),
+		}
+		saved := new(CourseSuspension)
+		mustPutObject(path, nil, suspension, saved)
+		log.Printf("%s is suspended in %s until %s", user.Name, course.Label, saved.Until.Local().Format("Jan 2 at 3:04pm"))
+		return
+	}
+
+	suspensions := []*CourseSuspension{}
+	mustGetObject(path, nil, &suspensions)
+	if len(suspensions) == 0 {
+		fmt.Printf("no students are suspended in %s\n", course.Label)
+		return
+	}
+	for _, suspension := range suspensions {
+		user := new(User)
+		mustGetObject("/users/"+strconv.FormatInt(suspension.UserID, 10), nil, user)
+		fmt.Printf("%-32s until %-16s %s\n", user.Email, suspension.Until.Local().Format("Jan 2 3:04pm"), suspension.Reason)
+	}
+}
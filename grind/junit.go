@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// junitSuites is the root of a JUnit XML report, the format CI systems
+// such as GitHub Actions and GitLab CI know how to display.
+// Each graded step becomes a test suite, and each report card result a test case.
+type junitSuites struct {
+	XMLName  xml.Name      `xml:"testsuites"`
+	Tests    int           `xml:"tests,attr"`
+	Failures int           `xml:"failures,attr"`
+	Errors   int           `xml:"errors,attr"`
+	Suites   []*junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     float64      `xml:"time,attr"`
+	Cases    []*junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// add records the report card for a graded step.
+// A report card with no results becomes a single test case for the whole step.
+func (report *junitSuites) add(unique string, commit *Commit) {
+	name := fmt.Sprintf("%s step %d", unique, commit.Step)
+	suite := &junitSuite{Name: name}
+	card := commit.ReportCard
+	if card == nil {
+		card = &ReportCard{Note: "no report card was returned"}
+	}
+	suite.Time = card.Duration.Seconds()
+
+	results := card.Results
+	if len(results) == 0 {
+		outcome := "failed"
+		if card.Passed {
+			outcome = "passed"
+		}
+		results = []*ReportCardResult{{Name: name, Outcome: outcome, Details: card.Note}}
+	}
+	for _, result := range results {
+		elt := &junitCase{Name: result.Name, ClassName: unique}
+		msg := &junitMessage{Message: result.Context, Body: result.Details}
+		switch result.Outcome {
+		case "passed":
+		case "skipped":
+			elt.Skipped = msg
+			suite.Skipped++
+		case "error":
+			elt.Error = msg
+			suite.Errors++
+		default:
+			elt.Failure = msg
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, elt)
+	}
+	suite.Tests = len(suite.Cases)
+
+	report.Suites = append(report.Suites, suite)
+	report.Tests += suite.Tests
+	report.Failures += suite.Failures
+	report.Errors += suite.Errors
+}
+
+func (report *junitSuites) mustWrite(path string) {
+	raw, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatalf(exitUsage, "error encoding JUnit report: %v", err)
+	}
+	raw = append([]byte(xml.Header), raw...)
+	if err := ioutil.WriteFile(path, append(raw, '\n'), 0644); err != nil {
+		fatalf(exitUsage, "error writing %s: %v", path, err)
+	}
+}
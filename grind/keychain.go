@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Settings for the credentials option in the config file.
+const (
+	credentialsKeychain = "keychain" // keep the cookie in the system keychain if there is one (the default)
+	credentialsFile     = "file"     // keep the cookie in the config file
+)
+
+// keychainService names the entries grind makes in the operating system's credential store.
+const keychainService = "codegrinder"
+
+// errNoCredential is returned by a keychain that has nothing stored for a host.
+var errNoCredential = errors.New("no cookie is stored for this server")
+
+// keychain keeps login cookies in the operating system's credential store,
+// one for each server. platformKeychain returns the one for this system,
+// or nil if there is none, in which case the cookie is kept in the config file.
+type keychain interface {
+	String() string
+	get(host string) (string, error)
+	set(host, cookie string) error
+	remove(host string) error
+}
+
+// keychainToolError reports that a credential store tool failed.
+type keychainToolError struct {
+	code    int
+	message string
+}
+
+func (e *keychainToolError) Error() string {
+	return e.message
+}
+
+// exitCode returns the exit status of a credential store tool that failed, or -1.
+func exitCode(err error) int {
+	if e, ok := err.(*keychainToolError); ok {
+		return e.code
+	}
+	return -1
+}
+
+// runKeychainTool runs a command-line tool that manages a credential store,
+// returning its output without the trailing newline.
+func runKeychainTool(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if exit, ok := err.(*exec.ExitError); ok {
+		message := strings.TrimSpace(string(exit.Stderr))
+		if message == "" {
+			message = name + ": " + exit.Error()
+		}
+		return "", &keychainToolError{code: exit.ExitCode(), message: message}
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// loadKeychainCookie fills in the cookie from the keychain for a config file that says it is there.
+// Problems are reported but not fatal, so "grind init" and "grind logout" still work.
+func loadKeychainCookie() {
+	k := platformKeychain()
+	if k == nil {
+		errorLog.Printf("your cookie was saved in a keychain that is not available now; run \"grind init\" to log in again")
+		return
+	}
+	cookie, err := k.get(Config.Host)
+	if err == errNoCredential {
+		errorLog.Printf("your cookie for %s is missing from the %s; run \"grind init\" to log in again", Config.Host, k)
+		return
+	} else if err != nil {
+		errorLog.Printf("error reading your cookie from the %s: %v", k, err)
+		return
+	}
+	Config.Cookie, Config.stored = cookie, cookie
+}
+
+// storeCookie moves the cookie out of a config file that is about to be written
+// and into the keychain, unless the credentials setting says to keep it in the file
+// or there is no keychain. If the keychain fails, the cookie stays in the file.
+func storeCookie(saved *grindConfig) {
+	k := platformKeychain()
+	if k == nil && saved.Keychain && saved.Cookie == "" {
+		// keep pointing at a keychain that is not available right now
+		return
+	}
+	if k == nil || saved.Credentials == credentialsFile {
+		if saved.Keychain && k != nil {
+			// the cookie is moving back to the file
+			if err := k.remove(saved.Host); err != nil && err != errNoCredential {
+				errorLog.Printf("error removing your cookie from the %s: %v", k, err)
+			}
+		}
+		saved.Keychain = false
+		return
+	}
+	if saved.Cookie == "" {
+		return
+	}
+	if !saved.Keychain || saved.Cookie != Config.stored {
+		if err := k.set(saved.Host, saved.Cookie); err != nil {
+			errorLog.Printf("unable to save your cookie in the %s, so it is kept in the config file: %v", k, err)
+			saved.Keychain = false
+			return
+		}
+		Config.stored = saved.Cookie
+	}
+	saved.Cookie, saved.Keychain = "", true
+	Config.Keychain = true
+}
+
+func CommandLogout(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	setQuiet(cmd)
+	if !mustReadConfigFile(mustFindConfigFile()) {
+		log.Printf("you are not logged in")
+	} else {
+		if Config.Keychain {
+			if k := platformKeychain(); k != nil {
+				if err := k.remove(Config.Host); err != nil && err != errNoCredential {
+					fatalf(exitUsage, "error removing your cookie from the %s: %v", k, err)
+				}
+			}
+		}
+		Config.Cookie, Config.Keychain, Config.stored = "", false, ""
+		mustWriteConfig()
+		log.Printf("logged out of %s", Config.Host)
+	}
+	for _, name := range []string{cookieOverrideVariable, tokenVariable} {
+		if os.Getenv(name) != "" {
+			errorLog.Printf("%s is still set in the environment and will be used to log in", name)
+		}
+	}
+}
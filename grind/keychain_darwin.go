@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// macKeychain uses the login keychain through the security tool.
+type macKeychain struct{}
+
+func platformKeychain() keychain {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil
+	}
+	return macKeychain{}
+}
+
+func (macKeychain) String() string { return "macOS keychain" }
+
+func (macKeychain) get(host string) (string, error) {
+	cookie, err := runKeychainTool("", "security", "find-generic-password", "-s", keychainService, "-a", host, "-w")
+	if exitCode(err) == 44 {
+		return "", errNoCredential
+	}
+	return cookie, err
+}
+
+// set gives the command to security on stdin, so the cookie never appears in
+// the argument list where other users could see it with ps. In this mode
+// security does not report failure in its exit status, so the cookie is read
+// back to make sure it was saved.
+func (k macKeychain) set(host, cookie string) error {
+	command := strings.Join([]string{"add-generic-password", "-U",
+		"-s", securityQuote(keychainService), "-a", securityQuote(host),
+		"-l", securityQuote("CodeGrinder (" + host + ")"), "-w", securityQuote(cookie)}, " ")
+	if _, err := runKeychainTool(command+"\n", "security", "-i"); err != nil {
+		return err
+	}
+	if saved, err := k.get(host); err != nil || saved != cookie {
+		return errors.New("security did not save the cookie in the keychain")
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for a command given to security -i.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (macKeychain) remove(host string) error {
+	_, err := runKeychainTool("", "security", "delete-generic-password", "-s", keychainService, "-a", host)
+	if exitCode(err) == 44 {
+		return errNoCredential
+	}
+	return err
+}
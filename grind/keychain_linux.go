@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// secretServiceKeychain uses the desktop's secret service (GNOME Keyring or KWallet)
+// through the secret-tool program from libsecret.
+type secretServiceKeychain struct{}
+
+func platformKeychain() keychain {
+	// the secret service needs a desktop session
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil
+	}
+	return secretServiceKeychain{}
+}
+
+func (secretServiceKeychain) String() string { return "secret service keyring" }
+
+func (secretServiceKeychain) get(host string) (string, error) {
+	cookie, err := runKeychainTool("", "secret-tool", "lookup", "service", keychainService, "host", host)
+	if (err == nil && cookie == "") || exitCode(err) == 1 {
+		return "", errNoCredential
+	}
+	return cookie, err
+}
+
+func (secretServiceKeychain) set(host, cookie string) error {
+	_, err := runKeychainTool(cookie, "secret-tool", "store", "--label=CodeGrinder ("+host+")", "service", keychainService, "host", host)
+	return err
+}
+
+func (secretServiceKeychain) remove(host string) error {
+	_, err := runKeychainTool("", "secret-tool", "clear", "service", keychainService, "host", host)
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package main
+
+// platformKeychain reports that there is no credential store on this system,
+// so the cookie is kept in the config file.
+func platformKeychain() keychain {
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// windowsKeychain uses the Windows Credential Manager.
+type windowsKeychain struct{}
+
+var (
+	advapi32       = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = 1168
+)
+
+// winCredential is the CREDENTIALW structure.
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func platformKeychain() keychain {
+	if advapi32.Load() != nil || procCredReadW.Find() != nil {
+		return nil
+	}
+	return windowsKeychain{}
+}
+
+func (windowsKeychain) String() string { return "Windows Credential Manager" }
+
+func credentialTarget(host string) *uint16 {
+	target, _ := syscall.UTF16PtrFromString(keychainService + ":" + host)
+	return target
+}
+
+func (windowsKeychain) get(host string) (string, error) {
+	var cred *winCredential
+	ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(credentialTarget(host))), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ret == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno == errorNotFound {
+			return "", errNoCredential
+		}
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (windowsKeychain) set(host, cookie string) error {
+	blob := []byte(cookie)
+	user, _ := syscall.UTF16PtrFromString(host)
+	cred := &winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         credentialTarget(host),
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if ret, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(cred)), 0); ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func (windowsKeychain) remove(host string) error {
+	ret, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(credentialTarget(host))), credTypeGeneric, 0)
+	if ret == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno == errorNotFound {
+			return errNoCredential
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandRedeem(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	redemption := new(LabRedemption)
+	mustPostObject("/lab_redemptions", nil, &LabCode{Code: args[0]}, redemption)
+	problemSet := new(ProblemSet)
+	mustGetObject(fmt.Sprintf("/problem_sets/%d", redemption.ProblemSetID), nil, problemSet)
+	switch redemption.Purpose {
+	case LabCredit:
+		log.Printf("you have been checked off for %s", problemSet.Unique)
+	default:
+		log.Printf("%s is unlocked; use \"grind get\" to download it", problemSet.Unique)
+	}
+}
+
+func CommandCourseLabCode(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/lab_sessions", course.ID, problemSet.ID)
+
+	if revoke := cmd.Flag("revoke").Value.String(); revoke != "" {
+		doRequest(fmt.Sprintf("%s/%d", path, mustParseID(revoke)), nil, "DELETE", nil, nil, false)
+		log.Printf("lab code %s has expired", revoke)
+		return
+	}
+	if cmd.Flag("list").Value.String() == "true" {
+		sessions := []*LabSession{}
+		mustGetObject(path, nil, &sessions)
+		if len(sessions) == 0 {
+			fmt.Printf("no lab codes have been issued for %s\n", problemSet.Unique)
+			return
+		}
+		now := serverNow()
+		for _, elt := range sessions {
+			status := "expired"
+			if !elt.IsExpired(now) {
+				status = "until " + elt.ExpiresAt.Local().Format("15:04")
+			}
+			fmt.Printf("%4d %s %-6s %s %s, redeemed by %d student%s\n", elt.ID, elt.Code, elt.Purpose,
+				elt.CreatedAt.Local().Format("2006-01-02 15:04"), status, elt.Redeemed, plural(elt.Redeemed))
+		}
+		return
+	}
+
+	minutes, err := strconv.Atoi(cmd.Flag("minutes").Value.String())
+	if err != nil || minutes < 1 {
+		fatalf(exitUsage, "minutes must be a positive number")
+	}
+	session := &LabSession{
+		Purpose:   LabUnlock,
+		ExpiresAt: serverNow().Add(time.Duration(minutes) * time.Minute),
+	}
+	if cmd.Flag("credit").Value.String() == "true" {
+		session.Purpose = LabCredit
+	}
+	saved := new(LabSession)
+	mustPostObject(path, nil, session, saved)
+	fmt.Printf("\n    %s\n\n", saved.Code)
+	log.Printf("students can run \"grind redeem %s\" until %s (code ID %d)", saved.Code, saved.ExpiresAt.Local().Format("15:04"), saved.ID)
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	. "github.com/russross/codegrinder/types"
 	"github.com/spf13/cobra"
@@ -10,35 +12,116 @@ import (
 
 func CommandList(cmd *cobra.Command, args []string) {
 	mustLoadConfig(cmd)
+	if len(args) != 0 {
+		usage(cmd)
+	}
+
+	// the server does the filtering and sorting
+	params := map[string]string{}
+	all := cmd.Flag("all").Value.String() == "true"
+	if !all {
+		// courses from past terms are hidden unless --all is given
+		params["active"] = "true"
+	}
+	course := cmd.Flag("course").Value.String()
+	if course != "" {
+		if id, err := strconv.ParseInt(course, 10, 64); err == nil && id > 0 {
+			params["course_id"] = course
+		} else {
+			params["course_lti_label"] = course
+		}
+	}
+	if s := cmd.Flag("due-before").Value.String(); s != "" {
+		params["due_before"] = mustParseDueBefore(s).Format(time.RFC3339)
+	}
+	if cmd.Flag("incomplete").Value.String() == "true" {
+		params["incomplete"] = "true"
+	}
+	sort := cmd.Flag("sort").Value.String()
+	switch sort {
+	case "course", "due", "title", "updated":
+		params["sort"] = sort
+	default:
+		fatalf(exitUsage, "--sort must be course, due, title, or updated, not %q", sort)
+	}
+	long := cmd.Flag("long").Value.String() == "true"
 
-	user := new(User)
-	mustGetObject("/users/me", nil, user)
-	assignments := []*Assignment{}
-	mustGetObject(fmt.Sprintf("/users/%d/assignments", user.ID), nil, &assignments)
+	assignments := []*AssignmentListing{}
+	mustGetList("/users/me/assignments", params, &assignments)
 	if len(assignments) == 0 {
-		log.Printf("no assignments found")
-		log.Fatalf("you must start each assignment through Canvas before you can access it here")
+		switch {
+		case course != "" || params["due_before"] != "" || params["incomplete"] != "":
+			fmt.Println("no assignments match")
+		case !all:
+			errorLog.Printf("no assignments found in active courses; use \"grind list --all\" to include past terms")
+			fatalf(exitUsage, "you must start each assignment through Canvas before you can access it here")
+		default:
+			errorLog.Printf("no assignments found")
+			fatalf(exitUsage, "you must start each assignment through Canvas before you can access it here")
+		}
 	}
 
-	var course *Course
-	for _, asst := range assignments {
-		if course == nil || asst.CourseID != course.ID {
-			if course != nil {
+	var courseID int64
+	for i, asst := range assignments {
+		if sort == "course" && asst.CourseID != courseID {
+			// a heading for each course
+			courseID = asst.CourseID
+			if i > 0 {
 				fmt.Println()
 			}
+			title := asst.CourseName
+			if asst.CourseTerm != "" {
+				title += " (" + asst.CourseTerm + ")"
+			}
+			if !asst.CourseActive {
+				title += " [inactive]"
+			}
+			fmt.Println(title)
+			fmt.Println(dashes(len(title)))
+		}
 
-			// fetch the course
-			course = new(Course)
-			mustGetObject(fmt.Sprintf("/courses/%d", asst.CourseID), nil, course)
-			fmt.Println(course.Name)
-			fmt.Println(dashes(len(course.Name)))
+		fmt.Printf("%d: %s (%s/%s)\n", asst.ID, asst.CanvasTitle, asst.CourseLabel, asst.ProblemSetUnique)
+		if long {
+			var details []string
+			if !asst.DueAt.IsZero() {
+				details = append(details, "due "+asst.DueAt.Local().Format("Mon Jan 2 15:04"))
+			} else {
+				details = append(details, "no due date")
+			}
+			details = append(details, fmt.Sprintf("%.0f%% complete", asst.Score*100.0))
+			if sort != "course" && asst.CourseTerm != "" {
+				details = append(details, asst.CourseTerm)
+			}
+			details = append(details, "last worked on "+asst.UpdatedAt.Local().Format("Jan 2 15:04"))
+			fmt.Printf("    %s\n", strings.Join(details, ", "))
+		}
+		if asst.IsLocked() {
+			fmt.Printf("    locked until you complete:\n")
+			for _, status := range asst.Prerequisites {
+				if !status.IsMet() {
+					fmt.Printf("      %s at %.0f%% (you have %.0f%%)\n", status.Unique, status.Threshold*100.0, status.Score*100.0)
+				}
+			}
 		}
+	}
 
-		// fetch the problem
-		problemSet := new(ProblemSet)
-		mustGetObject(fmt.Sprintf("/problem_sets/%d", asst.ProblemSetID), nil, problemSet)
-		fmt.Printf("%d: %s (%s/%s)\n", asst.ID, asst.CanvasTitle, course.Label, problemSet.Unique)
+	// servers too old to have announcements report none
+	announcements := []*Announcement{}
+	doRequest("/users/me/announcements", nil, "GET", nil, &announcements, true)
+	printAnnouncements(announcements)
+}
+
+// mustParseDueBefore parses the --due-before flag: a date, which includes all of that day,
+// or a time in RFC 3339 format.
+func mustParseDueBefore(s string) time.Time {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1)
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
 	}
+	fatalf(exitUsage, "--due-before must be a date like 2006-01-02 or a time like 2006-01-02T15:04:05-07:00, not %q", s)
+	return time.Time{}
 }
 
 func dashes(n int) string {
@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/blang/semver"
 	. "github.com/russross/codegrinder/types"
@@ -18,41 +20,69 @@ import (
 )
 
 const (
-	defaultHost          = "dorking.cs.dixie.edu"
-	perUserDotFile       = ".codegrinderrc"
-	perProblemSetDotFile = ".grind"
+	defaultHost            = "dorking.cs.dixie.edu"
+	perUserDotFile         = ".codegrinderrc"
+	tokenVariable          = "CODEGRINDER_TOKEN"
+	hostVariable           = "CODEGRINDER_HOST"
+	hostOverrideVariable   = "GRIND_HOST"
+	cookieOverrideVariable = "GRIND_COOKIE"
+	perProblemSetDotFile   = ".grind"
 )
 
-var Config struct {
-	Host      string `json:"host"`
-	Cookie    string `json:"cookie"`
-	apiReport bool
-	apiDump   bool
+type grindConfig struct {
+	Host        string             `json:"host"`
+	Cookie      string             `json:"cookie"`
+	Maintenance *MaintenanceNotice `json:"maintenance,omitempty"`
+	Confirm     string             `json:"confirm,omitempty"`
+	State       string             `json:"state,omitempty"`
+	Credentials string             `json:"credentials,omitempty"`
+	Keychain    bool               `json:"keychain,omitempty"`  // the cookie is in the keychain, not this file
+	ReportKey   string             `json:"reportKey,omitempty"` // the public key grade reports must be signed with
+	ExamKeys    map[string]string  `json:"examKeys,omitempty"`  // sealed exam key fingerprints by assignment ID
+	apiReport   bool
+	apiDump     bool
+	fromFile    bool
+	fileValues  map[string]string // settings from the file that the environment overrides
+	stored      string            // the cookie as it is in the keychain
 }
 
+var Config grindConfig
+
+// traceParent is sent with every request so that the server can trace everything
+// one run of grind asks it to do, including grading on the daycare, as a single trace.
+var traceParent = FormatTraceParent(NewTraceID(), NewSpanID(), true)
+
 type DotFileInfo struct {
 	AssignmentID int64                   `json:"assignmentID"`
 	Problems     map[string]*ProblemInfo `json:"problems"`
 	Path         string                  `json:"-"`
+	Dir          string                  `json:"-"`
 }
 
 type ProblemInfo struct {
-	ID        int64           `json:"id"`
-	Step      int64           `json:"step"`
-	Whitelist map[string]bool `json:"whitelist"`
+	ID        int64             `json:"id"`
+	Step      int64             `json:"step"`
+	Whitelist map[string]bool   `json:"whitelist"`
+	Snapshot  map[string]string `json:"snapshot,omitempty"`
+	SyncedAt  time.Time         `json:"syncedAt,omitempty"`
 }
 
 func main() {
 	log.SetFlags(log.Ltime)
+	errorLog.SetOutput(io.MultiWriter(os.Stderr, new(errorLogFile)))
 
 	cmdGrind := &cobra.Command{
 		Use:   "grind",
 		Short: "Command-line interface to CodeGrinder",
 		Long: "A command-line tool to access CodeGrinder\n" +
-			"by Russ Ross <russ@russross.com>",
+			"by Russ Ross <russ@russross.com>\n\n" +
+			"Exit status: 0 success, 1 work did not pass, 2 usage error,\n" +
+			"3 not logged in or not allowed, 4 network error, 5 server error",
 	}
 	cmdGrind.PersistentFlags().BoolP("api", "", false, "report all API requests")
 	cmdGrind.PersistentFlags().BoolP("api-dump", "", false, "dump API request and response data")
+	cmdGrind.PersistentFlags().BoolP("quiet", "q", false, "only print errors and the information asked for")
+	addHostFlags(cmdGrind)
 
 	cmdVersion := &cobra.Command{
 		Use:   "version",
@@ -66,17 +96,108 @@ func main() {
 	cmdInit := &cobra.Command{
 		Use:   "init",
 		Short: "connect to codegrinder server",
-		Run:   CommandInit,
+		Long: "   Use --host to log in to a server other than " + defaultHost + ",\n" +
+			"   or --school to look up your school's server by name, e.g.,\n" +
+			"   grind init --school \"Dixie State\". The server is saved with your\n" +
+			"   cookie for later commands.",
+		Run: CommandInit,
 	}
+	cmdInit.Flags().StringP("school", "", "", "find your school's server in the directory at "+discoveryHost)
 	cmdGrind.AddCommand(cmdInit)
 
+	cmdLogout := &cobra.Command{
+		Use:   "logout",
+		Short: "forget your login cookie",
+		Long: "   Removes your cookie from the system keychain and the config file.\n" +
+			"   Use \"grind init\" to log in again.",
+		Run: CommandLogout,
+	}
+	cmdGrind.AddCommand(cmdLogout)
+
+	cmdConfig := &cobra.Command{
+		Use:   "config",
+		Short: "show or change settings in the config file",
+		Long: "   Settings are kept in ~/.config/codegrinder/" + configFileName + ", which \"grind init\"\n" +
+			"   creates. XDG_CONFIG_HOME, XDG_CACHE_HOME, and XDG_STATE_HOME move the\n" +
+			"   config, cache, and state directories as usual. Errors are logged in\n" +
+			"   " + errorLogFileName + " in the cache directory.\n\n" +
+			"   " + hostOverrideVariable + " and " + cookieOverrideVariable + " override the host and cookie\n" +
+			"   settings from the file without changing it.",
+	}
+	cmdGrind.AddCommand(cmdConfig)
+
+	cmdConfigList := &cobra.Command{
+		Use:   "list",
+		Short: "list every setting with its value",
+		Run:   CommandConfigList,
+	}
+	cmdConfig.AddCommand(cmdConfigList)
+
+	cmdConfigGet := &cobra.Command{
+		Use:   "get",
+		Short: "print the value of a setting",
+		Long:  "   Example: grind config get host",
+		Run:   CommandConfigGet,
+	}
+	cmdConfig.AddCommand(cmdConfigGet)
+
+	cmdConfigSet := &cobra.Command{
+		Use:   "set",
+		Short: "change a setting",
+		Long: "   Give the name of the setting and its new value. The value is checked\n" +
+			"   before it is saved. Use \"grind config list\" to see the settings.\n\n" +
+			"   Example: grind config set confirm strict",
+		Run: CommandConfigSet,
+	}
+	cmdConfig.AddCommand(cmdConfigSet)
+
+	cmdCache := &cobra.Command{
+		Use:   "cache",
+		Short: "manage downloaded copies of problem steps",
+		Long: "   Instructions, images, and starter files for each problem step are kept\n" +
+			"   in the cache directory (~/.cache/codegrinder unless XDG_CACHE_HOME says\n" +
+			"   otherwise) so they are only downloaded once. A problem that changes on\n" +
+			"   the server is downloaded again.",
+	}
+	cmdGrind.AddCommand(cmdCache)
+
+	cmdCacheClean := &cobra.Command{
+		Use:   "clean",
+		Short: "remove all cached problem steps",
+		Run:   CommandCacheClean,
+	}
+	cmdCache.AddCommand(cmdCacheClean)
+
 	cmdList := &cobra.Command{
 		Use:   "list",
 		Short: "list all of your active assignments",
-		Run:   CommandList,
+		Long: "   Assignments are grouped by course unless --sort says otherwise.\n" +
+			"   A date for --due-before includes all of that day.\n\n" +
+			"   Example: grind list --incomplete --due-before 2024-10-01 --sort due --long",
+		Run: CommandList,
 	}
+	cmdList.Flags().BoolP("all", "a", false, "include courses from past terms")
+	cmdList.Flags().StringP("course", "c", "", "only list assignments in this course (label or ID)")
+	cmdList.Flags().String("due-before", "", "only list assignments due before this date or time")
+	cmdList.Flags().BoolP("incomplete", "i", false, "leave out assignments with full credit")
+	cmdList.Flags().StringP("sort", "s", "course", "order by course, due, title, or updated")
+	cmdList.Flags().BoolP("long", "l", false, "show the due date, progress, and when you last worked on each")
+	requires(cmdList, "GET /users/me/assignments")
 	cmdGrind.AddCommand(cmdList)
 
+	cmdAck := &cobra.Command{
+		Use:   "ack",
+		Short: "acknowledge announcements so they are no longer shown",
+		Long: "   Give the ID of each announcement you have read, as shown by\n" +
+			"   \"grind list\" and \"grind status\", or use --all to acknowledge\n" +
+			"   every announcement waiting for you.\n\n" +
+			"   Example: grind ack 12",
+		Run: CommandAck,
+	}
+	cmdAck.Flags().BoolP("all", "a", false, "acknowledge every announcement")
+	requires(cmdAck, "POST /users/me/announcements/:announcement_id/acknowledge")
+	cmdGrind.AddCommand(cmdAck)
+
 	cmdGet := &cobra.Command{
 		Use:   "get",
 		Short: "download an assignment to work on it locally",
@@ -86,10 +207,17 @@ func main() {
 			"   By default, the assignment will be stored in a directory matching the\n" +
 			"   course/problem name, but you can override this by supplying the directory\n" +
 			"   name as an additional argument.\n\n" +
+			"   If the directory already holds a copy of the assignment, it is brought up\n" +
+			"   to date with work saved from other computers. Files changed both locally\n" +
+			"   and on the server are reported as conflicts for you to resolve.\n\n" +
+			"   Downloading into a directory that holds other files asks before any\n" +
+			"   are overwritten.\n\n" +
 			"   Example: grind get CS-1400/cs1400-loops\n\n" +
 			"   Note: you must load an assignment through Canvas before you can access it.",
 		Run: CommandGet,
 	}
+	addForceFlag(cmdGet)
+	cmdGet.Flags().String("conflict", "", "when updating an existing copy, resolve conflicts with the server copy: local, server, or both")
 	cmdGrind.AddCommand(cmdGet)
 
 	cmdSave := &cobra.Command{
@@ -97,15 +225,344 @@ func main() {
 		Short: "save your work to the server without additional action",
 		Run:   CommandSave,
 	}
+	cmdSave.Flags().BoolP("all", "a", false, "work on every problem in the problem set")
+	cmdSave.Flags().String("conflict", "", "resolve conflicts with the server copy: local, server, or both")
 	cmdGrind.AddCommand(cmdSave)
 
+	cmdRemote := &cobra.Command{
+		Use:   "remote",
+		Short: "set up git push to submit your work",
+		Long: "   Run this in a problem set directory that is a git repository.\n" +
+			"   It adds a git remote named " + gitRemote + ", and each push to it is\n" +
+			"   graded with the results shown in the output of git push.\n" +
+			"   Problem sets with several problems keep each one in its own directory.\n\n" +
+			"   Example: grind remote && git push " + gitRemote + " main",
+		Run: CommandRemote,
+	}
+	cmdGrind.AddCommand(cmdRemote)
+
 	cmdGrade := &cobra.Command{
 		Use:   "grade",
 		Short: "save your work and submit it for grading",
-		Run:   CommandGrade,
+		Long: "   To grade from a CI job such as GitHub Actions, set " + tokenVariable + "\n" +
+			"   to the cookie from \"grind init\" (stored as a secret) and, if needed,\n" +
+			"   " + hostVariable + " to the server name. With --junit, the results are\n" +
+			"   also written in JUnit XML form for the CI system to display.\n\n" +
+			"   Example: grind grade --all --dir assignment --junit results.xml",
+		Run: CommandGrade,
 	}
+	cmdGrade.Flags().Bool("async", false, "submit for grading and return without waiting for the results")
+	cmdGrade.Flags().BoolP("all", "a", false, "work on every problem in the problem set")
+	cmdGrade.Flags().String("conflict", "", "resolve conflicts with the server copy: local, server, or both")
+	cmdGrade.Flags().String("dir", "", "directory holding the problem (instead of giving it as an argument)")
+	cmdGrade.Flags().String("junit", "", "also write the results to this file as JUnit XML")
+	cmdGrade.Flags().Bool("broadcast", false, "instructors: show the grading live to everyone in the course")
+	requiresWithFlag(cmdGrade, "async", "POST /submissions")
+	requiresWithFlag(cmdGrade, "broadcast", "PUT /courses/:course_id/broadcast")
 	cmdGrind.AddCommand(cmdGrade)
 
+	cmdVerify := &cobra.Command{
+		Use:   "verify",
+		Short: "confirm that your local files match the work saved on the server",
+		Run:   CommandVerify,
+	}
+	cmdVerify.Flags().BoolP("all", "a", false, "work on every problem in the problem set")
+	cmdGrind.AddCommand(cmdVerify)
+
+	cmdVerifyReport := &cobra.Command{
+		Use:   "verify-report",
+		Short: "get or check a grade report signed by the server",
+		Long: "   With no arguments, run this from a problem set directory. A report of\n" +
+			"   your grade signed by the server is downloaded, checked, and saved as\n" +
+			"   " + gradeReportFile + " in the problem set directory.\n\n" +
+			"   Give the name of a saved report to check its signature, and to confirm\n" +
+			"   that every submission it vouches for is still on record unchanged.\n" +
+			"   Signatures are checked against the server's key as pinned by \"grind\n" +
+			"   init\", whose fingerprint is shown with each report.\n" +
+			"   Keep saved reports somewhere safe to settle any dispute about your work.\n\n" +
+			"   Example: grind verify-report ~/reports/cs1400-loops.json",
+		Run: CommandVerifyReport,
+	}
+	requires(cmdVerifyReport, "GET /assignments/:assignment_id/report")
+	cmdGrind.AddCommand(cmdVerifyReport)
+
+	cmdArchive := &cobra.Command{
+		Use:   "archive",
+		Short: "download your work from courses that have ended",
+		Long: "   After a course ends or you leave it, your work stays available to\n" +
+			"   download for a while, but it can no longer be changed or submitted.",
+	}
+	cmdGrind.AddCommand(cmdArchive)
+
+	cmdArchiveList := &cobra.Command{
+		Use:   "list",
+		Short: "list work from past courses that you can still download",
+		Run:   CommandArchiveList,
+	}
+	requires(cmdArchiveList, "GET /users/me/archive")
+	cmdArchive.AddCommand(cmdArchiveList)
+
+	cmdArchiveGet := &cobra.Command{
+		Use:   "get",
+		Short: "download your work and grade report from a past course",
+		Long: "   Give the assignment ID shown by \"grind archive list\". Your last saved\n" +
+			"   work on each problem is written to archive/COURSE/problem-set, or to\n" +
+			"   the directory given as an additional argument, along with a grade\n" +
+			"   report signed by the server that \"grind verify-report\" can check.\n\n" +
+			"   Example: grind archive get 1234 ~/cs1400/loops",
+		Run: CommandArchiveGet,
+	}
+	requires(cmdArchiveGet, "GET /users/me/archive/:assignment_id")
+	cmdArchive.AddCommand(cmdArchiveGet)
+
+	cmdExportMyWork := &cobra.Command{
+		Use:   "export-my-work",
+		Short: "download everything you have ever submitted, for your own records",
+		Long: "   Every commit you have saved in any course is written to a directory\n" +
+			"   tree, named codegrinder-export unless you give another name, laid out as\n" +
+			"   COURSE/problem-set/problem/step-N/TIME. Each commit directory holds the\n" +
+			"   files you saved and a " + exportCommitFile + " with the time, score, and\n" +
+			"   report card. Each problem set directory also gets a grade report signed\n" +
+			"   by the server that \"grind verify-report\" can check.\n\n" +
+			"   Example: grind export-my-work --zip ~/portfolio/codegrinder.zip",
+		Run: CommandExportMyWork,
+	}
+	cmdExportMyWork.Flags().BoolP("zip", "z", false, "write a zip file instead of a directory")
+	requires(cmdExportMyWork, "GET /users/me/export")
+	cmdGrind.AddCommand(cmdExportMyWork)
+
+	cmdSubmitArchive := &cobra.Command{
+		Use:   "submit-archive",
+		Short: "grade work from a zip or tar.gz file instead of a grind directory",
+		Long: "   Give the archive and the assignment, either as its numeric ID or as\n" +
+			"   the course/problem identifier shown by \"grind list\". The files the\n" +
+			"   current step expects are taken from the archive, even if they are\n" +
+			"   inside a folder; anything else is skipped. For a problem set with more\n" +
+			"   than one problem, use --problem to say which one the archive holds.\n\n" +
+			"   Use \"grind get\" afterward to download the next step.\n\n" +
+			"   Example: grind submit-archive project.zip CS-1400/cs1400-loops",
+		Run: CommandSubmitArchive,
+	}
+	cmdSubmitArchive.Flags().StringP("problem", "p", "", "the problem the archive holds")
+	cmdSubmitArchive.Flags().Bool("save", false, "save the work without grading it")
+	cmdGrind.AddCommand(cmdSubmitArchive)
+
+	cmdEmail := &cobra.Command{
+		Use:   "email",
+		Short: "show how to submit work by email if you cannot reach the LMS",
+		Long: "   Shows the address and the private key to use when emailing your work,\n" +
+			"   and what happened to work you have already sent. Look this up ahead of\n" +
+			"   time so you have it if the LMS is down near a deadline.",
+		Run: CommandEmail,
+	}
+	requires(cmdEmail, "GET /users/me/email_gateway")
+	cmdGrind.AddCommand(cmdEmail)
+
+	cmdResults := &cobra.Command{
+		Use:   "results",
+		Short: "show the results of submissions made with \"grind grade --async\"",
+		Long: "   With no arguments, lists your recent submissions.\n" +
+			"   Give a submission ID to see its report card. If the submission passed\n" +
+			"   and you run this from the problem directory, it moves on to the next step.",
+		Run: CommandResults,
+	}
+	cmdResults.Flags().IntP("wait", "w", 0, "seconds to wait for grading to finish")
+	cmdGrind.AddCommand(cmdResults)
+
+	cmdSolution := &cobra.Command{
+		Use:   "solution",
+		Short: "download the reference solution once your instructor releases it",
+		Long: "   Run this from a problem directory, or give the directory. The solution\n" +
+			"   is saved in a " + solutionDir + " directory inside the problem directory, so\n" +
+			"   your own work is left alone. Your instructor decides when solutions\n" +
+			"   are released: after a given time, after you pass the problem, or both.",
+		Run: CommandSolution,
+	}
+	requires(cmdSolution, "GET /assignments/:assignment_id/problems/:problem_id/solution")
+	cmdGrind.AddCommand(cmdSolution)
+
+	cmdExemplars := &cobra.Command{
+		Use:   "exemplars",
+		Short: "download example solutions from other students chosen by your instructor",
+		Long: "   Run this from a problem directory, or give the directory. Exemplars\n" +
+			"   are saved in an " + exemplarDir + " directory inside the problem directory.\n" +
+			"   They are passing solutions by students who agreed to share them, and\n" +
+			"   their authors' names are never shown.\n\n" +
+			"   Use --share to let your instructor choose your passing work on the\n" +
+			"   problem as an exemplar, or --unshare to withdraw and remove it.",
+		Run: CommandExemplars,
+	}
+	cmdExemplars.Flags().Bool("share", false, "agree to let your passing work be shown to other students")
+	cmdExemplars.Flags().Bool("unshare", false, "withdraw your agreement and remove your work if it was shown")
+	requires(cmdExemplars, "GET /assignments/:assignment_id/problems/:problem_id/exemplars")
+	cmdGrind.AddCommand(cmdExemplars)
+
+	cmdReflect := &cobra.Command{
+		Use:   "reflect",
+		Short: "answer your instructor's questions about your work on a problem",
+		Long: "   Run this from a problem directory, or give the directory. Some\n" +
+			"   problems ask questions such as how long the work took or what was\n" +
+			"   hardest. Questions asked before grading must be answered before the\n" +
+			"   last step can be graded. Only unanswered questions are asked unless\n" +
+			"   --redo is given.",
+		Run: CommandReflect,
+	}
+	cmdReflect.Flags().Bool("redo", false, "ask every question again, even ones already answered")
+	requires(cmdReflect, "GET /assignments/:assignment_id/problems/:problem_id/reflections")
+	cmdGrind.AddCommand(cmdReflect)
+
+	cmdFeedback := &cobra.Command{
+		Use:   "feedback",
+		Short: "show how your instructor graded your work against the rubric",
+		Long: "   Run this from a problem set directory, or give the directory.\n" +
+			"   Until your work is graded, the rubric that will be used is shown.",
+		Run: CommandFeedback,
+	}
+	requires(cmdFeedback, "GET /assignments/:assignment_id/rubric")
+	cmdGrind.AddCommand(cmdFeedback)
+
+	cmdCheckoff := &cobra.Command{
+		Use:   "checkoff",
+		Short: "record that a student demonstrated their work in person",
+		Long: "   Give the student's email address and the assignment as\n" +
+			"   course/problem-set. The check-off is recorded with the time and you\n" +
+			"   as the grader, and combined with the autograded score if the problem\n" +
+			"   set requires one (see \"grind course checkoff\"). With only the\n" +
+			"   assignment, the students checked off so far are listed. While grading\n" +
+			"   is anonymous, give the student's pseudonym instead of their email.\n\n" +
+			"   Example: grind checkoff ann@example.edu CS-1400/cs1400-lab3 --note \"explained the loop invariant\"",
+		Run: CommandCheckoff,
+	}
+	cmdCheckoff.Flags().String("note", "", "note about the demonstration")
+	cmdCheckoff.Flags().Bool("undo", false, "undo the student's check-off")
+	requires(cmdCheckoff, "PUT /courses/:course_id/problem_sets/:problem_set_id/checkoffs/:user_id")
+	cmdGrind.AddCommand(cmdCheckoff)
+
+	cmdStory := &cobra.Command{
+		Use:   "story",
+		Short: "show how a student's work on an assignment developed, save by save",
+		Long: "   Give the student's email address and the assignment as\n" +
+			"   course/problem-set. Each save is listed, oldest first, with the files\n" +
+			"   it touched, the lines added and removed since the save before it, and\n" +
+			"   the test results when it was graded. With --diff, the changed lines\n" +
+			"   are shown as well. While grading is anonymous, give the student's\n" +
+			"   pseudonym instead of their email.\n\n" +
+			"   Example: grind story ann@example.edu CS-1400/cs1400-lab3 --diff",
+		Run: CommandStory,
+	}
+	cmdStory.Flags().String("problem", "", "only show saves for this problem")
+	cmdStory.Flags().Bool("diff", false, "show the lines changed by each save")
+	requires(cmdStory, "GET /courses/:course_id/problem_sets/:problem_set_id/stories/:user_id")
+	cmdGrind.AddCommand(cmdStory)
+
+	cmdWatch := &cobra.Command{
+		Use:   "watch",
+		Short: "watch a run a student is sharing with you",
+		Long: "   Give the share ID the student sees after checking Share in the web\n" +
+			"   workspace. The run so far is shown, then everything that happens until\n" +
+			"   it ends. Lines you type are sent to the program only if the student\n" +
+			"   has also checked Let them type. The same session can be watched in a\n" +
+			"   browser at /watch/SHARE-ID on the server.\n\n" +
+			"   Example: grind watch K7QX2MPA9D",
+		Run: CommandWatch,
+	}
+	cmdGrind.AddCommand(cmdWatch)
+
+	cmdQueue := &cobra.Command{
+		Use:   "queue",
+		Short: "ask for help in office hours, or see your place in line",
+		Long: "   With no subcommand, shows where you are in the office-hours queue.\n" +
+			"   Instructors and TAs use the other subcommands to work through it,\n" +
+			"   or the page at /help_queue/COURSE-ID on the server.",
+		Run: CommandQueue,
+	}
+	requires(cmdQueue, "GET /users/me/help_requests")
+	cmdGrind.AddCommand(cmdQueue)
+
+	cmdQueueJoin := &cobra.Command{
+		Use:   "join",
+		Short: "join the office-hours queue for a course",
+		Long: "   Say what you need help with, and run this from the problem you are\n" +
+			"   working on or give its directory. Your work is saved first so whoever\n" +
+			"   helps you can look at it. Joining again while you are still waiting\n" +
+			"   changes your message without losing your place.\n\n" +
+			"   Example: grind queue join \"segfault in step 3\"",
+		Run: CommandQueueJoin,
+	}
+	cmdQueueJoin.Flags().String("conflict", "", "resolve conflicts with the server copy: local, server, or both")
+	requires(cmdQueueJoin, "POST /assignments/:assignment_id/help_requests")
+	cmdQueue.AddCommand(cmdQueueJoin)
+
+	cmdQueueLeave := &cobra.Command{
+		Use:   "leave",
+		Short: "leave the office-hours queue",
+		Run:   CommandQueueLeave,
+	}
+	requires(cmdQueueLeave, "DELETE /users/me/help_requests/:help_request_id")
+	cmdQueue.AddCommand(cmdQueueLeave)
+
+	cmdQueueList := &cobra.Command{
+		Use:   "list",
+		Short: "list the students waiting for help in a course (instructors and TAs only)",
+		Long: "   Give the course label. Students are listed in the order they joined,\n" +
+			"   with the ID used to claim and resolve each request.\n\n" +
+			"   Example: grind queue list CS-1400",
+		Run: CommandQueueList,
+	}
+	requires(cmdQueueList, "GET /courses/:course_id/help_requests")
+	cmdQueue.AddCommand(cmdQueueList)
+
+	cmdQueueClaim := &cobra.Command{
+		Use:   "claim",
+		Short: "start helping a student (instructors and TAs only)",
+		Long: "   Give the course label and the ID of the request.\n\n" +
+			"   Example: grind queue claim CS-1400 42",
+		Run: CommandQueueClaim,
+	}
+	requires(cmdQueueClaim, "PUT /courses/:course_id/help_requests/:help_request_id")
+	cmdQueue.AddCommand(cmdQueueClaim)
+
+	cmdQueueRelease := &cobra.Command{
+		Use:   "release",
+		Short: "put a claimed request back in its place in line (instructors and TAs only)",
+		Long: "   Give the course label and the ID of the request.\n\n" +
+			"   Example: grind queue release CS-1400 42",
+		Run: CommandQueueRelease,
+	}
+	requires(cmdQueueRelease, "PUT /courses/:course_id/help_requests/:help_request_id")
+	cmdQueue.AddCommand(cmdQueueRelease)
+
+	cmdQueueResolve := &cobra.Command{
+		Use:   "resolve",
+		Short: "take a request out of the queue once the student has been helped (instructors and TAs only)",
+		Long: "   Give the course label and the ID of the request. Wait and help times\n" +
+			"   are reported by \"grind course usage\".\n\n" +
+			"   Example: grind queue resolve CS-1400 42 --note \"off-by-one in the loop bound\"",
+		Run: CommandQueueResolve,
+	}
+	cmdQueueResolve.Flags().String("note", "", "note about the visit")
+	requires(cmdQueueResolve, "PUT /courses/:course_id/help_requests/:help_request_id")
+	cmdQueue.AddCommand(cmdQueueResolve)
+
+	cmdRedeem := &cobra.Command{
+		Use:   "redeem",
+		Short: "redeem a code given out in the lab",
+		Long: "   Give the code your instructor shows in the lab. Depending on the code,\n" +
+			"   it unlocks an assignment done in the lab or checks you off for it.\n\n" +
+			"   Example: grind redeem K7QX2M",
+		Run: CommandRedeem,
+	}
+	requires(cmdRedeem, "POST /lab_redemptions")
+	cmdGrind.AddCommand(cmdRedeem)
+
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "show your progress and grading settings for an assignment",
+		Run:   CommandStatus,
+	}
+	cmdStatus.Flags().BoolP("all", "a", false, "show every problem in the problem set")
+	cmdGrind.AddCommand(cmdStatus)
+
 	cmdCreate := &cobra.Command{
 		Use:   "create",
 		Short: "create a new problem (authors only)",
@@ -114,17 +571,768 @@ func main() {
 	cmdCreate.Flags().BoolP("update", "u", false, "update an existing problem")
 	cmdGrind.AddCommand(cmdCreate)
 
-	cmdGrind.Execute()
+	cmdCourse := &cobra.Command{
+		Use:   "course",
+		Short: "manage courses (instructors only)",
+	}
+	cmdGrind.AddCommand(cmdCourse)
+
+	cmdCourseTerm := &cobra.Command{
+		Use:   "term",
+		Short: "set the term for a course",
+		Long: "   Give the course label, the term name, and optionally the date the term ends.\n" +
+			"   The course becomes inactive after the end date.\n\n" +
+			"   Example: grind course term CS-1400 \"Fall 2016\" 2016-12-16",
+		Run: CommandCourseTerm,
+	}
+	requires(cmdCourseTerm, "PUT /courses/:course_id/term")
+	cmdCourse.AddCommand(cmdCourseTerm)
+
+	cmdCourseArchive := &cobra.Command{
+		Use:   "archive",
+		Short: "mark a course as inactive",
+		Run:   CommandCourseArchive,
+	}
+	cmdCourseArchive.Flags().BoolP("undo", "u", false, "make an archived course active again")
+	addForceFlag(cmdCourseArchive)
+	requires(cmdCourseArchive, "PUT /courses/:course_id/term")
+	cmdCourse.AddCommand(cmdCourseArchive)
+
+	cmdCourseRollForward := &cobra.Command{
+		Use:   "roll-forward",
+		Short: "copy problem sets from an earlier course into a new term's course",
+		Long: "   Give the label of the earlier course, then the label of the new course.\n" +
+			"   You must have launched at least one assignment in the new course through\n" +
+			"   Canvas as an instructor first.\n\n" +
+			"   Example: grind course roll-forward CS-1400-F16 CS-1400-S17",
+		Run: CommandCourseRollForward,
+	}
+	cmdCourseRollForward.Flags().StringP("term", "t", "", "name of the new term")
+	cmdCourseRollForward.Flags().StringP("ends", "e", "", "date the new term ends (YYYY-MM-DD)")
+	addForceFlag(cmdCourseRollForward)
+	requires(cmdCourseRollForward, "POST /courses/:course_id/roll_forward")
+	cmdCourse.AddCommand(cmdCourseRollForward)
+
+	cmdCourseProblemType := &cobra.Command{
+		Use:   "problem-type",
+		Short: "override problem type defaults for a course",
+		Long: "   Give the course label and the problem type name, followed by the settings\n" +
+			"   to change. Settings that are not given use the problem type defaults.\n" +
+			"   The new settings apply to all grading in the course from then on.\n\n" +
+			"   Example: grind course problem-type CS-1400 python27unittest --max-cpu 20 --option strict-style",
+		Run: CommandCourseProblemType,
+	}
+	cmdCourseProblemType.Flags().String("image", "", "container image to use for grading")
+	cmdCourseProblemType.Flags().Int("max-cpu", 0, "CPU time limit in seconds")
+	cmdCourseProblemType.Flags().Int("max-clock", 0, "wall clock time limit in seconds")
+	cmdCourseProblemType.Flags().Int("max-fd", 0, "open file descriptor limit")
+	cmdCourseProblemType.Flags().Int("max-file-size", 0, "file size limit in megabytes")
+	cmdCourseProblemType.Flags().Int("max-memory", 0, "memory limit in megabytes")
+	cmdCourseProblemType.Flags().Int("max-threads", 0, "thread limit")
+	cmdCourseProblemType.Flags().StringSlice("option", nil, "extra option passed to the grader (may be repeated)")
+	cmdCourseProblemType.Flags().Bool("reset", false, "remove all overrides and use the defaults")
+	addForceFlag(cmdCourseProblemType)
+	requires(cmdCourseProblemType, "PUT /courses/:course_id/problem_type_overrides/:problem_type")
+	cmdCourse.AddCommand(cmdCourseProblemType)
+
+	cmdCourseSeal := &cobra.Command{
+		Use:   "seal",
+		Short: "make a problem set a sealed exam",
+		Long: "   Give the course label, the problem set, and the deadline.\n" +
+			"   Student work is encrypted to a key generated on this computer, so no one\n" +
+			"   can read it until you unseal the exam with the private key after the deadline.\n" +
+			"   The private key is saved in the current directory unless --key is given.\n" +
+			"   Give students the key fingerprint it prints; grind shows them the same\n" +
+			"   fingerprint when they submit, and refuses if the key ever changes.\n\n" +
+			"   Example: grind course seal CS-1400 cs1400-midterm \"2016-10-14 17:00\"",
+		Run: CommandCourseSeal,
+	}
+	cmdCourseSeal.Flags().StringP("key", "k", "", "file to save the private key in")
+	requires(cmdCourseSeal, "PUT /courses/:course_id/problem_sets/:problem_set_id/seal")
+	cmdCourse.AddCommand(cmdCourseSeal)
+
+	cmdCourseUnseal := &cobra.Command{
+		Use:   "unseal",
+		Short: "decrypt a sealed exam after its deadline and grade the submissions",
+		Long: "   Give the course label and the problem set. The private key saved by\n" +
+			"   \"grind course seal\" is sent to the server to decrypt the submissions,\n" +
+			"   which are then graded in the background. The key is not stored.\n\n" +
+			"   Example: grind course unseal CS-1400 cs1400-midterm",
+		Run: CommandCourseUnseal,
+	}
+	cmdCourseUnseal.Flags().StringP("key", "k", "", "file holding the private key")
+	requires(cmdCourseUnseal, "POST /courses/:course_id/problem_sets/:problem_set_id/unseal")
+	cmdCourse.AddCommand(cmdCourseUnseal)
+
+	cmdCourseSolutions := &cobra.Command{
+		Use:   "solutions",
+		Short: "release the reference solutions to a problem set to students",
+		Long: "   Give the course label and the problem set. With --at, solutions are\n" +
+			"   released to everyone at that time; with --on-pass, each student can\n" +
+			"   get the solution to a problem as soon as they pass every step of it.\n" +
+			"   Use --file to release only some files of the solution. Students\n" +
+			"   download released solutions with \"grind solution\".\n\n" +
+			"   Example: grind course solutions CS-1400 cs1400-loops --at 2016-10-14 --on-pass",
+		Run: CommandCourseSolutions,
+	}
+	cmdCourseSolutions.Flags().String("at", "", "release time, as \"YYYY-MM-DD HH:MM\" or YYYY-MM-DD for the end of that day")
+	cmdCourseSolutions.Flags().Bool("on-pass", false, "release each solution to students who pass the problem")
+	cmdCourseSolutions.Flags().StringSlice("file", nil, "release only these files")
+	cmdCourseSolutions.Flags().Bool("remove", false, "stop releasing solutions")
+	requires(cmdCourseSolutions, "PUT /courses/:course_id/problem_sets/:problem_set_id/solution_release")
+	cmdCourse.AddCommand(cmdCourseSolutions)
+
+	cmdCourseExemplars := &cobra.Command{
+		Use:   "exemplars",
+		Short: "publish passing student solutions as examples for the class",
+		Long: "   Give the course label and the problem. With no flags, this lists the\n" +
+			"   published exemplars and the passing solutions whose authors agreed to\n" +
+			"   share them. Publish one by its commit ID with --publish; students can\n" +
+			"   see it after the last due date for the problem, or a later time given\n" +
+			"   with --after. Lines that mention its author are removed, and the files\n" +
+			"   are printed so you can check that nothing else gives them away.\n\n" +
+			"   Example: grind course exemplars CS-1400 loops-sum --publish 4211 --after 2016-10-14",
+		Run: CommandCourseExemplars,
+	}
+	cmdCourseExemplars.Flags().String("publish", "", "commit ID of the solution to publish")
+	cmdCourseExemplars.Flags().String("after", "", "when students may see it, no earlier than the due date, as \"YYYY-MM-DD HH:MM\" or YYYY-MM-DD for the end of that day")
+	cmdCourseExemplars.Flags().String("note", "", "what students should notice about it")
+	cmdCourseExemplars.Flags().String("remove", "", "ID of an exemplar to remove")
+	requires(cmdCourseExemplars, "GET /courses/:course_id/problems/:problem_id/exemplar_candidates")
+	cmdCourse.AddCommand(cmdCourseExemplars)
+
+	cmdCourseReflections := &cobra.Command{
+		Use:   "reflections",
+		Short: "export students' answers to reflection questions as CSV",
+		Long: "   Give the course label, and optionally a problem. The answers are\n" +
+			"   written to standard output in CSV form. With --anonymous, students\n" +
+			"   are identified by pseudonyms that are the same in every export for\n" +
+			"   the course, for use in research.\n\n" +
+			"   Example: grind course reflections CS-1400 loops-sum --anonymous > loops.csv",
+		Run: CommandCourseReflections,
+	}
+	cmdCourseReflections.Flags().Bool("anonymous", false, "identify students by pseudonyms")
+	requires(cmdCourseReflections, "GET /courses/:course_id/reflections")
+	cmdCourse.AddCommand(cmdCourseReflections)
+
+	cmdCourseRubric := &cobra.Command{
+		Use:   "rubric",
+		Short: "set the rubric for grading a problem set by hand",
+		Long: "   Give the course label, the problem set, and a rubric file, or leave\n" +
+			"   off the file to see the current rubric. The file looks like this:\n\n" +
+			"       [rubric]\n" +
+			"       formula = weighted\n" +
+			"       weight = 0.3\n\n" +
+			"       [criterion \"style\"]\n" +
+			"       description = names, layout, and comments\n" +
+			"       level = 4 excellent: clear and consistent throughout\n" +
+			"       level = 2 fair\n" +
+			"       level = 0 missing\n\n" +
+			"   The formula combines the rubric score with the autograded score:\n" +
+			"   weighted (the rubric counts for the given weight), minimum (the\n" +
+			"   lower of the two), or product (the autograded score scaled by the\n" +
+			"   rubric score). Until a student is graded, the autograded score stands.\n\n" +
+			"   Example: grind course rubric CS-1400 cs1400-loops loops-rubric.cfg",
+		Run: CommandCourseRubric,
+	}
+	cmdCourseRubric.Flags().Bool("remove", false, "remove the rubric and grade by the autograder alone")
+	requires(cmdCourseRubric, "PUT /courses/:course_id/problem_sets/:problem_set_id/rubric")
+	cmdCourse.AddCommand(cmdCourseRubric)
+
+	cmdCourseRubricGrade := &cobra.Command{
+		Use:   "rubric-grade",
+		Short: "grade a student against the rubric for a problem set",
+		Long: "   Give the course label, the problem set, the student's email address,\n" +
+			"   and the level for each criterion as criterion=level, with an optional\n" +
+			"   comment for the student as criterion=level:comment. The student's\n" +
+			"   grade is updated and posted to the LMS. With only the course and the\n" +
+			"   problem set, the students graded so far are listed. While grading is\n" +
+			"   anonymous, give the student's pseudonym instead of their email.\n\n" +
+			"   Example: grind course rubric-grade CS-1400 cs1400-loops ann@example.edu \\\n" +
+			"       style=excellent \"design=fair:split main into helpers\"",
+		Run: CommandCourseRubricGrade,
+	}
+	cmdCourseRubricGrade.Flags().String("note", "", "overall note for the student")
+	requires(cmdCourseRubricGrade, "PUT /courses/:course_id/problem_sets/:problem_set_id/rubric_grades/:user_id")
+	cmdCourse.AddCommand(cmdCourseRubricGrade)
+
+	cmdCourseCheckoff := &cobra.Command{
+		Use:   "checkoff",
+		Short: "require students to demonstrate a problem set in person",
+		Long: "   Give the course label and the problem set. The check-off counts for\n" +
+			"   the given weight of the grade and the autograded score for the rest,\n" +
+			"   so students who have not been checked off lose that part of the\n" +
+			"   grade. Record check-offs with \"grind checkoff\".\n\n" +
+			"   Example: grind course checkoff CS-1400 cs1400-lab3 --weight 20%",
+		Run: CommandCourseCheckoff,
+	}
+	cmdCourseCheckoff.Flags().String("weight", "20%", "share of the grade given by the check-off")
+	cmdCourseCheckoff.Flags().Bool("remove", false, "no longer require a check-off")
+	requires(cmdCourseCheckoff, "PUT /courses/:course_id/problem_sets/:problem_set_id/checkoff_policy")
+	cmdCourse.AddCommand(cmdCourseCheckoff)
+
+	cmdCourseLabCode := &cobra.Command{
+		Use:   "lab-code",
+		Short: "issue a short-lived code for students to redeem in the lab",
+		Long: "   Give the course label and the problem set. The code is printed for\n" +
+			"   you to show in the lab, and students redeem it with \"grind redeem\"\n" +
+			"   before it expires. Once a code has been issued for a problem set, the\n" +
+			"   problem set stays locked for each student until they redeem one. With\n" +
+			"   --credit, redeeming the code checks the student off instead (see\n" +
+			"   \"grind course checkoff\").\n\n" +
+			"   Example: grind course lab-code CS-1400 cs1400-lab3 --minutes 20",
+		Run: CommandCourseLabCode,
+	}
+	cmdCourseLabCode.Flags().Int("minutes", 15, "how long the code can be redeemed")
+	cmdCourseLabCode.Flags().Bool("credit", false, "check off students who redeem the code instead of unlocking the problem set")
+	cmdCourseLabCode.Flags().Bool("list", false, "list the codes issued for the problem set")
+	cmdCourseLabCode.Flags().String("revoke", "", "expire the code with this ID now")
+	requires(cmdCourseLabCode, "POST /courses/:course_id/problem_sets/:problem_set_id/lab_sessions")
+	cmdCourse.AddCommand(cmdCourseLabCode)
+
+	cmdCourseAnonymous := &cobra.Command{
+		Use:   "anonymous",
+		Short: "grade a problem set anonymously",
+		Long: "   Give the course label and the problem set. Until grading is finalized,\n" +
+			"   instructors see students only by pseudonym, name them by pseudonym to\n" +
+			"   grade them, and grades are not posted.\n\n" +
+			"   Example: grind course anonymous CS-1400 cs1400-project",
+		Run: CommandCourseAnonymous,
+	}
+	requires(cmdCourseAnonymous, "PUT /courses/:course_id/problem_sets/:problem_set_id/anonymous")
+	cmdCourse.AddCommand(cmdCourseAnonymous)
+
+	cmdCourseFinalize := &cobra.Command{
+		Use:   "finalize",
+		Short: "reveal identities on an anonymously graded problem set and post the grades",
+		Run:   CommandCourseFinalize,
+	}
+	addForceFlag(cmdCourseFinalize)
+	requires(cmdCourseFinalize, "POST /courses/:course_id/problem_sets/:problem_set_id/finalize")
+	cmdCourse.AddCommand(cmdCourseFinalize)
+
+	cmdCourseGrades := &cobra.Command{
+		Use:   "grades",
+		Short: "list student grades on a problem set",
+		Run:   CommandCourseGrades,
+	}
+	cmdCourseGrades.Flags().Bool("csv", false, "print the grades in CSV form")
+	cmdCourseGrades.Flags().StringP("section", "s", "", "only list students in this section (name or ID)")
+	requires(cmdCourseGrades, "GET /courses/:course_id/problem_sets/:problem_set_id/grades")
+	cmdCourse.AddCommand(cmdCourseGrades)
+
+	cmdCourseModerate := &cobra.Command{
+		Use:   "moderate",
+		Short: "choose a sample of students to have their grades checked by a second marker",
+		Long: "   Give the course label and the problem set. Run it again later to add\n" +
+			"   students who joined the course since the sample was chosen.\n\n" +
+			"   Example: grind course moderate CS-1400 cs1400-project --percent 20 --threshold 10 --marker ta@example.edu",
+		Run: CommandCourseModerate,
+	}
+	cmdCourseModerate.Flags().Float64P("percent", "p", 10.0, "percentage of students to sample")
+	cmdCourseModerate.Flags().Float64P("threshold", "t", 10.0, "flag grades that differ by more than this many percentage points")
+	cmdCourseModerate.Flags().StringP("marker", "m", "", "email address of the second marker (required)")
+	requires(cmdCourseModerate, "PUT /courses/:course_id/problem_sets/:problem_set_id/moderation")
+	cmdCourse.AddCommand(cmdCourseModerate)
+
+	cmdCourseMarks := &cobra.Command{
+		Use:   "marks",
+		Short: "list the students chosen for second marking and their status",
+		Run:   CommandCourseMarks,
+	}
+	requires(cmdCourseMarks, "GET /courses/:course_id/problem_sets/:problem_set_id/moderation_marks")
+	cmdCourse.AddCommand(cmdCourseMarks)
+
+	cmdCourseMark := &cobra.Command{
+		Use:   "mark",
+		Short: "record a second mark",
+		Long: "   Give the course label, the mark ID from \"grind course marks\", and the score\n" +
+			"   as a percentage.\n\n" +
+			"   Example: grind course mark CS-1400 17 85 --note \"missed the edge cases\"",
+		Run: CommandCourseMark,
+	}
+	cmdCourseMark.Flags().StringP("note", "n", "", "note explaining the mark")
+	requires(cmdCourseMark, "PUT /courses/:course_id/moderation_marks/:mark_id/second")
+	cmdCourse.AddCommand(cmdCourseMark)
+
+	cmdCourseReconcile := &cobra.Command{
+		Use:   "reconcile",
+		Short: "record the agreed grade for a second-marked student",
+		Long: "   Give the course label, the mark ID from \"grind course marks\", and the score\n" +
+			"   as a percentage. The score replaces the student's grade for the problem set.\n\n" +
+			"   Example: grind course reconcile CS-1400 17 88",
+		Run: CommandCourseReconcile,
+	}
+	cmdCourseReconcile.Flags().StringP("note", "n", "", "note explaining the decision")
+	requires(cmdCourseReconcile, "PUT /courses/:course_id/moderation_marks/:mark_id/reconcile")
+	cmdCourse.AddCommand(cmdCourseReconcile)
+
+	cmdCourseSections := &cobra.Command{
+		Use:   "sections",
+		Short: "list the sections of a course and their TAs",
+		Run:   CommandCourseSections,
+	}
+	requires(cmdCourseSections, "GET /courses/:course_id/sections")
+	cmdCourse.AddCommand(cmdCourseSections)
+
+	cmdCourseTA := &cobra.Command{
+		Use:   "ta",
+		Short: "attach a TA to a section",
+		Long: "   Give the course label, the section name or ID from \"grind course sections\",\n" +
+			"   and the email address of the TA.\n\n" +
+			"   Example: grind course ta CS-1400 \"CS-1400-002\" ta@example.edu",
+		Run: CommandCourseTA,
+	}
+	cmdCourseTA.Flags().Bool("remove", false, "remove the TA from the section instead")
+	requires(cmdCourseTA, "PUT /courses/:course_id/sections/:section_id/tas/:user_id")
+	cmdCourse.AddCommand(cmdCourseTA)
+
+	cmdCourseRoster := &cobra.Command{
+		Use:   "roster",
+		Short: "show how the course roster compares with the LMS",
+		Long: "   Give the course label. Students dropped from the LMS can no longer\n" +
+			"   submit work, but everything they have done is kept.\n\n" +
+			"   Example: grind course roster CS-1400 --sync",
+		Run: CommandCourseRoster,
+	}
+	cmdCourseRoster.Flags().Bool("sync", false, "compare with the LMS now instead of showing the last report")
+	requires(cmdCourseRoster, "GET /courses/:course_id/roster_syncs")
+	cmdCourse.AddCommand(cmdCourseRoster)
+
+	cmdCourseRequire := &cobra.Command{
+		Use:   "require",
+		Short: "lock a problem set until an earlier one is completed",
+		Long: "   Give the course label, the problem set to lock, and the problem set\n" +
+			"   that must be completed first. With no required problem set, the current\n" +
+			"   prerequisites are listed.\n\n" +
+			"   Example: grind course require CS-1400 cs1400-functions cs1400-loops --threshold 80",
+		Run: CommandCourseRequire,
+	}
+	cmdCourseRequire.Flags().StringP("threshold", "t", "100", "score needed on the required problem set, as a percentage")
+	cmdCourseRequire.Flags().Bool("remove", false, "remove the prerequisite instead")
+	requires(cmdCourseRequire, "GET /courses/:course_id/problem_sets/:problem_set_id/prerequisites")
+	cmdCourse.AddCommand(cmdCourseRequire)
+
+	cmdCourseFeature := &cobra.Command{
+		Use:   "feature",
+		Short: "turn a feature on or off for a course",
+		Long: "   Give the course label and the name of a feature. The course keeps its\n" +
+			"   own setting until it is reset, after which it follows the rollout set\n" +
+			"   by the administrators. With no feature, the features that are on for\n" +
+			"   the course are listed.\n\n" +
+			"   Example: grind course feature CS-1400 hints --off",
+		Run: CommandCourseFeature,
+	}
+	cmdCourseFeature.Flags().Bool("off", false, "turn the feature off for the course")
+	cmdCourseFeature.Flags().Bool("reset", false, "follow the rollout again instead of a course setting")
+	addForceFlag(cmdCourseFeature)
+	requires(cmdCourseFeature, "GET /courses/:course_id/feature_flags")
+	cmdCourse.AddCommand(cmdCourseFeature)
+
+	cmdCourseGitHub := &cobra.Command{
+		Use:   "github",
+		Short: "grade student repositories from a GitHub Classroom assignment",
+		Long: "   Give the course label, the problem set, and the GitHub Classroom\n" +
+			"   assignment ID along with the roster exported from GitHub Classroom to\n" +
+			"   link them. The roster identifiers must be student email addresses.\n" +
+			"   With --import, the latest commit in each student repository is graded,\n" +
+			"   and the result is posted to the LMS and as a commit status.\n" +
+			"   Problem sets with several problems need a directory for each problem.\n\n" +
+			"   Example: grind course github CS-1400 cs1400-lab3 123456 --roster classroom_roster.csv\n" +
+			"            grind course github CS-1400 cs1400-lab3 --import",
+		Run: CommandCourseGitHub,
+	}
+	cmdCourseGitHub.Flags().StringP("roster", "r", "", "roster CSV file exported from GitHub Classroom")
+	cmdCourseGitHub.Flags().Bool("import", false, "grade the latest commit in each student repository")
+	requires(cmdCourseGitHub, "PUT /courses/:course_id/problem_sets/:problem_set_id/github_classroom")
+	cmdCourse.AddCommand(cmdCourseGitHub)
+
+	cmdCourseJobs := &cobra.Command{
+		Use:   "jobs",
+		Short: "list or stop grading jobs running for students in a course",
+		Long: "   Give the course label. Each running job is listed with its ID, which\n" +
+			"   can be given to --kill to stop it. The student sees that the job was\n" +
+			"   stopped by an instructor.\n\n" +
+			"   Example: grind course jobs CS-1400 --kill 1234",
+		Run: CommandCourseJobs,
+	}
+	cmdCourseJobs.Flags().StringP("kill", "k", "", "ID of the job to stop")
+	addForceFlag(cmdCourseJobs)
+	requires(cmdCourseJobs, "GET /courses/:course_id/jobs")
+	cmdCourse.AddCommand(cmdCourseJobs)
+
+	cmdCourseSuspend := &cobra.Command{
+		Use:   "suspend",
+		Short: "stop a student from having work graded for a while",
+		Long: "   Give the course label and the student's email address. The student\n" +
+			"   can still save work, but grading requests are refused with a message\n" +
+			"   saying when the suspension ends and why. With no student, the current\n" +
+			"   suspensions are listed.\n\n" +
+			"   Example: grind course suspend CS-1400 student@example.edu --for 2h \\\n" +
+			"       --reason \"your program keeps filling the disk; come see me\"",
+		Run: CommandCourseSuspend,
+	}
+	cmdCourseSuspend.Flags().String("for", "24h", "how long the suspension lasts")
+	cmdCourseSuspend.Flags().StringP("reason", "r", "", "reason shown to the student")
+	cmdCourseSuspend.Flags().Bool("lift", false, "end the suspension now")
+	requires(cmdCourseSuspend, "GET /courses/:course_id/suspensions")
+	cmdCourse.AddCommand(cmdCourseSuspend)
+
+	cmdCourseAnnounce := &cobra.Command{
+		Use:   "announce",
+		Short: "post an announcement to the students in a course",
+		Long: "   Give the course label and the message. Students see the announcement\n" +
+			"   in \"grind list\", \"grind status\", and on the assignment page until\n" +
+			"   they acknowledge it. Use --problem-set to show it only to students\n" +
+			"   assigned that problem set. With no message, the announcements already\n" +
+			"   posted are listed with how many students have acknowledged each one.\n\n" +
+			"   Example: grind course announce CS-1400 --problem-set cs1400-loops \\\n" +
+			"       \"test 5 had a bug and has been regraded; resubmit if you want\"",
+		Run: CommandCourseAnnounce,
+	}
+	cmdCourseAnnounce.Flags().StringP("problem-set", "p", "", "only announce to students assigned this problem set")
+	cmdCourseAnnounce.Flags().Int64("remove", 0, "withdraw the announcement with this ID")
+	requires(cmdCourseAnnounce, "POST /courses/:course_id/announcements")
+	cmdCourse.AddCommand(cmdCourseAnnounce)
+
+	cmdCourseEmail := &cobra.Command{
+		Use:   "email",
+		Short: "review work students sent by email",
+		Long: "   Give the course label to list work sent to the email gateway, with\n" +
+			"   pending submissions first. Approved work is graded as though it had\n" +
+			"   been submitted when the message arrived.\n\n" +
+			"   Example: grind course email CS-1400 --approve 17",
+		Run: CommandCourseEmail,
+	}
+	cmdCourseEmail.Flags().Int64("approve", 0, "approve the email submission with this ID for grading")
+	cmdCourseEmail.Flags().Int64("reject", 0, "reject the email submission with this ID")
+	cmdCourseEmail.Flags().StringP("reason", "r", "", "reason for a rejection, shown to the student")
+	requires(cmdCourseEmail, "GET /courses/:course_id/email_submissions")
+	cmdCourse.AddCommand(cmdCourseEmail)
+
+	cmdCourseUsage := &cobra.Command{
+		Use:   "usage",
+		Short: "show the grading time, storage, and office hours a course has used",
+		Long: "   Give the course label. Grading time is the time spent running student\n" +
+			"   work in containers, and storage is the space taken by saved work. If an\n" +
+			"   administrator has set a quota for the course, a warning is given as it\n" +
+			"   runs low; once it is used up, students cannot have work graded until\n" +
+			"   it is raised. Use of the office-hours queue is summarized too. Use\n" +
+			"   --from and --to to count grading and help requests on certain days.\n\n" +
+			"   Example: grind course usage CS-1400 --from 2026-09-01 --to 2026-10-01",
+		Run: CommandCourseUsage,
+	}
+	cmdCourseUsage.Flags().String("from", "", "first day to count (YYYY-MM-DD)")
+	cmdCourseUsage.Flags().String("to", "", "day to stop counting, not included (YYYY-MM-DD)")
+	requires(cmdCourseUsage, "GET /courses/:course_id/usage")
+	cmdCourse.AddCommand(cmdCourseUsage)
+
+	cmdCourseAtRisk := &cobra.Command{
+		Use:   "at-risk",
+		Short: "list students who may be falling behind",
+		Long: fmt.Sprintf("   Give the course label. A student is listed if they have not started an\n"+
+			"   open assignment within %d days, have failed one step %d or more times\n"+
+			"   in the last %d days without passing it, or have saved nothing for %d\n"+
+			"   days while work is still open. Use --digest on to have the list\n"+
+			"   emailed to you each week, if the server is set up to send email.\n\n"+
+			"   Example: grind course at-risk CS-1400 --digest on",
+			RiskIdleDays, RiskFailedAttempts, RiskWindowDays, RiskInactiveDays),
+		Run: CommandCourseAtRisk,
+	}
+	cmdCourseAtRisk.Flags().String("digest", "", "on or off to start or stop a weekly email of this list")
+	requires(cmdCourseAtRisk, "GET /courses/:course_id/at_risk")
+	requiresWithFlag(cmdCourseAtRisk, "digest", "PUT /courses/:course_id/at_risk/digest")
+	cmdCourse.AddCommand(cmdCourseAtRisk)
+
+	cmdAuthor := &cobra.Command{
+		Use:   "author",
+		Short: "problem authoring commands (authors only)",
+	}
+	cmdGrind.AddCommand(cmdAuthor)
+
+	cmdAuthorCompat := &cobra.Command{
+		Use:   "compat",
+		Short: "report problems whose reference solutions fail with the current toolchains",
+		Long: "   The server re-runs the reference solution for every problem when the\n" +
+			"   toolchain for its problem type changes. This lists problems whose\n" +
+			"   solutions failed their most recent check.\n\n" +
+			"   Give a problem's unique ID to see its full validation history.",
+		Run: CommandAuthorCompat,
+	}
+	cmdAuthorCompat.Flags().BoolP("all", "a", false, "include problems that passed")
+	requires(cmdAuthorCompat, "GET /problem_compatibility")
+	cmdAuthor.AddCommand(cmdAuthorCompat)
+
+	cmdAuthorCanary := &cobra.Command{
+		Use:   "canary",
+		Short: "report problems whose reference solutions failed the nightly regrade",
+		Long: "   Every night the server regrades the reference solution for every\n" +
+			"   problem in an active course on each daycare, so a change to the\n" +
+			"   grading environment is caught before students run into it. This\n" +
+			"   lists the problems that failed on any daycare in the latest run.",
+		Run: CommandAuthorCanary,
+	}
+	cmdAuthorCanary.Flags().BoolP("all", "a", false, "include problems that passed")
+	requires(cmdAuthorCanary, "GET /canary_results")
+	cmdAuthor.AddCommand(cmdAuthorCanary)
+
+	cmdAuthorCosts := &cobra.Command{
+		Use:   "costs",
+		Short: "report what grading each problem costs in runtime, CPU, and memory",
+		Long: "   Lists the average container runtime, CPU time, and memory\n" +
+			"   high-water mark per grading run for each problem, most CPU time\n" +
+			"   first. The most expensive problems are marked; their test suites\n" +
+			"   are the best candidates for optimization. Runtime, CPU, and memory\n" +
+			"   are averages per run; peak is the most memory any run used.",
+		Run: CommandAuthorCosts,
+	}
+	cmdAuthorCosts.Flags().StringP("term", "t", "", "only count courses in this term (default: every term)")
+	requires(cmdAuthorCosts, "GET /problem_costs")
+	cmdAuthor.AddCommand(cmdAuthorCosts)
+
+	cmdAuthorPath := &cobra.Command{
+		Use:   "path",
+		Short: "send students down a remedial or advanced path based on a diagnostic problem",
+		Long: "   Give the problem set and the diagnostic problem, which must already be\n" +
+			"   in the set. Students who reach the threshold on the diagnostic problem\n" +
+			"   get the advanced problems; everyone else gets the remedial problems.\n" +
+			"   Problems on neither list are given to every student.\n\n" +
+			"   Example: grind author path cs1400-loops loops-diagnostic --threshold 70 \\\n" +
+			"       --remedial loops-review,loops-practice --advanced loops-nested",
+		Run: CommandAuthorPath,
+	}
+	cmdAuthorPath.Flags().StringP("threshold", "t", "70", "diagnostic score needed for the advanced path, as a percentage")
+	cmdAuthorPath.Flags().StringSlice("remedial", nil, "problems for students below the threshold")
+	cmdAuthorPath.Flags().StringSlice("advanced", nil, "problems for students at or above the threshold")
+	cmdAuthorPath.Flags().Bool("remove", false, "give every problem in the set to every student again")
+	requires(cmdAuthorPath, "PUT /problem_sets/:problem_set_id/path")
+	cmdAuthor.AddCommand(cmdAuthorPath)
+
+	cmdAuthorEnv := &cobra.Command{
+		Use:   "env",
+		Short: "set environment variables and secrets for grading runs of a problem",
+		Long: "   Give the problem's unique ID and NAME=value. With --secret, the value\n" +
+			"   is stored encrypted and left out of downloads. It is not put in the\n" +
+			"   environment; only actions marked secrets get it, for the grader to\n" +
+			"   read before student code runs. Leave off =value to type a secret\n" +
+			"   instead of putting it on the command line.\n" +
+			"   With no variable, the variables for the problem are listed.\n\n" +
+			"   Example: grind author env weather-api WEATHER_API_KEY --secret",
+		Run: CommandAuthorEnv,
+	}
+	cmdAuthorEnv.Flags().Bool("secret", false, "store the value encrypted and hide it from students")
+	cmdAuthorEnv.Flags().Bool("remove", false, "remove the variable")
+	requires(cmdAuthorEnv, "GET /problems/:problem_id/variables")
+	cmdAuthor.AddCommand(cmdAuthorEnv)
+
+	cmdAuthorMatrix := &cobra.Command{
+		Use:   "matrix",
+		Short: "grade a problem against several toolchain versions at once",
+		Long: "   Give the problem's unique ID and one or more LABEL=IMAGE pairs, each\n" +
+			"   naming a container image with a different version of the interpreter\n" +
+			"   or compiler. Once a problem has a matrix, every submission is graded\n" +
+			"   against all of the images in parallel instead of the problem type's\n" +
+			"   usual image, and it passes only if it passes on every one. The report\n" +
+			"   card shows the results for each version. With --remove, give only the\n" +
+			"   labels to drop. With no pairs, the matrix for the problem is listed.\n\n" +
+			"   Example: grind author matrix hello-world py3.8=codegrinder/python:3.8 py3.12=codegrinder/python:3.12",
+		Run: CommandAuthorMatrix,
+	}
+	cmdAuthorMatrix.Flags().Bool("remove", false, "remove the given labels from the matrix")
+	requires(cmdAuthorMatrix, "GET /problems/:problem_id/toolchain_variants")
+	cmdAuthor.AddCommand(cmdAuthorMatrix)
+
+	cmdAuthorImport := &cobra.Command{
+		Use:   "import",
+		Short: "let a problem use files the student wrote for an earlier problem",
+		Long: "   Give the problem set, the problem, the earlier problem, and the files to\n" +
+			"   take from it. When the problem is graded, the student's files from\n" +
+			"   their passing work on the last step of the earlier problem are added.\n" +
+			"   Students must pass the earlier problem before the later one can be\n" +
+			"   graded. With only the problem set, the imports in the set are listed.\n\n" +
+			"   Example: grind author import cs1400-lists lists-search lists-core listlib.py",
+		Run: CommandAuthorImport,
+	}
+	cmdAuthorImport.Flags().Bool("remove", false, "stop the problem from using files from another problem")
+	requires(cmdAuthorImport, "GET /problem_sets/:problem_set_id/imports")
+	cmdAuthor.AddCommand(cmdAuthorImport)
+
+	cmdAuthorReflect := &cobra.Command{
+		Use:   "reflect",
+		Short: "ask students questions about their work on a problem",
+		Long: "   Give the problem's unique ID, a name for the question, and the\n" +
+			"   question itself. Students must answer it before the last step can be\n" +
+			"   graded, or with --after, they are asked once they pass the problem.\n" +
+			"   With no name, the questions for the problem are listed.\n\n" +
+			"   Example: grind author reflect loops-sum time --question \"About how many hours did this take?\"",
+		Run: CommandAuthorReflect,
+	}
+	cmdAuthorReflect.Flags().String("question", "", "the question to ask")
+	cmdAuthorReflect.Flags().Bool("after", false, "ask once the student passes instead of before grading")
+	cmdAuthorReflect.Flags().Bool("remove", false, "remove the question")
+	requires(cmdAuthorReflect, "GET /problems/:problem_id/reflections")
+	cmdAuthor.AddCommand(cmdAuthorReflect)
+
+	cmdAuthorFeedback := &cobra.Command{
+		Use:   "feedback",
+		Short: "explain common misconceptions when students' code or output shows them",
+		Long: "   Give the problem's unique ID, a name for the rule, a regular expression\n" +
+			"   to look for, and the feedback to give. With --code, the pattern is\n" +
+			"   matched against the student's files; with --output, against the\n" +
+			"   report card and output of a submission that did not pass. Matching\n" +
+			"   rules add their feedback to the report card. Rules are listed with\n" +
+			"   how often each has matched, most common first. With no name, the\n" +
+			"   rules for the problem are listed.\n\n" +
+			"   Example: grind author feedback loops-sum skips-first --code 'range\\(1, *len' \\\n" +
+			"       --files '*.py' --message \"range(1, len(x)) skips the first element\"",
+		Run: CommandAuthorFeedback,
+	}
+	cmdAuthorFeedback.Flags().String("code", "", "regular expression to match in the student's files")
+	cmdAuthorFeedback.Flags().String("output", "", "regular expression to match in failing output")
+	cmdAuthorFeedback.Flags().String("files", "", "only match code in files matching this pattern")
+	cmdAuthorFeedback.Flags().String("message", "", "the feedback to give")
+	cmdAuthorFeedback.Flags().String("step", "0", "only match this step (default: every step)")
+	cmdAuthorFeedback.Flags().Bool("remove", false, "remove the rule")
+	requires(cmdAuthorFeedback, "GET /problems/:problem_id/feedback_rules")
+	cmdAuthor.AddCommand(cmdAuthorFeedback)
+
+	cmdAuthorRecord := &cobra.Command{
+		Use:   "record",
+		Short: "record golden output files by running the reference solution",
+		Long: "   Run from a problem directory. Each input in the step's tests directory\n" +
+			"   (tests/NAME.in) is fed to the reference solution on stdin inside the\n" +
+			"   problem type's image, and the output is saved as tests/NAME.out after\n" +
+			"   you review how it differs from the current file. Give input names to\n" +
+			"   record only those. Docker must be installed.\n\n" +
+			"   Example: grind author record --step 2 --command \"python3 main.py\" empty-list",
+		Run: CommandAuthorRecord,
+	}
+	cmdAuthorRecord.Flags().StringP("command", "c", "", "command that runs the reference solution")
+	cmdAuthorRecord.Flags().StringP("step", "s", "1", "step whose tests are recorded")
+	cmdAuthorRecord.Flags().BoolP("yes", "y", false, "keep every new output without asking")
+	cmdAuthor.AddCommand(cmdAuthorRecord)
+
+	cmdAdmin := &cobra.Command{
+		Use:   "admin",
+		Short: "server administration commands",
+	}
+	cmdGrind.AddCommand(cmdAdmin)
+
+	cmdAdminHealth := &cobra.Command{
+		Use:   "health",
+		Short: "show a summary of server health checks",
+		Run:   CommandAdminHealth,
+	}
+	cmdAdmin.AddCommand(cmdAdminHealth)
+
+	cmdAdminReload := &cobra.Command{
+		Use:   "reload",
+		Short: "reload the server config file without restarting",
+		Long: "   Settings such as LTI secrets, tool names, the daycare host, and problem\n" +
+			"   type definitions take effect right away. Other changed settings are\n" +
+			"   listed and take effect the next time the server restarts.\n\n" +
+			"   Sending the server a SIGHUP does the same thing.",
+		Run: CommandAdminReload,
+	}
+	requires(cmdAdminReload, "POST /config/reload")
+	cmdAdmin.AddCommand(cmdAdminReload)
+
+	cmdAdminFeature := &cobra.Command{
+		Use:   "feature",
+		Short: "create a feature flag or change its rollout",
+		Long: "   Give the name of the feature and the percentage of courses that should\n" +
+			"   have it. Courses are chosen the same way every time, so raising the\n" +
+			"   percentage only adds courses. Instructors can turn a feature on or off\n" +
+			"   for their own course with \"grind course feature\". With no feature,\n" +
+			"   every feature flag is listed.\n\n" +
+			"   Example: grind admin feature hints --percent 25",
+		Run: CommandAdminFeature,
+	}
+	cmdAdminFeature.Flags().Float64P("percent", "p", 0.0, "percentage of courses with the feature turned on")
+	cmdAdminFeature.Flags().StringP("description", "d", "", "what the feature does")
+	cmdAdminFeature.Flags().Bool("remove", false, "remove the feature flag from every course")
+	requires(cmdAdminFeature, "GET /feature_flags")
+	cmdAdmin.AddCommand(cmdAdminFeature)
+
+	cmdAdminJobs := &cobra.Command{
+		Use:   "jobs",
+		Short: "list or stop grading jobs running on the daycare",
+		Long: "   Each running job is listed with its ID, which can be given to --kill\n" +
+			"   to stop it.\n\n" +
+			"   Example: grind admin jobs --kill 1234",
+		Run: CommandAdminJobs,
+	}
+	cmdAdminJobs.Flags().StringP("kill", "k", "", "ID of the job to stop")
+	addForceFlag(cmdAdminJobs)
+	requires(cmdAdminJobs, "GET /jobs")
+	cmdAdmin.AddCommand(cmdAdminJobs)
+
+	cmdAdminQuota := &cobra.Command{
+		Use:   "quota",
+		Short: "limit the grading time and storage a course can use",
+		Long: "   Give the course label and at least one limit. A limit of zero means no\n" +
+			"   limit. Instructors are warned when the course has used 80% of a quota.\n" +
+			"   Once it is used up, students can still save work but cannot have it\n" +
+			"   graded until the quota is raised.\n\n" +
+			"   Example: grind admin quota CS-1400 --minutes 6000 --storage 2048",
+		Run: CommandAdminQuota,
+	}
+	cmdAdminQuota.Flags().String("minutes", "0", "grading minutes the course can use")
+	cmdAdminQuota.Flags().String("storage", "0", "megabytes of saved work the course can keep")
+	cmdAdminQuota.Flags().Bool("remove", false, "remove the limits")
+	requires(cmdAdminQuota, "PUT /courses/:course_id/quota")
+	cmdAdmin.AddCommand(cmdAdminQuota)
+
+	cmdAdminUsage := &cobra.Command{
+		Use:   "usage",
+		Short: "report the grading time and storage used by every course",
+		Long: "   Courses that have used nothing are left out. Use --from and --to to\n" +
+			"   count grading on certain days, and --csv for a spreadsheet to charge\n" +
+			"   compute costs back to departments.\n\n" +
+			"   Example: grind admin usage --from 2026-09-01 --to 2026-10-01 --csv > september.csv",
+		Run: CommandAdminUsage,
+	}
+	cmdAdminUsage.Flags().String("from", "", "first day to count (YYYY-MM-DD)")
+	cmdAdminUsage.Flags().String("to", "", "day to stop counting, not included (YYYY-MM-DD)")
+	cmdAdminUsage.Flags().Bool("csv", false, "write every course as CSV")
+	requires(cmdAdminUsage, "GET /usage")
+	cmdAdmin.AddCommand(cmdAdminUsage)
+
+	cmdAdminMaintenance := &cobra.Command{
+		Use:   "maintenance [message]",
+		Short: "announce a maintenance window to users",
+		Long: "   Give the window in local time and a message. Every grind command shows\n" +
+			"   the notice from now until the window ends, and if the server cannot be\n" +
+			"   reached during the window, grind explains that it is down for\n" +
+			"   maintenance. With no message, show the current notice.\n\n" +
+			"   Example: grind admin maintenance --start \"2026-12-20 22:00\" \\\n" +
+			"       --end \"2026-12-21 02:00\" upgrading the database server",
+		Run: CommandAdminMaintenance,
+	}
+	cmdAdminMaintenance.Flags().String("start", "", "when the window starts (YYYY-MM-DD HH:MM)")
+	cmdAdminMaintenance.Flags().String("end", "", "when the window ends (YYYY-MM-DD HH:MM)")
+	cmdAdminMaintenance.Flags().Bool("remove", false, "remove the notice")
+	requires(cmdAdminMaintenance, "PUT /maintenance")
+	cmdAdmin.AddCommand(cmdAdminMaintenance)
+
+	if err := cmdGrind.Execute(); err != nil {
+		os.Exit(exitUsage)
+	}
 }
 
 func CommandInit(cmd *cobra.Command, args []string) {
+	// keep the other settings from an earlier login
+	mustReadConfigFile(mustFindConfigFile())
+	applyHostFlags(cmd, true)
+	applySchoolFlag(cmd)
+
 	fmt.Println(
 		`Please follow these steps:
 
 1.  Use Canvas to load a CodeGrinder window
 2.  Open a new tab in your browser and copy this URL into the address bar:
 
-    https://` + defaultHost + `/v2/users/me/cookie
+    https://` + Config.Host + `/v2/users/me/cookie
 
 3.  The browser will display something of the form: ` + CookieName + `=...
 4.  Copy that entire string to the clipboard and paste it below.
@@ -134,18 +1342,17 @@ Paste here: `)
 	var cookie string
 	n, err := fmt.Scanln(&cookie)
 	if err != nil {
-		log.Fatalf("error encountered while reading the cookie you pasted: %v\n", err)
+		fatalf(exitUsage, "error encountered while reading the cookie you pasted: %v\n", err)
 	}
 	if n != 1 {
-		log.Fatalf("failed to read the cookie you pasted; please try again\n")
+		fatalf(exitUsage, "failed to read the cookie you pasted; please try again\n")
 	}
 	if !strings.HasPrefix(cookie, CookieName+"=") {
-		log.Fatalf("the cookie must start with %s=; perhaps you copied the wrong thing?\n", CookieName)
+		fatalf(exitUsage, "the cookie must start with %s=; perhaps you copied the wrong thing?\n", CookieName)
 	}
 
 	// set up config
 	Config.Cookie = cookie
-	Config.Host = defaultHost
 
 	// see if they need an upgrade
 	checkVersion()
@@ -154,6 +1361,10 @@ Paste here: `)
 	user := new(User)
 	mustGetObject("/users/me", nil, user)
 
+	// pin the key grade reports are signed with, so a report cannot
+	// later be checked against a key the server was made to hand out
+	pinReportKey()
+
 	// save config for later use
 	mustWriteConfig()
 
@@ -176,6 +1387,48 @@ func mustPutObject(path string, params map[string]string, upload interface{}, do
 	doRequest(path, params, "PUT", upload, download, false)
 }
 
+// listPageSize is how many items grind asks for at a time from list endpoints.
+const listPageSize = 500
+
+// nextCursor is the cursor for the next page given by the most recent response, if any.
+var nextCursor string
+
+// mustGetList gets a list that the server may return a page at a time,
+// following the cursor in each response until the whole list has been gathered.
+func mustGetList(path string, params map[string]string, download interface{}) {
+	getList(path, params, download, false)
+}
+
+func getList(path string, params map[string]string, download interface{}, notfoundokay bool) bool {
+	paged := map[string]string{"limit": strconv.Itoa(listPageSize)}
+	for key, value := range params {
+		paged[key] = value
+	}
+	var items []json.RawMessage
+	for {
+		page := []json.RawMessage{}
+		if !doRequest(path, paged, "GET", nil, &page, notfoundokay) {
+			return false
+		}
+		items = append(items, page...)
+		if nextCursor == "" {
+			break
+		}
+		paged["cursor"] = nextCursor
+	}
+	if items == nil {
+		items = []json.RawMessage{}
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		fatalf(exitUsage, "getList: JSON error encoding list: %v", err)
+	}
+	if err := json.Unmarshal(raw, download); err != nil {
+		fatalf(exitServer, "failed to parse result list from server: %v\n", err)
+	}
+	return true
+}
+
 func doRequest(path string, params map[string]string, method string, upload interface{}, download interface{}, notfoundokay bool) bool {
 	if !strings.HasPrefix(path, "/") {
 		log.Panicf("doRequest path must start with /")
@@ -186,7 +1439,7 @@ func doRequest(path string, params map[string]string, method string, upload inte
 	url := fmt.Sprintf("https://%s/v2%s", Config.Host, path)
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		log.Fatalf("error creating http request: %v\n", err)
+		fatalf(exitUsage, "error creating http request: %v\n", err)
 	}
 
 	// add any parameters
@@ -205,13 +1458,14 @@ func doRequest(path string, params map[string]string, method string, upload inte
 	// set the headers
 	req.Header["Accept"] = []string{"application/json"}
 	req.Header["Cookie"] = []string{Config.Cookie}
+	req.Header.Set(TraceParentHeader, traceParent)
 
 	// upload the payload if any
 	if upload != nil && (method == "POST" || method == "PUT") {
 		req.Header["Content-Type"] = []string{"application/json"}
 		payload, err := json.MarshalIndent(upload, "", "    ")
 		if err != nil {
-			log.Fatalf("doRequest: JSON error encoding object to upload: %v", err)
+			fatalf(exitUsage, "doRequest: JSON error encoding object to upload: %v", err)
 		}
 		req.Body = ioutil.NopCloser(bytes.NewReader(payload))
 
@@ -220,31 +1474,43 @@ func doRequest(path string, params map[string]string, method string, upload inte
 		}
 	}
 
+	sent := time.Now()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Fatalf("error connecting to %s: %v\n", Config.Host, err)
+		mustReportNetworkError(err)
 	}
 	defer resp.Body.Close()
+	noteServerTime(resp, sent, time.Now())
+	noteMaintenance(resp)
+	nextCursor = resp.Header.Get(NextCursorHeader)
 	if notfoundokay && resp.StatusCode == http.StatusNotFound {
 		return false
 	}
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("unexpected status from %s: %s\n", url, resp.Status)
-		io.Copy(os.Stderr, resp.Body)
-		log.Fatalf("giving up")
+		mustReportAPIError(url, resp)
+	}
+
+	// raw downloads are returned as is
+	if raw, ok := download.(*[]byte); ok {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			mustReportNetworkError(err)
+		}
+		*raw = body
+		return true
 	}
 
 	// parse the result if any
 	if download != nil {
 		decoder := json.NewDecoder(resp.Body)
 		if err := decoder.Decode(download); err != nil {
-			log.Fatalf("failed to parse result object from server: %v\n", err)
+			fatalf(exitServer, "failed to parse result object from server: %v\n", err)
 		}
 
 		if Config.apiDump {
 			raw, err := json.MarshalIndent(download, "", "    ")
 			if err != nil {
-				log.Fatalf("doRequest: JSON error encoding downloaded object: %v", err)
+				fatalf(exitUsage, "doRequest: JSON error encoding downloaded object: %v", err)
 			}
 			log.Printf("Response data: %s", raw)
 		}
@@ -255,21 +1521,26 @@ func doRequest(path string, params map[string]string, method string, upload inte
 }
 
 func mustLoadConfig(cmd *cobra.Command) {
-	home := os.Getenv("HOME")
-	if home == "" {
-		home = os.Getenv("USERPROFILE")
-	}
-	if home == "" {
-		log.Fatalf("Unable to locate home directory, giving up\n")
-	}
-	configFile := filepath.Join(home, perUserDotFile)
-
-	if raw, err := ioutil.ReadFile(configFile); err != nil {
-		log.Fatalf("Unable to load config file; try running \"grind init\"\n")
-	} else if err := json.Unmarshal(raw, &Config); err != nil {
-		log.Printf("failed to parse %s: %v", configFile, err)
-		log.Fatalf("you may wish to try deleting the file and running \"grind init\" again\n")
+	if token := os.Getenv(tokenVariable); token != "" {
+		// CI jobs have no config file, so the cookie comes from the environment
+		if !strings.HasPrefix(token, CookieName+"=") {
+			token = CookieName + "=" + token
+		}
+		Config.Cookie = token
+		Config.Host = os.Getenv(hostVariable)
+		if Config.Host == "" {
+			Config.Host = defaultHost
+		}
+	} else {
+		if mustReadConfigFile(mustFindConfigFile()) {
+			Config.fromFile = true
+		} else if os.Getenv(cookieOverrideVariable) == "" {
+			errorLog.Printf("Unable to load config file; try running \"grind init\"")
+			fatalf(exitAuth, "  or set %s to the cookie from \"grind init\" when running without a terminal\n", tokenVariable)
+		}
+		applyConfigEnvironment()
 	}
+	applyHostFlags(cmd, false)
 	if cmd.Flag("api").Value.String() == "true" {
 		Config.apiReport = true
 	}
@@ -277,28 +1548,34 @@ func mustLoadConfig(cmd *cobra.Command) {
 		Config.apiReport = true
 		Config.apiDump = true
 	}
+	setQuiet(cmd)
 
 	checkVersion()
+	checkCapabilities(cmd)
 }
 
 func mustWriteConfig() {
-	home := os.Getenv("HOME")
-	if home == "" {
-		home = os.Getenv("USERPROFILE")
-	}
-	if home == "" {
-		log.Fatalf("Unable to locate home directory, giving up\n")
-	}
-	configFile := filepath.Join(home, perUserDotFile)
+	configFile := mustFindConfigFile()
 
-	raw, err := json.MarshalIndent(&Config, "", "    ")
+	// settings overridden by the environment keep their values from the file
+	saved := Config
+	for _, key := range configKeys {
+		if value, exists := Config.fileValues[key.name]; exists {
+			*key.field(&saved) = value
+		}
+	}
+	storeCookie(&saved)
+	raw, err := json.MarshalIndent(&saved, "", "    ")
 	if err != nil {
-		log.Fatalf("JSON error encoding cookie file: %v", err)
+		fatalf(exitUsage, "JSON error encoding cookie file: %v", err)
 	}
 	raw = append(raw, '\n')
 
-	if err = ioutil.WriteFile(configFile, raw, 0644); err != nil {
-		log.Fatalf("error writing %s: %v", configFile, err)
+	if err := os.MkdirAll(filepath.Dir(configFile), 0700); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(configFile), err)
+	}
+	if err = ioutil.WriteFile(configFile, raw, 0600); err != nil {
+		fatalf(exitUsage, "error writing %s: %v", configFile, err)
 	}
 }
 
@@ -315,8 +1592,8 @@ func checkVersion() {
 	grindCurrent := semver.MustParse(CurrentVersion.Version)
 	grindRequired := semver.MustParse(server.GrindVersionRequired)
 	if grindRequired.GT(grindCurrent) {
-		log.Printf("this is grind version %s, but the server requires %s or higher", CurrentVersion.Version, server.GrindVersionRequired)
-		log.Fatalf("  you must upgrade to continue")
+		errorLog.Printf("this is grind version %s, but the server requires %s or higher", CurrentVersion.Version, server.GrindVersionRequired)
+		fatalf(exitUsage, "  you must upgrade to continue")
 	}
 	grindRecommended := semver.MustParse(server.GrindVersionRecommended)
 	if grindRecommended.GT(grindCurrent) {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+var maintenanceShown bool
+
+// noteMaintenance shows the maintenance notice in a response once per run, and remembers
+// it so that when the server is down for maintenance the failure can be explained.
+func noteMaintenance(resp *http.Response) {
+	var notice *MaintenanceNotice
+	if header := resp.Header.Get(MaintenanceHeader); header != "" {
+		var err error
+		if notice, err = ParseMaintenanceHeader(header); err != nil {
+			return
+		}
+		if !maintenanceShown {
+			maintenanceShown = true
+			errorLog.Printf("notice: %s", notice.Banner(serverNow()))
+		}
+	}
+
+	old := Config.Maintenance
+	changed := (old == nil) != (notice == nil) ||
+		old != nil && (old.Message != notice.Message || !old.StartsAt.Equal(notice.StartsAt) || !old.EndsAt.Equal(notice.EndsAt))
+	if changed && Config.fromFile {
+		Config.Maintenance = notice
+		mustWriteConfig()
+	}
+}
+
+// explainMaintenance reports the maintenance that is probably why the server
+// cannot be reached, returning false if none is expected now.
+func explainMaintenance() bool {
+	notice := Config.Maintenance
+	if notice == nil {
+		return false
+	}
+	// allow for maintenance that starts early or runs a little late
+	now := time.Now()
+	if now.Before(notice.StartsAt.Add(-time.Hour)) || now.After(notice.EndsAt.Add(time.Hour)) {
+		return false
+	}
+	errorLog.Printf("the server is probably down for scheduled maintenance")
+	errorLog.Printf("  %s", notice.Banner(now))
+	return true
+}
+
+func CommandAdminMaintenance(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if cmd.Flag("remove").Value.String() == "true" {
+		if len(args) != 0 {
+			usage(cmd)
+		}
+		doRequest("/maintenance", nil, "DELETE", nil, nil, false)
+		log.Printf("maintenance notice removed")
+		return
+	}
+	if len(args) == 0 {
+		var notice *MaintenanceNotice
+		mustGetObject("/maintenance", nil, &notice)
+		if notice == nil {
+			fmt.Println("no maintenance is scheduled")
+			return
+		}
+		fmt.Println(notice.Banner(serverNow()))
+		return
+	}
+
+	notice := &MaintenanceNotice{Message: strings.Join(args, " ")}
+	var err error
+	if notice.StartsAt, err = time.ParseInLocation("2006-01-02 15:04", cmd.Flag("start").Value.String(), time.Local); err != nil {
+		fatalf(exitUsage, "start must be a local time in the form \"YYYY-MM-DD HH:MM\"")
+	}
+	if notice.EndsAt, err = time.ParseInLocation("2006-01-02 15:04", cmd.Flag("end").Value.String(), time.Local); err != nil {
+		fatalf(exitUsage, "end must be a local time in the form \"YYYY-MM-DD HH:MM\"")
+	}
+	saved := new(MaintenanceNotice)
+	mustPutObject("/maintenance", nil, notice, saved)
+	fmt.Println(saved.Banner(serverNow()))
+}
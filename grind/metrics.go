@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// reportMetrics summarizes the size and complexity of a graded submission.
+func reportMetrics(commit *Commit) {
+	if commit.ReportCard == nil || commit.ReportCard.Metrics == nil {
+		return
+	}
+	m := commit.ReportCard.Metrics
+	log.Printf("  metrics: %d lines of code in %d function%s, longest %d lines, most complex %d",
+		m.CodeLines, len(m.Functions), plural(len(m.Functions)), m.MaxLength, m.MaxComplexity)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandQueue(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	requests := []*HelpRequest{}
+	mustGetObject("/users/me/help_requests", nil, &requests)
+	if len(requests) == 0 {
+		fmt.Println("you are not waiting for help; use \"grind queue join\" to ask for it")
+		return
+	}
+	for _, elt := range requests {
+		printHelpRequest(elt)
+	}
+}
+
+func CommandQueueJoin(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	dir := "."
+	switch len(args) {
+	case 1:
+	case 2:
+		dir = args[1]
+	default:
+		usage(cmd)
+	}
+
+	// save the work so whoever helps can see it
+	request := &HelpRequest{Message: args[0]}
+	dotfile, _, problemDir := findDotFile(dir)
+	if problemDir != "" || len(dotfile.Problems) == 1 {
+		problem, _, commit, df := gather(now, dir)
+		mustSaveCommit(problemDirectory(df, df.Dir, problem.Unique), df, problem, commit, cmd.Flag("conflict").Value.String())
+		request.ProblemID = problem.ID
+	}
+
+	saved := new(HelpRequest)
+	mustPostObject(fmt.Sprintf("/assignments/%d/help_requests", dotfile.AssignmentID), nil, request, saved)
+	printHelpRequest(saved)
+}
+
+func CommandQueueLeave(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	requests := []*HelpRequest{}
+	mustGetObject("/users/me/help_requests", nil, &requests)
+	if len(requests) == 0 {
+		fmt.Println("you are not waiting for help")
+		return
+	}
+	for _, elt := range requests {
+		doRequest(fmt.Sprintf("/users/me/help_requests/%d", elt.ID), nil, "DELETE", nil, nil, false)
+		log.Printf("left the queue: %s", elt.Message)
+	}
+}
+
+func CommandQueueList(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	requests := []*HelpRequest{}
+	mustGetObject(fmt.Sprintf("/courses/%d/help_requests", course.ID), nil, &requests)
+	if len(requests) == 0 {
+		fmt.Printf("nobody in %s is waiting for help\n", course.Name)
+		return
+	}
+	for _, elt := range requests {
+		printHelpRequest(elt)
+	}
+}
+
+func CommandQueueClaim(cmd *cobra.Command, args []string) {
+	mustUpdateHelpRequest(cmd, args, "claimed")
+}
+
+func CommandQueueRelease(cmd *cobra.Command, args []string) {
+	mustUpdateHelpRequest(cmd, args, "waiting")
+}
+
+func CommandQueueResolve(cmd *cobra.Command, args []string) {
+	mustUpdateHelpRequest(cmd, args, "resolved")
+}
+
+// mustUpdateHelpRequest moves a help request, given by course label and ID, to a new status.
+func mustUpdateHelpRequest(cmd *cobra.Command, args []string, status string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	update := &HelpRequest{Status: status}
+	if flag := cmd.Flag("note"); flag != nil {
+		update.Note = flag.Value.String()
+	}
+	saved := new(HelpRequest)
+	mustPutObject(fmt.Sprintf("/courses/%d/help_requests/%d", course.ID, mustParseID(args[1])), nil, update, saved)
+	printHelpRequest(saved)
+}
+
+func printHelpRequest(elt *HelpRequest) {
+	switch elt.Status {
+	case "waiting":
+		fmt.Printf("%d. [%d] %s, waiting since %s\n", elt.Position, elt.ID, elt.Student, elt.CreatedAt.Local().Format("15:04"))
+	case "claimed":
+		fmt.Printf("   [%d] %s, being helped by %s since %s\n", elt.ID, elt.Student, elt.Helper, elt.ClaimedAt.Local().Format("15:04"))
+	default:
+		fmt.Printf("   [%d] %s, %s\n", elt.ID, elt.Student, elt.Status)
+	}
+	fmt.Printf("    %s\n", elt.Message)
+	if elt.Problem != "" {
+		fmt.Printf("    %s step %d", elt.Problem, elt.Step)
+		if elt.CommitID > 0 {
+			fmt.Printf(", commit %d", elt.CommitID)
+		}
+		fmt.Println()
+	}
+}
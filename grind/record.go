@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// recordTestDir holds the test fixtures of a step: NAME.in is fed to the
+	// reference solution on stdin and NAME.out is the expected output.
+	recordTestDir = "tests"
+
+	// recordDefaultClock is the time limit for one run when the problem type has none.
+	recordDefaultClock = 30 * time.Second
+)
+
+func CommandAuthorRecord(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	command := cmd.Flag("command").Value.String()
+	if command == "" {
+		fatalf(exitUsage, "give the command that runs the reference solution with --command")
+	}
+	step, err := strconv.Atoi(cmd.Flag("step").Value.String())
+	if err != nil || step < 1 {
+		fatalf(exitUsage, "--step must be a step number")
+	}
+	acceptAll := cmd.Flag("yes").Value.String() == "true"
+
+	dir := mustFindProblemDir(".")
+	cfg := mustReadProblemConfig(dir)
+	stepdir := filepath.Join(dir, strconv.Itoa(step))
+	if _, err := os.Stat(stepdir); err != nil {
+		fatalf(exitUsage, "step %d not found: %v", step, err)
+	}
+
+	// find the inputs
+	testdir := filepath.Join(stepdir, recordTestDir)
+	var inputs []string
+	if len(args) == 0 {
+		inputs, err = filepath.Glob(filepath.Join(testdir, "*.in"))
+		if err != nil {
+			fatalf(exitUsage, "error finding inputs: %v", err)
+		}
+		sort.Strings(inputs)
+	}
+	for _, name := range args {
+		name = strings.TrimSuffix(filepath.Base(name), ".in")
+		inputs = append(inputs, filepath.Join(testdir, name+".in"))
+	}
+	if len(inputs) == 0 {
+		fatalf(exitUsage, "no inputs found; put each one in %s as NAME.in", filepath.Join(strconv.Itoa(step), recordTestDir))
+	}
+
+	// run in the image the daycare uses
+	problemType := new(ProblemType)
+	mustGetObject("/problem_types/"+cfg.Problem.Type, nil, problemType)
+	image := recordImage(problemType)
+	clock := recordDefaultClock
+	if problemType.MaxClock > 0 {
+		clock = time.Duration(problemType.MaxClock) * time.Second
+	}
+	log.Printf("recording %d output%s with %s", len(inputs), plural(len(inputs)), image)
+
+	workspace := mustRecordWorkspace(stepdir)
+	defer os.RemoveAll(workspace)
+
+	reader := bufio.NewReader(os.Stdin)
+	kept := 0
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".in")
+		stdin, err := ioutil.ReadFile(input)
+		if err != nil {
+			fatalf(exitUsage, "error reading input: %v", err)
+		}
+		stdout, err := recordRun(image, problemType, workspace, command, stdin, clock)
+		if err != nil {
+			errorLog.Printf("%s: %v", name, err)
+			continue
+		}
+
+		golden := filepath.Join(testdir, name+".out")
+		old, err := ioutil.ReadFile(golden)
+		if err != nil && !os.IsNotExist(err) {
+			fatalf(exitUsage, "error reading %s: %v", golden, err)
+		}
+		if err == nil && bytes.Equal(old, stdout) {
+			fmt.Printf("%s: unchanged\n", name)
+			continue
+		}
+
+		// review the change before it replaces the expected output
+		if err == nil {
+			fmt.Printf("%s: output changed\n", name)
+		} else {
+			fmt.Printf("%s: new output\n", name)
+		}
+		fmt.Print(recordDiff(golden, stdout))
+		if !acceptAll && !recordConfirm(reader, "keep the new output? [y/N] ") {
+			continue
+		}
+		mustWriteProblemFile(testdir, name+".out", string(stdout))
+		kept++
+	}
+	log.Printf("saved %d golden file%s; run \"grind create --update\" to send them to the server", kept, plural(kept))
+}
+
+// recordImage picks the image to record with: the pinned toolchain image if
+// the server keeps one for the problem type, or else the problem type's image.
+func recordImage(problemType *ProblemType) string {
+	images := []*ToolchainImage{}
+	if getObject("/toolchain_images", nil, &images) {
+		for _, elt := range images {
+			if elt.ProblemType == problemType.Name && elt.Reference != "" {
+				return elt.Reference
+			}
+		}
+	}
+	return problemType.Image
+}
+
+// mustRecordWorkspace copies the files for a step into a scratch directory,
+// with the reference solution in place of the starter files.
+func mustRecordWorkspace(stepdir string) string {
+	workspace, err := ioutil.TempDir("", "grind-record-")
+	if err != nil {
+		fatalf(exitUsage, "error creating workspace: %v", err)
+	}
+	var solutionFiles []string
+	err = filepath.Walk(stepdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relpath, err := filepath.Rel(stepdir, path)
+		if err != nil {
+			return err
+		}
+		reldir, relfile := filepath.Split(relpath)
+		switch reldir {
+		case "_starter/":
+			return nil
+		case "_solution/":
+			// applied last so they replace anything else with the same name
+			solutionFiles = append(solutionFiles, relfile)
+			return nil
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		mustWriteProblemFile(workspace, relpath, string(contents))
+		return nil
+	})
+	if err != nil {
+		fatalf(exitUsage, "error reading %s: %v", stepdir, err)
+	}
+	for _, name := range solutionFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(stepdir, "_solution", name))
+		if err != nil {
+			fatalf(exitUsage, "error reading solution: %v", err)
+		}
+		mustWriteProblemFile(workspace, name, string(contents))
+	}
+	return workspace
+}
+
+// recordRun runs the command in a container with the input on stdin,
+// returning what it wrote to stdout.
+func recordRun(image string, problemType *ProblemType, workspace, command string, stdin []byte, clock time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clock)
+	defer cancel()
+	dockerArgs := []string{"run", "--rm", "-i", "--network", "none",
+		"-v", workspace + ":/home/student", "-w", "/home/student"}
+	if problemType.MaxMemory > 0 {
+		dockerArgs = append(dockerArgs, "--memory", fmt.Sprintf("%dm", problemType.MaxMemory))
+	}
+	dockerArgs = append(dockerArgs, image, "/bin/sh", "-c", command)
+	run := exec.CommandContext(ctx, "docker", dockerArgs...)
+	run.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	run.Stdout = &stdout
+	run.Stderr = &stderr
+	if err := run.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("still running after %v", clock)
+		}
+		return nil, fmt.Errorf("%v\n%s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// recordDiff shows how the new output differs from the golden file.
+func recordDiff(golden string, output []byte) string {
+	if _, err := os.Stat(golden); err != nil {
+		return string(output)
+	}
+	tmp, err := ioutil.TempFile("", "grind-record-")
+	if err != nil {
+		return string(output)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Write(output)
+	tmp.Close()
+	out, _ := exec.Command("diff", "-u", "--label", "expected", "--label", "recorded", golden, tmp.Name()).Output()
+	if len(out) == 0 {
+		return string(output)
+	}
+	return string(out)
+}
+
+func recordConfirm(reader *bufio.Reader, prompt string) bool {
+	fmt.Print(prompt)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
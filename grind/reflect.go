@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandReflect(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	dir := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+	dotfile, unique, _ := mustFindProblemDirectory(dir)
+	path := fmt.Sprintf("/assignments/%d/problems/%d/reflections", dotfile.AssignmentID, dotfile.Problems[unique].ID)
+	redo := cmd.Flag("redo").Value.String() == "true"
+
+	statuses := []*ReflectionStatus{}
+	mustGetObject(path, nil, &statuses)
+	if len(statuses) == 0 {
+		log.Printf("%s has no reflection questions", unique)
+		return
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	answers := &ReflectionAnswers{Answers: make(map[string]string)}
+	for _, elt := range statuses {
+		if elt.Answer != "" && !redo {
+			continue
+		}
+		fmt.Printf("%s\n> ", elt.Question)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			fatalf(exitUsage, "error reading your answer: %v", err)
+		}
+		if answer := strings.TrimSpace(line); answer != "" {
+			answers.Answers[elt.Name] = answer
+		}
+	}
+	if len(answers.Answers) == 0 {
+		log.Printf("all questions for %s have been answered; use --redo to change your answers", unique)
+		return
+	}
+	mustPutObject(path, nil, answers, &statuses)
+	log.Printf("saved %d answer%s for %s", len(answers.Answers), plural(len(answers.Answers)), unique)
+}
+
+// remindReflections points out questions a student is asked after passing a problem.
+func remindReflections(assignmentID int64, problem *Problem) {
+	statuses := []*ReflectionStatus{}
+	if !getObject(fmt.Sprintf("/assignments/%d/problems/%d/reflections", assignmentID, problem.ID), nil, &statuses) {
+		return
+	}
+	for _, elt := range statuses {
+		if elt.When == ReflectionAfter && elt.Answer == "" {
+			log.Printf("your instructor has questions about your work on %s; answer them with \"grind reflect\"", problem.Unique)
+			return
+		}
+	}
+}
+
+func CommandAuthorReflect(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	problem := mustFindProblem(args[0])
+	path := fmt.Sprintf("/problems/%d/reflections", problem.ID)
+
+	if len(args) == 2 {
+		name := args[1]
+		if cmd.Flag("remove").Value.String() == "true" {
+			doRequest(path+"/"+name, nil, "DELETE", nil, nil, false)
+			log.Printf("%s no longer asks %s", problem.Unique, name)
+			return
+		}
+		prompt := &ReflectionPrompt{Question: cmd.Flag("question").Value.String(), When: ReflectionBefore}
+		if prompt.Question == "" {
+			fatalf(exitUsage, "give the question with --question")
+		}
+		if cmd.Flag("after").Value.String() == "true" {
+			prompt.When = ReflectionAfter
+		}
+		mustPutObject(path+"/"+name, nil, prompt, nil)
+	}
+
+	prompts := []*ReflectionPrompt{}
+	mustGetObject(path, nil, &prompts)
+	if len(prompts) == 0 {
+		fmt.Printf("%s has no reflection questions\n", problem.Unique)
+		return
+	}
+	for _, elt := range prompts {
+		fmt.Printf("%s (%s grading): %s\n", elt.Name, elt.When, elt.Question)
+	}
+}
+
+func CommandCourseReflections(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	params := make(map[string]string)
+	if len(args) == 2 {
+		params["problem_id"] = strconv.FormatInt(mustFindProblem(args[1]).ID, 10)
+	}
+	anonymous := cmd.Flag("anonymous").Value.String() == "true"
+	if anonymous {
+		params["anonymous"] = "true"
+	}
+
+	answers := []*ReflectionAnswer{}
+	mustGetObject(fmt.Sprintf("/courses/%d/reflections", course.ID), params, &answers)
+
+	out := csv.NewWriter(os.Stdout)
+	header := []string{"student", "email", "problem", "question", "answer", "score", "answered"}
+	if anonymous {
+		header = []string{"student", "problem", "question", "answer", "score", "answered"}
+	}
+	out.Write(header)
+	for _, elt := range answers {
+		row := []string{elt.Name, elt.Email, elt.Unique, elt.Prompt, elt.Answer,
+			strconv.FormatFloat(elt.Score*100.0, 'f', 0, 64), elt.AnsweredAt.Local().Format("2006-01-02 15:04")}
+		if anonymous {
+			row = append(row[:1], row[2:]...)
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		fatalf(exitUsage, "error writing answers: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// gitRemote is the name of the git remote that submits to CodeGrinder.
+const gitRemote = "codegrinder"
+
+func CommandRemote(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	dir := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		fatalf(exitUsage, "git is not installed: %v", err)
+	}
+	if capabilities := getCapabilities(nil); capabilities != nil && !capabilities.GitPush {
+		fatalf(exitServer, "the server at %s does not accept work through git push", Config.Host)
+	}
+	dotfile, problemSetDir, _ := findDotFile(dir)
+
+	url := fmt.Sprintf("https://%s/git/%d", Config.Host, dotfile.AssignmentID)
+	// the server grades the files at the top of the repository
+	out, err := exec.Command("git", "-C", problemSetDir, "rev-parse", "--show-toplevel").Output()
+	top, _ := filepath.EvalSymlinks(strings.TrimSpace(string(out)))
+	want, _ := filepath.EvalSymlinks(problemSetDir)
+	if abs, err := filepath.Abs(want); err == nil {
+		want = abs
+	}
+	if err != nil || top != want {
+		errorLog.Printf("%s must be the top directory of a git repository", problemSetDir)
+		fatalf(exitUsage, "  run \"git init\" there first")
+	}
+	mustGit(problemSetDir, "remote", "remove", gitRemote)
+	mustGit(problemSetDir, "remote", "add", gitRemote, url)
+
+	// the session cookie goes in the repository config, not in the URL,
+	// so it does not appear in the output of git commands
+	mustGit(problemSetDir, "config", "http."+url+".extraHeader", "Cookie: "+Config.Cookie)
+
+	log.Printf("added git remote %s for %s", gitRemote, url)
+	log.Printf("submit your work with: git push %s main", gitRemote)
+}
+
+// mustGit runs a git command in a directory. Removing a remote
+// that does not exist is not an error.
+func mustGit(dir string, args ...string) {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil && !(args[0] == "remote" && args[1] == "remove") {
+		fatalf(exitUsage, "git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// gradeReportFile is where a signed grade report is saved in the problem set directory.
+const gradeReportFile = "grade-report.json"
+
+func CommandVerifyReport(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	report := new(GradeReport)
+	switch len(args) {
+	case 0:
+		// get a fresh report for the problem set in this directory and keep it
+		dotfile, problemSetDir, _ := findDotFile(".")
+		mustGetObject(fmt.Sprintf("/assignments/%d/report", dotfile.AssignmentID), nil, report)
+		mustVerifyReport(report)
+		path := filepath.Join(problemSetDir, gradeReportFile)
+		contents, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			fatalf(exitUsage, "JSON error encoding %s: %v", path, err)
+		}
+		contents = append(contents, '\n')
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			fatalf(exitUsage, "error saving file %s: %v", path, err)
+		}
+		printReport(report)
+		log.Printf("report saved to %s; keep a copy in case your grade is ever disputed", path)
+
+	case 1:
+		contents, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fatalf(exitUsage, "error reading %s: %v", args[0], err)
+		}
+		if err := json.Unmarshal(contents, report); err != nil {
+			fatalf(exitUsage, "error parsing %s: %v", args[0], err)
+		}
+		mustVerifyReport(report)
+		printReport(report)
+
+		// make sure the work it vouches for is still on record
+		records := []*SubmissionRecord{}
+		if !getList(fmt.Sprintf("/assignments/%d/submission_records", report.AssignmentID), nil, &records, true) {
+			log.Printf("the submission log for this assignment is not available to you, so only the signature was checked")
+			return
+		}
+		if err := VerifySubmissionRecords(records); err != nil {
+			fatalf(exitFailed, "the submission log on the server is broken: %v", err)
+		}
+		if report.Records > 0 {
+			if report.Records > len(records) || records[report.Records-1].Hash != report.LastHash {
+				fatalf(exitFailed, "the submission log on the server no longer matches this report")
+			}
+		}
+		log.Printf("the submission log on the server still holds all %d record%s this report vouches for, with %d since",
+			report.Records, plural(report.Records), len(records)-report.Records)
+
+	default:
+		usage(cmd)
+	}
+}
+
+// pinReportKey saves the server's report signing key in the config, so reports are
+// always checked against the key first seen rather than whatever the server says now.
+// A key that differs from the one already pinned is reported and replaces it.
+func pinReportKey() {
+	signingKey := new(ReportSigningKey)
+	mustGetObject("/report_signing_key", nil, signingKey)
+	if _, err := DecodeReportSigningKey(signingKey.PublicKey); err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if Config.ReportKey == signingKey.PublicKey {
+		return
+	}
+	if Config.ReportKey != "" {
+		old, _ := DecodeReportSigningKey(Config.ReportKey)
+		errorLog.Printf("warning: %s now signs grade reports with key %s in place of %s;", Config.Host, signingKey.Fingerprint, ReportKeyFingerprint(old))
+		errorLog.Printf("  reports saved before this will only check out with the old key")
+	}
+	Config.ReportKey = signingKey.PublicKey
+	log.Printf("grade reports from %s are signed with key %s", Config.Host, signingKey.Fingerprint)
+}
+
+// mustVerifyReport checks a grade report against the key pinned by "grind init".
+func mustVerifyReport(report *GradeReport) {
+	if Config.ReportKey == "" {
+		if !Config.fromFile {
+			fatalf(exitUsage, "no report signing key is pinned; run \"grind init\" first")
+		}
+		pinReportKey()
+		mustWriteConfig()
+	}
+	key, err := DecodeReportSigningKey(Config.ReportKey)
+	if err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	if err := report.Verify(key); err != nil {
+		fatalf(exitFailed, "this report was not signed by %s: %v", Config.Host, err)
+	}
+	log.Printf("the report signature is valid (key %s)", report.KeyFingerprint)
+}
+
+func printReport(report *GradeReport) {
+	fmt.Printf("%s for %s (%s)\n", report.ProblemSet, report.Name, report.Email)
+	fmt.Printf("issued %s with %d saved record%s\n", report.IssuedAt.Local().Format("2006-01-02 15:04"), report.Records, plural(report.Records))
+	var uniques []string
+	for unique := range report.RawScores {
+		uniques = append(uniques, unique)
+	}
+	sort.Strings(uniques)
+	for _, unique := range uniques {
+		fmt.Printf("  %s:", unique)
+		for _, score := range report.RawScores[unique] {
+			fmt.Printf(" %.0f%%", score*100.0)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("score: %.0f%%\n", report.Score*100.0)
+}
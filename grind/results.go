@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandResults(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	switch len(args) {
+	case 0:
+		submissions := []*Submission{}
+		mustGetObject("/submissions", nil, &submissions)
+		if len(submissions) == 0 {
+			fmt.Println("no submissions found")
+			return
+		}
+		for _, elt := range submissions {
+			fmt.Printf("%d: problem %d step %d, %s at %s: %s\n", elt.ID, elt.ProblemID, elt.Step,
+				elt.Status, elt.UpdatedAt.Format("2006-01-02 15:04"), elt.Note)
+		}
+
+	case 1:
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || id < 1 {
+			fatalf(exitUsage, "submission ID must be a positive number")
+		}
+		var params map[string]string
+		if wait, _ := cmd.Flags().GetInt("wait"); wait > 0 {
+			params = map[string]string{"wait": strconv.Itoa(wait)}
+		}
+		submission := new(Submission)
+		mustGetObject(fmt.Sprintf("/submissions/%d", id), params, submission)
+
+		problem := new(Problem)
+		mustGetObject(fmt.Sprintf("/problems/%d", submission.ProblemID), nil, problem)
+		fmt.Printf("submission %d: %s step %d\n", submission.ID, problem.Unique, submission.Step)
+		if !submission.IsFinished() {
+			fmt.Printf("  %s: %s\n", submission.Status, submission.Note)
+			return
+		}
+		if submission.Status == "failed" || submission.ReportCard == nil {
+			fatalf(exitServer, "  %s", submission.Note)
+		}
+		printReportCard(submission.ReportCard)
+
+		if submission.ReportCard.Passed && submission.Score == 1.0 {
+			advanceAfterSubmission(submission, problem)
+		} else {
+			log.Printf("  solution for step %d failed", submission.Step)
+			os.Exit(exitFailed)
+		}
+
+	default:
+		usage(cmd)
+	}
+}
+
+func printReportCard(card *ReportCard) {
+	fmt.Printf("  %s\n", card.Note)
+	for _, result := range card.Results {
+		fmt.Printf("  %-7s %s\n", result.Outcome, result.Name)
+	}
+}
+
+// advanceAfterSubmission moves to the next step if the current directory
+// is within the problem set that passed and it is still on the same step.
+func advanceAfterSubmission(submission *Submission, problem *Problem) {
+	if dir, _ := searchUpForDotFile("."); dir == "" {
+		return
+	}
+	dotfile, dir, _ := findDotFile(".")
+	info := dotfile.Problems[problem.Unique]
+	if dotfile.AssignmentID != submission.AssignmentID || info == nil || info.Step != submission.Step {
+		return
+	}
+	dir = problemDirectory(dotfile, dir, problem.Unique)
+	if nextStep(dir, info, problem, &Commit{Step: submission.Step}) {
+		mustWriteDotFile(dotfile)
+	}
+}
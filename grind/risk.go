@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCourseAtRisk(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	path := fmt.Sprintf("/courses/%d/at_risk", course.ID)
+	switch cmd.Flag("digest").Value.String() {
+	case "":
+	case "on":
+		mustPutObject(path+"/digest", nil, nil, nil)
+		log.Printf("you will be emailed this list for %s each week", course.Name)
+	case "off":
+		doRequest(path+"/digest", nil, "DELETE", nil, nil, false)
+		log.Printf("you will no longer be emailed this list for %s", course.Name)
+	default:
+		fatalf(exitUsage, "--digest must be on or off")
+	}
+
+	students := []*AtRiskStudent{}
+	mustGetObject(path, nil, &students)
+	if len(students) == 0 {
+		fmt.Printf("no students in %s show signs of falling behind\n", course.Name)
+		return
+	}
+	for _, student := range students {
+		last := "never"
+		if !student.LastActiveAt.IsZero() {
+			last = student.LastActiveAt.Local().Format("2006-01-02")
+		}
+		fmt.Printf("%s <%s>, last active %s\n", student.Name, student.Email, last)
+		for _, signal := range student.Signals {
+			fmt.Printf("    %s\n", signal)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+	"github.com/spf13/cobra"
+)
+
+// rubricFile is the form of a rubric written by an instructor:
+//
+//	[rubric]
+//	formula = weighted
+//	weight = 0.3
+//
+//	[criterion "style"]
+//	description = names, layout, and comments
+//	level = 4 excellent: clear and consistent throughout
+//	level = 2 fair
+//	level = 0 missing
+//
+// Criteria are kept in the order they appear in the file, and each level
+// gives its points, its name, and optionally a description after a colon.
+type rubricFile struct {
+	Rubric struct {
+		Formula string
+		Weight  float64
+	}
+	Criterion map[string]*struct {
+		Description string
+		Level       []string
+	}
+}
+
+var rubricCriterionRE = regexp.MustCompile(`(?m)^\s*\[\s*criterion\s+"((?:[^"\\]|\\.)*)"\s*\]`)
+
+func mustReadRubric(path string) *Rubric {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		fatalf(exitUsage, "error reading rubric: %v", err)
+	}
+	file := new(rubricFile)
+	if err := gcfg.ReadStringInto(file, string(raw)); err != nil {
+		fatalf(exitUsage, "error parsing %s: %v", path, err)
+	}
+	rubric := &Rubric{Formula: file.Rubric.Formula, Weight: file.Rubric.Weight}
+	seen := make(map[string]bool)
+	for _, match := range rubricCriterionRE.FindAllStringSubmatch(string(raw), -1) {
+		section := file.Criterion[match[1]]
+		if section == nil || seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+		criterion := &RubricCriterion{Name: match[1], Description: section.Description}
+		for _, line := range section.Level {
+			fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+			points, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil || len(fields) < 2 {
+				fatalf(exitUsage, "criterion %q: level %q must start with its points and then its name", match[1], line)
+			}
+			level := &RubricLevel{Points: points, Name: strings.TrimSpace(fields[1])}
+			if i := strings.Index(level.Name, ":"); i >= 0 {
+				level.Name, level.Description = strings.TrimSpace(level.Name[:i]), strings.TrimSpace(level.Name[i+1:])
+			}
+			criterion.Levels = append(criterion.Levels, level)
+		}
+		rubric.Criteria = append(rubric.Criteria, criterion)
+	}
+	if err := rubric.Normalize(); err != nil {
+		fatalf(exitUsage, "%s: %v", path, err)
+	}
+	return rubric
+}
+
+func CommandCourseRubric(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 2 || len(args) > 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/rubric", course.ID, problemSet.ID)
+
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("%s is graded by the autograder alone", problemSet.Unique)
+		return
+	}
+	rubric := new(Rubric)
+	if len(args) == 3 {
+		mustPutObject(path, nil, mustReadRubric(args[2]), rubric)
+	} else {
+		mustGetObject(path, nil, rubric)
+	}
+	printRubric(rubric, nil)
+}
+
+func CommandCourseRubricGrade(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/rubric_grades", course.ID, problemSet.ID)
+
+	if len(args) == 2 {
+		grades := []*RubricGrade{}
+		mustGetObject(path, nil, &grades)
+		if len(grades) == 0 {
+			fmt.Printf("no students have been graded on the rubric for %s\n", problemSet.Unique)
+			return
+		}
+		for _, elt := range grades {
+			fmt.Printf("%-30s %5.1f points (%.0f%%) %s\n", elt.Student, elt.Points, elt.Score*100.0, elt.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		return
+	}
+
+	student, name := mustFindStudent(args[2])
+	grade := &RubricGrade{
+		Levels:   make(map[string]string),
+		Comments: make(map[string]string),
+		Note:     cmd.Flag("note").Value.String(),
+	}
+	for _, arg := range args[3:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			fatalf(exitUsage, "give each criterion as criterion=level or criterion=level:comment, not %q", arg)
+		}
+		level := parts[1]
+		if i := strings.Index(level, ":"); i >= 0 {
+			grade.Comments[parts[0]] = strings.TrimSpace(level[i+1:])
+			level = level[:i]
+		}
+		grade.Levels[parts[0]] = level
+	}
+	if len(grade.Levels) == 0 {
+		rubric := new(Rubric)
+		mustGetObject(fmt.Sprintf("/courses/%d/problem_sets/%d/rubric", course.ID, problemSet.ID), nil, rubric)
+		printRubric(rubric, nil)
+		fatalf(exitUsage, "give the level for each criterion as criterion=level")
+	}
+
+	saved := new(RubricGrade)
+	mustPutObject(path+"/"+student, nil, grade, saved)
+	log.Printf("%s scored %.1f points (%.0f%%) on the rubric for %s", name, saved.Points, saved.Score*100.0, problemSet.Unique)
+}
+
+func CommandFeedback(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	dir := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+	dotfile, _, _ := findDotFile(dir)
+	feedback := new(RubricFeedback)
+	if !getObject(fmt.Sprintf("/assignments/%d/rubric", dotfile.AssignmentID), nil, feedback) {
+		log.Printf("this assignment is not graded by hand")
+		return
+	}
+	if feedback.Grade == nil {
+		log.Printf("your work has not been graded by hand yet; this is the rubric that will be used:")
+	}
+	printRubric(feedback.Rubric, feedback.Grade)
+}
+
+// printRubric shows a rubric, marking the levels chosen in the grade if there is one.
+func printRubric(rubric *Rubric, grade *RubricGrade) {
+	for _, criterion := range rubric.Criteria {
+		fmt.Printf("%s", criterion.Name)
+		if criterion.Description != "" {
+			fmt.Printf(": %s", criterion.Description)
+		}
+		fmt.Println()
+		for _, level := range criterion.Levels {
+			mark := " "
+			if grade != nil && grade.Levels[criterion.Name] == level.Name {
+				mark = "*"
+			}
+			fmt.Printf("  %s %4g %s", mark, level.Points, level.Name)
+			if level.Description != "" {
+				fmt.Printf(": %s", level.Description)
+			}
+			fmt.Println()
+		}
+		if grade != nil && grade.Comments[criterion.Name] != "" {
+			fmt.Printf("    comment: %s\n", grade.Comments[criterion.Name])
+		}
+	}
+	switch rubric.Formula {
+	case RubricWeighted:
+		fmt.Printf("the rubric counts for %.0f%% of the grade\n", rubric.Weight*100.0)
+	case RubricMinimum:
+		fmt.Printf("the grade is the lower of the rubric and autograder scores\n")
+	case RubricProduct:
+		fmt.Printf("the autograder score is scaled by the rubric score\n")
+	}
+	if grade != nil {
+		fmt.Printf("rubric score: %g of %g points (%.0f%%)\n", grade.Points, rubric.MaxPoints(), grade.Score*100.0)
+		if grade.Note != "" {
+			fmt.Printf("note: %s\n", grade.Note)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	. "github.com/russross/codegrinder/types"
@@ -25,19 +27,52 @@ func CommandSave(cmd *cobra.Command, args []string) {
 	case 1:
 		dir = args[0]
 	default:
-		cmd.Help()
-		return
+		usage(cmd)
 	}
 
-	problem, _, commit, _ := gather(now, dir)
+	var problems []*Problem
+	var commits []*Commit
+	var dirs []string
+	var dotfile *DotFileInfo
+	if cmd.Flag("all").Value.String() == "true" {
+		problems, commits, dirs, dotfile = gatherAll(now, dir)
+	} else {
+		problem, _, commit, df := gather(now, dir)
+		problemDir := problemDirectory(df, df.Dir, problem.Unique)
+		problems, commits, dirs, dotfile = []*Problem{problem}, []*Commit{commit}, []string{problemDir}, df
+	}
+	mode := cmd.Flag("conflict").Value.String()
+
+	for i, commit := range commits {
+		mustSaveCommit(dirs[i], dotfile, problems[i], commit, mode)
+	}
+}
+
+// mustSaveCommit saves a problem's files to the server without grading them.
+// It returns false if no commit was saved: work on a sealed exam is submitted
+// sealed instead, and a conflict may be settled by keeping the server's copy.
+func mustSaveCommit(dir string, dotfile *DotFileInfo, problem *Problem, commit *Commit, mode string) bool {
+	if mustSubmitSealed(problem, commit) {
+		return false
+	}
+	if !mustReconcileCommit(dir, dotfile, problem, commit, mode) {
+		return false
+	}
 	commit.Action = ""
 	commit.Note = "saving from grind tool"
+	commit.AddChecksums()
 	unsigned := &CommitBundle{Commit: commit}
 
 	// send the commit to the server
 	signed := new(CommitBundle)
 	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
+	mustVerifyCommit(signed.Commit)
 	log.Printf("problem %s step %d saved", problem.Unique, commit.Step)
+
+	// remember what the server has now
+	takeSnapshot(dotfile.Problems[problem.Unique], signed.Commit.Files, signed.Commit.UpdatedAt)
+	mustWriteDotFile(dotfile)
+	return true
 }
 
 func gather(now time.Time, startDir string) (*Problem, *Assignment, *Commit, *DotFileInfo) {
@@ -55,19 +90,77 @@ func gather(now time.Time, startDir string) (*Problem, *Assignment, *Commit, *Do
 		for u := range dotfile.Problems {
 			unique = u
 		}
-		problemDir = problemSetDir
 	} else {
 		// use the subdirectory name to identify the problem
 		if problemDir == "" {
-			log.Printf("you must identify the problem within this problem set")
-			log.Printf("  either run this from with the problem directory, or")
-			log.Fatalf("  identify it as a parameter in the command")
+			errorLog.Printf("you must identify the problem within this problem set")
+			errorLog.Printf("  either run this from with the problem directory, or")
+			errorLog.Printf("  identify it as a parameter in the command, or")
+			fatalf(exitUsage, "  use --all to work on every problem in the set")
+		}
+		_, unique = filepath.Split(problemDir)
+	}
+	problem, commit := gatherProblem(now, dotfile, problemSetDir, unique)
+
+	return problem, assignment, commit, dotfile
+}
+
+// gatherAll gathers every problem in the problem set containing startDir,
+// returning them in order of unique ID along with the directory of each.
+func gatherAll(now time.Time, startDir string) ([]*Problem, []*Commit, []string, *DotFileInfo) {
+	dotfile, problemSetDir, _ := findDotFile(startDir)
+
+	var uniques []string
+	for unique := range dotfile.Problems {
+		uniques = append(uniques, unique)
+	}
+	sort.Strings(uniques)
+
+	var problems []*Problem
+	var commits []*Commit
+	var dirs []string
+	for _, unique := range uniques {
+		problem, commit := gatherProblem(now, dotfile, problemSetDir, unique)
+		problems = append(problems, problem)
+		commits = append(commits, commit)
+		dirs = append(dirs, problemDirectory(dotfile, problemSetDir, unique))
+	}
+	return problems, commits, dirs, dotfile
+}
+
+// problemDirectory returns the directory holding the files for a problem.
+// A problem set with a single problem keeps its files alongside the dotfile.
+func problemDirectory(dotfile *DotFileInfo, problemSetDir, unique string) string {
+	if len(dotfile.Problems) == 1 {
+		return problemSetDir
+	}
+	return filepath.Join(problemSetDir, unique)
+}
+
+// mustFindProblemDirectory identifies the single problem that dir belongs to,
+// returning the problem set's dotfile, the problem's unique ID, and its directory.
+func mustFindProblemDirectory(dir string) (*DotFileInfo, string, string) {
+	dotfile, problemSetDir, problemDir := findDotFile(dir)
+	unique := ""
+	if len(dotfile.Problems) == 1 {
+		for u := range dotfile.Problems {
+			unique = u
 		}
+	} else if problemDir != "" {
 		_, unique = filepath.Split(problemDir)
 	}
+	if dotfile.Problems[unique] == nil {
+		fatalf(exitUsage, "run this from within a problem directory, or give the directory as a parameter")
+	}
+	return dotfile, unique, problemDirectory(dotfile, problemSetDir, unique)
+}
+
+// gatherProblem reads the local files for one problem and forms a commit.
+func gatherProblem(now time.Time, dotfile *DotFileInfo, problemSetDir, unique string) (*Problem, *Commit) {
+	problemDir := problemDirectory(dotfile, problemSetDir, unique)
 	info := dotfile.Problems[unique]
 	if info == nil {
-		log.Fatalf("unable to recognize the problem based on the directory name of %q", unique)
+		fatalf(exitUsage, "unable to recognize the problem based on the directory name of %q", unique)
 	}
 	problem := new(Problem)
 	mustGetObject(fmt.Sprintf("/problems/%d", info.ID), nil, problem)
@@ -110,7 +203,7 @@ func gather(now time.Time, startDir string) (*Problem, *Assignment, *Commit, *Do
 		return nil
 	})
 	if err != nil {
-		log.Fatalf("walk error: %v", err)
+		fatalf(exitUsage, "walk error: %v", err)
 	}
 	if len(files) != len(info.Whitelist) {
 		log.Printf("did not find all the expected files")
@@ -119,7 +212,7 @@ func gather(now time.Time, startDir string) (*Problem, *Assignment, *Commit, *Do
 				log.Printf("  %s not found", name)
 			}
 		}
-		log.Fatalf("all expected files must be present")
+		fatalf(exitUsage, "all expected files must be present")
 	}
 
 	// form a commit object
@@ -129,55 +222,144 @@ func gather(now time.Time, startDir string) (*Problem, *Assignment, *Commit, *Do
 		ProblemID:    info.ID,
 		Step:         info.Step,
 		Files:        files,
+		ClientTime:   now,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
 
-	return problem, assignment, commit, dotfile
+	return problem, commit
+}
+
+// mustVerifyCommit checks that the files in a commit received from the server arrived intact.
+func mustVerifyCommit(commit *Commit) {
+	if err := commit.VerifyChecksums(); err != nil {
+		fatalf(exitUsage, "step %d received from the server is damaged: %v", commit.Step, err)
+	}
 }
 
+// mustWriteDotFile saves the dotfile with updated steps and whitelists.
+func mustWriteDotFile(dotfile *DotFileInfo) {
+	contents, err := json.MarshalIndent(dotfile, "", "    ")
+	if err != nil {
+		fatalf(exitUsage, "JSON error encoding %s: %v", dotfile.Path, err)
+	}
+	contents = append(contents, '\n')
+	if err := os.MkdirAll(filepath.Dir(dotfile.Path), 0755); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(dotfile.Path), err)
+	}
+	if err := ioutil.WriteFile(dotfile.Path, contents, 0644); err != nil {
+		fatalf(exitUsage, "error saving file %s: %v", dotfile.Path, err)
+	}
+}
+
+// maxDotFileSearchDepth limits how far below the starting directory
+// findDotFile looks when no dotfile is found above it.
+const maxDotFileSearchDepth = 3
+
+// findDotFile locates the problem set containing startDir, much as git finds its
+// .git directory. It first looks in startDir and each of its ancestors. If that fails,
+// it looks in the subdirectories of startDir, which works from the root of a workspace
+// holding a single problem set. problemDir is the directory directly below the
+// problem set directory that holds startDir, or empty if there is none.
 func findDotFile(startDir string) (dotfile *DotFileInfo, problemSetDir, problemDir string) {
-	abs := false
-	problemSetDir, problemDir = startDir, ""
-	for {
-		path := filepath.Join(problemSetDir, perProblemSetDotFile)
-		if _, err := os.Stat(path); err != nil {
-			if os.IsNotExist(err) {
-				if !abs {
-					abs = true
-					path, err := filepath.Abs(problemSetDir)
-					if err != nil {
-						log.Fatalf("error finding absolute path of %s: %v", problemSetDir, err)
-					}
-					problemSetDir = path
-				}
-
-				// try moving up a directory
-				problemDir = problemSetDir
-				problemSetDir = filepath.Dir(problemSetDir)
-				if problemSetDir == problemDir {
-					log.Fatalf("unable to find %s in %s or an ancestor directory", perProblemSetDotFile, startDir)
-				}
-				log.Printf("could not find %s in %s, trying %s", perProblemSetDotFile, problemDir, problemSetDir)
-				continue
+	problemSetDir, problemDir = searchUpForDotFile(startDir)
+	if problemSetDir == "" {
+		found := searchDownForDotFiles(startDir, maxDotFileSearchDepth)
+		switch len(found) {
+		case 0:
+			fatalf(exitUsage, "unable to find a problem set in %s, an ancestor directory, or a subdirectory", startDir)
+		case 1:
+			problemSetDir = found[0]
+		default:
+			log.Printf("found multiple problem sets under %s:", startDir)
+			for _, elt := range found {
+				log.Printf("  %s", elt)
 			}
-
-			log.Fatalf("error searching for %s in %s: %v", perProblemSetDotFile, problemSetDir, err)
+			fatalf(exitUsage, "run this from within one of them, or give its directory as a parameter")
 		}
-		break
 	}
 
-	// read the .grind file
-	path := filepath.Join(problemSetDir, perProblemSetDotFile)
+	dotfile = readDotFile(problemSetDir)
+	if dotfile == nil {
+		fatalf(exitUsage, "unable to find the problem set in %s", problemSetDir)
+	}
+
+	return dotfile, problemSetDir, problemDir
+}
+
+// readDotFile reads and parses the dotfile for the problem set in dir,
+// returning nil if there is none.
+func readDotFile(dir string) *DotFileInfo {
+	path := existingDotFile(dir)
+	if path == "" {
+		return nil
+	}
 	contents, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalf("error reading %s: %v", path, err)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		fatalf(exitUsage, "error reading %s: %v", path, err)
 	}
-	dotfile = new(DotFileInfo)
+	dotfile := new(DotFileInfo)
 	if err := json.Unmarshal(contents, dotfile); err != nil {
-		log.Fatalf("error parsing %s: %v", path, err)
+		fatalf(exitUsage, "error parsing %s: %v", path, err)
 	}
 	dotfile.Path = path
+	dotfile.Dir = dir
+	return dotfile
+}
 
-	return dotfile, problemSetDir, problemDir
+// searchUpForDotFile looks for a problem set in startDir and each of its ancestors,
+// returning its directory and the directory directly below it that holds startDir.
+// The problem set directory is empty if none is found.
+func searchUpForDotFile(startDir string) (problemSetDir, problemDir string) {
+	start, err := filepath.Abs(startDir)
+	if err != nil {
+		fatalf(exitUsage, "error finding absolute path of %s: %v", startDir, err)
+	}
+	for dir := start; ; dir = filepath.Dir(dir) {
+		if existingDotFile(dir) != "" {
+			rel, err := filepath.Rel(dir, start)
+			if err != nil || rel == "." {
+				return dir, ""
+			}
+			return dir, filepath.Join(dir, strings.Split(rel, string(filepath.Separator))[0])
+		}
+		if dir == filepath.Dir(dir) {
+			return "", ""
+		}
+	}
+}
+
+// searchDownForDotFiles finds problem sets in the subdirectories of startDir,
+// looking at most depth levels down and not looking inside problem sets.
+// Hidden directories are skipped.
+func searchDownForDotFiles(startDir string, depth int) []string {
+	start, err := filepath.Abs(startDir)
+	if err != nil {
+		fatalf(exitUsage, "error finding absolute path of %s: %v", startDir, err)
+	}
+	var found []string
+	filepath.Walk(start, func(path string, stat os.FileInfo, err error) error {
+		if err != nil || !stat.IsDir() {
+			return nil
+		}
+		if path != start && strings.HasPrefix(stat.Name(), ".") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(start, path)
+		if err != nil {
+			return filepath.SkipDir
+		}
+		if rel != "." && len(strings.Split(rel, string(filepath.Separator))) > depth {
+			return filepath.SkipDir
+		}
+		if existingDotFile(path) != "" {
+			found = append(found, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return found
 }
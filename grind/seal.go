@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCourseSeal(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 3 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	deadline := mustParseDeadline(args[2])
+
+	keyFile := cmd.Flag("key").Value.String()
+	if keyFile == "" {
+		keyFile = fmt.Sprintf("%s-%s-exam-key.pem", course.Label, problemSet.Unique)
+	}
+	if _, err := os.Stat(keyFile); err == nil {
+		fatalf(exitUsage, "%s already exists; give a different file name with --key", keyFile)
+	}
+
+	// the private key never leaves this computer until the exam is unsealed
+	publicKey, privateKey, err := GenerateSealKeys()
+	if err != nil {
+		fatalf(exitUsage, "error generating exam keys: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, []byte(privateKey), 0600); err != nil {
+		fatalf(exitUsage, "error saving private key to %s: %v", keyFile, err)
+	}
+
+	exam := &SealedExam{PublicKey: publicKey, Deadline: deadline}
+	saved := new(SealedExam)
+	mustPutObject(fmt.Sprintf("/courses/%d/problem_sets/%d/seal", course.ID, problemSet.ID), nil, exam, saved)
+
+	fmt.Printf("%s in %s is now a sealed exam\n", problemSet.Unique, course.Label)
+	fmt.Printf("  deadline:    %s\n", saved.Deadline.Local().Format("2006-01-02 15:04 MST"))
+	fmt.Printf("  private key: %s\n", keyFile)
+	if key, err := ParseSealPublicKey(saved.PublicKey); err == nil {
+		fmt.Printf("  fingerprint: %s\n", SealKeyFingerprint(key))
+		fmt.Printf("give students the fingerprint; grind shows it when they submit so they can check it matches\n")
+	}
+	fmt.Printf("keep the private key safe: without it the submissions cannot be read or graded\n")
+	fmt.Printf("after the deadline, run \"grind course unseal %s %s --key %s\"\n", course.Label, problemSet.Unique, keyFile)
+}
+
+func CommandCourseUnseal(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+
+	keyFile := cmd.Flag("key").Value.String()
+	if keyFile == "" {
+		keyFile = fmt.Sprintf("%s-%s-exam-key.pem", course.Label, problemSet.Unique)
+	}
+	contents, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		fatalf(exitUsage, "error reading private key: %v", err)
+	}
+
+	sealed := []*SealedSubmission{}
+	mustPostObject(fmt.Sprintf("/courses/%d/problem_sets/%d/unseal", course.ID, problemSet.ID), nil, &SealKey{PrivateKey: string(contents)}, &sealed)
+	queued := 0
+	for _, elt := range sealed {
+		if elt.SubmissionID > 0 {
+			queued++
+		}
+	}
+	fmt.Printf("%s in %s has been unsealed\n", problemSet.Unique, course.Label)
+	fmt.Printf("%d of %d sealed submission%s queued for grading\n", queued, len(sealed), plural(len(sealed)))
+	if queued < len(sealed) {
+		fmt.Printf("submissions that could not be queued are listed in the server log\n")
+	}
+}
+
+// mustFindCourseProblemSet finds a problem set offered in a course by its unique ID.
+func mustFindCourseProblemSet(course *Course, unique string) *ProblemSet {
+	problemSets := []*ProblemSet{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_sets", course.ID), nil, &problemSets)
+	for _, elt := range problemSets {
+		if elt.Unique == unique {
+			return elt
+		}
+	}
+	fatalf(exitUsage, "problem set %s is not offered in %s", unique, course.Label)
+	return nil
+}
+
+// mustParseDeadline parses a date and time, or a date alone meaning the end of that day.
+func mustParseDeadline(s string) time.Time {
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+		return t
+	}
+	if _, err := time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+		fatalf(exitUsage, "deadline must be in the form \"YYYY-MM-DD HH:MM\" or YYYY-MM-DD")
+	}
+	return mustParseDate(s)
+}
+
+// sealedExams caches the sealed exam settings for each assignment, with nil for ordinary assignments.
+var sealedExams = make(map[int64]*SealedExam)
+
+// getSealedExam returns the sealed exam settings for an assignment
+// if it is a sealed exam that has not yet been unsealed.
+// The first key seen for an exam is pinned in the config, and a different
+// key after that is refused, so the server cannot swap in a key of its own.
+func getSealedExam(assignmentID int64) *SealedExam {
+	exam, cached := sealedExams[assignmentID]
+	if !cached {
+		exam = new(SealedExam)
+		if !getObject(fmt.Sprintf("/assignments/%d/seal", assignmentID), nil, exam) {
+			exam = nil
+		}
+		if exam != nil && exam.IsSealed() {
+			mustPinExamKey(assignmentID, exam)
+		}
+		sealedExams[assignmentID] = exam
+	}
+	if exam == nil || !exam.IsSealed() {
+		return nil
+	}
+	return exam
+}
+
+// mustPinExamKey shows the fingerprint of an exam's key, checking it against
+// the one pinned for the assignment or pinning it if this is the first time.
+func mustPinExamKey(assignmentID int64, exam *SealedExam) {
+	publicKey, err := ParseSealPublicKey(exam.PublicKey)
+	if err != nil {
+		fatalf(exitUsage, "error reading the exam key: %v", err)
+	}
+	fingerprint := SealKeyFingerprint(publicKey)
+	id := strconv.FormatInt(assignmentID, 10)
+	switch pinned := Config.ExamKeys[id]; {
+	case pinned == fingerprint:
+		log.Printf("this exam is sealed with key %s", fingerprint)
+	case pinned != "":
+		errorLog.Printf("this exam was sealed with key %s, but the server now gives key %s", pinned, fingerprint)
+		fatalf(exitFailed, "nothing was submitted; check with your instructor before trying again")
+	default:
+		log.Printf("this exam is sealed with key %s", fingerprint)
+		log.Printf("  check that it matches the fingerprint your instructor gave you")
+		if Config.fromFile {
+			if Config.ExamKeys == nil {
+				Config.ExamKeys = make(map[string]string)
+			}
+			Config.ExamKeys[id] = fingerprint
+			mustWriteConfig()
+		}
+	}
+}
+
+// mustSubmitSealed encrypts a commit to the instructor's key and submits it
+// if the assignment is a sealed exam. It returns false for ordinary assignments.
+func mustSubmitSealed(problem *Problem, commit *Commit) bool {
+	exam := getSealedExam(commit.AssignmentID)
+	if exam == nil {
+		return false
+	}
+	if serverNow().After(exam.Deadline) {
+		fatalf(exitUsage, "the deadline for this exam passed at %s", exam.Deadline.Local().Format("2006-01-02 15:04 MST"))
+	}
+	publicKey, err := ParseSealPublicKey(exam.PublicKey)
+	if err != nil {
+		fatalf(exitUsage, "error reading the exam key: %v", err)
+	}
+
+	sealed := &SealedSubmission{
+		AssignmentID: commit.AssignmentID,
+		ProblemID:    commit.ProblemID,
+		Step:         commit.Step,
+	}
+	if err := sealed.Seal(publicKey, commit.Files); err != nil {
+		fatalf(exitUsage, "error sealing files: %v", err)
+	}
+	saved := new(SealedSubmission)
+	mustPostObject("/sealed_submissions", nil, sealed, saved)
+	log.Printf("problem %s step %d sealed and submitted", problem.Unique, commit.Step)
+	log.Printf("  it will be graded after the deadline at %s", exam.Deadline.Local().Format("2006-01-02 15:04 MST"))
+	return true
+}
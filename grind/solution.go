@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// solutionDir is where downloaded reference solutions are kept within a problem directory.
+const solutionDir = "solution"
+
+func CommandSolution(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	dir := "."
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+
+	dotfile, unique, problemDir := mustFindProblemDirectory(dir)
+	info := dotfile.Problems[unique]
+
+	solutions := []*ProblemSolution{}
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/solution", dotfile.AssignmentID, info.ID), nil, &solutions)
+
+	// the solution goes beside the student's work, never on top of it
+	target := filepath.Join(problemDir, solutionDir)
+	if _, err := os.Stat(target); err == nil {
+		fatalf(exitUsage, "%s already exists; delete it first to download the solution again", target)
+	}
+	count := 0
+	for _, solution := range solutions {
+		stepDir := target
+		if len(solutions) > 1 {
+			stepDir = filepath.Join(target, fmt.Sprintf("step-%d", solution.Step))
+		}
+		var names []string
+		for name := range solution.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			mustWriteProblemFile(stepDir, name, solution.Files[name])
+			count++
+		}
+	}
+	if count == 0 {
+		log.Printf("no solution files have been released for %s", unique)
+		return
+	}
+	log.Printf("saved %d solution file%s for %s in %s", count, plural(count), unique, target)
+}
+
+func CommandCourseSolutions(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	problemSet := mustFindCourseProblemSet(course, args[1])
+	path := fmt.Sprintf("/courses/%d/problem_sets/%d/solution_release", course.ID, problemSet.ID)
+
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("solutions to %s are no longer released to students", problemSet.Unique)
+		return
+	}
+
+	release := new(SolutionRelease)
+	if at := cmd.Flag("at").Value.String(); at != "" {
+		release.ReleaseAt = mustParseDeadline(at)
+	}
+	release.OnPass = cmd.Flag("on-pass").Value.String() == "true"
+	files, err := cmd.Flags().GetStringSlice("file")
+	if err != nil {
+		fatalf(exitUsage, "%v", err)
+	}
+	release.Files = files
+	if release.ReleaseAt.IsZero() && !release.OnPass {
+		fatalf(exitUsage, "give --at, --on-pass, or both")
+	}
+
+	saved := new(SolutionRelease)
+	mustPutObject(path, nil, release, saved)
+	var when []string
+	if !saved.ReleaseAt.IsZero() {
+		when = append(when, "at "+saved.ReleaseAt.Local().Format("2006-01-02 15:04 MST"))
+	}
+	if saved.OnPass {
+		when = append(when, "to each student who passes a problem")
+	}
+	what := "the full solutions"
+	if len(saved.Files) > 0 {
+		what = strings.Join(saved.Files, ", ")
+	}
+	log.Printf("%s to %s will be released %s", what, problemSet.Unique, strings.Join(when, " and "))
+}
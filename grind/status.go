@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandStatus(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	// find the directory
+	dir := ""
+	switch len(args) {
+	case 0:
+		dir = "."
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+
+	dotfile, problemSetDir, problemDir := findDotFile(dir)
+
+	// get the assignment and course
+	assignment := new(Assignment)
+	mustGetObject(fmt.Sprintf("/assignments/%d", dotfile.AssignmentID), nil, assignment)
+	course := new(Course)
+	mustGetObject(fmt.Sprintf("/courses/%d", assignment.CourseID), nil, course)
+
+	fmt.Printf("assignment: %s\n", assignment.CanvasTitle)
+	fmt.Printf("course:     %s (%s)\n", course.Name, course.Label)
+	if course.Term != "" {
+		fmt.Printf("term:       %s\n", course.Term)
+	}
+	if !course.IsActive(serverNow()) {
+		fmt.Printf("            this course is no longer active\n")
+	}
+	fmt.Printf("directory:  %s\n", problemSetDir)
+	fmt.Printf("score:      %.1f%%\n", assignment.Score*100.0)
+
+	// get the course overrides
+	overrides := []*ProblemTypeOverride{}
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_type_overrides", course.ID), nil, &overrides)
+	overridesByType := make(map[string]*ProblemTypeOverride)
+	for _, elt := range overrides {
+		overridesByType[elt.ProblemType] = elt
+	}
+
+	// report on each problem
+	// from within a problem directory, only that problem is shown unless --all is given
+	var uniques []string
+	if _, unique := filepath.Split(problemDir); problemDir != "" && dotfile.Problems[unique] != nil &&
+		cmd.Flag("all").Value.String() != "true" {
+		uniques = []string{unique}
+	} else {
+		for unique := range dotfile.Problems {
+			uniques = append(uniques, unique)
+		}
+		sort.Strings(uniques)
+	}
+	problemTypes := make(map[string]*ProblemType)
+	for _, unique := range uniques {
+		info := dotfile.Problems[unique]
+		problem := new(Problem)
+		mustGetObject(fmt.Sprintf("/problems/%d", info.ID), nil, problem)
+		fmt.Println()
+		fmt.Printf("problem %s: %s\n", problem.Unique, problem.Note)
+		fmt.Printf("  step %d, scores %v\n", info.Step, assignment.RawScores[problem.Unique])
+
+		problemType, exists := problemTypes[problem.ProblemType]
+		if !exists {
+			problemType = new(ProblemType)
+			mustGetObject(fmt.Sprintf("/problem_types/%s", problem.ProblemType), nil, problemType)
+			problemTypes[problem.ProblemType] = problemType
+		}
+		override := overridesByType[problem.ProblemType]
+		printProblemType(override.Apply(problemType), override)
+	}
+
+	announcements := []*Announcement{}
+	doRequest("/users/me/announcements", map[string]string{"assignment_id": strconv.FormatInt(assignment.ID, 10)}, "GET", nil, &announcements, true)
+	printAnnouncements(announcements)
+}
+
+func printProblemType(problemType *ProblemType, override *ProblemTypeOverride) {
+	set := "default"
+	if override != nil {
+		set = "set by course"
+	}
+	fmt.Printf("  problem type %s (%s)\n", problemType.Name, set)
+	fmt.Printf("    image:     %s\n", problemType.Image)
+	fmt.Printf("    limits:    cpu %ds, clock %ds, memory %dM, file size %dM, fds %d, threads %d\n",
+		problemType.MaxCPU, problemType.MaxClock, problemType.MaxMemory, problemType.MaxFileSize, problemType.MaxFD, problemType.MaxThreads)
+	if override != nil && len(override.Options) > 0 {
+		fmt.Printf("    options:   %s\n", strings.Join(override.Options, " "))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandStory(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	parts := strings.SplitN(args[1], "/", 2)
+	if len(parts) != 2 {
+		fatalf(exitUsage, "give the assignment as course/problem-set, not %q", args[1])
+	}
+	course := mustFindCourse(parts[0])
+	problemSet := mustFindCourseProblemSet(course, parts[1])
+	student, name := mustFindStudent(args[0])
+	problem := cmd.Flag("problem").Value.String()
+	diffs := cmd.Flag("diff").Value.String() == "true"
+
+	story := new(SubmissionStory)
+	mustGetObject(fmt.Sprintf("/courses/%d/problem_sets/%d/stories/%s", course.ID, problemSet.ID, student), nil, story)
+
+	shown := 0
+	for _, save := range story.Saves {
+		if problem != "" && save.Problem != problem {
+			continue
+		}
+		shown++
+		fmt.Printf("%s  %s step %d  %d file%s, %d bytes\n", save.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			save.Problem, save.Step, save.Files, plural(save.Files), save.Size)
+		fmt.Printf("    %s\n", save.Summary())
+		if !diffs {
+			continue
+		}
+		for _, change := range save.Changes {
+			fmt.Printf("    --- %s (%s)\n", change.Name, change.Change)
+			for _, line := range strings.Split(strings.TrimSuffix(change.Diff, "\n"), "\n") {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+	if shown == 0 {
+		fmt.Printf("%s has no saved work on %s\n", name, problemSet.Unique)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// maxArchiveSize is the most an unpacked submission archive may hold.
+const maxArchiveSize = 16 << 20
+
+func CommandSubmitArchive(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+	if len(args) != 2 {
+		usage(cmd)
+	}
+	archive := mustReadArchive(args[0])
+	if len(archive) == 0 {
+		fatalf(exitUsage, "%s does not contain any files", args[0])
+	}
+	assignment := mustFindAssignment(args[1])
+
+	// find the problems on the student's path
+	problemSetProblems := []*ProblemSetProblem{}
+	mustGetObject(fmt.Sprintf("/problem_sets/%d/problems", assignment.ProblemSetID), nil, &problemSetProblems)
+	var problems []*Problem
+	for _, elt := range problemSetProblems {
+		if !assignment.OnPath(elt.Track) {
+			continue
+		}
+		problem := new(Problem)
+		mustGetObject(fmt.Sprintf("/problems/%d", elt.ProblemID), nil, problem)
+		problems = append(problems, problem)
+	}
+	if only := cmd.Flag("problem").Value.String(); only != "" {
+		var found *Problem
+		for _, problem := range problems {
+			if problem.Unique == only {
+				found = problem
+			}
+		}
+		if found == nil {
+			fatalf(exitUsage, "problem %s is not part of this assignment", only)
+		}
+		problems = []*Problem{found}
+	} else if len(problems) > 1 {
+		// an archive holds the work for a single problem
+		var uniques []string
+		for _, problem := range problems {
+			uniques = append(uniques, problem.Unique)
+		}
+		errorLog.Printf("this problem set has more than one problem: %s", strings.Join(uniques, ", "))
+		fatalf(exitUsage, "use --problem to say which one the archive holds")
+	}
+	problem := problems[0]
+
+	// the files accepted are the same ones "grind get" would have set up
+	commit := new(Commit)
+	step := int64(1)
+	whitelist := make(map[string]bool)
+	if getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", assignment.ID, problem.ID), nil, commit) {
+		mustVerifyCommit(commit)
+		step = commit.Step
+		for name := range commit.Files {
+			whitelist[name] = true
+		}
+	}
+	problemStep := mustGetProblemStep(problem, step)
+	for name := range problemStep.Files {
+		if dir, _ := filepath.Split(name); dir == "" {
+			whitelist[name] = true
+		}
+	}
+
+	files := mustMatchArchive(archive, whitelist)
+	commit = &Commit{
+		AssignmentID: assignment.ID,
+		ProblemID:    problem.ID,
+		Step:         step,
+		Files:        files,
+		ClientTime:   now,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if mustSubmitSealed(problem, commit) {
+		return
+	}
+
+	if cmd.Flag("save").Value.String() == "true" {
+		commit.Note = "saving from an archive with grind tool"
+		commit.AddChecksums()
+		signed := new(CommitBundle)
+		mustPostObject("/commit_bundles/unsigned", nil, &CommitBundle{Commit: commit}, signed)
+		mustVerifyCommit(signed.Commit)
+		log.Printf("problem %s step %d saved from %s", problem.Unique, step, args[0])
+		return
+	}
+
+	commit.Action = "grade"
+	commit.Note = "grading from an archive with grind tool"
+	commit.AddChecksums()
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	saved, queued := gradeCommit(user.ID, &CommitBundle{Commit: commit}, problem, false)
+	if queued != nil {
+		return
+	}
+	if saved.ReportCard != nil && saved.ReportCard.Passed && saved.Score == 1.0 {
+		log.Printf("step %d passed", saved.Step)
+		log.Printf("use \"grind get %d\" to download the next step", assignment.ID)
+		return
+	}
+	reportFailure(saved)
+	os.Exit(exitFailed)
+}
+
+// mustReadArchive unpacks a zip or gzipped tar file in memory, returning its regular files
+// by slash-separated path. Hidden files and directories, such as .git and the
+// __MACOSX folders added by macOS, are left out.
+func mustReadArchive(name string) map[string][]byte {
+	raw, err := ioutil.ReadFile(name)
+	if err != nil {
+		fatalf(exitUsage, "error reading %s: %v", name, err)
+	}
+	files := make(map[string][]byte)
+	total := 0
+	add := func(entry string, r io.Reader) {
+		entry = path.Clean(strings.TrimPrefix(filepath.ToSlash(entry), "/"))
+		if entry == ".." || strings.HasPrefix(entry, "../") {
+			fatalf(exitUsage, "%s contains a file outside the archive: %s", name, entry)
+		}
+		for _, part := range strings.Split(entry, "/") {
+			if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+				return
+			}
+		}
+		contents, err := ioutil.ReadAll(io.LimitReader(r, maxArchiveSize+1))
+		if err != nil {
+			fatalf(exitUsage, "error reading %s from %s: %v", entry, name, err)
+		}
+		if total += len(contents); total > maxArchiveSize {
+			fatalf(exitUsage, "%s holds more than %dM of files", name, maxArchiveSize>>20)
+		}
+		files[entry] = contents
+	}
+
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		reader, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+		if err != nil {
+			fatalf(exitUsage, "error reading %s: %v", name, err)
+		}
+		for _, f := range reader.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				fatalf(exitUsage, "error reading %s from %s: %v", f.Name, name, err)
+			}
+			add(f.Name, r)
+			r.Close()
+		}
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			fatalf(exitUsage, "error reading %s: %v", name, err)
+		}
+		reader := tar.NewReader(gz)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fatalf(exitUsage, "error reading %s: %v", name, err)
+			}
+			if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+				add(header.Name, reader)
+			}
+		}
+	default:
+		fatalf(exitUsage, "%s must be a .zip, .tar.gz, or .tgz file", name)
+	}
+	return files
+}
+
+// mustMatchArchive picks the expected files out of an archive. A file at the top of
+// the archive, or inside a single folder that holds everything else, is used first;
+// otherwise a file of the same name anywhere in the archive is accepted if there is only one.
+func mustMatchArchive(archive map[string][]byte, whitelist map[string]bool) map[string]string {
+	// many archives wrap everything in one folder named for the project
+	prefix := ""
+	for entry := range archive {
+		dir := strings.SplitN(entry, "/", 2)[0] + "/"
+		if !strings.Contains(entry, "/") || (prefix != "" && prefix != dir) {
+			prefix = ""
+			break
+		}
+		prefix = dir
+	}
+
+	var names []string
+	for name := range whitelist {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make(map[string]string)
+	used := make(map[string]bool)
+	var missing []string
+	for _, name := range names {
+		if contents, exists := archive[prefix+name]; exists {
+			files[name] = string(contents)
+			used[prefix+name] = true
+			continue
+		}
+		var found []string
+		for entry := range archive {
+			if path.Base(entry) == name {
+				found = append(found, entry)
+			}
+		}
+		switch len(found) {
+		case 0:
+			missing = append(missing, name)
+		case 1:
+			log.Printf("using %s for %s", found[0], name)
+			files[name] = string(archive[found[0]])
+			used[found[0]] = true
+		default:
+			sort.Strings(found)
+			fatalf(exitUsage, "the archive has more than one %s: %s", name, strings.Join(found, ", "))
+		}
+	}
+	if len(missing) > 0 {
+		errorLog.Printf("the archive does not have every file the problem expects")
+		for _, name := range missing {
+			errorLog.Printf("  %s not found", name)
+		}
+		fatalf(exitUsage, "all expected files must be present")
+	}
+
+	var skipped []string
+	for entry := range archive {
+		if !used[entry] {
+			skipped = append(skipped, entry)
+		}
+	}
+	sort.Strings(skipped)
+	for _, entry := range skipped {
+		log.Printf("skipping %q which is not a file introduced by the problem", entry)
+	}
+	return files
+}
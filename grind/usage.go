@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCourseUsage(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	report := new(CourseUsage)
+	mustGetObject(fmt.Sprintf("/courses/%d/usage", course.ID), usageParams(cmd), report)
+	printUsage(report)
+	if help := report.Help; help != nil {
+		fmt.Printf("office hours: %d request%s, %d resolved, %d withdrawn, %d waiting now\n",
+			help.Requests, plural(int(help.Requests)), help.Resolved, help.Withdrawn, help.Waiting)
+		fmt.Printf("    wait %.0f minutes on average, %.0f at most; %.0f minutes helping on average\n",
+			help.MeanWaitMinutes, help.MaxWaitMinutes, help.MeanHelpMinutes)
+	}
+	for _, warning := range report.Warnings {
+		log.Printf("warning: %s", warning)
+	}
+}
+
+func CommandAdminQuota(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	course := mustFindCourse(args[0])
+	path := fmt.Sprintf("/courses/%d/quota", course.ID)
+	if cmd.Flag("remove").Value.String() == "true" {
+		doRequest(path, nil, "DELETE", nil, nil, false)
+		log.Printf("%s no longer has a quota", course.Name)
+		return
+	}
+	quota := new(CourseQuota)
+	var err error
+	if quota.GradingMinutes, err = strconv.ParseFloat(cmd.Flag("minutes").Value.String(), 64); err != nil {
+		fatalf(exitUsage, "minutes must be a number")
+	}
+	if quota.StorageMB, err = strconv.ParseFloat(cmd.Flag("storage").Value.String(), 64); err != nil {
+		fatalf(exitUsage, "storage must be a number of megabytes")
+	}
+	mustPutObject(path, nil, quota, nil)
+
+	report := new(CourseUsage)
+	mustGetObject(fmt.Sprintf("/courses/%d/usage", course.ID), nil, report)
+	printUsage(report)
+	for _, warning := range report.Warnings {
+		log.Printf("warning: %s", warning)
+	}
+}
+
+func CommandAdminUsage(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		usage(cmd)
+	}
+	report := []*CourseUsage{}
+	mustGetObject("/usage", usageParams(cmd), &report)
+
+	if cmd.Flag("csv").Value.String() == "true" {
+		out := csv.NewWriter(os.Stdout)
+		out.Write([]string{"course_id", "course", "gradings", "grading_minutes", "storage_mb", "quota_minutes", "quota_mb"})
+		for _, elt := range report {
+			quotaMinutes, quotaMB := "", ""
+			if elt.Quota != nil {
+				quotaMinutes = strconv.FormatFloat(elt.Quota.GradingMinutes, 'f', 0, 64)
+				quotaMB = strconv.FormatFloat(elt.Quota.StorageMB, 'f', 0, 64)
+			}
+			out.Write([]string{
+				strconv.FormatInt(elt.CourseID, 10),
+				elt.Name,
+				strconv.FormatInt(elt.Gradings, 10),
+				strconv.FormatFloat(elt.GradingMinutes, 'f', 1, 64),
+				strconv.FormatFloat(elt.StorageMB, 'f', 1, 64),
+				quotaMinutes,
+				quotaMB,
+			})
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			fatalf(exitFailed, "error writing CSV: %v", err)
+		}
+		return
+	}
+
+	for _, elt := range report {
+		if elt.Gradings == 0 && elt.StorageMB < 1.0 && elt.Quota == nil {
+			continue
+		}
+		printUsage(elt)
+		for _, warning := range elt.Warnings {
+			fmt.Printf("    warning: %s\n", warning)
+		}
+	}
+}
+
+// usageParams gathers the date range for a usage report.
+func usageParams(cmd *cobra.Command) map[string]string {
+	params := make(map[string]string)
+	for _, name := range []string{"from", "to"} {
+		if value := cmd.Flag(name).Value.String(); value != "" {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+func printUsage(report *CourseUsage) {
+	minutes := fmt.Sprintf("%.1f", report.GradingMinutes)
+	storage := fmt.Sprintf("%.1f", report.StorageMB)
+	if report.Quota != nil && report.Quota.GradingMinutes > 0 {
+		minutes += fmt.Sprintf(" of %.0f", report.Quota.GradingMinutes)
+	}
+	if report.Quota != nil && report.Quota.StorageMB > 0 {
+		storage += fmt.Sprintf(" of %.0f", report.Quota.StorageMB)
+	}
+	fmt.Printf("%s: %d grading run%s, %s grading minutes, %s MB stored\n",
+		report.Name, report.Gradings, plural(int(report.Gradings)), minutes, storage)
+}
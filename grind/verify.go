@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandVerify(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	// find the directory
+	dir := ""
+	switch len(args) {
+	case 0:
+		dir = "."
+	case 1:
+		dir = args[0]
+	default:
+		usage(cmd)
+	}
+
+	var problems []*Problem
+	var commits []*Commit
+	var dotfile *DotFileInfo
+	if cmd.Flag("all").Value.String() == "true" {
+		problems, commits, _, dotfile = gatherAll(now, dir)
+	} else {
+		problem, _, commit, df := gather(now, dir)
+		problems, commits, dotfile = []*Problem{problem}, []*Commit{commit}, df
+	}
+
+	mismatches := 0
+	for i, local := range commits {
+		problem := problems[i]
+
+		// get the last commit saved for this step
+		saved := new(Commit)
+		if !getObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits/last", dotfile.AssignmentID, problem.ID, local.Step), nil, saved) {
+			log.Printf("%s step %d: nothing has been saved yet", problem.Unique, local.Step)
+			mismatches++
+			continue
+		}
+		mustVerifyCommit(saved)
+
+		var names []string
+		for name := range local.Files {
+			names = append(names, name)
+		}
+		for name := range saved.Files {
+			if _, exists := local.Files[name]; !exists {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		var problemsFound []string
+		for _, name := range names {
+			mine, isLocal := local.Files[name]
+			theirs, isSaved := saved.Files[name]
+			switch {
+			case !isSaved:
+				problemsFound = append(problemsFound, fmt.Sprintf("  %s has not been saved", name))
+			case !isLocal:
+				problemsFound = append(problemsFound, fmt.Sprintf("  %s is missing locally", name))
+			case FileChecksum(FixLineEndings(mine)) != FileChecksum(theirs):
+				problemsFound = append(problemsFound, fmt.Sprintf("  %s differs from the saved copy", name))
+			}
+		}
+		if len(problemsFound) == 0 {
+			log.Printf("%s step %d: all %d file%s match the copy saved at %s",
+				problem.Unique, local.Step, len(names), plural(len(names)), saved.UpdatedAt.Local().Format("Jan 2 15:04"))
+			continue
+		}
+		log.Printf("%s step %d: local files do not match the copy saved at %s",
+			problem.Unique, local.Step, saved.UpdatedAt.Local().Format("Jan 2 15:04"))
+		for _, line := range problemsFound {
+			log.Print(line)
+		}
+		mismatches++
+	}
+
+	if mismatches > 0 {
+		fatalf(exitFailed, "use \"grind save\" to save your current work")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandWatch(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		usage(cmd)
+	}
+	shareID := strings.ToUpper(strings.TrimSpace(args[0]))
+
+	headers := make(http.Header)
+	headers.Set(TraceParentHeader, traceParent)
+	url := "wss://" + Config.Host + "/v2/shares/" + shareID
+	socket, resp, err := websocket.DefaultDialer.Dial(url, headers)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			fatalf(exitUsage, "shared session %s not found; it may have ended or expired", shareID)
+		}
+		if resp != nil && resp.Body != nil {
+			mustReportAPIError(url, resp)
+		}
+		fatalf(exitNetwork, "unable to reach the grading service at %s: %v", url, err)
+	}
+	defer socket.Close()
+	log.Printf("watching shared session %s; lines you type go to the program only if the student allows it", shareID)
+
+	// pass along anything typed here
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				if err := socket.WriteJSON(&DaycareRequest{Stdin: line}); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		reply := new(DaycareResponse)
+		if err := socket.ReadJSON(reply); err != nil {
+			if closed, ok := err.(*websocket.CloseError); ok && closed.Text != "" {
+				log.Printf("%s", closed.Text)
+			} else {
+				log.Printf("the session is no longer shared")
+			}
+			return
+		}
+
+		switch {
+		case reply.Error != "":
+			errorLog.Printf("%s", reply.Error)
+
+		case reply.Event != nil:
+			switch reply.Event.Event {
+			case "exec":
+				color.Cyan("$ %s\n", strings.Join(reply.Event.ExecCommand, " "))
+			case "stdin":
+				color.Yellow("%s", reply.Event.StreamData)
+			case "stdout":
+				color.White("%s", reply.Event.StreamData)
+			case "stderr":
+				color.Red("%s", reply.Event.StreamData)
+			case "exit":
+				color.Cyan("exit: %s\n", reply.Event.ExitStatus)
+			case "error":
+				color.Red("Error: %s\n", reply.Event.Error)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files are kept where the XDG base directory convention says, so that shared
+// machines can put caches and state on local disk instead of a mounted home directory.
+const (
+	xdgSubdir        = "codegrinder"  // under each of the XDG directories
+	configFileName   = "config.json"  // in the config directory
+	errorLogFileName = "grind.log"    // in the cache directory
+	stateSubdir      = "problem-sets" // in the state directory, when the state setting is home
+	maxErrorLogSize  = 256 << 10      // grind.log starts over once it is larger than this
+)
+
+// Settings for the state option in the config file, which says where grind keeps
+// what it knows about each problem set, such as the step and the expected files.
+const (
+	stateDotFile = "dotfile" // in a dotfile in the problem set directory (the default)
+	stateHome    = "home"    // in the state directory, named for the problem set directory's absolute path
+)
+
+// homeDir returns the user's home directory.
+func homeDir() (string, error) {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	if home == "" {
+		return "", fmt.Errorf("unable to locate home directory")
+	}
+	return home, nil
+}
+
+// xdgDir returns the codegrinder directory under the directory named by an
+// XDG environment variable, or under fallback in the home directory if it is not set.
+func xdgDir(variable, fallback string) (string, error) {
+	if dir := os.Getenv(variable); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(dir, xdgSubdir), nil
+	}
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, filepath.FromSlash(fallback), xdgSubdir), nil
+}
+
+func mustFindDir(variable, fallback string) string {
+	dir, err := xdgDir(variable, fallback)
+	if err != nil {
+		fatalf(exitUsage, "%v, giving up", err)
+	}
+	return dir
+}
+
+// mustFindConfigFile returns the path of the config file, moving the config file
+// from where older versions of grind kept it if necessary.
+func mustFindConfigFile() string {
+	path := filepath.Join(mustFindDir("XDG_CONFIG_HOME", ".config"), configFileName)
+	if _, err := os.Stat(path); err == nil || !os.IsNotExist(err) {
+		return path
+	}
+	home, err := homeDir()
+	if err != nil {
+		return path
+	}
+	legacy := filepath.Join(home, perUserDotFile)
+	raw, err := ioutil.ReadFile(legacy)
+	if err != nil {
+		return path
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+		fatalf(exitUsage, "error writing %s: %v", path, err)
+	}
+	if err := os.Remove(legacy); err != nil {
+		fatalf(exitUsage, "error removing %s after copying it to %s: %v", legacy, path, err)
+	}
+	log.Printf("moved your settings from %s to %s", legacy, path)
+	return path
+}
+
+// errorLogFile copies everything errorLog reports to grind.log in the cache directory,
+// so there is a record to share when asking for help. The file is only opened when
+// something goes wrong, and each run starts with a line giving the date and command.
+// Failing to write the log is not itself an error.
+type errorLogFile struct {
+	file   *os.File
+	failed bool
+}
+
+func (f *errorLogFile) Write(p []byte) (int, error) {
+	if f.file == nil && !f.failed {
+		f.failed = true
+		dir, err := xdgDir("XDG_CACHE_HOME", ".cache")
+		if err != nil {
+			return len(p), nil
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return len(p), nil
+		}
+		path := filepath.Join(dir, errorLogFileName)
+		flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if stat, err := os.Stat(path); err == nil && stat.Size() > maxErrorLogSize {
+			flags |= os.O_TRUNC
+		}
+		file, err := os.OpenFile(path, flags, 0600)
+		if err != nil {
+			return len(p), nil
+		}
+		fmt.Fprintf(file, "--- %s %s\n", time.Now().Format("2006-01-02 15:04:05"), strings.Join(os.Args, " "))
+		f.file, f.failed = file, false
+	}
+	if f.file != nil {
+		f.file.Write(p)
+	}
+	return len(p), nil
+}
+
+// dotFilePath returns where the state for the problem set in dir belongs:
+// in the directory itself, or in the state directory if the state setting is home.
+func dotFilePath(dir string) string {
+	if Config.State != stateHome {
+		return filepath.Join(dir, perProblemSetDotFile)
+	}
+	return stateFilePath(dir)
+}
+
+// stateFilePath returns the path in the state directory for the problem set in dir,
+// named for a hash of its absolute path.
+func stateFilePath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		fatalf(exitUsage, "error finding absolute path of %s: %v", dir, err)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(mustFindDir("XDG_STATE_HOME", ".local/state"), stateSubdir, hex.EncodeToString(sum[:12])+".json")
+}
+
+// existingDotFile returns the path of the state for the problem set in dir, or
+// an empty string if dir does not hold a problem set. State found where the state
+// setting does not say it belongs is moved there.
+func existingDotFile(dir string) string {
+	want := dotFilePath(dir)
+	if _, err := os.Stat(want); err == nil {
+		return want
+	} else if !os.IsNotExist(err) {
+		fatalf(exitUsage, "error checking for %s: %v", want, err)
+	}
+	other := filepath.Join(dir, perProblemSetDotFile)
+	if other == want {
+		other = stateFilePath(dir)
+	}
+	raw, err := ioutil.ReadFile(other)
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		fatalf(exitUsage, "error reading %s: %v", other, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(want), 0755); err != nil {
+		fatalf(exitUsage, "error creating directory %s: %v", filepath.Dir(want), err)
+	}
+	if err := ioutil.WriteFile(want, raw, 0644); err != nil {
+		fatalf(exitUsage, "error saving file %s: %v", want, err)
+	}
+	if err := os.Remove(other); err != nil {
+		fatalf(exitUsage, "error removing %s after copying it to %s: %v", other, want, err)
+	}
+	log.Printf("moved the state for %s to %s", dir, want)
+	return want
+}
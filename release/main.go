@@ -0,0 +1,138 @@
+// Command release cross-compiles the CodeGrinder release binaries.
+// Run it from the top of the repository:
+//
+//	go run ./release [-o DIR]
+//
+// The server is built for Linux on amd64 and arm64 (including Raspberry Pi
+// labs), grind is built for Linux and Windows, and the native runner agent
+// is built for Windows and macOS. Everything the server needs at runtime,
+// including the database schema and web files, is compiled in, so each
+// binary can be copied to a machine on its own.
+//
+// Builds are reproducible: cgo is off, file paths and build IDs are stripped,
+// and the same source and Go version always give the same bytes. SHA256SUMS
+// lists the checksum of every file so others can check a release by building
+// it themselves.
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/russross/codegrinder/types"
+)
+
+type target struct {
+	Command string
+	GOOS    string
+	GOARCH  string
+}
+
+var targets = []target{
+	{"codegrinder", "linux", "amd64"},
+	{"codegrinder", "linux", "arm64"},
+	{"grind", "linux", "amd64"},
+	{"grind", "linux", "arm64"},
+	{"grind", "windows", "amd64"},
+	{"runner", "windows", "amd64"},
+	{"runner", "darwin", "amd64"},
+	{"runner", "darwin", "arm64"},
+}
+
+func (t target) String() string {
+	return fmt.Sprintf("%s %s/%s", t.Command, t.GOOS, t.GOARCH)
+}
+
+// filename is the name of the binary in the release directory, e.g., grind-1.9.0-windows-amd64.exe.
+func (t target) filename() string {
+	name := fmt.Sprintf("%s-%s-%s-%s", t.Command, types.CurrentVersion.Version, t.GOOS, t.GOARCH)
+	if t.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func main() {
+	log.SetFlags(0)
+	var dist string
+	flag.StringVar(&dist, "o", "dist", "Directory to put the release in, under a subdirectory named for the version")
+	flag.Parse()
+	if flag.NArg() != 0 {
+		log.Fatalf("usage: go run ./release [-o DIR]")
+	}
+	for _, dir := range []string{"codegrinder", "grind"} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			log.Fatalf("run this from the top of the codegrinder repository")
+		}
+	}
+
+	out := filepath.Join(dist, types.CurrentVersion.Version)
+	if err := os.MkdirAll(out, 0755); err != nil {
+		log.Fatalf("error creating %s: %v", out, err)
+	}
+
+	var sums []string
+	failed := 0
+	for _, t := range targets {
+		log.Printf("building %v", t)
+		path := filepath.Join(out, t.filename())
+		if err := build(t, path); err != nil {
+			log.Printf("error building %v: %v", t, err)
+			failed++
+			continue
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			log.Fatalf("error reading %s: %v", path, err)
+		}
+		sums = append(sums, fmt.Sprintf("%x  %s\n", sum, t.filename()))
+	}
+
+	sumsPath := filepath.Join(out, "SHA256SUMS")
+	if err := os.WriteFile(sumsPath, []byte(strings.Join(sums, "")), 0644); err != nil {
+		log.Fatalf("error writing %s: %v", sumsPath, err)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d builds failed", failed, len(targets))
+	}
+	log.Printf("release %s is in %s", types.CurrentVersion.Version, out)
+}
+
+// build compiles one target. Only the settings that affect the output are
+// passed on from the environment, so the result does not depend on who runs it.
+func build(t target, path string) error {
+	cmd := exec.Command("go", "build",
+		"-trimpath",
+		"-ldflags", "-s -w -buildid=",
+		"-o", path,
+		"./"+t.Command)
+	cmd.Env = append(os.Environ(),
+		"CGO_ENABLED=0",
+		"GOOS="+t.GOOS,
+		"GOARCH="+t.GOARCH,
+		"GOFLAGS=",
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func sha256File(path string) ([]byte, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,360 @@
+// Command runner is the runner agent. It grades problem types that cannot run in a
+// Linux container, such as C# WinForms on Windows or Swift on macOS, or that need
+// hardware such as an FPGA board or a GPU, using what is on the machine it runs on:
+//
+//	runner -daycare daycare.example.edu -token-file FILE -user ACCOUNT [-name NAME] [-platform PLATFORM] [-capability CAP]... [-env VAR]...
+//
+// The token comes from "codegrinder runner-token NAME" on the daycare and only works
+// with that name. Put it in a file only the agent's account can read; it is never
+// taken from the command line or the environment, where student code could see it.
+// Each -capability advertises something attached to this machine, and the daycare
+// only sends it problem types whose requirements it meets.
+//
+// The agent connects to the daycare and waits for work. There is no container around
+// student code, so grading commands run as ACCOUNT, which must not be the agent's own,
+// with only a few variables from the agent's environment plus any named with -env.
+// On Linux and macOS the agent runs as root so it can switch to ACCOUNT, and each
+// command gets its own process group; anything still running as ACCOUNT after a
+// command finishes is killed. On Windows the agent runs as a service that can start
+// processes as another user, ACCOUNT's password is read from -password-file, and each
+// command runs in a job object it cannot leave. Each grading run gets a fresh working
+// directory that is deleted afterward. Use a machine that is used for nothing else.
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+const maxBackoff = time.Minute
+
+// names collects repeated -env flags.
+type names []string
+
+func (n *names) String() string { return strings.Join(*n, ",") }
+
+func (n *names) Set(value string) error {
+	*n = append(*n, value)
+	return nil
+}
+
+// capabilities collects repeated -capability flags.
+type capabilities []string
+
+func (c *capabilities) String() string { return strings.Join(*c, ",") }
+
+func (c *capabilities) Set(value string) error {
+	*c = append(*c, strings.ToLower(value))
+	return nil
+}
+
+func main() {
+	hostname, _ := os.Hostname()
+	platform := runtime.GOOS
+	if platform == "darwin" {
+		platform = "macos"
+	}
+	var daycare, tokenFile, name, root, account, passwordFile string
+	var caps capabilities
+	var passEnv names
+	flag.StringVar(&daycare, "daycare", "", "Host of the daycare to take work from")
+	flag.StringVar(&tokenFile, "token-file", "", "File holding the token from codegrinder runner-token on the daycare")
+	flag.StringVar(&account, "user", "", "Account to run grading commands as")
+	flag.StringVar(&passwordFile, "password-file", "", "File holding the password for -user (Windows only)")
+	flag.Var(&passEnv, "env", "Environment variable to pass to grading commands (may be repeated)")
+	flag.StringVar(&name, "name", hostname, "Name of this runner, which the token was made for")
+	flag.StringVar(&platform, "platform", platform, "Platform this runner grades for, matching the platform of problem types")
+	flag.Var(&caps, "capability", "Capability to advertise, matching what problem types require (may be repeated)")
+	flag.StringVar(&root, "dir", os.TempDir(), "Directory to make working directories in")
+	flag.Parse()
+	if daycare == "" || tokenFile == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	raw, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		log.Fatalf("error reading token: %v", err)
+	}
+	token := strings.TrimSpace(string(raw))
+	box, err := newSandbox(account, passwordFile)
+	if err != nil {
+		log.Fatalf("error setting up to run grading commands: %v", err)
+	}
+	env := []string{}
+	for _, key := range append(inheritEnv, passEnv...) {
+		if value, present := os.LookupEnv(key); present {
+			env = append(env, key+"="+value)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("name", name)
+	u := (&url.URL{Scheme: "wss", Host: daycare, Path: RunnerPath, RawQuery: params.Encode()}).String()
+	header := http.Header{RunnerTokenHeader: {token}}
+	hello := &RunnerMessage{Op: RunnerHello, Platform: platform, Capabilities: caps}
+
+	// keep reconnecting, backing off while the daycare is down
+	backoff := time.Second
+	for {
+		start := time.Now()
+		socket, _, err := websocket.DefaultDialer.Dial(u, header)
+		if err != nil {
+			log.Printf("error connecting to %s: %v", daycare, err)
+		} else {
+			log.Printf("connected to %s as %s runner %s", daycare, platform, name)
+			a := &agent{socket: socket, root: root, sandbox: box, baseEnv: env}
+			if err = a.send(hello); err == nil {
+				err = a.serve()
+			}
+			a.cleanup()
+			socket.Close()
+			log.Printf("disconnected from %s: %v", daycare, err)
+		}
+		if time.Since(start) > maxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// agent runs the work for one connection to the daycare, one grading run at a time.
+type agent struct {
+	socket    *websocket.Conn
+	writeLock sync.Mutex
+	root      string
+	sandbox   *sandbox
+	baseEnv   []string
+
+	dir     string
+	env     []string
+	timeout time.Duration
+
+	lock  sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func (a *agent) send(msg *RunnerMessage) error {
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+	return a.socket.WriteJSON(msg)
+}
+
+func (a *agent) fail(err error) error {
+	return a.send(&RunnerMessage{Op: RunnerError, Error: err.Error()})
+}
+
+func (a *agent) serve() error {
+	for {
+		msg := new(RunnerMessage)
+		if err := a.socket.ReadJSON(msg); err != nil {
+			return err
+		}
+		var err error
+		switch msg.Op {
+		case RunnerStart:
+			a.cleanup()
+			a.env = msg.Env
+			a.timeout = time.Duration(msg.Timeout) * time.Second
+			if a.dir, err = ioutil.TempDir(a.root, "codegrinder-"); err == nil {
+				if err = a.sandbox.own(a.dir); err == nil {
+					err = a.send(&RunnerMessage{Op: RunnerReady})
+				}
+			}
+		case RunnerPut:
+			if err = a.put(msg.Files); err == nil {
+				err = a.send(&RunnerMessage{Op: RunnerReady})
+			}
+		case RunnerGet:
+			var files map[string]string
+			if files, err = a.get(msg.Names); err == nil {
+				err = a.send(&RunnerMessage{Op: RunnerFiles, Files: files})
+			}
+		case RunnerExec:
+			err = a.exec(msg.Cmd)
+		case RunnerStdin, RunnerEOF:
+			a.lock.Lock()
+			if a.stdin != nil {
+				if msg.Op == RunnerStdin {
+					io.WriteString(a.stdin, msg.Data)
+				} else {
+					a.stdin.Close()
+				}
+			}
+			a.lock.Unlock()
+		case RunnerKill:
+			a.kill()
+		case RunnerRemove:
+			a.cleanup()
+			err = a.send(&RunnerMessage{Op: RunnerReady})
+		default:
+			err = errors.New("unknown operation " + msg.Op)
+		}
+		if err != nil {
+			if err := a.fail(err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// path turns a file name from the daycare into a path in the working directory.
+func (a *agent) path(name string) (string, error) {
+	if a.dir == "" {
+		return "", errors.New("no grading run has started")
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.New("file name " + name + " is outside the working directory")
+	}
+	return filepath.Join(a.dir, clean), nil
+}
+
+func (a *agent) put(files map[string]string) error {
+	for name, contents := range files {
+		path, err := a.path(name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+	return a.sandbox.own(a.dir)
+}
+
+func (a *agent) get(names []string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, name := range names {
+		path, err := a.path(name)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files[name] = string(contents)
+	}
+	return files, nil
+}
+
+// output sends what a command writes to the daycare as it is written.
+type output struct {
+	a  *agent
+	op string
+}
+
+func (out output) Write(data []byte) (int, error) {
+	if err := out.a.send(&RunnerMessage{Op: out.op, Data: string(data)}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (a *agent) exec(args []string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.dir == "" {
+		return errors.New("no grading run has started")
+	}
+	if a.cmd != nil {
+		return errors.New("a command is already running")
+	}
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = a.dir
+	cmd.Env = append([]string{}, a.baseEnv...)
+	cmd.Env = append(cmd.Env, "HOME="+a.dir, "TMPDIR="+a.dir, "TEMP="+a.dir, "TMP="+a.dir)
+	cmd.Env = append(cmd.Env, a.env...)
+	a.sandbox.prepare(cmd)
+	cmd.Stdout = output{a: a, op: RunnerStdout}
+	cmd.Stderr = output{a: a, op: RunnerStderr}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := a.sandbox.started(cmd); err != nil {
+		cmd.Wait()
+		return err
+	}
+	a.cmd, a.stdin = cmd, stdin
+
+	go func() {
+		// there is no container, so the time limit is enforced here
+		var timer *time.Timer
+		if a.timeout > 0 {
+			timer = time.AfterFunc(a.timeout, func() {
+				a.send(&RunnerMessage{Op: RunnerStderr, Data: "\ntime limit exceeded\n"})
+				a.sandbox.kill(cmd)
+			})
+		}
+		cmd.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+		a.lock.Lock()
+		a.cmd, a.stdin = nil, nil
+		a.lock.Unlock()
+		a.send(&RunnerMessage{Op: RunnerExit, Status: cmd.ProcessState.ExitCode()})
+	}()
+	return nil
+}
+
+func (a *agent) kill() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.cmd != nil {
+		a.sandbox.kill(a.cmd)
+	}
+}
+
+// cleanup stops anything still running, including anything a command left
+// behind, and deletes the working directory.
+func (a *agent) cleanup() {
+	a.kill()
+	for i := 0; i < 50; i++ {
+		a.lock.Lock()
+		running := a.cmd != nil
+		a.lock.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	a.sandbox.sweep()
+	if a.dir != "" {
+		if err := os.RemoveAll(a.dir); err != nil {
+			log.Printf("error removing %s: %v", a.dir, err)
+		}
+		a.dir = ""
+	}
+}
@@ -0,0 +1,110 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// inheritEnv lists the variables from the agent's environment that grading
+// commands get. Anything else has to be passed with -env.
+var inheritEnv = []string{"PATH", "LANG", "TZ"}
+
+// sandbox runs grading commands as a separate account, each in its own process
+// group, so student code cannot read the agent's token or signal the agent, and
+// anything it leaves running can be found and killed.
+type sandbox struct {
+	uid, gid uint32
+	groups   []uint32
+}
+
+// newSandbox sets up to run commands as the named account. The agent must be
+// able to switch to it, which normally means running as root.
+func newSandbox(account, passwordFile string) (*sandbox, error) {
+	if account == "" {
+		return nil, errors.New("-user must name the account to run grading commands as")
+	}
+	if passwordFile != "" {
+		return nil, errors.New("-password-file is only used on Windows")
+	}
+	u, err := user.Lookup(account)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	if uid == 0 {
+		return nil, errors.New("grading commands must not run as root")
+	}
+	if uid == uint64(os.Getuid()) {
+		return nil, errors.New("grading commands must run as a different account than the agent")
+	}
+	s := &sandbox{uid: uint32(uid), gid: uint32(gid)}
+
+	// keep the account's own groups, such as one that can use a serial port
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		id, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		s.groups = append(s.groups, uint32(id))
+	}
+	return s, nil
+}
+
+func (s *sandbox) attr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setpgid:    true,
+		Credential: &syscall.Credential{Uid: s.uid, Gid: s.gid, Groups: s.groups},
+	}
+}
+
+// prepare makes a command run as the grading account in a new process group.
+func (s *sandbox) prepare(cmd *exec.Cmd) {
+	cmd.SysProcAttr = s.attr()
+}
+
+// started is called once a command is running.
+func (s *sandbox) started(cmd *exec.Cmd) error {
+	return nil
+}
+
+// kill stops a command and everything else in its process group.
+func (s *sandbox) kill(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// sweep kills every process running as the grading account, including any
+// that left the process group, so nothing survives into the next run.
+func (s *sandbox) sweep() {
+	cmd := exec.Command("kill", "-KILL", "-1")
+	cmd.SysProcAttr = s.attr()
+	cmd.Run()
+}
+
+// own hands a working directory and everything in it to the grading account.
+func (s *sandbox) own(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, int(s.uid), int(s.gid))
+	})
+}
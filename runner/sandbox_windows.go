@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inheritEnv lists the variables from the agent's environment that grading
+// commands get. Anything else has to be passed with -env.
+var inheritEnv = []string{"PATH", "PATHEXT", "SystemRoot", "windir", "ComSpec", "NUMBER_OF_PROCESSORS", "PROCESSOR_ARCHITECTURE"}
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	advapi32                     = syscall.NewLazyDLL("advapi32.dll")
+	ntdll                        = syscall.NewLazyDLL("ntdll.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procLogonUserW               = advapi32.NewProc("LogonUserW")
+	procNtResumeProcess          = ntdll.NewProc("NtResumeProcess")
+)
+
+const (
+	jobObjectExtendedLimitInformation = 9
+	jobObjectLimitKillOnJobClose      = 0x2000
+	processSetQuota                   = 0x0100
+	processSuspendResume              = 0x0800
+	createSuspended                   = 0x00000004
+	logon32LogonBatch                 = 4
+	logon32ProviderDefault            = 0
+)
+
+// jobExtendedLimits is the JOBOBJECT_EXTENDED_LIMIT_INFORMATION structure.
+type jobExtendedLimits struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IOCounters              [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+// sandbox runs grading commands as a separate account inside a job object,
+// so student code cannot read the agent's token, and anything it starts can
+// be killed along with it. Processes cannot break away from the job, and
+// closing it kills them all if the agent itself exits.
+type sandbox struct {
+	token syscall.Token
+	job   syscall.Handle
+}
+
+// newSandbox logs on to the named account with the password in passwordFile.
+// The account needs the right to log on as a batch job, and the agent needs to
+// run as an account that can start processes as another user, such as
+// LocalSystem.
+func newSandbox(account, passwordFile string) (*sandbox, error) {
+	if account == "" || passwordFile == "" {
+		return nil, errors.New("-user and -password-file must name the account to run grading commands as")
+	}
+	raw, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	domain := "."
+	if i := strings.IndexByte(account, '\\'); i >= 0 {
+		domain, account = account[:i], account[i+1:]
+	}
+	userPtr, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return nil, err
+	}
+	domainPtr, err := syscall.UTF16PtrFromString(domain)
+	if err != nil {
+		return nil, err
+	}
+	passwordPtr, err := syscall.UTF16PtrFromString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, err
+	}
+	s := new(sandbox)
+	if ret, _, err := procLogonUserW.Call(uintptr(unsafe.Pointer(userPtr)), uintptr(unsafe.Pointer(domainPtr)), uintptr(unsafe.Pointer(passwordPtr)),
+		logon32LogonBatch, logon32ProviderDefault, uintptr(unsafe.Pointer(&s.token))); ret == 0 {
+		return nil, err
+	}
+
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		s.token.Close()
+		return nil, err
+	}
+	s.job = syscall.Handle(job)
+	limits := jobExtendedLimits{LimitFlags: jobObjectLimitKillOnJobClose}
+	if ret, _, err := procSetInformationJobObject.Call(job, jobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), unsafe.Sizeof(limits)); ret == 0 {
+		s.token.Close()
+		syscall.CloseHandle(s.job)
+		return nil, err
+	}
+	return s, nil
+}
+
+// prepare makes a command run as the grading account. It starts suspended
+// so it can be put in the job before it has a chance to start anything else.
+func (s *sandbox) prepare(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Token: s.token, CreationFlags: createSuspended}
+}
+
+// started puts a command in the job, so anything it starts is in the job too,
+// then lets it run.
+func (s *sandbox) started(cmd *exec.Cmd) error {
+	process, err := syscall.OpenProcess(processSetQuota|processSuspendResume|syscall.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	defer syscall.CloseHandle(process)
+	if ret, _, err := procAssignProcessToJobObject.Call(uintptr(s.job), uintptr(process)); ret == 0 {
+		cmd.Process.Kill()
+		return err
+	}
+	if status, _, _ := procNtResumeProcess.Call(uintptr(process)); status != 0 {
+		cmd.Process.Kill()
+		return syscall.Errno(status)
+	}
+	return nil
+}
+
+// kill stops a command and everything else in the job.
+func (s *sandbox) kill(cmd *exec.Cmd) {
+	s.sweep()
+}
+
+// sweep kills every process in the job, so nothing survives into the next run.
+func (s *sandbox) sweep() {
+	procTerminateJobObject.Call(uintptr(s.job), 1)
+}
+
+// own does nothing on Windows. The -dir directory must be one the grading
+// account can write to, and working directories inherit its permissions.
+func (s *sandbox) own(dir string) error {
+	return nil
+}
@@ -0,0 +1,227 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// CTFSpecFile describes the challenge for a capture-the-flag problem. It uses
+// the same format as TestSpecFile:
+//
+//	[ctf]
+//	target = python3 server.py
+//	startup = 1
+//	flag-file = vault/flag.txt
+//
+// Every student has a flag of their own, which actions marked flag find in
+// FlagFile. Before anything else runs, the flag is read in and removed, and the
+// target, if any, is started as TargetAccount with the flag in the FlagVariable
+// environment variable and, with flag-file, written to that file under the
+// account's home directory. Only the target gets the flag; the student's
+// exploit runs as the student and must get the flag out of the target, and the
+// student submits it in FlagSubmissionFile, where it is compared with the
+// expected flag by hash.
+//
+// Starting the target as another account takes an image with TargetAccount in
+// it and codegrinder-ctf installed setuid root, in a problem type marked
+// switchUser so the container keeps the capabilities to change accounts.
+const CTFSpecFile = "ctf.cfg"
+
+const (
+	// FlagSubmissionFile is where the student puts the flag they captured.
+	FlagSubmissionFile = "flag.txt"
+
+	// FlagVariable names the environment variable giving the flag to the target.
+	FlagVariable = "CODEGRINDER_FLAG"
+
+	// TargetAccount is the account the target runs as, apart from the student.
+	TargetAccount = "ctf"
+)
+
+// CTFOptions describes the target of a capture-the-flag challenge.
+type CTFOptions struct {
+	Target   string
+	Startup  float64 // seconds to wait for the target to start
+	FlagFile string  `gcfg:"flag-file"`
+	Timeout  int     // seconds an exploit may take when checking a solution
+}
+
+// CTFSpec is a parsed capture-the-flag specification.
+type CTFSpec struct {
+	CTF CTFOptions
+
+	target []string
+}
+
+// LoadCTFSpec reads a capture-the-flag specification, filling in defaults.
+// A missing file describes a challenge with no target.
+func LoadCTFSpec(path string) (*CTFSpec, error) {
+	spec := new(CTFSpec)
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		raw = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	spec.target = strings.Fields(spec.CTF.Target)
+	if spec.CTF.Startup == 0 {
+		spec.CTF.Startup = 1
+	}
+	if spec.CTF.Timeout == 0 {
+		spec.CTF.Timeout = 30
+	}
+	return spec, nil
+}
+
+// TakeFlag reads the student's flag and removes it from the disk.
+func TakeFlag() (string, error) {
+	raw, err := ioutil.ReadFile(FlagFile)
+	if err != nil {
+		return "", fmt.Errorf("the flag is missing; is the action marked flag? %v", err)
+	}
+	if err := os.Remove(FlagFile); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// StartChallenge starts this program again as TargetAccount to hide the flag
+// and run the target, then gives up the privileges it needed to do so.
+// The function it returns stops the target.
+func (spec *CTFSpec) StartChallenge(flag string) (func(), error) {
+	if spec.CTF.FlagFile == "" && len(spec.target) == 0 {
+		return func() {}, DropPrivileges()
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, "target")
+	cmd.Env = append(os.Environ(), FlagVariable+"="+flag)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := startAsTarget(cmd); err != nil {
+		return nil, fmt.Errorf("error starting the target: %v", err)
+	}
+	if err := DropPrivileges(); err != nil {
+		stdin.Close()
+		cmd.Wait()
+		return nil, err
+	}
+	time.Sleep(time.Duration(spec.CTF.Startup * float64(time.Second)))
+	return func() {
+		stdin.Close()
+		cmd.Wait()
+	}, nil
+}
+
+// RunTarget is the half of StartChallenge that runs as TargetAccount. It writes
+// the flag to the flag file and runs the target until its input is closed,
+// which also happens if the program that started it dies.
+func (spec *CTFSpec) RunTarget() error {
+	flag := os.Getenv(FlagVariable)
+	if flag == "" {
+		return fmt.Errorf("the target must be started by %s run or solve", os.Args[0])
+	}
+	if spec.CTF.FlagFile != "" {
+		account, err := user.Current()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(account.HomeDir, spec.CTF.FlagFile)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(flag+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+	if len(spec.target) == 0 {
+		_, err := io.Copy(ioutil.Discard, os.Stdin)
+		return err
+	}
+	cmd := exec.Command(spec.target[0], spec.target[1:]...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(ioutil.Discard, os.Stdin)
+		cmd.Process.Kill()
+	}()
+	cmd.Wait()
+	return nil
+}
+
+// CheckFlag compares the flag the student submitted with the expected flag.
+func CheckFlag(flag string) *ReportCard {
+	card := NewReportCard()
+	raw, err := ioutil.ReadFile(FlagSubmissionFile)
+	switch {
+	case os.IsNotExist(err):
+		card.AddFailedResult("flag", fmt.Sprintf("put the flag you captured in %s", FlagSubmissionFile), "")
+	case err != nil:
+		card.Failf("%v", err)
+	case strings.TrimSpace(string(raw)) == "":
+		card.AddFailedResult("flag", fmt.Sprintf("%s is empty", FlagSubmissionFile), FlagSubmissionFile)
+	case !FlagMatches(flag, string(raw)):
+		card.AddFailedResult("flag", "that is not your flag; every student's flag is different", FlagSubmissionFile)
+	default:
+		card.AddPassedResult("flag", "flag captured")
+	}
+	return card
+}
+
+// RunCTFSolution runs an exploit against the challenge and checks that it prints
+// the flag, for confirming that a problem's solution works.
+func RunCTFSolution(spec *CTFSpec, flag string, command []string) *ReportCard {
+	card := NewReportCard()
+	if len(command) == 0 {
+		card.Failf("no command to run")
+		return card
+	}
+	stop, err := spec.StartChallenge(flag)
+	if err != nil {
+		card.Failf("%v", err)
+		return card
+	}
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(spec.CTF.Timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	start := time.Now()
+	err = cmd.Run()
+	card.AddTime(time.Since(start))
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		card.AddFailedResult("exploit", fmt.Sprintf("still running after %d seconds\n%s", spec.CTF.Timeout, output.String()), "")
+	case strings.Contains(output.String(), flag):
+		card.AddPassedResult("exploit", "the exploit captured the flag")
+	case err != nil:
+		card.AddFailedResult("exploit", fmt.Sprintf("the exploit failed: %v\n%s", err, output.String()), "")
+	default:
+		card.AddFailedResult("exploit", "the exploit did not print the flag\n"+output.String(), "")
+	}
+	return card
+}
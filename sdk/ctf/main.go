@@ -0,0 +1,96 @@
+// Command ctf runs capture-the-flag problems described by sdk.CTFSpecFile.
+// Install it in a problem type's image as codegrinder-ctf and use it in
+// actions marked flag:
+//
+//	"command": ["/usr/local/bin/codegrinder-ctf", "run", "python3", "exploit.py"]
+//	"command": ["/usr/local/bin/codegrinder-ctf", "check"]
+//	"command": ["/usr/local/bin/codegrinder-ctf", "solve", "python3", "exploit.py"]
+//
+// run starts the challenge and runs the student's exploit against it
+// interactively. check grades the flag the student submitted. solve runs an
+// exploit and passes if it prints the flag, for confirming a problem's solution.
+//
+// It must be installed setuid root so it can start the target as
+// sdk.TargetAccount, which it does by running itself again in target mode.
+// Everything else runs as the student.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/russross/codegrinder/sdk"
+	. "github.com/russross/codegrinder/types"
+)
+
+func main() {
+	if len(os.Args) == 2 && os.Args[1] == "target" {
+		runTarget()
+		return
+	}
+	if len(os.Args) < 2 || (os.Args[1] != "check" && len(os.Args) < 3) {
+		fmt.Fprintf(os.Stderr, "Usage: %s run|solve COMMAND [ARGS...]\n       %s check\n", os.Args[0], os.Args[0])
+		os.Exit(2)
+	}
+	if err := sdk.ActAsStudent(); err != nil {
+		fail(err)
+	}
+
+	// nothing else runs until the flag is off the disk
+	flag, err := sdk.TakeFlag()
+	if err != nil {
+		fail(err)
+	}
+	spec, err := sdk.LoadCTFSpec(sdk.CTFSpecFile)
+	if err != nil {
+		fail(err)
+	}
+
+	var card *ReportCard
+	switch os.Args[1] {
+	case "run":
+		stop, err := spec.StartChallenge(flag)
+		if err != nil {
+			fail(err)
+		}
+		cmd := exec.Command(os.Args[2], os.Args[3:]...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = cmd.Run()
+		stop()
+		if exit, ok := err.(*exec.ExitError); ok {
+			os.Exit(exit.ExitCode())
+		} else if err != nil {
+			fail(err)
+		}
+		return
+	case "check":
+		card = sdk.CheckFlag(flag)
+	case "solve":
+		card = sdk.RunCTFSolution(spec, flag, os.Args[2:])
+	default:
+		fail(fmt.Errorf("unknown mode %q; use run, check, or solve", os.Args[1]))
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fail(fmt.Errorf("error writing report: %v", err))
+	}
+}
+
+// runTarget runs the target for StartChallenge as sdk.TargetAccount.
+func runTarget() {
+	if err := sdk.DropPrivileges(); err != nil {
+		fail(err)
+	}
+	spec, err := sdk.LoadCTFSpec(sdk.CTFSpecFile)
+	if err != nil {
+		fail(err)
+	}
+	if err := spec.RunTarget(); err != nil {
+		fail(fmt.Errorf("error running the target: %v", err))
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,52 @@
+package sdk
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// ActAsStudent switches a setuid codegrinder-ctf to the account that ran it,
+// keeping root as the saved user so it can still start the target.
+func ActAsStudent() error {
+	if os.Geteuid() != 0 || os.Getuid() == 0 {
+		return nil
+	}
+	return syscall.Setresuid(-1, os.Getuid(), -1)
+}
+
+// DropPrivileges switches to the account that ran this program for good.
+func DropPrivileges() error {
+	uid := os.Getuid()
+	return syscall.Setresuid(uid, uid, uid)
+}
+
+// startAsTarget starts a command as TargetAccount, which takes root for a moment.
+func startAsTarget(cmd *exec.Cmd) error {
+	account, err := user.Lookup(TargetAccount)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(account.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(account.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Setresuid(-1, 0, -1); err != nil {
+		return fmt.Errorf("%s must be installed setuid root to run the target as %s: %v", os.Args[0], TargetAccount, err)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	err = cmd.Start()
+	if err2 := ActAsStudent(); err == nil {
+		err = err2
+	}
+	return err
+}
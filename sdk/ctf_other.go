@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package sdk
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// ActAsStudent does nothing outside Linux, where there is no target to start.
+func ActAsStudent() error {
+	return nil
+}
+
+// DropPrivileges does nothing outside Linux.
+func DropPrivileges() error {
+	return nil
+}
+
+// startAsTarget fails outside Linux; capture-the-flag problems run in containers.
+func startAsTarget(cmd *exec.Cmd) error {
+	return errors.New("capture-the-flag targets only run on Linux")
+}
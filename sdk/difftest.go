@@ -0,0 +1,399 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// DiffTestSpecFile describes how to test a program against the reference solution
+// on generated inputs, for problems where listing expected outputs is impractical.
+// It uses the same format as TestSpecFile:
+//
+//	[difftest]
+//	generator = python3 gen.py
+//	runs = 200
+//	compare = tokens
+//	timeout = 5
+//
+// The generator is run once for each input with a seed as its last argument,
+// and prints the input on stdout. The grader's command then runs the student's
+// program and the reference solution with that input on stdin, and the student's
+// program must exit normally with output matching the reference solution's.
+// Inputs that the reference solution fails on are taken to be invalid and skipped.
+// The first input where the two disagree is shrunk by dropping lines and then
+// tokens for as long as they still disagree, and the shrunk input is reported.
+//
+// Seeds start from seed, or from the test seed for mastery problems, so a
+// problem is graded on the same inputs every time. The reference solution
+// comes from ReferenceDir, so the action must be marked reference. It and the
+// step's own files in SupportDir are read in and removed before any student
+// code runs, and are only put back, in a fresh scratch directory with its own
+// home directory, while the reference solution itself runs. None of the
+// student's files go there, so they cannot stand in for anything the
+// reference solution uses. Each run of the student's program is killed along
+// with anything it started as soon as it finishes, so nothing of the
+// student's is still running when the reference solution runs again.
+const DiffTestSpecFile = "difftest.cfg"
+
+// DiffTestOptions controls a differential test.
+type DiffTestOptions struct {
+	Generator string
+	Runs      int
+	Seed      int64
+	Compare   string
+	Tolerance float64
+	Timeout   int // seconds for each run
+	Build     string
+	MaxShrink int `gcfg:"max-shrink"` // runs to spend shrinking a mismatch
+}
+
+// DiffTestSpec is a parsed differential test specification.
+type DiffTestSpec struct {
+	DiffTest DiffTestOptions
+
+	generator []string
+	build     []string
+}
+
+// LoadDiffTestSpec reads a differential test specification, filling in defaults.
+func LoadDiffTestSpec(path string) (*DiffTestSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(DiffTestSpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	opts := &spec.DiffTest
+	spec.generator = strings.Fields(opts.Generator)
+	if len(spec.generator) == 0 {
+		return nil, fmt.Errorf("%s has no generator", path)
+	}
+	spec.build = strings.Fields(opts.Build)
+	if opts.Runs == 0 {
+		opts.Runs = 100
+	}
+	if opts.Seed == 0 {
+		opts.Seed = 1
+	}
+	if seed, ok := Seed(); ok {
+		opts.Seed = int64(seed)
+	}
+	if opts.Compare == "" {
+		opts.Compare = CompareTrim
+	}
+	switch opts.Compare {
+	case CompareExact, CompareTrim, CompareTokens, CompareNumbers:
+	default:
+		return nil, fmt.Errorf("unknown comparison %q", opts.Compare)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5
+	}
+	if opts.MaxShrink == 0 {
+		opts.MaxShrink = 200
+	}
+	return spec, nil
+}
+
+// behavior is what a program did with one input.
+type behavior struct {
+	output string
+	failed string // why the program did not exit normally, if it did not
+}
+
+// runBehavior runs a program on one input in a directory. With home set, the
+// program gets it as its home and temporary directory.
+func runBehavior(command []string, dir, home, input string, timeout time.Duration) *behavior {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	if home != "" {
+		cmd.Env = append(os.Environ(), "HOME="+home, "TMPDIR="+home)
+	}
+	cmd.Stdin = strings.NewReader(input)
+	isolate(cmd)
+	cmd.WaitDelay = time.Second
+	defer killGroup(cmd)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return &behavior{output: stdout.String(), failed: fmt.Sprintf("still running after %v", timeout)}
+		}
+		msg := fmt.Sprintf("the program failed: %v", err)
+		if stderr.Len() > 0 {
+			msg += "\n" + stderr.String()
+		}
+		return &behavior{output: stdout.String(), failed: msg}
+	}
+	return &behavior{output: stdout.String()}
+}
+
+// referenceFile is a file to put in the scratch directory for the reference solution.
+type referenceFile struct {
+	contents []byte
+	mode     os.FileMode
+}
+
+// takeReference reads the reference solution and the step's own files and
+// removes them from the disk. Where they share a name, the reference solution wins.
+func takeReference() (map[string]*referenceFile, error) {
+	files := make(map[string]*referenceFile)
+	read := func(root string) error {
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !info.Mode().IsRegular() {
+				return nil
+			}
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files[rel] = &referenceFile{contents: contents, mode: info.Mode().Perm()}
+			return nil
+		})
+	}
+	if _, err := os.Stat(ReferenceDir); err != nil {
+		return nil, fmt.Errorf("the reference solution is missing; is the action marked reference? %v", err)
+	}
+	if _, err := os.Stat(SupportDir); err == nil {
+		if err := read(SupportDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := read(ReferenceDir); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{SupportDir, ReferenceDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// runReference puts the reference solution in a scratch directory, builds it
+// if the spec says how, and runs it on each input. The scratch directory has
+// a home directory of its own, so nothing the student's program left in the
+// real one can change how the reference solution runs.
+func (spec *DiffTestSpec) runReference(files map[string]*referenceFile, command []string, inputs []string) ([]*behavior, error) {
+	scratch, err := ioutil.TempDir("", "reference")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+	dir, home := filepath.Join(scratch, "work"), filepath.Join(scratch, "home")
+	for _, d := range []string{dir, home} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			return nil, err
+		}
+	}
+	for name, file := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, file.contents, file.mode); err != nil {
+			return nil, err
+		}
+	}
+	if len(spec.build) > 0 {
+		cmd := exec.Command(spec.build[0], spec.build[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "HOME="+home, "TMPDIR="+home)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("error building the reference solution: %v\n%s", err, output)
+		}
+	}
+	timeout := time.Duration(spec.DiffTest.Timeout) * time.Second
+	var results []*behavior
+	for _, input := range inputs {
+		results = append(results, runBehavior(command, dir, home, input, timeout))
+	}
+	return results, nil
+}
+
+// agrees reports whether the student's program behaved like the reference solution.
+func (spec *DiffTestSpec) agrees(want, got *behavior) bool {
+	if got.failed != "" {
+		return false
+	}
+	test := &TestCase{Compare: spec.DiffTest.Compare, Tolerance: spec.DiffTest.Tolerance, Expected: want.output}
+	return test.Matches(got.output)
+}
+
+// generate runs the generator with a seed and returns the input it prints.
+func (spec *DiffTestSpec) generate(seed int64) (string, error) {
+	args := append(append([]string{}, spec.generator[1:]...), strconv.FormatInt(seed, 10))
+	cmd := exec.Command(spec.generator[0], args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("generator failed with seed %d: %v\n%s", seed, err, stderr.String())
+	}
+	return string(output), nil
+}
+
+var (
+	lineUnitRE  = regexp.MustCompile(`[^\n]*\n|[^\n]+$`)
+	tokenUnitRE = regexp.MustCompile(`\s*\S+\s*`)
+)
+
+// mismatch is an input the student's program gets wrong.
+type mismatch struct {
+	input string
+	want  *behavior
+	got   *behavior
+}
+
+// shrink looks for a smaller input the student's program still gets wrong,
+// dropping chunks of lines and then of tokens, delta debugging style.
+// It gives up after budget runs of the two programs.
+func (spec *DiffTestSpec) shrink(files map[string]*referenceFile, command []string, found *mismatch, budget int) (*mismatch, error) {
+	timeout := time.Duration(spec.DiffTest.Timeout) * time.Second
+	for _, unitRE := range []*regexp.Regexp{lineUnitRE, tokenUnitRE} {
+		units := unitRE.FindAllString(found.input, -1)
+		n := 2
+		for len(units) >= 2 && budget > 0 {
+			if n > len(units) {
+				n = len(units)
+			}
+
+			// try each input with one chunk left out
+			var candidates []string
+			var rests [][]string
+			for i := 0; i < n; i++ {
+				start, end := i*len(units)/n, (i+1)*len(units)/n
+				rest := append(append([]string{}, units[:start]...), units[end:]...)
+				rests = append(rests, rest)
+				candidates = append(candidates, strings.Join(rest, ""))
+			}
+			wants, err := spec.runReference(files, command, candidates)
+			if err != nil {
+				return nil, err
+			}
+			budget -= len(candidates)
+			smaller := false
+			for i, want := range wants {
+				if want.failed != "" || budget <= 0 {
+					continue
+				}
+				budget--
+				got := runBehavior(command, ".", "", candidates[i], timeout)
+				if !spec.agrees(want, got) {
+					found = &mismatch{input: candidates[i], want: want, got: got}
+					units = rests[i]
+					smaller = true
+					break
+				}
+			}
+			switch {
+			case smaller:
+				if n > 2 {
+					n--
+				}
+			case n == len(units):
+				units = nil
+			default:
+				n *= 2
+			}
+		}
+	}
+	return found, nil
+}
+
+// RunDiffTest runs the command as the student's program and as the reference
+// solution on generated inputs and reports the first input where they differ.
+func RunDiffTest(command []string, spec *DiffTestSpec) *ReportCard {
+	card := NewReportCard()
+	if len(command) == 0 {
+		card.Failf("no command to run")
+		return card
+	}
+	files, err := takeReference()
+	if err != nil {
+		card.Failf("%v", err)
+		return card
+	}
+	start := time.Now()
+	defer func() { card.AddTime(time.Since(start)) }()
+
+	// generate every input and run the reference solution before the student's code runs
+	opts := &spec.DiffTest
+	var inputs []string
+	for i := 0; i < opts.Runs; i++ {
+		input, err := spec.generate(opts.Seed + int64(i))
+		if err != nil {
+			card.Failf("%v", err)
+			return card
+		}
+		inputs = append(inputs, input)
+	}
+	wants, err := spec.runReference(files, command, inputs)
+	if err != nil {
+		card.Failf("%v", err)
+		return card
+	}
+
+	timeout := time.Duration(opts.Timeout) * time.Second
+	tested := 0
+	for i, want := range wants {
+		if want.failed != "" {
+			continue
+		}
+		tested++
+		got := runBehavior(command, ".", "", inputs[i], timeout)
+		if spec.agrees(want, got) {
+			continue
+		}
+
+		found, err := spec.shrink(files, command, &mismatch{input: inputs[i], want: want, got: got}, opts.MaxShrink)
+		if err != nil {
+			card.Failf("%v", err)
+			return card
+		}
+		var msg strings.Builder
+		fmt.Fprintf(&msg, "your program differs from the reference solution on input %d", i+1)
+		if len(found.input) < len(inputs[i]) {
+			fmt.Fprintf(&msg, ", shrunk from %d bytes to %d", len(inputs[i]), len(found.input))
+		}
+		fmt.Fprintf(&msg, ":\n%s\nexpected output:\n%s\n", found.input, found.want.output)
+		if found.got.failed != "" {
+			fmt.Fprintf(&msg, "%s", found.got.failed)
+		} else {
+			fmt.Fprintf(&msg, "your output:\n%s", found.got.output)
+		}
+		card.AddFailedResult("difftest", msg.String(), "")
+		return card
+	}
+	if tested == 0 {
+		card.Failf("the reference solution rejected all %d generated inputs", len(inputs))
+		return card
+	}
+	card.AddPassedResult("difftest", fmt.Sprintf("matched the reference solution on %d generated input%s", tested, plural(tested)))
+	return card
+}
@@ -0,0 +1,38 @@
+// Command difftest grades a program by comparing it with the reference solution
+// on generated inputs, following the specification in sdk.DiffTestSpecFile.
+// Install it in a problem type's image as codegrinder-difftest, mark the action
+// reference so the daycare supplies the reference solution, and give it the
+// command that runs a solution:
+//
+//	"command": ["/usr/local/bin/codegrinder-difftest", "python3", "main.py"],
+//	"reference": true
+//
+// The same command runs the student's program in the working directory and
+// the reference solution in a scratch directory of its own.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [ARGS...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	spec, err := sdk.LoadDiffTestSpec(sdk.DiffTestSpecFile)
+	if err != nil {
+		card.Failf("error loading %s: %v", sdk.DiffTestSpecFile, err)
+	} else {
+		card = sdk.RunDiffTest(os.Args[1:], spec)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
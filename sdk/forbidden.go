@@ -0,0 +1,152 @@
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// ForbiddenSpecFile lists the imports, calls, and language constructs a problem
+// does not permit. It uses the same format as TestSpecFile:
+//
+//	[forbidden]
+//	deny-import = *
+//	allow-import = math
+//	deny-call = eval
+//	deny-call = exec
+//	deny-construct = comprehension
+//	reason = this assignment practices writing loops by hand
+//
+// Anything matching a deny pattern and no allow pattern is forbidden, and each
+// forbidden name is reported as a failed result with every place it is used.
+// A pattern is a name, *, or a prefix ending in .* or /*; imports also match
+// everything inside them and calls match as in the call rule of
+// StructureSpecFile, so eval matches builtins.eval(). Constructs are named as in
+// StructureSpecFile. The language is chosen the same way, and when both files
+// are present, the language in StructureSpecFile wins.
+const ForbiddenSpecFile = "forbidden.cfg"
+
+// ForbiddenOptions lists what a problem does not permit.
+type ForbiddenOptions struct {
+	Language      string
+	DenyImport    []string `gcfg:"deny-import"`
+	AllowImport   []string `gcfg:"allow-import"`
+	DenyCall      []string `gcfg:"deny-call"`
+	AllowCall     []string `gcfg:"allow-call"`
+	DenyConstruct []string `gcfg:"deny-construct"`
+	Reason        string
+}
+
+// ForbiddenSpec is a parsed forbidden code specification.
+type ForbiddenSpec struct {
+	Forbidden ForbiddenOptions
+}
+
+// LoadForbiddenSpec reads a forbidden code specification.
+func LoadForbiddenSpec(path string) (*ForbiddenSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(ForbiddenSpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	opts := &spec.Forbidden
+	if len(opts.DenyImport)+len(opts.DenyCall)+len(opts.DenyConstruct) == 0 {
+		return nil, fmt.Errorf("%s forbids nothing", path)
+	}
+	if opts.Language == "" {
+		opts.Language = DetectLanguage()
+	}
+	return spec, nil
+}
+
+// patternMatches reports whether a deny or allow pattern matches a name, using
+// exact to compare a name without wildcards.
+func patternMatches(pattern, name string, exact func(name, pattern string) bool) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, ".*"), strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(name, pattern[:len(pattern)-1])
+	default:
+		return exact(name, pattern)
+	}
+}
+
+func importMatches(name, pattern string) bool {
+	return name == pattern || strings.HasPrefix(name, pattern+".") || strings.HasPrefix(name, pattern+"/")
+}
+
+func constructMatches(name, pattern string) bool {
+	return name == pattern
+}
+
+// forbidden reports whether a name matches a deny pattern and no allow pattern.
+func forbidden(name string, deny, allow []string, exact func(name, pattern string) bool) bool {
+	denied := false
+	for _, pattern := range deny {
+		denied = denied || patternMatches(pattern, name, exact)
+	}
+	if !denied {
+		return false
+	}
+	for _, pattern := range allow {
+		if patternMatches(pattern, name, exact) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check reports each forbidden import, call, and construct in the outline.
+func (spec *ForbiddenSpec) Check(card *ReportCard, outline *Outline) {
+	opts := &spec.Forbidden
+	var names []string
+	uses := make(map[string][]*OutlineUse)
+	check := func(kind string, list []*OutlineUse, deny, allow []string, exact func(name, pattern string) bool) {
+		for _, use := range list {
+			if !forbidden(use.Name, deny, allow, exact) {
+				continue
+			}
+			key := kind + " " + use.Name
+			if _, exists := uses[key]; !exists {
+				names = append(names, key)
+			}
+			uses[key] = append(uses[key], use)
+		}
+	}
+	check("import", outline.Imports, opts.DenyImport, opts.AllowImport, importMatches)
+	check("call", outline.Calls, opts.DenyCall, opts.AllowCall, CallMatches)
+	check("construct", outline.Constructs, opts.DenyConstruct, nil, constructMatches)
+
+	for _, key := range names {
+		list := uses[key]
+		fields := strings.SplitN(key, " ", 2)
+		var msg string
+		switch fields[0] {
+		case "import":
+			msg = fmt.Sprintf("importing `%s` is not permitted in this assignment", fields[1])
+		default:
+			msg = fmt.Sprintf("use of `%s` is not permitted in this assignment", fields[1])
+		}
+		if opts.Reason != "" {
+			msg += ": " + opts.Reason
+		}
+		var places []string
+		for _, use := range list {
+			places = append(places, use.Location())
+		}
+		msg += "\nused at " + strings.Join(places, ", ")
+		card.AddFailedResult("forbidden "+key, msg, list[0].Location())
+	}
+	if len(names) > 0 {
+		card.Failf("%d forbidden name%s used", len(names), plural(len(names)))
+	} else {
+		card.AddPassedResult("forbidden code", "nothing forbidden is used")
+	}
+}
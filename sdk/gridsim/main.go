@@ -0,0 +1,35 @@
+// Command gridsim grades grid robot programs in the worlds of a world specification.
+// Install it in a problem type's image as codegrinder-gridsim and give it the
+// command that runs the student's program:
+//
+//	"command": ["/usr/local/bin/codegrinder-gridsim", "python3", "robot.py"]
+//
+// The worlds are read from sdk.WorldSpecFile in the working directory. The
+// report has an animated replay of each world that is not hidden.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [ARGS...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	worlds, err := sdk.LoadWorlds(sdk.WorldSpecFile)
+	if err != nil {
+		card.Failf("error loading worlds: %v", err)
+	} else {
+		card = sdk.RunSimulations(os.Args[1:], worlds)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
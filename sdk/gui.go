@@ -0,0 +1,534 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// GUISpecFile describes how to drive and check a GUI program, such as one
+// written with tkinter or Swing. It uses the same format as TestSpecFile:
+//
+//	[display]
+//	size = 640 480
+//	window = "Calculator"
+//
+//	[step "add"]
+//	click = 40 200
+//	type = "12+30"
+//	key = Return
+//	expect-window = "= 42"
+//	expect-pixel = 320 20 #000000
+//
+//	[step "layout"]
+//	expect-image = tests/layout.png
+//	region = 0 0 320 240
+//	max-diff = 0.02
+//	points = 2
+//
+// The program runs on a virtual framebuffer with no window manager. Grading
+// starts once a window whose title matches window appears. Each step then
+// clicks, types text, and presses keys, in that order, waits for things to
+// settle, and takes a screenshot. A step passes if every check holds:
+//
+//	expect-window  a window title matches this regular expression
+//	expect-pixel   X Y #RRGGBB: the pixel is this color, within color-tolerance
+//	expect-image   the screenshot, or its region X Y W H, matches this PNG file,
+//	               with at most max-diff of the pixels differing
+//
+// The screenshot of each step goes in the report, along with an image marking
+// the differing pixels in red when expect-image fails. The image needs Xvfb,
+// xdotool, and ImageMagick installed.
+const GUISpecFile = "gui.cfg"
+
+// GUIDisplay describes the virtual screen and the window to wait for.
+type GUIDisplay struct {
+	Size    string
+	Window  string
+	Startup int     // seconds to wait for the window
+	Settle  float64 // seconds to wait after each step's input before checking
+}
+
+// GUIDefaults applies to every step that does not set its own value.
+type GUIDefaults struct {
+	MaxDiff        float64 `gcfg:"max-diff"`
+	ColorTolerance int     `gcfg:"color-tolerance"`
+	Points         float64
+}
+
+// GUIStep is one step of a GUI test.
+type GUIStep struct {
+	Name           string   `gcfg:"-"`
+	Click          []string `gcfg:"click"`
+	Type           string   `gcfg:"type"`
+	Key            []string `gcfg:"key"`
+	ExpectWindow   string   `gcfg:"expect-window"`
+	ExpectPixel    []string `gcfg:"expect-pixel"`
+	ExpectImage    string   `gcfg:"expect-image"`
+	Region         string   `gcfg:"region"`
+	MaxDiff        float64  `gcfg:"max-diff"`
+	ColorTolerance int      `gcfg:"color-tolerance"`
+	Points         float64  `gcfg:"points"`
+	Hidden         bool     `gcfg:"hidden"`
+
+	order  int
+	clicks [][]int
+	pixels []pixelCheck
+	region image.Rectangle
+	want   image.Image
+}
+
+type pixelCheck struct {
+	X, Y  int
+	Color color.RGBA
+}
+
+// GUISpec is a parsed GUI test specification.
+type GUISpec struct {
+	Display  GUIDisplay
+	Defaults GUIDefaults
+	Step     map[string]*GUIStep
+
+	width, height int
+	steps         []*GUIStep
+}
+
+// LoadGUISpec reads a GUI test specification, filling in defaults and reading
+// expected images, which are relative to the spec file.
+func LoadGUISpec(path string) (*GUISpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(GUISpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	display := &spec.Display
+	spec.width, spec.height = 800, 600
+	if display.Size != "" {
+		size, err := parseInts(display.Size, 2)
+		if err != nil {
+			return nil, fmt.Errorf("size: %v", err)
+		}
+		spec.width, spec.height = size[0], size[1]
+	}
+	if display.Window == "" {
+		display.Window = "."
+	}
+	if _, err := regexp.Compile(display.Window); err != nil {
+		return nil, fmt.Errorf("window: bad regular expression: %v", err)
+	}
+	if display.Startup == 0 {
+		display.Startup = 10
+	}
+	if display.Settle == 0 {
+		display.Settle = 0.5
+	}
+
+	order := sectionOrder(stepSectionRE, raw)
+	dir := filepath.Dir(path)
+	for name, step := range spec.Step {
+		step.Name = name
+		step.order = order[name]
+		if step.MaxDiff == 0 {
+			step.MaxDiff = spec.Defaults.MaxDiff
+		}
+		if step.ColorTolerance == 0 {
+			step.ColorTolerance = spec.Defaults.ColorTolerance
+		}
+		if step.Points == 0 {
+			step.Points = spec.Defaults.Points
+		}
+		if err := step.parse(dir, spec.width, spec.height); err != nil {
+			return nil, fmt.Errorf("step %q: %v", name, err)
+		}
+		spec.steps = append(spec.steps, step)
+	}
+	sort.Slice(spec.steps, func(i, j int) bool {
+		if spec.steps[i].order != spec.steps[j].order {
+			return spec.steps[i].order < spec.steps[j].order
+		}
+		return spec.steps[i].Name < spec.steps[j].Name
+	})
+	if len(spec.steps) == 0 {
+		return nil, fmt.Errorf("%s has no steps", path)
+	}
+	return spec, nil
+}
+
+func (step *GUIStep) parse(dir string, width, height int) error {
+	for _, click := range step.Click {
+		xy, err := parseInts(click, 2)
+		if err != nil {
+			return fmt.Errorf("click: %v", err)
+		}
+		step.clicks = append(step.clicks, xy)
+	}
+	if step.ExpectWindow != "" {
+		if _, err := regexp.Compile(step.ExpectWindow); err != nil {
+			return fmt.Errorf("expect-window: bad regular expression: %v", err)
+		}
+	}
+	for _, pixel := range step.ExpectPixel {
+		fields := strings.Fields(pixel)
+		if len(fields) != 3 {
+			return fmt.Errorf("expect-pixel must be X Y #RRGGBB")
+		}
+		xy, err := parseInts(fields[0]+" "+fields[1], 2)
+		if err != nil {
+			return fmt.Errorf("expect-pixel: %v", err)
+		}
+		rgb, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "#"), 16, 32)
+		if err != nil || len(strings.TrimPrefix(fields[2], "#")) != 6 {
+			return fmt.Errorf("expect-pixel: %q is not a color like #RRGGBB", fields[2])
+		}
+		c := color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}
+		step.pixels = append(step.pixels, pixelCheck{X: xy[0], Y: xy[1], Color: c})
+	}
+
+	step.region = image.Rect(0, 0, width, height)
+	if step.Region != "" {
+		r, err := parseInts(step.Region, 4)
+		if err != nil {
+			return fmt.Errorf("region: %v", err)
+		}
+		step.region = image.Rect(r[0], r[1], r[0]+r[2], r[1]+r[3])
+		if !step.region.In(image.Rect(0, 0, width, height)) || step.region.Empty() {
+			return fmt.Errorf("region is not on the screen")
+		}
+	}
+	if step.ExpectImage != "" {
+		fp, err := os.Open(filepath.Join(dir, step.ExpectImage))
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+		if step.want, err = png.Decode(fp); err != nil {
+			return fmt.Errorf("expect-image: %v", err)
+		}
+		if step.want.Bounds().Dx() != step.region.Dx() || step.want.Bounds().Dy() != step.region.Dy() {
+			return fmt.Errorf("expect-image is %dx%d but the region is %dx%d",
+				step.want.Bounds().Dx(), step.want.Bounds().Dy(), step.region.Dx(), step.region.Dy())
+		}
+	}
+	return nil
+}
+
+// guiSession is the virtual display and the student's program running on it.
+type guiSession struct {
+	spec    *GUISpec
+	display string
+	xvfb    *exec.Cmd
+	program *exec.Cmd
+	stderr  bytes.Buffer
+	exited  chan struct{}
+}
+
+// startGUI starts a virtual display and runs the program on it.
+func startGUI(spec *GUISpec, command []string) (*guiSession, error) {
+	s := &guiSession{spec: spec, exited: make(chan struct{})}
+
+	// find a free display number
+	for n := 99; n < 199; n++ {
+		if _, err := os.Stat(fmt.Sprintf("/tmp/.X11-unix/X%d", n)); os.IsNotExist(err) {
+			s.display = fmt.Sprintf(":%d", n)
+			break
+		}
+	}
+	if s.display == "" {
+		return nil, fmt.Errorf("no free X display")
+	}
+	screen := fmt.Sprintf("%dx%dx24", spec.width, spec.height)
+	s.xvfb = exec.Command("Xvfb", s.display, "-screen", "0", screen, "-nolisten", "tcp")
+	if err := s.xvfb.Start(); err != nil {
+		return nil, fmt.Errorf("error starting Xvfb: %v", err)
+	}
+	socket := "/tmp/.X11-unix/X" + strings.TrimPrefix(s.display, ":")
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if i == 50 {
+			s.stop()
+			return nil, fmt.Errorf("Xvfb did not start")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	s.program = exec.Command(command[0], command[1:]...)
+	s.program.Env = append(os.Environ(), "DISPLAY="+s.display)
+	s.program.Stderr = &s.stderr
+	if err := s.program.Start(); err != nil {
+		s.stop()
+		return nil, err
+	}
+	go func() {
+		s.program.Wait()
+		close(s.exited)
+	}()
+	return s, nil
+}
+
+// stop kills the program and the virtual display. It is safe to call more than once.
+func (s *guiSession) stop() {
+	if s.program != nil && s.program.Process != nil {
+		s.program.Process.Kill()
+		<-s.exited
+	}
+	if s.xvfb != nil && s.xvfb.Process != nil && s.xvfb.ProcessState == nil {
+		s.xvfb.Process.Kill()
+		s.xvfb.Wait()
+	}
+}
+
+// running reports whether the program is still going.
+func (s *guiSession) running() bool {
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// xdotool runs an xdotool command against the virtual display.
+func (s *guiSession) xdotool(timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "xdotool", args...)
+	cmd.Env = append(os.Environ(), "DISPLAY="+s.display)
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("timed out")
+	}
+	return string(out), err
+}
+
+// windowTitles returns the titles of the visible windows.
+func (s *guiSession) windowTitles() []string {
+	out, err := s.xdotool(5*time.Second, "search", "--onlyvisible", "--name", ".")
+	if err != nil {
+		return nil
+	}
+	var titles []string
+	for _, id := range strings.Fields(out) {
+		if title, err := s.xdotool(5*time.Second, "getwindowname", id); err == nil {
+			titles = append(titles, strings.TrimSpace(title))
+		}
+	}
+	return titles
+}
+
+// hasWindow reports whether a window title matches the regular expression.
+func (s *guiSession) hasWindow(pattern string) bool {
+	re := regexp.MustCompile(pattern)
+	for _, title := range s.windowTitles() {
+		if re.MatchString(title) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForWindow waits until a window whose title matches the pattern appears.
+func (s *guiSession) waitForWindow() error {
+	deadline := time.Now().Add(time.Duration(s.spec.Display.Startup) * time.Second)
+	for time.Now().Before(deadline) {
+		if !s.running() {
+			return fmt.Errorf("the program exited before opening a window")
+		}
+		if s.hasWindow(s.spec.Display.Window) {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("no window with a title matching %q appeared within %d second%s",
+		s.spec.Display.Window, s.spec.Display.Startup, plural(s.spec.Display.Startup))
+}
+
+// screenshot captures the whole virtual screen.
+func (s *guiSession) screenshot() (image.Image, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "import", "-display", s.display, "-window", "root", "png:-")
+	raw, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error taking a screenshot: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading the screenshot: %v", err)
+	}
+	return img, raw, nil
+}
+
+// run carries out the input of a step.
+func (step *GUIStep) run(s *guiSession) error {
+	for _, xy := range step.clicks {
+		if _, err := s.xdotool(5*time.Second, "mousemove", strconv.Itoa(xy[0]), strconv.Itoa(xy[1]), "click", "1"); err != nil {
+			return fmt.Errorf("error clicking at %d %d: %v", xy[0], xy[1], err)
+		}
+	}
+	if step.Type != "" {
+		if _, err := s.xdotool(30*time.Second, "type", "--delay", "20", step.Type); err != nil {
+			return fmt.Errorf("error typing %q: %v", step.Type, err)
+		}
+	}
+	for _, key := range step.Key {
+		if _, err := s.xdotool(5*time.Second, "key", key); err != nil {
+			return fmt.Errorf("error pressing %s: %v", key, err)
+		}
+	}
+	time.Sleep(time.Duration(s.spec.Display.Settle * float64(time.Second)))
+	return nil
+}
+
+func colorClose(a, b color.Color, tolerance int) bool {
+	r1, g1, b1, _ := a.RGBA()
+	r2, g2, b2, _ := b.RGBA()
+	diff := func(x, y uint32) bool {
+		d := int(x>>8) - int(y>>8)
+		return d <= tolerance && -d <= tolerance
+	}
+	return diff(r1, r2) && diff(g1, g2) && diff(b1, b2)
+}
+
+// compare checks the screenshot region against the expected image, returning
+// the fraction of pixels that differ and an image with them marked in red.
+func (step *GUIStep) compare(got image.Image) (float64, image.Image) {
+	marked := image.NewRGBA(image.Rect(0, 0, step.region.Dx(), step.region.Dy()))
+	draw.Draw(marked, marked.Bounds(), got, step.region.Min, draw.Src)
+	red := color.RGBA{R: 255, A: 255}
+	wantMin := step.want.Bounds().Min
+	differ := 0
+	for y := 0; y < step.region.Dy(); y++ {
+		for x := 0; x < step.region.Dx(); x++ {
+			a := got.At(step.region.Min.X+x, step.region.Min.Y+y)
+			b := step.want.At(wantMin.X+x, wantMin.Y+y)
+			if !colorClose(a, b, step.ColorTolerance) {
+				differ++
+				marked.Set(x, y, red)
+			}
+		}
+	}
+	return float64(differ) / float64(step.region.Dx()*step.region.Dy()), marked
+}
+
+// check takes a screenshot and checks the expectations of a step,
+// returning what failed and any images to attach.
+func (step *GUIStep) check(s *guiSession) ([]string, map[string][]byte) {
+	var problems []string
+	images := make(map[string][]byte)
+	if step.ExpectWindow != "" && !s.hasWindow(step.ExpectWindow) {
+		problems = append(problems, fmt.Sprintf("no window has a title matching %q; the windows are: %s",
+			step.ExpectWindow, strings.Join(s.windowTitles(), ", ")))
+	}
+
+	img, raw, err := s.screenshot()
+	if err != nil {
+		return append(problems, err.Error()), images
+	}
+	images["screenshot"] = raw
+	for _, pixel := range step.pixels {
+		if got := img.At(pixel.X, pixel.Y); !colorClose(got, pixel.Color, step.ColorTolerance) {
+			r, g, b, _ := got.RGBA()
+			problems = append(problems, fmt.Sprintf("the pixel at %d %d is #%02x%02x%02x, not #%02x%02x%02x",
+				pixel.X, pixel.Y, r>>8, g>>8, b>>8, pixel.Color.R, pixel.Color.G, pixel.Color.B))
+		}
+	}
+	if step.want != nil {
+		fraction, marked := step.compare(img)
+		if fraction > step.MaxDiff {
+			problems = append(problems, fmt.Sprintf("%.1f%% of the pixels differ from %s, more than the %.1f%% allowed",
+				fraction*100, step.ExpectImage, step.MaxDiff*100))
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, marked); err == nil {
+				images["diff"] = buf.Bytes()
+			}
+		}
+	}
+	return problems, images
+}
+
+// RunGUITests runs the program on a virtual display, drives it through each
+// step, and reports the results with a screenshot of each step.
+func RunGUITests(command []string, spec *GUISpec) *ReportCard {
+	card := NewReportCard()
+	if len(command) == 0 {
+		card.Failf("no command to run")
+		return card
+	}
+	start := time.Now()
+	s, err := startGUI(spec, command)
+	if err != nil {
+		card.Failf("%v", err)
+		return card
+	}
+	defer s.stop()
+	if err := s.waitForWindow(); err != nil {
+		// stop the program before reading what it wrote
+		s.stop()
+		details := err.Error()
+		if s.stderr.Len() > 0 {
+			details += "\n" + s.stderr.String()
+		}
+		card.AddFailedResult("startup", details, "")
+		card.AddTime(time.Since(start))
+		return card
+	}
+	card.AddTime(time.Since(start))
+
+	for _, step := range spec.steps {
+		start := time.Now()
+		var problems []string
+		images := make(map[string][]byte)
+		if !s.running() {
+			problems = append(problems, "the program exited\n"+s.stderr.String())
+		} else if err := step.run(s); err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems, images = step.check(s)
+		}
+		card.AddTime(time.Since(start))
+
+		var result *ReportCardResult
+		if len(problems) > 0 {
+			result = card.AddFailedResult(step.Name, strings.Join(problems, "\n"), "")
+		} else {
+			result = card.AddPassedResult(step.Name, "")
+		}
+		result.Points = step.Points
+		if step.Hidden {
+			result.Details = ""
+			if result.Outcome == "failed" {
+				result.Details = "this is a hidden test, so its details are not shown"
+			}
+			continue
+		}
+		base := strings.Trim(unsafeNameRE.ReplaceAllString(step.Name, "-"), "-.")
+		for _, kind := range []string{"screenshot", "diff"} {
+			if raw, ok := images[kind]; ok {
+				card.AddArtifact(kind+"-"+base+".png", "image/png", raw, step.Name)
+			}
+		}
+	}
+	return card
+}
@@ -0,0 +1,36 @@
+// Command guitest grades GUI programs, such as tkinter or Swing programs, by
+// driving them on a virtual framebuffer. Install it in a problem type's image
+// as codegrinder-guitest, along with Xvfb, xdotool, and ImageMagick, and give
+// it the command that runs the student's program:
+//
+//	"command": ["/usr/local/bin/codegrinder-guitest", "python3", "main.py"]
+//
+// The steps are read from sdk.GUISpecFile in the working directory. The
+// report has a screenshot of each step that is not hidden.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [ARGS...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	spec, err := sdk.LoadGUISpec(sdk.GUISpecFile)
+	if err != nil {
+		card.Failf("error loading GUI tests: %v", err)
+	} else {
+		card = sdk.RunGUITests(os.Args[1:], spec)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,335 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// HardwareSpecFile describes how to grade firmware on a microcontroller attached
+// to a runner agent. It uses the same format as TestSpecFile:
+//
+//	[device]
+//	flash = avrdude -p m328p -c arduino -P {port} -U flash:w:firmware.hex
+//	baud = 115200
+//	ready = "READY"
+//	timeout = 5
+//
+//	[step "echo"]
+//	send = "ping\n"
+//	expect = "pong"
+//
+//	[step "button"]
+//	harness = ./harness.py press 2
+//	expect = "button 2 down"
+//	points = 2
+//
+// The flash command loads the student's firmware onto the board, with {port}
+// replaced by the serial port. Once the board prints something matching ready,
+// the steps run in order. Each step runs its harness command, which drives the
+// board's pins through whatever test fixture the runner has, sends its input to
+// the serial port, and waits for the serial output to match its expect regular
+// expression. The serial output captured during each step goes in the report.
+//
+// The serial port is the port setting or else SerialPortVariable, which is set
+// on the runner so the same problem works on every machine with the board attached.
+const HardwareSpecFile = "hardware.cfg"
+
+// SerialPortVariable names the environment variable giving the serial port of the attached board.
+const SerialPortVariable = "CODEGRINDER_SERIAL_PORT"
+
+// HardwareDevice describes the attached board and how to flash it.
+type HardwareDevice struct {
+	Port         string
+	Baud         int
+	Flash        string
+	FlashTimeout int `gcfg:"flash-timeout"` // seconds
+	Ready        string
+	ReadyTimeout int `gcfg:"ready-timeout"` // seconds
+	Timeout      int // seconds, for steps that do not set their own
+}
+
+// HardwareStep is one step of a hardware test.
+type HardwareStep struct {
+	Name    string  `gcfg:"-"`
+	Harness string  `gcfg:"harness"`
+	Send    string  `gcfg:"send"`
+	Expect  string  `gcfg:"expect"`
+	Timeout int     `gcfg:"timeout"`
+	Points  float64 `gcfg:"points"`
+	Hidden  bool    `gcfg:"hidden"`
+
+	order  int
+	expect *regexp.Regexp
+}
+
+// HardwareSpec is a parsed hardware test specification.
+type HardwareSpec struct {
+	Device HardwareDevice
+	Step   map[string]*HardwareStep
+
+	steps []*HardwareStep
+	ready *regexp.Regexp
+}
+
+var stepSectionRE = regexp.MustCompile(`(?m)^\s*\[\s*step\s+"((?:[^"\\]|\\.)*)"\s*\]`)
+
+// LoadHardwareSpec reads a hardware test specification, filling in defaults.
+func LoadHardwareSpec(path string) (*HardwareSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(HardwareSpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	device := &spec.Device
+	if device.Port == "" {
+		device.Port = os.Getenv(SerialPortVariable)
+	}
+	if device.Port == "" {
+		return nil, fmt.Errorf("no serial port: set port in %s or %s on the runner", path, SerialPortVariable)
+	}
+	if device.Baud == 0 {
+		device.Baud = 9600
+	}
+	if device.Flash == "" {
+		return nil, fmt.Errorf("%s has no flash command", path)
+	}
+	if device.FlashTimeout == 0 {
+		device.FlashTimeout = 60
+	}
+	if device.ReadyTimeout == 0 {
+		device.ReadyTimeout = 10
+	}
+	if device.Timeout == 0 {
+		device.Timeout = 5
+	}
+	if device.Ready != "" {
+		if spec.ready, err = regexp.Compile(device.Ready); err != nil {
+			return nil, fmt.Errorf("ready: bad regular expression: %v", err)
+		}
+	}
+
+	order := sectionOrder(stepSectionRE, raw)
+	for name, step := range spec.Step {
+		step.Name = name
+		step.order = order[name]
+		if step.Timeout == 0 {
+			step.Timeout = device.Timeout
+		}
+		if step.Expect != "" {
+			if step.expect, err = regexp.Compile(step.Expect); err != nil {
+				return nil, fmt.Errorf("step %q: bad regular expression: %v", name, err)
+			}
+		}
+		spec.steps = append(spec.steps, step)
+	}
+	sort.Slice(spec.steps, func(i, j int) bool {
+		if spec.steps[i].order != spec.steps[j].order {
+			return spec.steps[i].order < spec.steps[j].order
+		}
+		return spec.steps[i].Name < spec.steps[j].Name
+	})
+	if len(spec.steps) == 0 {
+		return nil, fmt.Errorf("%s has no steps", path)
+	}
+	return spec, nil
+}
+
+// serialPort collects everything a board writes to its serial port.
+type serialPort struct {
+	fp *os.File
+
+	sync.Mutex
+	output  bytes.Buffer
+	err     error
+	updated chan struct{} // closed and replaced whenever output arrives
+}
+
+// openSerial opens a serial port in raw mode at the given speed.
+func openSerial(port string, baud int) (*serialPort, error) {
+	// stty is on every runner platform that has serial ports, and saves writing termios code for each
+	flag := "-F"
+	if runtime.GOOS == "darwin" {
+		flag = "-f"
+	}
+	if out, err := exec.Command("stty", flag, port, strconv.Itoa(baud), "raw", "-echo").CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("error setting up %s: %v\n%s", port, err, out)
+	}
+	fp, err := os.OpenFile(port, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &serialPort{fp: fp, updated: make(chan struct{})}
+	go s.read()
+	return s, nil
+}
+
+func (s *serialPort) read() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.fp.Read(buf)
+		s.Lock()
+		s.output.Write(buf[:n])
+		if err != nil {
+			s.err = err
+		}
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// since returns the output after the given offset, the new offset, and a channel closed when more arrives.
+func (s *serialPort) since(offset int) (string, int, chan struct{}, error) {
+	s.Lock()
+	defer s.Unlock()
+	return string(s.output.Bytes()[offset:]), s.output.Len(), s.updated, s.err
+}
+
+// expect waits until the output after offset matches re or the time runs out,
+// and returns that output and the offset to continue from.
+func (s *serialPort) expect(offset int, re *regexp.Regexp, timeout time.Duration) (string, int, bool) {
+	deadline := time.After(timeout)
+	for {
+		output, end, updated, err := s.since(offset)
+		if re != nil && re.MatchString(output) {
+			return output, end, true
+		}
+		if err != nil && err != io.EOF {
+			return output + fmt.Sprintf("\n[serial port error: %v]", err), end, false
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			return output, end, re == nil
+		}
+	}
+}
+
+func (s *serialPort) Close() error {
+	return s.fp.Close()
+}
+
+// runHardwareCommand runs a flash or harness command in the working directory.
+func runHardwareCommand(line, port string, timeout time.Duration) (string, error) {
+	args := strings.Fields(strings.Replace(line, "{port}", port, -1))
+	if len(args) == 0 {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return string(out), fmt.Errorf("%s was still running after %v", args[0], timeout)
+	}
+	if err != nil {
+		return string(out), fmt.Errorf("%s failed: %v", args[0], err)
+	}
+	return string(out), nil
+}
+
+// RunHardwareTests flashes the firmware, runs each step against the board,
+// and reports the results along with the serial output of each step.
+func RunHardwareTests(spec *HardwareSpec) *ReportCard {
+	card := NewReportCard()
+	device := &spec.Device
+
+	// flash the board before opening the port, since flashing tools need it to themselves
+	start := time.Now()
+	out, err := runHardwareCommand(device.Flash, device.Port, time.Duration(device.FlashTimeout)*time.Second)
+	card.AddTime(time.Since(start))
+	if err != nil {
+		card.AddFailedResult("flash", err.Error()+"\n"+out, "")
+		card.Failf("the firmware could not be loaded onto the board")
+		return card
+	}
+
+	serial, err := openSerial(device.Port, device.Baud)
+	if err != nil {
+		card.AddFailedResult("serial", err.Error(), "")
+		card.Failf("the board's serial port could not be opened")
+		return card
+	}
+	defer serial.Close()
+
+	offset := 0
+	if spec.ready != nil {
+		output, end, ok := serial.expect(offset, spec.ready, time.Duration(device.ReadyTimeout)*time.Second)
+		offset = end
+		if !ok {
+			card.AddFailedResult("boot", fmt.Sprintf("the board did not print output matching %q within %d second%s\nserial output:\n%s",
+				device.Ready, device.ReadyTimeout, plural(device.ReadyTimeout), output), "")
+			card.Failf("the firmware did not start")
+			return card
+		}
+	}
+
+	for _, step := range spec.steps {
+		start := time.Now()
+		var details strings.Builder
+		failed := false
+		if step.Harness != "" {
+			out, err := runHardwareCommand(step.Harness, device.Port, time.Duration(step.Timeout)*time.Second)
+			if err != nil {
+				fmt.Fprintf(&details, "the test harness failed: %v\n%s", err, out)
+				failed = true
+			}
+		}
+		if !failed && step.Send != "" {
+			if _, err := io.WriteString(serial.fp, step.Send); err != nil {
+				fmt.Fprintf(&details, "error writing to the board: %v\n", err)
+				failed = true
+			}
+		}
+		if !failed {
+			output, end, ok := serial.expect(offset, step.expect, time.Duration(step.Timeout)*time.Second)
+			offset = end
+			if step.Send != "" {
+				fmt.Fprintf(&details, "sent:\n%s\n", step.Send)
+			}
+			if !ok {
+				fmt.Fprintf(&details, "expected serial output matching:\n%s\nwithin %d second%s\n", step.Expect, step.Timeout, plural(step.Timeout))
+				failed = true
+			}
+			fmt.Fprintf(&details, "serial output:\n%s", output)
+		}
+		card.AddTime(time.Since(start))
+
+		var result *ReportCardResult
+		if failed {
+			result = card.AddFailedResult(step.Name, details.String(), "")
+		} else {
+			result = card.AddPassedResult(step.Name, details.String())
+		}
+		result.Points = step.Points
+		if step.Hidden {
+			result.Details = ""
+			if result.Outcome == "failed" {
+				result.Details = "this is a hidden test, so its input and output are not shown"
+			}
+		}
+	}
+	return card
+}
@@ -0,0 +1,38 @@
+// Command hiltest grades embedded firmware on a microcontroller attached to a
+// runner agent, following the hardware test specification in the working directory.
+// Install it on the runner machine as codegrinder-hiltest and run it after the
+// firmware is built:
+//
+//	"pipeline": [
+//	    {"name": "build", "command": ["make"]},
+//	    {"name": "hardware", "command": ["codegrinder-hiltest"]}
+//	]
+//
+// The tests are read from sdk.HardwareSpecFile in the working directory.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	spec, err := sdk.LoadHardwareSpec(sdk.HardwareSpecFile)
+	if err != nil {
+		card.Failf("error loading hardware tests: %v", err)
+	} else {
+		card = sdk.RunHardwareTests(spec)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
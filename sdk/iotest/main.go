@@ -0,0 +1,34 @@
+// Command iotest grades I/O-style problems from a declarative test specification.
+// Install it in a problem type's image as codegrinder-iotest and give it the
+// command that runs the student's program:
+//
+//	"command": ["/usr/local/bin/codegrinder-iotest", "python3", "main.py"]
+//
+// The tests are read from sdk.TestSpecFile in the working directory.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s COMMAND [ARGS...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	tests, err := sdk.LoadTests(sdk.TestSpecFile)
+	if err != nil {
+		card.Failf("error loading tests: %v", err)
+	} else {
+		card = sdk.RunTests(os.Args[1:], tests)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,126 @@
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// MetricsSpecFile sets soft limits on the size and complexity of a solution.
+// It uses the same format as TestSpecFile:
+//
+//	[metrics]
+//	language = python
+//	max-complexity = 8
+//	max-function-length = 30
+//	max-lines = 200
+//
+// Every submission is measured: its lines of code, and the length and
+// cyclomatic complexity of each function. The measurements go in the report
+// card, and a function or program over a limit gets a warning, which suggests
+// breaking it up without counting against the grade. Limits that are zero or
+// missing are not checked. The file itself is optional, and the language is
+// chosen as in StructureSpecFile.
+const MetricsSpecFile = "metrics.cfg"
+
+// MetricsOptions gives the soft limits for a submission.
+type MetricsOptions struct {
+	Language          string
+	MaxComplexity     int `gcfg:"max-complexity"`
+	MaxFunctionLength int `gcfg:"max-function-length"`
+	MaxLines          int `gcfg:"max-lines"` // lines of code, not counting blank lines and comments
+}
+
+// MetricsSpec is a parsed metrics specification.
+type MetricsSpec struct {
+	Metrics MetricsOptions
+}
+
+// LoadMetricsSpec reads a metrics specification. A missing file sets no limits.
+func LoadMetricsSpec(path string) (*MetricsSpec, error) {
+	spec := new(MetricsSpec)
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		raw = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if spec.Metrics.Language == "" {
+		spec.Metrics.Language = DetectLanguage()
+	}
+	return spec, nil
+}
+
+// ComputeMetrics measures the program described by an outline.
+func ComputeMetrics(outline *Outline) *CodeMetrics {
+	metrics := &CodeMetrics{Files: len(outline.Files)}
+	for _, file := range outline.Files {
+		metrics.Lines += file.Lines
+		metrics.CodeLines += file.Code
+	}
+	for _, fn := range outline.Functions {
+		name := fn.Name
+		if fn.Class != "" {
+			name = fn.Class + "." + fn.Name
+		}
+		metrics.Functions = append(metrics.Functions, &FunctionMetrics{
+			Name:       name,
+			File:       fn.File,
+			Line:       fn.Line,
+			Length:     fn.Length,
+			Complexity: fn.Complexity,
+		})
+		if fn.Length > metrics.MaxLength {
+			metrics.MaxLength = fn.Length
+		}
+		if fn.Complexity > metrics.MaxComplexity {
+			metrics.MaxComplexity = fn.Complexity
+		}
+	}
+	return metrics
+}
+
+// RunMetrics measures the program in the working directory, puts the metrics
+// in the report card, and warns about anything over the spec's limits.
+func RunMetrics(spec *MetricsSpec) *ReportCard {
+	card := NewReportCard()
+	outline, err := BuildOutline(spec.Metrics.Language)
+	if err != nil {
+		card.Failf("%v", err)
+		return card
+	}
+	metrics := ComputeMetrics(outline)
+	card.Metrics = metrics
+
+	opts := &spec.Metrics
+	warnings := 0
+	if opts.MaxLines > 0 && metrics.CodeLines > opts.MaxLines {
+		card.AddWarningResult("program size",
+			fmt.Sprintf("%d lines of code, over the suggested limit of %d; look for repeated code you could share", metrics.CodeLines, opts.MaxLines), "")
+		warnings++
+	}
+	for _, fn := range metrics.Functions {
+		context := fmt.Sprintf("%s:%d", fn.File, fn.Line)
+		if opts.MaxFunctionLength > 0 && fn.Length > opts.MaxFunctionLength {
+			card.AddWarningResult(fn.Name+" length",
+				fmt.Sprintf("%s is %d lines long, over the suggested limit of %d; consider breaking it into smaller functions", fn.Name, fn.Length, opts.MaxFunctionLength), context)
+			warnings++
+		}
+		if opts.MaxComplexity > 0 && fn.Complexity > opts.MaxComplexity {
+			card.AddWarningResult(fn.Name+" complexity",
+				fmt.Sprintf("%s has a complexity of %d, over the suggested limit of %d; consider moving some of its decisions into helper functions", fn.Name, fn.Complexity, opts.MaxComplexity), context)
+			warnings++
+		}
+	}
+	card.Note = fmt.Sprintf("%d lines of code in %d function%s", metrics.CodeLines, len(metrics.Functions), plural(len(metrics.Functions)))
+	if warnings > 0 {
+		card.Note += fmt.Sprintf(", %d warning%s", warnings, plural(warnings))
+	}
+	return card
+}
@@ -0,0 +1,37 @@
+// Command metrics measures a submission's lines of code and the length and
+// cyclomatic complexity of each function, putting the measurements in the
+// report card and warning about anything over the soft limits in
+// sdk.MetricsSpecFile. It grades nothing, so it belongs in a pipeline after
+// the stages that do. Install it in a problem type's image as
+// codegrinder-metrics:
+//
+//	{ "name": "metrics", "command": ["/usr/local/bin/codegrinder-metrics"] }
+//
+// Python analysis needs python3 in the image.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	spec, err := sdk.LoadMetricsSpec(sdk.MetricsSpecFile)
+	if err != nil {
+		card.Failf("error loading %s: %v", sdk.MetricsSpecFile, err)
+	} else {
+		card = sdk.RunMetrics(spec)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,437 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Outline describes the structure of a program: the functions and classes it
+// defines, what it imports and calls, which language constructs it uses, and
+// how long and complex it is. Each language has an analyzer that builds an outline from its syntax tree,
+// so structural checks can be written once for every language.
+type Outline struct {
+	Files      []*OutlineFile     `json:"files"`
+	Functions  []*OutlineFunction `json:"functions"`
+	Classes    []*OutlineClass    `json:"classes"`
+	Imports    []*OutlineUse      `json:"imports"`
+	Calls      []*OutlineUse      `json:"calls"`
+	Constructs []*OutlineUse      `json:"constructs"`
+}
+
+// OutlineFile is a source file. Code counts the lines with code on them.
+type OutlineFile struct {
+	Name  string `json:"name"`
+	Lines int    `json:"lines"`
+	Code  int    `json:"code"`
+}
+
+// OutlineFunction is a function or method. Calls lists the names it calls,
+// Length counts its lines, and Complexity is its cyclomatic complexity.
+type OutlineFunction struct {
+	Name       string   `json:"name"`
+	Class      string   `json:"class,omitempty"` // the class or receiver type of a method
+	File       string   `json:"file"`
+	Line       int      `json:"line"`
+	Calls      []string `json:"calls"`
+	Length     int      `json:"length"`
+	Complexity int      `json:"complexity"`
+}
+
+// OutlineClass is a class, or in Go, a named type.
+type OutlineClass struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Line    int      `json:"line"`
+	Methods []string `json:"methods"`
+}
+
+// OutlineUse is one import, call, or use of a construct. Calls are named as
+// written, such as sorted, math.sqrt, or self.push; constructs are named by
+// kind, such as for, while, lambda, or comprehension.
+type OutlineUse struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Location gives the file:line of the use.
+func (use *OutlineUse) Location() string {
+	return fmt.Sprintf("%s:%d", use.File, use.Line)
+}
+
+// CallMatches reports whether a call written as call is a call of name.
+// A name matches the whole call or the part after its last dot, so sort
+// matches both sort and items.sort, while list.sort matches only itself.
+func CallMatches(call, name string) bool {
+	return call == name || strings.HasSuffix(call, "."+name)
+}
+
+// Languages with a built-in analyzer.
+const (
+	LanguageGo     = "go"
+	LanguagePython = "python"
+)
+
+// DetectLanguage guesses the language of the program in the working directory.
+func DetectLanguage() string {
+	counts := make(map[string]int)
+	filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path != "." && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		switch filepath.Ext(path) {
+		case ".go":
+			counts[LanguageGo]++
+		case ".py":
+			counts[LanguagePython]++
+		}
+		return nil
+	})
+	if counts[LanguageGo] > counts[LanguagePython] {
+		return LanguageGo
+	}
+	return LanguagePython
+}
+
+// BuildOutline analyzes the program in the working directory. The language is
+// go or python, or the command of an external analyzer that prints an outline
+// as JSON on stdout.
+func BuildOutline(language string) (*Outline, error) {
+	var outline *Outline
+	var err error
+	switch language {
+	case LanguageGo:
+		outline, err = outlineGo()
+	case LanguagePython:
+		outline, err = outlineCommand([]string{"python3", "-c", pythonOutlineScript})
+	default:
+		fields := strings.Fields(language)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("no analyzer given")
+		}
+		outline, err = outlineCommand(fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(outline.Functions, func(i, j int) bool {
+		a, b := outline.Functions[i], outline.Functions[j]
+		return a.File < b.File || a.File == b.File && a.Line < b.Line
+	})
+	return outline, nil
+}
+
+func outlineCommand(command []string) (*Outline, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("the analyzer failed: %v\n%s", err, stderr.String())
+	}
+	outline := new(Outline)
+	if err := json.Unmarshal(output, outline); err != nil {
+		return nil, fmt.Errorf("error parsing the analyzer's outline: %v", err)
+	}
+	return outline, nil
+}
+
+// outlineGo analyzes the Go files in the working directory and its subdirectories.
+func outlineGo() (*Outline, error) {
+	outline := new(Outline)
+	fset := token.NewFileSet()
+	classes := make(map[string]*OutlineClass)
+	err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != "." && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		outline.Files = append(outline.Files, &OutlineFile{Name: path, Lines: countLines(src), Code: countGoCode(src)})
+		line := func(node ast.Node) int { return fset.Position(node.Pos()).Line }
+		for _, spec := range file.Imports {
+			name := strings.Trim(spec.Path.Value, `"`)
+			outline.Imports = append(outline.Imports, &OutlineUse{Name: name, File: path, Line: line(spec)})
+		}
+
+		for _, decl := range file.Decls {
+			var current *OutlineFunction
+			if n, ok := decl.(*ast.FuncDecl); ok {
+				current = &OutlineFunction{
+					Name:       n.Name.Name,
+					File:       path,
+					Line:       line(n),
+					Length:     fset.Position(n.End()).Line - line(n) + 1,
+					Complexity: 1,
+				}
+				if n.Recv != nil && len(n.Recv.List) > 0 {
+					current.Class = receiverType(n.Recv.List[0].Type)
+					class := classes[current.Class]
+					if class == nil {
+						class = &OutlineClass{Name: current.Class, File: path, Line: line(n)}
+						classes[current.Class] = class
+					}
+					class.Methods = append(class.Methods, current.Name)
+				}
+				outline.Functions = append(outline.Functions, current)
+			}
+			outlineGoDecl(outline, classes, decl, current, path, line)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		outline.Classes = append(outline.Classes, classes[name])
+	}
+	return outline, nil
+}
+
+// outlineGoDecl records the types, calls, and constructs in one declaration.
+// Calls are credited to current, the function being declared, if any.
+func outlineGoDecl(outline *Outline, classes map[string]*OutlineClass, decl ast.Decl, current *OutlineFunction, path string, line func(ast.Node) int) {
+	use := func(name string, node ast.Node) *OutlineUse {
+		return &OutlineUse{Name: name, File: path, Line: line(node)}
+	}
+	decision := func() {
+		if current != nil {
+			current.Complexity++
+		}
+	}
+	ast.Inspect(decl, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.TypeSpec:
+			class := classes[n.Name.Name]
+			if class == nil {
+				class = &OutlineClass{Name: n.Name.Name}
+				classes[n.Name.Name] = class
+			}
+			class.File, class.Line = path, line(n)
+		case *ast.CallExpr:
+			if name := callName(n.Fun); name != "" {
+				outline.Calls = append(outline.Calls, use(name, n))
+				if current != nil {
+					current.Calls = append(current.Calls, name)
+				}
+			}
+		case *ast.IfStmt:
+			decision()
+		case *ast.CaseClause:
+			if n.List != nil {
+				decision()
+			}
+		case *ast.CommClause:
+			if n.Comm != nil {
+				decision()
+			}
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				decision()
+			}
+		case *ast.ForStmt:
+			decision()
+			outline.Constructs = append(outline.Constructs, use("for", n))
+		case *ast.RangeStmt:
+			decision()
+			outline.Constructs = append(outline.Constructs, use("for", n), use("range", n))
+		case *ast.GoStmt:
+			outline.Constructs = append(outline.Constructs, use("go", n))
+		case *ast.DeferStmt:
+			outline.Constructs = append(outline.Constructs, use("defer", n))
+		case *ast.SelectStmt:
+			outline.Constructs = append(outline.Constructs, use("select", n))
+		case *ast.SwitchStmt, *ast.TypeSwitchStmt:
+			outline.Constructs = append(outline.Constructs, use("switch", n))
+		case *ast.FuncLit:
+			outline.Constructs = append(outline.Constructs, use("closure", n))
+		case *ast.BranchStmt:
+			if n.Tok == token.GOTO {
+				outline.Constructs = append(outline.Constructs, use("goto", n))
+			}
+		}
+		return true
+	})
+}
+
+// countLines counts the lines in a file, including a last line with no newline.
+func countLines(src []byte) int {
+	n := bytes.Count(src, []byte("\n"))
+	if len(src) > 0 && src[len(src)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// countGoCode counts the lines of a Go file with at least one token on them.
+func countGoCode(src []byte) int {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	lines := make(map[int]bool)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		lines[file.Line(pos)] = true
+	}
+	return len(lines)
+}
+
+func receiverType(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(t.X)
+	case *ast.Ident:
+		return t.Name
+	case *ast.IndexExpr:
+		return receiverType(t.X)
+	}
+	return ""
+}
+
+func callName(expr ast.Expr) string {
+	switch f := expr.(type) {
+	case *ast.Ident:
+		return f.Name
+	case *ast.SelectorExpr:
+		if x, ok := f.X.(*ast.Ident); ok {
+			return x.Name + "." + f.Sel.Name
+		}
+		return f.Sel.Name
+	}
+	return ""
+}
+
+// pythonOutlineScript builds an outline of the Python files in the working directory.
+const pythonOutlineScript = `
+import ast, io, json, os, sys, tokenize
+
+outline = {"files": [], "functions": [], "classes": [], "imports": [], "calls": [], "constructs": []}
+kinds = {
+    ast.For: "for", ast.AsyncFor: "for", ast.While: "while", ast.Lambda: "lambda",
+    ast.ListComp: "comprehension", ast.SetComp: "comprehension", ast.DictComp: "comprehension",
+    ast.GeneratorExp: "comprehension", ast.Try: "try", ast.With: "with", ast.AsyncWith: "with",
+    ast.Global: "global", ast.Nonlocal: "nonlocal", ast.Yield: "yield", ast.YieldFrom: "yield",
+    ast.Assert: "assert",
+}
+
+decisions = (ast.If, ast.IfExp, ast.For, ast.AsyncFor, ast.While, ast.ExceptHandler, ast.Assert)
+
+def complexity(node):
+    if isinstance(node, ast.BoolOp):
+        return len(node.values) - 1
+    if isinstance(node, ast.comprehension):
+        return 1 + len(node.ifs)
+    if isinstance(node, decisions):
+        return 1
+    return 0
+
+def code_lines(source):
+    lines = set()
+    skip = (tokenize.COMMENT, tokenize.NL, tokenize.NEWLINE, tokenize.INDENT, tokenize.DEDENT, tokenize.ENDMARKER)
+    for tok in tokenize.generate_tokens(io.StringIO(source).readline):
+        if tok.type not in skip:
+            lines.update(range(tok.start[0], tok.end[0] + 1))
+    return len(lines)
+
+def name_of(node):
+    if isinstance(node, ast.Name):
+        return node.id
+    if isinstance(node, ast.Attribute):
+        if isinstance(node.value, ast.Name):
+            return node.value.id + "." + node.attr
+        return node.attr
+    return ""
+
+def visit(node, path, function, cls):
+    for child in ast.iter_child_nodes(node):
+        inner_function, inner_cls = function, cls
+        if isinstance(child, (ast.FunctionDef, ast.AsyncFunctionDef)):
+            end = getattr(child, "end_lineno", None) or max(getattr(n, "lineno", 0) for n in ast.walk(child))
+            inner_function = {"name": child.name, "file": path, "line": child.lineno, "calls": [],
+                "length": end - child.lineno + 1, "complexity": 1}
+            if cls is not None and function is None:
+                inner_function["class"] = cls["name"]
+                cls["methods"].append(child.name)
+            outline["functions"].append(inner_function)
+        elif isinstance(child, ast.ClassDef):
+            inner_cls = {"name": child.name, "file": path, "line": child.lineno, "methods": []}
+            inner_function = None
+            outline["classes"].append(inner_cls)
+        elif isinstance(child, ast.Import):
+            for alias in child.names:
+                outline["imports"].append({"name": alias.name, "file": path, "line": child.lineno})
+        elif isinstance(child, ast.ImportFrom):
+            module = child.module or ""
+            outline["imports"].append({"name": module, "file": path, "line": child.lineno})
+            for alias in child.names:
+                outline["imports"].append({"name": module + "." + alias.name, "file": path, "line": child.lineno})
+        elif isinstance(child, ast.Call):
+            name = name_of(child.func)
+            if name:
+                outline["calls"].append({"name": name, "file": path, "line": child.lineno})
+                if function is not None:
+                    function["calls"].append(name)
+        if function is not None:
+            function["complexity"] += complexity(child)
+        kind = kinds.get(type(child))
+        if kind:
+            outline["constructs"].append({"name": kind, "file": path, "line": getattr(child, "lineno", 0)})
+        visit(child, path, inner_function, inner_cls)
+
+for root, dirs, files in os.walk("."):
+    dirs[:] = sorted(d for d in dirs if not d.startswith("."))
+    for name in sorted(files):
+        if not name.endswith(".py"):
+            continue
+        path = os.path.normpath(os.path.join(root, name))
+        with open(path) as f:
+            source = f.read()
+        try:
+            tree = ast.parse(source, path)
+        except SyntaxError as e:
+            sys.stderr.write("error parsing %s: %s\n" % (path, e))
+            sys.exit(1)
+        lines = source.count("\n") + (1 if source and not source.endswith("\n") else 0)
+        outline["files"].append({"name": path, "lines": lines, "code": code_lines(source)})
+        visit(tree, path, None, None)
+
+json.dump(outline, sys.stdout)
+`
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package sdk
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolate makes a command start in a process group of its own, so that
+// killGroup stops anything it starts along with it, including when it times out.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return killGroup(cmd) }
+}
+
+// killGroup kills a command started by isolate and everything else in its process group.
+func killGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
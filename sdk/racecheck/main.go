@@ -0,0 +1,37 @@
+// Command racecheck grades concurrent programs by running them under a race
+// detector and reporting each race it finds. Install it in a problem type's
+// image as codegrinder-racecheck and give it a command that runs the student's
+// code under the Go race detector, ThreadSanitizer, or Helgrind:
+//
+//	"command": ["/usr/local/bin/codegrinder-racecheck", "-runs", "5", "go", "test", "-race", "."]
+//	"command": ["/usr/local/bin/codegrinder-racecheck", "valgrind", "--tool=helgrind", "./prog"]
+//
+// Races often show up on only some runs, so -runs repeats the command. Used as
+// a pipeline stage, onFailure decides whether a clean run is required for
+// credit (stop or continue) or races are only pointed out (warn).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	runs := flag.Int("runs", 3, "how many times to run the command")
+	timeout := flag.Int("timeout", 30, "seconds each run may take")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-runs N] [-timeout SECONDS] COMMAND [ARGS...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.RunRaceCheck(flag.Args(), *runs, time.Duration(*timeout)*time.Second)
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
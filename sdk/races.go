@@ -0,0 +1,241 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// Race is one concurrency error found by a race detector.
+type Race struct {
+	Tool     string // go, tsan, or helgrind
+	Kind     string // e.g., data race or lock order inversion
+	Function string
+	Location string // file:line in the student's code, if known
+	Report   string // the detector's full report
+	Count    int    // how many times it was seen
+}
+
+// Key identifies the same race across runs.
+func (race *Race) Key() string {
+	return race.Tool + "\x00" + race.Kind + "\x00" + race.Function + "\x00" + race.Location
+}
+
+// Summary describes the race in one line.
+func (race *Race) Summary() string {
+	s := race.Kind
+	if race.Function != "" {
+		s += " in " + race.Function
+	}
+	if race.Location != "" {
+		s += " at " + race.Location
+	}
+	return s
+}
+
+var (
+	goStackFileRE   = regexp.MustCompile(`^\s+(\S+\.go):(\d+)`)
+	goStackFuncRE   = regexp.MustCompile(`^\s+(\S+)\(.*\)$`)
+	tsanWarningRE   = regexp.MustCompile(`WARNING: ThreadSanitizer: ([^(]+?)\s*(\(|$)`)
+	tsanSummaryRE   = regexp.MustCompile(`^SUMMARY: ThreadSanitizer: (.+?) (\S+:\d+)(?::\d+)? in (\S+)`)
+	helgrindLineRE  = regexp.MustCompile(`^==\d+== ?(.*)$`)
+	helgrindStartRE = regexp.MustCompile(`^(Possible data race|Thread #\d+: lock order .* violated|Thread #\d+ unlocked|Thread #\d+'s call to)`)
+	helgrindFrameRE = regexp.MustCompile(`(?:at|by) 0x[0-9A-Fa-f]+: (\S+) \(([^():]+:\d+)\)`)
+)
+
+// relative shortens a path in the working directory to a relative one.
+func relative(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// ParseRaces finds the races reported in the output of a program run under
+// the Go race detector, ThreadSanitizer, or Valgrind's Helgrind.
+func ParseRaces(output string) []*Race {
+	var races []*Race
+	lines := strings.Split(output, "\n")
+
+	// the Go race detector and ThreadSanitizer put each report between lines of equals signs
+	var block []string
+	inBlock := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "==================") {
+			if inBlock {
+				if race := parseRaceBlock(block); race != nil {
+					races = append(races, race)
+				}
+				block = nil
+			}
+			inBlock = !inBlock
+			continue
+		}
+		if inBlock {
+			block = append(block, line)
+		}
+	}
+
+	// Helgrind prefixes every line with the process ID
+	var current *Race
+	var report []string
+	finish := func() {
+		if current != nil {
+			current.Report = strings.Join(report, "\n")
+			races = append(races, current)
+		}
+		current, report = nil, nil
+	}
+	for _, line := range lines {
+		groups := helgrindLineRE.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+		text := strings.TrimSpace(groups[1])
+		switch {
+		case helgrindStartRE.MatchString(text):
+			finish()
+			kind := "data race"
+			if !strings.HasPrefix(text, "Possible data race") {
+				kind = "lock misuse"
+				if strings.Contains(text, "lock order") {
+					kind = "lock order violation"
+				}
+			}
+			current = &Race{Tool: "helgrind", Kind: kind, Count: 1}
+		case strings.HasPrefix(text, "----------------"):
+			finish()
+			continue
+		case current == nil:
+			continue
+		}
+		report = append(report, text)
+		if current.Location == "" {
+			if frame := helgrindFrameRE.FindStringSubmatch(text); frame != nil {
+				current.Function, current.Location = frame[1], frame[2]
+			}
+		}
+	}
+	finish()
+	return races
+}
+
+// parseRaceBlock reads one report from the Go race detector or ThreadSanitizer.
+func parseRaceBlock(block []string) *Race {
+	report := strings.Join(block, "\n")
+	for _, line := range block {
+		if strings.HasPrefix(line, "WARNING: DATA RACE") {
+			race := &Race{Tool: "go", Kind: "data race", Report: report, Count: 1}
+			for i, line := range block {
+				if groups := goStackFileRE.FindStringSubmatch(line); groups != nil {
+					race.Location = relative(groups[1]) + ":" + groups[2]
+					if i > 0 {
+						if fn := goStackFuncRE.FindStringSubmatch(block[i-1]); fn != nil {
+							race.Function = fn[1]
+						}
+					}
+					break
+				}
+			}
+			return race
+		}
+		if groups := tsanWarningRE.FindStringSubmatch(line); groups != nil {
+			race := &Race{Tool: "tsan", Kind: groups[1], Report: report, Count: 1}
+			for _, line := range block {
+				if summary := tsanSummaryRE.FindStringSubmatch(line); summary != nil {
+					race.Location = relative(summary[2])
+					race.Function = summary[3]
+				}
+			}
+			return race
+		}
+	}
+	return nil
+}
+
+// RunRaceCheck runs a command under a race detector the given number of times,
+// since races often only show up on some runs, and reports each distinct race
+// it finds as a failed result. A run with no races that still fails is reported too.
+func RunRaceCheck(command []string, runs int, timeout time.Duration) *ReportCard {
+	card := NewReportCard()
+	if len(command) == 0 {
+		card.Failf("no command to run")
+		return card
+	}
+	if runs < 1 {
+		runs = 1
+	}
+
+	var races []*Race
+	seen := make(map[string]*Race)
+	var failure string
+	for run := 1; run <= runs; run++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+
+		// keep going after the first race so one run can find them all
+		cmd.Env = append(os.Environ(),
+			"GORACE=halt_on_error=0",
+			"TSAN_OPTIONS=halt_on_error=0 second_deadlock_stack=1")
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		start := time.Now()
+		err := cmd.Run()
+		card.AddTime(time.Since(start))
+		timedOut := ctx.Err() == context.DeadlineExceeded
+		cancel()
+
+		found := ParseRaces(output.String())
+		for _, race := range found {
+			if prior, exists := seen[race.Key()]; exists {
+				prior.Count++
+				continue
+			}
+			seen[race.Key()] = race
+			races = append(races, race)
+		}
+		switch {
+		case timedOut:
+			failure = fmt.Sprintf("run %d was still going after %v, which may mean a deadlock\n%s", run, timeout, output.String())
+		case err != nil && len(found) == 0 && failure == "":
+			failure = fmt.Sprintf("run %d failed: %v\n%s", run, err, output.String())
+		}
+		if timedOut {
+			break
+		}
+	}
+
+	for _, race := range races {
+		details := race.Report
+		if race.Count > 1 {
+			details = fmt.Sprintf("seen in %d of %d runs\n%s", race.Count, runs, details)
+		}
+		card.AddFailedResult(race.Summary(), details, race.Location)
+	}
+	if failure != "" {
+		card.AddFailedResult("run", failure, "")
+	}
+	switch {
+	case len(races) > 0:
+		card.Failf("%d concurrency error%s found", len(races), plural(len(races)))
+	case failure != "":
+		card.Failf("the program failed")
+	default:
+		card.AddPassedResult("no races", fmt.Sprintf("%d clean run%s", runs, plural(runs)))
+	}
+	return card
+}
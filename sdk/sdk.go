@@ -0,0 +1,130 @@
+// Package sdk helps authors of scripted problem types write graders
+// that run inside a problem type's image.
+//
+// A daycare runs each action of a scripted problem type in the working
+// directory of a fresh container holding the student's files. Problem and
+// course options are in OptionsFile, one per line. A grader reports its
+// results by writing a report card to ReportFile; without one, an action
+// passes if its command exits with status zero. Mastery problems also get
+// a seed for generating fresh tests in the SeedVariable environment variable.
+// Actions marked with reference find the reference solution to the step in
+// ReferenceDir and the step's own files in SupportDir, actions marked with
+// flag find the student's flag for a capture-the-flag problem in FlagFile,
+// and actions marked with secrets find the problem's secret variables in
+// SecretsFile. Build caches shared between runs are writable only when
+// CacheVariable says so, so tools that cannot cope with a read-only cache,
+// such as ccache, can check it.
+//
+// Use "codegrinder conform" to check a problem type before deploying it.
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+const (
+	// OptionsFile holds the problem and course options, one per line.
+	OptionsFile = ".codegrinder/options"
+
+	// ReportFile is where a grader writes its report card as JSON.
+	ReportFile = ".codegrinder/report.json"
+
+	// ReferenceDir holds the reference solution for actions that ask for it.
+	ReferenceDir = ".codegrinder/reference"
+
+	// SupportDir holds a copy of the files the problem step supplies, as the
+	// author wrote them, for actions that ask for the reference solution.
+	SupportDir = ".codegrinder/support"
+
+	// FlagFile holds the student's flag for actions that ask for it.
+	FlagFile = ".codegrinder/flag"
+
+	// SecretsFile holds the problem's secret variables as a JSON object
+	// for actions that ask for them.
+	SecretsFile = ".codegrinder/secrets"
+
+	// SeedVariable names the environment variable holding the test seed for mastery problems.
+	SeedVariable = "CODEGRINDER_SEED"
+
+	// CacheVariable names the environment variable that says whether the
+	// problem type's build caches are CacheReadOnly or CacheWritable.
+	CacheVariable = "CODEGRINDER_CACHE"
+
+	// Values of CacheVariable.
+	CacheReadOnly = "readonly"
+	CacheWritable = "writable"
+)
+
+// Options returns the options given to the action, if any.
+func Options() ([]string, error) {
+	raw, err := ioutil.ReadFile(OptionsFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var options []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		if line != "" {
+			options = append(options, line)
+		}
+	}
+	return options, nil
+}
+
+// Seed returns the test seed for a mastery problem,
+// or false if the problem is not a mastery problem.
+func Seed() (uint32, bool) {
+	n, err := strconv.ParseUint(os.Getenv(SeedVariable), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// TakeSecrets reads the problem's secret variables and removes them from the
+// disk, so a grader must call it before any student code runs. It returns nil
+// if the problem has no secrets. Never pass a secret to student code, not
+// even in its environment.
+func TakeSecrets() (map[string]string, error) {
+	raw, err := ioutil.ReadFile(SecretsFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(SecretsFile); err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(raw, &secrets); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", SecretsFile, err)
+	}
+	return secrets, nil
+}
+
+// NewReport returns an empty report card that has passed.
+// Adding a failed result marks it as failed.
+func NewReport() *ReportCard {
+	return NewReportCard()
+}
+
+// WriteReport saves a report card where the daycare will find it.
+func WriteReport(card *ReportCard) error {
+	raw, err := json.MarshalIndent(card, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ReportFile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ReportFile, raw, 0644)
+}
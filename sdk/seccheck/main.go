@@ -0,0 +1,36 @@
+// Command seccheck grades code for security courses by running the static
+// analyzers named in sdk.SecuritySpecFile and scoring their findings by severity.
+// Install it in a problem type's image as codegrinder-seccheck along with the
+// analyzers the problems use:
+//
+//	"command": ["/usr/local/bin/codegrinder-seccheck"]
+//
+// Used as a pipeline stage, onFailure decides whether findings keep the student
+// from getting credit for the tests (stop or continue) or only cost points.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	spec, err := sdk.LoadSecuritySpec(sdk.SecuritySpecFile)
+	if err != nil {
+		card.Failf("error loading %s: %v", sdk.SecuritySpecFile, err)
+	} else {
+		card = sdk.RunSecurityCheck(spec)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,389 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// SecuritySpecFile describes how to grade code with static security analyzers.
+// It uses the same format as TestSpecFile:
+//
+//	[security]
+//	analyzer = bandit
+//	analyzer = semgrep
+//	rules = security/injection.yml
+//	fail-at = medium
+//	max-suppress = medium
+//	points = 10
+//
+//	[weights]
+//	high = 5
+//	medium = 2
+//	low = 1
+//
+// Each analyzer (bandit, gosec, or semgrep) runs over the working directory,
+// semgrep with the rules files shipped with the problem. The analyzers' own
+// suppression comments (nosec, nosemgrep) are ignored. Findings at fail-at
+// severity or above fail, each costing its weight against the points for a
+// clean analysis; findings below are only warnings.
+//
+// A student may suppress a finding of max-suppress severity or below with a
+// comment on the line or the line before it that names the rule and justifies
+// the exception in at least min-justification words:
+//
+//	subprocess.call(cmd, shell=True)  # codegrinder:allow B602 -- cmd is a constant built above
+//
+// Suppressed findings are listed in the report with their justification.
+const SecuritySpecFile = "security.cfg"
+
+// Severities of security findings, from least to most severe.
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+var severityRank = map[string]int{SeverityLow: 1, SeverityMedium: 2, SeverityHigh: 3}
+
+// SecurityOptions controls a security analysis.
+type SecurityOptions struct {
+	Analyzer         []string
+	Rules            []string
+	FailAt           string `gcfg:"fail-at"`
+	MaxSuppress      string `gcfg:"max-suppress"`
+	MinJustification int    `gcfg:"min-justification"`
+	Points           float64
+}
+
+// SecurityWeights gives the points each finding costs by severity.
+type SecurityWeights struct {
+	High   float64
+	Medium float64
+	Low    float64
+}
+
+// SecuritySpec is a parsed security analysis specification.
+type SecuritySpec struct {
+	Security SecurityOptions
+	Weights  SecurityWeights
+}
+
+// LoadSecuritySpec reads a security analysis specification, filling in defaults.
+func LoadSecuritySpec(path string) (*SecuritySpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(SecuritySpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	opts := &spec.Security
+	if len(opts.Analyzer) == 0 {
+		return nil, fmt.Errorf("%s names no analyzers", path)
+	}
+	for _, analyzer := range opts.Analyzer {
+		switch analyzer {
+		case "bandit", "gosec":
+		case "semgrep":
+			if len(opts.Rules) == 0 {
+				return nil, fmt.Errorf("semgrep needs at least one rules file")
+			}
+		default:
+			return nil, fmt.Errorf("unknown analyzer %q", analyzer)
+		}
+	}
+	if opts.FailAt == "" {
+		opts.FailAt = SeverityLow
+	}
+	if opts.MaxSuppress == "" {
+		opts.MaxSuppress = SeverityMedium
+	}
+	for _, severity := range []string{opts.FailAt, opts.MaxSuppress} {
+		if severityRank[severity] == 0 {
+			return nil, fmt.Errorf("unknown severity %q; use low, medium, or high", severity)
+		}
+	}
+	if opts.MinJustification == 0 {
+		opts.MinJustification = 5
+	}
+	if opts.Points == 0 {
+		opts.Points = 10
+	}
+	if spec.Weights.High == 0 {
+		spec.Weights.High = 5
+	}
+	if spec.Weights.Medium == 0 {
+		spec.Weights.Medium = 2
+	}
+	if spec.Weights.Low == 0 {
+		spec.Weights.Low = 1
+	}
+	return spec, nil
+}
+
+// Finding is one problem reported by a security analyzer.
+type Finding struct {
+	Tool     string
+	Rule     string
+	Severity string
+	Message  string
+	File     string
+	Line     int
+	Code     string
+}
+
+// Location gives the file:line of the finding.
+func (finding *Finding) Location() string {
+	if finding.Line == 0 {
+		return finding.File
+	}
+	return fmt.Sprintf("%s:%d", finding.File, finding.Line)
+}
+
+// ParseBandit reads the findings from bandit's JSON output.
+func ParseBandit(output []byte) ([]*Finding, error) {
+	var report struct {
+		Results []struct {
+			Filename string `json:"filename"`
+			Line     int    `json:"line_number"`
+			Severity string `json:"issue_severity"`
+			Text     string `json:"issue_text"`
+			TestID   string `json:"test_id"`
+			Code     string `json:"code"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("error parsing bandit output: %v", err)
+	}
+	var findings []*Finding
+	for _, elt := range report.Results {
+		findings = append(findings, &Finding{
+			Tool:     "bandit",
+			Rule:     elt.TestID,
+			Severity: strings.ToLower(elt.Severity),
+			Message:  elt.Text,
+			File:     relative(elt.Filename),
+			Line:     elt.Line,
+			Code:     elt.Code,
+		})
+	}
+	return findings, nil
+}
+
+// ParseGosec reads the findings from gosec's JSON output.
+func ParseGosec(output []byte) ([]*Finding, error) {
+	var report struct {
+		Issues []struct {
+			Severity string `json:"severity"`
+			RuleID   string `json:"rule_id"`
+			Details  string `json:"details"`
+			File     string `json:"file"`
+			Line     string `json:"line"` // a number or a range
+			Code     string `json:"code"`
+		} `json:"Issues"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("error parsing gosec output: %v", err)
+	}
+	var findings []*Finding
+	for _, elt := range report.Issues {
+		line, _ := strconv.Atoi(strings.SplitN(elt.Line, "-", 2)[0])
+		findings = append(findings, &Finding{
+			Tool:     "gosec",
+			Rule:     elt.RuleID,
+			Severity: strings.ToLower(elt.Severity),
+			Message:  elt.Details,
+			File:     relative(elt.File),
+			Line:     line,
+			Code:     elt.Code,
+		})
+	}
+	return findings, nil
+}
+
+// ParseSemgrep reads the findings from semgrep's JSON output.
+func ParseSemgrep(output []byte) ([]*Finding, error) {
+	var report struct {
+		Results []struct {
+			CheckID string `json:"check_id"`
+			Path    string `json:"path"`
+			Start   struct {
+				Line int `json:"line"`
+			} `json:"start"`
+			Extra struct {
+				Message  string `json:"message"`
+				Severity string `json:"severity"`
+				Lines    string `json:"lines"`
+			} `json:"extra"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, fmt.Errorf("error parsing semgrep output: %v", err)
+	}
+	var findings []*Finding
+	for _, elt := range report.Results {
+		severity := SeverityLow
+		switch strings.ToUpper(elt.Extra.Severity) {
+		case "ERROR":
+			severity = SeverityHigh
+		case "WARNING":
+			severity = SeverityMedium
+		}
+
+		// rules shipped with a problem are named by their path; keep only the rule name
+		rule := elt.CheckID
+		if i := strings.LastIndex(rule, "."); i >= 0 {
+			rule = rule[i+1:]
+		}
+		findings = append(findings, &Finding{
+			Tool:     "semgrep",
+			Rule:     rule,
+			Severity: severity,
+			Message:  elt.Extra.Message,
+			File:     relative(elt.Path),
+			Line:     elt.Start.Line,
+			Code:     elt.Extra.Lines,
+		})
+	}
+	return findings, nil
+}
+
+// runAnalyzer runs one analyzer over the working directory and returns its findings.
+func (spec *SecuritySpec) runAnalyzer(analyzer string) ([]*Finding, error) {
+	var cmd *exec.Cmd
+	var parse func([]byte) ([]*Finding, error)
+	switch analyzer {
+	case "bandit":
+		cmd = exec.Command("bandit", "-r", ".", "-x", "./.codegrinder", "-f", "json", "-q", "--ignore-nosec")
+		parse = ParseBandit
+	case "gosec":
+		cmd = exec.Command("gosec", "-fmt=json", "-quiet", "-nosec", "./...")
+		parse = ParseGosec
+	case "semgrep":
+		args := []string{"--json", "--quiet", "--disable-nosem", "--metrics=off"}
+		for _, rules := range spec.Security.Rules {
+			args = append(args, "--config", rules)
+		}
+		cmd = exec.Command("semgrep", append(args, "--exclude", ".codegrinder", ".")...)
+		parse = ParseSemgrep
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// analyzers exit with a failing status when they find something
+	err := cmd.Run()
+	if stdout.Len() == 0 && err != nil {
+		return nil, fmt.Errorf("%s failed: %v\n%s", analyzer, err, stderr.String())
+	}
+	return parse(stdout.Bytes())
+}
+
+var suppressionRE = regexp.MustCompile(`codegrinder:\s*allow\s+([\w.,-]+)\s*(?:--|:)?\s*(.*)$`)
+
+// suppression finds the comment suppressing a finding, returning the
+// justification and whether there was one naming its rule.
+func suppression(finding *Finding, sources map[string][]string) (string, bool) {
+	lines, exists := sources[finding.File]
+	if !exists {
+		raw, err := ioutil.ReadFile(finding.File)
+		if err == nil {
+			lines = strings.Split(string(raw), "\n")
+		}
+		sources[finding.File] = lines
+	}
+	for _, n := range []int{finding.Line, finding.Line - 1} {
+		if n < 1 || n > len(lines) {
+			continue
+		}
+		groups := suppressionRE.FindStringSubmatch(lines[n-1])
+		if groups == nil {
+			continue
+		}
+		for _, rule := range strings.Split(groups[1], ",") {
+			if strings.EqualFold(rule, finding.Rule) {
+				return strings.TrimSpace(strings.TrimRight(groups[2], "*/ \t")), true
+			}
+		}
+	}
+	return "", false
+}
+
+// RunSecurityCheck runs the analyzers in the spec over the working directory and
+// reports each finding, scoring by severity and honoring justified suppressions.
+func RunSecurityCheck(spec *SecuritySpec) *ReportCard {
+	card := NewReportCard()
+	opts := &spec.Security
+
+	var findings []*Finding
+	for _, analyzer := range opts.Analyzer {
+		found, err := spec.runAnalyzer(analyzer)
+		if err != nil {
+			card.Failf("%v", err)
+			return card
+		}
+		findings = append(findings, found...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if severityRank[a.Severity] != severityRank[b.Severity] {
+			return severityRank[a.Severity] > severityRank[b.Severity]
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+
+	weights := map[string]float64{SeverityHigh: spec.Weights.High, SeverityMedium: spec.Weights.Medium, SeverityLow: spec.Weights.Low}
+	sources := make(map[string][]string)
+	failed := 0
+	for _, finding := range findings {
+		if severityRank[finding.Severity] == 0 {
+			finding.Severity = SeverityLow
+		}
+		name := fmt.Sprintf("%s %s (%s)", finding.Tool, finding.Rule, finding.Severity)
+		details := finding.Message
+		if finding.Code != "" {
+			details += "\n" + strings.TrimRight(finding.Code, "\n")
+		}
+
+		justification, suppressed := suppression(finding, sources)
+		switch {
+		case suppressed && severityRank[finding.Severity] > severityRank[opts.MaxSuppress]:
+			details += fmt.Sprintf("\n%s findings cannot be suppressed in this problem", finding.Severity)
+		case suppressed && len(strings.Fields(justification)) < opts.MinJustification:
+			details += fmt.Sprintf("\nthe suppression needs a justification of at least %d words", opts.MinJustification)
+		case suppressed:
+			card.AddWarningResult(name, "suppressed: "+justification+"\n"+details, finding.Location())
+			continue
+		}
+
+		if severityRank[finding.Severity] < severityRank[opts.FailAt] {
+			card.AddWarningResult(name, details, finding.Location())
+			continue
+		}
+		result := card.AddFailedResult(name, details, finding.Location())
+		result.Points = weights[finding.Severity]
+		failed++
+	}
+
+	clean := card.AddPassedResult("security analysis", fmt.Sprintf("%d finding%s", len(findings), plural(len(findings))))
+	clean.Points = opts.Points
+	if failed > 0 {
+		card.Failf("%d security finding%s at %s severity or above", failed, plural(failed), opts.FailAt)
+	}
+	return card
+}
@@ -0,0 +1,682 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// WorldSpecFile describes the worlds a grid robot program is graded in.
+// It uses the same format as TestSpecFile, with one section per world:
+//
+//	[defaults]
+//	timeout = 5
+//	max-moves = 200
+//
+//	[world "corridor"]
+//	size = 5 3
+//	start = 0 1 east
+//	wall = 2 0
+//	wall = 2 2
+//	beeper = 4 1
+//	goal = at 4 1
+//	goal = carrying 1
+//
+//	[world "exact-path"]
+//	size = 3 1
+//	start = 0 0 east
+//	trace-file = tests/exact-path.trace
+//
+// The student's program drives the robot by writing one command per line
+// to standard output: move, left, right, pick, or put. It can also ask
+// front_clear?, beeper?, or facing?, and reads the answer (yes, no, or a
+// heading) from standard input, so it must flush its output after asking.
+// Cells are numbered from 0 0 in the southwest corner, and wall blocks a cell.
+//
+// A world passes if the robot never crashes, every goal holds at the end, and,
+// if any trace files are given, the moves match one of them exactly. A trace
+// file lists the moves one per line. Goals are:
+//
+//	at X Y         the robot ends in this cell
+//	facing H       the robot ends facing north, east, south, or west
+//	carrying N     the robot ends holding N beepers
+//	beepers N      N beepers are left in the world
+//	beeper X Y N   N beepers are left in this cell
+//	visited X Y    the robot passed through this cell
+//	moves N        the robot took at most N moves
+//
+// Each world gets an animated replay, an HTML file the student can open in a browser.
+const WorldSpecFile = "worlds.cfg"
+
+var headings = []string{"north", "east", "south", "west"}
+
+// SimDefaults applies to every world that does not set its own value.
+type SimDefaults struct {
+	Timeout  int // seconds
+	MaxMoves int `gcfg:"max-moves"`
+	Points   float64
+}
+
+// World is one world in a world specification.
+type World struct {
+	Name      string   `gcfg:"-"`
+	Size      string   `gcfg:"size"`
+	Start     string   `gcfg:"start"`
+	Wall      []string `gcfg:"wall"`
+	Beeper    []string `gcfg:"beeper"`
+	Goal      []string `gcfg:"goal"`
+	TraceFile []string `gcfg:"trace-file"`
+	Timeout   int      `gcfg:"timeout"`
+	MaxMoves  int      `gcfg:"max-moves"`
+	Points    float64  `gcfg:"points"`
+	Hidden    bool     `gcfg:"hidden"`
+
+	order   int
+	width   int
+	height  int
+	start   robot
+	walls   map[cell]bool
+	beepers map[cell]int
+	goals   [][]string
+	traces  [][]string
+}
+
+// WorldSpec is a parsed world specification.
+type WorldSpec struct {
+	Defaults SimDefaults
+	World    map[string]*World
+}
+
+type cell struct {
+	X, Y int
+}
+
+type robot struct {
+	cell
+	Heading  int // index into headings
+	Carrying int
+}
+
+var worldSectionRE = regexp.MustCompile(`(?m)^\s*\[\s*world\s+"((?:[^"\\]|\\.)*)"\s*\]`)
+
+// LoadWorlds reads a world specification, filling in defaults and reading
+// trace files, which are relative to the spec file.
+func LoadWorlds(path string) ([]*World, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(WorldSpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	order := sectionOrder(worldSectionRE, raw)
+
+	dir := filepath.Dir(path)
+	var worlds []*World
+	for name, world := range spec.World {
+		world.Name = name
+		world.order = order[name]
+		if world.Timeout == 0 {
+			world.Timeout = spec.Defaults.Timeout
+		}
+		if world.Timeout == 0 {
+			world.Timeout = 5
+		}
+		if world.MaxMoves == 0 {
+			world.MaxMoves = spec.Defaults.MaxMoves
+		}
+		if world.MaxMoves == 0 {
+			world.MaxMoves = 1000
+		}
+		if world.Points == 0 {
+			world.Points = spec.Defaults.Points
+		}
+		if err := world.parse(); err != nil {
+			return nil, fmt.Errorf("world %q: %v", name, err)
+		}
+		for _, traceFile := range world.TraceFile {
+			contents, err := ioutil.ReadFile(filepath.Join(dir, traceFile))
+			if err != nil {
+				return nil, fmt.Errorf("world %q: %v", name, err)
+			}
+			world.traces = append(world.traces, strings.Fields(string(contents)))
+		}
+		if len(world.goals) == 0 && len(world.traces) == 0 {
+			return nil, fmt.Errorf("world %q has no goals and no trace files", name)
+		}
+		worlds = append(worlds, world)
+	}
+	sort.Slice(worlds, func(i, j int) bool {
+		if worlds[i].order != worlds[j].order {
+			return worlds[i].order < worlds[j].order
+		}
+		return worlds[i].Name < worlds[j].Name
+	})
+	return worlds, nil
+}
+
+func parseInts(s string, n int) ([]int, error) {
+	fields := strings.Fields(s)
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d numbers in %q", n, s)
+	}
+	var ints []int
+	for _, field := range fields {
+		i, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", field)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+func parseHeading(s string) (int, error) {
+	for i, heading := range headings {
+		if s == heading {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not north, east, south, or west", s)
+}
+
+// parse checks the world and fills in its layout and goals.
+func (world *World) parse() error {
+	size, err := parseInts(world.Size, 2)
+	if err != nil {
+		return fmt.Errorf("size: %v", err)
+	}
+	world.width, world.height = size[0], size[1]
+	if world.width < 1 || world.height < 1 {
+		return fmt.Errorf("size must be at least 1 1")
+	}
+
+	start := strings.Fields(world.Start)
+	if len(start) != 3 {
+		return fmt.Errorf("start must be X Y HEADING")
+	}
+	xy, err := parseInts(start[0]+" "+start[1], 2)
+	if err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	world.start.cell = cell{xy[0], xy[1]}
+	if world.start.Heading, err = parseHeading(start[2]); err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+
+	world.walls = make(map[cell]bool)
+	for _, wall := range world.Wall {
+		xy, err := parseInts(wall, 2)
+		if err != nil {
+			return fmt.Errorf("wall: %v", err)
+		}
+		world.walls[cell{xy[0], xy[1]}] = true
+	}
+	world.beepers = make(map[cell]int)
+	for _, beeper := range world.Beeper {
+		xy, err := parseInts(beeper, 2)
+		if err != nil {
+			return fmt.Errorf("beeper: %v", err)
+		}
+		world.beepers[cell{xy[0], xy[1]}]++
+	}
+	if !world.open(world.start.cell) {
+		return fmt.Errorf("the robot starts outside the world or in a wall")
+	}
+
+	for _, goal := range world.Goal {
+		fields := strings.Fields(goal)
+		if len(fields) == 0 {
+			return fmt.Errorf("empty goal")
+		}
+		var err error
+		switch fields[0] {
+		case "at", "visited":
+			_, err = parseInts(strings.Join(fields[1:], " "), 2)
+		case "facing":
+			if len(fields) != 2 {
+				err = fmt.Errorf("expected a heading")
+			} else {
+				_, err = parseHeading(fields[1])
+			}
+		case "carrying", "beepers", "moves":
+			_, err = parseInts(strings.Join(fields[1:], " "), 1)
+		case "beeper":
+			_, err = parseInts(strings.Join(fields[1:], " "), 3)
+		default:
+			err = fmt.Errorf("unknown goal")
+		}
+		if err != nil {
+			return fmt.Errorf("goal %q: %v", goal, err)
+		}
+		world.goals = append(world.goals, fields)
+	}
+	return nil
+}
+
+// open reports whether the robot can be in a cell.
+func (world *World) open(c cell) bool {
+	return c.X >= 0 && c.Y >= 0 && c.X < world.width && c.Y < world.height && !world.walls[c]
+}
+
+// SimFrame is the state of a world after one move, for the replay.
+type SimFrame struct {
+	Move     string         `json:"move"`
+	X        int            `json:"x"`
+	Y        int            `json:"y"`
+	Heading  string         `json:"heading"`
+	Carrying int            `json:"carrying"`
+	Beepers  map[string]int `json:"beepers"`
+}
+
+// simulation is one run of the student's program in a world.
+type simulation struct {
+	world   *World
+	robot   robot
+	beepers map[cell]int
+	visited map[cell]bool
+	moves   []string
+	frames  []*SimFrame
+}
+
+func (sim *simulation) frame(move string) {
+	beepers := make(map[string]int)
+	for c, n := range sim.beepers {
+		if n > 0 {
+			beepers[fmt.Sprintf("%d,%d", c.X, c.Y)] = n
+		}
+	}
+	sim.frames = append(sim.frames, &SimFrame{
+		Move:     move,
+		X:        sim.robot.X,
+		Y:        sim.robot.Y,
+		Heading:  headings[sim.robot.Heading],
+		Carrying: sim.robot.Carrying,
+		Beepers:  beepers,
+	})
+}
+
+func (sim *simulation) ahead() cell {
+	c := sim.robot.cell
+	switch headings[sim.robot.Heading] {
+	case "north":
+		c.Y++
+	case "east":
+		c.X++
+	case "south":
+		c.Y--
+	case "west":
+		c.X--
+	}
+	return c
+}
+
+// do carries out one line from the program, returning the answer to a question, if any.
+func (sim *simulation) do(line string) (string, error) {
+	switch line {
+	case "front_clear?":
+		if sim.world.open(sim.ahead()) {
+			return "yes", nil
+		}
+		return "no", nil
+	case "beeper?":
+		if sim.beepers[sim.robot.cell] > 0 {
+			return "yes", nil
+		}
+		return "no", nil
+	case "facing?":
+		return headings[sim.robot.Heading], nil
+	case "move", "left", "right", "pick", "put":
+	default:
+		return "", fmt.Errorf("unknown command %q", line)
+	}
+
+	if len(sim.moves) >= sim.world.MaxMoves {
+		return "", fmt.Errorf("the robot made more than %d moves", sim.world.MaxMoves)
+	}
+	sim.moves = append(sim.moves, line)
+	switch line {
+	case "move":
+		next := sim.ahead()
+		if !sim.world.open(next) {
+			sim.frame("crash")
+			return "", fmt.Errorf("the robot crashed moving %s from %d %d", headings[sim.robot.Heading], sim.robot.X, sim.robot.Y)
+		}
+		sim.robot.cell = next
+		sim.visited[next] = true
+	case "left":
+		sim.robot.Heading = (sim.robot.Heading + 3) % 4
+	case "right":
+		sim.robot.Heading = (sim.robot.Heading + 1) % 4
+	case "pick":
+		if sim.beepers[sim.robot.cell] == 0 {
+			return "", fmt.Errorf("there is no beeper to pick up at %d %d", sim.robot.X, sim.robot.Y)
+		}
+		sim.beepers[sim.robot.cell]--
+		sim.robot.Carrying++
+	case "put":
+		if sim.robot.Carrying == 0 {
+			return "", fmt.Errorf("the robot has no beeper to put down at %d %d", sim.robot.X, sim.robot.Y)
+		}
+		sim.robot.Carrying--
+		sim.beepers[sim.robot.cell]++
+	}
+	sim.frame(line)
+	return "", nil
+}
+
+// check returns the goals that do not hold at the end of the simulation.
+func (sim *simulation) check() []string {
+	var failed []string
+	for _, goal := range sim.world.goals {
+		n := make([]int, len(goal)-1)
+		for i, field := range goal[1:] {
+			n[i], _ = strconv.Atoi(field)
+		}
+		var ok bool
+		switch goal[0] {
+		case "at":
+			ok = sim.robot.cell == cell{n[0], n[1]}
+		case "visited":
+			ok = sim.visited[cell{n[0], n[1]}]
+		case "facing":
+			ok = headings[sim.robot.Heading] == goal[1]
+		case "carrying":
+			ok = sim.robot.Carrying == n[0]
+		case "beepers":
+			total := 0
+			for _, count := range sim.beepers {
+				total += count
+			}
+			ok = total == n[0]
+		case "beeper":
+			ok = sim.beepers[cell{n[0], n[1]}] == n[2]
+		case "moves":
+			ok = len(sim.moves) <= n[0]
+		}
+		if !ok {
+			failed = append(failed, strings.Join(goal, " "))
+		}
+	}
+	return failed
+}
+
+// matchesTrace reports whether the moves match one of the acceptable traces.
+func (sim *simulation) matchesTrace() bool {
+	for _, trace := range sim.world.traces {
+		if strings.Join(trace, " ") == strings.Join(sim.moves, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// run runs the program in the world, feeding it answers until it exits.
+func (sim *simulation) run(command []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sim.world.Timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	var simErr error
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		answer, err := sim.do(line)
+		if err != nil {
+			simErr = err
+			cmd.Process.Kill()
+			break
+		}
+		if answer != "" {
+			io.WriteString(stdin, answer+"\n")
+		}
+	}
+	stdin.Close()
+	io.Copy(ioutil.Discard, stdout)
+	err = cmd.Wait()
+
+	switch {
+	case simErr != nil:
+		return simErr
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("still running after %d second%s (flush your output after asking a question)",
+			sim.world.Timeout, plural(sim.world.Timeout))
+	case err != nil:
+		msg := fmt.Sprintf("the program failed: %v", err)
+		if stderr.Len() > 0 {
+			msg += "\n" + stderr.String()
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// RunSimulations runs the command in each world and reports the results,
+// attaching an animated replay of each run.
+func RunSimulations(command []string, worlds []*World) *ReportCard {
+	card := NewReportCard()
+	if len(command) == 0 {
+		card.Failf("no command to run")
+		return card
+	}
+	for _, world := range worlds {
+		sim := &simulation{
+			world:   world,
+			robot:   world.start,
+			beepers: make(map[cell]int),
+			visited: map[cell]bool{world.start.cell: true},
+		}
+		for c, n := range world.beepers {
+			sim.beepers[c] = n
+		}
+		sim.frame("start")
+
+		start := time.Now()
+		err := sim.run(command)
+		card.AddTime(time.Since(start))
+
+		var problems []string
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			for _, goal := range sim.check() {
+				problems = append(problems, "goal not met: "+goal)
+			}
+			if len(world.traces) > 0 && !sim.matchesTrace() {
+				problems = append(problems, "the robot did not follow an accepted path")
+			}
+		}
+
+		var result *ReportCardResult
+		if len(problems) > 0 {
+			details := strings.Join(problems, "\n") + "\nyour moves:\n" + strings.Join(sim.moves, "\n")
+			result = card.AddFailedResult(world.Name, details, "")
+		} else {
+			result = card.AddPassedResult(world.Name, fmt.Sprintf("%d move%s", len(sim.moves), plural(len(sim.moves))))
+		}
+		result.Points = world.Points
+		if world.Hidden {
+			result.Details = ""
+			if result.Outcome == "failed" {
+				result.Details = "this is a hidden test, so its world and moves are not shown"
+			}
+			continue
+		}
+
+		replay, err := sim.replay(len(problems) == 0)
+		if err != nil {
+			result.Details += fmt.Sprintf("\nerror making replay: %v", err)
+			continue
+		}
+		card.AddArtifact(replayName(world.Name), "text/html", replay, world.Name)
+	}
+	return card
+}
+
+var unsafeNameRE = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// replayName turns a world name into a file name for its replay.
+func replayName(world string) string {
+	return "replay-" + strings.Trim(unsafeNameRE.ReplaceAllString(world, "-"), "-.") + ".html"
+}
+
+// replay renders the simulation as a self-contained animated HTML page.
+func (sim *simulation) replay(passed bool) ([]byte, error) {
+	var walls [][2]int
+	for c := range sim.world.walls {
+		walls = append(walls, [2]int{c.X, c.Y})
+	}
+	data := map[string]interface{}{
+		"name":   sim.world.Name,
+		"width":  sim.world.width,
+		"height": sim.world.height,
+		"walls":  walls,
+		"frames": sim.frames,
+		"passed": passed,
+	}
+	var buf bytes.Buffer
+	if err := replayTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var replayTemplate = template.Must(template.New("replay").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Replay: {{.name}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+canvas { border: 1px solid #888; display: block; margin: 1em 0; }
+#status { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>{{.name}}</h1>
+<canvas id="world"></canvas>
+<div>
+<button id="play">Play</button>
+<button id="back">&larr;</button>
+<button id="next">&rarr;</button>
+<input id="step" type="range" min="0" value="0">
+</div>
+<p id="status"></p>
+<script>
+const replay = {{.}};
+const size = 48;
+const canvas = document.getElementById("world");
+const slider = document.getElementById("step");
+const status = document.getElementById("status");
+const turns = { north: 0, east: 1, south: 2, west: 3 };
+canvas.width = replay.width * size;
+canvas.height = replay.height * size;
+slider.max = replay.frames.length - 1;
+const ctx = canvas.getContext("2d");
+
+function draw(step) {
+  const frame = replay.frames[step];
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  ctx.strokeStyle = "#ccc";
+  for (let x = 0; x < replay.width; x++) {
+    for (let y = 0; y < replay.height; y++) {
+      ctx.strokeRect(x * size, (replay.height - 1 - y) * size, size, size);
+    }
+  }
+  ctx.fillStyle = "#555";
+  for (const [x, y] of replay.walls || []) {
+    ctx.fillRect(x * size, (replay.height - 1 - y) * size, size, size);
+  }
+
+  // the path so far
+  ctx.strokeStyle = "#39f";
+  ctx.lineWidth = 3;
+  ctx.beginPath();
+  for (let i = 0; i <= step; i++) {
+    const f = replay.frames[i];
+    const px = (f.x + 0.5) * size, py = (replay.height - 0.5 - f.y) * size;
+    if (i === 0) ctx.moveTo(px, py); else ctx.lineTo(px, py);
+  }
+  ctx.stroke();
+  ctx.lineWidth = 1;
+
+  ctx.fillStyle = "#e90";
+  ctx.font = "14px sans-serif";
+  for (const key in frame.beepers) {
+    const [x, y] = key.split(",").map(Number);
+    const cx = (x + 0.5) * size, cy = (replay.height - 0.5 - y) * size;
+    ctx.beginPath();
+    ctx.arc(cx, cy, size / 6, 0, 2 * Math.PI);
+    ctx.fill();
+    if (frame.beepers[key] > 1) ctx.fillText(frame.beepers[key], cx + size / 5, cy - size / 5);
+  }
+
+  // the robot, as a triangle pointing where it faces
+  const cx = (frame.x + 0.5) * size, cy = (replay.height - 0.5 - frame.y) * size;
+  ctx.save();
+  ctx.translate(cx, cy);
+  ctx.rotate(turns[frame.heading] * Math.PI / 2);
+  ctx.fillStyle = frame.move === "crash" ? "#d22" : "#2a2";
+  ctx.beginPath();
+  ctx.moveTo(0, -size / 3);
+  ctx.lineTo(size / 4, size / 4);
+  ctx.lineTo(-size / 4, size / 4);
+  ctx.closePath();
+  ctx.fill();
+  ctx.restore();
+
+  let text = "step " + step + " of " + (replay.frames.length - 1) + ": " + frame.move + ", carrying " + frame.carrying;
+  if (step === replay.frames.length - 1) text += replay.passed ? " (passed)" : " (failed)";
+  status.textContent = text;
+  slider.value = step;
+}
+
+let step = 0, timer = null;
+function show(n) { step = Math.max(0, Math.min(replay.frames.length - 1, n)); draw(step); }
+function stop() { clearInterval(timer); timer = null; document.getElementById("play").textContent = "Play"; }
+document.getElementById("play").onclick = () => {
+  if (timer) return stop();
+  if (step === replay.frames.length - 1) show(0);
+  document.getElementById("play").textContent = "Pause";
+  timer = setInterval(() => { if (step === replay.frames.length - 1) stop(); else show(step + 1); }, 400);
+};
+document.getElementById("back").onclick = () => { stop(); show(step - 1); };
+document.getElementById("next").onclick = () => { stop(); show(step + 1); };
+slider.oninput = () => { stop(); show(Number(slider.value)); };
+show(0);
+</script>
+</body>
+</html>
+`))
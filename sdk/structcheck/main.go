@@ -0,0 +1,51 @@
+// Command structcheck grades the structure of a solution by checking it against
+// the requirements in sdk.StructureSpecFile and the forbidden imports, calls,
+// and constructs in sdk.ForbiddenSpecFile, using its syntax tree. It suits
+// problems that call for recursion, forbid built-in helpers, or ask for
+// particular classes and methods. Install it in a problem type's image as
+// codegrinder-structcheck:
+//
+//	"command": ["/usr/local/bin/codegrinder-structcheck"]
+//
+// Python analysis needs python3 in the image.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/russross/codegrinder/sdk"
+)
+
+func main() {
+	if len(os.Args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	card := sdk.NewReport()
+	var structure *sdk.StructureSpec
+	var forbidden *sdk.ForbiddenSpec
+	var err error
+	if _, statErr := os.Stat(sdk.StructureSpecFile); statErr == nil {
+		if structure, err = sdk.LoadStructureSpec(sdk.StructureSpecFile); err != nil {
+			card.Failf("error loading %s: %v", sdk.StructureSpecFile, err)
+		}
+	}
+	if _, statErr := os.Stat(sdk.ForbiddenSpecFile); err == nil && statErr == nil {
+		if forbidden, err = sdk.LoadForbiddenSpec(sdk.ForbiddenSpecFile); err != nil {
+			card.Failf("error loading %s: %v", sdk.ForbiddenSpecFile, err)
+		}
+	}
+	switch {
+	case err != nil:
+	case structure == nil && forbidden == nil:
+		card.Failf("found neither %s nor %s", sdk.StructureSpecFile, sdk.ForbiddenSpecFile)
+	default:
+		card = sdk.RunStructureCheck(structure, forbidden)
+	}
+	if err := sdk.WriteReport(card); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
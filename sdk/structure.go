@@ -0,0 +1,293 @@
+package sdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// StructureSpecFile lists structural requirements a solution must meet, checked
+// against the program's syntax tree rather than its behavior. It uses the same
+// format as TestSpecFile:
+//
+//	[structure]
+//	language = python
+//
+//	[requirement "fib is recursive"]
+//	rule = recursion fib
+//
+//	[requirement "no built-in sorting"]
+//	rule = no-call sorted
+//	rule = no-call sort
+//	message = write your own merge sort instead of using the built-in sort
+//
+//	[requirement "Stack class"]
+//	rule = class Stack push pop peek
+//	points = 2
+//
+// Each requirement is reported as a result under its name, and fails if any of
+// its rules is broken. The rules are:
+//
+//	function NAME           a function or method named NAME is defined
+//	class NAME [METHOD...]  a class (in Go, a type) named NAME is defined with each method
+//	recursion [NAME]        the function NAME, or some function, calls itself directly
+//	call NAME               NAME is called somewhere
+//	no-call NAME            NAME is never called
+//	import NAME             NAME is imported
+//	no-import NAME          NAME, and nothing inside it, is imported
+//	construct KIND          KIND is used somewhere
+//	no-construct KIND       KIND is never used
+//
+// A call NAME matches calls written as NAME or ending in .NAME, so sort matches
+// items.sort(). Python constructs are for, while, lambda, comprehension, try,
+// with, global, nonlocal, yield, and assert; Go constructs are for, range, go,
+// defer, select, switch, closure, and goto. The language is go or python, the
+// default being whichever has more source files, or the command of an analyzer
+// for another language that prints an Outline as JSON.
+const StructureSpecFile = "structure.cfg"
+
+// StructureOptions controls a structural check.
+type StructureOptions struct {
+	Language string
+}
+
+// StructureRequirement is one named requirement.
+type StructureRequirement struct {
+	Rule    []string
+	Message string
+	Points  float64
+}
+
+// StructureSpec is a parsed structural requirements specification.
+type StructureSpec struct {
+	Structure   StructureOptions
+	Requirement map[string]*StructureRequirement
+}
+
+// structureRuleArgs gives the least and most arguments each rule takes, with -1 for no limit.
+var structureRuleArgs = map[string][2]int{
+	"function":     {1, 1},
+	"class":        {1, -1},
+	"recursion":    {0, 1},
+	"call":         {1, 1},
+	"no-call":      {1, 1},
+	"import":       {1, 1},
+	"no-import":    {1, 1},
+	"construct":    {1, 1},
+	"no-construct": {1, 1},
+}
+
+// LoadStructureSpec reads a structural requirements specification, filling in defaults.
+func LoadStructureSpec(path string) (*StructureSpec, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(StructureSpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if len(spec.Requirement) == 0 {
+		return nil, fmt.Errorf("%s has no requirements", path)
+	}
+	if spec.Structure.Language == "" {
+		spec.Structure.Language = DetectLanguage()
+	}
+	for name, req := range spec.Requirement {
+		if len(req.Rule) == 0 {
+			return nil, fmt.Errorf("requirement %q has no rules", name)
+		}
+		for _, rule := range req.Rule {
+			fields := strings.Fields(rule)
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("requirement %q has an empty rule", name)
+			}
+			limits, ok := structureRuleArgs[fields[0]]
+			if !ok {
+				return nil, fmt.Errorf("requirement %q has unknown rule %q", name, fields[0])
+			}
+			if n := len(fields) - 1; n < limits[0] || limits[1] >= 0 && n > limits[1] {
+				return nil, fmt.Errorf("requirement %q has the wrong number of arguments for %s", name, fields[0])
+			}
+		}
+		if req.Points == 0 {
+			req.Points = 1
+		}
+	}
+	return spec, nil
+}
+
+// checkRule tests one rule against an outline. If the rule is broken, it
+// explains why and gives the location of the offending code, if any.
+func checkRule(outline *Outline, rule string) (ok bool, why, context string) {
+	fields := strings.Fields(rule)
+	kind, args := fields[0], fields[1:]
+	switch kind {
+	case "function":
+		for _, fn := range outline.Functions {
+			if fn.Name == args[0] {
+				return true, "", ""
+			}
+		}
+		return false, fmt.Sprintf("define a function named %s", args[0]), ""
+
+	case "class":
+		for _, class := range outline.Classes {
+			if class.Name != args[0] {
+				continue
+			}
+			var missing []string
+			for _, method := range args[1:] {
+				found := false
+				for _, m := range class.Methods {
+					found = found || m == method
+				}
+				if !found {
+					missing = append(missing, method)
+				}
+			}
+			if len(missing) > 0 {
+				return false, fmt.Sprintf("%s needs method%s %s", class.Name, plural(len(missing)), strings.Join(missing, ", ")),
+					fmt.Sprintf("%s:%d", class.File, class.Line)
+			}
+			return true, "", ""
+		}
+		return false, fmt.Sprintf("define a class named %s", args[0]), ""
+
+	case "recursion":
+		defined := false
+		for _, fn := range outline.Functions {
+			if len(args) > 0 && fn.Name != args[0] {
+				continue
+			}
+			defined = true
+			for _, call := range fn.Calls {
+				if CallMatches(call, fn.Name) {
+					return true, "", ""
+				}
+			}
+		}
+		switch {
+		case len(args) == 0:
+			return false, "use recursion: some function must call itself", ""
+		case !defined:
+			return false, fmt.Sprintf("define a function named %s", args[0]), ""
+		default:
+			return false, fmt.Sprintf("%s must be recursive: it should call itself", args[0]), ""
+		}
+
+	case "call", "no-call":
+		for _, call := range outline.Calls {
+			if CallMatches(call.Name, args[0]) {
+				if kind == "no-call" {
+					return false, fmt.Sprintf("do not call %s", args[0]), call.Location()
+				}
+				return true, "", ""
+			}
+		}
+		if kind == "call" {
+			return false, fmt.Sprintf("call %s", args[0]), ""
+		}
+		return true, "", ""
+
+	case "import", "no-import":
+		for _, imp := range outline.Imports {
+			if importMatches(imp.Name, args[0]) {
+				if kind == "no-import" {
+					return false, fmt.Sprintf("do not import %s", imp.Name), imp.Location()
+				}
+				return true, "", ""
+			}
+		}
+		if kind == "import" {
+			return false, fmt.Sprintf("import %s", args[0]), ""
+		}
+		return true, "", ""
+
+	case "construct", "no-construct":
+		for _, use := range outline.Constructs {
+			if use.Name == args[0] {
+				if kind == "no-construct" {
+					return false, fmt.Sprintf("do not use %s", args[0]), use.Location()
+				}
+				return true, "", ""
+			}
+		}
+		if kind == "construct" {
+			return false, fmt.Sprintf("use %s", args[0]), ""
+		}
+		return true, "", ""
+	}
+	return false, fmt.Sprintf("unknown rule %q", kind), ""
+}
+
+// Check reports whether the outline meets each requirement in the spec.
+func (spec *StructureSpec) Check(card *ReportCard, outline *Outline) {
+	var names []string
+	for name := range spec.Requirement {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failed := 0
+	for _, name := range names {
+		req := spec.Requirement[name]
+		var reasons []string
+		var context string
+		for _, rule := range req.Rule {
+			if ok, why, where := checkRule(outline, rule); !ok {
+				reasons = append(reasons, why)
+				if context == "" {
+					context = where
+				}
+			}
+		}
+		var result *ReportCardResult
+		switch {
+		case len(reasons) == 0:
+			result = card.AddPassedResult(name, "requirement met")
+		case req.Message != "":
+			result = card.AddFailedResult(name, req.Message+"\n"+strings.Join(reasons, "\n"), context)
+			failed++
+		default:
+			result = card.AddFailedResult(name, strings.Join(reasons, "\n"), context)
+			failed++
+		}
+		result.Points = req.Points
+	}
+	if failed > 0 {
+		card.Failf("%d of %d structural requirement%s not met", failed, len(names), plural(len(names)))
+	}
+}
+
+// RunStructureCheck builds an outline of the program in the working directory
+// and checks it against the structural requirements and forbidden code lists.
+// Either spec may be nil.
+func RunStructureCheck(structure *StructureSpec, forbidden *ForbiddenSpec) *ReportCard {
+	card := NewReportCard()
+	var language string
+	switch {
+	case structure != nil:
+		language = structure.Structure.Language
+	case forbidden != nil:
+		language = forbidden.Forbidden.Language
+	default:
+		card.Failf("nothing to check")
+		return card
+	}
+	outline, err := BuildOutline(language)
+	if err != nil {
+		card.Failf("%v", err)
+		return card
+	}
+	if structure != nil {
+		structure.Check(card, outline)
+	}
+	if forbidden != nil {
+		forbidden.Check(card, outline)
+	}
+	return card
+}
@@ -0,0 +1,293 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/gcfg"
+)
+
+// TestSpecFile is the declarative test specification for I/O-style problems.
+// It uses the same format as problem.cfg, with one section per test:
+//
+//	[defaults]
+//	compare = tokens
+//	timeout = 5
+//
+//	[test "empty-list"]
+//	input = "0\n"
+//	expected = "nothing to sort\n"
+//
+//	[test "large-list"]
+//	arg = --quiet
+//	input-file = tests/large.in
+//	expected-file = tests/large.out
+//	points = 3
+//	hidden = true
+//
+// Tests run in the order they appear in the file. Each one runs the command
+// given to RunTests followed by its args, with its input on stdin, and the
+// output is compared with what is expected. The details of hidden tests are
+// not shown to the student.
+const TestSpecFile = "tests.cfg"
+
+// Comparison modes for test output.
+const (
+	CompareExact   = "exact"   // byte for byte
+	CompareTrim    = "trim"    // ignoring trailing whitespace on each line and trailing blank lines
+	CompareTokens  = "tokens"  // ignoring all differences in whitespace
+	CompareNumbers = "numbers" // as tokens, with numbers equal within the tolerance
+	CompareRegexp  = "regexp"  // the expected output is a regular expression the whole output must match
+)
+
+// TestDefaults applies to every test that does not set its own value.
+type TestDefaults struct {
+	Compare   string
+	Timeout   int // seconds
+	Tolerance float64
+	Points    float64
+}
+
+// TestCase is one test in a test specification.
+type TestCase struct {
+	Name         string   `gcfg:"-"`
+	Arg          []string `gcfg:"arg"`
+	Input        string   `gcfg:"input"`
+	InputFile    string   `gcfg:"input-file"`
+	Expected     string   `gcfg:"expected"`
+	ExpectedFile string   `gcfg:"expected-file"`
+	Compare      string   `gcfg:"compare"`
+	Timeout      int      `gcfg:"timeout"`
+	Tolerance    float64  `gcfg:"tolerance"`
+	Points       float64  `gcfg:"points"`
+	Hidden       bool     `gcfg:"hidden"`
+
+	order int
+}
+
+// TestSpec is a parsed test specification.
+type TestSpec struct {
+	Defaults TestDefaults
+	Test     map[string]*TestCase
+}
+
+var testSectionRE = regexp.MustCompile(`(?m)^\s*\[\s*test\s+"((?:[^"\\]|\\.)*)"\s*\]`)
+
+// sectionOrder maps the name of each section matched by re to its position in the
+// raw source of a spec file, since gcfg does not keep the order of sections.
+// The first submatch of re must be the section name.
+func sectionOrder(re *regexp.Regexp, raw []byte) map[string]int {
+	order := make(map[string]int)
+	for i, match := range re.FindAllStringSubmatch(string(raw), -1) {
+		if _, exists := order[match[1]]; !exists {
+			order[match[1]] = i
+		}
+	}
+	return order
+}
+
+// LoadTests reads a test specification, filling in defaults and reading
+// input and expected output files, which are relative to the spec file.
+func LoadTests(path string) ([]*TestCase, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(TestSpec)
+	if err := gcfg.ReadStringInto(spec, string(raw)); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	order := sectionOrder(testSectionRE, raw)
+
+	dir := filepath.Dir(path)
+	var tests []*TestCase
+	for name, test := range spec.Test {
+		test.Name = name
+		test.order = order[name]
+		if test.Compare == "" {
+			test.Compare = spec.Defaults.Compare
+		}
+		if test.Compare == "" {
+			test.Compare = CompareTrim
+		}
+		switch test.Compare {
+		case CompareExact, CompareTrim, CompareTokens, CompareNumbers, CompareRegexp:
+		default:
+			return nil, fmt.Errorf("test %q: unknown comparison %q", name, test.Compare)
+		}
+		if test.Timeout == 0 {
+			test.Timeout = spec.Defaults.Timeout
+		}
+		if test.Tolerance == 0 {
+			test.Tolerance = spec.Defaults.Tolerance
+		}
+		if test.Points == 0 {
+			test.Points = spec.Defaults.Points
+		}
+		if test.InputFile != "" {
+			if test.Input != "" {
+				return nil, fmt.Errorf("test %q has both input and input-file", name)
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(dir, test.InputFile))
+			if err != nil {
+				return nil, fmt.Errorf("test %q: %v", name, err)
+			}
+			test.Input = string(contents)
+		}
+		if test.ExpectedFile != "" {
+			if test.Expected != "" {
+				return nil, fmt.Errorf("test %q has both expected and expected-file", name)
+			}
+			contents, err := ioutil.ReadFile(filepath.Join(dir, test.ExpectedFile))
+			if err != nil {
+				return nil, fmt.Errorf("test %q: %v", name, err)
+			}
+			test.Expected = string(contents)
+		}
+		if test.Compare == CompareRegexp {
+			if _, err := regexp.Compile(test.Expected); err != nil {
+				return nil, fmt.Errorf("test %q: bad regular expression: %v", name, err)
+			}
+		}
+		tests = append(tests, test)
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		if tests[i].order != tests[j].order {
+			return tests[i].order < tests[j].order
+		}
+		return tests[i].Name < tests[j].Name
+	})
+	return tests, nil
+}
+
+// RunTests runs each test against the command and reports the results.
+func RunTests(command []string, tests []*TestCase) *ReportCard {
+	card := NewReportCard()
+	for _, test := range tests {
+		start := time.Now()
+		output, err := test.run(command)
+		card.AddTime(time.Since(start))
+
+		var result *ReportCardResult
+		switch {
+		case err != nil:
+			result = card.AddFailedResult(test.Name, err.Error(), "")
+		case !test.Matches(output):
+			result = card.AddFailedResult(test.Name, test.describeMismatch(output), "")
+		default:
+			result = card.AddPassedResult(test.Name, "")
+		}
+		result.Points = test.Points
+		if test.Hidden {
+			result.Details = ""
+			if result.Outcome == "failed" {
+				result.Details = "this is a hidden test, so its input and output are not shown"
+			}
+		}
+	}
+	return card
+}
+
+func (test *TestCase) run(command []string) (string, error) {
+	if len(command) == 0 {
+		return "", fmt.Errorf("no command to run")
+	}
+	ctx := context.Background()
+	if test.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(test.Timeout)*time.Second)
+		defer cancel()
+	}
+	args := append(append([]string{}, command[1:]...), test.Arg...)
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stdin = strings.NewReader(test.Input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("still running after %d second%s", test.Timeout, plural(test.Timeout))
+		}
+		msg := fmt.Sprintf("the program failed: %v", err)
+		if stderr.Len() > 0 {
+			msg += "\n" + stderr.String()
+		}
+		return "", fmt.Errorf("%s", msg)
+	}
+	return stdout.String(), nil
+}
+
+// Matches reports whether the output is what the test expects.
+func (test *TestCase) Matches(output string) bool {
+	switch test.Compare {
+	case CompareExact:
+		return output == test.Expected
+	case CompareTokens:
+		return strings.Join(strings.Fields(output), " ") == strings.Join(strings.Fields(test.Expected), " ")
+	case CompareNumbers:
+		got, want := strings.Fields(output), strings.Fields(test.Expected)
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] == want[i] {
+				continue
+			}
+			a, errA := strconv.ParseFloat(got[i], 64)
+			b, errB := strconv.ParseFloat(want[i], 64)
+			if errA != nil || errB != nil || math.Abs(a-b) > test.Tolerance {
+				return false
+			}
+		}
+		return true
+	case CompareRegexp:
+		re, err := regexp.Compile(`\A(?:` + test.Expected + `)\z`)
+		return err == nil && re.MatchString(output)
+	default:
+		return trimLines(output) == trimLines(test.Expected)
+	}
+}
+
+func (test *TestCase) describeMismatch(output string) string {
+	var msg strings.Builder
+	if test.Input != "" {
+		fmt.Fprintf(&msg, "input:\n%s\n", test.Input)
+	}
+	if len(test.Arg) > 0 {
+		fmt.Fprintf(&msg, "arguments: %s\n", strings.Join(test.Arg, " "))
+	}
+	if test.Compare == CompareRegexp {
+		fmt.Fprintf(&msg, "expected output matching:\n%s\n", test.Expected)
+	} else {
+		fmt.Fprintf(&msg, "expected output:\n%s\n", test.Expected)
+	}
+	fmt.Fprintf(&msg, "your output:\n%s", output)
+	return msg.String()
+}
+
+func trimLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,11 @@
+#!/bin/bash
+
+# Build, scan, and push every toolchain image, recording each one as the
+# image the daycares must run. Daycares install them with the
+# codegrinder-images-pull timer and stop taking jobs for a problem type
+# until they run the new image.
+
+set -e
+
+cd "$(dirname "$0")/../../containers"
+codegrinder images build "$@" */
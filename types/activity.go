@@ -0,0 +1,36 @@
+package types
+
+import "time"
+
+// ProblemSetActivity shows when students in a course worked on a problem set,
+// counted from the submission log. Every save counts as a submission; only
+// saves that were graded count toward the pass rate, and saves from before
+// their results were kept are never counted as graded. Times are in the
+// server's time zone. Hours lists only the hours with any activity, oldest first.
+// Heatmap counts submissions by day of the week (Sunday first) and hour of the day.
+type ProblemSetActivity struct {
+	CourseID     int64           `json:"courseID"`
+	ProblemSetID int64           `json:"problemSetID"`
+	Problem      string          `json:"problem,omitempty"`
+	From         time.Time       `json:"from,omitempty"`
+	To           time.Time       `json:"to,omitempty"`
+	Submissions  int64           `json:"submissions"`
+	Students     int64           `json:"students"`
+	Graded       int64           `json:"graded"`
+	Passed       int64           `json:"passed"`
+	PassRate     float64         `json:"passRate"`
+	Hours        []*ActivityHour `json:"hours"`
+	Heatmap      [7][24]int64    `json:"heatmap"`
+}
+
+// ActivityHour is the work done on a problem set in one hour.
+// Students counts the distinct students who saved anything, and PassRate
+// is the fraction of graded submissions that passed.
+type ActivityHour struct {
+	Hour        time.Time `json:"hour"`
+	Submissions int64     `json:"submissions"`
+	Students    int64     `json:"students"`
+	Graded      int64     `json:"graded"`
+	Passed      int64     `json:"passed"`
+	PassRate    float64   `json:"passRate"`
+}
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxAnnouncementLength is the longest message an announcement can have.
+const MaxAnnouncementLength = 2000
+
+// Announcement is a message from an instructor to the students in a course,
+// or only to those working on one problem set in it. Students see it until
+// they acknowledge it.
+type Announcement struct {
+	ID               int64     `json:"id" meddler:"id,pk"`
+	CourseID         int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID     int64     `json:"problemSetID,omitempty" meddler:"problem_set_id,zeroisnull"`
+	CourseLabel      string    `json:"courseLabel" meddler:"-"`
+	ProblemSetUnique string    `json:"problemSetUnique,omitempty" meddler:"-"`
+	Message          string    `json:"message" meddler:"message"`
+	AuthorID         int64     `json:"authorID" meddler:"author_id"`
+	Acknowledged     int64     `json:"acknowledged,omitempty" meddler:"-"`
+	CreatedAt        time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt        time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+func (elt *Announcement) Normalize() error {
+	elt.Message = strings.TrimSpace(elt.Message)
+	if elt.Message == "" {
+		return fmt.Errorf("announcement must have a message")
+	}
+	if len(elt.Message) > MaxAnnouncementLength {
+		return fmt.Errorf("announcement is %d characters long, but cannot be more than %d", len(elt.Message), MaxAnnouncementLength)
+	}
+	return nil
+}
+
+// Audience describes who the announcement is for, such as "CS-1400" or "CS-1400/cs1400-loops".
+func (elt *Announcement) Audience() string {
+	if elt.ProblemSetUnique != "" {
+		return elt.CourseLabel + "/" + elt.ProblemSetUnique
+	}
+	return elt.CourseLabel
+}
+
+// AnnouncementAck records that a user has read an announcement.
+type AnnouncementAck struct {
+	AnnouncementID int64     `json:"announcementID" meddler:"announcement_id"`
+	UserID         int64     `json:"userID" meddler:"user_id"`
+	AcknowledgedAt time.Time `json:"acknowledgedAt" meddler:"acknowledged_at,localtime"`
+}
@@ -0,0 +1,35 @@
+package types
+
+import "time"
+
+// ArchivedAssignment is an assignment a student can no longer work on,
+// because the course has ended or been archived or because they dropped it.
+// They can still download their work until AvailableUntil, which is zero
+// if the server keeps it available indefinitely.
+type ArchivedAssignment struct {
+	AssignmentID     int64     `json:"assignmentID"`
+	CourseID         int64     `json:"courseID"`
+	CourseName       string    `json:"courseName"`
+	CourseLabel      string    `json:"courseLabel"`
+	Term             string    `json:"term,omitempty"`
+	ProblemSetID     int64     `json:"problemSetID"`
+	ProblemSetUnique string    `json:"problemSetUnique"`
+	CanvasTitle      string    `json:"canvasTitle"`
+	Score            float64   `json:"score"`
+	Dropped          bool      `json:"dropped,omitempty"`
+	ClosedAt         time.Time `json:"closedAt"`
+	AvailableUntil   time.Time `json:"availableUntil,omitempty"`
+}
+
+// Name gives the course label and problem set, such as "CS-1400/cs1400-loops".
+func (elt *ArchivedAssignment) Name() string {
+	return elt.CourseLabel + "/" + elt.ProblemSetUnique
+}
+
+// ArchivedWork is everything a student can download for an archived assignment:
+// their last commit for each problem, keyed by problem unique ID, and a signed grade report.
+type ArchivedWork struct {
+	Assignment *ArchivedAssignment `json:"assignment"`
+	Commits    map[string]*Commit  `json:"commits"`
+	Report     *GradeReport        `json:"report"`
+}
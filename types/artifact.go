@@ -0,0 +1,72 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MaxArtifactBytes is the most artifact data a single report card can carry.
+// Report cards are stored with every commit, so graders should keep artifacts small.
+const MaxArtifactBytes = 2 * 1024 * 1024
+
+// ReportCardArtifact is a file a grader returns for the student to look at,
+// such as an animated replay of a simulation or a screenshot of a GUI program.
+// Name is a plain file name, and Result names the result it illustrates, if any.
+// Stage and Variant are filled in like those of ReportCardResult.
+type ReportCardArtifact struct {
+	Name      string `json:"name"`
+	MediaType string `json:"mediaType"`
+	Contents  []byte `json:"contents"`
+	Result    string `json:"result,omitempty"`
+	Stage     string `json:"stage,omitempty"`
+	Variant   string `json:"variant,omitempty"`
+}
+
+// ValidArtifactName checks that an artifact name is a plain file name
+// that is safe to write into a student's problem directory.
+func ValidArtifactName(name string) error {
+	if name == "" || name != path.Base(name) || strings.ContainsAny(name, `/\:`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%q is not a valid artifact name", name)
+	}
+	return nil
+}
+
+// AddArtifact attaches a file to the report card for the student to look at.
+func (elt *ReportCard) AddArtifact(name, mediaType string, contents []byte, result string) *ReportCardArtifact {
+	artifact := &ReportCardArtifact{
+		Name:      name,
+		MediaType: mediaType,
+		Contents:  contents,
+		Result:    result,
+	}
+	elt.Artifacts = append(elt.Artifacts, artifact)
+	return artifact
+}
+
+// TrimArtifacts drops artifacts with bad names and any that would take the
+// report card past MaxArtifactBytes, noting what was dropped.
+func (elt *ReportCard) TrimArtifacts() {
+	total := 0
+	var kept []*ReportCardArtifact
+	for _, artifact := range elt.Artifacts {
+		if err := ValidArtifactName(artifact.Name); err != nil {
+			elt.Note = joinNote(elt.Note, err.Error())
+			continue
+		}
+		if total+len(artifact.Contents) > MaxArtifactBytes {
+			elt.Note = joinNote(elt.Note, fmt.Sprintf("artifact %s was left out because it is too large", artifact.Name))
+			continue
+		}
+		total += len(artifact.Contents)
+		kept = append(kept, artifact)
+	}
+	elt.Artifacts = kept
+}
+
+func joinNote(note, msg string) string {
+	if note == "" {
+		return msg
+	}
+	return note + ", " + msg
+}
@@ -0,0 +1,18 @@
+package types
+
+import "time"
+
+// Broadcast is an instructor's session that everyone in a course can watch
+// live, such as a demonstration of grading work against the tests. A course
+// has at most one broadcast at a time, and a new one replaces the old.
+// ShareID is the shared daycare session students connect to.
+type Broadcast struct {
+	CourseID   int64     `json:"courseID" meddler:"course_id"`
+	UserID     int64     `json:"userID" meddler:"user_id"`
+	Instructor string    `json:"instructor,omitempty" meddler:"-"`
+	ShareID    string    `json:"shareID" meddler:"share_id"`
+	Problem    string    `json:"problem,omitempty" meddler:"problem,zeroisnull"`
+	Action     string    `json:"action,omitempty" meddler:"action,zeroisnull"`
+	StartedAt  time.Time `json:"startedAt" meddler:"started_at,localtime"`
+	ExpiresAt  time.Time `json:"expiresAt" meddler:"expires_at,localtime"`
+}
@@ -1,6 +1,12 @@
 package types
 
-import "time"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"time"
+)
 
 type ProblemSetBundle struct {
 	ProblemSet *ProblemSet `json:"problemSets"`
@@ -12,16 +18,79 @@ type ProblemBundle struct {
 	Problem          *Problem       `json:"problem"`
 	ProblemSteps     []*ProblemStep `json:"problemSteps"`
 	ProblemSignature string         `json:"problemSignature,omitempty"`
+	Environment      string         `json:"environment,omitempty"` // sealed; see SealEnvironment
 	Commits          []*Commit      `json:"commits"`
 	CommitSignatures []string       `json:"commitSignatures,omitempty"`
 }
 
 type CommitBundle struct {
-	Problem          *Problem       `json:"problem"`
-	ProblemSteps     []*ProblemStep `json:"problemSteps"`
-	ProblemSignature string         `json:"problemSignature,omitempty"`
-	Commit           *Commit        `json:"commit"`
-	CommitSignature  string         `json:"commitSignature,omitempty"`
+	Problem             *Problem             `json:"problem"`
+	ProblemSteps        []*ProblemStep       `json:"problemSteps"`
+	ProblemSignature    string               `json:"problemSignature,omitempty"`
+	ProblemTypeOverride *ProblemTypeOverride `json:"problemTypeOverride,omitempty"`
+	Environment         string               `json:"environment,omitempty"` // sealed; see SealEnvironment
+	Reference           string               `json:"reference,omitempty"`   // sealed; see SealReference
+	Imports             map[string]string    `json:"imports,omitempty"`     // files from earlier problems; see ProblemSetImport
+	Matrix              []*ToolchainVariant  `json:"matrix,omitempty"`      // toolchain versions to grade against; see ToolchainVariant
+	Commit              *Commit              `json:"commit"`
+	CommitSignature     string               `json:"commitSignature,omitempty"`
+	Path                string               `json:"path,omitempty"`   // set when this commit placed the student on a path
+	Tenant              string               `json:"tenant,omitempty"` // hostname of the tenant the commit belongs to; signed
+}
+
+// SigningSignature returns the signature the commit signature is chained to:
+// the problem signature, or the override signature when the course overrides
+// the problem type defaults. The sealed environment, imported files, the
+// grading matrix, and the tenant are chained on after that.
+func (bundle *CommitBundle) SigningSignature(secret string) string {
+	sig := bundle.ProblemSignature
+	if bundle.ProblemTypeOverride != nil {
+		sig = bundle.ProblemTypeOverride.ComputeSignature(secret, sig)
+	}
+	if bundle.Environment != "" {
+		v := make(url.Values)
+		v.Add("environment", bundle.Environment)
+		v.Add("signature", sig)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(encode(v)))
+		sig = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	if len(bundle.Imports) > 0 {
+		v := make(url.Values)
+		for name, contents := range bundle.Imports {
+			v.Add("import-"+name, contents)
+		}
+		v.Add("signature", sig)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(encode(v)))
+		sig = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	if len(bundle.Matrix) > 0 {
+		v := make(url.Values)
+		for _, variant := range bundle.Matrix {
+			v.Add("variant-"+variant.Label, variant.Image)
+		}
+		v.Add("signature", sig)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(encode(v)))
+		sig = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	if bundle.Tenant != "" {
+		v := make(url.Values)
+		v.Add("tenant", bundle.Tenant)
+		v.Add("signature", sig)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(encode(v)))
+		sig = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return sig
+}
+
+// SigningSignature returns the signature the commit signatures of a new problem
+// are chained to, the same as for a CommitBundle of one of its steps.
+func (bundle *ProblemBundle) SigningSignature(secret string) string {
+	elt := &CommitBundle{ProblemSignature: bundle.ProblemSignature, Environment: bundle.Environment}
+	return elt.SigningSignature(secret)
 }
 
 // MaxDaycareRequestAge is the maximum age of a daycare-signed commit to be saved.
@@ -30,10 +99,15 @@ const MaxDaycareRequestAge = 15 * time.Minute
 
 // DaycareRequest represents a single request from a client to the daycare.
 // These objects are streamed across a websockets connection.
+// Share asks the daycare to let others watch the session: view lets them see
+// it, input lets them type into it as well, and broadcast lets a whole class
+// watch. A later request can change view to input or back again to grant or
+// take back permission to type.
 type DaycareRequest struct {
 	UserID       int64         `json:"userID,omitempty"`
 	CommitBundle *CommitBundle `json:"commitBundle,omitempty"`
 	Stdin        string        `json:"stdin,omitempty"`
+	Share        string        `json:"share,omitempty"`
 }
 
 // DaycareResponse represents a single response from the daycare back to a client.
@@ -42,4 +116,17 @@ type DaycareResponse struct {
 	CommitBundle *CommitBundle `json:"commitBundle,omitempty"`
 	Event        *EventMessage `json:"event,omitempty"`
 	Error        string        `json:"error,omitempty"`
+	ShareID      string        `json:"shareID,omitempty"`
 }
+
+// MaxShareAge is the longest a shared session can be watched,
+// even if the session itself is still going.
+const MaxShareAge = time.Hour
+
+// ShareView, ShareInput, and ShareBroadcast are the ways a session can be
+// shared. A broadcast is for a whole class: anyone can watch, but no one can type.
+const (
+	ShareView      = "view"
+	ShareInput     = "input"
+	ShareBroadcast = "broadcast"
+)
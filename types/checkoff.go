@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// CheckoffPolicy requires students to demonstrate a problem set in person,
+// such as a lab shown to a TA or an oral exam. The check-off counts for
+// Weight of the grade and the autograded score for the rest.
+type CheckoffPolicy struct {
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"problem_set_id"`
+	Weight       float64   `json:"weight" meddler:"weight"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks a check-off policy.
+func (policy *CheckoffPolicy) Normalize() error {
+	if policy.Weight <= 0.0 || policy.Weight > 1.0 {
+		return fmt.Errorf("the check-off weight must be more than 0 and at most 1")
+	}
+	return nil
+}
+
+// Combine merges the autograded score with whether the student has been checked off.
+func (policy *CheckoffPolicy) Combine(auto float64, checkedOff bool) float64 {
+	score := auto * (1.0 - policy.Weight)
+	if checkedOff {
+		score += policy.Weight
+	}
+	return score
+}
+
+// Checkoff records that a student demonstrated their work for an assignment in person.
+type Checkoff struct {
+	AssignmentID int64     `json:"assignmentID,omitempty" meddler:"assignment_id"`
+	Student      string    `json:"student,omitempty" meddler:"-"`
+	Note         string    `json:"note,omitempty" meddler:"note,zeroisnull"`
+	GraderID     int64     `json:"graderID,omitempty" meddler:"grader_id,zeroisnull"`
+	Grader       string    `json:"grader,omitempty" meddler:"-"`
+	CheckedOffAt time.Time `json:"checkedOffAt" meddler:"checked_off_at,localtime"`
+}
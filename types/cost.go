@@ -0,0 +1,77 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+// ExpensiveFraction is the share of problems, by total CPU time, that cost reports flag as expensive.
+const ExpensiveFraction = 0.1
+
+// ResourceUsage measures what a grading run cost to run.
+// Runtime is how long the container existed, which includes setting it up.
+type ResourceUsage struct {
+	Runtime     time.Duration `json:"runtime"`
+	CPUSeconds  float64       `json:"cpuSeconds"`
+	MaxMemoryMB float64       `json:"maxMemoryMB"`
+}
+
+// Add combines the usage of another run into this one, for containers that ran together.
+func (elt *ResourceUsage) Add(other *ResourceUsage) {
+	elt.Runtime += other.Runtime
+	elt.CPUSeconds += other.CPUSeconds
+	if other.MaxMemoryMB > elt.MaxMemoryMB {
+		elt.MaxMemoryMB = other.MaxMemoryMB
+	}
+}
+
+// ProblemCost totals the resources used grading a problem for courses in one term.
+type ProblemCost struct {
+	ProblemID      int64     `json:"problemID" meddler:"problem_id"`
+	Unique         string    `json:"unique" meddler:"-"`
+	Term           string    `json:"term" meddler:"term"`
+	Runs           int64     `json:"runs" meddler:"runs"`
+	RuntimeSeconds float64   `json:"runtimeSeconds" meddler:"runtime_seconds"`
+	CPUSeconds     float64   `json:"cpuSeconds" meddler:"cpu_seconds"`
+	MemoryMBTotal  float64   `json:"memoryMBTotal" meddler:"memory_mb_total"`
+	MaxMemoryMB    float64   `json:"maxMemoryMB" meddler:"max_memory_mb"`
+	Expensive      bool      `json:"expensive" meddler:"-"`
+	UpdatedAt      time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// AverageRuntime is the mean container runtime per grading run, in seconds.
+func (elt *ProblemCost) AverageRuntime() float64 {
+	if elt.Runs == 0 {
+		return 0
+	}
+	return elt.RuntimeSeconds / float64(elt.Runs)
+}
+
+// AverageCPU is the mean CPU time per grading run, in seconds.
+func (elt *ProblemCost) AverageCPU() float64 {
+	if elt.Runs == 0 {
+		return 0
+	}
+	return elt.CPUSeconds / float64(elt.Runs)
+}
+
+// AverageMemory is the mean memory high-water mark per grading run, in megabytes.
+func (elt *ProblemCost) AverageMemory() float64 {
+	if elt.Runs == 0 {
+		return 0
+	}
+	return elt.MemoryMBTotal / float64(elt.Runs)
+}
+
+// FlagExpensiveProblems sorts a cost report with the most CPU time first and
+// flags the problems in the top ExpensiveFraction, always including the most expensive.
+func FlagExpensiveProblems(report []*ProblemCost) {
+	sort.SliceStable(report, func(i, j int) bool { return report[i].CPUSeconds > report[j].CPUSeconds })
+	n := int(float64(len(report)) * ExpensiveFraction)
+	if n < 1 {
+		n = 1
+	}
+	for i, elt := range report {
+		elt.Expensive = i < n && elt.CPUSeconds > 0
+	}
+}
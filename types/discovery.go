@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+// Institution is a school listed in the public discovery index of CodeGrinder servers,
+// which "grind init --school" searches so students do not need to know the hostname.
+// Schools register their own server, and it is listed once an administrator of the
+// index approves it. Contact is only shown to administrators.
+type Institution struct {
+	ID        int64     `json:"id" meddler:"id,pk"`
+	Name      string    `json:"name" meddler:"name"`
+	Hostname  string    `json:"hostname" meddler:"hostname"`
+	Contact   string    `json:"contact,omitempty" meddler:"contact"`
+	Approved  bool      `json:"approved" meddler:"approved"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
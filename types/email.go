@@ -0,0 +1,31 @@
+package types
+
+import "time"
+
+// EmailSubmission is work a student sent to the email gateway, typically because
+// they could not reach the LMS near a deadline. It waits for an instructor to
+// approve it, and is then graded like any other submission.
+// Status is one of pending, approved, or rejected.
+type EmailSubmission struct {
+	ID           int64             `json:"id" meddler:"id,pk"`
+	CourseID     int64             `json:"courseID" meddler:"course_id"`
+	AssignmentID int64             `json:"assignmentID" meddler:"assignment_id"`
+	UserID       int64             `json:"userID" meddler:"user_id"`
+	Sender       string            `json:"sender" meddler:"sender"`
+	Subject      string            `json:"subject" meddler:"subject"`
+	Files        map[string]string `json:"files" meddler:"files,json"`
+	Status       string            `json:"status" meddler:"status"`
+	Note         string            `json:"note,omitempty" meddler:"note,zeroisnull"`
+	ReviewedBy   int64             `json:"reviewedBy,omitempty" meddler:"reviewed_by,zeroisnull"`
+	ReviewedAt   time.Time         `json:"reviewedAt,omitempty" meddler:"reviewed_at,localtimez"`
+	CreatedAt    time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// EmailGateway tells a student how to submit work by email.
+// The key must appear in the subject line along with the assignment,
+// and the message must come from the address the LMS has for the student.
+type EmailGateway struct {
+	Address string `json:"address"`
+	Key     string `json:"key"`
+}
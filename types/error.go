@@ -0,0 +1,62 @@
+package types
+
+import "net/http"
+
+// Error codes used in APIError. Clients should branch on the code,
+// not the message, which is meant for people and may change.
+const (
+	ErrorBadRequest       = "bad_request"
+	ErrorNotAuthenticated = "not_authenticated"
+	ErrorForbidden        = "forbidden"
+	ErrorNotFound         = "not_found"
+	ErrorConflict         = "conflict"
+	ErrorTooLarge         = "too_large"
+	ErrorRateLimited      = "rate_limited"
+	ErrorInternal         = "internal"
+	ErrorUnavailable      = "unavailable"
+)
+
+// APIError is the body of every error response from the server.
+// Hint suggests what the user can do about it, and Retryable says
+// whether the same request might succeed if it is sent again later.
+type APIError struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Hint      string `json:"hint,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// NewAPIError builds an error response, choosing the code, hint,
+// and retryable flag that fit the HTTP status.
+func NewAPIError(status int, message string) *APIError {
+	e := &APIError{Status: status, Code: ErrorInternal, Message: message}
+	switch status {
+	case http.StatusBadRequest:
+		e.Code = ErrorBadRequest
+	case http.StatusUnauthorized:
+		e.Code = ErrorNotAuthenticated
+		e.Hint = "your session has expired or is not valid; log in again through your LMS"
+	case http.StatusForbidden:
+		e.Code = ErrorForbidden
+	case http.StatusNotFound:
+		e.Code = ErrorNotFound
+	case http.StatusConflict:
+		e.Code = ErrorConflict
+	case http.StatusRequestEntityTooLarge:
+		e.Code = ErrorTooLarge
+	case http.StatusTooManyRequests:
+		e.Code = ErrorRateLimited
+		e.Hint = "too many requests; wait a little while and try again"
+		e.Retryable = true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		e.Code = ErrorUnavailable
+		e.Hint = "the server is temporarily unavailable; try again in a few minutes"
+		e.Retryable = true
+	}
+	return e
+}
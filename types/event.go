@@ -11,10 +11,17 @@ const MaxDetailsLen = 50e3
 
 // ReportCard gives the results of a graded run
 type ReportCard struct {
-	Passed   bool                `json:"passed"`
-	Note     string              `json:"note"`
-	Duration time.Duration       `json:"duration"`
-	Results  []*ReportCardResult `json:"results"`
+	Passed    bool                  `json:"passed"`
+	Note      string                `json:"note"`
+	Duration  time.Duration         `json:"duration"`
+	Results   []*ReportCardResult   `json:"results"`
+	Stages    []*ReportCardStage    `json:"stages,omitempty"`
+	Variants  []*ReportCardVariant  `json:"variants,omitempty"`
+	Artifacts []*ReportCardArtifact `json:"artifacts,omitempty"`
+	Resources *ResourceUsage        `json:"resources,omitempty"`
+	Toolchain *Toolchain            `json:"toolchain,omitempty"`
+	Feedback  []*ReportCardFeedback `json:"feedback,omitempty"`
+	Metrics   *CodeMetrics          `json:"metrics,omitempty"`
 }
 
 // ReportCardResult Outcomes:
@@ -22,15 +29,29 @@ type ReportCard struct {
 //   failed
 //   error
 //   skipped
+//   warning (failed in a pipeline stage that only warns; does not count)
 // Details: a multi-line message that should
 //   be displayed in a monospace font
 // Context:
 //   path/to/file.py:line#
+// Points: the weight of the result in the score, or 1 if zero
+// Stage: the pipeline stage that produced the result, if any
 type ReportCardResult struct {
-	Name    string `json:"name"`
-	Outcome string `json:"outcome"`
-	Details string `json:"details,omitempty"`
-	Context string `json:"context,omitempty"`
+	Name    string  `json:"name"`
+	Outcome string  `json:"outcome"`
+	Details string  `json:"details,omitempty"`
+	Context string  `json:"context,omitempty"`
+	Points  float64 `json:"points,omitempty"`
+	Stage   string  `json:"stage,omitempty"`
+	Variant string  `json:"variant,omitempty"`
+}
+
+// Weight returns the points the result is worth.
+func (r *ReportCardResult) Weight() float64 {
+	if r.Points > 0 {
+		return r.Points
+	}
+	return 1.0
 }
 
 // EventMessage follows one of these forms:
@@ -137,19 +158,42 @@ func (elt *ReportCard) AddPassedResult(name, details string) *ReportCardResult {
 	return r
 }
 
+// AddWarningResult adds a result that points something out without counting
+// toward the score.
+func (elt *ReportCard) AddWarningResult(name, details, context string) *ReportCardResult {
+	r := &ReportCardResult{
+		Name:    name,
+		Outcome: "warning",
+		Details: details,
+		Context: context,
+	}
+	elt.Results = append(elt.Results, r)
+	return r
+}
+
 func (elt *ReportCard) ComputeScore() float64 {
 	if len(elt.Results) == 0 {
 		return 0.0
 	}
-	passed := 0
+	passed, total := 0.0, 0.0
 	for _, result := range elt.Results {
+		if result.Outcome == "warning" {
+			continue
+		}
 		if result.Outcome == "passed" {
-			passed++
+			passed += result.Weight()
+		}
+		total += result.Weight()
+	}
+	if total == 0.0 {
+		if elt.Passed {
+			return 1.0
 		}
+		return 0.0
 	}
-	score := float64(passed) / float64(len(elt.Results))
+	score := passed / total
 	if !elt.Passed && score >= 1.0 {
-		score = float64(passed) / float64(len(elt.Results)+1)
+		score = passed / (total + 1.0)
 	}
 	return score
 }
@@ -0,0 +1,42 @@
+package types
+
+import "time"
+
+// MaxExemplars is the most exemplars that can be published for one problem in a course.
+const MaxExemplars = 5
+
+// ExemplarConsent records a student's permission to show their passing work
+// on a problem, without their name, to other students in the course.
+type ExemplarConsent struct {
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	ProblemID    int64     `json:"problemID" meddler:"problem_id"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// Exemplar is a passing solution that an instructor has chosen to show the
+// students in a course once VisibleAt has passed. The files are copied when it
+// is published, without any lines that mention its author, so later changes
+// by its author are not shown. VisibleAt is never before the problem is due,
+// and students never see which commit it came from.
+type Exemplar struct {
+	ID        int64             `json:"id" meddler:"id,pk"`
+	CourseID  int64             `json:"courseID" meddler:"course_id"`
+	ProblemID int64             `json:"problemID" meddler:"problem_id"`
+	CommitID  int64             `json:"commitID,omitempty" meddler:"commit_id"`
+	Note      string            `json:"note,omitempty" meddler:"note"`
+	Files     map[string]string `json:"files" meddler:"files,json"`
+	VisibleAt time.Time         `json:"visibleAt" meddler:"visible_at,localtime"`
+	CreatedAt time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// ExemplarCandidate is a passing solution whose author has agreed to share it,
+// as listed for instructors choosing exemplars.
+type ExemplarCandidate struct {
+	CommitID     int64     `json:"commitID" meddler:"commit_id"`
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	UserName     string    `json:"userName" meddler:"user_name"`
+	UserEmail    string    `json:"userEmail" meddler:"user_email"`
+	Published    bool      `json:"published" meddler:"published"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
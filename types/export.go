@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// ExportPage is one page of everything a user has submitted, oldest first.
+// Each page includes the assignments its commits belong to, so pages can be
+// handled one at a time. Next is passed as the after parameter to get the next
+// page, and is zero on the last page.
+type ExportPage struct {
+	Assignments []*ExportAssignment `json:"assignments"`
+	Commits     []*ExportCommit     `json:"commits"`
+	Next        int64               `json:"next,omitempty"`
+}
+
+// ExportAssignment describes an assignment in an export, with a signed grade report.
+type ExportAssignment struct {
+	AssignmentID     int64        `json:"assignmentID"`
+	CourseName       string       `json:"courseName"`
+	CourseLabel      string       `json:"courseLabel"`
+	Term             string       `json:"term,omitempty"`
+	ProblemSetUnique string       `json:"problemSetUnique"`
+	CanvasTitle      string       `json:"canvasTitle"`
+	Score            float64      `json:"score"`
+	CreatedAt        time.Time    `json:"createdAt"`
+	Report           *GradeReport `json:"report"`
+}
+
+// ExportCommit is one saved commit in an export.
+type ExportCommit struct {
+	Problem string  `json:"problem"`
+	Commit  *Commit `json:"commit"`
+}
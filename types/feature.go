@@ -0,0 +1,101 @@
+package types
+
+import (
+	"fmt"
+	"hash/crc32"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var featureNameRE = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// FeatureFlag controls a capability that is being rolled out gradually,
+// such as hints or exam mode. Percent (on a scale of 0 to 100) of the courses
+// have the feature turned on. A course can also turn it on or off for itself;
+// see CourseFeatureFlag.
+type FeatureFlag struct {
+	Name        string    `json:"name" meddler:"name"`
+	Description string    `json:"description,omitempty" meddler:"description,zeroisnull"`
+	Percent     float64   `json:"percent" meddler:"percent"`
+	CreatedAt   time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt   time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks the feature flag for sane values.
+func (flag *FeatureFlag) Normalize() error {
+	flag.Name = strings.TrimSpace(flag.Name)
+	flag.Description = strings.TrimSpace(flag.Description)
+	if !featureNameRE.MatchString(flag.Name) {
+		return fmt.Errorf("feature names must be lower case letters, digits, dashes, and underscores")
+	}
+	if flag.Percent < 0.0 || flag.Percent > 100.0 {
+		return fmt.Errorf("rollout percentage must be between 0 and 100")
+	}
+	return nil
+}
+
+// InRollout reports whether a course falls within the rollout percentage.
+// Each course lands in the same place for a given flag every time,
+// so raising the percentage only ever adds courses.
+func (flag *FeatureFlag) InRollout(courseID int64) bool {
+	if flag.Percent >= 100.0 {
+		return true
+	}
+	bucket := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s/%d", flag.Name, courseID))) % 10000
+	return float64(bucket) < flag.Percent*100.0
+}
+
+// CourseFeatureFlag turns a feature on or off for one course,
+// regardless of the rollout percentage.
+type CourseFeatureFlag struct {
+	CourseID  int64     `json:"courseID" meddler:"course_id"`
+	Name      string    `json:"name" meddler:"name"`
+	Enabled   bool      `json:"enabled" meddler:"enabled"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Capabilities describes what the server offers to a client, so a client
+// talking to a server running an older or newer version can adapt.
+// Endpoints lists the API routes in the form "GET /problems/:problem_id".
+// ProblemTypes lists the actions of each problem type. Features lists the
+// feature flags that are on, either everywhere or for the course named in
+// the request. GitPush is set if students can submit with git push.
+type Capabilities struct {
+	Version      string              `json:"version"`
+	Endpoints    []string            `json:"endpoints"`
+	ProblemTypes map[string][]string `json:"problemTypes"`
+	CourseID     int64               `json:"courseID,omitempty"`
+	Features     []string            `json:"features"`
+	GitPush      bool                `json:"gitPush,omitempty"`
+	AssetMirror  string              `json:"assetMirror,omitempty"` // where problem steps can be downloaded instead of from the server
+}
+
+// Supports reports whether the server offers an endpoint.
+// Servers too old to describe themselves have nil capabilities,
+// and are assumed to support everything.
+func (c *Capabilities) Supports(endpoint string) bool {
+	if c == nil {
+		return true
+	}
+	for _, elt := range c.Endpoints {
+		if elt == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFeature reports whether a feature flag is on.
+func (c *Capabilities) HasFeature(name string) bool {
+	if c == nil {
+		return false
+	}
+	for _, elt := range c.Features {
+		if elt == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What a feedback rule looks at.
+const (
+	FeedbackCode   = "code"   // the student's files
+	FeedbackOutput = "output" // the report card and transcript of a commit that did not pass
+)
+
+var feedbackNameRE = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// FeedbackRule recognizes a common misconception in a student's code or in the
+// output of a failing run and explains it. Rules whose pattern matches add their
+// message to the report card. Hits counts how often each rule has matched, so the
+// rules for a problem grow into a record of where its students go wrong.
+type FeedbackRule struct {
+	ProblemID int64     `json:"problemID" meddler:"problem_id"`
+	Name      string    `json:"name" meddler:"name"`
+	Match     string    `json:"match" meddler:"match_on"`
+	Pattern   string    `json:"pattern" meddler:"pattern"`
+	Files     string    `json:"files,omitempty" meddler:"files"` // a glob limiting which files code rules look at
+	Step      int64     `json:"step,omitempty" meddler:"step"`   // zero for every step
+	Message   string    `json:"message" meddler:"message"`
+	Hits      int64     `json:"hits" meddler:"hits"`
+	LastHitAt time.Time `json:"lastHitAt,omitempty" meddler:"last_hit_at,localtimez"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// ReportCardFeedback explains a misconception recognized by one of the
+// problem's feedback rules. Context is file:line for rules that match code.
+type ReportCardFeedback struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	Context string `json:"context,omitempty"`
+}
+
+// Normalize checks a rule, defaulting to matching code.
+func (rule *FeedbackRule) Normalize() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if !feedbackNameRE.MatchString(rule.Name) {
+		return fmt.Errorf("%q is not a valid rule name; use lower case letters, digits, and dashes", rule.Name)
+	}
+	switch rule.Match {
+	case "":
+		rule.Match = FeedbackCode
+	case FeedbackCode, FeedbackOutput:
+	default:
+		return fmt.Errorf("rules match %q or %q, not %q", FeedbackCode, FeedbackOutput, rule.Match)
+	}
+	if rule.Pattern == "" {
+		return fmt.Errorf("the rule must have a pattern")
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("bad pattern: %v", err)
+	}
+	rule.Files = strings.TrimSpace(rule.Files)
+	if rule.Files != "" {
+		if rule.Match != FeedbackCode {
+			return fmt.Errorf("only code rules can be limited to some files")
+		}
+		if _, err := path.Match(rule.Files, ""); err != nil {
+			return fmt.Errorf("bad file pattern: %v", err)
+		}
+	}
+	if rule.Step < 0 {
+		return fmt.Errorf("the step cannot be negative")
+	}
+	rule.Message = strings.TrimSpace(rule.Message)
+	if rule.Message == "" {
+		return fmt.Errorf("the rule must have a message")
+	}
+	return nil
+}
+
+// Check applies the rule to a graded commit, returning where it matched,
+// such as file:line for code rules, and whether it matched at all.
+func (rule *FeedbackRule) Check(commit *Commit) (string, bool) {
+	if rule.Step != 0 && rule.Step != commit.Step {
+		return "", false
+	}
+	re, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return "", false
+	}
+
+	if rule.Match == FeedbackOutput {
+		if commit.ReportCard == nil || commit.ReportCard.Passed {
+			return "", false
+		}
+		texts := []string{commit.ReportCard.Note}
+		for _, result := range commit.ReportCard.Results {
+			if result.Outcome == "failed" || result.Outcome == "error" {
+				texts = append(texts, result.Details)
+			}
+		}
+		for _, event := range commit.Transcript {
+			if event.Event == "stdout" || event.Event == "stderr" {
+				texts = append(texts, event.StreamData)
+			}
+		}
+		return "", re.MatchString(strings.Join(texts, "\n"))
+	}
+
+	var names []string
+	for name := range commit.Files {
+		if rule.Files == "" {
+			names = append(names, name)
+		} else if ok, _ := path.Match(rule.Files, name); ok {
+			names = append(names, name)
+		} else if ok, _ := path.Match(rule.Files, path.Base(name)); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		contents := commit.Files[name]
+		if loc := re.FindStringIndex(contents); loc != nil {
+			line := strings.Count(contents[:loc[0]], "\n") + 1
+			return fmt.Sprintf("%s:%d", name, line), true
+		}
+	}
+	return "", false
+}
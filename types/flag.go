@@ -0,0 +1,26 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// ComputeFlag returns the flag a student must retrieve in a capture-the-flag
+// problem. Each student gets a different flag, derived from the daycare secret
+// and their assignment, so flags are never stored and cannot be shared.
+func ComputeFlag(secret string, problemUnique string, assignmentID int64) string {
+	mac := hmac.New(sha256.New, []byte("flag:"+secret))
+	mac.Write([]byte(problemUnique + "/" + strconv.FormatInt(assignmentID, 10)))
+	return "flag{" + hex.EncodeToString(mac.Sum(nil))[:32] + "}"
+}
+
+// FlagMatches reports whether a submitted flag is the expected one, ignoring
+// surrounding whitespace. The two are compared by hash in constant time.
+func FlagMatches(expected, submitted string) bool {
+	want := sha256.Sum256([]byte(strings.TrimSpace(expected)))
+	got := sha256.Sum256([]byte(strings.TrimSpace(submitted)))
+	return hmac.Equal(want[:], got[:])
+}
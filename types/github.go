@@ -0,0 +1,64 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GitHubClassroom links a problem set in a course to a GitHub Classroom assignment.
+// Logins maps each student's GitHub login to the email address CodeGrinder knows
+// them by, as found in the roster exported from GitHub Classroom.
+type GitHubClassroom struct {
+	CourseID              int64             `json:"courseID" meddler:"course_id"`
+	ProblemSetID          int64             `json:"problemSetID" meddler:"problem_set_id"`
+	ClassroomAssignmentID int64             `json:"classroomAssignmentID" meddler:"classroom_assignment_id"`
+	Logins                map[string]string `json:"logins" meddler:"logins,json"`
+	ImportedAt            time.Time         `json:"importedAt,omitempty" meddler:"imported_at,localtimez"`
+	CreatedAt             time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt             time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks the link for sane values.
+// GitHub logins are not case sensitive, so they are stored in lower case.
+func (classroom *GitHubClassroom) Normalize() error {
+	if classroom.ClassroomAssignmentID < 1 {
+		return fmt.Errorf("a GitHub Classroom assignment ID is required")
+	}
+	logins := make(map[string]string)
+	for login, email := range classroom.Logins {
+		login = strings.ToLower(strings.TrimSpace(login))
+		email = strings.TrimSpace(email)
+		if login == "" || email == "" {
+			continue
+		}
+		logins[login] = email
+	}
+	classroom.Logins = logins
+	return nil
+}
+
+// GitHubSubmission records the repository and commit a submission was imported from,
+// so the result can be posted back as a commit status.
+type GitHubSubmission struct {
+	SubmissionID int64     `json:"submissionID" meddler:"submission_id"`
+	Repository   string    `json:"repository" meddler:"repository"`
+	SHA          string    `json:"sha" meddler:"sha"`
+	StatusPosted bool      `json:"statusPosted" meddler:"status_posted"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// GitHubImport reports what happened to each repository during an import.
+type GitHubImport struct {
+	Repositories []*GitHubImportRepository `json:"repositories"`
+}
+
+// GitHubImportRepository is the outcome of importing one student repository.
+// Repositories whose latest commit was already imported are left alone.
+type GitHubImportRepository struct {
+	Login         string  `json:"login"`
+	Repository    string  `json:"repository"`
+	SHA           string  `json:"sha,omitempty"`
+	SubmissionIDs []int64 `json:"submissionIDs,omitempty"`
+	Note          string  `json:"note"`
+}
@@ -0,0 +1,38 @@
+package types
+
+import "time"
+
+// HealthReport is the result of a readiness probe. Each dependency that the
+// server relies on is checked and reported separately.
+type HealthReport struct {
+	Healthy bool           `json:"healthy"`
+	Host    string         `json:"host"`
+	Roles   []string       `json:"roles"`
+	Time    time.Time      `json:"time"`
+	Checks  []*HealthCheck `json:"checks"`
+}
+
+// Summary returns a copy of the report with only the name and status of each check,
+// leaving out details such as hosts, paths, and expiry dates.
+func (report *HealthReport) Summary() *HealthReport {
+	summary := &HealthReport{Healthy: report.Healthy, Time: report.Time, Checks: []*HealthCheck{}}
+	for _, check := range report.Checks {
+		summary.Checks = append(summary.Checks, &HealthCheck{Name: check.Name, Healthy: check.Healthy})
+	}
+	return summary
+}
+
+// HealthCheck is the result of probing a single dependency.
+type HealthCheck struct {
+	Name     string        `json:"name"`
+	Healthy  bool          `json:"healthy"`
+	Message  string        `json:"message,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ConfigReload reports the result of reloading the server config file.
+// Changed settings that can only take effect when the server restarts are listed separately.
+type ConfigReload struct {
+	Reloaded    []string `json:"reloaded"`
+	NeedRestart []string `json:"needRestart"`
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// DaycareJob is a grading job running on the daycare. The daycare fills in what it
+// knows from the commit bundle; the TA adds the course and student when it lists jobs.
+type DaycareJob struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"userID"`
+	AssignmentID int64     `json:"assignmentID"`
+	ProblemID    int64     `json:"problemID"`
+	Unique       string    `json:"unique"`
+	ProblemType  string    `json:"problemType"`
+	Action       string    `json:"action"`
+	Tenant       string    `json:"tenant,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	CourseID     int64     `json:"courseID,omitempty"`
+	Email        string    `json:"email,omitempty"`
+}
+
+// CourseSuspension stops a student from having work graded in a course
+// until a given time, such as when their program keeps running away.
+type CourseSuspension struct {
+	CourseID    int64     `json:"courseID" meddler:"course_id"`
+	UserID      int64     `json:"userID" meddler:"user_id"`
+	Until       time.Time `json:"until" meddler:"until,localtime"`
+	Reason      string    `json:"reason,omitempty" meddler:"reason,zeroisnull"`
+	SuspendedBy int64     `json:"suspendedBy" meddler:"suspended_by"`
+	CreatedAt   time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt   time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Message explains the suspension to the student.
+func (suspension *CourseSuspension) Message() string {
+	msg := fmt.Sprintf("grading is suspended for you in this course until %s", suspension.Until.Format("Jan 2 at 3:04pm MST"))
+	if suspension.Reason != "" {
+		msg += ": " + suspension.Reason
+	}
+	return msg
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// What redeeming a lab code does for a student.
+const (
+	LabUnlock = "unlock" // the problem set stays locked until the student redeems a code
+	LabCredit = "credit" // redeeming a code checks the student off for the problem set
+)
+
+// MaxLabSession is the longest a lab code can be redeemed for.
+const MaxLabSession = 12 * time.Hour
+
+// LabSession is a short-lived code an instructor shows in the lab. Students
+// redeem it to prove they were there, so in-person activities cannot be
+// completed remotely.
+type LabSession struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"problem_set_id"`
+	Code         string    `json:"code" meddler:"code"`
+	Purpose      string    `json:"purpose" meddler:"purpose"`
+	CreatedBy    int64     `json:"createdBy,omitempty" meddler:"created_by,zeroisnull"`
+	Redeemed     int       `json:"redeemed" meddler:"-"`
+	ExpiresAt    time.Time `json:"expiresAt" meddler:"expires_at,localtime"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// Normalize checks a new lab session, defaulting to one that unlocks the problem set.
+func (session *LabSession) Normalize(now time.Time) error {
+	switch session.Purpose {
+	case "":
+		session.Purpose = LabUnlock
+	case LabUnlock, LabCredit:
+	default:
+		return fmt.Errorf("a lab code must be for %s or %s, not %q", LabUnlock, LabCredit, session.Purpose)
+	}
+	if !session.ExpiresAt.After(now) {
+		return fmt.Errorf("a lab code must expire in the future")
+	}
+	if session.ExpiresAt.Sub(now) > MaxLabSession {
+		return fmt.Errorf("a lab code can last at most %v", MaxLabSession)
+	}
+	return nil
+}
+
+// IsExpired reports whether the code can no longer be redeemed.
+func (session *LabSession) IsExpired(now time.Time) bool {
+	return !now.Before(session.ExpiresAt)
+}
+
+// LabCode is a code typed in by a student.
+type LabCode struct {
+	Code string `json:"code"`
+}
+
+// LabRedemption records a student redeeming a lab code.
+type LabRedemption struct {
+	LabSessionID int64     `json:"labSessionID" meddler:"lab_session_id"`
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"-"`
+	Purpose      string    `json:"purpose" meddler:"-"`
+	RedeemedAt   time.Time `json:"redeemedAt" meddler:"redeemed_at,localtime"`
+}
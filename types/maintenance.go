@@ -0,0 +1,80 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceHeader is the response header announcing scheduled maintenance,
+// in the form "<start>/<end> <message>" with the times in RFC 3339 format.
+const MaintenanceHeader = "X-Maintenance"
+
+// MaintenanceNotice announces a window when the server will be down or unreliable.
+// It is shown to users from the time it is set until the window ends.
+type MaintenanceNotice struct {
+	ID        int64     `json:"id" meddler:"id,pk"`
+	Message   string    `json:"message" meddler:"message"`
+	StartsAt  time.Time `json:"startsAt" meddler:"starts_at,localtime"`
+	EndsAt    time.Time `json:"endsAt" meddler:"ends_at,localtime"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+func (elt *MaintenanceNotice) Normalize(now time.Time) error {
+	elt.Message = strings.Join(strings.Fields(elt.Message), " ")
+	if elt.Message == "" {
+		return fmt.Errorf("maintenance notice must have a message")
+	}
+	if elt.StartsAt.IsZero() || elt.EndsAt.IsZero() {
+		return fmt.Errorf("maintenance notice must have a start and end time")
+	}
+	if !elt.EndsAt.After(elt.StartsAt) {
+		return fmt.Errorf("maintenance must end after it starts")
+	}
+	if !elt.EndsAt.After(now) {
+		return fmt.Errorf("maintenance window has already ended")
+	}
+	return nil
+}
+
+// InProgress reports whether the maintenance window is open.
+func (elt *MaintenanceNotice) InProgress(now time.Time) bool {
+	return !now.Before(elt.StartsAt) && now.Before(elt.EndsAt)
+}
+
+// Banner describes the notice for users, with times in the local time zone.
+func (elt *MaintenanceNotice) Banner(now time.Time) string {
+	start, end := elt.StartsAt.Local(), elt.EndsAt.Local()
+	endFormat := "Mon Jan 2 15:04 MST"
+	if start.YearDay() == end.YearDay() && start.Year() == end.Year() {
+		endFormat = "15:04 MST"
+	}
+	if elt.InProgress(now) {
+		return fmt.Sprintf("maintenance in progress until %s: %s", end.Format(endFormat), elt.Message)
+	}
+	return fmt.Sprintf("maintenance scheduled %s to %s: %s", start.Format("Mon Jan 2 15:04"), end.Format(endFormat), elt.Message)
+}
+
+// Header renders the notice for MaintenanceHeader.
+func (elt *MaintenanceNotice) Header() string {
+	return fmt.Sprintf("%s/%s %s", elt.StartsAt.UTC().Format(time.RFC3339), elt.EndsAt.UTC().Format(time.RFC3339), elt.Message)
+}
+
+// ParseMaintenanceHeader reads a notice from MaintenanceHeader.
+func ParseMaintenanceHeader(header string) (*MaintenanceNotice, error) {
+	parts := strings.SplitN(strings.TrimSpace(header), " ", 2)
+	window := strings.SplitN(parts[0], "/", 2)
+	if len(parts) != 2 || len(window) != 2 {
+		return nil, fmt.Errorf("maintenance header must have a start/end window and a message")
+	}
+	start, err := time.Parse(time.RFC3339, window[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad maintenance start time: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339, window[1])
+	if err != nil {
+		return nil, fmt.Errorf("bad maintenance end time: %v", err)
+	}
+	return &MaintenanceNotice{Message: parts[1], StartsAt: start, EndsAt: end}, nil
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MaxToolchainVariants is the most toolchain versions a problem can be graded against at once.
+const MaxToolchainVariants = 6
+
+// ToolchainVariant is one entry in a problem's grading matrix: a container image
+// with a different version of the interpreter or compiler. When a problem has
+// variants, every submission is graded against all of them in parallel instead of
+// against the problem type's usual image, and must pass on each one.
+type ToolchainVariant struct {
+	ProblemID int64     `json:"problemID" meddler:"problem_id"`
+	Label     string    `json:"label" meddler:"label"`
+	Image     string    `json:"image" meddler:"image"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+var variantLabelRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Normalize checks the variant for sane values.
+func (variant *ToolchainVariant) Normalize() error {
+	variant.Label = strings.TrimSpace(variant.Label)
+	variant.Image = strings.TrimSpace(variant.Image)
+	if !variantLabelRE.MatchString(variant.Label) {
+		return fmt.Errorf("%q is not a valid label; use letters, digits, dots, dashes, and underscores", variant.Label)
+	}
+	if variant.Image == "" || strings.ContainsAny(variant.Image, " \t\n") {
+		return fmt.Errorf("%q is not a valid image name", variant.Image)
+	}
+	return nil
+}
+
+// ReportCardVariant summarizes the grading run against one toolchain variant.
+type ReportCardVariant struct {
+	Label     string        `json:"label"`
+	Image     string        `json:"image"`
+	Passed    bool          `json:"passed"`
+	Note      string        `json:"note,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Toolchain *Toolchain    `json:"toolchain,omitempty"`
+}
+
+// AddVariant merges the report card from the run against one toolchain variant
+// into the overall report card, tagging its results and stages with the label.
+// The overall card passes only if every variant passes.
+func (elt *ReportCard) AddVariant(variant *ToolchainVariant, card *ReportCard) {
+	elt.Variants = append(elt.Variants, &ReportCardVariant{
+		Label:     variant.Label,
+		Image:     variant.Image,
+		Passed:    card.Passed,
+		Note:      card.Note,
+		Duration:  card.Duration,
+		Toolchain: card.Toolchain,
+	})
+	for _, result := range card.Results {
+		result.Variant = variant.Label
+		elt.Results = append(elt.Results, result)
+	}
+	for _, stage := range card.Stages {
+		stage.Name = variant.Label + ": " + stage.Name
+		elt.Stages = append(elt.Stages, stage)
+	}
+	for _, artifact := range card.Artifacts {
+		artifact.Variant = variant.Label
+		elt.Artifacts = append(elt.Artifacts, artifact)
+	}
+
+	// the variants run side by side, so the slowest one decides how long grading took
+	if card.Duration > elt.Duration {
+		elt.Duration = card.Duration
+	}
+	if card.Resources != nil {
+		if elt.Resources == nil {
+			elt.Resources = new(ResourceUsage)
+		}
+		elt.Resources.Add(card.Resources)
+	}
+	if card.Passed {
+		return
+	}
+	note := card.Note
+	if note == "" {
+		note = "failed"
+	}
+	elt.Failf("%s: %s", variant.Label, note)
+}
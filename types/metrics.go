@@ -0,0 +1,22 @@
+package types
+
+// CodeMetrics measures the size and complexity of a submission.
+type CodeMetrics struct {
+	Files         int                `json:"files"`
+	Lines         int                `json:"lines"`
+	CodeLines     int                `json:"codeLines"` // lines with code, not counting blank lines and comments
+	MaxLength     int                `json:"maxLength"`
+	MaxComplexity int                `json:"maxComplexity"`
+	Functions     []*FunctionMetrics `json:"functions,omitempty"`
+}
+
+// FunctionMetrics measures one function. Length counts every line from the
+// declaration to the end of the body, and Complexity is the cyclomatic
+// complexity: one more than the number of decision points.
+type FunctionMetrics struct {
+	Name       string `json:"name"`
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Length     int    `json:"length"`
+	Complexity int    `json:"complexity"`
+}
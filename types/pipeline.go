@@ -0,0 +1,76 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// What a grading pipeline does when a stage fails.
+const (
+	StageStop     = "stop"     // the grade fails and later stages are skipped
+	StageContinue = "continue" // the grade fails but later stages still run
+	StageWarn     = "warn"     // the failure is reported but does not count against the grade
+)
+
+// ReportCardStage summarizes one stage of a grading pipeline.
+// Outcome is passed, failed, warning, or skipped.
+type ReportCardStage struct {
+	Name     string        `json:"name"`
+	Outcome  string        `json:"outcome"`
+	Note     string        `json:"note,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AddStage merges the report card from one stage of a pipeline into the overall
+// report card, tagging its results with the stage name and applying the stage's
+// failure policy. It returns true if later stages should be skipped.
+func (elt *ReportCard) AddStage(name, onFailure string, stage *ReportCard, duration time.Duration) bool {
+	summary := &ReportCardStage{Name: name, Outcome: "passed", Note: stage.Note, Duration: duration}
+	elt.Stages = append(elt.Stages, summary)
+	for _, result := range stage.Results {
+		result.Stage = name
+		if onFailure == StageWarn && result.Outcome != "passed" {
+			result.Outcome = "warning"
+		}
+		elt.Results = append(elt.Results, result)
+	}
+	for _, artifact := range stage.Artifacts {
+		artifact.Stage = name
+		elt.Artifacts = append(elt.Artifacts, artifact)
+	}
+	if stage.Metrics != nil {
+		elt.Metrics = stage.Metrics
+	}
+	if stage.Passed {
+		return false
+	}
+
+	if onFailure == StageWarn {
+		summary.Outcome = "warning"
+		return false
+	}
+	summary.Outcome = "failed"
+	note := stage.Note
+	if note == "" {
+		note = "failed"
+	}
+	elt.Failf("%s: %s", name, note)
+	return onFailure == StageStop
+}
+
+// SkipStage records a pipeline stage that did not run because an earlier stage failed.
+func (elt *ReportCard) SkipStage(name string) {
+	elt.Stages = append(elt.Stages, &ReportCardStage{Name: name, Outcome: "skipped", Note: "an earlier stage failed"})
+}
+
+// ValidStagePolicy checks a pipeline stage failure policy, defaulting to stop.
+func ValidStagePolicy(onFailure string) (string, error) {
+	switch onFailure {
+	case "":
+		return StageStop, nil
+	case StageStop, StageContinue, StageWarn:
+		return onFailure, nil
+	default:
+		return "", fmt.Errorf("a stage must %s, %s, or %s on failure, not %q", StageStop, StageContinue, StageWarn, onFailure)
+	}
+}
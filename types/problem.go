@@ -22,27 +22,244 @@ import (
 var BeginningOfTime = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
 
 // ProblemType defines one type of problem.
+// A problem type with a Platform, such as windows or macos, is graded by a native
+// runner agent on that platform instead of in a container, and its Image only names
+// the toolchain the agent is expected to have installed. Requires lists capabilities,
+// such as fpga or gpu, that the runner agent must advertise to be given the work.
+// Cache lists directories in the image, such as the Go build cache or a ccache
+// directory, that are shared by every grading run of the problem type with the
+// same image. The image should create them, owned by the user that grades.
+// Dependencies says how to install the third-party packages a problem lists.
 type ProblemType struct {
-	Name        string                        `json:"name"`
-	Image       string                        `json:"image"`
-	MaxCPU      int                           `json:"maxCPU"`
-	MaxClock    int                           `json:"maxClock"`
-	MaxFD       int                           `json:"maxFD"`
-	MaxFileSize int                           `json:"maxFileSize"`
-	MaxMemory   int                           `json:"maxMemory"`
-	MaxThreads  int                           `json:"maxThreads"`
-	Actions     map[string]*ProblemTypeAction `json:"actions"`
-	Files       map[string]string             `json:"files,omitempty"`
+	Name         string                        `json:"name"`
+	Image        string                        `json:"image"`
+	Platform     string                        `json:"platform,omitempty"`
+	Requires     []string                      `json:"requires,omitempty"`
+	MaxCPU       int                           `json:"maxCPU"`
+	MaxClock     int                           `json:"maxClock"`
+	MaxFD        int                           `json:"maxFD"`
+	MaxFileSize  int                           `json:"maxFileSize"`
+	MaxMemory    int                           `json:"maxMemory"`
+	MaxThreads   int                           `json:"maxThreads"`
+	Actions      map[string]*ProblemTypeAction `json:"actions"`
+	Files        map[string]string             `json:"files,omitempty"`
+	Cache        []string                      `json:"cache,omitempty"`
+	Dependencies *ProblemTypeDependencies      `json:"dependencies,omitempty"`
+	SwitchUser   bool                          `json:"switchUser,omitempty"`
+}
+
+// Toolchain identifies the container image a commit was graded with.
+// Version is taken from the "version" label of the image, if present.
+type Toolchain struct {
+	Image   string `json:"image"`
+	ImageID string `json:"imageID"`
+	Version string `json:"version,omitempty"`
+}
+
+// ToolchainImage records a toolchain image built for a problem type and pushed
+// to the image registry. The newest image for each problem type is the one every
+// daycare is expected to run. Reference is the registry name daycares pull.
+type ToolchainImage struct {
+	ID          int64     `json:"id" meddler:"id,pk"`
+	ProblemType string    `json:"problemType" meddler:"problem_type"`
+	Image       string    `json:"image" meddler:"image"`
+	ImageID     string    `json:"imageID" meddler:"image_id"`
+	Reference   string    `json:"reference" meddler:"reference"`
+	ScanNote    string    `json:"scanNote,omitempty" meddler:"scan_note,zeroisnull"`
+	CreatedAt   time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// ProblemTypeDependencies describes the files, such as a lockfile, in which a
+// problem lists the third-party packages it needs, and the command that installs
+// them. Packages are installed ahead of time in a snapshot image built on the
+// problem type's image, so grading never needs the network.
+type ProblemTypeDependencies struct {
+	Files   []string `json:"files"`
+	Install []string `json:"install"`
+}
+
+// Key identifies the snapshot that installs the dependencies in a problem's files
+// on top of the given base image. It is empty if the files list no dependencies.
+func (deps *ProblemTypeDependencies) Key(baseImageID string, files map[string]string) string {
+	if deps == nil {
+		return ""
+	}
+	found := false
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\x00%q\x00", baseImageID, deps.Install)
+	for _, name := range deps.Files {
+		contents, exists := files[name]
+		found = found || exists
+		fmt.Fprintf(sum, "%q\x00%d\x00%s", name, len(contents), contents)
+	}
+	if !found {
+		return ""
+	}
+	return fmt.Sprintf("%x", sum.Sum(nil))[:20]
+}
+
+// DependencyImage records a snapshot image with a problem's dependencies installed,
+// built on BaseImageID and pushed to the image registry. Daycares pull Reference
+// and install it under a name derived from the key.
+type DependencyImage struct {
+	ID          int64     `json:"id" meddler:"id,pk"`
+	Key         string    `json:"key" meddler:"key"`
+	ProblemType string    `json:"problemType" meddler:"problem_type"`
+	BaseImageID string    `json:"baseImageID" meddler:"base_image_id"`
+	ImageID     string    `json:"imageID" meddler:"image_id"`
+	Reference   string    `json:"reference" meddler:"reference"`
+	CreatedAt   time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// ProblemTypeOverride holds a course's changes to the defaults of one problem type,
+// such as a different toolchain image, different resource limits, or extra options
+// (compiler flags, style-check strictness) passed to the action handlers.
+// Zero values leave the problem type default in place.
+type ProblemTypeOverride struct {
+	CourseID    int64     `json:"courseID" meddler:"course_id"`
+	ProblemType string    `json:"problemType" meddler:"problem_type"`
+	Image       string    `json:"image,omitempty" meddler:"image"`
+	MaxCPU      int       `json:"maxCPU,omitempty" meddler:"max_cpu"`
+	MaxClock    int       `json:"maxClock,omitempty" meddler:"max_clock"`
+	MaxFD       int       `json:"maxFD,omitempty" meddler:"max_fd"`
+	MaxFileSize int       `json:"maxFileSize,omitempty" meddler:"max_file_size"`
+	MaxMemory   int       `json:"maxMemory,omitempty" meddler:"max_memory"`
+	MaxThreads  int       `json:"maxThreads,omitempty" meddler:"max_threads"`
+	Options     []string  `json:"options,omitempty" meddler:"options,json"`
+	CreatedAt   time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt   time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Apply returns a copy of the problem type with the course overrides in place.
+// The actions are shared with the original.
+func (override *ProblemTypeOverride) Apply(problemType *ProblemType) *ProblemType {
+	elt := *problemType
+	if override == nil {
+		return &elt
+	}
+	if override.Image != "" {
+		elt.Image = override.Image
+	}
+	if override.MaxCPU > 0 {
+		elt.MaxCPU = override.MaxCPU
+	}
+	if override.MaxClock > 0 {
+		elt.MaxClock = override.MaxClock
+	}
+	if override.MaxFD > 0 {
+		elt.MaxFD = override.MaxFD
+	}
+	if override.MaxFileSize > 0 {
+		elt.MaxFileSize = override.MaxFileSize
+	}
+	if override.MaxMemory > 0 {
+		elt.MaxMemory = override.MaxMemory
+	}
+	if override.MaxThreads > 0 {
+		elt.MaxThreads = override.MaxThreads
+	}
+	return &elt
+}
+
+// ComputeSignature signs the override, chaining it to the signature of the problem
+// it applies to. Commits graded under an override are signed against this signature
+// in place of the problem signature so the override cannot be dropped or altered.
+func (override *ProblemTypeOverride) ComputeSignature(secret string, problemSignature string) string {
+	v := make(url.Values)
+
+	// gather all relevant fields
+	v.Add("course_id", strconv.FormatInt(override.CourseID, 10))
+	v.Add("problem_type", override.ProblemType)
+	v.Add("image", override.Image)
+	v.Add("max_cpu", strconv.Itoa(override.MaxCPU))
+	v.Add("max_clock", strconv.Itoa(override.MaxClock))
+	v.Add("max_fd", strconv.Itoa(override.MaxFD))
+	v.Add("max_file_size", strconv.Itoa(override.MaxFileSize))
+	v.Add("max_memory", strconv.Itoa(override.MaxMemory))
+	v.Add("max_threads", strconv.Itoa(override.MaxThreads))
+	v["options"] = override.Options
+	v.Add("problem_signature", problemSignature)
+
+	// compute signature
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encode(v)))
+	sum := mac.Sum(nil)
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// OverridePolicy is what a server lets courses change in a problem type.
+// Images lists the images a course can use in place of a problem type's own.
+// Each limit is the most a course can raise that limit to; where it is zero,
+// a course can lower the problem type's own limit but not raise it.
+type OverridePolicy struct {
+	Images      []string
+	MaxCPU      int
+	MaxClock    int
+	MaxFD       int
+	MaxFileSize int
+	MaxMemory   int
+	MaxThreads  int
+}
+
+// Normalize checks the override for sane values. The image must be the problem
+// type's own or one the policy allows, and each limit is lowered to the most
+// the policy allows.
+func (override *ProblemTypeOverride) Normalize(problemType *ProblemType, policy *OverridePolicy) error {
+	override.Image = strings.TrimSpace(override.Image)
+	if override.Image == problemType.Image {
+		override.Image = ""
+	}
+	if override.Image != "" {
+		allowed := false
+		for _, image := range policy.Images {
+			allowed = allowed || image == override.Image
+		}
+		if !allowed {
+			return fmt.Errorf("image %s is not one that courses are allowed to use", override.Image)
+		}
+	}
+	if override.MaxCPU < 0 || override.MaxClock < 0 || override.MaxFD < 0 ||
+		override.MaxFileSize < 0 || override.MaxMemory < 0 || override.MaxThreads < 0 {
+		return fmt.Errorf("resource limits cannot be negative")
+	}
+	clamp := func(limit *int, own, most int) {
+		if most == 0 {
+			most = own
+		}
+		if *limit > most {
+			*limit = most
+		}
+	}
+	clamp(&override.MaxCPU, problemType.MaxCPU, policy.MaxCPU)
+	clamp(&override.MaxClock, problemType.MaxClock, policy.MaxClock)
+	clamp(&override.MaxFD, problemType.MaxFD, policy.MaxFD)
+	clamp(&override.MaxFileSize, problemType.MaxFileSize, policy.MaxFileSize)
+	clamp(&override.MaxMemory, problemType.MaxMemory, policy.MaxMemory)
+	clamp(&override.MaxThreads, problemType.MaxThreads, policy.MaxThreads)
+	options := []string{}
+	for _, option := range override.Options {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	override.Options = options
+	return nil
 }
 
 // ProblemTypeAction defines the label, button, UI classes, and handler for a
-// single problem type action.
+// single problem type action. An action with Reference is also given the
+// reference solution to the step, to compare the student's program against.
+// An action with Flag is given the student's flag for a capture-the-flag problem.
+// An action with Secrets is given the problem's secret variables.
 type ProblemTypeAction struct {
-	Action  string `json:"action,omitempty"`
-	Button  string `json:"button,omitempty"`
-	Message string `json:"message,omitempty"`
-	Class   string `json:"className,omitempty"`
-	Handler interface{}
+	Action    string      `json:"action,omitempty"`
+	Button    string      `json:"button,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Class     string      `json:"className,omitempty"`
+	Reference bool        `json:"reference,omitempty"`
+	Flag      bool        `json:"flag,omitempty"`
+	Secrets   bool        `json:"secrets,omitempty"`
+	Handler   interface{} `json:"-"`
 }
 
 type Problem struct {
@@ -52,14 +269,22 @@ type Problem struct {
 	ProblemType string    `json:"problemType" meddler:"problem_type"`
 	Tags        []string  `json:"tags" meddler:"tags,json"`
 	Options     []string  `json:"options" meddler:"options,json"`
+	Mastery     int64     `json:"mastery,omitempty" meddler:"mastery"` // consecutive passes needed for credit
 	CreatedAt   time.Time `json:"createdAt" meddler:"created_at,localtime"`
 	UpdatedAt   time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
+// IsMastery returns true if credit for each step requires passing several times in a row.
+func (problem *Problem) IsMastery() bool {
+	return problem.Mastery > 1
+}
+
 // ProblemStep represents a single step of a problem.
 // Anything in the root directory of Files is added to the working directory,
 // possibly overwriting existing content. The subdirectory contents of Files
 // replace all subdirectory contents in the problem from earlier steps.
+// Files named in Carry are the exception: the student's own copy from the
+// previous step is kept in place of the starter file.
 type ProblemStep struct {
 	ProblemID    int64             `json:"problemID" meddler:"problem_id"`
 	Step         int64             `json:"step" meddler:"step"` // note: one-based
@@ -67,21 +292,159 @@ type ProblemStep struct {
 	Instructions string            `json:"instructions" meddler:"instructions"`
 	Weight       float64           `json:"weight" meddler:"weight"`
 	Files        map[string]string `json:"files" meddler:"files,json"`
+	Carry        []string          `json:"carry,omitempty" meddler:"carry,json"`
+}
+
+// Carries returns true if the student's copy of a file is kept when moving to this step.
+func (step *ProblemStep) Carries(name string) bool {
+	for _, elt := range step.Carry {
+		if elt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Layout of a problem step downloaded as a gzip tarball from .../steps/:step/bundle.
+const (
+	StepBundleMetadata = "step.json" // the step without its files
+	StepBundleFilesDir = "files/"    // prefix of each step file
+)
+
+// StepMirror is a signed URL where a problem step can be downloaded
+// from the asset mirror, in the same form as .../steps/:step/bundle.
+type StepMirror struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ProblemSolution is the reference solution for one step of a problem.
+// Solutions are kept so they can be re-run when a toolchain changes.
+type ProblemSolution struct {
+	ProblemID int64             `json:"problemID" meddler:"problem_id"`
+	Step      int64             `json:"step" meddler:"step"`
+	Files     map[string]string `json:"files" meddler:"files,json"`
+}
+
+// SolutionRelease is the policy for showing students the reference solutions to
+// the problems in a problem set offered in a course. Solutions are released to
+// everyone once ReleaseAt has passed, and with OnPass, to each student as soon as
+// they pass every step of a problem. Files limits the release to the named files;
+// with no files, every file of the reference solution is released.
+type SolutionRelease struct {
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"problem_set_id"`
+	ReleaseAt    time.Time `json:"releaseAt,omitempty" meddler:"release_at,localtimez"`
+	OnPass       bool      `json:"onPass" meddler:"on_pass"`
+	Files        []string  `json:"files" meddler:"files,json"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Includes returns true if the named solution file is released.
+func (release *SolutionRelease) Includes(name string) bool {
+	if len(release.Files) == 0 {
+		return true
+	}
+	for _, elt := range release.Files {
+		if elt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ProblemValidation records the result of running the reference solutions
+// of a problem against one version of its toolchain.
+type ProblemValidation struct {
+	ProblemID   int64     `json:"problemID" meddler:"problem_id"`
+	Image       string    `json:"image" meddler:"image"`
+	ImageID     string    `json:"imageID" meddler:"image_id"`
+	Version     string    `json:"version" meddler:"version"`
+	Passed      bool      `json:"passed" meddler:"passed"`
+	Note        string    `json:"note" meddler:"note"`
+	ValidatedAt time.Time `json:"validatedAt" meddler:"validated_at,localtime"`
+}
+
+// ProblemCompatibility summarizes the most recent toolchain validation of a problem.
+type ProblemCompatibility struct {
+	ProblemID   int64     `json:"problemID" meddler:"problem_id"`
+	Unique      string    `json:"unique" meddler:"unique_id"`
+	ProblemType string    `json:"problemType" meddler:"problem_type"`
+	Image       string    `json:"image" meddler:"image"`
+	ImageID     string    `json:"imageID" meddler:"image_id"`
+	Version     string    `json:"version" meddler:"version"`
+	Passed      bool      `json:"passed" meddler:"passed"`
+	Note        string    `json:"note" meddler:"note"`
+	ValidatedAt time.Time `json:"validatedAt" meddler:"validated_at,localtime"`
+}
+
+// CanaryResult records the latest nightly regrade of the reference solutions
+// of a problem on one daycare host. FailingSince is set while they fail.
+type CanaryResult struct {
+	ProblemID    int64     `json:"problemID" meddler:"problem_id"`
+	Unique       string    `json:"unique" meddler:"-"`
+	Host         string    `json:"host" meddler:"host"`
+	Image        string    `json:"image" meddler:"image"`
+	ImageID      string    `json:"imageID" meddler:"image_id"`
+	Version      string    `json:"version" meddler:"version"`
+	Passed       bool      `json:"passed" meddler:"passed"`
+	Note         string    `json:"note" meddler:"note"`
+	FailingSince time.Time `json:"failingSince,omitempty" meddler:"failing_since,localtimez"`
+	RanAt        time.Time `json:"ranAt" meddler:"ran_at,localtime"`
 }
 
 type ProblemSet struct {
-	ID        int64     `json:"id" meddler:"id,pk"`
-	Unique    string    `json:"unique" meddler:"unique_id"`
-	Note      string    `json:"note" meddler:"note"`
-	Tags      []string  `json:"tags" meddler:"tags,json"`
-	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+	ID                  int64     `json:"id" meddler:"id,pk"`
+	Unique              string    `json:"unique" meddler:"unique_id"`
+	Note                string    `json:"note" meddler:"note"`
+	Tags                []string  `json:"tags" meddler:"tags,json"`
+	DiagnosticProblemID int64     `json:"diagnosticProblemID,omitempty" meddler:"diagnostic_problem_id,zeroisnull"`
+	DiagnosticThreshold float64   `json:"diagnosticThreshold,omitempty" meddler:"diagnostic_threshold"`
+	CreatedAt           time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt           time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// IsAdaptive returns true if students are sent down a remedial or advanced path
+// depending on how they do on a diagnostic problem.
+func (set *ProblemSet) IsAdaptive() bool {
+	return set.DiagnosticProblemID > 0
 }
 
+// Tracks of an adaptive problem set. Problems with no track are given to every student.
+const (
+	TrackRemedial = "remedial"
+	TrackAdvanced = "advanced"
+)
+
 type ProblemSetProblem struct {
 	ProblemSetID int64   `json:"problemSetID" meddler:"problem_set_id"`
 	ProblemID    int64   `json:"problemID" meddler:"problem_id"`
 	Weight       float64 `json:"weight" meddler:"weight"`
+	Track        string  `json:"track,omitempty" meddler:"track,zeroisnull"`
+}
+
+// ProblemSetPath configures an adaptive problem set. Students scoring at least
+// Threshold (on a scale of 0 to 1) on the diagnostic problem get the advanced
+// problems, and everyone else gets the remedial problems.
+type ProblemSetPath struct {
+	DiagnosticProblemID int64   `json:"diagnosticProblemID"`
+	Threshold           float64 `json:"threshold"`
+	Remedial            []int64 `json:"remedial"`
+	Advanced            []int64 `json:"advanced"`
+}
+
+// ProblemSetImport lets a problem in a problem set use files the student wrote
+// for an earlier problem in the same set, such as a library built in problem 1
+// and used by problems 2 through 4. The files are taken from the student's
+// passing commit for the last step of the earlier problem.
+type ProblemSetImport struct {
+	ProblemSetID  int64     `json:"problemSetID" meddler:"problem_set_id"`
+	ProblemID     int64     `json:"problemID" meddler:"problem_id"`
+	FromProblemID int64     `json:"fromProblemID" meddler:"from_problem_id"`
+	Files         []string  `json:"files" meddler:"files,json"`
+	CreatedAt     time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt     time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
 func (problem *Problem) Normalize(now time.Time, steps []*ProblemStep) error {
@@ -125,6 +488,25 @@ func (problem *Problem) Normalize(now time.Time, steps []*ProblemStep) error {
 		step.Normalize(int64(n) + 1)
 	}
 
+	// carried files must be student files from an earlier step
+	whitelists := problem.GetStepWhitelists(steps)
+	for n, step := range steps {
+		if len(step.Carry) == 0 {
+			continue
+		}
+		if n == 0 {
+			return fmt.Errorf("step 1 cannot carry files forward from an earlier step")
+		}
+		for i, name := range step.Carry {
+			name = strings.TrimSpace(name)
+			if !whitelists[n-1][name] {
+				return fmt.Errorf("step %d carries %q, but it is not a student file in an earlier step", n+1, name)
+			}
+			step.Carry[i] = name
+		}
+		sort.Strings(step.Carry)
+	}
+
 	// sanity check timestamps
 	if problem.CreatedAt.Before(BeginningOfTime) || problem.CreatedAt.After(now) {
 		return fmt.Errorf("problem CreatedAt time of %v is invalid", problem.CreatedAt)
@@ -146,11 +528,17 @@ func (problem *Problem) ComputeSignature(secret string, steps []*ProblemStep) st
 	v.Add("problemType", problem.ProblemType)
 	v["tags"] = problem.Tags
 	v["options"] = problem.Options
+	if problem.IsMastery() {
+		v.Add("mastery", strconv.FormatInt(problem.Mastery, 10))
+	}
 	v.Add("createdAt", problem.CreatedAt.Round(time.Second).UTC().Format(time.RFC3339))
 	v.Add("updatedAt", problem.UpdatedAt.Round(time.Second).UTC().Format(time.RFC3339))
 	for _, step := range steps {
 		v.Add(fmt.Sprintf("step-%d-note", step.Step), step.Note)
 		v.Add(fmt.Sprintf("step-%d-weight", step.Step), strconv.FormatFloat(step.Weight, 'g', -1, 64))
+		if len(step.Carry) > 0 {
+			v[fmt.Sprintf("step-%d-carry", step.Step)] = step.Carry
+		}
 		for name, contents := range step.Files {
 			v.Add(fmt.Sprintf("step-%d-file-%s", step.Step, name), contents)
 		}
@@ -193,7 +581,7 @@ func (step *ProblemStep) Normalize(n int64) error {
 		parts := strings.Split(name, "/")
 		fixed := contents
 		if (len(parts) < 2 || !ProblemStepDirectoryWhitelist[parts[0]]) && utf8.ValidString(contents) {
-			fixed = fixLineEndings(contents)
+			fixed = FixLineEndings(contents)
 			if fixed != contents {
 				log.Printf("fixed line endings for %s", name)
 			}
@@ -377,7 +765,9 @@ func (set *ProblemSet) Normalize(now time.Time) error {
 	return nil
 }
 
-func fixLineEndings(s string) string {
+// FixLineEndings normalizes line endings and strips trailing whitespace the way
+// the server does when it saves files.
+func FixLineEndings(s string) string {
 	s = strings.Replace(s, "\r\n", "\n", -1) + "\n"
 	for strings.Contains(s, " \n") {
 		s = strings.Replace(s, " \n", "\n", -1)
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxHelpMessageLength is the longest message a help request can have.
+const MaxHelpMessageLength = 500
+
+// HelpRequest is a student's place in a course's office-hours queue. It links
+// the work the student had saved when they joined, so whoever helps them can
+// look at it first.
+// Status is one of waiting, claimed, resolved, or withdrawn.
+// Position is the student's place among those waiting, starting at 1.
+type HelpRequest struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	UserID       int64     `json:"userID" meddler:"user_id"`
+	Student      string    `json:"student,omitempty" meddler:"-"`
+	AssignmentID int64     `json:"assignmentID,omitempty" meddler:"assignment_id,zeroisnull"`
+	ProblemID    int64     `json:"problemID,omitempty" meddler:"problem_id,zeroisnull"`
+	Problem      string    `json:"problem,omitempty" meddler:"-"`
+	Step         int64     `json:"step,omitempty" meddler:"step,zeroisnull"`
+	CommitID     int64     `json:"commitID,omitempty" meddler:"commit_id,zeroisnull"`
+	Message      string    `json:"message" meddler:"message"`
+	Status       string    `json:"status" meddler:"status"`
+	Position     int64     `json:"position,omitempty" meddler:"-"`
+	HelperID     int64     `json:"helperID,omitempty" meddler:"helper_id,zeroisnull"`
+	Helper       string    `json:"helper,omitempty" meddler:"-"`
+	Note         string    `json:"note,omitempty" meddler:"note,zeroisnull"`
+	ClaimedAt    time.Time `json:"claimedAt,omitempty" meddler:"claimed_at,localtimez"`
+	ResolvedAt   time.Time `json:"resolvedAt,omitempty" meddler:"resolved_at,localtimez"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+func (elt *HelpRequest) Normalize() error {
+	elt.Message = strings.TrimSpace(elt.Message)
+	if elt.Message == "" {
+		return fmt.Errorf("say what you need help with")
+	}
+	if len(elt.Message) > MaxHelpMessageLength {
+		return fmt.Errorf("help request is %d characters long, but cannot be more than %d", len(elt.Message), MaxHelpMessageLength)
+	}
+	return nil
+}
+
+// IsOpen returns true if the student is still waiting or being helped.
+func (elt *HelpRequest) IsOpen() bool {
+	return elt.Status == "waiting" || elt.Status == "claimed"
+}
+
+// HelpQueueStats summarizes a course's office-hours queue over the same
+// period as the course's usage. Waits run from joining the queue until
+// someone claims the request, and help from then until it is resolved.
+type HelpQueueStats struct {
+	Waiting         int64   `json:"waiting"`
+	Requests        int64   `json:"requests"`
+	Resolved        int64   `json:"resolved"`
+	Withdrawn       int64   `json:"withdrawn"`
+	MeanWaitMinutes float64 `json:"meanWaitMinutes"`
+	MaxWaitMinutes  float64 `json:"maxWaitMinutes"`
+	MeanHelpMinutes float64 `json:"meanHelpMinutes"`
+}
@@ -0,0 +1,171 @@
+package types
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SubmissionRecord is one entry in the append-only log of everything a student
+// saved for an assignment. Each entry's hash covers the one before it, so the
+// log cannot be changed after the fact without breaking every later hash.
+type SubmissionRecord struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	CommitID     int64     `json:"commitID" meddler:"commit_id"`
+	ProblemID    int64     `json:"problemID" meddler:"problem_id"`
+	Step         int64     `json:"step" meddler:"step"`
+	Action       string    `json:"action,omitempty" meddler:"action,zeroisnull"`
+	Digest       string    `json:"digest" meddler:"digest"`
+	PrevHash     string    `json:"prevHash,omitempty" meddler:"prev_hash,zeroisnull"`
+	Hash         string    `json:"hash" meddler:"hash"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// ComputeHash computes the hash of a record from its contents and the hash of the record before it.
+func (record *SubmissionRecord) ComputeHash() string {
+	v := make(url.Values)
+	v.Add("prev_hash", record.PrevHash)
+	v.Add("assignment_id", strconv.FormatInt(record.AssignmentID, 10))
+	v.Add("commit_id", strconv.FormatInt(record.CommitID, 10))
+	v.Add("problem_id", strconv.FormatInt(record.ProblemID, 10))
+	v.Add("step", strconv.FormatInt(record.Step, 10))
+	v.Add("action", record.Action)
+	v.Add("digest", record.Digest)
+	v.Add("created_at", record.CreatedAt.Round(time.Second).UTC().Format(time.RFC3339))
+	sum := sha256.Sum256([]byte(encode(v)))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifySubmissionRecords checks that a log of records, oldest first, forms an unbroken chain.
+func VerifySubmissionRecords(records []*SubmissionRecord) error {
+	prev := ""
+	for _, record := range records {
+		if record.PrevHash != prev {
+			return fmt.Errorf("record %d does not follow the record before it", record.ID)
+		}
+		if record.ComputeHash() != record.Hash {
+			return fmt.Errorf("record %d has been changed since it was recorded", record.ID)
+		}
+		prev = record.Hash
+	}
+	return nil
+}
+
+// Digest summarizes the files and result of a commit for the submission log.
+func (commit *Commit) Digest() string {
+	v := make(url.Values)
+	v.Add("problem_id", strconv.FormatInt(commit.ProblemID, 10))
+	v.Add("step", strconv.FormatInt(commit.Step, 10))
+	names := make([]string, 0, len(commit.Files))
+	for name := range commit.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v.Add("file-"+name, FileChecksum(commit.Files[name]))
+	}
+	if commit.ReportCard != nil {
+		v.Add("reportcard-passed", strconv.FormatBool(commit.ReportCard.Passed))
+	}
+	v.Add("score", strconv.FormatFloat(commit.Score, 'g', -1, 64))
+	sum := sha256.Sum256([]byte(encode(v)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReportSigningKey is the public key the server signs grade reports with.
+type ReportSigningKey struct {
+	PublicKey   string `json:"publicKey"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// ReportKeyFingerprint returns a short name for a report signing key,
+// for people to compare when deciding whether to trust it.
+func ReportKeyFingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:16])
+}
+
+// GradeReport is a statement signed by the server of a student's grade on an
+// assignment and of the last entry in their submission log when it was issued.
+// Anyone with the server's public key can check it, so a student who keeps a
+// report can later show what the server recorded. KeyFingerprint names the key
+// it was signed with.
+type GradeReport struct {
+	AssignmentID   int64                `json:"assignmentID"`
+	CourseID       int64                `json:"courseID"`
+	ProblemSetID   int64                `json:"problemSetID"`
+	ProblemSet     string               `json:"problemSet"`
+	UserID         int64                `json:"userID"`
+	Name           string               `json:"name"`
+	Email          string               `json:"email"`
+	RawScores      map[string][]float64 `json:"rawScores"`
+	Score          float64              `json:"score"`
+	Records        int                  `json:"records"`
+	LastHash       string               `json:"lastHash,omitempty"`
+	IssuedAt       time.Time            `json:"issuedAt"`
+	KeyFingerprint string               `json:"keyFingerprint"`
+	Signature      string               `json:"signature,omitempty"`
+}
+
+func (report *GradeReport) signingData() []byte {
+	v := make(url.Values)
+	v.Add("assignment_id", strconv.FormatInt(report.AssignmentID, 10))
+	v.Add("course_id", strconv.FormatInt(report.CourseID, 10))
+	v.Add("problem_set_id", strconv.FormatInt(report.ProblemSetID, 10))
+	v.Add("problem_set", report.ProblemSet)
+	v.Add("user_id", strconv.FormatInt(report.UserID, 10))
+	v.Add("name", report.Name)
+	v.Add("email", report.Email)
+	for unique, scores := range report.RawScores {
+		for n, score := range scores {
+			v.Add(fmt.Sprintf("raw-%s-%d", unique, n+1), strconv.FormatFloat(score, 'g', -1, 64))
+		}
+	}
+	v.Add("score", strconv.FormatFloat(report.Score, 'g', -1, 64))
+	v.Add("records", strconv.Itoa(report.Records))
+	v.Add("last_hash", report.LastHash)
+	v.Add("issued_at", report.IssuedAt.Round(time.Second).UTC().Format(time.RFC3339))
+	v.Add("key_fingerprint", report.KeyFingerprint)
+	return []byte(encode(v))
+}
+
+// Sign signs the report with the server's private key.
+func (report *GradeReport) Sign(key ed25519.PrivateKey) {
+	report.IssuedAt = report.IssuedAt.Round(time.Second)
+	report.KeyFingerprint = ReportKeyFingerprint(key.Public().(ed25519.PublicKey))
+	report.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, report.signingData()))
+}
+
+// Verify checks the report's signature against the server's public key.
+func (report *GradeReport) Verify(key ed25519.PublicKey) error {
+	if fingerprint := ReportKeyFingerprint(key); report.KeyFingerprint != fingerprint {
+		return fmt.Errorf("the report was signed with key %s, not %s", report.KeyFingerprint, fingerprint)
+	}
+	sig, err := base64.StdEncoding.DecodeString(report.Signature)
+	if err != nil {
+		return fmt.Errorf("the report signature is not valid base64: %v", err)
+	}
+	if !ed25519.Verify(key, report.signingData(), sig) {
+		return fmt.Errorf("the report signature does not match its contents")
+	}
+	return nil
+}
+
+// DecodeReportSigningKey decodes a public key published by the server.
+func DecodeReportSigningKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("the signing key is not valid base64: %v", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("the signing key has %d bytes, expected %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
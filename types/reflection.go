@@ -0,0 +1,74 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// When a reflection prompt is asked.
+const (
+	ReflectionBefore = "before" // must be answered before the last step can be graded
+	ReflectionAfter  = "after"  // asked once the student passes the problem
+)
+
+var reflectionNameRE = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// ReflectionPrompt is a question students answer about their work on a problem,
+// such as how long it took or what was hardest. The answers are kept with the
+// student's commit for the last step of the problem.
+type ReflectionPrompt struct {
+	ProblemID int64     `json:"problemID" meddler:"problem_id"`
+	Name      string    `json:"name" meddler:"name"`
+	Question  string    `json:"question" meddler:"question"`
+	When      string    `json:"when" meddler:"asked_when"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks a prompt, defaulting to asking before grading.
+func (prompt *ReflectionPrompt) Normalize() error {
+	prompt.Name = strings.TrimSpace(prompt.Name)
+	if !reflectionNameRE.MatchString(prompt.Name) {
+		return fmt.Errorf("%q is not a valid prompt name; use lower case letters, digits, and dashes", prompt.Name)
+	}
+	prompt.Question = strings.TrimSpace(prompt.Question)
+	if prompt.Question == "" {
+		return fmt.Errorf("the prompt must have a question")
+	}
+	switch prompt.When {
+	case "":
+		prompt.When = ReflectionBefore
+	case ReflectionBefore, ReflectionAfter:
+	default:
+		return fmt.Errorf("prompts are asked %q or %q grading, not %q", ReflectionBefore, ReflectionAfter, prompt.When)
+	}
+	return nil
+}
+
+// ReflectionStatus is a prompt along with the student's answer, if any.
+type ReflectionStatus struct {
+	ReflectionPrompt
+	Answer string `json:"answer,omitempty"`
+}
+
+// ReflectionAnswers carries a student's answers to the prompts for a problem, by prompt name.
+type ReflectionAnswers struct {
+	Answers map[string]string `json:"answers"`
+}
+
+// ReflectionAnswer is one answer to a prompt, as exported for instructors.
+// For research exports the student is identified only by a pseudonym.
+type ReflectionAnswer struct {
+	AssignmentID int64     `json:"assignmentID,omitempty" meddler:"assignment_id"`
+	UserID       int64     `json:"userID,omitempty" meddler:"user_id"`
+	Name         string    `json:"name" meddler:"name"`
+	Email        string    `json:"email,omitempty" meddler:"email"`
+	ProblemID    int64     `json:"problemID" meddler:"problem_id"`
+	Unique       string    `json:"unique" meddler:"unique_id"`
+	Prompt       string    `json:"prompt" meddler:"prompt"`
+	Answer       string    `json:"answer" meddler:"answer"`
+	Score        float64   `json:"score" meddler:"score,zeroisnull"`
+	AnsweredAt   time.Time `json:"answeredAt" meddler:"answered_at,localtime"`
+}
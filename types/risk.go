@@ -0,0 +1,65 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// Thresholds for the signals that a student may be falling behind.
+// RiskIdleDays is how long an open assignment can sit untouched,
+// RiskFailedAttempts is how many failed grading attempts on one step
+// without passing it count as stuck, looking back RiskWindowDays, and
+// RiskInactiveDays is how long a student can go without saving anything
+// in a course while they still have open assignments.
+const (
+	RiskIdleDays       = 3
+	RiskFailedAttempts = 10
+	RiskWindowDays     = 7
+	RiskInactiveDays   = 10
+)
+
+// AtRiskStudent is a student in a course who shows one or more signals of
+// falling behind, so an instructor can reach out before it is too late.
+// LastActiveAt is when they last saved work in the course, if ever.
+type AtRiskStudent struct {
+	UserID       int64         `json:"userID"`
+	Name         string        `json:"name"`
+	Email        string        `json:"email"`
+	LastActiveAt time.Time     `json:"lastActiveAt,omitempty"`
+	Signals      []*RiskSignal `json:"signals"`
+}
+
+// RiskSignal is one reason a student may be falling behind.
+// Kind is idle for an open assignment with no work saved on it,
+// stuck for repeated failed attempts at a step that has never passed,
+// or inactive for a student who has stopped saving work altogether.
+type RiskSignal struct {
+	Kind         string    `json:"kind"`
+	AssignmentID int64     `json:"assignmentID,omitempty"`
+	ProblemSet   string    `json:"problemSet,omitempty"`
+	Problem      string    `json:"problem,omitempty"`
+	Step         int64     `json:"step,omitempty"`
+	Attempts     int64     `json:"attempts,omitempty"`
+	Since        time.Time `json:"since,omitempty"`
+}
+
+// String describes the signal in a few words.
+func (elt *RiskSignal) String() string {
+	switch elt.Kind {
+	case "idle":
+		return fmt.Sprintf("has not started %s, open since %s", elt.ProblemSet, elt.Since.Local().Format("Jan 2"))
+	case "stuck":
+		return fmt.Sprintf("failed %s step %d %d times in %d days without passing", elt.Problem, elt.Step, elt.Attempts, RiskWindowDays)
+	case "inactive":
+		return fmt.Sprintf("has saved nothing since %s", elt.Since.Local().Format("Jan 2"))
+	default:
+		return elt.Kind
+	}
+}
+
+// RiskDigest is an instructor's request to be emailed a course's at-risk list each week.
+type RiskDigest struct {
+	CourseID  int64     `json:"courseID" meddler:"course_id"`
+	UserID    int64     `json:"userID" meddler:"user_id"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
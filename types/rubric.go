@@ -0,0 +1,176 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Ways of combining a rubric score with the autograded score.
+const (
+	RubricWeighted = "weighted" // the rubric counts for Weight of the grade and the autograder for the rest
+	RubricMinimum  = "minimum"  // the lower of the two
+	RubricProduct  = "product"  // the autograded score scaled by the rubric score
+)
+
+// RubricLevel is one level of achievement on a criterion.
+type RubricLevel struct {
+	Name        string  `json:"name"`
+	Points      float64 `json:"points"`
+	Description string  `json:"description,omitempty"`
+}
+
+// RubricCriterion is one thing a rubric grades, with its levels from best to worst.
+type RubricCriterion struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Levels      []*RubricLevel `json:"levels"`
+}
+
+// Rubric describes how instructors grade a problem set by hand in a course,
+// and how that grade is combined with the autograded score.
+type Rubric struct {
+	CourseID     int64              `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64              `json:"problemSetID" meddler:"problem_set_id"`
+	Criteria     []*RubricCriterion `json:"criteria" meddler:"criteria,json"`
+	Formula      string             `json:"formula" meddler:"formula"`
+	Weight       float64            `json:"weight" meddler:"weight"`
+	CreatedAt    time.Time          `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time          `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks a rubric, defaulting to the weighted formula.
+func (rubric *Rubric) Normalize() error {
+	if len(rubric.Criteria) == 0 {
+		return fmt.Errorf("a rubric must have at least one criterion")
+	}
+	criteria := make(map[string]bool)
+	for _, criterion := range rubric.Criteria {
+		criterion.Name = strings.TrimSpace(criterion.Name)
+		if criterion.Name == "" {
+			return fmt.Errorf("every criterion must have a name")
+		}
+		if criteria[criterion.Name] {
+			return fmt.Errorf("criterion %q appears more than once", criterion.Name)
+		}
+		criteria[criterion.Name] = true
+		if len(criterion.Levels) == 0 {
+			return fmt.Errorf("criterion %q has no levels", criterion.Name)
+		}
+		levels := make(map[string]bool)
+		for _, level := range criterion.Levels {
+			level.Name = strings.TrimSpace(level.Name)
+			if level.Name == "" {
+				return fmt.Errorf("every level of criterion %q must have a name", criterion.Name)
+			}
+			if levels[level.Name] {
+				return fmt.Errorf("criterion %q has level %q more than once", criterion.Name, level.Name)
+			}
+			levels[level.Name] = true
+			if level.Points < 0.0 || math.IsNaN(level.Points) || math.IsInf(level.Points, 0) {
+				return fmt.Errorf("level %q of criterion %q has invalid points", level.Name, criterion.Name)
+			}
+		}
+	}
+	if rubric.MaxPoints() <= 0.0 {
+		return fmt.Errorf("the rubric must be worth some points")
+	}
+	switch rubric.Formula {
+	case "":
+		rubric.Formula = RubricWeighted
+	case RubricWeighted, RubricMinimum, RubricProduct:
+	default:
+		return fmt.Errorf("unknown formula %q; use %s, %s, or %s", rubric.Formula, RubricWeighted, RubricMinimum, RubricProduct)
+	}
+	if rubric.Formula == RubricWeighted && (rubric.Weight < 0.0 || rubric.Weight > 1.0) {
+		return fmt.Errorf("the weight of the rubric must be between 0 and 1")
+	}
+	return nil
+}
+
+// MaxPoints returns the points for the best level of every criterion.
+func (rubric *Rubric) MaxPoints() float64 {
+	total := 0.0
+	for _, criterion := range rubric.Criteria {
+		best := 0.0
+		for _, level := range criterion.Levels {
+			best = math.Max(best, level.Points)
+		}
+		total += best
+	}
+	return total
+}
+
+// Grade totals the points for the levels chosen for each criterion,
+// filling in grade.Points and grade.Score. Every criterion must have a level.
+func (rubric *Rubric) Grade(grade *RubricGrade) error {
+	for name := range grade.Levels {
+		if rubric.criterion(name) == nil {
+			return fmt.Errorf("the rubric has no criterion %q", name)
+		}
+	}
+	points := 0.0
+	for _, criterion := range rubric.Criteria {
+		chosen, exists := grade.Levels[criterion.Name]
+		if !exists {
+			return fmt.Errorf("choose a level for criterion %q", criterion.Name)
+		}
+		var level *RubricLevel
+		for _, elt := range criterion.Levels {
+			if elt.Name == chosen {
+				level = elt
+			}
+		}
+		if level == nil {
+			return fmt.Errorf("criterion %q has no level %q", criterion.Name, chosen)
+		}
+		points += level.Points
+	}
+	grade.Points = points
+	grade.Score = points / rubric.MaxPoints()
+	return nil
+}
+
+func (rubric *Rubric) criterion(name string) *RubricCriterion {
+	for _, elt := range rubric.Criteria {
+		if elt.Name == name {
+			return elt
+		}
+	}
+	return nil
+}
+
+// Combine merges the autograded score with the rubric score using the rubric's formula.
+func (rubric *Rubric) Combine(auto, manual float64) float64 {
+	switch rubric.Formula {
+	case RubricMinimum:
+		return math.Min(auto, manual)
+	case RubricProduct:
+		return auto * manual
+	default:
+		return auto*(1.0-rubric.Weight) + manual*rubric.Weight
+	}
+}
+
+// RubricGrade is an instructor's grade for one student against the rubric,
+// with the level chosen for each criterion by name. Comments are shown to the
+// student alongside each criterion.
+type RubricGrade struct {
+	AssignmentID int64             `json:"assignmentID,omitempty" meddler:"assignment_id"`
+	Student      string            `json:"student,omitempty" meddler:"-"`
+	Levels       map[string]string `json:"levels" meddler:"levels,json"`
+	Comments     map[string]string `json:"comments,omitempty" meddler:"comments,json"`
+	Note         string            `json:"note,omitempty" meddler:"note,zeroisnull"`
+	Points       float64           `json:"points" meddler:"points"`
+	Score        float64           `json:"score" meddler:"score"`
+	GraderID     int64             `json:"graderID,omitempty" meddler:"grader_id,zeroisnull"`
+	CreatedAt    time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// RubricFeedback is what a student sees of the rubric for an assignment and their grade on it.
+type RubricFeedback struct {
+	Rubric *Rubric      `json:"rubric"`
+	Grade  *RubricGrade `json:"grade,omitempty"`
+}
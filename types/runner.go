@@ -0,0 +1,67 @@
+package types
+
+import "time"
+
+// RunnerPath is where runner agents connect to a daycare with a websocket.
+const RunnerPath = "/v2/runners/connect"
+
+// RunnerTokenHeader carries the token a runner agent presents when it connects.
+// Each agent has its own token, which only works with the name it was made for.
+const RunnerTokenHeader = "X-Runner-Token"
+
+// Runner operations. The daycare sends the first group and the agent sends the second.
+//
+// An agent opens the connection by sending hello with its Platform and Capabilities.
+// After that the daycare leases it to one grading run at a time: start begins the
+// lease and remove ends it. Every message in either direction renews the lease. If a
+// lease sits idle for longer than the problem type's MaxClock plus a few minutes, the
+// daycare lets it expire and may send start for a different grading run, so an agent
+// must clean up after any earlier run when it gets start.
+const (
+	RunnerStart  = "start"  // make a fresh working directory with Env and Timeout
+	RunnerPut    = "put"    // write Files into the working directory
+	RunnerGet    = "get"    // read the Names files from the working directory
+	RunnerExec   = "exec"   // run Cmd in the working directory
+	RunnerStdin  = "stdin"  // send Data to the running command
+	RunnerEOF    = "eof"    // close the running command's input
+	RunnerKill   = "kill"   // stop the running command
+	RunnerRemove = "remove" // delete the working directory and end the lease
+
+	RunnerHello  = "hello"  // the agent grades for Platform and offers Capabilities
+	RunnerReady  = "ready"  // the last start, put, or remove is done
+	RunnerFiles  = "files"  // Files answers a get
+	RunnerStdout = "stdout" // Data is output from the running command
+	RunnerStderr = "stderr" // Data is error output from the running command
+	RunnerExit   = "exit"   // the running command finished with Status
+	RunnerError  = "error"  // the last operation failed with Error
+)
+
+// RunnerMessage is a single message between a daycare and a runner agent.
+// Runner agents run problem types that must be graded on a platform other than
+// Linux, such as Windows or macOS, or that need hardware attached to a particular
+// machine, using tools installed on the agent's machine.
+// These objects are streamed across a websockets connection.
+type RunnerMessage struct {
+	Op           string            `json:"op"`
+	Platform     string            `json:"platform,omitempty"`
+	Capabilities []string          `json:"capabilities,omitempty"`
+	Env          []string          `json:"env,omitempty"`
+	Timeout      int               `json:"timeout,omitempty"`
+	Cmd          []string          `json:"cmd,omitempty"`
+	Data         string            `json:"data,omitempty"`
+	Files        map[string]string `json:"files,omitempty"`
+	Names        []string          `json:"names,omitempty"`
+	Status       int               `json:"status,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// RunnerInfo describes a runner agent connected to a daycare.
+// LeasedTo names the grading run holding the agent, if any.
+type RunnerInfo struct {
+	Name         string    `json:"name"`
+	Platform     string    `json:"platform"`
+	Capabilities []string  `json:"capabilities,omitempty"`
+	Address      string    `json:"address"`
+	LeasedTo     string    `json:"leasedTo,omitempty"`
+	LeaseExpires time.Time `json:"leaseExpires"`
+}
@@ -0,0 +1,206 @@
+package types
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SealKeyBits is the size of the RSA keys generated for sealed exams.
+const SealKeyBits = 3072
+
+// SealedExam marks a problem set in a course as an exam whose submissions
+// are encrypted to the instructor's public key. The server cannot read them
+// until the instructor supplies the private key after the deadline.
+type SealedExam struct {
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"problem_set_id"`
+	PublicKey    string    `json:"publicKey" meddler:"public_key"`
+	Deadline     time.Time `json:"deadline" meddler:"deadline,localtime"`
+	UnsealedAt   time.Time `json:"unsealedAt,omitempty" meddler:"unsealed_at,localtimez"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// IsSealed returns true if submissions must still be sealed.
+func (exam *SealedExam) IsSealed() bool {
+	return exam.UnsealedAt.IsZero()
+}
+
+// SealKey carries the instructor's private key when unsealing an exam.
+// It is used to decrypt the submissions and is never stored.
+type SealKey struct {
+	PrivateKey string `json:"privateKey"`
+}
+
+// SealedSubmission is the encrypted work of a student on one step of a problem in a sealed exam.
+// The files are encrypted with a random AES key, which is in turn encrypted to the instructor's
+// public key. Once the exam is unsealed, the files are saved as a commit and queued as a Submission.
+type SealedSubmission struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	UserID       int64     `json:"userID" meddler:"user_id"`
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	ProblemID    int64     `json:"problemID" meddler:"problem_id"`
+	Step         int64     `json:"step" meddler:"step"`
+	EncryptedKey string    `json:"encryptedKey" meddler:"encrypted_key"`
+	Nonce        string    `json:"nonce" meddler:"nonce"`
+	Ciphertext   string    `json:"ciphertext" meddler:"ciphertext"`
+	SubmissionID int64     `json:"submissionID,omitempty" meddler:"submission_id,zeroisnull"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// GenerateSealKeys creates a new key pair for a sealed exam,
+// returning the public and private keys PEM encoded.
+func GenerateSealKeys() (publicKey, privateKey string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, SealKeyBits)
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	publicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	privateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	return publicKey, privateKey, nil
+}
+
+// ParseSealPublicKey decodes a PEM encoded RSA public key.
+func ParseSealPublicKey(publicKey string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("public key must be a PEM encoded PUBLIC KEY block")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key must be an RSA key")
+	}
+	if rsaKey.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("public key must be at least 2048 bits")
+	}
+	return rsaKey, nil
+}
+
+// SealKeyFingerprint identifies an exam's public key in a form short enough to
+// read out in class, so students can check that their work is sealed to their
+// instructor's key and not one put in its place on the server.
+func SealKeyFingerprint(publicKey *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	var groups []string
+	for i := 0; i < 10; i += 2 {
+		groups = append(groups, hex.EncodeToString(sum[i:i+2]))
+	}
+	return strings.Join(groups, "-")
+}
+
+// ParseSealPrivateKey decodes a PEM encoded RSA private key.
+func ParseSealPrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, fmt.Errorf("private key must be a PEM encoded RSA PRIVATE KEY block")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// MatchesPublicKey returns true if the private key belongs to the given public key.
+func MatchesPublicKey(private *rsa.PrivateKey, public *rsa.PublicKey) bool {
+	return private.PublicKey.E == public.E && private.PublicKey.N.Cmp(public.N) == 0
+}
+
+// sealContext binds the ciphertext to a single step of a single assignment,
+// so sealed work cannot be moved from one student or problem to another.
+func (sub *SealedSubmission) sealContext() []byte {
+	return []byte(fmt.Sprintf("codegrinder-seal:%d:%d:%d", sub.AssignmentID, sub.ProblemID, sub.Step))
+}
+
+// Seal encrypts the files to the public key. AssignmentID, ProblemID,
+// and Step must be set first as they are bound into the ciphertext.
+func (sub *SealedSubmission) Seal(publicKey *rsa.PublicKey, files map[string]string) error {
+	plaintext, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		return err
+	}
+	gcm, err := newSealCipher(aesKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, sub.sealContext())
+	if err != nil {
+		return err
+	}
+	sub.EncryptedKey = base64.StdEncoding.EncodeToString(encryptedKey)
+	sub.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	sub.Ciphertext = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, sub.sealContext()))
+	return nil
+}
+
+// Unseal decrypts the files using the private key.
+func (sub *SealedSubmission) Unseal(privateKey *rsa.PrivateKey) (map[string]string, error) {
+	encryptedKey, err := base64.StdEncoding.DecodeString(sub.EncryptedKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encrypted key: %v", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sub.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding nonce: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sub.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ciphertext: %v", err)
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, encryptedKey, sub.sealContext())
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting key: %v", err)
+	}
+	gcm, err := newSealCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("nonce must be %d bytes, found %d", gcm.NonceSize(), len(nonce))
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, sub.sealContext())
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting files: %v", err)
+	}
+	files := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &files); err != nil {
+		return nil, fmt.Errorf("error decoding files: %v", err)
+	}
+	return files, nil
+}
+
+func newSealCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
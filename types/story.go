@@ -0,0 +1,127 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SubmissionSnapshot keeps the files saved with one entry in the submission
+// log, along with how the work fared if it had been graded, so a student's
+// work can be followed save by save.
+type SubmissionSnapshot struct {
+	RecordID int64             `json:"recordID" meddler:"record_id"`
+	Files    map[string]string `json:"files" meddler:"files,json"`
+	Graded   bool              `json:"graded" meddler:"graded"`
+	Passed   bool              `json:"passed" meddler:"passed"`
+	Score    float64           `json:"score" meddler:"score"`
+	Outcomes map[string]int    `json:"outcomes,omitempty" meddler:"outcomes,json"`
+}
+
+// NewSubmissionSnapshot records the files and results of a saved commit.
+func NewSubmissionSnapshot(recordID int64, commit *Commit) *SubmissionSnapshot {
+	snapshot := &SubmissionSnapshot{
+		RecordID: recordID,
+		Files:    commit.Files,
+		Score:    commit.Score,
+	}
+	if card := commit.ReportCard; card != nil {
+		snapshot.Graded = true
+		snapshot.Passed = card.Passed
+		snapshot.Outcomes = make(map[string]int)
+		for _, result := range card.Results {
+			snapshot.Outcomes[result.Outcome]++
+		}
+	}
+	return snapshot
+}
+
+// SubmissionStory is the history of a student's work on an assignment, one
+// entry per save, oldest first, for instructors to walk through.
+type SubmissionStory struct {
+	AssignmentID int64        `json:"assignmentID,omitempty"`
+	UserID       int64        `json:"userID,omitempty"`
+	Saves        []*StorySave `json:"saves"`
+}
+
+// StorySave is one save in a SubmissionStory. Its changes are against the
+// save before it for the same problem.
+type StorySave struct {
+	RecordID  int64          `json:"recordID"`
+	Problem   string         `json:"problem"`
+	Step      int64          `json:"step"`
+	Action    string         `json:"action,omitempty"`
+	Files     int            `json:"files"`
+	Size      int            `json:"size"`
+	Changes   []*StoryChange `json:"changes,omitempty"`
+	Graded    bool           `json:"graded"`
+	Passed    bool           `json:"passed"`
+	Score     float64        `json:"score"`
+	Outcomes  map[string]int `json:"outcomes,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+}
+
+// StoryChange is a file added, removed, or changed by a save.
+// Diff lists the changed lines, each starting with + or -, with a
+// little of the surrounding code starting with a space.
+type StoryChange struct {
+	Name    string `json:"name"`
+	Change  string `json:"change"`
+	Size    int    `json:"size"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Diff    string `json:"diff,omitempty"`
+}
+
+// Summary describes a save in one line.
+func (save *StorySave) Summary() string {
+	var parts []string
+	switch {
+	case save.Action == "grade" && save.Graded:
+		parts = append(parts, "graded")
+	case save.Action != "":
+		parts = append(parts, "sent to "+save.Action)
+	default:
+		parts = append(parts, "saved")
+	}
+	if len(save.Changes) == 0 {
+		parts = append(parts, "no changes")
+	} else {
+		added, removed := 0, 0
+		var names []string
+		for _, change := range save.Changes {
+			added += change.Added
+			removed += change.Removed
+			switch change.Change {
+			case "added":
+				names = append(names, "+"+change.Name)
+			case "removed":
+				names = append(names, "-"+change.Name)
+			default:
+				names = append(names, change.Name)
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s (+%d -%d lines)", strings.Join(names, ", "), added, removed))
+	}
+	if save.Graded {
+		var kinds []string
+		for kind := range save.Outcomes {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		var counts []string
+		for _, kind := range kinds {
+			counts = append(counts, fmt.Sprintf("%d %s", save.Outcomes[kind], kind))
+		}
+		result := "failed"
+		if save.Passed {
+			result = "passed"
+		}
+		if len(counts) > 0 {
+			result += ": " + strings.Join(counts, ", ")
+		}
+		parts = append(parts, fmt.Sprintf("%s, score %.0f%%", result, save.Score*100.0))
+	}
+	return strings.Join(parts, "; ")
+}
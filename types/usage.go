@@ -0,0 +1,95 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuotaWarnFraction is how much of a quota a course can use before its instructors are warned.
+const QuotaWarnFraction = 0.8
+
+// CourseQuota limits the grading time and storage a course can use over its lifetime.
+// A limit of zero means there is no limit. When a course reaches a limit, students
+// can still save work but cannot have it graded until the quota is raised.
+type CourseQuota struct {
+	CourseID       int64     `json:"courseID" meddler:"course_id"`
+	GradingMinutes float64   `json:"gradingMinutes" meddler:"grading_minutes"`
+	StorageMB      float64   `json:"storageMB" meddler:"storage_mb"`
+	CreatedAt      time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt      time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Normalize checks the quota for sane values.
+func (quota *CourseQuota) Normalize() error {
+	if quota.GradingMinutes < 0 || quota.StorageMB < 0 {
+		return fmt.Errorf("quotas cannot be negative")
+	}
+	if quota.GradingMinutes == 0 && quota.StorageMB == 0 {
+		return fmt.Errorf("a quota must limit grading minutes, storage, or both")
+	}
+	return nil
+}
+
+// CourseUsageDay is the grading done for a course on one day.
+type CourseUsageDay struct {
+	CourseID       int64     `json:"courseID" meddler:"course_id"`
+	Day            time.Time `json:"day" meddler:"day,localtime"`
+	Gradings       int64     `json:"gradings" meddler:"gradings"`
+	GradingSeconds float64   `json:"gradingSeconds" meddler:"grading_seconds"`
+}
+
+// CourseUsage reports the resources a course has used. Grading is counted between
+// From and To when they are set, and over the life of the course otherwise.
+// Storage is what the course's saved work takes up now. The office-hours queue
+// is only summarized in the report for a single course.
+type CourseUsage struct {
+	CourseID       int64           `json:"courseID"`
+	Name           string          `json:"name"`
+	From           time.Time       `json:"from,omitempty"`
+	To             time.Time       `json:"to,omitempty"`
+	Gradings       int64           `json:"gradings"`
+	GradingMinutes float64         `json:"gradingMinutes"`
+	StorageMB      float64         `json:"storageMB"`
+	Quota          *CourseQuota    `json:"quota,omitempty"`
+	Help           *HelpQueueStats `json:"help,omitempty"`
+	Warnings       []string        `json:"warnings,omitempty"`
+}
+
+// CheckQuota fills in warnings for any quota that is used up or nearly so,
+// returning an explanation if grading should stop.
+func (usage *CourseUsage) CheckQuota() string {
+	usage.Warnings = nil
+	if usage.Quota == nil {
+		return ""
+	}
+	over := ""
+	check := func(what string, used, limit float64) {
+		switch {
+		case limit <= 0:
+		case used >= limit:
+			msg := fmt.Sprintf("the course has used all %.0f of its %s", limit, what)
+			usage.Warnings = append(usage.Warnings, msg+"; students cannot have work graded until the quota is raised")
+			if over == "" {
+				over = msg
+			}
+		case used >= limit*QuotaWarnFraction:
+			usage.Warnings = append(usage.Warnings, fmt.Sprintf("the course has used %.0f%% of its %.0f %s", used/limit*100.0, limit, what))
+		}
+	}
+	check("grading minutes", usage.GradingMinutes, usage.Quota.GradingMinutes)
+	check("MB of storage", usage.StorageMB, usage.Quota.StorageMB)
+	return over
+}
+
+// ComputeTime is the container time a grading run used. Runs against a matrix
+// of toolchains happen side by side, so each variant's time is counted.
+func (elt *ReportCard) ComputeTime() time.Duration {
+	if len(elt.Variants) == 0 {
+		return elt.Duration
+	}
+	var total time.Duration
+	for _, variant := range elt.Variants {
+		total += variant.Duration
+	}
+	return total
+}
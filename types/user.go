@@ -5,6 +5,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/url"
@@ -21,17 +22,43 @@ const (
 	OpenCommitTimeout         = 6 * time.Hour
 	SignedCommitTimeout       = 15 * time.Minute
 	CookieName                = "codegrinder"
+	ServerTimeHeader          = "X-Server-Time" // response header giving the server clock in RFC 3339 format
+	NextCursorHeader          = "X-Next-Cursor" // response header giving the cursor for the next page of a list
+	MaxClockSkew              = time.Minute     // clients warn when their clock is off from the server by more than this
 )
 
 // Course represents a single instance of a course as defined by LTI.
+// A course is tied to a single term, and becomes inactive when the term ends
+// or when an instructor archives it.
 type Course struct {
 	ID        int64     `json:"id" meddler:"id,pk"`
 	Name      string    `json:"name" meddler:"name"`
 	Label     string    `json:"label" meddler:"lti_label"`
 	LtiID     string    `json:"ltiID" meddler:"lti_id"`
 	CanvasID  int64     `json:"canvasID" meddler:"canvas_id"`
+	Term      string    `json:"term" meddler:"term,zeroisnull"`
+	EndsAt    time.Time `json:"endsAt" meddler:"ends_at,localtimez"`
+	Archived  bool      `json:"archived" meddler:"archived"`
 	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
 	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+
+	// set when an instructor makes the course active again after its term ended,
+	// so the LMS term end date is ignored and the course is not archived at the end of term
+	ArchiveOverride bool `json:"archiveOverride" meddler:"archive_override"`
+
+	// the LMS roster service, as reported by the most recent launch that included it
+	RosterURL         string    `json:"-" meddler:"roster_url,zeroisnull"`
+	RosterID          string    `json:"-" meddler:"roster_id,zeroisnull"`
+	RosterConsumerKey string    `json:"-" meddler:"roster_consumer_key,zeroisnull"`
+	RosterSyncedAt    time.Time `json:"rosterSyncedAt,omitempty" meddler:"roster_synced_at,localtimez"`
+}
+
+// CourseProblemSet records that a problem set has been offered in a course.
+// These are used to carry a course's problem sets forward into a new term.
+type CourseProblemSet struct {
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"problem_set_id"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
 }
 
 // User represents a single user as defined by LTI.
@@ -53,26 +80,41 @@ type User struct {
 // Assignment represents a single instance of a problem set for a student in a course.
 // Many commits (attempts to solve a step of a problem in the set) are linked to an assignment.
 type Assignment struct {
-	ID                 int64                `json:"id" meddler:"id,pk"`
-	CourseID           int64                `json:"courseID" meddler:"course_id"`
-	ProblemSetID       int64                `json:"problemSetID" meddler:"problem_set_id"`
-	UserID             int64                `json:"userID" meddler:"user_id"`
-	Roles              string               `json:"roles" meddler:"roles"`
-	Instructor         bool                 `json:"instructor" meddler:"instructor"`
-	RawScores          map[string][]float64 `json:"raw_scores" meddler:"raw_scores,json"`
-	Score              float64              `json:"score" meddler:"score,zeroisnull"`
-	GradeID            string               `json:"-" meddler:"grade_id,zeroisnull"`
-	LtiID              string               `json:"-" meddler:"lti_id"`
-	CanvasTitle        string               `json:"canvasTitle" meddler:"canvas_title"`
-	CanvasID           int64                `json:"canvasID" meddler:"canvas_id"`
-	CanvasAPIDomain    string               `json:"canvasAPIDomain" meddler:"canvas_api_domain"`
-	OutcomeURL         string               `json:"-" meddler:"outcome_url"`
-	OutcomeExtURL      string               `json:"-" meddler:"outcome_ext_url"`
-	OutcomeExtAccepted string               `json:"-" meddler:"outcome_ext_accepted"`
-	FinishedURL        string               `json:"finishedURL" meddler:"finished_url"`
-	ConsumerKey        string               `json:"-" meddler:"consumer_key"`
-	CreatedAt          time.Time            `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt          time.Time            `json:"updatedAt" meddler:"updated_at,localtime"`
+	ID                 int64                 `json:"id" meddler:"id,pk"`
+	CourseID           int64                 `json:"courseID" meddler:"course_id"`
+	ProblemSetID       int64                 `json:"problemSetID" meddler:"problem_set_id"`
+	UserID             int64                 `json:"userID" meddler:"user_id"`
+	Roles              string                `json:"roles" meddler:"roles"`
+	Instructor         bool                  `json:"instructor" meddler:"instructor"`
+	RawScores          map[string][]float64  `json:"raw_scores" meddler:"raw_scores,json"`
+	Score              float64               `json:"score" meddler:"score,zeroisnull"`
+	GradeID            string                `json:"-" meddler:"grade_id,zeroisnull"`
+	LtiID              string                `json:"-" meddler:"lti_id"`
+	CanvasTitle        string                `json:"canvasTitle" meddler:"canvas_title"`
+	CanvasID           int64                 `json:"canvasID" meddler:"canvas_id"`
+	CanvasAPIDomain    string                `json:"canvasAPIDomain" meddler:"canvas_api_domain"`
+	OutcomeURL         string                `json:"-" meddler:"outcome_url"`
+	OutcomeExtURL      string                `json:"-" meddler:"outcome_ext_url"`
+	OutcomeExtAccepted string                `json:"-" meddler:"outcome_ext_accepted"`
+	FinishedURL        string                `json:"finishedURL" meddler:"finished_url"`
+	ConsumerKey        string                `json:"-" meddler:"consumer_key"`
+	DroppedAt          time.Time             `json:"droppedAt,omitempty" meddler:"dropped_at,localtimez"`
+	DueAt              time.Time             `json:"dueAt,omitempty" meddler:"due_at,localtimez"`
+	Path               string                `json:"path,omitempty" meddler:"path,zeroisnull"`
+	Prerequisites      []*PrerequisiteStatus `json:"prerequisites,omitempty" meddler:"-"`
+	CreatedAt          time.Time             `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt          time.Time             `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// AssignmentListing is an assignment with the details of its course and problem set
+// needed to list it, so a listing does not need a request for each course and problem set.
+type AssignmentListing struct {
+	*Assignment
+	CourseName       string `json:"courseName"`
+	CourseLabel      string `json:"courseLabel"`
+	CourseTerm       string `json:"courseTerm,omitempty"`
+	CourseActive     bool   `json:"courseActive"`
+	ProblemSetUnique string `json:"problemSetUnique"`
 }
 
 // Commit defines an attempt at solving one step of a Problem.
@@ -84,13 +126,265 @@ type Commit struct {
 	Action       string            `json:"action" meddler:"action,zeroisnull"`
 	Note         string            `json:"note" meddler:"note,zeroisnull"`
 	Files        map[string]string `json:"files" meddler:"files,json"`
+	Checksums    map[string]string `json:"checksums,omitempty" meddler:"-"`
+	Streak       *MasteryStreak    `json:"streak,omitempty" meddler:"-"`
 	Transcript   []*EventMessage   `json:"transcript,omitempty" meddler:"transcript,json"`
 	ReportCard   *ReportCard       `json:"reportCard" meddler:"report_card,json"`
+	Reflections  map[string]string `json:"reflections,omitempty" meddler:"reflections,json"`
 	Score        float64           `json:"score" meddler:"score,zeroisnull"`
+	ClientTime   time.Time         `json:"clientTime,omitempty" meddler:"client_time,localtimez"`
+	CreatedAt    time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Submission is a commit queued to be graded in the background.
+// Status is one of queued, grading, done, or failed. When grading is done,
+// the score and report card are recorded here as well as on the commit.
+// Files is a copy of the submitted files, so later saves to the same step
+// do not change what gets graded.
+type Submission struct {
+	ID           int64             `json:"id" meddler:"id,pk"`
+	UserID       int64             `json:"userID" meddler:"user_id"`
+	AssignmentID int64             `json:"assignmentID" meddler:"assignment_id"`
+	ProblemID    int64             `json:"problemID" meddler:"problem_id"`
+	Step         int64             `json:"step" meddler:"step"`
+	CommitID     int64             `json:"commitID" meddler:"commit_id"`
+	Files        map[string]string `json:"-" meddler:"files,json"`
+	Status       string            `json:"status" meddler:"status"`
+	Note         string            `json:"note" meddler:"note"`
+	Score        float64           `json:"score" meddler:"score"`
+	ReportCard   *ReportCard       `json:"reportCard,omitempty" meddler:"report_card,json"`
 	CreatedAt    time.Time         `json:"createdAt" meddler:"created_at,localtime"`
 	UpdatedAt    time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
+// IsFinished returns true if the submission is done or has failed.
+func (submission *Submission) IsFinished() bool {
+	return submission.Status == "done" || submission.Status == "failed"
+}
+
+// AnonymousGrading hides student identities from instructors for a problem set in a course.
+// Until grading is finalized, instructor views show pseudonyms in place of names,
+// leave out user and assignment IDs, and name students by pseudonym when grading
+// them, and grades are not posted to the LMS. Salt keeps pseudonyms from being
+// linked across problem sets and is never sent to clients.
+type AnonymousGrading struct {
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID int64     `json:"problemSetID" meddler:"problem_set_id"`
+	Salt         string    `json:"-" meddler:"salt"`
+	FinalizedAt  time.Time `json:"finalizedAt,omitempty" meddler:"finalized_at,localtimez"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// IsAnonymous returns true if identities are still hidden.
+func (anon *AnonymousGrading) IsAnonymous() bool {
+	return anon.FinalizedAt.IsZero()
+}
+
+// Pseudonym returns the name used for a user while grading is anonymous.
+func (anon *AnonymousGrading) Pseudonym(userID int64) string {
+	mac := hmac.New(sha256.New, []byte(anon.Salt))
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	return PseudonymPrefix + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// PseudonymPrefix starts every pseudonym, so one can be told apart from a user ID or email address.
+const PseudonymPrefix = "student-"
+
+// MasteryStreak tracks a student's run of consecutive passes on one step of a mastery problem.
+// Once the run reaches Required the step is mastered and keeps full credit.
+type MasteryStreak struct {
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	ProblemID    int64     `json:"problemID" meddler:"problem_id"`
+	Step         int64     `json:"step" meddler:"step"`
+	Streak       int64     `json:"streak" meddler:"streak"`
+	Required     int64     `json:"required" meddler:"required"`
+	Attempts     int64     `json:"attempts" meddler:"attempts"`
+	MasteredAt   time.Time `json:"masteredAt,omitempty" meddler:"mastered_at,localtimez"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// IsMastered returns true if the student has passed enough times in a row.
+func (streak *MasteryStreak) IsMastered() bool {
+	return !streak.MasteredAt.IsZero()
+}
+
+// Score is the credit for the step: full credit once mastered,
+// and partial credit for progress toward mastery until then.
+func (streak *MasteryStreak) Score() float64 {
+	if streak.IsMastered() || streak.Required < 1 {
+		return 1.0
+	}
+	return float64(streak.Streak) / float64(streak.Required)
+}
+
+// Prerequisite locks a problem set in a course until the student has scored
+// at least Threshold (on a scale of 0 to 1) on an earlier problem set.
+type Prerequisite struct {
+	CourseID             int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID         int64     `json:"problemSetID" meddler:"problem_set_id"`
+	RequiredProblemSetID int64     `json:"requiredProblemSetID" meddler:"required_problem_set_id"`
+	Threshold            float64   `json:"threshold" meddler:"threshold"`
+	CreatedAt            time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt            time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// PrerequisiteStatus reports how a student stands on one prerequisite of an assignment.
+type PrerequisiteStatus struct {
+	ProblemSetID int64   `json:"problemSetID" meddler:"problem_set_id"`
+	Unique       string  `json:"unique" meddler:"unique_id"`
+	Note         string  `json:"note" meddler:"note"`
+	Threshold    float64 `json:"threshold" meddler:"threshold"`
+	Score        float64 `json:"score" meddler:"score"`
+}
+
+// IsMet returns true if the student has scored enough on the required problem set.
+func (status *PrerequisiteStatus) IsMet() bool {
+	return status.Score >= status.Threshold
+}
+
+// GradeEntry is one student's grade on a problem set, as listed for instructors.
+// While grading is anonymous, UserID and Email are omitted and Name is a pseudonym.
+type GradeEntry struct {
+	AssignmentID int64                `json:"assignmentID,omitempty" meddler:"assignment_id"`
+	UserID       int64                `json:"userID,omitempty" meddler:"user_id"`
+	Name         string               `json:"name" meddler:"name"`
+	Email        string               `json:"email,omitempty" meddler:"email"`
+	Anonymous    bool                 `json:"anonymous,omitempty" meddler:"-"`
+	RawScores    map[string][]float64 `json:"raw_scores" meddler:"raw_scores,json"`
+	Score        float64              `json:"score" meddler:"score,zeroisnull"`
+	UpdatedAt    time.Time            `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Moderation configures second marking for a problem set in a course.
+// SamplePercent of the students are chosen to have their grades checked by
+// the second marker. Differences larger than Threshold (on a scale of 0 to 1)
+// are flagged and must be reconciled.
+type Moderation struct {
+	CourseID       int64     `json:"courseID" meddler:"course_id"`
+	ProblemSetID   int64     `json:"problemSetID" meddler:"problem_set_id"`
+	SamplePercent  float64   `json:"samplePercent" meddler:"sample_percent"`
+	Threshold      float64   `json:"threshold" meddler:"threshold"`
+	SecondMarkerID int64     `json:"secondMarkerID" meddler:"second_marker_id"`
+	CreatedAt      time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt      time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// ModerationMark tracks the second marking of one student's work.
+// FirstScore is the grade the work had when the second mark was recorded.
+// Student is the name (or pseudonym, when grading is anonymous) shown to instructors.
+type ModerationMark struct {
+	ID              int64     `json:"id" meddler:"id,pk"`
+	AssignmentID    int64     `json:"assignmentID,omitempty" meddler:"assignment_id"`
+	Student         string    `json:"student,omitempty" meddler:"-"`
+	FirstScore      float64   `json:"firstScore" meddler:"first_score"`
+	SecondScore     float64   `json:"secondScore" meddler:"second_score"`
+	SecondNote      string    `json:"secondNote,omitempty" meddler:"second_note,zeroisnull"`
+	SecondMarkedAt  time.Time `json:"secondMarkedAt,omitempty" meddler:"second_marked_at,localtimez"`
+	Flagged         bool      `json:"flagged" meddler:"flagged"`
+	ReconciledScore float64   `json:"reconciledScore" meddler:"reconciled_score"`
+	ReconciledByID  int64     `json:"reconciledByID,omitempty" meddler:"reconciled_by_id,zeroisnull"`
+	ReconciledNote  string    `json:"reconciledNote,omitempty" meddler:"reconciled_note,zeroisnull"`
+	ReconciledAt    time.Time `json:"reconciledAt,omitempty" meddler:"reconciled_at,localtimez"`
+	CreatedAt       time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt       time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// Status describes where a moderation mark is in the workflow.
+func (mark *ModerationMark) Status() string {
+	switch {
+	case !mark.ReconciledAt.IsZero():
+		return "reconciled"
+	case mark.SecondMarkedAt.IsZero():
+		return "awaiting second mark"
+	case mark.Flagged:
+		return "flagged"
+	default:
+		return "agreed"
+	}
+}
+
+// Mark is a score given by a marker, on a scale of 0 to 1.
+type Mark struct {
+	Score float64 `json:"score"`
+	Note  string  `json:"note"`
+}
+
+// Section is a section of a course, as imported from the LMS.
+// Students and TAs are members of sections. Students' membership follows the LMS,
+// while instructors may also attach TAs to sections by hand.
+type Section struct {
+	ID        int64     `json:"id" meddler:"id,pk"`
+	CourseID  int64     `json:"courseID" meddler:"course_id"`
+	CanvasID  int64     `json:"canvasID" meddler:"canvas_id"`
+	Name      string    `json:"name" meddler:"name"`
+	Students  int       `json:"students" meddler:"-"`
+	TAs       []*User   `json:"tas,omitempty" meddler:"-"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// SectionMember records that a user is a student or TA in a section.
+type SectionMember struct {
+	SectionID int64     `json:"sectionID" meddler:"section_id"`
+	UserID    int64     `json:"userID" meddler:"user_id"`
+	Role      string    `json:"role" meddler:"role"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// RosterSync is the result of comparing a course's roster in the LMS
+// with the students who have assignments in the course.
+type RosterSync struct {
+	ID        int64          `json:"id" meddler:"id,pk"`
+	CourseID  int64          `json:"courseID" meddler:"course_id"`
+	Students  int            `json:"students" meddler:"students"`
+	Dropped   []*RosterEntry `json:"dropped" meddler:"dropped,json"`
+	Returned  []*RosterEntry `json:"returned" meddler:"returned,json"`
+	Missing   []*RosterEntry `json:"missing" meddler:"missing,json"`
+	Error     string         `json:"error,omitempty" meddler:"error,zeroisnull"`
+	CreatedAt time.Time      `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// RosterEntry identifies a student in a roster sync report.
+// UserID is zero for students who have never launched a problem set.
+type RosterEntry struct {
+	UserID int64  `json:"userID,omitempty"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+// HasDrift returns true if the LMS roster and the course disagreed.
+func (report *RosterSync) HasDrift() bool {
+	return len(report.Dropped) > 0 || len(report.Returned) > 0 || len(report.Missing) > 0
+}
+
+// CourseTerm is the term information for a course, as set by an instructor.
+type CourseTerm struct {
+	Term            string    `json:"term"`
+	EndsAt          time.Time `json:"endsAt"`
+	Archived        bool      `json:"archived"`
+	ArchiveOverride bool      `json:"archiveOverride"`
+}
+
+// CourseRollForward asks for the problem sets and configuration of an earlier
+// course to be copied into a new term's course.
+// Term and EndsAt are optional and set the term of the new course.
+type CourseRollForward struct {
+	FromCourseID int64     `json:"fromCourseID"`
+	Term         string    `json:"term"`
+	EndsAt       time.Time `json:"endsAt"`
+}
+
+// IsActive returns true if the course has not been archived and its term has not ended.
+func (course *Course) IsActive(now time.Time) bool {
+	if course.Archived {
+		return false
+	}
+	return course.EndsAt.IsZero() || now.Before(course.EndsAt)
+}
+
 // isInstructorRole returns true if the given LTI Roles field indicates this
 // user is an instructor for a specific course.
 func (asst *Assignment) IsInstructorRole() bool {
@@ -102,6 +396,44 @@ func (asst *Assignment) IsInstructorRole() bool {
 	return false
 }
 
+func (asst *Assignment) IsTARole() bool {
+	for _, role := range strings.Split(asst.Roles, ",") {
+		if strings.HasSuffix(role, "TeachingAssistant") {
+			return true
+		}
+	}
+	return false
+}
+
+// OnPath returns true if a problem on the given track of an adaptive problem set
+// is part of this assignment. Until the diagnostic problem places the student,
+// only the problems common to every path are.
+func (asst *Assignment) OnPath(track string) bool {
+	return track == "" || track == asst.Path
+}
+
+// IsLocked returns true if any prerequisite of the assignment has not been met.
+// Prerequisites must be filled in first.
+func (asst *Assignment) IsLocked() bool {
+	for _, status := range asst.Prerequisites {
+		if !status.IsMet() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsComplete returns true if the student has full credit for the assignment.
+func (asst *Assignment) IsComplete() bool {
+	return asst.Score >= 1.0
+}
+
+// IsDropped returns true if the student has dropped the course.
+// Their work is kept, but they can no longer submit new work.
+func (asst *Assignment) IsDropped() bool {
+	return !asst.DroppedAt.IsZero()
+}
+
 func (commit *Commit) ComputeSignature(secret string, problemSignature string) string {
 	v := make(url.Values)
 
@@ -133,7 +465,18 @@ func (commit *Commit) ComputeSignature(secret string, problemSignature string) s
 			}
 		}
 	}
+	if commit.ReportCard != nil && commit.ReportCard.Toolchain != nil {
+		v.Add("toolchain-image", commit.ReportCard.Toolchain.Image)
+		v.Add("toolchain-image-id", commit.ReportCard.Toolchain.ImageID)
+		v.Add("toolchain-version", commit.ReportCard.Toolchain.Version)
+	}
+	for name, answer := range commit.Reflections {
+		v.Add(fmt.Sprintf("reflection-%s", name), answer)
+	}
 	v.Add("score", strconv.FormatFloat(commit.Score, 'g', -1, 64))
+	if !commit.ClientTime.IsZero() {
+		v.Add("client_time", commit.ClientTime.Round(time.Second).UTC().Format(time.RFC3339))
+	}
 	v.Add("created_at", commit.CreatedAt.Round(time.Second).UTC().Format(time.RFC3339))
 	v.Add("updated_at", commit.UpdatedAt.Round(time.Second).UTC().Format(time.RFC3339))
 	v.Add("problem_signature", problemSignature)
@@ -169,6 +512,44 @@ func (commit *Commit) Normalize(now time.Time, whitelist map[string]bool) error
 	return nil
 }
 
+// FileChecksum returns the SHA-256 checksum of a file's contents in hex.
+func FileChecksum(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+// AddChecksums records a checksum for each file in the commit,
+// so the receiver can detect files that were damaged in transit.
+func (commit *Commit) AddChecksums() {
+	commit.Checksums = make(map[string]string)
+	for name, contents := range commit.Files {
+		commit.Checksums[name] = FileChecksum(contents)
+	}
+}
+
+// VerifyChecksums confirms that every file in the commit matches its checksum
+// and that no file is missing. Commits without checksums are accepted as is.
+func (commit *Commit) VerifyChecksums() error {
+	if commit.Checksums == nil {
+		return nil
+	}
+	for name, contents := range commit.Files {
+		sum, exists := commit.Checksums[name]
+		if !exists {
+			return fmt.Errorf("file %s has no checksum", name)
+		}
+		if FileChecksum(contents) != sum {
+			return fmt.Errorf("file %s does not match its checksum; it may have been damaged in transit", name)
+		}
+	}
+	for name := range commit.Checksums {
+		if _, exists := commit.Files[name]; !exists {
+			return fmt.Errorf("file %s is missing; it may have been lost in transit", name)
+		}
+	}
+	return nil
+}
+
 // filter out files in subdirectories/not on whitelist, and clean up line endings
 func (commit *Commit) FilterIncoming(whitelist map[string]bool) {
 	clean := make(map[string]string)
@@ -177,14 +558,14 @@ func (commit *Commit) FilterIncoming(whitelist map[string]bool) {
 		if whitelist == nil {
 			// only keep files not in a subdirectory
 			if len(filepath.SplitList(name)) == 1 {
-				clean[name] = fixLineEndings(contents)
+				clean[name] = FixLineEndings(contents)
 			} else {
 				log.Printf("filtered out %s, which is in a subdirectory", name)
 			}
 		} else {
 			// only keep files on the whitelist
 			if whitelist[name] {
-				clean[name] = fixLineEndings(contents)
+				clean[name] = FixLineEndings(contents)
 			} else {
 				log.Printf("filtered out %s, which is not on the problem step whitelist", name)
 			}
@@ -0,0 +1,234 @@
+package types
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProblemVariable is an environment variable set for every grading run of a problem.
+// Secret values, such as an API key for a service the problem is allowed to use,
+// are stored encrypted and are never returned by the API or included in downloads.
+// They are not put in the environment. Only actions marked secrets get them, in a
+// file that the grader reads and removes before any student code runs; see
+// sdk.TakeSecrets. Literal copies of a secret are replaced in transcripts and
+// report cards, but that cannot hide one that student code gets hold of and
+// prints in some other form, so a grader must never let student code see one.
+type ProblemVariable struct {
+	ProblemID int64     `json:"problemID" meddler:"problem_id"`
+	Name      string    `json:"name" meddler:"name"`
+	Value     string    `json:"value,omitempty" meddler:"value"`
+	Secret    bool      `json:"secret" meddler:"secret"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+var variableNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Normalize checks the variable for sane values.
+// Names used by CodeGrinder itself are reserved.
+func (variable *ProblemVariable) Normalize() error {
+	variable.Name = strings.TrimSpace(variable.Name)
+	if !variableNameRE.MatchString(variable.Name) {
+		return fmt.Errorf("%q is not a valid environment variable name", variable.Name)
+	}
+	if strings.HasPrefix(strings.ToUpper(variable.Name), "CODEGRINDER_") {
+		return fmt.Errorf("names starting with CODEGRINDER_ are reserved")
+	}
+	if variable.Secret && variable.Value == "" {
+		return fmt.Errorf("a secret must have a value")
+	}
+	return nil
+}
+
+// Environment is the set of variables given to a grading run.
+// Secrets lists the names whose values must be hidden from the student.
+type Environment struct {
+	Vars    map[string]string `json:"vars"`
+	Secrets []string          `json:"secrets,omitempty"`
+}
+
+// isSecret reports whether the named variable is a secret.
+func (env *Environment) isSecret(name string) bool {
+	for _, secret := range env.Secrets {
+		if secret == name {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the variables that are not secrets in NAME=value form, sorted by name.
+func (env *Environment) List() []string {
+	var list []string
+	for name, value := range env.Vars {
+		if !env.isSecret(name) {
+			list = append(list, name+"="+value)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// SecretValues returns the secrets by name, or nil if there are none.
+func (env *Environment) SecretValues() map[string]string {
+	var values map[string]string
+	for _, name := range env.Secrets {
+		if value, exists := env.Vars[name]; exists {
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// Redactor returns a replacer that hides the values of secrets,
+// or nil if there are none.
+func (env *Environment) Redactor() *strings.Replacer {
+	var pairs []string
+	for _, name := range env.Secrets {
+		if value := env.Vars[name]; value != "" {
+			pairs = append(pairs, value, "["+name+" redacted]")
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// SealEnvironment encrypts an environment so it can travel through the client
+// to the daycare without being readable. It is bound to the problem signature
+// so it cannot be attached to a different problem.
+func SealEnvironment(secret string, env *Environment, problemSignature string) (string, error) {
+	plaintext, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return sealWithSecret(secret, "environment", plaintext, problemSignature)
+}
+
+// OpenEnvironment decrypts an environment sealed by SealEnvironment.
+func OpenEnvironment(secret string, sealed string, problemSignature string) (*Environment, error) {
+	plaintext, err := openWithSecret(secret, "environment", sealed, problemSignature)
+	if err != nil {
+		return nil, err
+	}
+	env := new(Environment)
+	if err := json.Unmarshal(plaintext, env); err != nil {
+		return nil, fmt.Errorf("error decoding environment: %v", err)
+	}
+	return env, nil
+}
+
+// SealReference encrypts the reference solution to a problem step so it can travel
+// through the client to the daycare for actions that compare against it.
+// It is bound to the problem signature and the step.
+func SealReference(secret string, files map[string]string, problemSignature string, step int64) (string, error) {
+	plaintext, err := json.Marshal(files)
+	if err != nil {
+		return "", err
+	}
+	return sealWithSecret(secret, "reference", plaintext, fmt.Sprintf("%s/%d", problemSignature, step))
+}
+
+// OpenReference decrypts a reference solution sealed by SealReference.
+func OpenReference(secret string, sealed string, problemSignature string, step int64) (map[string]string, error) {
+	plaintext, err := openWithSecret(secret, "reference", sealed, fmt.Sprintf("%s/%d", problemSignature, step))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &files); err != nil {
+		return nil, fmt.Errorf("error decoding reference solution: %v", err)
+	}
+	return files, nil
+}
+
+// SealVariableValue encrypts the value of a secret for storage.
+func SealVariableValue(secret string, problemID int64, name, value string) (string, error) {
+	return sealWithSecret(secret, "variable", []byte(value), fmt.Sprintf("%d/%s", problemID, name))
+}
+
+// OpenVariableValue decrypts the stored value of a secret.
+func OpenVariableValue(secret string, problemID int64, name, sealed string) (string, error) {
+	plaintext, err := openWithSecret(secret, "variable", sealed, fmt.Sprintf("%d/%s", problemID, name))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// sealWithSecret encrypts with a key derived from a shared secret and a purpose,
+// returning the nonce and ciphertext together in base64.
+func sealWithSecret(secret, purpose string, plaintext []byte, context string) (string, error) {
+	key := sha256.Sum256([]byte(purpose + ":" + secret))
+	gcm, err := newSealCipher(key[:])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(context))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openWithSecret(secret, purpose string, sealed string, context string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding sealed %s: %v", purpose, err)
+	}
+	key := sha256.Sum256([]byte(purpose + ":" + secret))
+	gcm, err := newSealCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed %s is too short", purpose)
+	}
+	plaintext, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], []byte(context))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s: %v", purpose, err)
+	}
+	return plaintext, nil
+}
+
+// Redact hides secrets in the report card.
+func (elt *ReportCard) Redact(r *strings.Replacer) {
+	elt.Note = r.Replace(elt.Note)
+	for _, result := range elt.Results {
+		result.Name = r.Replace(result.Name)
+		result.Details = r.Replace(result.Details)
+		result.Context = r.Replace(result.Context)
+	}
+	for _, artifact := range elt.Artifacts {
+		if strings.HasPrefix(artifact.MediaType, "text/") || strings.HasSuffix(artifact.MediaType, "+xml") || artifact.MediaType == "application/json" {
+			artifact.Contents = []byte(r.Replace(string(artifact.Contents)))
+		}
+	}
+}
+
+// Redact hides secrets in the event.
+func (e *EventMessage) Redact(r *strings.Replacer) {
+	for i, arg := range e.ExecCommand {
+		e.ExecCommand[i] = r.Replace(arg)
+	}
+	e.StreamData = r.Replace(e.StreamData)
+	e.Error = r.Replace(e.Error)
+	if e.ReportCard != nil {
+		e.ReportCard.Redact(r)
+	}
+	for name, contents := range e.Files {
+		e.Files[name] = r.Replace(contents)
+	}
+}